@@ -110,6 +110,7 @@ type configuration struct {
 	encryptionKey        string              // Key for encrypting data (unlocks "real" key in brain)
 	historyProvider      string              // Name of the history provider to use
 	queueProviders       []string            // Queue providers to start after full robot initialization
+	modulePaths          []string            // resolved ModulePaths entries for Lua/JavaScript require()
 	workSpace            string              // Read/Write directory where the robot does work
 	readyMessage         string              // optional channel message sent after startup readiness
 	readyChannel         string              // channel for readyMessage; defaults to defaultJobChannel
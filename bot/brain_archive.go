@@ -0,0 +1,302 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const brainArchiveFormat = "gopherbot-brain-archive-v1"
+
+// brainArchiveKeyCheck is encrypted into every archive so an import can tell
+// whether it holds the right data key before writing anything.
+var brainArchiveKeyCheck = []byte(brainArchiveFormat)
+
+type brainArchive struct {
+	Format       string               `json:"format"`
+	RecordFormat string               `json:"record_format"`
+	CreatedAt    time.Time            `json:"created_at"`
+	KeyCheck     []byte               `json:"key_check"`
+	Records      []brainArchiveRecord `json:"records"`
+}
+
+// brainArchiveRecord is a v3 brain record; Payload stays encrypted with the
+// archive's data key and is empty for tombstones.
+type brainArchiveRecord struct {
+	Key       string    `json:"key"`
+	Payload   []byte    `json:"payload,omitempty"`
+	Version   uint64    `json:"version"`
+	Checksum  string    `json:"checksum"`
+	Deleted   bool      `json:"deleted"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type brainImportOptions struct {
+	force     bool
+	reencrypt string
+}
+
+func cliBrainExport(path, reencryptKeyFile string) error {
+	initCLIConfigOnly()
+	key, err := currentBrainDataKey()
+	if err != nil {
+		return err
+	}
+	cache, err := openExistingBrainCacheComplete(currentCfg.brainCache)
+	if err != nil {
+		return err
+	}
+	archive, err := collectBrainArchive(cache, key)
+	if err != nil {
+		return err
+	}
+	if reencryptKeyFile != "" {
+		newKey, err := loadWrappedDataKey(reencryptKeyFile)
+		if err != nil {
+			return err
+		}
+		if err := reencryptBrainArchive(&archive, key, newKey); err != nil {
+			return err
+		}
+	}
+	if err := writeBrainArchive(path, archive); err != nil {
+		return err
+	}
+	deleted := 0
+	for _, record := range archive.Records {
+		if record.Deleted {
+			deleted++
+		}
+	}
+	fmt.Printf("Exported %d memories (%d tombstones) to %s\n", len(archive.Records), deleted, path)
+	if reencryptKeyFile != "" {
+		fmt.Printf("Archive is encrypted with the data key from %s\n", reencryptKeyFile)
+	}
+	return nil
+}
+
+func cliBrainImport(path string, opts brainImportOptions) error {
+	initCLIConfigOnly()
+	key, err := currentBrainDataKey()
+	if err != nil {
+		return err
+	}
+	archive, err := readBrainArchive(path)
+	if err != nil {
+		return err
+	}
+	if opts.reencrypt != "" {
+		oldKey, err := loadWrappedDataKey(opts.reencrypt)
+		if err != nil {
+			return err
+		}
+		if err := reencryptBrainArchive(&archive, oldKey, key); err != nil {
+			return err
+		}
+	} else if err := verifyBrainArchiveKey(archive, key); err != nil {
+		return fmt.Errorf("%w; rerun with -reencrypt <key file> naming the key the archive was written with", err)
+	}
+	cfg := defaultBrainCacheConfig(currentCfg.brainCache)
+	if _, err := os.Stat(filepath.Join(cfg.Directory, "control.json")); err == nil && !opts.force {
+		return fmt.Errorf("local brain cache at %s already exists; rerun with -force to replace it", cfg.Directory)
+	}
+	cache, err := openBrainCacheForImport(cfg, "archive", opts.force)
+	if err != nil {
+		return err
+	}
+	if err := importBrainArchive(cache, archive); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d memories from %s into local brain cache\n", len(archive.Records), path)
+	provider := currentCfg.brainProvider
+	if provider != "" && provider != "file" && provider != "mem" {
+		fmt.Printf("Run gopherbot restore-brain -force to write the imported memories to %s.\n", provider)
+	}
+	return nil
+}
+
+func currentBrainDataKey() ([]byte, error) {
+	cryptKey.RLock()
+	defer cryptKey.RUnlock()
+	if !cryptKey.initialized {
+		return nil, errors.New("brain encryption is not initialized")
+	}
+	return append([]byte(nil), cryptKey.key...), nil
+}
+
+// loadWrappedDataKey reads a binary-encrypted-key style file, as written by
+// gopherbot genkey, and unwraps it with GOPHER_ENCRYPTION_KEY.
+func loadWrappedDataKey(path string) ([]byte, error) {
+	wrappingKey, ok := lookupEnv(keyEnv)
+	if !ok || len(wrappingKey) < 32 {
+		return nil, fmt.Errorf("%s must be set and at least 32 bytes long", keyEnv)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("base64 decoding %s: %w", path, err)
+	}
+	key, err := decrypt(wrapped, []byte(wrappingKey)[:32])
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s with %s: %w", path, keyEnv, err)
+	}
+	return key, nil
+}
+
+// collectBrainArchive gathers every record in the cache, tombstones
+// included; the instance lock belongs to the running robot and is left out.
+func collectBrainArchive(cache *cachedBrain, key []byte) (brainArchive, error) {
+	keyCheck, err := encrypt(brainArchiveKeyCheck, key)
+	if err != nil {
+		return brainArchive{}, err
+	}
+	archive := brainArchive{
+		Format:       brainArchiveFormat,
+		RecordFormat: brainCacheFormat,
+		CreatedAt:    time.Now().UTC(),
+		KeyCheck:     keyCheck,
+		Records:      []brainArchiveRecord{},
+	}
+	entries, err := os.ReadDir(cache.metaDir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return brainArchive{}, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		meta, err := cache.readMetaFile(filepath.Join(cache.metaDir(), entry.Name()))
+		if err != nil {
+			return brainArchive{}, err
+		}
+		if meta.Key == brainLockKey {
+			continue
+		}
+		record := brainArchiveRecord{
+			Key:       meta.Key,
+			Version:   meta.Version,
+			Checksum:  meta.Checksum,
+			Deleted:   meta.Deleted,
+			UpdatedAt: meta.UpdatedAt,
+		}
+		if !meta.Deleted {
+			payload, err := os.ReadFile(cache.payloadPath(meta.Key))
+			if err != nil {
+				return brainArchive{}, fmt.Errorf("reading memory %s: %w", meta.Key, err)
+			}
+			if checksumBytes(payload) != meta.Checksum {
+				return brainArchive{}, fmt.Errorf("local memory %s checksum mismatch", meta.Key)
+			}
+			record.Payload = payload
+		}
+		archive.Records = append(archive.Records, record)
+	}
+	sort.Slice(archive.Records, func(i, j int) bool { return archive.Records[i].Key < archive.Records[j].Key })
+	return archive, nil
+}
+
+func verifyBrainArchiveKey(archive brainArchive, key []byte) error {
+	check, err := decrypt(archive.KeyCheck, key)
+	if err != nil || !bytes.Equal(check, brainArchiveKeyCheck) {
+		return errors.New("brain archive was written with a different encryption key")
+	}
+	return nil
+}
+
+// reencryptBrainArchive moves every payload from oldKey to newKey. The new
+// ciphertext gets a new checksum, so versions are raised above the highest
+// archived version; a provider still holding the old records then takes
+// the re-encrypted ones as updates.
+func reencryptBrainArchive(archive *brainArchive, oldKey, newKey []byte) error {
+	if err := verifyBrainArchiveKey(*archive, oldKey); err != nil {
+		return err
+	}
+	var maxVersion uint64
+	for _, record := range archive.Records {
+		if record.Version > maxVersion {
+			maxVersion = record.Version
+		}
+	}
+	for i := range archive.Records {
+		record := &archive.Records[i]
+		record.Version += maxVersion
+		if record.Deleted {
+			continue
+		}
+		// The legacy v1 key record is wrapped with the user-supplied key,
+		// not the data key, and is copied unchanged.
+		if record.Key == botEncryptionKey {
+			continue
+		}
+		payload, err := reencryptPayload(record.Payload, oldKey, newKey)
+		if err != nil {
+			return fmt.Errorf("memory %s: %w", record.Key, err)
+		}
+		record.Payload = payload
+		record.Checksum = checksumBytes(payload)
+	}
+	keyCheck, err := encrypt(brainArchiveKeyCheck, newKey)
+	if err != nil {
+		return err
+	}
+	archive.KeyCheck = keyCheck
+	return nil
+}
+
+func importBrainArchive(cache *cachedBrain, archive brainArchive) error {
+	for _, record := range archive.Records {
+		if !keyRe.MatchString(record.Key) {
+			return fmt.Errorf("brain archive has invalid memory key %q", record.Key)
+		}
+		if err := cache.importV3Record(robot.RemoteBrainRecord{
+			Key:       record.Key,
+			Payload:   record.Payload,
+			Format:    brainCacheFormat,
+			Version:   record.Version,
+			Checksum:  record.Checksum,
+			Deleted:   record.Deleted,
+			UpdatedAt: record.UpdatedAt,
+		}); err != nil {
+			return err
+		}
+	}
+	return cache.finalizeImport("archive")
+}
+
+func writeBrainArchive(path string, archive brainArchive) error {
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAtomicFile(path, data, 0600)
+}
+
+func readBrainArchive(path string) (brainArchive, error) {
+	var archive brainArchive
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return archive, err
+	}
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return archive, fmt.Errorf("decoding brain archive %s: %w", path, err)
+	}
+	if archive.Format != brainArchiveFormat {
+		return archive, fmt.Errorf("unsupported brain archive format %q", archive.Format)
+	}
+	if archive.RecordFormat != brainCacheFormat {
+		return archive, fmt.Errorf("unsupported brain archive record format %q", archive.RecordFormat)
+	}
+	return archive, nil
+}
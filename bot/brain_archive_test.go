@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newArchiveTestCache(t *testing.T, key []byte) *cachedBrain {
+	t.Helper()
+	cache, err := newLocalCachedBrain(BrainCacheConfig{Directory: filepath.Join(t.TempDir(), "cache")})
+	if err != nil {
+		t.Fatalf("newLocalCachedBrain: %v", err)
+	}
+	for name, value := range map[string]string{"alpha": "one", "beta": "two", brainLockKey: "lock"} {
+		encrypted, err := encrypt([]byte(value), key)
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		if err := cache.Store(name, &encrypted); err != nil {
+			t.Fatalf("Store(%s): %v", name, err)
+		}
+	}
+	if err := cache.Delete("beta"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	return cache
+}
+
+func TestBrainArchiveRoundTripKeepsVersionsAndTombstones(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	source := newArchiveTestCache(t, key)
+	defer source.Shutdown()
+
+	archive, err := collectBrainArchive(source, key)
+	if err != nil {
+		t.Fatalf("collectBrainArchive: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "brain.json")
+	if err := writeBrainArchive(path, archive); err != nil {
+		t.Fatalf("writeBrainArchive: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("archive file mode = %v, err %v; want 0600", info.Mode().Perm(), err)
+	}
+	read, err := readBrainArchive(path)
+	if err != nil {
+		t.Fatalf("readBrainArchive: %v", err)
+	}
+	if err := verifyBrainArchiveKey(read, key); err != nil {
+		t.Fatalf("verifyBrainArchiveKey: %v", err)
+	}
+	if err := verifyBrainArchiveKey(read, bytes.Repeat([]byte("x"), 32)); err == nil {
+		t.Fatal("verifyBrainArchiveKey accepted the wrong key")
+	}
+	if len(read.Records) != 2 || read.Records[0].Key != "alpha" || read.Records[1].Key != "beta" || !read.Records[1].Deleted {
+		t.Fatalf("archive records = %+v, want alpha and beta tombstone without the instance lock", read.Records)
+	}
+
+	target, err := openBrainCacheForImport(BrainCacheConfig{Directory: filepath.Join(t.TempDir(), "cache")}, "archive", false)
+	if err != nil {
+		t.Fatalf("openBrainCacheForImport: %v", err)
+	}
+	if err := importBrainArchive(target, read); err != nil {
+		t.Fatalf("importBrainArchive: %v", err)
+	}
+	for _, record := range read.Records {
+		meta, exists, err := target.readMeta(record.Key)
+		if err != nil || !exists {
+			t.Fatalf("readMeta(%s) exists=%t err=%v", record.Key, exists, err)
+		}
+		if meta.Version != record.Version || meta.Checksum != record.Checksum || meta.Deleted != record.Deleted {
+			t.Fatalf("imported meta %+v does not match archived record %+v", meta, record)
+		}
+	}
+	payload, exists, err := target.Retrieve("alpha")
+	if err != nil || !exists {
+		t.Fatalf("Retrieve(alpha) exists=%t err=%v", exists, err)
+	}
+	if plain, err := decrypt(*payload, key); err != nil || string(plain) != "one" {
+		t.Fatalf("decrypted alpha = %q, err %v", plain, err)
+	}
+	if !target.control.Complete || target.control.NextVersion <= read.Records[1].Version {
+		t.Fatalf("control after import = %+v", target.control)
+	}
+}
+
+func TestBrainArchiveReencryptRaisesVersions(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	source := newArchiveTestCache(t, oldKey)
+	defer source.Shutdown()
+	archive, err := collectBrainArchive(source, oldKey)
+	if err != nil {
+		t.Fatalf("collectBrainArchive: %v", err)
+	}
+	before := append([]brainArchiveRecord(nil), archive.Records...)
+	var maxVersion uint64
+	for _, record := range before {
+		maxVersion = max(maxVersion, record.Version)
+	}
+
+	if err := reencryptBrainArchive(&archive, newKey, oldKey); err == nil {
+		t.Fatal("reencryptBrainArchive accepted the wrong source key")
+	}
+	if err := reencryptBrainArchive(&archive, oldKey, newKey); err != nil {
+		t.Fatalf("reencryptBrainArchive: %v", err)
+	}
+	if err := verifyBrainArchiveKey(archive, newKey); err != nil {
+		t.Fatalf("verifyBrainArchiveKey(new key): %v", err)
+	}
+	for i, record := range archive.Records {
+		if record.Version <= maxVersion {
+			t.Fatalf("record %s version %d was not raised", record.Key, record.Version)
+		}
+		if record.Deleted {
+			continue
+		}
+		if record.Checksum == before[i].Checksum || record.Checksum != checksumBytes(record.Payload) {
+			t.Fatalf("record %s checksum not updated for new ciphertext", record.Key)
+		}
+		if plain, err := decrypt(record.Payload, newKey); err != nil || string(plain) != "one" {
+			t.Fatalf("re-encrypted %s = %q, err %v", record.Key, plain, err)
+		}
+	}
+}
+
+func TestLoadWrappedDataKeyUnwrapsGenkeyFile(t *testing.T) {
+	wrapping := strings.Repeat("w", 32)
+	t.Setenv(keyEnv, wrapping)
+	dataKey := bytes.Repeat([]byte("d"), 32)
+	wrapped, err := encrypt(dataKey, []byte(wrapping))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), encryptedKeyFile)
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(wrapped)+"\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	got, err := loadWrappedDataKey(path)
+	if err != nil {
+		t.Fatalf("loadWrappedDataKey: %v", err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Fatalf("loadWrappedDataKey = %x, want %x", got, dataKey)
+	}
+}
@@ -1975,6 +1975,58 @@ func admin(m robot.Robot, command string, args ...string) (retval robot.TaskRetV
 			return
 		}
 		r.Say("Restarted protocol '%s'", protocol)
+	case "queuelist":
+		statuses := listQueueProviderStatus()
+		if len(statuses) == 0 {
+			r.Say("No queue providers are configured")
+			return
+		}
+		lines := make([]string, 0, len(statuses)+1)
+		lines = append(lines, "Queue provider status:")
+		for _, status := range statuses {
+			line := fmt.Sprintf("%s: %s; handled %d, retries %d, duplicates %d", status.provider, status.state, status.handled, status.retries, status.dedupeHits)
+			if !status.lastMessage.IsZero() {
+				line += ", last message " + status.lastMessage.Format(time.RFC3339)
+			}
+			if status.err != "" {
+				line += " (last error: " + status.err + ")"
+			}
+			lines = append(lines, line)
+		}
+		r.Say(strings.Join(lines, "\n"))
+	case "queuestart":
+		if len(args) == 0 || len(strings.TrimSpace(args[0])) == 0 {
+			r.Say("Usage: queue-start <provider>")
+			return
+		}
+		provider := normalizeProviderName(args[0])
+		if err := startConfiguredQueueProvider(provider); err != nil {
+			r.Say("Unable to start queue provider '%s': %v", provider, err)
+			return
+		}
+		r.Say("Started queue provider '%s'", provider)
+	case "queuestop":
+		if len(args) == 0 || len(strings.TrimSpace(args[0])) == 0 {
+			r.Say("Usage: queue-stop <provider>")
+			return
+		}
+		provider := normalizeProviderName(args[0])
+		if err := stopConfiguredQueueProvider(provider); err != nil {
+			r.Say("Unable to stop queue provider '%s': %v", provider, err)
+			return
+		}
+		r.Say("Stopped queue provider '%s'", provider)
+	case "queuerestart":
+		if len(args) == 0 || len(strings.TrimSpace(args[0])) == 0 {
+			r.Say("Usage: queue-restart <provider>")
+			return
+		}
+		provider := normalizeProviderName(args[0])
+		if err := restartConfiguredQueueProvider(provider); err != nil {
+			r.Say("Unable to restart queue provider '%s': %v", provider, err)
+			return
+		}
+		r.Say("Restarted queue provider '%s'", provider)
 	case "gitinfo":
 		snapshot := getRuntimeGitSnapshot()
 		if refreshed, err := refreshRuntimeGitStateFromConfig(false); err == nil {
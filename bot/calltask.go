@@ -90,15 +90,6 @@ func emptyBot() map[string]string {
 	return bot
 }
 
-// Paths where libraries can be loaded
-func libPaths() []string {
-	libPaths := []string{
-		fmt.Sprintf("%s/lib", installPath),
-		fmt.Sprintf("%s/custom/lib", homePath),
-	}
-	return libPaths
-}
-
 func buildConfigureEnv() []string {
 	gemHome := filepath.Join(homePath, ".bot-gems")
 	pythonUserBase := filepath.Join(homePath, ".bot-python")
@@ -206,7 +197,7 @@ func getDefCfgThread(cchan chan<- getCfgReturn, ti interface{}) {
 			}
 		} else if isExternalLuaTask {
 			Log(robot.Info, "getting default configuration for external Lua plugin '"+task.name+"'")
-			if defConfig, err := runLuaGetConfigViaRPC(taskPath, task.name, configureWorkDir, libPaths(), emptyBot(), task.Privileged); err != nil {
+			if defConfig, err := runLuaGetConfigViaRPC(taskPath, task.name, configureWorkDir, libPaths("lua"), emptyBot(), task.Privileged); err != nil {
 				Log(robot.Warn, "unable to retrieve plugin default configuration for '%s': %s", task.name, err.Error())
 				// This error shouldn't disable an external Lua plugin
 				cchan <- getCfgReturn{&cfg, nil}
@@ -218,7 +209,7 @@ func getDefCfgThread(cchan chan<- getCfgReturn, ti interface{}) {
 		} else if isExternalJSTask {
 			// Assuming you have a similar function for JavaScript
 			Log(robot.Info, "getting default configuration for external JavaScript plugin '"+task.name+"'")
-			if defConfig, err := runJSGetConfigViaRPC(taskPath, task.name, configureWorkDir, libPaths(), emptyBot(), task.Privileged); err != nil {
+			if defConfig, err := runJSGetConfigViaRPC(taskPath, task.name, configureWorkDir, libPaths("js"), emptyBot(), task.Privileged); err != nil {
 				Log(robot.Warn, "unable to retrieve plugin default configuration for '%s': %s", task.name, err.Error())
 				// This error shouldn't disable an external JS plugin
 				cchan <- getCfgReturn{&cfg, nil}
@@ -613,7 +604,7 @@ func (w *worker) callTaskThread(rchan chan<- taskReturn, opts taskCallOptions, t
 			// Prepend the command to args, so Lua sees args[1] == <command>
 			allArgs := append([]string{command}, args...)
 
-			ret, err := runLuaExtensionViaRPC(taskPath, task.name, taskDir, libPaths(), scriptBot(envhash), privileged, w, r, allArgs)
+			ret, err := runLuaExtensionViaRPC(taskPath, task.name, taskDir, libPaths("lua"), scriptBot(envhash), privileged, w, r, allArgs)
 			if err != nil {
 				emit(ExternalTaskBadInterpreter)
 				rchan <- taskReturn{logTaskExecutionError(w, fmt.Sprintf("Running Lua plugin %s", task.name), err), robot.MechanismFail}
@@ -626,7 +617,7 @@ func (w *worker) callTaskThread(rchan chan<- taskReturn, opts taskCallOptions, t
 			var ret robot.TaskRetVal
 			// For jobs/tasks, pass args directly; no "command" prepended.
 			if isJob {
-				ret, err = runLuaExtensionViaRPC(taskPath, task.name, taskDir, libPaths(), scriptBot(envhash), privileged, w, r, args)
+				ret, err = runLuaExtensionViaRPC(taskPath, task.name, taskDir, libPaths("lua"), scriptBot(envhash), privileged, w, r, args)
 				if err != nil {
 					emit(ExternalTaskBadInterpreter)
 					rchan <- taskReturn{logTaskExecutionError(w, fmt.Sprintf("Running Lua job %s", task.name), err), robot.MechanismFail}
@@ -634,7 +625,7 @@ func (w *worker) callTaskThread(rchan chan<- taskReturn, opts taskCallOptions, t
 				}
 				w.Log(robot.Debug, "External Lua job '%s' executed with args: %q", task.name, args)
 			} else {
-				ret, err = runLuaExtensionViaRPC(taskPath, task.name, taskDir, libPaths(), scriptBot(envhash), privileged, w, r, args)
+				ret, err = runLuaExtensionViaRPC(taskPath, task.name, taskDir, libPaths("lua"), scriptBot(envhash), privileged, w, r, args)
 				if err != nil {
 					emit(ExternalTaskBadInterpreter)
 					rchan <- taskReturn{logTaskExecutionError(w, fmt.Sprintf("Running Lua task %s", task.name), err), robot.MechanismFail}
@@ -657,7 +648,7 @@ func (w *worker) callTaskThread(rchan chan<- taskReturn, opts taskCallOptions, t
 			// Prepend the command to args, so JavaScript sees args[1] == <command>
 			allArgs := append([]string{command}, args...)
 
-			ret, err := runJSExtensionViaRPC(taskPath, task.name, taskDir, libPaths(), scriptBot(envhash), privileged, w, r, allArgs)
+			ret, err := runJSExtensionViaRPC(taskPath, task.name, taskDir, libPaths("js"), scriptBot(envhash), privileged, w, r, allArgs)
 			if err != nil {
 				emit(ExternalTaskBadInterpreter)
 				rchan <- taskReturn{logTaskExecutionError(w, fmt.Sprintf("Running JavaScript plugin %s", task.name), err), robot.MechanismFail}
@@ -670,7 +661,7 @@ func (w *worker) callTaskThread(rchan chan<- taskReturn, opts taskCallOptions, t
 			var ret robot.TaskRetVal
 			// For jobs/tasks, pass args directly; no "command" prepended.
 			if isJob {
-				ret, err = runJSExtensionViaRPC(taskPath, task.name, taskDir, libPaths(), scriptBot(envhash), privileged, w, r, args)
+				ret, err = runJSExtensionViaRPC(taskPath, task.name, taskDir, libPaths("js"), scriptBot(envhash), privileged, w, r, args)
 				if err != nil {
 					emit(ExternalTaskBadInterpreter)
 					rchan <- taskReturn{logTaskExecutionError(w, fmt.Sprintf("Running JavaScript job %s", task.name), err), robot.MechanismFail}
@@ -678,7 +669,7 @@ func (w *worker) callTaskThread(rchan chan<- taskReturn, opts taskCallOptions, t
 				}
 				w.Log(robot.Debug, "External JavaScript job '%s' executed with args: %q", task.name, args)
 			} else {
-				ret, err = runJSExtensionViaRPC(taskPath, task.name, taskDir, libPaths(), scriptBot(envhash), privileged, w, r, args)
+				ret, err = runJSExtensionViaRPC(taskPath, task.name, taskDir, libPaths("js"), scriptBot(envhash), privileged, w, r, args)
 				if err != nil {
 					emit(ExternalTaskBadInterpreter)
 					rchan <- taskReturn{logTaskExecutionError(w, fmt.Sprintf("Running JavaScript task %s", task.name), err), robot.MechanismFail}
@@ -330,6 +330,41 @@ func cliCommands() []cliCommandSpec {
 			},
 			RunsBeforeInit: true,
 		},
+		{
+			Name:         "brain-export",
+			SummaryUsage: "brain-export [-reencrypt <key file>] <file>",
+			Summary:      "export the local brain cache to an encrypted archive",
+			HelpLines: []string{
+				"Usage: gopherbot brain-export [-reencrypt <key file>] <file>",
+				"",
+				"Writes every v3 record in the local brain cache, with versions, checksums",
+				"and tombstones, to a single archive. Memories stay encrypted with the",
+				"robot's data key. For a cloud brain, run pull-brain first.",
+				"",
+				"Options:",
+				"  -reencrypt <key file>  re-encrypt the archive with the data key in <key file>,",
+				"                         a binary-encrypted-key file as written by genkey",
+			},
+			RunsBeforeInit: true,
+		},
+		{
+			Name:         "brain-import",
+			SummaryUsage: "brain-import [options] <file>",
+			Summary:      "import a brain archive into the local brain cache",
+			HelpLines: []string{
+				"Usage: gopherbot brain-import [options] <file>",
+				"",
+				"Loads an archive written by brain-export into the local v3 brain cache.",
+				"For a cloud brain, follow with restore-brain -force to write it to the",
+				"configured provider.",
+				"",
+				"Options:",
+				"  -force                 replace existing local cache",
+				"  -reencrypt <key file>  the archive was written with the data key in",
+				"                         <key file>; re-encrypt it with the robot's key",
+			},
+			RunsBeforeInit: true,
+		},
 		{
 			Name:         "pull-brain",
 			SummaryUsage: "pull-brain [options]",
@@ -367,6 +402,27 @@ func cliCommands() []cliCommandSpec {
 			},
 			RunsBeforeInit: true,
 		},
+		{
+			Name:         "rotate-key",
+			SummaryUsage: "rotate-key [options]",
+			Summary:      "rotate the robot's encryption key",
+			HelpLines: []string{
+				"Usage: gopherbot rotate-key [options]",
+				"",
+				"Generates a new data key for the active environment and re-encrypts every",
+				"brain memory and conf/variables Secrets entry with it. Every record is",
+				"decrypted and its checksum verified before anything is switched. Stop the",
+				"robot first; cloud brains get the new records through the normal outbox.",
+				"Replaced key files are kept as <file>.rotated-<timestamp>.",
+				"",
+				"Options:",
+				"  -dry-run             verify and report planned work without writing",
+				"  -new-key-env <name>  environment variable holding a new " + keyEnv + ";",
+				"                       re-wraps every binary-encrypted-key* file with it",
+				"  -rewrap-only         with -new-key-env, keep the data key and only re-wrap",
+			},
+			RunsBeforeInit: true,
+		},
 		{
 			Name:         "run",
 			SummaryUsage: "run",
@@ -613,6 +669,21 @@ func processCLI(command string, args []string) int {
 	restoreBrainFlags.BoolVar(&restoreBrainOpts.v2, "v2", false, "write v2-compatible cloud data instead of v3")
 	restoreBrainFlags.IntVar(&restoreBrainOpts.budget, "budget", 0, "maximum cloud writes")
 
+	brainExportFlags := newCLIFlagSet("brain-export")
+	var brainExportReencrypt string
+	brainExportFlags.StringVar(&brainExportReencrypt, "reencrypt", "", "re-encrypt with the data key in this key file")
+
+	brainImportFlags := newCLIFlagSet("brain-import")
+	var brainImportOpts brainImportOptions
+	brainImportFlags.BoolVar(&brainImportOpts.force, "force", false, "replace existing local cache")
+	brainImportFlags.StringVar(&brainImportOpts.reencrypt, "reencrypt", "", "archive data key file to re-encrypt from")
+
+	rotateKeyFlags := newCLIFlagSet("rotate-key")
+	var rotateKeyOpts keyRotationOptions
+	rotateKeyFlags.BoolVar(&rotateKeyOpts.dryRun, "dry-run", false, "report planned work without writing")
+	rotateKeyFlags.StringVar(&rotateKeyOpts.newKeyEnv, "new-key-env", "", "environment variable holding the new wrapping key")
+	rotateKeyFlags.BoolVar(&rotateKeyOpts.rewrapOnly, "rewrap-only", false, "only re-wrap key files")
+
 	switch command {
 	case "help":
 		switch len(args) {
@@ -948,6 +1019,46 @@ func processCLI(command string, args []string) int {
 		reportLocalCloudOutboxStatus()
 		shutdownCLIBrainProvider(false)
 		fmt.Println("Brain flushed")
+	case "brain-export":
+		if err := brainExportFlags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				printCLICommandHelp(command)
+				return 0
+			}
+			fmt.Printf("Error: %v\n\n", err)
+			printCLICommandHelp(command)
+			return 2
+		}
+		if len(brainExportFlags.Args()) != 1 {
+			fmt.Println("Error: brain-export requires exactly one archive file")
+			fmt.Println()
+			printCLICommandHelp(command)
+			return 2
+		}
+		if err := cliBrainExport(brainExportFlags.Arg(0), brainExportReencrypt); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+	case "brain-import":
+		if err := brainImportFlags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				printCLICommandHelp(command)
+				return 0
+			}
+			fmt.Printf("Error: %v\n\n", err)
+			printCLICommandHelp(command)
+			return 2
+		}
+		if len(brainImportFlags.Args()) != 1 {
+			fmt.Println("Error: brain-import requires exactly one archive file")
+			fmt.Println()
+			printCLICommandHelp(command)
+			return 2
+		}
+		if err := cliBrainImport(brainImportFlags.Arg(0), brainImportOpts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
 	case "pull-brain":
 		if err := pullBrainFlags.Parse(args); err != nil {
 			if err == flag.ErrHelp {
@@ -988,6 +1099,26 @@ func processCLI(command string, args []string) int {
 			fmt.Printf("Error: %v\n", err)
 			return 1
 		}
+	case "rotate-key":
+		if err := rotateKeyFlags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				printCLICommandHelp(command)
+				return 0
+			}
+			fmt.Printf("Error: %v\n\n", err)
+			printCLICommandHelp(command)
+			return 2
+		}
+		if len(rotateKeyFlags.Args()) > 0 {
+			fmt.Println("Error: rotate-key does not take positional arguments")
+			fmt.Println()
+			printCLICommandHelp(command)
+			return 2
+		}
+		if err := cliRotateKey(rotateKeyOpts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
 	case "validate":
 		if err := validateFlags.Parse(args); err != nil {
 			if err == flag.ErrHelp {
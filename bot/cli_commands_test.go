@@ -367,6 +367,14 @@ func TestProcessCLIHelpBrainMemoryCommandsShowCacheSemantics(t *testing.T) {
 			"Usage: gopherbot list [options]",
 			"-cloud",
 		},
+		"brain-export": {
+			"Usage: gopherbot brain-export [-reencrypt <key file>] <file>",
+			"tombstones",
+		},
+		"brain-import": {
+			"Usage: gopherbot brain-import [options] <file>",
+			"restore-brain -force",
+		},
 		"restore-brain": {
 			"Usage: gopherbot restore-brain [-v2] [options]",
 			"Defaults to v3 output",
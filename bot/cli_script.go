@@ -568,10 +568,10 @@ func runCLIScript(inv cliScriptInvocation, r *cliLocalRobot) (robot.TaskRetVal,
 	switch inv.Language {
 	case "lua":
 		args := inv.scriptArgs()
-		return runLuaExtensionViaRPC(inv.ScriptPath, inv.TaskName, inv.WorkDir, libPaths(), botMap, false, nil, r, args)
+		return runLuaExtensionViaRPC(inv.ScriptPath, inv.TaskName, inv.WorkDir, libPaths("lua"), botMap, false, nil, r, args)
 	case "js":
 		args := inv.scriptArgs()
-		return runJSExtensionViaRPC(inv.ScriptPath, inv.TaskName, inv.WorkDir, libPaths(), botMap, false, nil, r, args)
+		return runJSExtensionViaRPC(inv.ScriptPath, inv.TaskName, inv.WorkDir, libPaths("js"), botMap, false, nil, r, args)
 	case "gsh":
 		return runGSHExtensionViaRPC(inv.ScriptPath, inv.TaskName, inv.WorkDir, env, false, nil, r, inv.scriptArgs())
 	case "go":
@@ -805,6 +805,11 @@ func (r *cliLocalRobot) UnlinkIdentity(provider, user string) robot.RetVal {
 	return robot.Failed
 }
 
+func (r *cliLocalRobot) PublishQueueMessage(provider, topic, body string, attributes map[string]string) robot.RetVal {
+	r.record(cliScriptEvent{Type: "queue", Method: "PublishQueueMessage", Target: provider + "/" + topic, Message: body})
+	return robot.Ok
+}
+
 func (r *cliLocalRobot) Email(subject string, messageBody *bytes.Buffer, html ...bool) robot.RetVal {
 	r.record(cliScriptEvent{Type: "email", Method: "Email", Target: "default", Message: subject})
 	return robot.Ok
@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// CommandSchemas implements robot.CommandDirectory, describing plugin
+// commands for connectors that register them natively. Matchers the schema
+// can't express, like alternatives or options blocks, are left out; users
+// can still type those.
+func (h handler) CommandSchemas() []robot.CommandSchema {
+	currentCfg.RLock()
+	tasks := currentCfg.taskList
+	currentCfg.RUnlock()
+
+	schemas := []robot.CommandSchema{}
+	for _, t := range tasks.t[1:] {
+		task, plugin, _ := getTask(t)
+		if task == nil || plugin == nil || task.Disabled {
+			continue
+		}
+		for _, matcher := range plugin.Commands {
+			spec := strings.TrimSpace(matcher.SimpleMatcher)
+			command := strings.TrimSpace(strings.ToLower(matcher.Command))
+			if spec == "" || command == "" {
+				continue
+			}
+			simple := matcher.simple
+			if simple == nil {
+				var err error
+				if simple, err = compileSimpleMatcherObject(spec); err != nil {
+					continue
+				}
+			}
+			schema, ok := commandSchemaFromMatcher(simple)
+			if !ok {
+				Log(robot.Debug, "Command '%s' in plugin '%s' has no native command schema", command, task.name)
+				continue
+			}
+			schema.Plugin = task.name
+			schema.Command = command
+			if len(schema.Words) == 0 {
+				schema.Words = []string{command}
+			}
+			schema.Summary = strings.TrimSpace(matcher.Summary)
+			if schema.Summary == "" {
+				schema.Summary = helpPluginSummary(task)
+			}
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+// commandSchemaBuilder walks a SimpleMatcher, collecting the leading
+// literal words, the arguments, and the parts to rebuild the text.
+type commandSchemaBuilder struct {
+	schema  robot.CommandSchema
+	leading bool
+	groups  int
+	names   map[string]int
+}
+
+func commandSchemaFromMatcher(m *simpleMatcher) (robot.CommandSchema, bool) {
+	if len(m.expr.alternatives) != 1 {
+		return robot.CommandSchema{}, false
+	}
+	b := &commandSchemaBuilder{leading: true, names: make(map[string]int)}
+	if !b.addSequence(m.expr.alternatives[0], 0) {
+		return robot.CommandSchema{}, false
+	}
+	return b.schema, true
+}
+
+func (b *commandSchemaBuilder) addSequence(s simpleMatcherSequence, group int) bool {
+	for _, term := range s.terms {
+		switch t := term.(type) {
+		case simpleMatcherLiteral:
+			b.addLiterals(simpleMatcherLiteralParts(t.value), group)
+		case simpleMatcherSlot:
+			b.leading = false
+			kind := strings.ToLower(t.kind)
+			b.addArgument(robot.CommandArgument{
+				Name:        t.name,
+				Type:        kind,
+				Description: simpleMatcherTypeDescriptions[kind],
+				Optional:    group > 0,
+			}, group)
+		case simpleMatcherGroup:
+			if !b.addGroup(t, group) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (b *commandSchemaBuilder) addGroup(g simpleMatcherGroup, group int) bool {
+	switch {
+	case g.capturing:
+		b.leading = false
+		choices := g.choiceValues()
+		b.addArgument(robot.CommandArgument{
+			Name:        g.label,
+			Type:        "choice",
+			Description: fmt.Sprintf("one of: %s", strings.Join(choices, ", ")),
+			Choices:     choices,
+			Optional:    g.optional || group > 0,
+		}, group)
+	case g.optional && !g.expr.containsSlot():
+		// Optional noise words don't change the command.
+	case g.optional:
+		if len(g.expr.alternatives) != 1 {
+			return false
+		}
+		b.leading = false
+		if group == 0 {
+			b.groups++
+			group = b.groups
+		}
+		return b.addSequence(g.expr.alternatives[0], group)
+	default:
+		// A synonym group; any alternative will do.
+		parts := synonymLiteralParts(g)
+		if len(parts) == 0 {
+			return false
+		}
+		b.addLiterals(parts, group)
+	}
+	return true
+}
+
+// synonymLiteralParts picks the first alternative spelled with plain
+// words, so "/i:|ignore/" names the command "ignore".
+func synonymLiteralParts(g simpleMatcherGroup) []string {
+	var first []string
+	for _, alt := range g.expr.alternatives {
+		parts := simpleMatcherSequenceLiteralParts(alt)
+		if len(parts) == 0 {
+			continue
+		}
+		if first == nil {
+			first = parts
+		}
+		plain := true
+		for _, part := range parts {
+			if !simpleMatcherIdentifierRe.MatchString(part) {
+				plain = false
+				break
+			}
+		}
+		if plain {
+			return parts
+		}
+	}
+	return first
+}
+
+func (b *commandSchemaBuilder) addLiterals(words []string, group int) {
+	for _, word := range words {
+		if b.leading {
+			b.schema.Words = append(b.schema.Words, strings.ToLower(word))
+		}
+		b.schema.Parts = append(b.schema.Parts, robot.CommandPart{Literal: word, Group: group})
+	}
+}
+
+func (b *commandSchemaBuilder) addArgument(arg robot.CommandArgument, group int) {
+	name := strings.ToLower(arg.Name)
+	if name == "" {
+		name = arg.Type
+	}
+	b.names[name]++
+	if n := b.names[name]; n > 1 {
+		name = fmt.Sprintf("%s%d", name, n)
+	}
+	arg.Name = name
+	b.schema.Arguments = append(b.schema.Arguments, arg)
+	b.schema.Parts = append(b.schema.Parts, robot.CommandPart{Argument: len(b.schema.Arguments), Group: group})
+}
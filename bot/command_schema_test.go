@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func mustCommandSchema(t *testing.T, spec string) robot.CommandSchema {
+	t.Helper()
+	m, err := compileSimpleMatcherObject(spec)
+	if err != nil {
+		t.Fatalf("compileSimpleMatcherObject(%q): %v", spec, err)
+	}
+	schema, ok := commandSchemaFromMatcher(m)
+	if !ok {
+		t.Fatalf("no command schema for %q", spec)
+	}
+	return schema
+}
+
+func TestCommandSchemaLiteralsAndCaptures(t *testing.T) {
+	schema := mustCommandSchema(t, "/remove|delete/ <user:token> from {the} [<group:rest>] group")
+	if !reflect.DeepEqual(schema.Words, []string{"remove"}) {
+		t.Fatalf("Words = %q", schema.Words)
+	}
+	want := []robot.CommandArgument{
+		{Name: "user", Type: "token", Description: simpleMatcherTypeDescriptions["token"]},
+		{Name: "group", Type: "rest", Optional: true},
+	}
+	if !reflect.DeepEqual(schema.Arguments, want) {
+		t.Fatalf("Arguments = %+v", schema.Arguments)
+	}
+	parts := []robot.CommandPart{
+		{Literal: "remove"},
+		{Argument: 1},
+		{Literal: "from"},
+		{Argument: 2, Group: 1},
+		{Literal: "group"},
+	}
+	if !reflect.DeepEqual(schema.Parts, parts) {
+		t.Fatalf("Parts = %+v", schema.Parts)
+	}
+}
+
+func TestCommandSchemaChoicesAndOptionalGroups(t *testing.T) {
+	schema := mustCommandSchema(t, "/set log level|set loglevel/ {to} (level:trace|debug|info)")
+	if !reflect.DeepEqual(schema.Words, []string{"set", "log", "level"}) {
+		t.Fatalf("Words = %q", schema.Words)
+	}
+	if arg := schema.Arguments[0]; arg.Name != "level" || arg.Type != "choice" || arg.Optional ||
+		!reflect.DeepEqual(arg.Choices, []string{"trace", "debug", "info"}) {
+		t.Fatalf("choice argument = %+v", arg)
+	}
+
+	schema = mustCommandSchema(t, "show /log|logs/ [page <page:number>]")
+	if !reflect.DeepEqual(schema.Words, []string{"show", "log"}) {
+		t.Fatalf("Words = %q", schema.Words)
+	}
+	parts := []robot.CommandPart{
+		{Literal: "show"},
+		{Literal: "log"},
+		{Literal: "page", Group: 1},
+		{Argument: 1, Group: 1},
+	}
+	if !reflect.DeepEqual(schema.Parts, parts) || !schema.Arguments[0].Optional {
+		t.Fatalf("schema = %+v", schema)
+	}
+
+	schema = mustCommandSchema(t, "/i:|ignore|ignore:/ <message:rest>")
+	if !reflect.DeepEqual(schema.Words, []string{"ignore"}) {
+		t.Fatalf("Words = %q", schema.Words)
+	}
+}
+
+func TestCommandSchemaRejectsAlternativesAndOptions(t *testing.T) {
+	for _, spec := range []string{
+		"restart [<app:ident>|all <env:ident>]",
+		"deploy <app:ident> [-opts: -force|-env<name:ident>]",
+	} {
+		m, err := compileSimpleMatcherObject(spec)
+		if err != nil {
+			t.Fatalf("compileSimpleMatcherObject(%q): %v", spec, err)
+		}
+		if _, ok := commandSchemaFromMatcher(m); ok {
+			t.Fatalf("got a command schema for %q", spec)
+		}
+	}
+}
@@ -113,6 +113,7 @@ type ConfigLoader struct {
 	EncryptionKey        string                            `yaml:"EncryptionKey"`        // Used to decrypt the "real" encryption key
 	HistoryProvider      string                            `yaml:"HistoryProvider"`      // Name of provider to use for storing and retrieving job/plugin histories
 	QueueProviders       []string                          `yaml:"QueueProviders"`       // Optional queue providers to initialize after startup
+	ModulePaths          []string                          `yaml:"ModulePaths"`          // Extra Lua/JavaScript module directories, relative to the config or install dir
 	HttpDebug            bool                              `yaml:"HttpDebug"`            // Whether to turn on debug logging of local http API calls
 	WorkSpace            string                            `yaml:"WorkSpace"`            // Read/Write area the robot uses to do work
 	ReadyMessage         string                            `yaml:"ReadyMessage"`         // Optional channel message sent after startup readiness
@@ -479,7 +480,7 @@ func loadConfig(preConnect bool) error {
 			val = &identityVal
 		case "ScheduledJobs":
 			val = &stval
-		case "DefaultChannels", "IgnoreUsers", "JoinChannels", "AdminUsers", "SecondaryProtocols", "QueueProviders":
+		case "DefaultChannels", "IgnoreUsers", "JoinChannels", "AdminUsers", "SecondaryProtocols", "QueueProviders", "ModulePaths":
 			val = &sarrval
 		case "MailConfig":
 			val = &mailval
@@ -525,6 +526,8 @@ func loadConfig(preConnect bool) error {
 			newconfig.HistoryProvider = *(val.(*string))
 		case "QueueProviders":
 			newconfig.QueueProviders = *(val.(*[]string))
+		case "ModulePaths":
+			newconfig.ModulePaths = *(val.(*[]string))
 		case "WorkSpace":
 			newconfig.WorkSpace = *(val.(*string))
 		case "ReadyMessage":
@@ -724,6 +727,8 @@ func loadConfig(preConnect bool) error {
 		processed.adminContact = newconfig.AdminContact
 	}
 
+	processed.modulePaths = resolveModulePaths(newconfig.ModulePaths)
+
 	if newconfig.TimeZone != "" {
 		tz, err := time.LoadLocation(newconfig.TimeZone)
 		if err == nil {
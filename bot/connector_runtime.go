@@ -63,6 +63,20 @@ func protocolNameFromEnum(protocol robot.Protocol) string {
 		return "nullconn"
 	case robot.SSH:
 		return "ssh"
+	case robot.Mattermost:
+		return "mattermost"
+	case robot.Matrix:
+		return "matrix"
+	case robot.IRC:
+		return "irc"
+	case robot.Webhook:
+		return "webhook"
+	case robot.Email:
+		return "email"
+	case robot.Teams:
+		return "teams"
+	case robot.Discord:
+		return "discord"
 	default:
 		return "test"
 	}
@@ -219,6 +219,7 @@ type worker struct {
 	automaticTask           bool                    // set for scheduled & triggers jobs, where user security restrictions don't apply
 	queueProvider           string                  // queue provider that started a queued job
 	queueMessageID          string                  // provider-local queue message ID for a queued job
+	queueParameters         map[string]string       // allow-listed queue attributes, by parameter name
 	*pipeContext                                    // pointer to the pipeline context
 	serializeAPICalls       sync.Mutex              // serializes external HTTP/RPC Robot API calls for this worker
 	externalKillPending     bool                    // timeout/admin kill is waiting for serialized external API calls to drain
@@ -286,6 +287,7 @@ func (w *worker) clone() *worker {
 		fmsg:            w.fmsg,
 		queueProvider:   w.queueProvider,
 		queueMessageID:  w.queueMessageID,
+		queueParameters: w.queueParameters,
 	}
 	if w.pipeContext != nil {
 		w.Lock()
@@ -531,6 +533,40 @@ func (h handler) GetBotInfo() robot.BotInfo {
 	}
 }
 
+// UserNameForEmail implements robot.UserDirectory.
+func (h handler) UserNameForEmail(address string) (string, bool) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", false
+	}
+	currentUCMaps.Lock()
+	maps := currentUCMaps.ucmap
+	currentUCMaps.Unlock()
+	if maps == nil {
+		return "", false
+	}
+	for name, du := range maps.user {
+		if strings.EqualFold(du.Email, address) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// EmailForUserName implements robot.UserDirectory.
+func (h handler) EmailForUserName(user string) (string, bool) {
+	currentUCMaps.Lock()
+	maps := currentUCMaps.ucmap
+	currentUCMaps.Unlock()
+	if maps == nil {
+		return "", false
+	}
+	if du, ok := maps.user[user]; ok && du.Email != "" {
+		return du.Email, true
+	}
+	return "", false
+}
+
 // Log logs a message to the robot's log file (or stderr)
 func (h handler) Log(l robot.LogLevel, m string, v ...interface{}) {
 	Log(l, m, v...)
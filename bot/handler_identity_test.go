@@ -1,6 +1,10 @@
 package bot
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
 
 func TestResolveIncomingUserProtocolAndDirectory(t *testing.T) {
 	alice := &UserInfo{UserName: "alice", UserID: "U001"}
@@ -97,3 +101,34 @@ func TestResolveIncomingProtocolUserPrefersTransportID(t *testing.T) {
 		t.Fatalf("resolveIncomingProtocolUser() without id = %q, want %q", got, "alice")
 	}
 }
+
+func TestHandlerUserDirectoryLooksUpRosterEmail(t *testing.T) {
+	currentUCMaps.Lock()
+	oldMaps := currentUCMaps.ucmap
+	currentUCMaps.ucmap = &userChanMaps{
+		user: map[string]*DirectoryUser{
+			"alice": {UserName: "alice", Email: "Alice@Example.com"},
+			"bob":   {UserName: "bob"},
+		},
+	}
+	currentUCMaps.Unlock()
+	defer func() {
+		currentUCMaps.Lock()
+		currentUCMaps.ucmap = oldMaps
+		currentUCMaps.Unlock()
+	}()
+
+	var dir robot.UserDirectory = connectorHandler{handler: handle, protocol: "email"}
+	if name, ok := dir.UserNameForEmail("alice@example.COM"); !ok || name != "alice" {
+		t.Fatalf("UserNameForEmail() = %q, %t, want alice", name, ok)
+	}
+	if name, ok := dir.UserNameForEmail(""); ok {
+		t.Fatalf("UserNameForEmail(\"\") matched %q", name)
+	}
+	if addr, ok := dir.EmailForUserName("alice"); !ok || addr != "Alice@Example.com" {
+		t.Fatalf("EmailForUserName(alice) = %q, %t", addr, ok)
+	}
+	if _, ok := dir.EmailForUserName("bob"); ok {
+		t.Fatal("EmailForUserName(bob) found an address for a user without one")
+	}
+}
@@ -3,6 +3,7 @@ package bot
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/robot"
 )
@@ -160,3 +161,47 @@ func TestNewLoggerWithRetainedLogsStoresHistoryMemory(t *testing.T) {
 		t.Fatalf("expected history lookup memory in brain")
 	}
 }
+
+type runInfoLogger struct {
+	robot.HistoryLogger
+	infos []robot.RunInfo
+}
+
+func (l *runInfoLogger) SetRunInfo(info robot.RunInfo) {
+	l.infos = append(l.infos, info)
+}
+
+func TestPipelineLoggerPassesRunInfo(t *testing.T) {
+	base := &runInfoLogger{}
+	newPipelineLiveLogger(base).setRunInfo(robot.RunInfo{Tag: "nightly", Index: 3})
+	if len(base.infos) != 1 || base.infos[0].Tag != "nightly" || base.infos[0].Index != 3 {
+		t.Fatalf("infos = %+v, want the nightly run", base.infos)
+	}
+	// Loggers without run metadata are left alone
+	newPipelineLiveLogger(base.HistoryLogger).setRunInfo(robot.RunInfo{})
+}
+
+func TestFormatRunInfo(t *testing.T) {
+	started := time.Date(2026, 3, 10, 14, 5, 0, 0, time.UTC)
+	run := robot.RunInfo{
+		Index:    12,
+		Ref:      "abc123",
+		User:     "alice",
+		Channel:  "ops",
+		Trigger:  "run",
+		Args:     []string{"prod"},
+		Tasks:    []string{"deploy", "notify"},
+		Started:  started,
+		Finished: started.Add(90 * time.Second),
+		Status:   robot.Fail,
+	}
+	want := "Run #12, Mar 10 14:05:00; run by alice in ops; args: prod; failed after 1m30s, exit code: 1 (Fail); tasks: deploy, notify; log abc123"
+	if got := formatRunInfo(run, time.UTC); got != want {
+		t.Fatalf("formatRunInfo() = %q, want %q", got, want)
+	}
+	run = robot.RunInfo{Index: 13, Trigger: "sched", Started: started}
+	want = "Run #13, Mar 10 14:05:00; sched; running"
+	if got := formatRunInfo(run, nil); got != want {
+		t.Fatalf("formatRunInfo() = %q, want %q", got, want)
+	}
+}
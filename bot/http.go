@@ -35,6 +35,14 @@ type identityrequest struct {
 	User     string
 }
 
+type queuepublishrequest struct {
+	Provider   string
+	Topic      string
+	Body       string
+	Attributes map[string]string
+	Base64     bool
+}
+
 type helpmetadataquery struct {
 	Query  string
 	Base64 bool
@@ -547,6 +555,16 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 		sendReturn(r, rw, &botretvalresponse{int(r.UnlinkIdentity(req.Provider, req.User))})
 		return
+	case "PublishQueueMessage":
+		var req queuepublishrequest
+		if !getArgs(rw, &f.FuncArgs, &req) {
+			return
+		}
+		if req.Base64 {
+			req.Body = decode(req.Body)
+		}
+		sendReturn(r, rw, &botretvalresponse{int(r.PublishQueueMessage(req.Provider, req.Topic, req.Body, req.Attributes))})
+		return
 	case "GetHelpMetadata":
 		var q helpmetadataquery
 		if !getArgs(rw, &f.FuncArgs, &q) {
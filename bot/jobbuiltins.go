@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/robot"
 )
@@ -104,7 +105,7 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 	w := getLockedWorker(r.tid)
 	w.Unlock()
 
-	var histRef, histSpec, jobName, index, user, address string
+	var histRef, histSpec, jobName, index, user, address, status, days, text string
 	var idx int
 
 	switch command {
@@ -116,7 +117,17 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 		histRef = args[0]
 	case "joblogs":
 		jobName = args[0]
+		if len(args) > 2 {
+			status = args[1]
+			days = args[2]
+		}
+	case "searchlogs":
+		jobName = args[0]
+		text = args[1]
 	}
+	// Providers that keep run metadata can answer lookups and searches the
+	// brain-backed history lists can't.
+	searcher, _ := getHistoryProvider().(robot.RunSearcher)
 
 	if len(index) > 0 {
 		var err error
@@ -136,6 +147,14 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 			return
 		}
 		hl, ok := lmap[histRef]
+		if !ok && searcher != nil {
+			runs, err := searcher.SearchRuns(robot.RunQuery{Ref: histRef, Limit: 1})
+			if err != nil {
+				w.Log(robot.Error, "Searching history for log ref '%s': %v", histRef, err)
+			} else if len(runs) == 1 {
+				hl, ok = historyLookup{runs[0].Tag, runs[0].Index}, true
+			}
+		}
 		if !ok {
 			r.Say("Log ref '%s' not found, possibly expired?", histRef)
 			w.Log(robot.Warn, "Log ref '%s' not found: %s", histRef)
@@ -161,16 +180,23 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 		return
 	}
 
-	jh := pipeHistory{}
-	key := histPrefix + histSpec
-	_, _, ret := checkoutDatum(key, &jh, false)
-	if ret != robot.Ok {
-		r.Say("No logs found for '%s'", histSpec)
+	if searcher == nil && (command == "searchlogs" || status != "" || days != "") {
+		r.Say("Sorry, searching logs needs a history provider that supports it, such as 'sqlite'")
 		return
 	}
-	if len(jh.Histories) == 0 {
-		r.Say("No logs found for '%s'", histSpec)
-		return
+
+	jh := pipeHistory{}
+	if searcher == nil {
+		key := histPrefix + histSpec
+		_, _, ret := checkoutDatum(key, &jh, false)
+		if ret != robot.Ok {
+			r.Say("No logs found for '%s'", histSpec)
+			return
+		}
+		if len(jh.Histories) == 0 {
+			r.Say("No logs found for '%s'", histSpec)
+			return
+		}
 	}
 
 	switch command {
@@ -195,7 +221,10 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 			return
 		}
 		r.Say("Here you go: %s", url)
-	case "joblogs":
+	case "joblogs", "searchlogs":
+		if searcher != nil {
+			return searchhistory(r, searcher, jobName, status, days, text)
+		}
 		var loglines []string
 		loglines = []string{fmt.Sprintf("Logs for job '%s':", jobName)}
 		for _, log := range jh.Histories {
@@ -209,6 +238,90 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 	return
 }
 
+// searchhistory lists runs of a job from a history provider that keeps run
+// metadata, optionally limited to failed runs, recent runs, or runs with
+// matching output.
+func searchhistory(r Robot, searcher robot.RunSearcher, jobName, status, days, text string) (retval robot.TaskRetVal) {
+	q := robot.RunQuery{
+		Tag:    jobName,
+		Failed: status == "failed",
+		Text:   text,
+	}
+	var filters []string
+	if q.Failed {
+		filters = append(filters, "failed")
+	}
+	if len(days) > 0 {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			r.Say("Unable to use '%s' as a number of days", days)
+			return
+		}
+		q.Since = time.Now().AddDate(0, 0, -n)
+		filters = append(filters, fmt.Sprintf("last %d days", n))
+	}
+	if len(text) > 0 {
+		filters = append(filters, fmt.Sprintf("output containing '%s'", text))
+	}
+	runs, err := searcher.SearchRuns(q)
+	if err != nil {
+		r.Log(robot.Error, "Searching history for job '%s': %v", jobName, err)
+		r.Say("There was a problem searching the logs for '%s', contact an administrator", jobName)
+		return
+	}
+	desc := ""
+	if len(filters) > 0 {
+		desc = " (" + strings.Join(filters, ", ") + ")"
+	}
+	if len(runs) == 0 {
+		r.Say("No logs found for job '%s'%s", jobName, desc)
+		return
+	}
+	loglines := []string{fmt.Sprintf("Logs for job '%s'%s:", jobName, desc)}
+	for _, run := range runs {
+		loglines = append(loglines, formatRunInfo(run, r.cfg.timeZone))
+	}
+	r.Say(strings.Join(loglines, "\n"))
+	return
+}
+
+// formatRunInfo gives a one-line summary of a run for job logs.
+func formatRunInfo(run robot.RunInfo, tz *time.Location) string {
+	started := run.Started
+	if tz != nil {
+		started = started.In(tz)
+	}
+	parts := []string{fmt.Sprintf("Run #%d, %s", run.Index, started.Format("Jan 2 15:04:05"))}
+	source := run.Trigger
+	if len(run.User) > 0 {
+		source += " by " + run.User
+	}
+	if len(run.Channel) > 0 {
+		source += " in " + run.Channel
+	}
+	if source = strings.TrimSpace(source); len(source) > 0 {
+		parts = append(parts, source)
+	}
+	if len(run.Args) > 0 {
+		parts = append(parts, "args: "+strings.Join(run.Args, " "))
+	}
+	switch {
+	case run.Finished.IsZero():
+		parts = append(parts, "running")
+	case run.Status == robot.Normal:
+		parts = append(parts, fmt.Sprintf("normal after %v", run.Finished.Sub(run.Started).Round(time.Second)))
+	default:
+		parts = append(parts, fmt.Sprintf("failed after %v, exit code: %d (%s)", run.Finished.Sub(run.Started).Round(time.Second), int(run.Status), run.Status))
+	}
+	if len(run.Tasks) > 0 {
+		parts = append(parts, "tasks: "+strings.Join(run.Tasks, ", "))
+	}
+	if len(run.Ref) > 0 {
+		parts = append(parts, "log "+run.Ref)
+	}
+	return strings.Join(parts, "; ")
+}
+
 // jobSecurityCheck performs all security checks - RequireAdmin, Authorization
 // and Elevation - and returns true if passed. It will message the user and
 // return false if a check fails.
@@ -0,0 +1,456 @@
+package bot
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"gopkg.in/yaml.v3"
+)
+
+// Key files replaced by rotate-key are kept beside the original with this
+// marker and a timestamp until the operator removes them.
+const rotatedKeyFileMarker = ".rotated-"
+
+type keyRotationOptions struct {
+	newKeyEnv  string
+	rewrapOnly bool
+	dryRun     bool
+}
+
+type stagedMemory struct {
+	key      string
+	payload  []byte
+	checksum string
+	previous []byte // ciphertext under the old key, for rollback
+}
+
+type stagedFile struct {
+	path     string
+	mode     os.FileMode
+	data     []byte
+	count    int
+	previous []byte // nil when the file didn't exist
+}
+
+func cliRotateKey(opts keyRotationOptions) error {
+	if opts.rewrapOnly && opts.newKeyEnv == "" {
+		return errors.New("-rewrap-only requires -new-key-env")
+	}
+	initCLIConfigOnly()
+	wrappingKey, ok := lookupEnv(keyEnv)
+	if !ok || len(wrappingKey) < 32 {
+		return fmt.Errorf("%s must be set and at least 32 bytes long", keyEnv)
+	}
+	newWrappingKey := []byte(wrappingKey)[:32]
+	if opts.newKeyEnv != "" {
+		value, ok := lookupEnv(opts.newKeyEnv)
+		if !ok || len(value) < 32 {
+			return fmt.Errorf("%s must be set and at least 32 bytes long", opts.newKeyEnv)
+		}
+		newWrappingKey = []byte(value)[:32]
+	}
+	activeKeyFile, _, _, err := resolveEncryptedKeyFile()
+	if err != nil {
+		return err
+	}
+	if activeKeyFile == "" {
+		return errors.New("no binary encrypted key file found; nothing to rotate")
+	}
+	oldKey, err := loadWrappedDataKey(activeKeyFile)
+	if err != nil {
+		return err
+	}
+	if current, err := currentBrainDataKey(); err != nil || !bytes.Equal(current, oldKey) {
+		return fmt.Errorf("active encryption key doesn't match %s", activeKeyFile)
+	}
+	newKey := oldKey
+	if !opts.rewrapOnly {
+		newKey = make([]byte, 32)
+		if _, err := crand.Read(newKey); err != nil {
+			return fmt.Errorf("generating random data key: %w", err)
+		}
+	}
+
+	// Stage and verify everything before anything is switched.
+	keyFiles, err := stageKeyFileRotation(activeKeyFile, newKey, newWrappingKey, opts)
+	if err != nil {
+		return err
+	}
+	var (
+		secretFiles []stagedFile
+		skipped     []string
+		memories    []stagedMemory
+	)
+	provider := currentCfg.brainProvider
+	if !opts.rewrapOnly {
+		secretFiles, skipped, err = stageSecretRotation(filepath.Join(configPath, "conf", "variables"), oldKey, newKey)
+		if err != nil {
+			return err
+		}
+		if provider != "" && provider != "mem" {
+			initCLIBrainProvider()
+			memories, err = stageBrainRotation(interfaces.brain, oldKey, newKey)
+			if err != nil {
+				shutdownCLIBrainProvider(false)
+				return err
+			}
+		}
+	}
+	secretCount := 0
+	for _, file := range secretFiles {
+		secretCount += file.count
+	}
+	for _, name := range skipped {
+		fmt.Printf("Skipping secret %s: it doesn't decrypt with the active key\n", name)
+	}
+	if opts.dryRun {
+		shutdownCLIBrainProvider(false)
+		fmt.Printf("Would rewrite %d key file(s), %d secret(s) in %d variables file(s) and %d brain memories\n", len(keyFiles), secretCount, len(secretFiles), len(memories))
+		return nil
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	backups, err := commitKeyRotation(interfaces.brain, keyFiles, secretFiles, memories, stamp)
+	if err != nil {
+		shutdownCLIBrainProvider(len(memories) > 0)
+		return err
+	}
+	if len(memories) > 0 {
+		cache, _ := interfaces.brain.(*cachedBrain)
+		shutdownCLIBrainProvider(true)
+		reportLocalCloudOutboxStatus()
+		if err := verifyRotatedMemories(cache, memories); err != nil {
+			return fmt.Errorf("%w; previous key files are saved as %s", err, strings.Join(backups, ", "))
+		}
+	} else {
+		shutdownCLIBrainProvider(false)
+	}
+
+	fmt.Printf("Rewrote %d key file(s), %d secret(s) in %d variables file(s) and %d brain memories\n", len(keyFiles), secretCount, len(secretFiles), len(memories))
+	if opts.newKeyEnv != "" {
+		fmt.Printf("Set %s to the value of %s before starting the robot.\n", keyEnv, opts.newKeyEnv)
+	}
+	if len(backups) > 0 {
+		fmt.Printf("Previous key files were saved as %s; remove them once the robot starts cleanly.\n", strings.Join(backups, ", "))
+	}
+	return nil
+}
+
+// stageKeyFileRotation wraps the new data key for the active key file, and
+// with a new wrapping key re-wraps every other binary-encrypted-key* file.
+func stageKeyFileRotation(activeKeyFile string, newKey, newWrappingKey []byte, opts keyRotationOptions) ([]stagedFile, error) {
+	var staged []stagedFile
+	wrap := func(path string, key []byte) error {
+		wrapped, err := encrypt(key, newWrappingKey)
+		if err != nil {
+			return fmt.Errorf("wrapping key for %s: %w", path, err)
+		}
+		staged = append(staged, stagedFile{
+			path:  path,
+			mode:  encryptedKeyFileMode,
+			data:  []byte(base64.StdEncoding.EncodeToString(wrapped)),
+			count: 1,
+		})
+		return nil
+	}
+	if err := wrap(activeKeyFile, newKey); err != nil {
+		return nil, err
+	}
+	if opts.newKeyEnv == "" {
+		return staged, nil
+	}
+	paths, err := encryptedKeyFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if path == activeKeyFile {
+			continue
+		}
+		key, err := loadWrappedDataKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := wrap(path, key); err != nil {
+			return nil, err
+		}
+	}
+	return staged, nil
+}
+
+func encryptedKeyFiles() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(configPath, encryptedKeyFile+"*"))
+	if err != nil {
+		return nil, err
+	}
+	paths := matches[:0]
+	for _, path := range matches {
+		if !strings.Contains(filepath.Base(path), rotatedKeyFileMarker) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// stageSecretRotation re-encrypts the Secrets in every conf/variables file.
+// Secrets from another environment's key domain don't decrypt with oldKey;
+// they're left alone and returned as file:NAME.
+func stageSecretRotation(dir string, oldKey, newKey []byte) ([]stagedFile, []string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+	var (
+		staged  []stagedFile
+		skipped []string
+	)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, count, names, err := rotateSecretsFile(raw, oldKey, newKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rotating secrets in %s: %w", path, err)
+		}
+		for _, name := range names {
+			skipped = append(skipped, filepath.Base(path)+":"+name)
+		}
+		if count > 0 {
+			staged = append(staged, stagedFile{path: path, mode: info.Mode().Perm(), data: data, count: count, previous: raw})
+		}
+	}
+	return staged, skipped, nil
+}
+
+// rotateSecretsFile replaces each Secrets ciphertext in place, so comments
+// and layout in the variables file are kept.
+func rotateSecretsFile(raw, oldKey, newKey []byte) ([]byte, int, []string, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return raw, 0, nil, nil
+	}
+	var loaded configVariablesFile
+	if err := yaml.Unmarshal(raw, &loaded); err != nil {
+		return nil, 0, nil, err
+	}
+	names := make([]string, 0, len(loaded.Secrets))
+	for name, value := range loaded.Secrets {
+		if value != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var skipped []string
+	count := 0
+	for _, name := range names {
+		old := strings.TrimSpace(*loaded.Secrets[name])
+		ciphertext, err := base64.StdEncoding.DecodeString(old)
+		if err != nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		rotated, err := reencryptPayload(ciphertext, oldKey, newKey)
+		if err != nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		if !bytes.Contains(raw, []byte(old)) {
+			return nil, 0, nil, fmt.Errorf("secret %s isn't a single-line value; re-encrypt it by hand", name)
+		}
+		raw = bytes.ReplaceAll(raw, []byte(old), []byte(base64.StdEncoding.EncodeToString(rotated)))
+		count++
+	}
+	return raw, count, skipped, nil
+}
+
+// stageBrainRotation re-encrypts every memory, verifying cached checksums
+// first. A robot still holding the instance lock stops the rotation.
+func stageBrainRotation(brain robot.SimpleBrain, oldKey, newKey []byte) ([]stagedMemory, error) {
+	lock, exists, err := readBrainLockForStartup()
+	if err != nil {
+		return nil, fmt.Errorf("checking brain instance lock: %w", err)
+	}
+	if exists && lock.State != brainLockReleased && !canReclaimHeldBrainLock(lock) {
+		return nil, errors.New(formatHeldBrainLockMessage(lock))
+	}
+	cache, _ := brain.(*cachedBrain)
+	if cache != nil && cache.remote != nil {
+		pending, err := cache.outboxEntries()
+		if err != nil {
+			return nil, err
+		}
+		if len(pending) > 0 {
+			return nil, fmt.Errorf("%d brain write(s) are still pending for cloud; run gopherbot flush-brain first", len(pending))
+		}
+	}
+	keys, err := brain.List()
+	if err != nil {
+		return nil, err
+	}
+	memories := make([]stagedMemory, 0, len(keys))
+	for _, key := range keys {
+		// The legacy v1 key record is wrapped with the user-supplied key.
+		if key == botEncryptionKey {
+			continue
+		}
+		payload, exists, err := brain.Retrieve(key)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving memory %s: %w", key, err)
+		}
+		if !exists || payload == nil {
+			continue
+		}
+		if cache != nil {
+			meta, exists, err := cache.readMeta(key)
+			if err != nil {
+				return nil, err
+			}
+			if !exists || checksumBytes(*payload) != meta.Checksum {
+				return nil, fmt.Errorf("local memory %s checksum mismatch", key)
+			}
+		}
+		rotated, err := reencryptPayload(*payload, oldKey, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("memory %s: %w", key, err)
+		}
+		memories = append(memories, stagedMemory{key: key, payload: rotated, checksum: checksumBytes(rotated), previous: *payload})
+	}
+	return memories, nil
+}
+
+// commitKeyRotation saves the previous key files beside the originals, then
+// stores the re-encrypted memories while the old key is still the active
+// one, and only then switches key files and secrets. A failure at any step
+// puts memories and files back, so the brain never ends up split across
+// two keys.
+func commitKeyRotation(brain robot.SimpleBrain, keyFiles, secretFiles []stagedFile, memories []stagedMemory, stamp string) ([]string, error) {
+	var backups []string
+	for i := range keyFiles {
+		previous, err := os.ReadFile(keyFiles[i].path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		keyFiles[i].previous = previous
+		backup := keyFiles[i].path + rotatedKeyFileMarker + stamp
+		if err := writeAtomicFile(backup, previous, encryptedKeyFileMode); err != nil {
+			return nil, fmt.Errorf("saving previous key file: %w", err)
+		}
+		backups = append(backups, backup)
+	}
+	if err := storeRotatedMemories(brain, memories); err != nil {
+		return backups, err
+	}
+	files := append(append([]stagedFile(nil), keyFiles...), secretFiles...)
+	for i, file := range files {
+		if err := writeAtomicFile(file.path, file.data, file.mode); err != nil {
+			err = fmt.Errorf("writing %s: %w", file.path, err)
+			if rerr := restoreStagedFiles(files[:i]); rerr != nil {
+				return backups, fmt.Errorf("%w; restoring files also failed: %v; previous key files are saved as %s", err, rerr, strings.Join(backups, ", "))
+			}
+			if rerr := restoreMemories(brain, memories); rerr != nil {
+				return backups, fmt.Errorf("%w; restoring brain memories also failed: %v", err, rerr)
+			}
+			return backups, fmt.Errorf("%w; rotation was rolled back", err)
+		}
+	}
+	return backups, nil
+}
+
+// storeRotatedMemories writes through the configured brain, so cloud brains
+// get new versions via the outbox. If a Store fails, memories already
+// written get their previous ciphertext back.
+func storeRotatedMemories(brain robot.SimpleBrain, memories []stagedMemory) error {
+	for i, memory := range memories {
+		payload := memory.payload
+		if err := brain.Store(memory.key, &payload); err != nil {
+			if rerr := restoreMemories(brain, memories[:i]); rerr != nil {
+				return fmt.Errorf("brain rotation stopped after %d of %d memories: %w; restoring the previous values also failed: %v", i, len(memories), err, rerr)
+			}
+			return fmt.Errorf("brain rotation stopped after %d of %d memories and was rolled back, nothing was changed: %w", i, len(memories), err)
+		}
+	}
+	return nil
+}
+
+// restoreMemories stores the pre-rotation ciphertext of each memory,
+// carrying on past failures and returning the first one.
+func restoreMemories(brain robot.SimpleBrain, memories []stagedMemory) error {
+	var first error
+	for _, memory := range memories {
+		previous := memory.previous
+		if err := brain.Store(memory.key, &previous); err != nil && first == nil {
+			first = fmt.Errorf("memory %s: %w", memory.key, err)
+		}
+	}
+	return first
+}
+
+// restoreStagedFiles puts back files written by a rotation that failed.
+func restoreStagedFiles(files []stagedFile) error {
+	var first error
+	for _, file := range files {
+		var err error
+		if file.previous == nil {
+			err = os.Remove(file.path)
+		} else {
+			err = writeAtomicFile(file.path, file.previous, file.mode)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// verifyRotatedMemories checks the flushed local cache holds the
+// re-encrypted checksums.
+func verifyRotatedMemories(cache *cachedBrain, memories []stagedMemory) error {
+	if cache == nil {
+		return nil
+	}
+	for _, memory := range memories {
+		meta, exists, err := cache.readMeta(memory.key)
+		if err != nil {
+			return err
+		}
+		if !exists || meta.Checksum != memory.checksum {
+			return fmt.Errorf("local memory %s doesn't have the re-encrypted checksum", memory.key)
+		}
+	}
+	return nil
+}
+
+// reencryptPayload moves ciphertext from oldKey to newKey, checking that the
+// result decrypts to the same plaintext.
+func reencryptPayload(ciphertext, oldKey, newKey []byte) ([]byte, error) {
+	plain, err := decrypt(ciphertext, oldKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting with the previous key: %w", err)
+	}
+	rotated, err := encrypt(plain, newKey)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting with the new key: %w", err)
+	}
+	check, err := decrypt(rotated, newKey)
+	if err != nil || !bytes.Equal(check, plain) {
+		return nil, errors.New("re-encrypted value didn't verify")
+	}
+	return rotated, nil
+}
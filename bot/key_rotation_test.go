@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func encryptedSecretForTest(t *testing.T, plaintext string, key []byte) string {
+	t.Helper()
+	ciphertext, err := encrypt([]byte(plaintext), key)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+func TestRotateSecretsFileKeepsLayoutAndSkipsOtherKeys(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	otherKey := bytes.Repeat([]byte("x"), 32)
+	otherSecret := encryptedSecretForTest(t, "dev-token", otherKey)
+	raw := []byte("# shared secrets\nSecrets:\n  API_TOKEN: \"" + encryptedSecretForTest(t, "token", oldKey) + "\" # rotated yearly\n" +
+		"  DEV_TOKEN: " + otherSecret + "\nVariables:\n  CHANNEL: general\n")
+
+	rotated, count, skipped, err := rotateSecretsFile(raw, oldKey, newKey)
+	if err != nil {
+		t.Fatalf("rotateSecretsFile: %v", err)
+	}
+	if count != 1 || len(skipped) != 1 || skipped[0] != "DEV_TOKEN" {
+		t.Fatalf("rotateSecretsFile count=%d skipped=%v, want 1 rotated and DEV_TOKEN skipped", count, skipped)
+	}
+	text := string(rotated)
+	for _, needle := range []string{"# shared secrets\n", "# rotated yearly", otherSecret, "CHANNEL: general"} {
+		if !strings.Contains(text, needle) {
+			t.Fatalf("rotated file lost %q:\n%s", needle, text)
+		}
+	}
+	var loaded configVariablesFile
+	if err := yaml.Unmarshal(rotated, &loaded); err != nil {
+		t.Fatalf("unmarshal rotated file: %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(*loaded.Secrets["API_TOKEN"])
+	if err != nil {
+		t.Fatalf("decode rotated secret: %v", err)
+	}
+	if plain, err := decrypt(ciphertext, newKey); err != nil || string(plain) != "token" {
+		t.Fatalf("rotated secret = %q, err %v", plain, err)
+	}
+}
+
+func TestStageKeyFileRotationRewrapsEveryKeyFile(t *testing.T) {
+	origConfigPath := configPath
+	t.Cleanup(func() { configPath = origConfigPath })
+	configPath = t.TempDir()
+	wrapping := strings.Repeat("w", 32)
+	newWrapping := []byte(strings.Repeat("v", 32))
+	t.Setenv(keyEnv, wrapping)
+
+	devKey := bytes.Repeat([]byte("d"), 32)
+	active := filepath.Join(configPath, encryptedKeyFile)
+	for path, key := range map[string][]byte{
+		active: bytes.Repeat([]byte("p"), 32),
+		filepath.Join(configPath, encryptedKeyFile+".development"):                          devKey,
+		filepath.Join(configPath, encryptedKeyFile+rotatedKeyFileMarker+"20260101T000000Z"): bytes.Repeat([]byte("r"), 32),
+	} {
+		wrapped, err := encrypt(key, []byte(wrapping))
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(wrapped)), 0600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	newKey := bytes.Repeat([]byte("n"), 32)
+	staged, err := stageKeyFileRotation(active, newKey, newWrapping, keyRotationOptions{newKeyEnv: "GOPHER_NEW_ENCRYPTION_KEY"})
+	if err != nil {
+		t.Fatalf("stageKeyFileRotation: %v", err)
+	}
+	if len(staged) != 2 {
+		t.Fatalf("staged %d key files, want active and development without the rotated backup", len(staged))
+	}
+	want := map[string][]byte{active: newKey, filepath.Join(configPath, encryptedKeyFile+".development"): devKey}
+	for _, file := range staged {
+		wrapped, err := base64.StdEncoding.DecodeString(string(file.data))
+		if err != nil {
+			t.Fatalf("decode %s: %v", file.path, err)
+		}
+		key, err := decrypt(wrapped, newWrapping)
+		if err != nil || !bytes.Equal(key, want[file.path]) {
+			t.Fatalf("%s unwraps to %x, err %v; want %x", file.path, key, err, want[file.path])
+		}
+		if file.mode != encryptedKeyFileMode {
+			t.Fatalf("%s mode = %o, want %o", file.path, file.mode, encryptedKeyFileMode)
+		}
+	}
+}
+
+func TestReencryptPayloadRejectsWrongKey(t *testing.T) {
+	ciphertext, err := encrypt([]byte("memory"), bytes.Repeat([]byte("a"), 32))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if _, err := reencryptPayload(ciphertext, bytes.Repeat([]byte("b"), 32), bytes.Repeat([]byte("c"), 32)); err == nil {
+		t.Fatal("reencryptPayload accepted the wrong previous key")
+	}
+}
+
+type failingStoreBrain struct {
+	memories  map[string][]byte
+	stores    int
+	failAfter int
+}
+
+func (b *failingStoreBrain) Store(key string, blob *[]byte) error {
+	b.stores++
+	if b.stores == b.failAfter+1 {
+		return errors.New("store refused")
+	}
+	b.memories[key] = append([]byte(nil), *blob...)
+	return nil
+}
+
+func (b *failingStoreBrain) Retrieve(key string) (*[]byte, bool, error) {
+	blob, ok := b.memories[key]
+	return &blob, ok, nil
+}
+
+func (b *failingStoreBrain) List() ([]string, error) { return nil, nil }
+func (b *failingStoreBrain) Delete(key string) error { return nil }
+func (b *failingStoreBrain) Flush() error            { return nil }
+func (b *failingStoreBrain) Shutdown()               {}
+
+func TestCommitKeyRotationRollsBackOnStoreFailure(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, encryptedKeyFile)
+	secretFile := filepath.Join(dir, "secrets.yaml")
+	for path, data := range map[string]string{keyFile: "old-wrapped-key", secretFile: "Secrets: {}\n"} {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	brain := &failingStoreBrain{memories: map[string][]byte{}, failAfter: 2}
+	var memories []stagedMemory
+	for _, key := range []string{"a", "b", "c", "d"} {
+		brain.memories[key] = []byte("old-" + key)
+		memories = append(memories, stagedMemory{key: key, payload: []byte("new-" + key), previous: []byte("old-" + key)})
+	}
+	keyFiles := []stagedFile{{path: keyFile, mode: encryptedKeyFileMode, data: []byte("new-wrapped-key"), count: 1}}
+	secretFiles := []stagedFile{{path: secretFile, mode: 0o600, data: []byte("Secrets: {new: x}\n"), count: 1, previous: []byte("Secrets: {}\n")}}
+
+	backups, err := commitKeyRotation(brain, keyFiles, secretFiles, memories, "20261017T000000Z")
+	if err == nil || !strings.Contains(err.Error(), "stopped after 2 of 4 memories and was rolled back") {
+		t.Fatalf("commitKeyRotation() error = %v, want rollback after 2 of 4", err)
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if got := string(brain.memories[key]); got != "old-"+key {
+			t.Fatalf("memory %s = %q after rollback, want old-%s", key, got, key)
+		}
+	}
+	for path, want := range map[string]string{keyFile: "old-wrapped-key", secretFile: "Secrets: {}\n"} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Fatalf("%s = %q, %v; want it untouched", path, got, err)
+		}
+	}
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], rotatedKeyFileMarker+"20261017T000000Z") {
+		t.Fatalf("backups = %q, want the previous key file saved", backups)
+	}
+
+	brain.stores, brain.failAfter = 0, -1
+	if _, err := commitKeyRotation(brain, keyFiles, secretFiles, memories, "20261017T000001Z"); err != nil {
+		t.Fatalf("commitKeyRotation() retry: %v", err)
+	}
+	if got, _ := os.ReadFile(keyFile); string(got) != "new-wrapped-key" || string(brain.memories["d"]) != "new-d" {
+		t.Fatalf("retry left key file %q and memory d %q", got, brain.memories["d"])
+	}
+}
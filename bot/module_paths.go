@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// resolveModulePaths turns robot.yaml ModulePaths entries into absolute
+// directories for Lua and JavaScript require(). Relative entries are tried
+// against the config directory, then the install directory; entries that
+// don't exist or don't resolve inside one of those are dropped with a warning.
+func resolveModulePaths(entries []string) []string {
+	bases := []string{configFull, installPath}
+	resolved := make([]string, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var candidates []string
+		if filepath.IsAbs(entry) {
+			candidates = []string{filepath.Clean(entry)}
+		} else {
+			for _, base := range bases {
+				if base != "" {
+					candidates = append(candidates, filepath.Join(base, entry))
+				}
+			}
+		}
+		found := ""
+		for _, dir := range candidates {
+			if !pathWithinAny(dir, bases) {
+				continue
+			}
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				found = dir
+				break
+			}
+		}
+		if found == "" {
+			Log(robot.Warn, "Ignoring ModulePaths entry '%s': not a directory under the config or install directory", entry)
+			continue
+		}
+		if seen[found] {
+			continue
+		}
+		seen[found] = true
+		resolved = append(resolved, found)
+	}
+	return resolved
+}
+
+// libPaths returns the module search path for lang ("lua" or "js"), in
+// lookup order: <install>/lib, <config>/lib, then the configured
+// ModulePaths, each directory preceded by its <lang> subdirectory. Missing
+// directories are skipped. ModulePaths directories that are group/world
+// writable are skipped too; the install and config lib directories are
+// trusted as they always have been.
+func libPaths(lang string) []string {
+	currentCfg.RLock()
+	configured := currentCfg.modulePaths
+	currentCfg.RUnlock()
+	bases := make([]string, 0, len(configured)+2)
+	if installPath != "" {
+		bases = append(bases, filepath.Join(installPath, "lib"))
+	}
+	if configFull != "" {
+		bases = append(bases, filepath.Join(configFull, "lib"))
+	}
+	bases = append(bases, configured...)
+	paths := make([]string, 0, 2*len(bases))
+	seen := make(map[string]bool)
+	for _, base := range bases {
+		for _, dir := range []string{filepath.Join(base, lang), base} {
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			info, err := os.Stat(dir)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			if info.Mode().Perm()&0o022 != 0 && pathWithinAny(dir, configured) {
+				Log(robot.Warn, "Skipping ModulePaths directory %s: group/world writable", dir)
+				continue
+			}
+			paths = append(paths, dir)
+		}
+	}
+	return paths
+}
+
+// strictModulePaths returns the entries of paths that come from robot.yaml
+// ModulePaths; the interpreters apply modpath's containment and permission
+// checks to modules loaded from those.
+func strictModulePaths(paths []string) []string {
+	currentCfg.RLock()
+	configured := currentCfg.modulePaths
+	currentCfg.RUnlock()
+	var strict []string
+	for _, dir := range paths {
+		if pathWithinAny(dir, configured) {
+			strict = append(strict, dir)
+		}
+	}
+	return strict
+}
+
+func pathWithinAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestModuleSearchPathOrderAndRestrictions(t *testing.T) {
+	oldInstallPath := installPath
+	oldConfigFull := configFull
+	oldConfig := currentCfg.configuration
+	t.Cleanup(func() {
+		installPath = oldInstallPath
+		configFull = oldConfigFull
+		currentCfg.Lock()
+		currentCfg.configuration = oldConfig
+		currentCfg.Unlock()
+	})
+
+	installPath = t.TempDir()
+	configFull = t.TempDir()
+	outside := t.TempDir()
+	mkdirs := func(dirs ...string) {
+		for _, dir := range dirs {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	mkdirs(
+		filepath.Join(installPath, "lib"),
+		filepath.Join(installPath, "lib", "lua"),
+		filepath.Join(configFull, "lib"),
+		filepath.Join(configFull, "lib", "js"),
+		filepath.Join(configFull, "shared"),
+		filepath.Join(installPath, "vendor", "lua"),
+		filepath.Join(configFull, "open"),
+	)
+	if err := os.Chmod(filepath.Join(configFull, "open"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	// umask 002 installs: the bundled lib dirs are trusted and still load.
+	for _, dir := range []string{filepath.Join(installPath, "lib"), filepath.Join(installPath, "lib", "lua")} {
+		if err := os.Chmod(dir, 0o775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolved := resolveModulePaths([]string{"shared", "vendor", "../" + filepath.Base(outside), outside, "missing", "shared", "open"})
+	wantResolved := []string{
+		filepath.Join(configFull, "shared"),
+		filepath.Join(installPath, "vendor"),
+		filepath.Join(configFull, "open"),
+	}
+	if !reflect.DeepEqual(resolved, wantResolved) {
+		t.Fatalf("resolveModulePaths() = %q, want %q", resolved, wantResolved)
+	}
+
+	currentCfg.Lock()
+	currentCfg.configuration = &configuration{modulePaths: resolved}
+	currentCfg.Unlock()
+
+	wantLua := []string{
+		filepath.Join(installPath, "lib", "lua"),
+		filepath.Join(installPath, "lib"),
+		filepath.Join(configFull, "lib"),
+		filepath.Join(configFull, "shared"),
+		filepath.Join(installPath, "vendor", "lua"),
+		filepath.Join(installPath, "vendor"),
+	}
+	if got := libPaths("lua"); !reflect.DeepEqual(got, wantLua) {
+		t.Fatalf("libPaths(lua) = %q, want %q", got, wantLua)
+	}
+	wantJS := []string{
+		filepath.Join(installPath, "lib"),
+		filepath.Join(configFull, "lib", "js"),
+		filepath.Join(configFull, "lib"),
+		filepath.Join(configFull, "shared"),
+		filepath.Join(installPath, "vendor"),
+	}
+	if got := libPaths("js"); !reflect.DeepEqual(got, wantJS) {
+		t.Fatalf("libPaths(js) = %q, want %q", got, wantJS)
+	}
+	wantStrict := []string{
+		filepath.Join(configFull, "shared"),
+		filepath.Join(installPath, "vendor", "lua"),
+		filepath.Join(installPath, "vendor"),
+	}
+	if got := strictModulePaths(wantLua); !reflect.DeepEqual(got, wantStrict) {
+		t.Fatalf("strictModulePaths() = %q, want %q", got, wantStrict)
+	}
+}
@@ -148,6 +148,7 @@ type pipeContext struct {
 	finalTasks       []TaskSpec         // clean-up tasks that always run when the pipeline ends
 	failTasks        []TaskSpec         // clean-up tasks that run when a pipeline fails
 	finalFailed      []string           // list of task names of final tasks that failed
+	tasksRun         []string           // names of tasks run in the primary pipeline, for run history
 	taskName         string             // name of current task
 	taskDesc         string             // description for same
 	taskType         string             // one of task, plugin, job
@@ -84,6 +84,13 @@ func (l *pipelineLiveLogger) Finalize() {
 	l.base.Finalize()
 }
 
+// setRunInfo passes run metadata to history loggers that keep it.
+func (l *pipelineLiveLogger) setRunInfo(info robot.RunInfo) {
+	if rl, ok := l.base.(robot.RunLogger); ok {
+		rl.SetRunInfo(info)
+	}
+}
+
 func (l *pipelineLiveLogger) Snapshot() io.Reader {
 	return l.live.Snapshot()
 }
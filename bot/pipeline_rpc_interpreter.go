@@ -37,6 +37,7 @@ type pipelineRPCLuaRunRequest struct {
 	TaskPath string            `json:"task_path"`
 	TaskName string            `json:"task_name"`
 	PkgPath  []string          `json:"pkg_path"`
+	Strict   []string          `json:"strict_paths,omitempty"`
 	Bot      map[string]string `json:"bot"`
 	Args     []string          `json:"args"`
 }
@@ -51,6 +52,7 @@ type pipelineRPCLuaGetConfigRequest struct {
 	TaskPath string            `json:"task_path"`
 	TaskName string            `json:"task_name"`
 	PkgPath  []string          `json:"pkg_path"`
+	Strict   []string          `json:"strict_paths,omitempty"`
 	Bot      map[string]string `json:"bot"`
 }
 
@@ -65,6 +67,7 @@ func runLuaExtensionViaRPC(taskPath, taskName, workDir string, pkgPath []string,
 		TaskPath: taskPath,
 		TaskName: taskName,
 		PkgPath:  pkgPath,
+		Strict:   strictModulePaths(pkgPath),
 		Bot:      bot,
 		Args:     args,
 	}
@@ -92,6 +95,7 @@ func runLuaGetConfigViaRPC(taskPath, taskName, workDir string, pkgPath []string,
 		TaskPath: taskPath,
 		TaskName: taskName,
 		PkgPath:  pkgPath,
+		Strict:   strictModulePaths(pkgPath),
 		Bot:      bot,
 	}
 	resRaw, err := runPipelineRPCRequestForRoleInDir("lua_get_config", params, nil, nil, privsepRoleForExecution(privileged), workDir)
@@ -453,7 +457,7 @@ func handlePipelineRPCLuaRun(dec *json.Decoder, enc *json.Encoder, msg pipelineR
 		return writePipelineRPCError(enc, msg.ID, "invalid_params", fmt.Sprintf("invalid lua_run params: %v", err))
 	}
 	client := newPipelineRPCInterpreterRobotClient(dec, enc, req.Bot)
-	ret, err := luamod.CallExtension(req.ExecPath, req.TaskPath, req.TaskName, req.PkgPath, client, req.Bot, client, req.Args)
+	ret, err := luamod.CallExtension(req.ExecPath, req.TaskPath, req.TaskName, req.PkgPath, req.Strict, client, req.Bot, client, req.Args)
 	res := pipelineRPCLuaRunResponse{RetVal: int(ret)}
 	if err != nil {
 		res.Error = err.Error()
@@ -466,7 +470,7 @@ func handlePipelineRPCLuaGetConfig(enc *json.Encoder, msg pipelineRPCMessage) er
 	if err := json.Unmarshal(msg.Params, &req); err != nil {
 		return writePipelineRPCError(enc, msg.ID, "invalid_params", fmt.Sprintf("invalid lua_get_config params: %v", err))
 	}
-	cfg, err := luamod.GetPluginConfig(req.ExecPath, req.TaskPath, req.TaskName, req.Bot, req.PkgPath)
+	cfg, err := luamod.GetPluginConfig(req.ExecPath, req.TaskPath, req.TaskName, req.Bot, req.PkgPath, req.Strict)
 	res := pipelineRPCLuaGetConfigResponse{}
 	if err != nil {
 		res.Error = err.Error()
@@ -613,6 +617,24 @@ func handlePipelineRPCRobotCall(paramsRaw json.RawMessage, base robot.Robot) (ma
 			return nil, err
 		}
 		return map[string]interface{}{"ret_val": int(r.UnlinkIdentity(provider, user))}, nil
+	case "PublishQueueMessage":
+		provider, err := pipelineRPCArgString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		topic, err := pipelineRPCArgString(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		body, err := pipelineRPCArgString(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		attributes, err := pipelineRPCArgStringMap(args, 3)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"ret_val": int(r.PublishQueueMessage(provider, topic, body, attributes))}, nil
 	case "Email":
 		subject, err := pipelineRPCArgString(args, 0)
 		if err != nil {
@@ -1340,6 +1362,14 @@ func (c *pipelineRPCInterpreterRobotClient) UnlinkIdentity(provider, user string
 	return robot.RetVal(pipelineRPCMapInt(res, "ret_val"))
 }
 
+func (c *pipelineRPCInterpreterRobotClient) PublishQueueMessage(provider, topic, body string, attributes map[string]string) robot.RetVal {
+	res, err := c.call("PublishQueueMessage", provider, topic, body, attributes)
+	if err != nil {
+		return robot.Failed
+	}
+	return robot.RetVal(pipelineRPCMapInt(res, "ret_val"))
+}
+
 func (c *pipelineRPCInterpreterRobotClient) Exclusive(tag string, queueTask bool) bool {
 	res, err := c.call("Exclusive", tag, queueTask)
 	if err != nil {
@@ -1797,6 +1827,26 @@ func pipelineRPCArgStringSlice(args []interface{}, idx int) ([]string, error) {
 	return out, nil
 }
 
+// pipelineRPCArgStringMap accepts a missing or null argument as a nil map.
+func pipelineRPCArgStringMap(args []interface{}, idx int) (map[string]string, error) {
+	if idx >= len(args) || args[idx] == nil {
+		return nil, nil
+	}
+	rawMap, ok := args[idx].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("argument %d is not a string map", idx)
+	}
+	out := make(map[string]string, len(rawMap))
+	for k, e := range rawMap {
+		s, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %d has non-string value for key %q", idx, k)
+		}
+		out[k] = s
+	}
+	return out, nil
+}
+
 func pipelineRPCArgTailStrings(args []interface{}, start int) ([]string, error) {
 	if start >= len(args) {
 		return []string{}, nil
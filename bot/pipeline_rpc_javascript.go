@@ -14,6 +14,7 @@ type pipelineRPCJSRunRequest struct {
 	TaskPath     string            `json:"task_path"`
 	TaskName     string            `json:"task_name"`
 	RequirePaths []string          `json:"require_paths"`
+	StrictPaths  []string          `json:"strict_paths,omitempty"`
 	Bot          map[string]string `json:"bot"`
 	Args         []string          `json:"args"`
 }
@@ -28,6 +29,7 @@ type pipelineRPCJSGetConfigRequest struct {
 	TaskPath     string            `json:"task_path"`
 	TaskName     string            `json:"task_name"`
 	RequirePaths []string          `json:"require_paths"`
+	StrictPaths  []string          `json:"strict_paths,omitempty"`
 	Bot          map[string]string `json:"bot"`
 }
 
@@ -42,6 +44,7 @@ func runJSExtensionViaRPC(taskPath, taskName, workDir string, requirePaths []str
 		TaskPath:     taskPath,
 		TaskName:     taskName,
 		RequirePaths: requirePaths,
+		StrictPaths:  strictModulePaths(requirePaths),
 		Bot:          bot,
 		Args:         args,
 	}
@@ -69,6 +72,7 @@ func runJSGetConfigViaRPC(taskPath, taskName, workDir string, requirePaths []str
 		TaskPath:     taskPath,
 		TaskName:     taskName,
 		RequirePaths: requirePaths,
+		StrictPaths:  strictModulePaths(requirePaths),
 		Bot:          bot,
 	}
 	resRaw, err := runPipelineRPCRequestForRoleInDir("js_get_config", params, nil, nil, privsepRoleForExecution(privileged), workDir)
@@ -92,7 +96,7 @@ func handlePipelineRPCJSRun(dec *json.Decoder, enc *json.Encoder, msg pipelineRP
 		return writePipelineRPCError(enc, msg.ID, "invalid_params", fmt.Sprintf("invalid js_run params: %v", err))
 	}
 	client := newPipelineRPCJSRobotClient(dec, enc, req.Bot)
-	ret, err := jsmod.CallExtension(req.ExecPath, req.TaskPath, req.TaskName, req.RequirePaths, client, req.Bot, client, req.Args)
+	ret, err := jsmod.CallExtension(req.ExecPath, req.TaskPath, req.TaskName, req.RequirePaths, req.StrictPaths, client, req.Bot, client, req.Args)
 	res := pipelineRPCJSRunResponse{RetVal: int(ret)}
 	if err != nil {
 		res.Error = err.Error()
@@ -105,7 +109,7 @@ func handlePipelineRPCJSGetConfig(enc *json.Encoder, msg pipelineRPCMessage) err
 	if err := json.Unmarshal(msg.Params, &req); err != nil {
 		return writePipelineRPCError(enc, msg.ID, "invalid_params", fmt.Sprintf("invalid js_get_config params: %v", err))
 	}
-	cfg, err := jsmod.GetPluginConfig(req.ExecPath, req.TaskPath, req.TaskName, req.Bot, req.RequirePaths)
+	cfg, err := jsmod.GetPluginConfig(req.ExecPath, req.TaskPath, req.TaskName, req.Bot, req.RequirePaths, req.StrictPaths)
 	res := pipelineRPCJSGetConfigResponse{}
 	if err != nil {
 		res.Error = err.Error()
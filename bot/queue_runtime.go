@@ -1,9 +1,11 @@
 package bot
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -20,10 +22,24 @@ const queueTimestampMaxLen = 15
 
 const queueDedupeRetention = 140 * time.Second
 
+// queueParameterRe matches parameter names that are also usable as
+// environment variable names.
+var queueParameterRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 type parsedQueueBody struct {
-	jobUUID   string
-	timestamp string
-	args      []string
+	jobUUID    string
+	timestamp  string
+	args       []string
+	attributes map[string]string
+}
+
+// queueJSONBody is the structured alternative to the
+// "<uuid>:<timestamp> [args]" text body.
+type queueJSONBody struct {
+	UUID       string            `json:"uuid"`
+	Timestamp  json.Number       `json:"timestamp"`
+	Args       []string          `json:"args"`
+	Attributes map[string]string `json:"attributes"`
 }
 
 type queueHandler struct {
@@ -38,7 +54,25 @@ type managedQueueProvider struct {
 	done      chan struct{}
 	running   bool
 	stopping  bool
+	failed    bool // start failed or Run exited unexpectedly
 	lastError string
+	// Message statistics survive stop/start and reload, and reset when
+	// the robot restarts.
+	handled     uint64
+	retries     uint64
+	dedupeHits  uint64
+	lastMessage time.Time
+}
+
+// queueProviderStatus is a snapshot of a queue provider for admin commands.
+type queueProviderStatus struct {
+	provider    string
+	state       string
+	err         string
+	handled     uint64
+	retries     uint64
+	dedupeHits  uint64
+	lastMessage time.Time
 }
 
 var runtimeQueueProviders = struct {
@@ -65,15 +99,22 @@ func (h queueHandler) GetQueueConfig(v interface{}) error {
 }
 
 func (h queueHandler) HandleQueueMessage(msg robot.QueueMessage) robot.QueueDisposition {
-	return triggerJobFromQueue(h.provider, msg)
+	disposition := triggerJobFromQueue(h.provider, msg)
+	recordQueueMessage(h.provider, disposition, time.Now())
+	return disposition
 }
 
+// Log also records provider errors, so a provider that is failing quietly,
+// e.g. reconnecting in a loop, shows its last error in queue-list.
 func (h queueHandler) Log(l robot.LogLevel, m string, v ...interface{}) {
 	p := normalizeProviderName(h.provider)
 	if p == "" {
 		h.handler.Log(l, m, v...)
 		return
 	}
+	if l == robot.Error {
+		recordQueueProviderError(p, fmt.Errorf(m, v...))
+	}
 	m = "[queue:" + p + "] " + m
 	h.handler.Log(l, m, v...)
 }
@@ -121,7 +162,7 @@ func startQueueProviderRuntime(provider string, logger *log.Logger) error {
 	registration, ok := queueProviderRegistration(name)
 	if !ok {
 		err := fmt.Errorf("no queue provider registered with name '%s'", name)
-		recordQueueProviderError(name, err)
+		recordQueueProviderFailure(name, err)
 		return err
 	}
 	initialized, err := registration.Initialize(queueHandler{
@@ -129,12 +170,12 @@ func startQueueProviderRuntime(provider string, logger *log.Logger) error {
 		provider: name,
 	}, logger)
 	if err != nil {
-		recordQueueProviderError(name, err)
+		recordQueueProviderFailure(name, err)
 		return err
 	}
 	if initialized.Provider == nil {
 		err := fmt.Errorf("queue provider '%s' returned nil from initializer", name)
-		recordQueueProviderError(name, err)
+		recordQueueProviderFailure(name, err)
 		return err
 	}
 
@@ -151,6 +192,7 @@ func startQueueProviderRuntime(provider string, logger *log.Logger) error {
 	mq.done = done
 	mq.running = true
 	mq.stopping = false
+	mq.failed = false
 	mq.lastError = ""
 	runtimeQueueProviders.Unlock()
 
@@ -164,7 +206,12 @@ func startQueueProviderRuntime(provider string, logger *log.Logger) error {
 			mq.running = false
 			mq.stopping = false
 			if shouldLogError && !state.shuttingDown {
-				mq.lastError = "queue provider exited"
+				mq.failed = true
+				if mq.lastError != "" {
+					mq.lastError = "queue provider exited after error: " + mq.lastError
+				} else {
+					mq.lastError = "queue provider exited"
+				}
 			}
 		}
 		runtimeQueueProviders.Unlock()
@@ -179,7 +226,19 @@ func startQueueProviderRuntime(provider string, logger *log.Logger) error {
 	return nil
 }
 
+// recordQueueProviderError keeps the provider's last logged error for
+// queue-list; it doesn't change the provider's state, since providers also
+// log errors about single messages.
 func recordQueueProviderError(provider string, err error) {
+	setQueueProviderError(provider, err, false)
+}
+
+// recordQueueProviderFailure records a provider that couldn't be started.
+func recordQueueProviderFailure(provider string, err error) {
+	setQueueProviderError(provider, err, true)
+}
+
+func setQueueProviderError(provider string, err error, failed bool) {
 	name := normalizeProviderName(provider)
 	if name == "" || err == nil {
 		return
@@ -191,9 +250,120 @@ func recordQueueProviderError(provider string, err error) {
 		runtimeQueueProviders.runtimes[name] = mq
 	}
 	mq.lastError = err.Error()
+	if failed {
+		mq.failed = true
+	}
 	runtimeQueueProviders.Unlock()
 }
 
+func recordQueueMessage(provider string, disposition robot.QueueDisposition, now time.Time) {
+	runtimeQueueProviders.Lock()
+	defer runtimeQueueProviders.Unlock()
+	mq, ok := runtimeQueueProviders.runtimes[normalizeProviderName(provider)]
+	if !ok || mq == nil {
+		return
+	}
+	mq.handled++
+	if disposition == robot.QueueRetry {
+		mq.retries++
+	}
+	mq.lastMessage = now
+}
+
+func recordQueueDedupeHit(provider string) {
+	runtimeQueueProviders.Lock()
+	defer runtimeQueueProviders.Unlock()
+	if mq, ok := runtimeQueueProviders.runtimes[normalizeProviderName(provider)]; ok && mq != nil {
+		mq.dedupeHits++
+	}
+}
+
+// listQueueProviderStatus reports configured queue providers, plus any
+// still running from before a reload.
+func listQueueProviderStatus() []queueProviderStatus {
+	providers := make(map[string]bool)
+	for _, provider := range configuredQueueProviders() {
+		providers[normalizeProviderName(provider)] = true
+	}
+
+	runtimeQueueProviders.RLock()
+	defer runtimeQueueProviders.RUnlock()
+	for provider := range runtimeQueueProviders.runtimes {
+		providers[provider] = true
+	}
+	keys := make([]string, 0, len(providers))
+	for provider := range providers {
+		keys = append(keys, provider)
+	}
+	sort.Strings(keys)
+
+	out := make([]queueProviderStatus, 0, len(keys))
+	for _, provider := range keys {
+		status := queueProviderStatus{
+			provider: provider,
+			state:    "stopped",
+		}
+		mq, ok := runtimeQueueProviders.runtimes[provider]
+		if !ok || mq == nil {
+			status.state = "failed"
+			status.err = "not initialized"
+			out = append(out, status)
+			continue
+		}
+		switch {
+		case mq.stopping:
+			status.state = "stopping"
+		case mq.running:
+			status.state = "running"
+		case mq.failed:
+			status.state = "failed"
+		}
+		status.err = mq.lastError
+		status.handled = mq.handled
+		status.retries = mq.retries
+		status.dedupeHits = mq.dedupeHits
+		status.lastMessage = mq.lastMessage
+		out = append(out, status)
+	}
+	return out
+}
+
+func configuredQueueProvider(provider string) (string, error) {
+	name := normalizeProviderName(provider)
+	if name == "" {
+		return "", fmt.Errorf("queue provider name is required")
+	}
+	for _, configured := range configuredQueueProviders() {
+		if normalizeProviderName(configured) == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("queue provider '%s' is not configured in QueueProviders", name)
+}
+
+func startConfiguredQueueProvider(provider string) error {
+	name, err := configuredQueueProvider(provider)
+	if err != nil {
+		return err
+	}
+	return startQueueProviderRuntime(name, botLogger.logger)
+}
+
+func stopConfiguredQueueProvider(provider string) error {
+	name, err := configuredQueueProvider(provider)
+	if err != nil {
+		return err
+	}
+	return stopQueueProviderRuntime(name)
+}
+
+func restartConfiguredQueueProvider(provider string) error {
+	if err := stopConfiguredQueueProvider(provider); err != nil {
+		return err
+	}
+	return startConfiguredQueueProvider(provider)
+}
+
 func stopQueueProviderRuntime(provider string) error {
 	name := normalizeProviderName(provider)
 	if name == "" {
@@ -258,9 +428,11 @@ func reconcileQueueProviderRuntimes(providers []string) {
 
 	for _, provider := range current {
 		_ = stopQueueProviderRuntime(provider)
-		runtimeQueueProviders.Lock()
-		delete(runtimeQueueProviders.runtimes, provider)
-		runtimeQueueProviders.Unlock()
+		if !desired[provider] {
+			runtimeQueueProviders.Lock()
+			delete(runtimeQueueProviders.runtimes, provider)
+			runtimeQueueProviders.Unlock()
+		}
 	}
 	for provider := range desired {
 		if err := startQueueProviderRuntime(provider, botLogger.logger); err != nil {
@@ -269,7 +441,46 @@ func reconcileQueueProviderRuntimes(providers []string) {
 	}
 }
 
+// runningQueuePublisher returns the named provider if it's running and
+// implements robot.QueuePublisher.
+func runningQueuePublisher(provider string) (robot.QueuePublisher, bool) {
+	runtimeQueueProviders.RLock()
+	defer runtimeQueueProviders.RUnlock()
+	mq, ok := runtimeQueueProviders.runtimes[provider]
+	if !ok || mq == nil || !mq.running || mq.stopping {
+		return nil, false
+	}
+	publisher, ok := mq.provider.(robot.QueuePublisher)
+	return publisher, ok
+}
+
+// see robot/robot.go
+func (r Robot) PublishQueueMessage(provider, topic, body string, attributes map[string]string) robot.RetVal {
+	name := normalizeProviderName(provider)
+	topic = strings.TrimSpace(topic)
+	if name == "" || topic == "" {
+		r.Log(robot.Error, "PublishQueueMessage called without a provider and topic")
+		return robot.MissingArguments
+	}
+	publisher, ok := runningQueuePublisher(name)
+	if !ok {
+		r.Log(robot.Error, "PublishQueueMessage: queue provider '%s' is not running or does not support publishing", name)
+		return robot.QueueProviderNotFound
+	}
+	// Like inbound messages, bodies may carry job arguments; only the
+	// length is logged.
+	if err := publisher.Publish(topic, []byte(body), attributes); err != nil {
+		r.Log(robot.Error, "Publishing to topic '%s' on queue provider '%s' failed: %v (body length %d)", topic, name, err, len(body))
+		return robot.QueuePublishFailed
+	}
+	r.Log(robot.Debug, "Published message to topic '%s' on queue provider '%s' (body length %d)", topic, name, len(body))
+	return robot.Ok
+}
+
 func parseQueueBody(body []byte) (parsedQueueBody, error) {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseQueueJSONBody(trimmed)
+	}
 	if len(body) < queueUUIDPrefixLen {
 		return parsedQueueBody{}, fmt.Errorf("queue body too short: %d byte(s)", len(body))
 	}
@@ -317,6 +528,77 @@ func parseQueueBody(body []byte) (parsedQueueBody, error) {
 	}, nil
 }
 
+// parseQueueJSONBody parses a JSON object body with "uuid", "timestamp",
+// optional "args" and optional string "attributes"; the timestamp may be a
+// JSON number or a string of digits.
+func parseQueueJSONBody(body []byte) (parsedQueueBody, error) {
+	var jb queueJSONBody
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&jb); err != nil {
+		return parsedQueueBody{}, fmt.Errorf("parsing JSON queue body: %w", err)
+	}
+	if dec.More() {
+		return parsedQueueBody{}, fmt.Errorf("parsing JSON queue body: trailing data after object")
+	}
+	id, err := uuid.Parse(jb.UUID)
+	if err != nil || len(jb.UUID) != queueUUIDPrefixLen {
+		return parsedQueueBody{}, fmt.Errorf("invalid JSON queue body uuid")
+	}
+	timestamp := jb.Timestamp.String()
+	if len(timestamp) < queueTimestampMinLen || len(timestamp) > queueTimestampMaxLen || strings.Trim(timestamp, "0123456789") != "" {
+		return parsedQueueBody{}, fmt.Errorf("queue timestamp must be %d-%d digits", queueTimestampMinLen, queueTimestampMaxLen)
+	}
+	return parsedQueueBody{
+		jobUUID:    id.String(),
+		timestamp:  timestamp,
+		args:       jb.Args,
+		attributes: jb.Attributes,
+	}, nil
+}
+
+// normalizeQueueParameters validates a job's QueueParameters, mapping queue
+// message attribute names to pipeline parameter names. GOPHER_* names are
+// reserved for the engine.
+func normalizeQueueParameters(in map[string]string) (map[string]string, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(in))
+	for attr, param := range in {
+		attr = strings.TrimSpace(attr)
+		param = strings.TrimSpace(param)
+		if attr == "" {
+			return nil, fmt.Errorf("empty queue attribute name")
+		}
+		if !queueParameterRe.MatchString(param) {
+			return nil, fmt.Errorf("invalid parameter name '%s' for queue attribute '%s'", param, attr)
+		}
+		if strings.HasPrefix(strings.ToUpper(param), "GOPHER_") {
+			return nil, fmt.Errorf("parameter name '%s' for queue attribute '%s' uses the reserved GOPHER_ prefix", param, attr)
+		}
+		out[attr] = param
+	}
+	return out, nil
+}
+
+// queueJobParameters selects the allow-listed attributes for a job. Attributes
+// from the transport take precedence over those in a JSON body.
+func queueJobParameters(allowed map[string]string, transport, body map[string]string) map[string]string {
+	if len(allowed) == 0 {
+		return nil
+	}
+	params := make(map[string]string, len(allowed))
+	for attr, param := range allowed {
+		if value, ok := transport[attr]; ok {
+			params[param] = value
+		} else if value, ok := body[attr]; ok {
+			params[param] = value
+		}
+	}
+	return params
+}
+
 func recordQueueDedupe(jobUUID, timestamp string, now time.Time) bool {
 	key := jobUUID + ":" + timestamp
 	queueDedupe.Lock()
@@ -368,6 +650,7 @@ func triggerJobFromQueue(provider string, msg robot.QueueMessage) robot.QueueDis
 			jobName = task.name
 		}
 		Log(robot.Info, "Queue provider '%s' message '%s' discarded duplicate queue trigger for job '%s'", provider, msg.ID, jobName)
+		recordQueueDedupeHit(provider)
 		return robot.QueueAck
 	}
 	if job == nil {
@@ -389,17 +672,19 @@ func triggerJobFromQueue(provider string, msg robot.QueueMessage) robot.QueueDis
 		}
 	}
 
+	params := queueJobParameters(job.QueueParameters, msg.Attributes, parsed.attributes)
 	Log(robot.Info, "Job '%s' triggered from queue provider '%s'", task.name, provider)
 	w := &worker{
-		Channel:        task.Channel,
-		Protocol:       getProtocol(protocol),
-		Incoming:       &robot.ConnectorMessage{Protocol: protocol},
-		cfg:            cfg,
-		id:             getWorkerID(),
-		tasks:          tasks,
-		automaticTask:  true,
-		queueProvider:  provider,
-		queueMessageID: msg.ID,
+		Channel:         task.Channel,
+		Protocol:        getProtocol(protocol),
+		Incoming:        &robot.ConnectorMessage{Protocol: protocol},
+		cfg:             cfg,
+		id:              getWorkerID(),
+		tasks:           tasks,
+		automaticTask:   true,
+		queueProvider:   provider,
+		queueMessageID:  msg.ID,
+		queueParameters: params,
 	}
 	go w.startPipeline(nil, taskItem, queuedJob, "run", parsed.args...)
 	return robot.QueueAck
@@ -1,10 +1,14 @@
 package bot
 
 import (
+	"errors"
+	"log"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
 )
 
 func TestParseQueueBodyNoArgs(t *testing.T) {
@@ -64,6 +68,89 @@ func TestParseQueueBodyRejectsMalformedBody(t *testing.T) {
 	}
 }
 
+func TestParseQueueBodyJSON(t *testing.T) {
+	parsed, err := parseQueueBody([]byte(` {"uuid": "1104df4c-feeb-43ab-8c85-83663288cea9", "timestamp": 17642656976077, "args": ["alpha", "two words"], "attributes": {"commit": "abc123"}}`))
+	if err != nil {
+		t.Fatalf("parseQueueBody returned error: %v", err)
+	}
+	if parsed.jobUUID != "1104df4c-feeb-43ab-8c85-83663288cea9" || parsed.timestamp != "17642656976077" {
+		t.Fatalf("id/timestamp = %q/%q", parsed.jobUUID, parsed.timestamp)
+	}
+	if !reflect.DeepEqual(parsed.args, []string{"alpha", "two words"}) {
+		t.Fatalf("args = %#v", parsed.args)
+	}
+	if parsed.attributes["commit"] != "abc123" {
+		t.Fatalf("attributes = %#v", parsed.attributes)
+	}
+
+	parsed, err = parseQueueBody([]byte(`{"uuid": "1104df4c-feeb-43ab-8c85-83663288cea9", "timestamp": "17642656976077"}`))
+	if err != nil {
+		t.Fatalf("parseQueueBody with string timestamp returned error: %v", err)
+	}
+	if parsed.timestamp != "17642656976077" || len(parsed.args) != 0 {
+		t.Fatalf("timestamp/args = %q/%#v", parsed.timestamp, parsed.args)
+	}
+}
+
+func TestParseQueueBodyRejectsMalformedJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		errPart string
+	}{
+		{name: "invalid json", body: `{"uuid": `, errPart: "parsing JSON queue body"},
+		{name: "unknown field", body: `{"uuid": "1104df4c-feeb-43ab-8c85-83663288cea9", "timestamp": 17642656976077, "command": "rm"}`, errPart: "unknown field"},
+		{name: "trailing data", body: `{"uuid": "1104df4c-feeb-43ab-8c85-83663288cea9", "timestamp": 17642656976077} {}`, errPart: "trailing data"},
+		{name: "invalid uuid", body: `{"uuid": "1104df4cfeeb43ab8c8583663288cea9", "timestamp": 17642656976077}`, errPart: "invalid JSON queue body uuid"},
+		{name: "fractional timestamp", body: `{"uuid": "1104df4c-feeb-43ab-8c85-83663288cea9", "timestamp": 1764265697.6077}`, errPart: "timestamp must be 12-15 digits"},
+		{name: "non-string attribute", body: `{"uuid": "1104df4c-feeb-43ab-8c85-83663288cea9", "timestamp": 17642656976077, "attributes": {"n": 1}}`, errPart: "parsing JSON queue body"},
+	}
+	for _, tc := range tests {
+		_, err := parseQueueBody([]byte(tc.body))
+		if err == nil {
+			t.Fatalf("%s: parseQueueBody returned nil error", tc.name)
+		}
+		if !strings.Contains(err.Error(), tc.errPart) {
+			t.Fatalf("%s: parseQueueBody error = %q, want substring %q", tc.name, err, tc.errPart)
+		}
+	}
+}
+
+func TestNormalizeQueueParameters(t *testing.T) {
+	got, err := normalizeQueueParameters(map[string]string{" commit ": " COMMIT_SHA "})
+	if err != nil {
+		t.Fatalf("normalizeQueueParameters returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, map[string]string{"commit": "COMMIT_SHA"}) {
+		t.Fatalf("normalizeQueueParameters = %#v", got)
+	}
+	for _, in := range []map[string]string{
+		{"": "COMMIT_SHA"},
+		{"commit": "COMMIT-SHA"},
+		{"commit": "1COMMIT"},
+		{"user": "GOPHER_USER"},
+		{"user": "gopher_user"},
+	} {
+		if _, err := normalizeQueueParameters(in); err == nil {
+			t.Fatalf("normalizeQueueParameters(%#v) returned nil error", in)
+		}
+	}
+}
+
+func TestQueueJobParametersUsesAllowListAndTransportPrecedence(t *testing.T) {
+	allowed := map[string]string{"commit": "COMMIT_SHA", "env": "DEPLOY_ENV", "requester": "REQUESTER"}
+	transport := map[string]string{"commit": "abc123", "amqp.routing_key": "deploy.prod"}
+	body := map[string]string{"commit": "ignored", "env": "prod", "secret": "nope"}
+	got := queueJobParameters(allowed, transport, body)
+	want := map[string]string{"COMMIT_SHA": "abc123", "DEPLOY_ENV": "prod"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("queueJobParameters = %#v, want %#v", got, want)
+	}
+	if got := queueJobParameters(nil, transport, body); got != nil {
+		t.Fatalf("queueJobParameters without allow-list = %#v, want nil", got)
+	}
+}
+
 func TestQueueDedupeRecordsUUIDTimestampPairs(t *testing.T) {
 	queueDedupe.Lock()
 	queueDedupe.seen = map[string]time.Time{}
@@ -94,3 +181,195 @@ func TestQueueDedupeExpiresAfterRetention(t *testing.T) {
 		t.Fatal("expired UUID/timestamp pair was reported as duplicate")
 	}
 }
+
+type testQueueProvider struct{}
+
+func (testQueueProvider) Run(<-chan struct{}) {}
+
+type testQueuePublisher struct {
+	testQueueProvider
+	topic string
+	body  string
+	attrs map[string]string
+	err   error
+}
+
+func (p *testQueuePublisher) Publish(topic string, body []byte, attributes map[string]string) error {
+	p.topic = topic
+	p.body = string(body)
+	p.attrs = attributes
+	return p.err
+}
+
+func TestPublishQueueMessage(t *testing.T) {
+	publisher := &testQueuePublisher{}
+	runtimeQueueProviders.Lock()
+	saved := runtimeQueueProviders.runtimes
+	runtimeQueueProviders.runtimes = map[string]*managedQueueProvider{
+		"pub":      {name: "pub", provider: publisher, running: true},
+		"receiver": {name: "receiver", provider: testQueueProvider{}, running: true},
+		"stopped":  {name: "stopped", provider: &testQueuePublisher{}},
+	}
+	runtimeQueueProviders.Unlock()
+	t.Cleanup(func() {
+		runtimeQueueProviders.Lock()
+		runtimeQueueProviders.runtimes = saved
+		runtimeQueueProviders.Unlock()
+	})
+
+	r := Robot{}
+	if ret := r.PublishQueueMessage(" Pub ", " deploys ", "body", map[string]string{"env": "prod"}); ret != robot.Ok {
+		t.Fatalf("PublishQueueMessage() = %s, want Ok", ret)
+	}
+	if publisher.topic != "deploys" || publisher.body != "body" || publisher.attrs["env"] != "prod" {
+		t.Fatalf("published %q %q %v, want deploys body map[env:prod]", publisher.topic, publisher.body, publisher.attrs)
+	}
+	if ret := r.PublishQueueMessage("pub", "", "body", nil); ret != robot.MissingArguments {
+		t.Fatalf("PublishQueueMessage() without topic = %s, want MissingArguments", ret)
+	}
+	for _, provider := range []string{"receiver", "stopped", "missing"} {
+		if ret := r.PublishQueueMessage(provider, "deploys", "body", nil); ret != robot.QueueProviderNotFound {
+			t.Fatalf("PublishQueueMessage(%q) = %s, want QueueProviderNotFound", provider, ret)
+		}
+	}
+	publisher.err = errors.New("topic not allowed")
+	if ret := r.PublishQueueMessage("pub", "other", "body", nil); ret != robot.QueuePublishFailed {
+		t.Fatalf("PublishQueueMessage() with failing provider = %s, want QueuePublishFailed", ret)
+	}
+}
+
+type blockingQueueProvider struct{}
+
+func (blockingQueueProvider) Run(stop <-chan struct{}) { <-stop }
+
+type exitingQueueProvider struct{}
+
+func (exitingQueueProvider) Run(<-chan struct{}) {}
+
+func TestQueueProviderAdminLifecycleAndStats(t *testing.T) {
+	currentCfg.Lock()
+	savedCfg := currentCfg.configuration
+	currentCfg.configuration = &configuration{queueProviders: []string{"fake"}}
+	currentCfg.Unlock()
+	runtimeQueueProviders.Lock()
+	savedRuntimes := runtimeQueueProviders.runtimes
+	runtimeQueueProviders.runtimes = map[string]*managedQueueProvider{}
+	runtimeQueueProviders.Unlock()
+	queueProviderRegistrationOverrides["fake"] = robot.QueueProviderRegistration{
+		Initialize: func(robot.QueueHandler, *log.Logger) (robot.InitializedQueueProvider, error) {
+			return robot.InitializedQueueProvider{Provider: blockingQueueProvider{}}, nil
+		},
+	}
+	t.Cleanup(func() {
+		shutdownQueueProviderRuntimes()
+		delete(queueProviderRegistrationOverrides, "fake")
+		runtimeQueueProviders.Lock()
+		runtimeQueueProviders.runtimes = savedRuntimes
+		runtimeQueueProviders.Unlock()
+		currentCfg.Lock()
+		currentCfg.configuration = savedCfg
+		currentCfg.Unlock()
+	})
+	statusOf := func() queueProviderStatus {
+		statuses := listQueueProviderStatus()
+		if len(statuses) != 1 {
+			t.Fatalf("listQueueProviderStatus() = %+v, want one provider", statuses)
+		}
+		return statuses[0]
+	}
+
+	if got := statusOf(); got.state != "failed" || got.err != "not initialized" {
+		t.Fatalf("status before start = %+v, want not initialized", got)
+	}
+	if err := startConfiguredQueueProvider("unknown"); err == nil {
+		t.Fatal("startConfiguredQueueProvider accepted a provider missing from QueueProviders")
+	}
+	if err := startConfiguredQueueProvider(" Fake "); err != nil {
+		t.Fatalf("startConfiguredQueueProvider() error = %v", err)
+	}
+	if got := statusOf(); got.state != "running" {
+		t.Fatalf("status after start = %+v, want running", got)
+	}
+
+	qh := queueHandler{handler: handle, provider: "fake"}
+	if disposition := qh.HandleQueueMessage(robot.QueueMessage{ID: "m-1", Body: []byte("malformed")}); disposition != robot.QueueAck {
+		t.Fatalf("HandleQueueMessage() = %v, want QueueAck", disposition)
+	}
+	now := time.Unix(300, 0)
+	recordQueueMessage("fake", robot.QueueRetry, now)
+	recordQueueDedupeHit("fake")
+	qh.Log(robot.Error, "subscription %s not found", "job-triggers-pull")
+	got := statusOf()
+	if got.handled != 2 || got.retries != 1 || got.dedupeHits != 1 || !got.lastMessage.Equal(now) {
+		t.Fatalf("stats = %+v, want handled 2, retries 1, duplicates 1, last message %s", got, now)
+	}
+	if got.err != "subscription job-triggers-pull not found" {
+		t.Fatalf("last error = %q", got.err)
+	}
+
+	if err := stopConfiguredQueueProvider("fake"); err != nil {
+		t.Fatalf("stopConfiguredQueueProvider() error = %v", err)
+	}
+	if got := statusOf(); got.state != "stopped" || got.err != "subscription job-triggers-pull not found" || got.handled != 2 {
+		t.Fatalf("status after stop = %+v, want stopped with last error and stats kept", got)
+	}
+	if err := restartConfiguredQueueProvider("fake"); err != nil {
+		t.Fatalf("restartConfiguredQueueProvider() error = %v", err)
+	}
+	if got := statusOf(); got.state != "running" || got.err != "" || got.handled != 2 {
+		t.Fatalf("status after restart = %+v, want running with stats kept", got)
+	}
+}
+
+func TestQueueProviderUnexpectedExitReportsFailed(t *testing.T) {
+	currentCfg.Lock()
+	savedCfg := currentCfg.configuration
+	currentCfg.configuration = &configuration{queueProviders: []string{"crashing", "broken"}}
+	currentCfg.Unlock()
+	runtimeQueueProviders.Lock()
+	savedRuntimes := runtimeQueueProviders.runtimes
+	runtimeQueueProviders.runtimes = map[string]*managedQueueProvider{}
+	runtimeQueueProviders.Unlock()
+	queueProviderRegistrationOverrides["crashing"] = robot.QueueProviderRegistration{
+		Initialize: func(robot.QueueHandler, *log.Logger) (robot.InitializedQueueProvider, error) {
+			return robot.InitializedQueueProvider{Provider: exitingQueueProvider{}}, nil
+		},
+	}
+	queueProviderRegistrationOverrides["broken"] = robot.QueueProviderRegistration{
+		Initialize: func(robot.QueueHandler, *log.Logger) (robot.InitializedQueueProvider, error) {
+			return robot.InitializedQueueProvider{}, errors.New("bad QueueConfig")
+		},
+	}
+	t.Cleanup(func() {
+		delete(queueProviderRegistrationOverrides, "crashing")
+		delete(queueProviderRegistrationOverrides, "broken")
+		runtimeQueueProviders.Lock()
+		runtimeQueueProviders.runtimes = savedRuntimes
+		runtimeQueueProviders.Unlock()
+		currentCfg.Lock()
+		currentCfg.configuration = savedCfg
+		currentCfg.Unlock()
+	})
+
+	if err := startQueueProviderRuntime("crashing", nil); err != nil {
+		t.Fatalf("startQueueProviderRuntime() error = %v", err)
+	}
+	runtimeQueueProviders.RLock()
+	done := runtimeQueueProviders.runtimes["crashing"].done
+	runtimeQueueProviders.RUnlock()
+	<-done
+	if err := startQueueProviderRuntime("broken", nil); err == nil {
+		t.Fatal("startQueueProviderRuntime() accepted a failing initializer")
+	}
+
+	states := map[string]queueProviderStatus{}
+	for _, status := range listQueueProviderStatus() {
+		states[status.provider] = status
+	}
+	if got := states["crashing"]; got.state != "failed" || got.err != "queue provider exited" {
+		t.Fatalf("status after Run returned = %+v, want failed", got)
+	}
+	if got := states["broken"]; got.state != "failed" || got.err != "bad QueueConfig" {
+		t.Fatalf("status after failed start = %+v, want failed", got)
+	}
+}
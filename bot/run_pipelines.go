@@ -160,6 +160,12 @@ func (w *worker) startPipeline(parent *worker, t interface{}, ptype pipelineType
 			c.environment["GOPHER_QUEUE_MESSAGE_ID"] = w.queueMessageID
 			c.parameters["GOPHER_QUEUE_PROVIDER"] = w.queueProvider
 			c.parameters["GOPHER_QUEUE_MESSAGE_ID"] = w.queueMessageID
+			// Mapped queue attributes override job Parameters of the
+			// same name, which serve as defaults.
+			for name, value := range w.queueParameters {
+				c.environment[name] = value
+				c.parameters[name] = value
+			}
 		}
 		// To change the channel to the job channel, we need to clear the ProcotolChannel
 		w.Channel = task.Channel
@@ -198,6 +204,17 @@ func (w *worker) startPipeline(parent *worker, t interface{}, ptype pipelineType
 		}
 	}
 	w.Unlock()
+	runInfo := robot.RunInfo{
+		Tag:     c.pipeName,
+		Index:   idx,
+		Ref:     ref,
+		User:    w.User,
+		Channel: initChannel,
+		Trigger: psSourceLabel(ptype, parent != nil),
+		Args:    args,
+		Started: c.startedAt,
+	}
+	pipeHistory.setRunInfo(runInfo)
 	w.startPipelineWatchdog(watchdogPhasePrimary, c.startedAt)
 	if isJob && (!job.Quiet || c.verbose || ptype == jobCommand) {
 		r := w.makeRobot()
@@ -311,6 +328,10 @@ func (w *worker) startPipeline(parent *worker, t interface{}, ptype pipelineType
 			}
 		}
 	}
+	runInfo.Tasks = c.tasksRun
+	runInfo.Finished = time.Now()
+	runInfo.Status = ret
+	pipeHistory.setRunInfo(runInfo)
 	// Release logs that shouldn't be saved
 	c.logger.Finalize()
 
@@ -483,10 +504,13 @@ func (w *worker) runPipeline(stage pipeStage, ptype pipelineType, initialRun boo
 				emit(JobTaskRan)
 			}
 		}
-		if w.stage == primaryTasks && i == 0 {
-			w.Lock()
-			w.executedPrimaryTask = true
-			w.Unlock()
+		if w.stage == primaryTasks {
+			if i == 0 {
+				w.Lock()
+				w.executedPrimaryTask = true
+				w.Unlock()
+			}
+			w.tasksRun = append(w.tasksRun, task.name)
 		}
 		if isJob && i != 0 {
 			child := w.clone()
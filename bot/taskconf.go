@@ -310,6 +310,7 @@ LoadLoop:
 			var intval int
 			var boolval bool
 			var sarrval []string
+			var mapval map[string]string
 			var mval []InputMatcher
 			var tval []JobTrigger
 			var timeoutval TimeOutThresholds
@@ -332,6 +333,8 @@ LoadLoop:
 				val = &mval
 			case "Triggers":
 				val = &tval
+			case "QueueParameters":
+				val = &mapval
 			case "Config":
 				skip = true
 			case "Privileged":
@@ -410,6 +413,12 @@ LoadLoop:
 				} else {
 					job.UUIDTrigger = strings.TrimSpace(*(val.(*string)))
 				}
+			case "QueueParameters":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.QueueParameters = *(val.(*map[string]string))
+				}
 			case "Authorizer":
 				task.Authorizer = *(val.(*string))
 			case "AuthRequire":
@@ -795,6 +804,18 @@ LoadLoop:
 			}
 		}
 
+		if isJob && len(job.QueueParameters) > 0 {
+			queueParams, err := normalizeQueueParameters(job.QueueParameters)
+			if err != nil {
+				msg := fmt.Sprintf("Disabling '%s', invalid QueueParameters: %v", task.name, err)
+				Log(robot.Error, msg)
+				task.Disabled = true
+				task.reason = msg
+				continue
+			}
+			job.QueueParameters = queueParams
+		}
+
 		if isJob && job.UUIDTrigger != "" {
 			parsed, err := uuid.Parse(job.UUIDTrigger)
 			if err != nil {
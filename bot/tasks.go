@@ -173,12 +173,13 @@ type Task struct {
 
 // Job - configuration only applicable to jobs. Read in from conf/jobs/<job>.yaml, which can also include anything from a Task.
 type Job struct {
-	Quiet       bool           `yaml:"Quiet"`       // Whether to quash "job started/ended" messages
-	KeepLogs    int            `yaml:"KeepLogs"`    // How many runs of this job/plugin to keep history for
-	UUIDTrigger string         `yaml:"UUIDTrigger"` // Optional UUID for queue-triggered jobs
-	Triggers    []JobTrigger   `yaml:"Triggers"`    // User/regex that triggers a job, e.g., a git-activated webhook or integration
-	Arguments   []InputMatcher `yaml:"Arguments"`   // List of arguments to prompt the user for
-	*Task       `yaml:",inline"`
+	Quiet           bool              `yaml:"Quiet"`           // Whether to quash "job started/ended" messages
+	KeepLogs        int               `yaml:"KeepLogs"`        // How many runs of this job/plugin to keep history for
+	UUIDTrigger     string            `yaml:"UUIDTrigger"`     // Optional UUID for queue-triggered jobs
+	QueueParameters map[string]string `yaml:"QueueParameters"` // Queue message attributes to expose as parameters, attribute: PARAMETER
+	Triggers        []JobTrigger      `yaml:"Triggers"`        // User/regex that triggers a job, e.g., a git-activated webhook or integration
+	Arguments       []InputMatcher    `yaml:"Arguments"`       // List of arguments to prompt the user for
+	*Task           `yaml:",inline"`
 }
 
 // Plugin specifies the structure of a plugin configuration. Plugins should include an example/default config.
@@ -110,6 +110,20 @@ func getProtocol(proto string) robot.Protocol {
 		return robot.Rocket
 	case "ssh":
 		return robot.SSH
+	case "mattermost":
+		return robot.Mattermost
+	case "matrix":
+		return robot.Matrix
+	case "irc":
+		return robot.IRC
+	case "webhook":
+		return robot.Webhook
+	case "email":
+		return robot.Email
+	case "teams":
+		return robot.Teams
+	case "discord":
+		return robot.Discord
 	default:
 		return robot.Test
 	}
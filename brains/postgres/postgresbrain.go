@@ -0,0 +1,235 @@
+// Package postgresbrain stores the robot's v3 brain records in a PostgreSQL
+// table, using the record version for optimistic concurrency.
+package postgresbrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const brainCacheFormat = "gopherbot-brain-v3"
+
+const maxPostgresBrainVersion = uint64(1<<63 - 1)
+
+type brainConfig struct {
+	URL                     string // connection string; keep it in a secret
+	Schema                  string
+	Table                   string
+	SkipTableCreate         bool
+	MaxConns                int32
+	OperationTimeoutSeconds int
+}
+
+type postgresRemoteBrain struct {
+	cfg   brainConfig
+	pool  *pgxpool.Pool
+	table string // quoted, schema-qualified identifier
+	scope string
+}
+
+func normalizeConfig(cfg brainConfig) (brainConfig, error) {
+	cfg.URL = strings.TrimSpace(cfg.URL)
+	cfg.Schema = strings.TrimSpace(cfg.Schema)
+	cfg.Table = strings.TrimSpace(cfg.Table)
+	if cfg.URL == "" {
+		return cfg, errors.New("URL is required")
+	}
+	if cfg.Table == "" {
+		cfg.Table = "gopherbot_brain"
+	}
+	if cfg.MaxConns <= 0 {
+		cfg.MaxConns = 4
+	}
+	if cfg.OperationTimeoutSeconds <= 0 {
+		cfg.OperationTimeoutSeconds = 15
+	}
+	return cfg, nil
+}
+
+func tableIdentifier(cfg brainConfig) string {
+	if cfg.Schema == "" {
+		return pgx.Identifier{cfg.Table}.Sanitize()
+	}
+	return pgx.Identifier{cfg.Schema, cfg.Table}.Sanitize()
+}
+
+func createTableSQL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+	key text PRIMARY KEY,
+	content bytea,
+	format text NOT NULL,
+	version bigint NOT NULL,
+	checksum text NOT NULL,
+	deleted boolean NOT NULL DEFAULT false,
+	updated_at timestamptz NOT NULL
+)`
+}
+
+// putSQL is a compare-and-swap on version: the update only applies over an
+// older version, or the same version with the same checksum so a retried
+// outbox write is idempotent.
+func putSQL(table string) string {
+	return `INSERT INTO ` + table + ` AS stored (key, content, format, version, checksum, deleted, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (key) DO UPDATE SET content = excluded.content, format = excluded.format, version = excluded.version,
+	checksum = excluded.checksum, deleted = excluded.deleted, updated_at = excluded.updated_at
+WHERE stored.version < excluded.version OR (stored.version = excluded.version AND stored.checksum = excluded.checksum)`
+}
+
+func remoteProvider(r robot.Handler) robot.RemoteBrainBackend {
+	var cfg brainConfig
+	if err := r.GetBrainConfig(&cfg); err != nil {
+		r.Log(robot.Fatal, "Unable to retrieve PostgreSQL brain configuration: %v", err)
+	}
+	cfg, err := normalizeConfig(cfg)
+	if err != nil {
+		r.Log(robot.Fatal, "Invalid PostgreSQL brain configuration: %v", err)
+	}
+	poolCfg, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		// The parse error can echo the URL, password included.
+		r.Log(robot.Fatal, "Unable to parse PostgreSQL brain URL")
+	}
+	poolCfg.MaxConns = cfg.MaxConns
+
+	tableName := cfg.Table
+	if cfg.Schema != "" {
+		tableName = cfg.Schema + "." + cfg.Table
+	}
+	b := &postgresRemoteBrain{
+		cfg:   cfg,
+		table: tableIdentifier(cfg),
+		scope: poolCfg.ConnConfig.Host + "/" + poolCfg.ConnConfig.Database + "/" + tableName,
+	}
+	ctx, cancel := b.timeoutContext()
+	defer cancel()
+	b.pool, err = pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		r.Log(robot.Fatal, "Creating PostgreSQL brain connection pool for '%s': %v", b.scope, err)
+	}
+	if err := b.pool.Ping(ctx); err != nil {
+		b.pool.Close()
+		r.Log(robot.Fatal, "Connecting to PostgreSQL brain database '%s': %v", b.scope, err)
+	}
+	if !cfg.SkipTableCreate {
+		if _, err := b.pool.Exec(ctx, createTableSQL(b.table)); err != nil {
+			b.pool.Close()
+			r.Log(robot.Fatal, "Creating PostgreSQL brain table %s: %v", b.table, err)
+		}
+	}
+	r.Log(robot.Info, "Initialized PostgreSQL brain '%s'", b.scope)
+	return b
+}
+
+func (b *postgresRemoteBrain) timeoutContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(b.cfg.OperationTimeoutSeconds) * time.Second
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func (b *postgresRemoteBrain) Identity() robot.BrainBackendIdentity {
+	return robot.BrainBackendIdentity{Provider: "postgres", Scope: b.scope}
+}
+
+func (b *postgresRemoteBrain) SyncPolicy() robot.BrainSyncPolicy {
+	return robot.BrainSyncPolicy{}
+}
+
+func (b *postgresRemoteBrain) Get(ctx context.Context, key string) (robot.RemoteBrainRecord, bool, error) {
+	var (
+		record  = robot.RemoteBrainRecord{Key: key}
+		version int64
+	)
+	err := b.pool.QueryRow(ctx, `SELECT content, format, version, checksum, deleted, updated_at FROM `+b.table+` WHERE key = $1`, key).
+		Scan(&record.Payload, &record.Format, &version, &record.Checksum, &record.Deleted, &record.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return robot.RemoteBrainRecord{}, false, nil
+		}
+		return robot.RemoteBrainRecord{}, false, err
+	}
+	if record.Format != brainCacheFormat {
+		return robot.RemoteBrainRecord{Key: key}, true, fmt.Errorf("not a v3 brain record")
+	}
+	if version < 0 {
+		return robot.RemoteBrainRecord{Key: key}, true, fmt.Errorf("postgres brain memory %s has negative version %d", key, version)
+	}
+	record.Version = uint64(version)
+	return record, true, nil
+}
+
+func (b *postgresRemoteBrain) Put(ctx context.Context, record robot.RemoteBrainRecord) error {
+	if record.Version > maxPostgresBrainVersion {
+		return fmt.Errorf("postgres brain version %d exceeds signed 64-bit storage limit", record.Version)
+	}
+	updatedAt := record.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+	tag, err := b.pool.Exec(ctx, putSQL(b.table),
+		record.Key, record.Payload, brainCacheFormat, int64(record.Version), record.Checksum, record.Deleted, updatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		var current int64
+		if err := b.pool.QueryRow(ctx, `SELECT version FROM `+b.table+` WHERE key = $1`, record.Key).Scan(&current); err != nil {
+			return fmt.Errorf("postgres brain memory %s version %d was rejected: %w", record.Key, record.Version, err)
+		}
+		return fmt.Errorf("postgres brain memory %s version %d conflicts with stored version %d", record.Key, record.Version, current)
+	}
+	return nil
+}
+
+func (b *postgresRemoteBrain) Delete(ctx context.Context, tombstone robot.RemoteBrainRecord) error {
+	tombstone.Format = brainCacheFormat
+	tombstone.Deleted = true
+	return b.Put(ctx, tombstone)
+}
+
+// ListMetadata pages by key; the cursor is the last key of the previous page.
+func (b *postgresRemoteBrain) ListMetadata(ctx context.Context, cursor string, limit int) (robot.RemoteBrainPage, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	rows, err := b.pool.Query(ctx, `SELECT key, format, version, checksum, deleted, updated_at FROM `+b.table+` WHERE key > $1 ORDER BY key LIMIT $2`, cursor, limit)
+	if err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	defer rows.Close()
+	records := make([]robot.RemoteBrainRecord, 0, limit)
+	for rows.Next() {
+		var (
+			record  robot.RemoteBrainRecord
+			version int64
+		)
+		if err := rows.Scan(&record.Key, &record.Format, &version, &record.Checksum, &record.Deleted, &record.UpdatedAt); err != nil {
+			return robot.RemoteBrainPage{}, err
+		}
+		if version < 0 {
+			return robot.RemoteBrainPage{}, fmt.Errorf("postgres brain memory %s has negative version %d", record.Key, version)
+		}
+		record.Version = uint64(version)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	page := robot.RemoteBrainPage{Records: records}
+	if len(records) == limit {
+		page.NextCursor = records[len(records)-1].Key
+	}
+	return page, nil
+}
+
+func (b *postgresRemoteBrain) Shutdown() {
+	if b.pool != nil {
+		b.pool.Close()
+	}
+}
@@ -0,0 +1,181 @@
+package postgresbrain
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestNormalizeConfigDefaults(t *testing.T) {
+	cfg, err := normalizeConfig(brainConfig{URL: " postgres://robot@db.example.com/robots "})
+	if err != nil {
+		t.Fatalf("normalizeConfig() error = %v", err)
+	}
+	if cfg.URL != "postgres://robot@db.example.com/robots" || cfg.Table != "gopherbot_brain" || cfg.MaxConns != 4 || cfg.OperationTimeoutSeconds != 15 {
+		t.Fatalf("normalizeConfig() = %+v, want trimmed URL and defaults", cfg)
+	}
+	if _, err := normalizeConfig(brainConfig{}); err == nil {
+		t.Fatal("normalizeConfig() accepted a missing URL")
+	}
+}
+
+func TestTableIdentifierQuotesSchemaAndTable(t *testing.T) {
+	if got := tableIdentifier(brainConfig{Table: "gopherbot_brain"}); got != `"gopherbot_brain"` {
+		t.Fatalf("tableIdentifier() = %s", got)
+	}
+	if got := tableIdentifier(brainConfig{Schema: "robots", Table: `floyd"; DROP TABLE x; --`}); got != `"robots"."floyd""; DROP TABLE x; --"` {
+		t.Fatalf("tableIdentifier() = %s, want quoted identifiers", got)
+	}
+}
+
+func TestPutSQLComparesVersions(t *testing.T) {
+	sql := putSQL(`"gopherbot_brain"`)
+	for _, want := range []string{
+		`INSERT INTO "gopherbot_brain" AS stored`,
+		"ON CONFLICT (key) DO UPDATE",
+		"WHERE stored.version < excluded.version OR (stored.version = excluded.version AND stored.checksum = excluded.checksum)",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Fatalf("putSQL() = %s\nmissing %q", sql, want)
+		}
+	}
+}
+
+// testRemoteBrain connects to GOPHER_TEST_POSTGRES_DSN with a throwaway
+// table, skipping when the variable isn't set.
+func testRemoteBrain(t *testing.T) *postgresRemoteBrain {
+	t.Helper()
+	dsn := os.Getenv("GOPHER_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("GOPHER_TEST_POSTGRES_DSN not set")
+	}
+	cfg, err := normalizeConfig(brainConfig{URL: dsn, Table: fmt.Sprintf("gopherbot_brain_test_%d", time.Now().UnixNano())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &postgresRemoteBrain{cfg: cfg, table: tableIdentifier(cfg)}
+	ctx, cancel := b.timeoutContext()
+	defer cancel()
+	if b.pool, err = pgxpool.New(ctx, dsn); err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	if _, err := b.pool.Exec(ctx, createTableSQL(b.table)); err != nil {
+		b.pool.Close()
+		t.Fatalf("creating %s: %v", b.table, err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := b.timeoutContext()
+		defer cancel()
+		if _, err := b.pool.Exec(ctx, `DROP TABLE IF EXISTS `+b.table); err != nil {
+			t.Errorf("dropping %s: %v", b.table, err)
+		}
+		b.Shutdown()
+	})
+	return b
+}
+
+func TestPutVersionCompareAndSwap(t *testing.T) {
+	b := testRemoteBrain(t)
+	ctx := context.Background()
+	put := func(version uint64, checksum, payload string) error {
+		return b.Put(ctx, robot.RemoteBrainRecord{Key: "memory", Payload: []byte(payload), Version: version, Checksum: checksum})
+	}
+	if err := put(1, "c1", "first"); err != nil {
+		t.Fatalf("Put(v1) error = %v", err)
+	}
+	if err := put(1, "c1", "first"); err != nil {
+		t.Fatalf("Put(v1) retry with the same checksum error = %v", err)
+	}
+	if err := put(1, "c2", "other"); err == nil || !strings.Contains(err.Error(), "conflicts with stored version 1") {
+		t.Fatalf("Put(v1) with another checksum error = %v, want a conflict", err)
+	}
+	if err := put(2, "c3", "second"); err != nil {
+		t.Fatalf("Put(v2) error = %v", err)
+	}
+	if err := put(1, "c1", "stale"); err == nil || !strings.Contains(err.Error(), "conflicts with stored version 2") {
+		t.Fatalf("Put(stale v1) error = %v, want a conflict", err)
+	}
+	record, exists, err := b.Get(ctx, "memory")
+	if err != nil || !exists {
+		t.Fatalf("Get() = %v, %v", exists, err)
+	}
+	if record.Version != 2 || record.Checksum != "c3" || string(record.Payload) != "second" || record.Format != brainCacheFormat || record.Deleted {
+		t.Fatalf("Get() = %+v, want v2 with checksum c3", record)
+	}
+	if _, exists, err := b.Get(ctx, "missing"); err != nil || exists {
+		t.Fatalf("Get(missing) = %v, %v; want not found", exists, err)
+	}
+}
+
+func TestDeleteStoresTombstone(t *testing.T) {
+	b := testRemoteBrain(t)
+	ctx := context.Background()
+	if err := b.Put(ctx, robot.RemoteBrainRecord{Key: "memory", Payload: []byte("value"), Version: 1, Checksum: "c1"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: "memory", Version: 2, Checksum: "gone"}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	record, exists, err := b.Get(ctx, "memory")
+	if err != nil || !exists {
+		t.Fatalf("Get(tombstone) = %v, %v; want the tombstone", exists, err)
+	}
+	if !record.Deleted || record.Version != 2 || record.Checksum != "gone" || len(record.Payload) != 0 || record.Format != brainCacheFormat {
+		t.Fatalf("Get(tombstone) = %+v, want a v2 deleted record", record)
+	}
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: "memory", Version: 1, Checksum: "late"}); err == nil {
+		t.Fatal("Delete() with a stale version succeeded")
+	}
+	if err := b.Put(ctx, robot.RemoteBrainRecord{Key: "memory", Payload: []byte("again"), Version: 3, Checksum: "c3"}); err != nil {
+		t.Fatalf("Put() over the tombstone error = %v", err)
+	}
+	if record, _, err := b.Get(ctx, "memory"); err != nil || record.Deleted || string(record.Payload) != "again" {
+		t.Fatalf("Get() after re-store = %+v, %v", record, err)
+	}
+}
+
+func TestListMetadataPages(t *testing.T) {
+	b := testRemoteBrain(t)
+	ctx := context.Background()
+	keys := []string{"a", "b", "c", "d", "e"}
+	for i, key := range keys {
+		if err := b.Put(ctx, robot.RemoteBrainRecord{Key: key, Payload: []byte(key), Version: uint64(i + 1), Checksum: "c" + key}); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: "c", Version: 10, Checksum: "gone"}); err != nil {
+		t.Fatalf("Delete(c) error = %v", err)
+	}
+	var (
+		got    []string
+		cursor string
+		pages  int
+	)
+	for {
+		page, err := b.ListMetadata(ctx, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListMetadata(%q) error = %v", cursor, err)
+		}
+		pages++
+		for _, record := range page.Records {
+			if len(record.Payload) != 0 {
+				t.Fatalf("ListMetadata() returned content for %s", record.Key)
+			}
+			got = append(got, fmt.Sprintf("%s:%d:%t", record.Key, record.Version, record.Deleted))
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	want := "a:1:false b:2:false c:10:true d:4:false e:5:false"
+	if strings.Join(got, " ") != want || pages != 3 {
+		t.Fatalf("ListMetadata() = %q in %d pages, want %q in 3", got, pages, want)
+	}
+}
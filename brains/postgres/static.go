@@ -0,0 +1,7 @@
+package postgresbrain
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterRemoteBrain("postgres", remoteProvider)
+}
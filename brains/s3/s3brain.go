@@ -0,0 +1,270 @@
+// Package s3brain stores the robot's v3 brain records as objects in AWS S3
+// or an S3-compatible store such as MinIO.
+package s3brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/v2/internal/s3"
+)
+
+const brainCacheFormat = "gopherbot-brain-v3"
+
+// User metadata keys; S3 returns them lowercased.
+const (
+	metaFormat    = "gopherbot-format"
+	metaVersion   = "gopherbot-version"
+	metaChecksum  = "gopherbot-checksum"
+	metaDeleted   = "gopherbot-deleted"
+	metaUpdatedAt = "gopherbot-updated-at"
+)
+
+type brainConfig struct {
+	Endpoint                    string
+	Region                      string
+	Bucket                      string
+	Prefix                      string
+	PathStyle                   bool
+	AccessKeyID                 string
+	SecretAccessKey             string
+	DisableConditionalWrites    bool
+	OperationTimeoutSeconds     int
+	CloudWriteBudgetPerDay      int
+	CloudWriteMinIntervalMillis int
+	CoalesceWindowMillis        int
+	FlushOnShutdownMaxMillis    int
+	CheckpointVerifyRetries     int
+	CheckpointVerifyDelayMillis int
+}
+
+// logger is the part of robot.Handler the brain needs after startup.
+type logger interface {
+	Log(l robot.LogLevel, m string, v ...interface{})
+}
+
+type s3RemoteBrain struct {
+	cfg    brainConfig
+	logger logger
+	client *s3.Client
+	// unconditional is set when the store doesn't implement conditional
+	// writes, or they're disabled in configuration.
+	unconditional atomic.Bool
+}
+
+func normalizeConfig(cfg brainConfig) (brainConfig, error) {
+	cfg.Endpoint = strings.TrimSpace(cfg.Endpoint)
+	cfg.Region = strings.TrimSpace(cfg.Region)
+	cfg.Bucket = strings.TrimSpace(cfg.Bucket)
+	cfg.Prefix = strings.TrimLeft(strings.TrimSpace(cfg.Prefix), "/")
+	cfg.AccessKeyID = strings.TrimSpace(cfg.AccessKeyID)
+	cfg.SecretAccessKey = strings.TrimSpace(cfg.SecretAccessKey)
+	if cfg.Bucket == "" {
+		return cfg, errors.New("Bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "gopherbot-brain/"
+	}
+	if !strings.HasSuffix(cfg.Prefix, "/") {
+		cfg.Prefix += "/"
+	}
+	if cfg.AccessKeyID == "" && cfg.SecretAccessKey != "" {
+		return cfg, errors.New("SecretAccessKey is set but AccessKeyID is empty")
+	}
+	if cfg.AccessKeyID != "" && cfg.SecretAccessKey == "" {
+		return cfg, errors.New("AccessKeyID is set but SecretAccessKey is empty")
+	}
+	if cfg.OperationTimeoutSeconds <= 0 {
+		cfg.OperationTimeoutSeconds = 15
+	}
+	return cfg, nil
+}
+
+func remoteProvider(r robot.Handler) robot.RemoteBrainBackend {
+	var cfg brainConfig
+	if err := r.GetBrainConfig(&cfg); err != nil {
+		r.Log(robot.Fatal, "Unable to retrieve S3 brain configuration: %v", err)
+	}
+	cfg, err := normalizeConfig(cfg)
+	if err != nil {
+		r.Log(robot.Fatal, "Invalid S3 brain configuration: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.OperationTimeoutSeconds)*time.Second)
+	defer cancel()
+	creds, err := s3.LoadCredentials(ctx, cfg.Region, cfg.AccessKeyID, cfg.SecretAccessKey)
+	if err != nil {
+		r.Log(robot.Fatal, "Unable to load AWS credentials for S3 brain: %v", err)
+	}
+	b, err := newS3RemoteBrain(cfg, r, creds)
+	if err != nil {
+		r.Log(robot.Fatal, "Creating S3 brain client: %v", err)
+	}
+	if _, _, err := b.client.List(ctx, cfg.Prefix, "", 1); err != nil {
+		r.Log(robot.Fatal, "Validating S3 brain bucket '%s': %v", b.client.Location(), err)
+	}
+	r.Log(robot.Info, "Initialized S3 brain in '%s' with prefix '%s'", b.client.Location(), cfg.Prefix)
+	return b
+}
+
+func newS3RemoteBrain(cfg brainConfig, logger logger, creds aws.CredentialsProvider) (*s3RemoteBrain, error) {
+	client, err := s3.New(s3.Config{
+		Endpoint:  cfg.Endpoint,
+		Region:    cfg.Region,
+		Bucket:    cfg.Bucket,
+		PathStyle: cfg.PathStyle,
+	}, creds, nil)
+	if err != nil {
+		return nil, err
+	}
+	b := &s3RemoteBrain{cfg: cfg, logger: logger, client: client}
+	b.unconditional.Store(cfg.DisableConditionalWrites)
+	return b, nil
+}
+
+func (b *s3RemoteBrain) Identity() robot.BrainBackendIdentity {
+	return robot.BrainBackendIdentity{
+		Provider: "s3",
+		Scope:    b.client.Location() + "/" + b.cfg.Prefix,
+	}
+}
+
+func (b *s3RemoteBrain) SyncPolicy() robot.BrainSyncPolicy {
+	return robot.BrainSyncPolicy{
+		WriteBudgetPerDay:          b.cfg.CloudWriteBudgetPerDay,
+		MinWriteInterval:           time.Duration(b.cfg.CloudWriteMinIntervalMillis) * time.Millisecond,
+		CoalesceWindow:             time.Duration(b.cfg.CoalesceWindowMillis) * time.Millisecond,
+		FlushOnShutdownMaxDuration: time.Duration(b.cfg.FlushOnShutdownMaxMillis) * time.Millisecond,
+		CheckpointVerifyRetries:    b.cfg.CheckpointVerifyRetries,
+		CheckpointVerifyDelay:      time.Duration(b.cfg.CheckpointVerifyDelayMillis) * time.Millisecond,
+	}
+}
+
+func (b *s3RemoteBrain) Get(ctx context.Context, key string) (robot.RemoteBrainRecord, bool, error) {
+	obj, exists, err := b.client.Get(ctx, b.cfg.Prefix+key)
+	if err != nil || !exists {
+		return robot.RemoteBrainRecord{}, exists, err
+	}
+	record, err := recordFromMetadata(key, obj.Metadata)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, true, err
+	}
+	record.Payload = obj.Body
+	return record, true, nil
+}
+
+// Put writes the record conditionally on the object being unchanged since
+// it was checked, and refuses to replace a newer version written by another
+// robot sharing the bucket.
+func (b *s3RemoteBrain) Put(ctx context.Context, record robot.RemoteBrainRecord) error {
+	objectKey := b.cfg.Prefix + record.Key
+	var opts s3.PutOptions
+	if !b.unconditional.Load() {
+		current, exists, err := b.client.Head(ctx, objectKey)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if stored, err := recordFromMetadata(record.Key, current.Metadata); err == nil && stored.Version > record.Version {
+				return fmt.Errorf("s3 brain memory %s has newer version %d than %d", record.Key, stored.Version, record.Version)
+			}
+			opts.IfMatch = current.ETag
+		} else {
+			opts.IfNoneMatch = true
+		}
+	}
+	_, err := b.client.Put(ctx, objectKey, record.Payload, metadataFromRecord(record), opts)
+	if err != nil && s3.IsNotImplemented(err) && opts != (s3.PutOptions{}) {
+		b.unconditional.Store(true)
+		b.logger.Log(robot.Warn, "S3 brain store '%s' doesn't support conditional writes, continuing without them", b.client.Location())
+		_, err = b.client.Put(ctx, objectKey, record.Payload, metadataFromRecord(record), s3.PutOptions{})
+	}
+	if err != nil && s3.IsPreconditionFailed(err) {
+		return fmt.Errorf("s3 brain memory %s was changed by another writer: %w", record.Key, err)
+	}
+	return err
+}
+
+func (b *s3RemoteBrain) Delete(ctx context.Context, tombstone robot.RemoteBrainRecord) error {
+	tombstone.Format = brainCacheFormat
+	tombstone.Deleted = true
+	return b.Put(ctx, tombstone)
+}
+
+// ListMetadata pages with S3 continuation tokens as the cursor; record
+// metadata comes from a HEAD of each object.
+func (b *s3RemoteBrain) ListMetadata(ctx context.Context, cursor string, limit int) (robot.RemoteBrainPage, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	objectKeys, next, err := b.client.List(ctx, b.cfg.Prefix, cursor, limit)
+	if err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	records := make([]robot.RemoteBrainRecord, 0, len(objectKeys))
+	for _, objectKey := range objectKeys {
+		key := strings.TrimPrefix(objectKey, b.cfg.Prefix)
+		obj, exists, err := b.client.Head(ctx, objectKey)
+		if err != nil {
+			return robot.RemoteBrainPage{}, err
+		}
+		if !exists {
+			continue
+		}
+		record, err := recordFromMetadata(key, obj.Metadata)
+		if err != nil {
+			records = append(records, robot.RemoteBrainRecord{Key: key})
+			continue
+		}
+		records = append(records, record)
+	}
+	return robot.RemoteBrainPage{Records: records, NextCursor: next}, nil
+}
+
+func (b *s3RemoteBrain) Shutdown() {}
+
+func metadataFromRecord(record robot.RemoteBrainRecord) map[string]string {
+	updatedAt := record.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+	return map[string]string{
+		metaFormat:    brainCacheFormat,
+		metaVersion:   strconv.FormatUint(record.Version, 10),
+		metaChecksum:  record.Checksum,
+		metaDeleted:   strconv.FormatBool(record.Deleted),
+		metaUpdatedAt: updatedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func recordFromMetadata(key string, metadata map[string]string) (robot.RemoteBrainRecord, error) {
+	if metadata[metaFormat] != brainCacheFormat {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("not a v3 brain record")
+	}
+	version, err := strconv.ParseUint(metadata[metaVersion], 10, 64)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("s3 brain memory %s has invalid version %q", key, metadata[metaVersion])
+	}
+	deleted, err := strconv.ParseBool(metadata[metaDeleted])
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("s3 brain memory %s has invalid deleted flag %q", key, metadata[metaDeleted])
+	}
+	updatedAt, _ := time.Parse(time.RFC3339Nano, metadata[metaUpdatedAt])
+	return robot.RemoteBrainRecord{
+		Key:       key,
+		Format:    brainCacheFormat,
+		Version:   version,
+		Checksum:  metadata[metaChecksum],
+		Deleted:   deleted,
+		UpdatedAt: updatedAt,
+	}, nil
+}
@@ -0,0 +1,276 @@
+package s3brain
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type fakeObject struct {
+	body     []byte
+	metadata http.Header
+	etag     string
+}
+
+// fakeS3 is a minimal path-style S3 stand-in, like a local MinIO, that
+// keeps objects in memory and enforces conditional writes.
+type fakeS3 struct {
+	sync.Mutex
+	bucket        string
+	objects       map[string]fakeObject
+	puts          int
+	noConditional bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == f.bucket && r.Method == http.MethodGet {
+		f.list(w, r)
+		return
+	}
+	key, ok := strings.CutPrefix(path, f.bucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	obj, exists := f.objects[key]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range obj.metadata {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", obj.etag)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.body)
+		}
+	case http.MethodPut:
+		ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		if f.noConditional && (ifMatch != "" || ifNoneMatch != "") {
+			w.WriteHeader(http.StatusNotImplemented)
+			_, _ = io.WriteString(w, "<Error><Code>NotImplemented</Code><Message>conditional writes</Message></Error>")
+			return
+		}
+		if ifNoneMatch == "*" && exists || ifMatch != "" && (!exists || ifMatch != obj.etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = io.WriteString(w, "<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.put(key, body, r.Header)
+		w.Header().Set("ETag", f.objects[key].etag)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) put(key string, body []byte, header http.Header) {
+	f.puts++
+	metadata := make(http.Header)
+	for name, values := range header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			metadata[name] = values
+		}
+	}
+	f.objects[key] = fakeObject{body: body, metadata: metadata, etag: fmt.Sprintf(`"etag-%d"`, f.puts)}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	after := query.Get("continuation-token")
+	maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	type content struct{ Key, ETag string }
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	if maxKeys > 0 && len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{Key: key, ETag: f.objects[key].etag})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+type testLogger struct{}
+
+func (testLogger) Log(robot.LogLevel, string, ...interface{}) {}
+
+func newTestBrain(t *testing.T) (*s3RemoteBrain, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{bucket: "robots", objects: make(map[string]fakeObject)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	cfg, err := normalizeConfig(brainConfig{
+		Endpoint:        server.URL,
+		Bucket:          "robots",
+		Prefix:          "floyd",
+		PathStyle:       true,
+		AccessKeyID:     "AKIDTEST",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("normalizeConfig() error = %v", err)
+	}
+	b, err := newS3RemoteBrain(cfg, testLogger{}, credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""))
+	if err != nil {
+		t.Fatalf("newS3RemoteBrain() error = %v", err)
+	}
+	return b, fake
+}
+
+func TestNormalizeConfigDefaultsAndValidation(t *testing.T) {
+	cfg, err := normalizeConfig(brainConfig{Bucket: " robots ", Prefix: "/floyd"})
+	if err != nil {
+		t.Fatalf("normalizeConfig() error = %v", err)
+	}
+	if cfg.Bucket != "robots" || cfg.Prefix != "floyd/" || cfg.Region != "us-east-1" {
+		t.Fatalf("normalizeConfig() = %+v, want trimmed bucket, prefix floyd/ and default region", cfg)
+	}
+	if _, err := normalizeConfig(brainConfig{}); err == nil {
+		t.Fatal("normalizeConfig() accepted a missing Bucket")
+	}
+	if _, err := normalizeConfig(brainConfig{Bucket: "robots", AccessKeyID: "AKIDTEST"}); err == nil {
+		t.Fatal("normalizeConfig() accepted AccessKeyID without SecretAccessKey")
+	}
+}
+
+func TestS3BrainRoundTripAndTombstone(t *testing.T) {
+	b, fake := newTestBrain(t)
+	ctx := context.Background()
+	updatedAt := time.Date(2026, 5, 25, 12, 0, 0, 0, time.UTC)
+
+	if _, exists, err := b.Get(ctx, "plugin:key"); err != nil || exists {
+		t.Fatalf("Get() on empty bucket = exists %t, error %v", exists, err)
+	}
+	if err := b.Put(ctx, robot.RemoteBrainRecord{
+		Key:       "plugin:key",
+		Payload:   []byte("encrypted payload"),
+		Version:   42,
+		Checksum:  "abc123",
+		UpdatedAt: updatedAt,
+	}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, ok := fake.objects["floyd/plugin:key"]; !ok {
+		t.Fatalf("object not stored under prefix, have %v", fake.objects)
+	}
+	got, exists, err := b.Get(ctx, "plugin:key")
+	if err != nil || !exists {
+		t.Fatalf("Get() = exists %t, error %v", exists, err)
+	}
+	if string(got.Payload) != "encrypted payload" || got.Format != brainCacheFormat || got.Version != 42 ||
+		got.Checksum != "abc123" || got.Deleted || !got.UpdatedAt.Equal(updatedAt) {
+		t.Fatalf("Get() = %+v, want stored record", got)
+	}
+
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: "plugin:key", Version: 43, Checksum: "def456"}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	got, exists, err = b.Get(ctx, "plugin:key")
+	if err != nil || !exists || !got.Deleted || got.Version != 43 {
+		t.Fatalf("Get() after Delete() = %+v, exists %t, error %v; want tombstone version 43", got, exists, err)
+	}
+}
+
+func TestS3BrainListMetadataUsesContinuationTokens(t *testing.T) {
+	b, fake := newTestBrain(t)
+	ctx := context.Background()
+	for i, key := range []string{"c", "a", "b"} {
+		if err := b.Put(ctx, robot.RemoteBrainRecord{Key: key, Payload: []byte(key), Version: uint64(i + 1)}); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+	fake.put("other-robot/a", []byte("x"), nil)
+
+	var keys []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("ListMetadata() did not terminate")
+		}
+		page, err := b.ListMetadata(ctx, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		for _, record := range page.Records {
+			if record.Payload != nil || record.Format != brainCacheFormat {
+				t.Fatalf("ListMetadata() record = %+v, want metadata only", record)
+			}
+			keys = append(keys, record.Key)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if got := strings.Join(keys, ","); got != "a,b,c" {
+		t.Fatalf("listed keys = %s, want a,b,c", got)
+	}
+}
+
+func TestS3BrainPutRefusesToReplaceNewerVersion(t *testing.T) {
+	b, _ := newTestBrain(t)
+	ctx := context.Background()
+	if err := b.Put(ctx, robot.RemoteBrainRecord{Key: "k", Payload: []byte("new"), Version: 7}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	err := b.Put(ctx, robot.RemoteBrainRecord{Key: "k", Payload: []byte("old"), Version: 6})
+	if err == nil || !strings.Contains(err.Error(), "newer version 7") {
+		t.Fatalf("Put() of older version error = %v, want newer version error", err)
+	}
+	if err := b.Put(ctx, robot.RemoteBrainRecord{Key: "k", Payload: []byte("retry"), Version: 7}); err != nil {
+		t.Fatalf("Put() retrying the same version error = %v", err)
+	}
+}
+
+func TestS3BrainFallsBackWithoutConditionalWrites(t *testing.T) {
+	b, fake := newTestBrain(t)
+	fake.noConditional = true
+	if err := b.Put(context.Background(), robot.RemoteBrainRecord{Key: "k", Payload: []byte("v"), Version: 1}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if !b.unconditional.Load() {
+		t.Fatal("Put() didn't switch to unconditional writes after NotImplemented")
+	}
+	if string(fake.objects["floyd/k"].body) != "v" {
+		t.Fatal("Put() didn't store the object after falling back")
+	}
+}
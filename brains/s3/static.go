@@ -0,0 +1,7 @@
+package s3brain
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterRemoteBrain("s3", remoteProvider)
+}
@@ -0,0 +1,258 @@
+// Package sqlitebrain stores the robot's v3 brain records in a local SQLite
+// database, using the pure-Go modernc.org/sqlite driver.
+package sqlitebrain
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	_ "modernc.org/sqlite"
+)
+
+const brainCacheFormat = "gopherbot-brain-v3"
+
+const maxSQLiteBrainVersion = uint64(1<<63 - 1)
+
+var tableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type brainConfig struct {
+	Path                    string // database file, created if missing
+	Table                   string
+	JournalMode             string // WAL for local disks, DELETE for NFS
+	BusyTimeoutMillis       int
+	OperationTimeoutSeconds int
+}
+
+type sqliteRemoteBrain struct {
+	cfg brainConfig
+	db  *sql.DB
+}
+
+func defaultedConfig(cfg brainConfig) (brainConfig, error) {
+	cfg.Path = strings.TrimSpace(cfg.Path)
+	cfg.Table = strings.TrimSpace(cfg.Table)
+	cfg.JournalMode = strings.ToUpper(strings.TrimSpace(cfg.JournalMode))
+	if cfg.Path == "" {
+		cfg.Path = "state/brain.sqlite"
+	}
+	if cfg.Table == "" {
+		cfg.Table = "gopherbot_brain"
+	}
+	if cfg.JournalMode == "" {
+		cfg.JournalMode = "WAL"
+	}
+	if cfg.BusyTimeoutMillis <= 0 {
+		cfg.BusyTimeoutMillis = 5000
+	}
+	if cfg.OperationTimeoutSeconds <= 0 {
+		cfg.OperationTimeoutSeconds = 15
+	}
+	if !tableNameRe.MatchString(cfg.Table) {
+		return cfg, fmt.Errorf("invalid Table %q, must be letters, digits and underscores", cfg.Table)
+	}
+	switch cfg.JournalMode {
+	case "WAL", "DELETE", "TRUNCATE", "PERSIST":
+	default:
+		return cfg, fmt.Errorf("invalid JournalMode %q, must be one of WAL, DELETE, TRUNCATE or PERSIST", cfg.JournalMode)
+	}
+	return cfg, nil
+}
+
+// dataSourceName sets the pragmas on every connection; synchronous(FULL)
+// makes each committed Put durable before the cached brain checkpoints it.
+func dataSourceName(cfg brainConfig) string {
+	q := url.Values{}
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cfg.BusyTimeoutMillis))
+	q.Add("_pragma", "journal_mode("+cfg.JournalMode+")")
+	q.Add("_pragma", "synchronous(FULL)")
+	q.Set("_txlock", "immediate")
+	return cfg.Path + "?" + q.Encode()
+}
+
+func openSQLiteBrain(cfg brainConfig) (*sqliteRemoteBrain, error) {
+	if dir := filepath.Dir(cfg.Path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("creating directory for '%s': %w", cfg.Path, err)
+		}
+	}
+	db, err := sql.Open("sqlite", dataSourceName(cfg))
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes writers inside the robot; other
+	// processes sharing the file wait up to BusyTimeoutMillis.
+	db.SetMaxOpenConns(1)
+	b := &sqliteRemoteBrain{cfg: cfg, db: db}
+
+	ctx, cancel := b.timeoutContext()
+	defer cancel()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+cfg.Table+` (
+	key TEXT PRIMARY KEY,
+	content BLOB,
+	format TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	checksum TEXT NOT NULL,
+	deleted INTEGER NOT NULL,
+	updated_at TEXT NOT NULL
+)`); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("creating table '%s': %w", cfg.Table, err)
+	}
+	return b, nil
+}
+
+func remoteProvider(r robot.Handler) robot.RemoteBrainBackend {
+	var cfg brainConfig
+	if err := r.GetBrainConfig(&cfg); err != nil {
+		r.Log(robot.Fatal, "Unable to retrieve SQLite brain configuration: %v", err)
+	}
+	cfg, err := defaultedConfig(cfg)
+	if err != nil {
+		r.Log(robot.Fatal, "Invalid SQLite brain configuration: %v", err)
+	}
+	b, err := openSQLiteBrain(cfg)
+	if err != nil {
+		r.Log(robot.Fatal, "Opening SQLite brain database '%s': %v", cfg.Path, err)
+	}
+	r.Log(robot.Info, "Initialized SQLite brain database '%s', table '%s', journal mode %s", cfg.Path, cfg.Table, cfg.JournalMode)
+	return b
+}
+
+func (b *sqliteRemoteBrain) timeoutContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(b.cfg.OperationTimeoutSeconds) * time.Second
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func (b *sqliteRemoteBrain) Identity() robot.BrainBackendIdentity {
+	path := b.cfg.Path
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return robot.BrainBackendIdentity{
+		Provider: "sqlite",
+		Scope:    path + "/" + b.cfg.Table,
+	}
+}
+
+func (b *sqliteRemoteBrain) SyncPolicy() robot.BrainSyncPolicy {
+	return robot.BrainSyncPolicy{}
+}
+
+func (b *sqliteRemoteBrain) Get(ctx context.Context, key string) (robot.RemoteBrainRecord, bool, error) {
+	row := b.db.QueryRowContext(ctx, `SELECT content, format, version, checksum, deleted, updated_at FROM `+b.cfg.Table+` WHERE key = ?`, key)
+	var (
+		content   []byte
+		format    string
+		version   int64
+		checksum  string
+		deleted   bool
+		updatedAt string
+	)
+	if err := row.Scan(&content, &format, &version, &checksum, &deleted, &updatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return robot.RemoteBrainRecord{}, false, nil
+		}
+		return robot.RemoteBrainRecord{}, false, err
+	}
+	if format != brainCacheFormat {
+		return robot.RemoteBrainRecord{Key: key}, true, fmt.Errorf("not a v3 brain record")
+	}
+	record, err := remoteRecordFromRow(key, format, version, checksum, deleted, updatedAt)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, true, err
+	}
+	record.Payload = content
+	return record, true, nil
+}
+
+func (b *sqliteRemoteBrain) Put(ctx context.Context, record robot.RemoteBrainRecord) error {
+	if record.Version > maxSQLiteBrainVersion {
+		return fmt.Errorf("sqlite brain version %d exceeds signed 64-bit storage limit", record.Version)
+	}
+	_, err := b.db.ExecContext(ctx, `INSERT INTO `+b.cfg.Table+` (key, content, format, version, checksum, deleted, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET content = excluded.content, format = excluded.format, version = excluded.version,
+	checksum = excluded.checksum, deleted = excluded.deleted, updated_at = excluded.updated_at`,
+		record.Key, record.Payload, brainCacheFormat, int64(record.Version), record.Checksum, record.Deleted,
+		record.UpdatedAt.UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+func (b *sqliteRemoteBrain) Delete(ctx context.Context, tombstone robot.RemoteBrainRecord) error {
+	tombstone.Format = brainCacheFormat
+	tombstone.Deleted = true
+	return b.Put(ctx, tombstone)
+}
+
+// ListMetadata pages through the table in key order; the cursor is the last
+// key of the previous page.
+func (b *sqliteRemoteBrain) ListMetadata(ctx context.Context, cursor string, limit int) (robot.RemoteBrainPage, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	rows, err := b.db.QueryContext(ctx, `SELECT key, format, version, checksum, deleted, updated_at FROM `+b.cfg.Table+` WHERE key > ? ORDER BY key LIMIT ?`, cursor, limit)
+	if err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	defer rows.Close()
+	records := make([]robot.RemoteBrainRecord, 0, limit)
+	for rows.Next() {
+		var (
+			key       string
+			format    string
+			version   int64
+			checksum  string
+			deleted   bool
+			updatedAt string
+		)
+		if err := rows.Scan(&key, &format, &version, &checksum, &deleted, &updatedAt); err != nil {
+			return robot.RemoteBrainPage{}, err
+		}
+		record, err := remoteRecordFromRow(key, format, version, checksum, deleted, updatedAt)
+		if err != nil {
+			return robot.RemoteBrainPage{}, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	page := robot.RemoteBrainPage{Records: records}
+	if len(records) == limit {
+		page.NextCursor = records[len(records)-1].Key
+	}
+	return page, nil
+}
+
+func (b *sqliteRemoteBrain) Shutdown() {
+	if b.db != nil {
+		_ = b.db.Close()
+	}
+}
+
+func remoteRecordFromRow(key, format string, version int64, checksum string, deleted bool, updatedAt string) (robot.RemoteBrainRecord, error) {
+	if version < 0 {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("sqlite brain memory %s has negative version %d", key, version)
+	}
+	updated, err := time.Parse(time.RFC3339Nano, updatedAt)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("sqlite brain memory %s has invalid updated_at %q: %w", key, updatedAt, err)
+	}
+	return robot.RemoteBrainRecord{
+		Key:       key,
+		Format:    format,
+		Version:   uint64(version),
+		Checksum:  checksum,
+		Deleted:   deleted,
+		UpdatedAt: updated,
+	}, nil
+}
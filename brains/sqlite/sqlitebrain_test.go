@@ -0,0 +1,120 @@
+package sqlitebrain
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func openTestBrain(t *testing.T) *sqliteRemoteBrain {
+	t.Helper()
+	cfg, err := defaultedConfig(brainConfig{Path: filepath.Join(t.TempDir(), "brain", "brain.sqlite")})
+	if err != nil {
+		t.Fatalf("defaultedConfig() error = %v", err)
+	}
+	b, err := openSQLiteBrain(cfg)
+	if err != nil {
+		t.Fatalf("openSQLiteBrain() error = %v", err)
+	}
+	t.Cleanup(b.Shutdown)
+	return b
+}
+
+func TestDefaultedConfigValidatesTableAndJournalMode(t *testing.T) {
+	cfg, err := defaultedConfig(brainConfig{JournalMode: " delete "})
+	if err != nil {
+		t.Fatalf("defaultedConfig() error = %v", err)
+	}
+	if cfg.Path != "state/brain.sqlite" || cfg.Table != "gopherbot_brain" || cfg.JournalMode != "DELETE" {
+		t.Fatalf("defaultedConfig() = %+v, want default path and table with DELETE journal", cfg)
+	}
+	if _, err := defaultedConfig(brainConfig{Table: "brain; DROP TABLE x"}); err == nil {
+		t.Fatal("defaultedConfig() accepted a table name that isn't a plain identifier")
+	}
+	if _, err := defaultedConfig(brainConfig{JournalMode: "OFF"}); err == nil {
+		t.Fatal("defaultedConfig() accepted journal mode OFF")
+	}
+}
+
+func TestSQLiteBrainRoundTripAndTombstone(t *testing.T) {
+	b := openTestBrain(t)
+	ctx := context.Background()
+	updatedAt := time.Date(2026, 5, 25, 12, 0, 0, 0, time.UTC)
+
+	if _, exists, err := b.Get(ctx, "plugin:key"); err != nil || exists {
+		t.Fatalf("Get() on empty brain = exists %t, error %v", exists, err)
+	}
+	record := robot.RemoteBrainRecord{
+		Key:       "plugin:key",
+		Payload:   []byte("encrypted payload"),
+		Version:   42,
+		Checksum:  "abc123",
+		UpdatedAt: updatedAt,
+	}
+	if err := b.Put(ctx, record); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, exists, err := b.Get(ctx, record.Key)
+	if err != nil || !exists {
+		t.Fatalf("Get() = exists %t, error %v", exists, err)
+	}
+	if string(got.Payload) != "encrypted payload" || got.Format != brainCacheFormat || got.Version != 42 ||
+		got.Checksum != "abc123" || got.Deleted || !got.UpdatedAt.Equal(updatedAt) {
+		t.Fatalf("Get() = %+v, want stored record", got)
+	}
+
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: record.Key, Version: 43, Checksum: "def456", UpdatedAt: updatedAt}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	got, exists, err = b.Get(ctx, record.Key)
+	if err != nil || !exists || !got.Deleted || got.Version != 43 || len(got.Payload) != 0 {
+		t.Fatalf("Get() after Delete() = %+v, exists %t, error %v; want tombstone version 43", got, exists, err)
+	}
+}
+
+func TestSQLiteBrainListMetadataPages(t *testing.T) {
+	b := openTestBrain(t)
+	ctx := context.Background()
+	for _, key := range []string{"c", "a", "b"} {
+		if err := b.Put(ctx, robot.RemoteBrainRecord{Key: key, Payload: []byte(key), Version: 1}); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+
+	var keys []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("ListMetadata() did not terminate")
+		}
+		page, err := b.ListMetadata(ctx, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		for _, record := range page.Records {
+			if record.Payload != nil {
+				t.Fatalf("ListMetadata() returned payload for %s", record.Key)
+			}
+			keys = append(keys, record.Key)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if got := strings.Join(keys, ","); got != "a,b,c" {
+		t.Fatalf("listed keys = %s, want a,b,c", got)
+	}
+}
+
+func TestSQLiteBrainRejectsVersionOverflow(t *testing.T) {
+	b := openTestBrain(t)
+	err := b.Put(context.Background(), robot.RemoteBrainRecord{Key: "plugin:key", Version: maxSQLiteBrainVersion + 1})
+	if err == nil {
+		t.Fatal("Put() accepted uint64 version larger than SQLite can store")
+	}
+}
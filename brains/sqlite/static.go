@@ -0,0 +1,7 @@
+package sqlitebrain
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterRemoteBrain("sqlite", remoteProvider)
+}
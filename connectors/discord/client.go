@@ -0,0 +1,239 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Discord REST API; the gateway only delivers events.
+
+var errUnauthorized = errors.New("Discord rejected the bot token")
+
+// errCodeThreadExists is Discord's JSON error code for a message that
+// already has a thread.
+const errCodeThreadExists = 160004
+
+// apiError is a failed REST call.
+type apiError struct {
+	Status     int
+	Code       int     `json:"code"`
+	Message    string  `json:"message"`
+	RetryAfter float64 `json:"retry_after"`
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Discord API returned HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("Discord API returned HTTP %d: %s (code %d)", e.Status, e.Message, e.Code)
+}
+
+func apiErrorCode(err error) (status, code int) {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		return ae.Status, ae.Code
+	}
+	return 0, 0
+}
+
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Bot        bool   `json:"bot"`
+}
+
+// displayName is what Discord shows for the user.
+func (u discordUser) displayName() string {
+	if u.GlobalName != "" {
+		return u.GlobalName
+	}
+	return u.Username
+}
+
+// Thread channel types.
+const (
+	channelAnnouncementThread = 10
+	channelPublicThread       = 11
+	channelPrivateThread      = 12
+)
+
+type discordChannel struct {
+	ID       string `json:"id"`
+	Type     int    `json:"type"`
+	GuildID  string `json:"guild_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+func (c *discordChannel) isThread() bool {
+	switch c.Type {
+	case channelAnnouncementThread, channelPublicThread, channelPrivateThread:
+		return true
+	}
+	return false
+}
+
+type messageReference struct {
+	MessageID       string `json:"message_id,omitempty"`
+	FailIfNotExists *bool  `json:"fail_if_not_exists,omitempty"`
+}
+
+// Message is a Discord message; incoming messages carry it as
+// ConnectorMessage.MessageObject.
+type Message struct {
+	ID        string            `json:"id"`
+	Type      int               `json:"type"`
+	ChannelID string            `json:"channel_id"`
+	GuildID   string            `json:"guild_id,omitempty"`
+	Author    discordUser       `json:"author"`
+	Content   string            `json:"content"`
+	Mentions  []discordUser     `json:"mentions"`
+	WebhookID string            `json:"webhook_id,omitempty"`
+	Reference *messageReference `json:"message_reference,omitempty"`
+}
+
+type allowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+type outgoingMessage struct {
+	Content         string            `json:"content"`
+	AllowedMentions *allowedMentions  `json:"allowed_mentions,omitempty"`
+	Reference       *messageReference `json:"message_reference,omitempty"`
+	Flags           int               `json:"flags,omitempty"`
+}
+
+// call makes one REST call, waiting out rate limits and retrying server
+// errors.
+func (dc *discordConnector) call(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	delay := time.Second
+	var err error
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = dc.callOnce(method, dc.apiURL+path, body, out)
+		status, _ := apiErrorCode(err)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, errUnauthorized):
+			return err
+		case status != 0 && status != http.StatusTooManyRequests && status < 500:
+			return err
+		}
+		if attempt < sendAttempts {
+			dc.retrySleep(max(delay, retryAfter))
+			delay *= 2
+		}
+	}
+	return err
+}
+
+func (dc *discordConnector) callOnce(method, endpoint string, body []byte, out interface{}) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bot "+dc.token)
+	req.Header.Set("User-Agent", userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := dc.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode == http.StatusUnauthorized {
+		return 0, errUnauthorized
+	}
+	if resp.StatusCode >= 300 {
+		ae := &apiError{}
+		json.Unmarshal(respBody, ae)
+		ae.Status = resp.StatusCode
+		if ae.Message == "" {
+			ae.Message = strings.TrimSpace(string(respBody))
+		}
+		retryAfter := time.Duration(math.Ceil(ae.RetryAfter*1000)) * time.Millisecond
+		if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && retryAfter == 0 {
+			retryAfter = time.Duration(secs * float64(time.Second))
+		}
+		return retryAfter, ae
+	}
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return 0, fmt.Errorf("decoding Discord response: %w", err)
+		}
+	}
+	return 0, nil
+}
+
+func (dc *discordConnector) getCurrentUser() (discordUser, error) {
+	var u discordUser
+	err := dc.call(http.MethodGet, "/users/@me", nil, &u)
+	return u, err
+}
+
+func (dc *discordConnector) getUser(id string) (discordUser, error) {
+	var u discordUser
+	err := dc.call(http.MethodGet, "/users/"+url.PathEscape(id), nil, &u)
+	return u, err
+}
+
+func (dc *discordConnector) getChannel(id string) (discordChannel, error) {
+	var c discordChannel
+	err := dc.call(http.MethodGet, "/channels/"+url.PathEscape(id), nil, &c)
+	return c, err
+}
+
+func (dc *discordConnector) getGatewayURL() (string, error) {
+	var g struct {
+		URL string `json:"url"`
+	}
+	err := dc.call(http.MethodGet, "/gateway/bot", nil, &g)
+	return g.URL, err
+}
+
+func (dc *discordConnector) createMessage(channelID string, m *outgoingMessage) (Message, error) {
+	var res Message
+	err := dc.call(http.MethodPost, "/channels/"+url.PathEscape(channelID)+"/messages", m, &res)
+	return res, err
+}
+
+// startThread opens a thread on a message; the thread gets the message's ID.
+func (dc *discordConnector) startThread(channelID, messageID, name string) (discordChannel, error) {
+	var res discordChannel
+	path := "/channels/" + url.PathEscape(channelID) + "/messages/" + url.PathEscape(messageID) + "/threads"
+	err := dc.call(http.MethodPost, path, map[string]string{"name": name}, &res)
+	return res, err
+}
+
+func (dc *discordConnector) createDM(userID string) (discordChannel, error) {
+	var res discordChannel
+	err := dc.call(http.MethodPost, "/users/@me/channels", map[string]string{"recipient_id": userID}, &res)
+	return res, err
+}
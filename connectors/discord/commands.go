@@ -0,0 +1,401 @@
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Plugin commands become slash commands. An invocation is answered with
+// the equivalent command text, which then goes to the engine as a message
+// to the robot, so matching, authorization and replies work as if the
+// user had typed it.
+
+// Discord's limits for application commands.
+const (
+	maxCommands       = 100
+	maxOptions        = 25
+	maxChoices        = 25
+	maxNameLength     = 32
+	maxDescription    = 100
+	maxChoiceValueLen = 100
+)
+
+// Application command option types.
+const (
+	optionString  = 3
+	optionInteger = 4
+	optionBoolean = 5
+	optionNumber  = 10
+)
+
+const (
+	commandChatInput              = 1
+	interactionApplicationCommand = 2
+	responseChannelMessage        = 4
+	flagEphemeral                 = 1 << 6
+)
+
+var (
+	commandNameRe = regexp.MustCompile(`[^-_a-z0-9]+`)
+	optionNameRe  = regexp.MustCompile(`[^_a-z0-9]+`)
+)
+
+type applicationCommand struct {
+	Name        string          `json:"name"`
+	Type        int             `json:"type"`
+	Description string          `json:"description"`
+	Options     []commandOption `json:"options,omitempty"`
+}
+
+type commandOption struct {
+	Type        int             `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Required    bool            `json:"required,omitempty"`
+	Choices     []commandChoice `json:"choices,omitempty"`
+}
+
+type commandChoice struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// slashCommand ties a registered command to the schema it came from.
+type slashCommand struct {
+	schema  robot.CommandSchema
+	options []string // option name of each argument
+}
+
+// Interaction is a slash command invocation; commands carry it as
+// ConnectorMessage.MessageObject.
+type Interaction struct {
+	ID        string `json:"id"`
+	Type      int    `json:"type"`
+	Token     string `json:"token"`
+	GuildID   string `json:"guild_id,omitempty"`
+	ChannelID string `json:"channel_id"`
+	Member    *struct {
+		User discordUser `json:"user"`
+	} `json:"member,omitempty"`
+	User *discordUser `json:"user,omitempty"`
+	Data struct {
+		Name    string              `json:"name"`
+		Options []interactionOption `json:"options"`
+	} `json:"data"`
+}
+
+type interactionOption struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+// text is the option's value as typed; numbers and booleans arrive as
+// JSON literals.
+func (o interactionOption) text() string {
+	var s string
+	if json.Unmarshal(o.Value, &s) == nil {
+		return s
+	}
+	return string(o.Value)
+}
+
+type interactionResponse struct {
+	Type int              `json:"type"`
+	Data *outgoingMessage `json:"data,omitempty"`
+}
+
+func commandName(words []string) string {
+	name := commandNameRe.ReplaceAllString(strings.ToLower(strings.Join(words, "-")), "")
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength]
+	}
+	return strings.Trim(name, "-_")
+}
+
+func optionName(name string) string {
+	name = optionNameRe.ReplaceAllString(strings.ToLower(name), "_")
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength]
+	}
+	return strings.Trim(name, "_")
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
+// buildCommands turns command schemas into application commands, keeping
+// the first of any that end up with the same name.
+func buildCommands(schemas []robot.CommandSchema, h robot.Handler) ([]applicationCommand, map[string]slashCommand) {
+	commands := []applicationCommand{}
+	byName := make(map[string]slashCommand)
+	for _, s := range schemas {
+		name := commandName(s.Words)
+		if name == "" {
+			h.Log(robot.Debug, "No Discord slash command name for command '%s' in plugin '%s'", s.Command, s.Plugin)
+			continue
+		}
+		if _, dup := byName[name]; dup {
+			h.Log(robot.Debug, "Discord slash command '/%s' already registered; skipping command '%s' in plugin '%s'", name, s.Command, s.Plugin)
+			continue
+		}
+		if len(s.Arguments) > maxOptions {
+			h.Log(robot.Debug, "Command '%s' in plugin '%s' has too many arguments for a Discord slash command", s.Command, s.Plugin)
+			continue
+		}
+		if len(commands) == maxCommands {
+			h.Log(robot.Warn, "Discord allows %d slash commands; not registering the rest", maxCommands)
+			break
+		}
+		sc, options, ok := commandOptions(s)
+		if !ok {
+			h.Log(robot.Debug, "Command '%s' in plugin '%s' has arguments Discord can't name", s.Command, s.Plugin)
+			continue
+		}
+		description := s.Summary
+		if description == "" {
+			description = fmt.Sprintf("The %s command from %s", s.Command, s.Plugin)
+		}
+		commands = append(commands, applicationCommand{
+			Name:        name,
+			Type:        commandChatInput,
+			Description: truncate(description, maxDescription),
+			Options:     options,
+		})
+		byName[name] = sc
+	}
+	return commands, byName
+}
+
+// commandOptions lists the options of a command; Discord requires the
+// required ones first.
+func commandOptions(s robot.CommandSchema) (slashCommand, []commandOption, bool) {
+	sc := slashCommand{schema: s, options: make([]string, len(s.Arguments))}
+	var required, optional []commandOption
+	seen := make(map[string]bool)
+	for i, arg := range s.Arguments {
+		opt := commandOption{
+			Type:        optionString,
+			Name:        optionName(arg.Name),
+			Description: arg.Description,
+			Required:    !arg.Optional,
+		}
+		if opt.Name == "" || seen[opt.Name] {
+			return slashCommand{}, nil, false
+		}
+		seen[opt.Name] = true
+		switch arg.Type {
+		case "number":
+			opt.Type = optionInteger
+		case "decimal":
+			opt.Type = optionNumber
+		case "bool":
+			opt.Type = optionBoolean
+		case "choice":
+			opt.Choices = commandChoices(arg.Choices)
+		}
+		if opt.Description == "" {
+			opt.Description = arg.Type
+			if arg.Type == "rest" {
+				opt.Description = "text"
+			}
+		}
+		opt.Description = truncate(opt.Description, maxDescription)
+		sc.options[i] = opt.Name
+		if opt.Required {
+			required = append(required, opt)
+		} else {
+			optional = append(optional, opt)
+		}
+	}
+	return sc, append(required, optional...), true
+}
+
+// commandChoices offers fixed values in a menu when Discord can hold them.
+func commandChoices(values []string) []commandChoice {
+	if len(values) > maxChoices {
+		return nil
+	}
+	choices := make([]commandChoice, 0, len(values))
+	for _, v := range values {
+		if len(v) > maxChoiceValueLen {
+			return nil
+		}
+		choices = append(choices, commandChoice{Name: v, Value: v})
+	}
+	return choices
+}
+
+// commandText rebuilds the command a user would have typed. Optional
+// groups only appear when all of their arguments were given.
+func commandText(sc slashCommand, values map[string]string) (string, error) {
+	value := func(arg int) string {
+		return strings.TrimSpace(values[sc.options[arg-1]])
+	}
+	complete := make(map[int]bool)
+	for _, p := range sc.schema.Parts {
+		if p.Group == 0 {
+			continue
+		}
+		if _, ok := complete[p.Group]; !ok {
+			complete[p.Group] = true
+		}
+		if p.Argument > 0 && value(p.Argument) == "" {
+			complete[p.Group] = false
+		}
+	}
+	words := make([]string, 0, len(sc.schema.Parts))
+	for _, p := range sc.schema.Parts {
+		if p.Group > 0 && !complete[p.Group] {
+			continue
+		}
+		if p.Argument == 0 {
+			words = append(words, p.Literal)
+			continue
+		}
+		v := value(p.Argument)
+		if v == "" {
+			if sc.schema.Arguments[p.Argument-1].Optional {
+				continue
+			}
+			return "", fmt.Errorf("missing a value for '%s'", sc.options[p.Argument-1])
+		}
+		words = append(words, v)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// syncCommands registers the plugin commands as slash commands, skipping
+// the call when nothing changed since the last registration.
+func (dc *discordConnector) syncCommands() {
+	if !dc.slashEnabled {
+		return
+	}
+	dir, ok := dc.Handler.(robot.CommandDirectory)
+	if !ok {
+		dc.Log(robot.Debug, "The engine doesn't describe its commands; not registering Discord slash commands")
+		return
+	}
+	dc.RLock()
+	appID := dc.appID
+	dc.RUnlock()
+	if appID == "" {
+		return
+	}
+	dc.commandLock.Lock()
+	defer dc.commandLock.Unlock()
+	commands, byName := buildCommands(dir.CommandSchemas(), dc.Handler)
+	body, err := json.Marshal(commands)
+	if err != nil {
+		dc.Log(robot.Error, "Encoding Discord slash commands: %v", err)
+		return
+	}
+	if !bytes.Equal(body, dc.registered) {
+		path := "/applications/" + url.PathEscape(appID) + "/commands"
+		if dc.guildID != "" {
+			path = "/applications/" + url.PathEscape(appID) + "/guilds/" + url.PathEscape(dc.guildID) + "/commands"
+		}
+		if err := dc.call(http.MethodPut, path, commands, nil); err != nil {
+			dc.Log(robot.Error, "Registering Discord slash commands: %v", err)
+			return
+		}
+		dc.registered = body
+		dc.Log(robot.Info, "Registered %d Discord slash command(s)", len(commands))
+	}
+	dc.Lock()
+	dc.slashCommands = byName
+	dc.Unlock()
+}
+
+// handleInteraction answers a slash command with the command text, then
+// hands that text to the engine.
+func (dc *discordConnector) handleInteraction(i *Interaction) {
+	if i.Type != interactionApplicationCommand {
+		dc.Log(robot.Trace, "Ignoring Discord interaction of type %d", i.Type)
+		return
+	}
+	user := i.User
+	if i.Member != nil {
+		user = &i.Member.User
+	}
+	if user == nil || user.ID == "" {
+		dc.Log(robot.Debug, "Ignoring Discord interaction '%s' without a user", i.ID)
+		return
+	}
+	dc.cacheUser(*user)
+	dc.RLock()
+	sc, ok := dc.slashCommands[i.Data.Name]
+	dc.RUnlock()
+	if !ok {
+		dc.respondEphemeral(i, "This command isn't available any more.")
+		return
+	}
+	values := make(map[string]string, len(i.Data.Options))
+	for _, o := range i.Data.Options {
+		values[o.Name] = o.text()
+	}
+	text, err := commandText(sc, values)
+	if err != nil {
+		dc.respondEphemeral(i, "Sorry, "+err.Error()+".")
+		return
+	}
+	messageID, err := dc.respond(i, &outgoingMessage{
+		Content:         codeSpan(text),
+		AllowedMentions: &allowedMentions{Parse: []string{}},
+	})
+	if err != nil {
+		dc.Log(robot.Error, "Answering Discord slash command '/%s': %v", i.Data.Name, err)
+		return
+	}
+	botMsg := &robot.ConnectorMessage{
+		Protocol:      "discord",
+		UserID:        user.ID,
+		MessageID:     messageID,
+		BotMessage:    true,
+		MessageText:   text,
+		MessageObject: i,
+	}
+	dc.setConversation(botMsg, i.GuildID, i.ChannelID, messageID)
+	if name, ok := dc.configuredCanonicalUser(user.ID); ok {
+		botMsg.UserName = name
+		botMsg.ValidatedUser = true
+	}
+	dc.IncomingMessage(botMsg)
+}
+
+// respond answers an interaction with a message and returns its ID.
+func (dc *discordConnector) respond(i *Interaction, m *outgoingMessage) (string, error) {
+	var res struct {
+		Resource struct {
+			Message Message `json:"message"`
+		} `json:"resource"`
+	}
+	path := "/interactions/" + url.PathEscape(i.ID) + "/" + url.PathEscape(i.Token) + "/callback?with_response=true"
+	err := dc.call(http.MethodPost, path, interactionResponse{Type: responseChannelMessage, Data: m}, &res)
+	return res.Resource.Message.ID, err
+}
+
+func (dc *discordConnector) respondEphemeral(i *Interaction, text string) {
+	if _, err := dc.respond(i, &outgoingMessage{Content: text, Flags: flagEphemeral}); err != nil {
+		dc.Log(robot.Error, "Answering Discord slash command '/%s': %v", i.Data.Name, err)
+	}
+}
+
+// codeSpan shows text as inline code, even when it has backticks.
+func codeSpan(text string) string {
+	if !strings.Contains(text, "`") {
+		return "`" + text + "`"
+	}
+	return "`` " + strings.ReplaceAll(text, "``", "`\u200b`") + " ``"
+}
@@ -0,0 +1,70 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestBuildCommandsOrdersAndTypesOptions(t *testing.T) {
+	schemas := []robot.CommandSchema{
+		{
+			Plugin: "logging", Command: "setlines", Words: []string{"Set", "log", "lines?"},
+			Arguments: []robot.CommandArgument{
+				{Name: "page", Type: "number", Optional: true},
+				{Name: "level", Type: "choice", Choices: []string{"debug", "info"}},
+				{Name: "Ratio", Type: "decimal"},
+			},
+		},
+		{Plugin: "other", Command: "dup", Words: []string{"set", "log", "lines"}},
+		{Plugin: "other", Command: "noname", Words: []string{"???"}},
+	}
+	commands, byName := buildCommands(schemas, &testHandler{})
+	if len(commands) != 1 || len(byName) != 1 {
+		t.Fatalf("commands = %+v", commands)
+	}
+	c := commands[0]
+	if c.Name != "set-log-lines" || c.Description != "The setlines command from logging" {
+		t.Fatalf("command = %+v", c)
+	}
+	var got []string
+	for _, o := range c.Options {
+		got = append(got, o.Name)
+	}
+	if strings.Join(got, ",") != "level,ratio,page" {
+		t.Fatalf("option order = %v", got)
+	}
+	if c.Options[0].Type != optionString || len(c.Options[0].Choices) != 2 || !c.Options[0].Required {
+		t.Fatalf("choice option = %+v", c.Options[0])
+	}
+	if c.Options[1].Type != optionNumber || c.Options[2].Type != optionInteger || c.Options[2].Required {
+		t.Fatalf("numeric options = %+v", c.Options[1:])
+	}
+	if sc := byName["set-log-lines"]; strings.Join(sc.options, ",") != "page,level,ratio" {
+		t.Fatalf("argument options = %v", sc.options)
+	}
+}
+
+func TestCommandTextDropsIncompleteGroups(t *testing.T) {
+	sc := slashCommand{
+		schema: robot.CommandSchema{
+			Arguments: []robot.CommandArgument{{Name: "page", Type: "number", Optional: true}, {Name: "who", Type: "token"}},
+			Parts: []robot.CommandPart{
+				{Literal: "show"}, {Literal: "log"},
+				{Literal: "page", Group: 1}, {Argument: 1, Group: 1},
+				{Literal: "for"}, {Argument: 2},
+			},
+		},
+		options: []string{"page", "who"},
+	}
+	if text, err := commandText(sc, map[string]string{"who": "bob"}); err != nil || text != "show log for bob" {
+		t.Fatalf("without page = %q, %v", text, err)
+	}
+	if text, err := commandText(sc, map[string]string{"who": "bob", "page": "2"}); err != nil || text != "show log page 2 for bob" {
+		t.Fatalf("with page = %q, %v", text, err)
+	}
+	if _, err := commandText(sc, map[string]string{"page": "2"}); err == nil {
+		t.Fatal("missing required argument accepted")
+	}
+}
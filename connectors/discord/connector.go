@@ -0,0 +1,482 @@
+// Package discord implements the robot.Connector interface for Discord,
+// reading events from the gateway websocket and sending with the REST API.
+// Plugin commands with a SimpleMatcher are registered as slash commands.
+package discord
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	defaultAPIURL          = "https://discord.com/api/v10"
+	defaultMaxMessageSplit = 2
+	maxMessageLength       = 2000 // Discord's limit for message content
+	maxThreadName          = 100
+	apiTimeout             = 30 * time.Second
+	sendAttempts           = 3
+	reconnectDelay         = 2 * time.Second
+	reconnectMaxDelay      = 2 * time.Minute
+	userAgent              = "DiscordBot (https://github.com/lnxjedi/gopherbot, 2)"
+)
+
+type config struct {
+	BotToken             string            // from the application's Bot page
+	APIURL               string            // REST API base, with the version
+	GatewayURL           string            // skips the gateway lookup when set
+	GuildID              string            // register slash commands in one guild instead of globally
+	DisableSlashCommands bool              // don't register plugin commands
+	MaxMessageSplit      int               // the maximum number of messages for one long send
+	Channels             map[string]string // channel name to Discord channel ID
+	UserMap              map[string]string // username to Discord user ID
+}
+
+type discordConnector struct {
+	robot.Handler
+	sync.RWMutex                              // protects the fields and maps below
+	channelMap      map[string]string         // configured channel name to ID
+	userMap         map[string]string         // configured username to user ID
+	channels        map[string]discordChannel // learned channels and threads
+	users           map[string]discordUser    // learned users
+	directChannels  map[string]string         // user ID to DM channel ID
+	slashCommands   map[string]slashCommand   // registered command name to schema
+	appID           string                    // application ID, from READY
+	token           string
+	apiURL          string
+	gatewayURL      string
+	guildID         string
+	slashEnabled    bool
+	botID           string
+	botName         string
+	maxMessageSplit int
+
+	httpClient     *http.Client
+	dialer         *websocket.Dialer
+	session        gatewaySession
+	reconnectDelay time.Duration
+	commandLock    sync.Mutex // serializes slash command registration
+	registered     []byte     // the last command list Discord accepted
+	sendLock       sync.Mutex // keeps outbound messages in order
+	retrySleep     func(time.Duration)
+	invalidSleep   func()
+}
+
+func normalizeMap(kind string, in map[string]string, h robot.Handler) map[string]string {
+	out := make(map[string]string, len(in))
+	for name, id := range in {
+		n := strings.TrimSpace(name)
+		v := strings.TrimSpace(id)
+		if n == "" || v == "" {
+			h.Log(robot.Warn, "Ignoring invalid Discord %s entry (empty name or ID): %q -> %q", kind, name, id)
+			continue
+		}
+		if kind == "UserMap" && strings.ToLower(n) != n {
+			h.Log(robot.Warn, "Ignoring Discord UserMap entry with uppercase username: %q", name)
+			continue
+		}
+		out[n] = v
+	}
+	return out
+}
+
+func newConnector(handler robot.Handler, c config) (*discordConnector, error) {
+	token := strings.TrimSpace(c.BotToken)
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bot "))
+	if token == "" {
+		return nil, fmt.Errorf("Discord protocol config requires BotToken")
+	}
+	if c.APIURL == "" {
+		c.APIURL = defaultAPIURL
+	}
+	if _, err := url.Parse(c.APIURL); err != nil {
+		return nil, fmt.Errorf("invalid Discord APIURL %q: %w", c.APIURL, err)
+	}
+	if c.MaxMessageSplit <= 0 {
+		c.MaxMessageSplit = defaultMaxMessageSplit
+	}
+	return &discordConnector{
+		Handler:         handler,
+		channelMap:      normalizeMap("Channels", c.Channels, handler),
+		userMap:         normalizeMap("UserMap", c.UserMap, handler),
+		channels:        make(map[string]discordChannel),
+		users:           make(map[string]discordUser),
+		directChannels:  make(map[string]string),
+		slashCommands:   make(map[string]slashCommand),
+		token:           token,
+		apiURL:          strings.TrimSuffix(c.APIURL, "/"),
+		gatewayURL:      strings.TrimSpace(c.GatewayURL),
+		guildID:         strings.TrimSpace(c.GuildID),
+		slashEnabled:    !c.DisableSlashCommands,
+		maxMessageSplit: c.MaxMessageSplit,
+		httpClient:      &http.Client{Timeout: apiTimeout},
+		dialer:          websocket.DefaultDialer,
+		reconnectDelay:  reconnectDelay,
+		retrySleep:      time.Sleep,
+		invalidSleep:    func() { time.Sleep(time.Second + rand.N(4*time.Second)) },
+	}, nil
+}
+
+// Initialize validates the token by looking up the bot user; the gateway
+// connects in Run.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to retrieve Discord protocol configuration: %w", err)}
+	}
+	dc, err := newConnector(handler, c)
+	if err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	me, err := dc.getCurrentUser()
+	if err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("looking up the Discord bot user: %w", err)}
+	}
+	dc.botID = me.ID
+	dc.botName = me.Username
+	handler.Log(robot.Info, "Discord connector using bot user '%s' (%s)", dc.botName, dc.botID)
+	handler.SetBotID(dc.botID)
+	handler.SetBotMention(dc.botName)
+	return robot.InitializedConnector{Connector: dc}
+}
+
+// Reload swaps in the channel and user maps and re-registers slash
+// commands if the plugin commands changed; token changes need a restart.
+func (dc *discordConnector) Reload() error {
+	var c config
+	if err := dc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Discord protocol configuration: %w", err)
+	}
+	channels := normalizeMap("Channels", c.Channels, dc.Handler)
+	users := normalizeMap("UserMap", c.UserMap, dc.Handler)
+	dc.Lock()
+	dc.channelMap = channels
+	dc.userMap = users
+	dc.Unlock()
+	dc.Log(robot.Info, "Discord connector reloaded %d channel(s) and %d user mapping(s)", len(channels), len(users))
+	go dc.syncCommands()
+	return nil
+}
+
+// channelID resolves a bracketed ID, a configured or learned channel name,
+// or a bare snowflake.
+func (dc *discordConnector) channelID(ch string) (string, bool) {
+	if id, ok := util.ExtractID(ch); ok {
+		return id, true
+	}
+	ch = strings.TrimPrefix(ch, "#")
+	dc.RLock()
+	defer dc.RUnlock()
+	if id, ok := dc.channelMap[ch]; ok {
+		return id, true
+	}
+	for id, c := range dc.channels {
+		if c.Name == ch && !c.isThread() {
+			return id, true
+		}
+	}
+	if isSnowflake(ch) {
+		return ch, true
+	}
+	return "", false
+}
+
+// channelName prefers the configured name for a channel ID.
+func (dc *discordConnector) channelName(id string) string {
+	dc.RLock()
+	defer dc.RUnlock()
+	for name, cid := range dc.channelMap {
+		if cid == id {
+			return name
+		}
+	}
+	return dc.channels[id].Name
+}
+
+// channel returns a cached channel, fetching it on a miss.
+func (dc *discordConnector) channel(id string) (discordChannel, bool) {
+	dc.RLock()
+	c, ok := dc.channels[id]
+	dc.RUnlock()
+	if ok {
+		return c, true
+	}
+	c, err := dc.getChannel(id)
+	if err != nil {
+		dc.Log(robot.Debug, "Looking up Discord channel '%s': %v", id, err)
+		return discordChannel{}, false
+	}
+	dc.cacheChannel(c)
+	return c, true
+}
+
+func (dc *discordConnector) cacheChannel(c discordChannel) {
+	if c.ID == "" {
+		return
+	}
+	dc.Lock()
+	dc.channels[c.ID] = c
+	dc.Unlock()
+}
+
+func (dc *discordConnector) cacheUser(u discordUser) {
+	if u.ID == "" {
+		return
+	}
+	dc.Lock()
+	dc.users[u.ID] = u
+	dc.Unlock()
+}
+
+// userID resolves a bracketed ID or a configured username.
+func (dc *discordConnector) userID(u string) (string, bool) {
+	if id, ok := util.ExtractID(u); ok {
+		return id, true
+	}
+	dc.RLock()
+	defer dc.RUnlock()
+	id, ok := dc.userMap[strings.ToLower(strings.TrimSpace(u))]
+	return id, ok
+}
+
+func (dc *discordConnector) configuredCanonicalUser(id string) (string, bool) {
+	dc.RLock()
+	defer dc.RUnlock()
+	for name, uid := range dc.userMap {
+		if uid == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (dc *discordConnector) user(id string) (discordUser, bool) {
+	dc.RLock()
+	u, ok := dc.users[id]
+	dc.RUnlock()
+	if ok {
+		return u, true
+	}
+	u, err := dc.getUser(id)
+	if err != nil {
+		dc.Log(robot.Error, "Looking up Discord user '%s': %v", id, err)
+		return discordUser{}, false
+	}
+	dc.cacheUser(u)
+	return u, true
+}
+
+// GetProtocolUserAttribute returns what Discord shares about a user; bots
+// can't see email addresses.
+func (dc *discordConnector) GetProtocolUserAttribute(u, attr string) (value string, ret robot.RetVal) {
+	id, ok := dc.userID(u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	if attr == "internalid" {
+		return id, robot.Ok
+	}
+	user, ok := dc.user(id)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	switch attr {
+	case "realname", "fullname", "real name", "full name":
+		return user.displayName(), robot.Ok
+	case "username":
+		return user.Username, robot.Ok
+	default:
+		return "", robot.AttributeNotFound
+	}
+}
+
+// MessageHeard shows a typing indicator.
+func (dc *discordConnector) MessageHeard(user, channel string) {
+	var target string
+	if channel == "" {
+		uid, ok := util.ExtractID(user)
+		if !ok {
+			return
+		}
+		dc.RLock()
+		target = dc.directChannels[uid]
+		dc.RUnlock()
+	} else if id, ok := util.ExtractID(channel); ok {
+		target = id
+	}
+	if target == "" {
+		return
+	}
+	go func() {
+		endpoint := dc.apiURL + "/channels/" + url.PathEscape(target) + "/typing"
+		if _, err := dc.callOnce(http.MethodPost, endpoint, nil, nil); err != nil {
+			dc.Log(robot.Debug, "Discord typing indicator failed: %v", err)
+		}
+	}()
+}
+
+func (dc *discordConnector) DefaultHelp() []string {
+	return nil
+}
+
+// JoinChannel is a no-op; a bot sees every channel its roles allow.
+func (dc *discordConnector) JoinChannel(c string) robot.RetVal {
+	return robot.Ok
+}
+
+// SendProtocolChannelThreadMessage posts in a channel, or in a thread
+// started from message thr.
+func (dc *discordConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	chID, ok := dc.channelID(ch)
+	if !ok {
+		dc.Log(robot.Error, "Discord channel ID not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	return dc.sendChannel(chID, thr, "", msg, f, msgObject)
+}
+
+// SendProtocolUserChannelThreadMessage posts in a channel, mentioning the
+// user.
+func (dc *discordConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	chID, ok := dc.channelID(ch)
+	if !ok {
+		dc.Log(robot.Error, "Discord channel ID not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	id, ok := dc.userID(uid)
+	if !ok {
+		id, ok = dc.userID(u)
+	}
+	prefix := u + ": "
+	if ok {
+		prefix = "<@" + id + "> "
+	} else {
+		dc.Log(robot.Debug, "No Discord user ID for '%s'; sending without a mention", u)
+	}
+	return dc.sendChannel(chID, thr, prefix, msg, f, msgObject)
+}
+
+// SendProtocolUserMessage sends a direct message, opening the DM channel
+// if needed.
+func (dc *discordConnector) SendProtocolUserMessage(u, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	id, ok := dc.userID(u)
+	if !ok {
+		dc.Log(robot.Error, "No Discord user ID found for user: %s", u)
+		return robot.UserNotFound
+	}
+	dc.RLock()
+	chID, ok := dc.directChannels[id]
+	dc.RUnlock()
+	if !ok {
+		c, err := dc.createDM(id)
+		if err != nil {
+			dc.Log(robot.Error, "Opening a Discord DM with user '%s': %v", u, err)
+			return robot.FailedMessageSend
+		}
+		dc.cacheChannel(c)
+		dc.Lock()
+		dc.directChannels[id] = c.ID
+		dc.Unlock()
+		chID = c.ID
+	}
+	dc.sendLock.Lock()
+	defer dc.sendLock.Unlock()
+	for _, part := range dc.formatMessage("", msg, f) {
+		if _, err := dc.createMessage(chID, &outgoingMessage{Content: part, AllowedMentions: userMentionsOnly()}); err != nil {
+			dc.Log(robot.Error, "Sending Discord message to user '%s': %v", u, err)
+			return robot.FailedMessageSend
+		}
+	}
+	return robot.Ok
+}
+
+func userMentionsOnly() *allowedMentions {
+	return &allowedMentions{Parse: []string{"users"}}
+}
+
+// sendChannel sends every part of a message in order. A thread ID that
+// isn't a thread yet is a message to start one from; where that isn't
+// possible, e.g. in a DM, the message is sent as a reply instead.
+func (dc *discordConnector) sendChannel(chID, thr, prefix, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	target := chID
+	var reference *messageReference
+	dc.sendLock.Lock()
+	defer dc.sendLock.Unlock()
+	if thr != "" && thr != chID {
+		if c, ok := dc.channel(thr); ok && c.isThread() {
+			target = thr
+		} else if thread, err := dc.openThread(chID, thr, threadName(msg, thr, msgObject)); err == nil {
+			target = thread
+		} else {
+			dc.Log(robot.Debug, "Replying to Discord message '%s' instead of threading: %v", thr, err)
+			fail := false
+			reference = &messageReference{MessageID: thr, FailIfNotExists: &fail}
+		}
+	}
+	for i, part := range dc.formatMessage(prefix, msg, f) {
+		m := &outgoingMessage{Content: part, AllowedMentions: userMentionsOnly()}
+		if i == 0 {
+			m.Reference = reference
+		}
+		if _, err := dc.createMessage(target, m); err != nil {
+			dc.Log(robot.Error, "Sending Discord message to channel '%s': %v", target, err)
+			return robot.FailedMessageSend
+		}
+	}
+	return robot.Ok
+}
+
+// openThread starts a thread from a message; if one already exists it has
+// the message's ID.
+func (dc *discordConnector) openThread(chID, messageID, name string) (string, error) {
+	thread, err := dc.startThread(chID, messageID, name)
+	if _, code := apiErrorCode(err); code == errCodeThreadExists {
+		return messageID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	dc.cacheChannel(thread)
+	return thread.ID, nil
+}
+
+// threadName uses the text of the message being threaded when the engine
+// passed it along, otherwise the start of the reply.
+func threadName(msg, thr string, msgObject *robot.ConnectorMessage) string {
+	if msgObject != nil && msgObject.MessageID == thr && strings.TrimSpace(msgObject.MessageText) != "" {
+		msg = msgObject.MessageText
+	}
+	name := strings.TrimSpace(msg)
+	if nl := strings.IndexByte(name, '\n'); nl >= 0 {
+		name = strings.TrimSpace(name[:nl])
+	}
+	if utf8.RuneCountInString(name) > maxThreadName {
+		name = string([]rune(name)[:maxThreadName-3]) + "..."
+	}
+	if name == "" {
+		name = "Thread"
+	}
+	return name
+}
+
+func isSnowflake(s string) bool {
+	if len(s) < 15 || len(s) > 20 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
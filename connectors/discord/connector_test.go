@@ -0,0 +1,476 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	botID     = "100000000000000001"
+	aliceID   = "200000000000000002"
+	generalID = "300000000000000003"
+	threadID  = "300000000000000004"
+	dmID      = "300000000000000005"
+	appID     = "400000000000000006"
+)
+
+type testHandler struct {
+	protocolConfig *config
+	botID          string
+	botMention     string
+	schemas        []robot.CommandSchema
+	incoming       chan *robot.ConnectorMessage
+}
+
+func (t *testHandler) IncomingMessage(m *robot.ConnectorMessage) {
+	if t.incoming != nil {
+		t.incoming <- m
+	}
+}
+func (t *testHandler) GetProtocolConfig(v interface{}) error {
+	if t.protocolConfig != nil {
+		*(v.(*config)) = *t.protocolConfig
+	}
+	return nil
+}
+func (t *testHandler) GetBrainConfig(_ interface{}) error         { return nil }
+func (t *testHandler) GetEventStrings() *[]string                 { return nil }
+func (t *testHandler) GetHistoryConfig(_ interface{}) error       { return nil }
+func (t *testHandler) GetBotInfo() robot.BotInfo                  { return robot.BotInfo{} }
+func (t *testHandler) SetBotID(id string)                         { t.botID = id }
+func (t *testHandler) SetTerminalWriter(_ io.Writer)              {}
+func (t *testHandler) SetBotMention(m string)                     { t.botMention = m }
+func (t *testHandler) GetLogLevel() robot.LogLevel                { return robot.Info }
+func (t *testHandler) GetInstallPath() string                     { return "" }
+func (t *testHandler) GetConfigPath() string                      { return "" }
+func (t *testHandler) ReadEncryptedFile(_ string) ([]byte, error) { return nil, nil }
+func (t *testHandler) Log(_ robot.LogLevel, _ string, _ ...interface{}) {
+}
+func (t *testHandler) GetDirectory(_ string) error { return nil }
+func (t *testHandler) CommandSchemas() []robot.CommandSchema {
+	return t.schemas
+}
+
+type request struct {
+	method, path string
+	body         map[string]interface{}
+	list         []interface{}
+}
+
+// fakeDiscord is a stand-in for the REST API and the gateway.
+type fakeDiscord struct {
+	*httptest.Server
+	sync.Mutex
+	requests  []request
+	identify  map[string]interface{}
+	events    chan string
+	closeCode int
+	seen      chan request
+}
+
+func newFakeDiscord(t *testing.T) *fakeDiscord {
+	t.Helper()
+	fd := &fakeDiscord{events: make(chan string, 10), seen: make(chan request, 20)}
+	channels := map[string]discordChannel{
+		generalID: {ID: generalID, Type: 0, GuildID: "g1", Name: "general"},
+		threadID:  {ID: threadID, Type: channelPublicThread, GuildID: "g1", Name: "deploys", ParentID: generalID},
+	}
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	upgrader := websocket.Upgrader{}
+	fd.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gateway/" {
+			fd.serveGateway(t, upgrader, w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bot secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, map[string]interface{}{"message": "401: Unauthorized", "code": 0})
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/api/v10")
+		req := request{method: r.Method, path: path}
+		data, _ := io.ReadAll(r.Body)
+		if len(data) > 0 && data[0] == '[' {
+			json.Unmarshal(data, &req.list)
+		} else if len(data) > 0 {
+			json.Unmarshal(data, &req.body)
+		}
+		fd.Lock()
+		fd.requests = append(fd.requests, req)
+		fd.Unlock()
+		select {
+		case fd.seen <- req:
+		default:
+		}
+		parts := strings.Split(strings.Trim(path, "/"), "/")
+		switch {
+		case path == "/users/@me":
+			writeJSON(w, discordUser{ID: botID, Username: "floyd", Bot: true})
+		case path == "/users/"+aliceID:
+			writeJSON(w, discordUser{ID: aliceID, Username: "alice_s", GlobalName: "Alice Smith"})
+		case path == "/users/@me/channels":
+			writeJSON(w, discordChannel{ID: dmID, Type: 1})
+		case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "channels":
+			c, ok := channels[parts[1]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				writeJSON(w, map[string]interface{}{"message": "Unknown Channel", "code": 10003})
+				return
+			}
+			writeJSON(w, c)
+		case len(parts) == 3 && parts[2] == "messages":
+			writeJSON(w, Message{ID: "500000000000000099", ChannelID: parts[1]})
+		case len(parts) == 5 && parts[4] == "threads":
+			if parts[3] == "500000000000000042" {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJSON(w, map[string]interface{}{"message": "A thread has already been created for this message", "code": 160004})
+				return
+			}
+			writeJSON(w, discordChannel{ID: parts[3], Type: channelPublicThread, ParentID: parts[1], Name: req.body["name"].(string)})
+		case len(parts) == 3 && parts[2] == "typing":
+			w.WriteHeader(http.StatusNoContent)
+		case parts[0] == "applications":
+			w.Write(data)
+		case parts[0] == "interactions":
+			writeJSON(w, map[string]interface{}{"resource": map[string]interface{}{"message": Message{ID: "500000000000000077"}}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(fd.Close)
+	return fd
+}
+
+func (fd *fakeDiscord) serveGateway(t *testing.T, upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if r.URL.Query().Get("v") != "10" {
+		t.Errorf("gateway version = %q", r.URL.RawQuery)
+	}
+	conn.WriteJSON(map[string]interface{}{"op": opHello, "d": map[string]int{"heartbeat_interval": 45000}})
+	var identify struct {
+		Op   int                    `json:"op"`
+		Data map[string]interface{} `json:"d"`
+	}
+	if err := conn.ReadJSON(&identify); err != nil {
+		return
+	}
+	fd.Lock()
+	fd.identify = identify.Data
+	closeCode := fd.closeCode
+	fd.Unlock()
+	if closeCode != 0 {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, "Authentication failed."))
+		return
+	}
+	seq := 0
+	dispatch := func(event, data string) {
+		seq++
+		conn.WriteJSON(map[string]interface{}{"op": opDispatch, "t": event, "s": seq, "d": json.RawMessage(data)})
+	}
+	dispatch("READY", `{"v":10,"user":{"id":"`+botID+`","username":"floyd"},"session_id":"s1","resume_gateway_url":"`+fd.wsURL()+`","application":{"id":"`+appID+`"}}`)
+	dispatch("GUILD_CREATE", `{"id":"g1","name":"Gophers","channels":[{"id":"`+generalID+`","type":0,"name":"general"}],"threads":[{"id":"`+threadID+`","type":11,"name":"deploys","parent_id":"`+generalID+`"}]}`)
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case evt := <-fd.events:
+			parts := strings.SplitN(evt, " ", 2)
+			dispatch(parts[0], parts[1])
+		case <-gone:
+			return
+		}
+	}
+}
+
+func (fd *fakeDiscord) wsURL() string {
+	return "ws" + strings.TrimPrefix(fd.URL, "http") + "/gateway"
+}
+
+func (fd *fakeDiscord) requestsTo(prefix string) []request {
+	fd.Lock()
+	defer fd.Unlock()
+	var out []request
+	for _, r := range fd.requests {
+		if strings.HasPrefix(r.method+" "+r.path, prefix) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (fd *fakeDiscord) waitFor(t *testing.T, prefix string) request {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case r := <-fd.seen:
+			if strings.HasPrefix(r.method+" "+r.path, prefix) {
+				return r
+			}
+		case <-timeout:
+			t.Fatalf("no %s request", prefix)
+		}
+	}
+}
+
+func testConfig(fd *fakeDiscord) *config {
+	return &config{
+		BotToken:   "secret",
+		APIURL:     fd.URL + "/api/v10",
+		GatewayURL: fd.wsURL(),
+		UserMap:    map[string]string{"alice": aliceID},
+	}
+}
+
+func startConnector(t *testing.T, h *testHandler) (*discordConnector, chan struct{}, chan error) {
+	t.Helper()
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	dc := ic.Connector.(*discordConnector)
+	dc.retrySleep = func(time.Duration) {}
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() { errc <- dc.Run(stop) }()
+	return dc, stop, errc
+}
+
+func nextMessage(t *testing.T, h *testHandler) *robot.ConnectorMessage {
+	t.Helper()
+	select {
+	case m := <-h.incoming:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no incoming message")
+	}
+	return nil
+}
+
+func TestInitializeIdentifiesBot(t *testing.T) {
+	fd := newFakeDiscord(t)
+	h := &testHandler{protocolConfig: testConfig(fd)}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	if h.botID != botID || h.botMention != "floyd" {
+		t.Fatalf("bot identity = %q, %q", h.botID, h.botMention)
+	}
+
+	h.protocolConfig.BotToken = "wrong"
+	if ic := Initialize(h, nil); !errors.Is(ic.Error, errUnauthorized) {
+		t.Fatalf("Initialize with a bad token = %v", ic.Error)
+	}
+	h.protocolConfig.BotToken = ""
+	if ic := Initialize(h, nil); ic.Error == nil {
+		t.Fatal("Initialize accepted a config without BotToken")
+	}
+}
+
+func TestRunDeliversMessages(t *testing.T) {
+	fd := newFakeDiscord(t)
+	h := &testHandler{protocolConfig: testConfig(fd), incoming: make(chan *robot.ConnectorMessage, 5)}
+	_, stop, errc := startConnector(t, h)
+
+	fd.events <- `MESSAGE_CREATE {"id":"500000000000000010","type":0,"channel_id":"` + generalID + `","guild_id":"g1","author":{"id":"` + aliceID + `","username":"alice_s"},"content":"<@` + botID + `> ping <#` + generalID + `>","mentions":[{"id":"` + botID + `","username":"floyd"}]}`
+	m := nextMessage(t, h)
+	if m.MessageText != "ping #general" || !m.BotMessage || m.ChannelName != "general" || m.ChannelID != generalID {
+		t.Fatalf("channel message = %+v", m)
+	}
+	if m.UserName != "alice" || !m.ValidatedUser || m.ThreadID != m.MessageID || m.ThreadedMessage {
+		t.Fatalf("channel message user/thread = %+v", m)
+	}
+
+	fd.events <- `MESSAGE_CREATE {"id":"500000000000000011","type":0,"channel_id":"` + threadID + `","guild_id":"g1","author":{"id":"` + aliceID + `","username":"alice_s"},"content":"status"}`
+	m = nextMessage(t, h)
+	if m.ChannelID != generalID || m.ThreadID != threadID || !m.ThreadedMessage || m.BotMessage {
+		t.Fatalf("thread message = %+v", m)
+	}
+
+	fd.events <- `MESSAGE_CREATE {"id":"500000000000000012","type":7,"channel_id":"` + generalID + `","guild_id":"g1","author":{"id":"` + aliceID + `"},"content":""}`
+	fd.events <- `MESSAGE_CREATE {"id":"500000000000000013","type":0,"channel_id":"` + dmID + `","author":{"id":"` + aliceID + `","username":"alice_s"},"content":"hello"}`
+	m = nextMessage(t, h)
+	if !m.DirectMessage || m.ChannelID != "" || m.MessageText != "hello" {
+		t.Fatalf("direct message = %+v", m)
+	}
+
+	fd.Lock()
+	identify := fd.identify
+	fd.Unlock()
+	if identify["token"] != "secret" || identify["intents"] != float64(gatewayIntents) {
+		t.Fatalf("identify = %v", identify)
+	}
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatalf("Run returned %v after stop", err)
+	}
+}
+
+func TestRunStopsOnRejectedToken(t *testing.T) {
+	fd := newFakeDiscord(t)
+	fd.closeCode = 4004
+	h := &testHandler{protocolConfig: testConfig(fd)}
+	_, stop, errc := startConnector(t, h)
+	defer close(stop)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, errUnauthorized) {
+			t.Fatalf("Run = %v, want errUnauthorized", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run kept going after the gateway rejected the token")
+	}
+}
+
+func TestSlashCommandRegistersAndDispatches(t *testing.T) {
+	fd := newFakeDiscord(t)
+	h := &testHandler{
+		protocolConfig: testConfig(fd),
+		incoming:       make(chan *robot.ConnectorMessage, 5),
+		schemas: []robot.CommandSchema{{
+			Plugin: "groups", Command: "remove", Words: []string{"remove"}, Summary: "Remove a user from a group",
+			Arguments: []robot.CommandArgument{
+				{Name: "user", Type: "token"},
+				{Name: "group", Type: "rest", Optional: true},
+			},
+			Parts: []robot.CommandPart{{Literal: "remove"}, {Argument: 1}, {Literal: "from"}, {Argument: 2, Group: 1}, {Literal: "group"}},
+		}},
+	}
+	dc, stop, errc := startConnector(t, h)
+
+	reg := fd.waitFor(t, "PUT /applications/"+appID+"/commands")
+	if len(reg.list) != 1 || reg.list[0].(map[string]interface{})["name"] != "remove" {
+		t.Fatalf("registered commands = %v", reg.list)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		dc.RLock()
+		n := len(dc.slashCommands)
+		dc.RUnlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slash commands not recorded after registering")
+		}
+	}
+
+	fd.events <- `INTERACTION_CREATE {"id":"600000000000000001","type":2,"token":"tok","guild_id":"g1","channel_id":"` + generalID + `","member":{"user":{"id":"` + aliceID + `","username":"alice_s"}},"data":{"name":"remove","options":[{"name":"user","type":3,"value":"bob"},{"name":"group","type":3,"value":"admins"}]}}`
+	m := nextMessage(t, h)
+	if m.MessageText != "remove bob from admins group" || !m.BotMessage || m.UserName != "alice" {
+		t.Fatalf("slash command message = %+v", m)
+	}
+	if m.ChannelID != generalID || m.ThreadID != "500000000000000077" {
+		t.Fatalf("slash command conversation = %+v", m)
+	}
+	reply := fd.requestsTo("POST /interactions/600000000000000001/tok/callback")
+	if len(reply) != 1 || reply[0].body["data"].(map[string]interface{})["content"] != "`remove bob from admins group`" {
+		t.Fatalf("interaction responses = %+v", reply)
+	}
+
+	fd.events <- `INTERACTION_CREATE {"id":"600000000000000002","type":2,"token":"tok2","channel_id":"` + dmID + `","user":{"id":"` + aliceID + `"},"data":{"name":"remove","options":[]}}`
+	r := fd.waitFor(t, "POST /interactions/600000000000000002")
+	if data := r.body["data"].(map[string]interface{}); data["flags"] != float64(flagEphemeral) {
+		t.Fatalf("missing-argument response = %v", data)
+	}
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatalf("Run returned %v after stop", err)
+	}
+}
+
+func TestSendsThreadsAndDMs(t *testing.T) {
+	fd := newFakeDiscord(t)
+	h := &testHandler{protocolConfig: testConfig(fd)}
+	ic := Initialize(h, nil)
+	dc := ic.Connector.(*discordConnector)
+	dc.cacheChannel(discordChannel{ID: generalID, Name: "general", GuildID: "g1"})
+
+	if ret := dc.SendProtocolChannelThreadMessage("general", "", "hi", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("channel send = %v", ret)
+	}
+	orig := &robot.ConnectorMessage{MessageID: "500000000000000010", MessageText: "deploy the app"}
+	if ret := dc.SendProtocolUserChannelThreadMessage("", "alice", "general", "500000000000000010", "on it", robot.Raw, orig); ret != robot.Ok {
+		t.Fatalf("thread send = %v", ret)
+	}
+	if ret := dc.SendProtocolChannelThreadMessage("general", "500000000000000042", "again", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("send to an existing thread = %v", ret)
+	}
+	if ret := dc.SendProtocolUserMessage("alice", "psst", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("DM = %v", ret)
+	}
+	if ret := dc.SendProtocolChannelThreadMessage("missing", "", "hi", robot.Raw, nil); ret != robot.ChannelNotFound {
+		t.Fatalf("send to an unknown channel = %v", ret)
+	}
+
+	threads := fd.requestsTo("POST /channels/" + generalID + "/messages/500000000000000010/threads")
+	if len(threads) != 1 || threads[0].body["name"] != "deploy the app" {
+		t.Fatalf("thread requests = %+v", threads)
+	}
+	posts := fd.requestsTo("POST /channels/")
+	var sent []string
+	for _, p := range posts {
+		if strings.HasSuffix(p.path, "/messages") {
+			sent = append(sent, strings.Split(p.path, "/")[2]+" "+p.body["content"].(string))
+		}
+	}
+	want := []string{
+		generalID + " hi",
+		"500000000000000010 <@" + aliceID + "> on it",
+		"500000000000000042 again",
+		dmID + " psst",
+	}
+	if strings.Join(sent, "|") != strings.Join(want, "|") {
+		t.Fatalf("sent = %q, want %q", sent, want)
+	}
+}
+
+func TestReloadSwapsMaps(t *testing.T) {
+	fd := newFakeDiscord(t)
+	h := &testHandler{protocolConfig: testConfig(fd)}
+	dc := Initialize(h, nil).Connector.(*discordConnector)
+	h.protocolConfig = &config{
+		BotToken: "secret",
+		Channels: map[string]string{"ops": "300000000000000009"},
+		UserMap:  map[string]string{"bob": "200000000000000008", "Carol": "200000000000000007"},
+	}
+	if err := dc.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if id, ok := dc.channelID("ops"); !ok || id != "300000000000000009" {
+		t.Fatalf("channelID(ops) = %q, %v", id, ok)
+	}
+	if _, ok := dc.userID("alice"); ok {
+		t.Fatal("alice still mapped after reload")
+	}
+	if _, ok := dc.userID("carol"); ok {
+		t.Fatal("uppercase UserMap entry was accepted")
+	}
+	if name, ok := dc.configuredCanonicalUser("200000000000000008"); !ok || name != "bob" {
+		t.Fatalf("configuredCanonicalUser = %q, %v", name, ok)
+	}
+}
@@ -0,0 +1,156 @@
+package discord
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Discord renders its own flavor of markdown: bold, italics, strikeout,
+// code spans and fences, quotes, headings, lists and masked links.
+
+const truncatedMessage = "(message too long, truncated)"
+
+var markdownEscapeReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"|", `\|`,
+	"<", `\<`,
+	"[", `\[`,
+	"]", `\]`,
+)
+
+var atMentionRe = regexp.MustCompile(`(^|[^\w.])@([a-z0-9][a-z0-9._-]*)`)
+
+// formatMessage renders msg and splits it into messages Discord accepts.
+func (dc *discordConnector) formatMessage(prefix, msg string, f robot.MessageFormat) []string {
+	var text string
+	switch f {
+	case robot.BasicMarkdown:
+		text = dc.renderBasicMarkdown(msg)
+	case robot.Fixed:
+		text = renderFixed(msg)
+	case robot.Variable:
+		text = renderVariable(msg)
+	default:
+		text = msg
+	}
+	if prefix != "" && strings.HasPrefix(text, "```") {
+		prefix = strings.TrimSpace(prefix) + "\n"
+	}
+	return splitMessage(prefix+text, maxMessageLength, dc.maxMessageSplit)
+}
+
+// renderFixed puts msg in a code block; Discord has no longer fences, so
+// a zero-width space breaks up any fence inside.
+func renderFixed(msg string) string {
+	if strings.TrimSpace(msg) == "" {
+		return ""
+	}
+	return "```\n" + strings.ReplaceAll(msg, "```", "``\u200b`") + "\n```"
+}
+
+// renderVariable escapes markdown so the text shows up as typed.
+func renderVariable(msg string) string {
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		line = markdownEscapeReplacer.Replace(line)
+		trimmed := strings.TrimLeft(line, " ")
+		indent := line[:len(line)-len(trimmed)]
+		switch {
+		case strings.HasPrefix(trimmed, "#"), strings.HasPrefix(trimmed, ">"), strings.HasPrefix(trimmed, "-"):
+			trimmed = `\` + trimmed
+		default:
+			digits := 0
+			for digits < len(trimmed) && trimmed[digits] >= '0' && trimmed[digits] <= '9' {
+				digits++
+			}
+			if digits > 0 && digits < len(trimmed) && trimmed[digits] == '.' {
+				trimmed = trimmed[:digits] + `\` + trimmed[digits:]
+			}
+		}
+		lines[i] = indent + trimmed
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderBasicMarkdown passes markdown through, turning @username for
+// configured users into Discord mentions outside of code.
+func (dc *discordConnector) renderBasicMarkdown(msg string) string {
+	segments := strings.Split(msg, "```")
+	for i := 0; i < len(segments); i += 2 {
+		spans := strings.Split(segments[i], "`")
+		for j := 0; j < len(spans); j += 2 {
+			spans[j] = dc.replaceMentions(spans[j])
+		}
+		segments[i] = strings.Join(spans, "`")
+	}
+	return strings.Join(segments, "```")
+}
+
+func (dc *discordConnector) replaceMentions(text string) string {
+	return atMentionRe.ReplaceAllStringFunc(text, func(match string) string {
+		sub := atMentionRe.FindStringSubmatch(match)
+		name := strings.TrimRight(sub[2], "._-")
+		dc.RLock()
+		id, ok := dc.userMap[name]
+		dc.RUnlock()
+		if !ok {
+			return match
+		}
+		return sub[1] + "<@" + id + ">" + sub[2][len(name):]
+	})
+}
+
+// splitMessage breaks msg into at most maxSplit messages of maxLen
+// characters, preferring newline boundaries and closing/reopening code
+// fences across messages. Text past the last allowed message is dropped
+// with a notice.
+func splitMessage(msg string, maxLen, maxSplit int) []string {
+	if utf8.RuneCountInString(msg) <= maxLen {
+		return []string{msg}
+	}
+	// Room for a closing fence and the truncation notice.
+	limit := maxLen - len(truncatedMessage) - 12
+	chunks := make([]string, 0, maxSplit)
+	for msg != "" {
+		if utf8.RuneCountInString(msg) <= maxLen {
+			chunks = append(chunks, msg)
+			break
+		}
+		cut := byteIndexForRunes(msg, limit)
+		if nl := strings.LastIndexByte(msg[:cut], '\n'); nl > 0 {
+			cut = nl
+		}
+		chunk := msg[:cut]
+		fenced := strings.Count(chunk, "```")%2 == 1
+		if fenced {
+			chunk += "\n```"
+		}
+		if len(chunks) == maxSplit-1 {
+			chunks = append(chunks, chunk+"\n"+truncatedMessage)
+			break
+		}
+		chunks = append(chunks, chunk)
+		msg = strings.TrimPrefix(msg[cut:], "\n")
+		if fenced {
+			msg = "```\n" + msg
+		}
+	}
+	return chunks
+}
+
+func byteIndexForRunes(msg string, runes int) int {
+	for i := range msg {
+		if runes == 0 {
+			return i
+		}
+		runes--
+	}
+	return len(msg)
+}
@@ -0,0 +1,40 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestFormatMessage(t *testing.T) {
+	dc := &discordConnector{userMap: map[string]string{"alice": aliceID}, maxMessageSplit: 2}
+	got := dc.formatMessage("", "hi @alice, see `@alice` and @bob.", robot.BasicMarkdown)
+	if got[0] != "hi <@"+aliceID+">, see `@alice` and @bob." {
+		t.Fatalf("BasicMarkdown = %q", got)
+	}
+	got = dc.formatMessage("<@"+aliceID+"> ", "a ``` b", robot.Fixed)
+	if got[0] != "<@"+aliceID+">\n```\na ``\u200b` b\n```" {
+		t.Fatalf("Fixed = %q", got)
+	}
+	got = dc.formatMessage("", "# not a *heading*\n1. <@1>", robot.Variable)
+	if got[0] != `\# not a \*heading\*`+"\n"+`1\. \<@1>` {
+		t.Fatalf("Variable = %q", got)
+	}
+}
+
+func TestSplitMessageReopensFences(t *testing.T) {
+	msg := "```\n" + strings.Repeat("line of text\n", 500) + "```"
+	parts := splitMessage(msg, maxMessageLength, 3)
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], truncatedMessage) {
+		t.Fatalf("got %d parts", len(parts))
+	}
+	for _, p := range parts[:2] {
+		if len(p) > maxMessageLength || strings.Count(p, "```")%2 != 0 {
+			t.Fatalf("unbalanced or long part: %d bytes", len(p))
+		}
+	}
+	if !strings.HasPrefix(parts[1], "```\n") {
+		t.Fatalf("second part doesn't reopen the fence: %q", parts[1][:20])
+	}
+}
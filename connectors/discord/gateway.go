@@ -0,0 +1,367 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Gateway opcodes.
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// Gateway intents: guild channels and threads, guild and direct messages,
+// and the privileged message content intent, which must be enabled for
+// the application in the Developer Portal.
+const (
+	intentGuilds         = 1 << 0
+	intentGuildMessages  = 1 << 9
+	intentDirectMessages = 1 << 12
+	intentMessageContent = 1 << 15
+	gatewayIntents       = intentGuilds | intentGuildMessages | intentDirectMessages | intentMessageContent
+)
+
+const gatewayQuery = "?v=10&encoding=json"
+
+var errGatewayRefused = errors.New("Discord gateway refused the connection")
+
+// errReconnect ends a session that Discord asked to resume elsewhere.
+var errReconnect = errors.New("Discord gateway requested a reconnect")
+
+type gatewayPayload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d,omitempty"`
+	Sequence *int64          `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+type outgoingPayload struct {
+	Op   int         `json:"op"`
+	Data interface{} `json:"d"`
+}
+
+// gatewaySession is what's needed to resume after a disconnect; only the
+// Run goroutine uses it.
+type gatewaySession struct {
+	id        string
+	resumeURL string
+	seq       int64
+}
+
+// Run reads gateway events until stop is closed, resuming or
+// reconnecting with backoff after transient failures. A rejected token or
+// refused intents end it early.
+func (dc *discordConnector) Run(stop <-chan struct{}) error {
+	delay := dc.reconnectDelay
+	for {
+		connected, err := dc.runSession(stop)
+		select {
+		case <-stop:
+			dc.Log(robot.Debug, "Received stop in connector")
+			return nil
+		default:
+		}
+		if errors.Is(err, errUnauthorized) || errors.Is(err, errGatewayRefused) {
+			return err
+		}
+		if connected {
+			delay = dc.reconnectDelay
+		}
+		if errors.Is(err, errReconnect) {
+			dc.Log(robot.Debug, "Discord gateway asked to reconnect")
+			continue
+		}
+		dc.Log(robot.Warn, "Discord gateway disconnected; reconnecting in %v: %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// runSession runs one websocket connection; connected reports whether the
+// gateway said hello.
+func (dc *discordConnector) runSession(stop <-chan struct{}) (connected bool, err error) {
+	endpoint := dc.session.resumeURL
+	if dc.session.id == "" || endpoint == "" {
+		if endpoint, err = dc.gatewayEndpoint(); err != nil {
+			return false, err
+		}
+	}
+	conn, _, err := dc.dialer.Dial(strings.TrimSuffix(endpoint, "/")+"/"+gatewayQuery, nil)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		conn.Close()
+	}()
+
+	var hello struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	conn.SetReadDeadline(time.Now().Add(apiTimeout))
+	var p gatewayPayload
+	if err := conn.ReadJSON(&p); err != nil {
+		return false, dc.closeError(err)
+	}
+	if p.Op != opHello || json.Unmarshal(p.Data, &hello) != nil || hello.HeartbeatInterval <= 0 {
+		return false, fmt.Errorf("expected a hello from the Discord gateway, got op %d", p.Op)
+	}
+	interval := time.Duration(hello.HeartbeatInterval) * time.Millisecond
+
+	var writeLock sync.Mutex
+	send := func(op int, data interface{}) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(outgoingPayload{Op: op, Data: data})
+	}
+	var ackLock sync.Mutex
+	acked := true
+	seq := dc.session.seq
+	var seqLock sync.Mutex
+	heartbeat := func() error {
+		seqLock.Lock()
+		var d interface{}
+		if seq > 0 {
+			d = seq
+		}
+		seqLock.Unlock()
+		return send(opHeartbeat, d)
+	}
+
+	if dc.session.id != "" {
+		err = send(opResume, map[string]interface{}{
+			"token":      dc.token,
+			"session_id": dc.session.id,
+			"seq":        dc.session.seq,
+		})
+	} else {
+		err = send(opIdentify, map[string]interface{}{
+			"token":   dc.token,
+			"intents": gatewayIntents,
+			"properties": map[string]string{
+				"os":      runtime.GOOS,
+				"browser": "gopherbot",
+				"device":  "gopherbot",
+			},
+		})
+	}
+	if err != nil {
+		return true, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				writeLock.Lock()
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				writeLock.Unlock()
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				ackLock.Lock()
+				zombie := !acked
+				acked = false
+				ackLock.Unlock()
+				if zombie {
+					dc.Log(robot.Warn, "Discord gateway missed a heartbeat acknowledgement; reconnecting")
+					conn.Close()
+					return
+				}
+				if err := heartbeat(); err != nil {
+					dc.Log(robot.Debug, "Discord heartbeat failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2*interval + apiTimeout))
+		var p gatewayPayload
+		if err := conn.ReadJSON(&p); err != nil {
+			return true, dc.closeError(err)
+		}
+		switch p.Op {
+		case opDispatch:
+			if p.Sequence != nil {
+				seqLock.Lock()
+				seq = *p.Sequence
+				seqLock.Unlock()
+				dc.session.seq = *p.Sequence
+			}
+			dc.dispatch(p.Type, p.Data)
+		case opHeartbeat:
+			if err := heartbeat(); err != nil {
+				return true, err
+			}
+		case opHeartbeatACK:
+			ackLock.Lock()
+			acked = true
+			ackLock.Unlock()
+		case opReconnect:
+			return true, errReconnect
+		case opInvalidSession:
+			var resumable bool
+			json.Unmarshal(p.Data, &resumable)
+			if !resumable {
+				dc.session = gatewaySession{}
+			}
+			dc.Log(robot.Info, "Discord gateway invalidated the session (resumable: %t)", resumable)
+			dc.invalidSleep()
+			return true, errReconnect
+		}
+	}
+}
+
+// gatewayEndpoint returns the configured gateway URL or asks for one.
+func (dc *discordConnector) gatewayEndpoint() (string, error) {
+	if dc.gatewayURL != "" {
+		return dc.gatewayURL, nil
+	}
+	u, err := dc.getGatewayURL()
+	if err != nil {
+		return "", err
+	}
+	if u == "" {
+		return "", fmt.Errorf("Discord returned no gateway URL")
+	}
+	return u, nil
+}
+
+// closeError maps the gateway's close codes to errors Run can act on, and
+// drops the session when Discord won't resume it.
+func (dc *discordConnector) closeError(err error) error {
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) {
+		return err
+	}
+	switch ce.Code {
+	case 4004:
+		return errUnauthorized
+	case 4007, 4009:
+		dc.session = gatewaySession{}
+	case 4010, 4011, 4012, 4013:
+		return fmt.Errorf("%w: %d %s", errGatewayRefused, ce.Code, ce.Text)
+	case 4014:
+		return fmt.Errorf("%w: %d %s; enable the Message Content intent for the application", errGatewayRefused, ce.Code, ce.Text)
+	}
+	return err
+}
+
+type readyEvent struct {
+	User             discordUser `json:"user"`
+	SessionID        string      `json:"session_id"`
+	ResumeGatewayURL string      `json:"resume_gateway_url"`
+	Application      struct {
+		ID string `json:"id"`
+	} `json:"application"`
+}
+
+type guildCreateEvent struct {
+	ID       string           `json:"id"`
+	Name     string           `json:"name"`
+	Channels []discordChannel `json:"channels"`
+	Threads  []discordChannel `json:"threads"`
+}
+
+// dispatch handles one gateway event.
+func (dc *discordConnector) dispatch(event string, data json.RawMessage) {
+	switch event {
+	case "READY":
+		var r readyEvent
+		if err := json.Unmarshal(data, &r); err != nil {
+			dc.Log(robot.Error, "Decoding Discord READY: %v", err)
+			return
+		}
+		dc.session.id = r.SessionID
+		dc.session.resumeURL = r.ResumeGatewayURL
+		dc.Lock()
+		dc.appID = r.Application.ID
+		dc.Unlock()
+		dc.Log(robot.Info, "Discord gateway ready as '%s'", r.User.Username)
+		go dc.syncCommands()
+	case "RESUMED":
+		dc.Log(robot.Info, "Discord gateway session resumed")
+	case "GUILD_CREATE":
+		var g guildCreateEvent
+		if err := json.Unmarshal(data, &g); err != nil {
+			dc.Log(robot.Error, "Decoding Discord GUILD_CREATE: %v", err)
+			return
+		}
+		dc.Lock()
+		for _, list := range [][]discordChannel{g.Channels, g.Threads} {
+			for _, c := range list {
+				c.GuildID = g.ID
+				dc.channels[c.ID] = c
+			}
+		}
+		dc.Unlock()
+		dc.Log(robot.Debug, "Discord guild '%s' has %d channel(s) and %d active thread(s)", g.Name, len(g.Channels), len(g.Threads))
+	case "CHANNEL_CREATE", "CHANNEL_UPDATE", "THREAD_CREATE", "THREAD_UPDATE":
+		var c discordChannel
+		if json.Unmarshal(data, &c) == nil {
+			dc.cacheChannel(c)
+		}
+	case "CHANNEL_DELETE", "THREAD_DELETE":
+		var c discordChannel
+		if json.Unmarshal(data, &c) == nil {
+			dc.Lock()
+			delete(dc.channels, c.ID)
+			dc.Unlock()
+		}
+	case "THREAD_LIST_SYNC":
+		var list struct {
+			Threads []discordChannel `json:"threads"`
+		}
+		if json.Unmarshal(data, &list) == nil {
+			for _, c := range list.Threads {
+				dc.cacheChannel(c)
+			}
+		}
+	case "MESSAGE_CREATE":
+		var m Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			dc.Log(robot.Error, "Decoding Discord message: %v", err)
+			return
+		}
+		dc.handleMessage(&m)
+	case "INTERACTION_CREATE":
+		var i Interaction
+		if err := json.Unmarshal(data, &i); err != nil {
+			dc.Log(robot.Error, "Decoding Discord interaction: %v", err)
+			return
+		}
+		// The interaction has to be answered within 3 seconds; don't hold
+		// up the event stream.
+		go dc.handleInteraction(&i)
+	default:
+		dc.Log(robot.Trace, "Ignoring Discord %s event", event)
+	}
+}
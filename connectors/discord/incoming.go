@@ -0,0 +1,106 @@
+package discord
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Message types that carry user text; the rest are system notices.
+const (
+	messageDefault = 0
+	messageReply   = 19
+)
+
+var (
+	userMentionRe    = regexp.MustCompile(`<@!?(\d+)>`)
+	channelMentionRe = regexp.MustCompile(`<#(\d+)>`)
+)
+
+func (dc *discordConnector) handleMessage(m *Message) {
+	if m.Type != messageDefault && m.Type != messageReply {
+		dc.Log(robot.Trace, "Ignoring Discord message '%s' of type %d", m.ID, m.Type)
+		return
+	}
+	if m.WebhookID == "" {
+		dc.cacheUser(m.Author)
+	}
+	text, mentioned := dc.messageText(m)
+	if text == "" {
+		dc.Log(robot.Debug, "Ignoring Discord message '%s' with no text", m.ID)
+		return
+	}
+	botMsg := &robot.ConnectorMessage{
+		Protocol:      "discord",
+		UserID:        m.Author.ID,
+		MessageID:     m.ID,
+		BotMessage:    mentioned,
+		MessageText:   text,
+		MessageObject: m,
+	}
+	dc.setConversation(botMsg, m.GuildID, m.ChannelID, m.ID)
+	if name, ok := dc.configuredCanonicalUser(m.Author.ID); ok {
+		botMsg.UserName = name
+		botMsg.ValidatedUser = true
+	}
+	if m.Author.ID == dc.botID {
+		botMsg.SelfMessage = true
+	}
+	dc.IncomingMessage(botMsg)
+}
+
+// setConversation fills in where a message was sent: a DM, a thread of a
+// guild channel, or the channel itself, where the message's own ID is the
+// thread a reply would start.
+func (dc *discordConnector) setConversation(botMsg *robot.ConnectorMessage, guildID, channelID, messageID string) {
+	if guildID == "" {
+		botMsg.DirectMessage = true
+		if botMsg.UserID != dc.botID {
+			dc.Lock()
+			dc.directChannels[botMsg.UserID] = channelID
+			dc.Unlock()
+		}
+		return
+	}
+	if c, ok := dc.channel(channelID); ok && c.isThread() && c.ParentID != "" {
+		botMsg.ChannelID = c.ParentID
+		botMsg.ChannelName = dc.channelName(c.ParentID)
+		botMsg.ThreadID = channelID
+		botMsg.ThreadedMessage = true
+		return
+	}
+	botMsg.ChannelID = channelID
+	botMsg.ChannelName = dc.channelName(channelID)
+	botMsg.ThreadID = messageID
+}
+
+// messageText replaces mention markup with readable names, dropping
+// mentions of the robot, which it reports.
+func (dc *discordConnector) messageText(m *Message) (string, bool) {
+	mentioned := false
+	text := userMentionRe.ReplaceAllStringFunc(m.Content, func(tag string) string {
+		id := userMentionRe.FindStringSubmatch(tag)[1]
+		if id == dc.botID {
+			mentioned = true
+			return ""
+		}
+		for _, u := range m.Mentions {
+			if u.ID == id {
+				return "@" + u.Username
+			}
+		}
+		return tag
+	})
+	text = channelMentionRe.ReplaceAllStringFunc(text, func(tag string) string {
+		if name := dc.channelName(channelMentionRe.FindStringSubmatch(tag)[1]); name != "" {
+			return "#" + name
+		}
+		return tag
+	})
+	text = strings.TrimSpace(text)
+	if mentioned {
+		text = strings.TrimSpace(strings.TrimLeft(text, ",:"))
+	}
+	return text, mentioned
+}
@@ -0,0 +1,500 @@
+// Package email implements the robot.Connector interface for email: it
+// polls an IMAP mailbox for messages to the robot and replies over SMTP,
+// keeping replies in the sender's thread.
+package email
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	dialTimeout          = 30 * time.Second
+	commandTimeout       = 60 * time.Second
+	defaultPollInterval  = 30 // seconds
+	defaultMaxMessageAge = 60 // minutes
+	defaultMailbox       = "INBOX"
+	reconnectMinDelay    = 2 * time.Second
+	reconnectMaxDelay    = 5 * time.Minute
+	maxSendAttempts      = 3
+	maxSubjectLength     = 60
+)
+
+var errUnauthorized = errors.New("mail server rejected the connector credentials")
+
+type config struct {
+	Address            string // the robot's email address
+	FromName           string // display name on outgoing mail, defaults to the robot's full name
+	IMAPServer         string // host:port, implicit TLS
+	IMAPUser           string
+	IMAPPassword       string
+	Mailbox            string
+	SMTPServer         string // host:port, STARTTLS
+	SMTPUser           string // defaults to IMAPUser
+	SMTPPassword       string // defaults to IMAPPassword
+	DisableTLS         bool   // plain text IMAP and SMTP; only for local testing
+	PollInterval       int    // seconds between mailbox checks
+	MaxMessageAge      int    // minutes; older unseen mail is marked seen and ignored
+	AuthServID         string // authserv-id of the receiving server's Authentication-Results
+	TrustSenderAddress bool   // validate roster users by From alone; only for closed mail systems
+}
+
+type emailConnector struct {
+	robot.Handler
+	sync.RWMutex              // protects authServID and trustSenderAddress
+	address            string // the robot's address, lowercased
+	fromName           string
+	imapServer         string
+	imapUser           string
+	imapPassword       string
+	mailbox            string
+	smtpServer         string
+	smtpUser           string
+	smtpPassword       string
+	useTLS             bool
+	pollInterval       time.Duration
+	maxMessageAge      time.Duration
+	authServID         string
+	trustSenderAddress bool
+
+	sendLock       sync.Mutex // one SMTP session at a time
+	reconnectDelay time.Duration
+	dialIMAP       func(server string, useTLS bool) (*imapClient, error)
+	now            func() time.Time
+}
+
+func validServer(name, server string) error {
+	if server == "" {
+		return fmt.Errorf("email protocol config requires %s", name)
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return fmt.Errorf("invalid email %s %q, want host:port: %w", name, server, err)
+	}
+	return nil
+}
+
+func newConnector(handler robot.Handler, c config) (*emailConnector, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(c.Address))
+	if err != nil {
+		return nil, fmt.Errorf("email protocol config requires a valid Address: %w", err)
+	}
+	if err := validServer("IMAPServer", c.IMAPServer); err != nil {
+		return nil, err
+	}
+	if err := validServer("SMTPServer", c.SMTPServer); err != nil {
+		return nil, err
+	}
+	if c.IMAPUser == "" || c.IMAPPassword == "" {
+		return nil, fmt.Errorf("email protocol config requires IMAPUser and IMAPPassword")
+	}
+	if c.SMTPUser == "" {
+		c.SMTPUser, c.SMTPPassword = c.IMAPUser, c.IMAPPassword
+	}
+	if c.FromName == "" {
+		c.FromName = handler.GetBotInfo().FullName
+	}
+	if c.Mailbox == "" {
+		c.Mailbox = defaultMailbox
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaultPollInterval
+	}
+	if c.MaxMessageAge <= 0 {
+		c.MaxMessageAge = defaultMaxMessageAge
+	}
+	return &emailConnector{
+		Handler:            handler,
+		address:            strings.ToLower(addr.Address),
+		fromName:           c.FromName,
+		imapServer:         c.IMAPServer,
+		imapUser:           c.IMAPUser,
+		imapPassword:       c.IMAPPassword,
+		mailbox:            c.Mailbox,
+		smtpServer:         c.SMTPServer,
+		smtpUser:           c.SMTPUser,
+		smtpPassword:       c.SMTPPassword,
+		useTLS:             !c.DisableTLS,
+		pollInterval:       time.Duration(c.PollInterval) * time.Second,
+		maxMessageAge:      time.Duration(c.MaxMessageAge) * time.Minute,
+		authServID:         c.AuthServID,
+		trustSenderAddress: c.TrustSenderAddress,
+		reconnectDelay:     reconnectMinDelay,
+		dialIMAP:           dialIMAP,
+		now:                time.Now,
+	}, nil
+}
+
+func (ec *emailConnector) warnUnvalidated() {
+	if ec.authServID == "" && !ec.trustSenderAddress {
+		ec.Log(robot.Warn, "Email connector has neither AuthServID nor TrustSenderAddress set; no sender can be a validated user")
+	}
+}
+
+// Initialize validates config and returns the connector; the mailbox is
+// first checked in Run.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to retrieve email protocol configuration: %w", err)}
+	}
+	ec, err := newConnector(handler, c)
+	if err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	if _, ok := handler.(robot.UserDirectory); !ok {
+		handler.Log(robot.Warn, "Email connector handler has no user directory; senders won't map to roster users")
+	}
+	ec.warnUnvalidated()
+	handler.Log(robot.Info, "Email connector using address '%s'", ec.address)
+	handler.SetBotID(ec.address)
+	return robot.InitializedConnector{Connector: ec}
+}
+
+// Reload swaps in the sender validation settings; server and credential
+// changes need a restart.
+func (ec *emailConnector) Reload() error {
+	var c config
+	if err := ec.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve email protocol configuration: %w", err)
+	}
+	ec.Lock()
+	ec.authServID = c.AuthServID
+	ec.trustSenderAddress = c.TrustSenderAddress
+	ec.Unlock()
+	ec.warnUnvalidated()
+	ec.Log(robot.Info, "Email connector reloaded sender validation settings")
+	return nil
+}
+
+// Run polls the mailbox until stop is closed, reconnecting with backoff.
+// Only rejected credentials end it early.
+func (ec *emailConnector) Run(stop <-chan struct{}) error {
+	delay := ec.reconnectDelay
+	for {
+		loggedIn, err := ec.session(stop)
+		select {
+		case <-stop:
+			ec.Log(robot.Debug, "Received stop in connector")
+			return nil
+		default:
+		}
+		if errors.Is(err, errUnauthorized) {
+			return err
+		}
+		if loggedIn {
+			delay = ec.reconnectDelay
+		}
+		ec.Log(robot.Warn, "IMAP connection lost; reconnecting in %v: %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// session logs in and polls until an IMAP error or stop.
+func (ec *emailConnector) session(stop <-chan struct{}) (loggedIn bool, err error) {
+	c, err := ec.dialIMAP(ec.imapServer, ec.useTLS)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	if err := c.login(ec.imapUser, ec.imapPassword); err != nil {
+		return false, err
+	}
+	if err := c.selectMailbox(ec.mailbox); err != nil {
+		return false, fmt.Errorf("selecting mailbox '%s': %w", ec.mailbox, err)
+	}
+	ec.Log(robot.Info, "Email connector polling %s on %s every %v", ec.mailbox, ec.imapServer, ec.pollInterval)
+	for {
+		if err := ec.poll(c); err != nil {
+			return true, err
+		}
+		timer := time.NewTimer(ec.pollInterval)
+		select {
+		case <-stop:
+			timer.Stop()
+			c.logout()
+			return true, nil
+		case <-timer.C:
+		}
+		// NOOP lets the server report new mail to the following SEARCH.
+		if err := c.noop(); err != nil {
+			return true, err
+		}
+	}
+}
+
+// poll handles unseen messages in UID order, marking each seen.
+func (ec *emailConnector) poll(c *imapClient) error {
+	uids, err := c.unseen()
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		raw, err := c.fetch(uid)
+		if err != nil {
+			return err
+		}
+		ec.handleMail(uid, raw)
+		if err := c.markSeen(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ec *emailConnector) handleMail(uid uint32, raw []byte) {
+	m, err := parseMail(raw)
+	if err != nil {
+		ec.Log(robot.Warn, "Ignoring unparseable email UID %d: %v", uid, err)
+		return
+	}
+	switch {
+	case m.From == ec.address:
+		ec.Log(robot.Debug, "Ignoring email UID %d from the robot's own address", uid)
+		return
+	case m.AutoSubmitted:
+		ec.Log(robot.Debug, "Ignoring automatic email UID %d from '%s'", uid, m.From)
+		return
+	case !m.Date.IsZero() && ec.now().Sub(m.Date) > ec.maxMessageAge:
+		ec.Log(robot.Info, "Ignoring stale email UID %d from '%s' dated %s", uid, m.From, m.Date.Format(time.RFC1123Z))
+		return
+	}
+	text := m.command()
+	if text == "" {
+		ec.Log(robot.Debug, "Ignoring email UID %d from '%s' with no command", uid, m.From)
+		return
+	}
+	messageID := m.MessageID
+	if messageID == "" {
+		messageID = fmt.Sprintf("uid:%d", uid)
+	}
+	botMsg := &robot.ConnectorMessage{
+		Protocol:      "email",
+		UserID:        m.From,
+		MessageID:     messageID,
+		ThreadID:      m.threadRoot(),
+		DirectMessage: true,
+		BotMessage:    true,
+		MessageText:   text,
+		MessageObject: m,
+	}
+	if name, ok := ec.rosterUser(m.From); ok {
+		botMsg.UserName = name
+		ec.RLock()
+		authServID, trustAddress := ec.authServID, ec.trustSenderAddress
+		ec.RUnlock()
+		// Anyone can put any address in From; only the receiving server's
+		// DKIM/DMARC verdict vouches for it.
+		botMsg.ValidatedUser = trustAddress || m.senderAuthenticated(authServID)
+		if !botMsg.ValidatedUser {
+			ec.Log(robot.Debug, "Email from '%s' isn't authenticated; not validating roster user '%s'", m.From, name)
+		}
+	}
+	ec.IncomingMessage(botMsg)
+}
+
+func (ec *emailConnector) rosterUser(address string) (string, bool) {
+	if dir, ok := ec.Handler.(robot.UserDirectory); ok {
+		return dir.UserNameForEmail(address)
+	}
+	return "", false
+}
+
+// userAddress resolves a bracketed address or a roster username.
+func (ec *emailConnector) userAddress(u string) (string, bool) {
+	if id, ok := util.ExtractID(u); ok {
+		u = id
+	}
+	if addr, err := mail.ParseAddress(u); err == nil {
+		return strings.ToLower(addr.Address), true
+	}
+	if dir, ok := ec.Handler.(robot.UserDirectory); ok {
+		if addr, ok := dir.EmailForUserName(u); ok {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// GetProtocolUserAttribute knows only a user's address.
+func (ec *emailConnector) GetProtocolUserAttribute(u, attr string) (value string, ret robot.RetVal) {
+	addr, ok := ec.userAddress(u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	switch attr {
+	case "email", "internalid":
+		return addr, robot.Ok
+	default:
+		return "", robot.AttributeNotFound
+	}
+}
+
+// MessageHeard is a no-op; email has no typing notifications.
+func (ec *emailConnector) MessageHeard(user, channel string) {}
+
+func (ec *emailConnector) DefaultHelp() []string {
+	return nil
+}
+
+// JoinChannel is a no-op; email has no channels.
+func (ec *emailConnector) JoinChannel(c string) robot.RetVal {
+	return robot.Ok
+}
+
+// SendProtocolChannelThreadMessage fails; email has no channels.
+func (ec *emailConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	ec.Log(robot.Warn, "Email has no channels; dropping message for channel '%s'", ch)
+	return robot.ChannelNotFound
+}
+
+// SendProtocolUserChannelThreadMessage mails the user directly, since
+// email has no channels.
+func (ec *emailConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	if _, ok := ec.userAddress(uid); ok {
+		return ec.SendProtocolUserMessage(uid, msg, f, msgObject)
+	}
+	return ec.SendProtocolUserMessage(u, msg, f, msgObject)
+}
+
+// SendProtocolUserMessage mails a user, as a reply in the thread of the
+// message that triggered it when there is one.
+func (ec *emailConnector) SendProtocolUserMessage(u, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	to, ok := ec.userAddress(u)
+	if !ok {
+		ec.Log(robot.Error, "No email address found for user: %s", u)
+		return robot.UserNotFound
+	}
+	if f == robot.BasicMarkdown {
+		msg = util.RenderBasicMarkdownPlain(msg)
+	}
+	out := &outgoingMail{
+		From:      ec.address,
+		FromName:  ec.fromName,
+		To:        to,
+		Subject:   subjectFor(msg),
+		MessageID: ec.newMessageID(),
+		Date:      ec.now(),
+		Body:      msg,
+	}
+	if msgObject != nil {
+		if in, ok := msgObject.MessageObject.(*incomingMail); ok && strings.EqualFold(in.From, to) {
+			out.Subject = "Re: " + stripSubjectPrefixes(in.Subject)
+			if in.MessageID != "" {
+				out.InReplyTo = in.MessageID
+				out.References = append(append([]string{}, in.References...), in.MessageID)
+			}
+		}
+	}
+	if err := ec.send(out); err != nil {
+		ec.Log(robot.Error, "Failed sending email to '%s': %v", to, err)
+		return robot.FailedMessageSend
+	}
+	return robot.Ok
+}
+
+// subjectFor makes a subject from the first line of an unsolicited
+// message.
+func subjectFor(msg string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	line = strings.TrimSpace(line)
+	if len(line) > maxSubjectLength {
+		cut := maxSubjectLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		line = line[:cut] + "..."
+	}
+	return line
+}
+
+func (ec *emailConnector) newMessageID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	_, domain, _ := strings.Cut(ec.address, "@")
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// send delivers one message, retrying failed SMTP sessions.
+func (ec *emailConnector) send(out *outgoingMail) error {
+	ec.sendLock.Lock()
+	defer ec.sendLock.Unlock()
+	var err error
+	delay := time.Second
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		if err = ec.sendSMTP(out); err == nil {
+			return nil
+		}
+		if attempt < maxSendAttempts {
+			ec.Log(robot.Warn, "SMTP send to '%s' failed, retrying in %v: %v", out.To, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+func (ec *emailConnector) sendSMTP(out *outgoingMail) error {
+	host, _, _ := net.SplitHostPort(ec.smtpServer)
+	conn, err := net.DialTimeout("tcp", ec.smtpServer, dialTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(commandTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ec.useTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s doesn't offer STARTTLS", ec.smtpServer)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok {
+		if err := c.Auth(smtp.PlainAuth("", ec.smtpUser, ec.smtpPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(ec.address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(out.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(out.bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,373 @@
+package email
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	protocolConfig *config
+	users          map[string]string // roster username -> email
+	incoming       chan *robot.ConnectorMessage
+}
+
+func (t *testHandler) IncomingMessage(m *robot.ConnectorMessage) {
+	if t.incoming != nil {
+		t.incoming <- m
+	}
+}
+func (t *testHandler) GetProtocolConfig(v interface{}) error {
+	if t.protocolConfig != nil {
+		*(v.(*config)) = *t.protocolConfig
+	}
+	return nil
+}
+func (t *testHandler) GetBrainConfig(_ interface{}) error         { return nil }
+func (t *testHandler) GetEventStrings() *[]string                 { return nil }
+func (t *testHandler) GetHistoryConfig(_ interface{}) error       { return nil }
+func (t *testHandler) GetBotInfo() robot.BotInfo                  { return robot.BotInfo{FullName: "Floyd"} }
+func (t *testHandler) SetBotID(_ string)                          {}
+func (t *testHandler) SetTerminalWriter(_ io.Writer)              {}
+func (t *testHandler) SetBotMention(_ string)                     {}
+func (t *testHandler) GetLogLevel() robot.LogLevel                { return robot.Info }
+func (t *testHandler) GetInstallPath() string                     { return "" }
+func (t *testHandler) GetConfigPath() string                      { return "" }
+func (t *testHandler) ReadEncryptedFile(_ string) ([]byte, error) { return nil, nil }
+func (t *testHandler) Log(_ robot.LogLevel, _ string, _ ...interface{}) {
+}
+func (t *testHandler) GetDirectory(_ string) error { return nil }
+
+func (t *testHandler) UserNameForEmail(address string) (string, bool) {
+	for name, email := range t.users {
+		if strings.EqualFold(email, address) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (t *testHandler) EmailForUserName(user string) (string, bool) {
+	email, ok := t.users[user]
+	return email, ok
+}
+
+// fakeIMAP serves a fixed mailbox; messages are marked seen by UID STORE.
+type fakeIMAP struct {
+	ln       net.Listener
+	password string
+	sync.Mutex
+	messages map[uint32]string
+	seen     map[uint32]bool
+}
+
+func newFakeIMAP(t *testing.T, messages map[uint32]string) *fakeIMAP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeIMAP{ln: ln, password: "secret", messages: messages, seen: make(map[uint32]bool)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeIMAP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake IMAP ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, cmd, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		s.Lock()
+		switch {
+		case strings.HasPrefix(cmd, "LOGIN "):
+			if strings.HasSuffix(cmd, `"`+s.password+`"`) {
+				fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+			} else {
+				fmt.Fprintf(conn, "%s NO [AUTHENTICATIONFAILED] invalid credentials\r\n", tag)
+			}
+		case cmd == "UID SEARCH UNSEEN":
+			var uids []string
+			for uid := range s.messages {
+				if !s.seen[uid] {
+					uids = append(uids, fmt.Sprint(uid))
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK done\r\n", strings.Join(uids, " "), tag)
+		case strings.HasPrefix(cmd, "UID FETCH "):
+			var uid uint32
+			fmt.Sscanf(cmd, "UID FETCH %d", &uid)
+			msg := s.messages[uid]
+			fmt.Fprintf(conn, "* 1 FETCH (UID %d BODY[] {%d}\r\n%s)\r\n%s OK done\r\n", uid, len(msg), msg, tag)
+		case strings.HasPrefix(cmd, "UID STORE "):
+			var uid uint32
+			fmt.Sscanf(cmd, "UID STORE %d", &uid)
+			s.seen[uid] = true
+			fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		case cmd == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK bye\r\n", tag)
+			s.Unlock()
+			return
+		default: // SELECT, NOOP
+			fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		}
+		s.Unlock()
+	}
+}
+
+func (s *fakeIMAP) isSeen(uid uint32) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.seen[uid]
+}
+
+// fakeSMTP accepts mail without TLS or AUTH and hands each DATA body to
+// delivered.
+func fakeSMTP(t *testing.T) (string, chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	delivered := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				fmt.Fprint(conn, "220 fake ESMTP\r\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+					case strings.HasPrefix(cmd, "EHLO"):
+						fmt.Fprint(conn, "250-fake\r\n250 8BITMIME\r\n")
+					case cmd == "DATA":
+						fmt.Fprint(conn, "354 go ahead\r\n")
+						var body strings.Builder
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if l == ".\r\n" {
+								break
+							}
+							body.WriteString(l)
+						}
+						delivered <- body.String()
+						fmt.Fprint(conn, "250 queued\r\n")
+					case cmd == "QUIT":
+						fmt.Fprint(conn, "221 bye\r\n")
+						return
+					default:
+						fmt.Fprint(conn, "250 ok\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), delivered
+}
+
+var testNow = time.Date(2026, 1, 2, 15, 10, 0, 0, time.UTC)
+
+func newTestConnector(t *testing.T, h *testHandler, imapServer, smtpServer string) *emailConnector {
+	t.Helper()
+	ec, err := newConnector(h, config{
+		Address:      "Floyd@Example.com",
+		IMAPServer:   imapServer,
+		IMAPUser:     "floyd",
+		IMAPPassword: "secret",
+		SMTPServer:   smtpServer,
+		DisableTLS:   true,
+		AuthServID:   "mx.example.com",
+	})
+	if err != nil {
+		t.Fatalf("newConnector: %v", err)
+	}
+	ec.pollInterval = 20 * time.Millisecond
+	ec.reconnectDelay = 10 * time.Millisecond
+	ec.now = func() time.Time { return testNow }
+	return ec
+}
+
+func testMail(from, subject, extra, body string) string {
+	return "From: " + from + "\r\nTo: floyd@example.com\r\nSubject: " + subject +
+		"\r\nDate: Fri, 02 Jan 2026 15:04:05 +0000\r\n" + extra + "\r\n" + body + "\r\n"
+}
+
+func TestInitializeIdentifiesBot(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{
+		Address:      "Floyd <floyd@example.com>",
+		IMAPServer:   "imap.example.com:993",
+		IMAPUser:     "floyd",
+		IMAPPassword: "secret",
+		SMTPServer:   "smtp.example.com:587",
+	}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	ec := ic.Connector.(*emailConnector)
+	if ec.address != "floyd@example.com" || ec.smtpUser != "floyd" || ec.fromName != "Floyd" || ec.mailbox != "INBOX" || !ec.useTLS {
+		t.Fatalf("connector = %+v", ec)
+	}
+	h.protocolConfig.SMTPServer = "smtp.example.com"
+	if ic := Initialize(h, nil); ic.Error == nil {
+		t.Fatal("SMTPServer without a port accepted")
+	}
+}
+
+func TestRunDeliversMailAndMarksSeen(t *testing.T) {
+	imap := newFakeIMAP(t, map[uint32]string{
+		1: testMail("Alice <alice@example.com>", "deploy web",
+			"Message-ID: <a1@example.com>\r\nAuthentication-Results: mx.example.com; dmarc=pass header.from=example.com\r\n", "thanks"),
+		2: testMail("Mallory <alice@example.com>", "deploy web", "Message-ID: <a2@example.com>\r\n", ""),
+		3: testMail("bob@example.com", "I'm away", "Auto-Submitted: auto-replied\r\n", ""),
+		4: testMail("floyd@example.com", "loop", "", ""),
+		5: testMail("carol@example.com", "status", "Message-ID: <c1@example.com>\r\n", ""),
+	})
+	h := &testHandler{users: map[string]string{"alice": "alice@example.com"}, incoming: make(chan *robot.ConnectorMessage, 5)}
+	ec := newTestConnector(t, h, imap.ln.Addr().String(), "127.0.0.1:1")
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- ec.Run(stop) }()
+
+	var got []*robot.ConnectorMessage
+	for len(got) < 3 {
+		select {
+		case m := <-h.incoming:
+			got = append(got, m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d messages delivered", len(got))
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	alice := got[0]
+	if alice.Protocol != "email" || alice.UserID != "alice@example.com" || alice.UserName != "alice" || !alice.ValidatedUser {
+		t.Fatalf("authenticated mail = %+v", alice)
+	}
+	if !alice.DirectMessage || !alice.BotMessage || alice.MessageText != "deploy web" || alice.ThreadID != "<a1@example.com>" {
+		t.Fatalf("authenticated mail = %+v", alice)
+	}
+	if spoof := got[1]; spoof.UserName != "alice" || spoof.ValidatedUser {
+		t.Fatalf("unauthenticated mail = %+v", spoof)
+	}
+	if carol := got[2]; carol.UserName != "" || carol.MessageText != "status" {
+		t.Fatalf("mail from unlisted user = %+v", carol)
+	}
+	if len(h.incoming) != 0 {
+		t.Fatalf("automatic or looped mail was delivered: %+v", <-h.incoming)
+	}
+	for uid := uint32(1); uid <= 5; uid++ {
+		if !imap.isSeen(uid) {
+			t.Errorf("UID %d not marked seen", uid)
+		}
+	}
+}
+
+func TestRunStopsOnBadLogin(t *testing.T) {
+	imap := newFakeIMAP(t, nil)
+	imap.password = "other"
+	ec := newTestConnector(t, &testHandler{}, imap.ln.Addr().String(), "127.0.0.1:1")
+	done := make(chan error)
+	go func() { done <- ec.Run(make(chan struct{})) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errUnauthorized) {
+			t.Fatalf("Run = %v, want errUnauthorized", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run kept retrying rejected credentials")
+	}
+}
+
+func TestSendsThreadedReply(t *testing.T) {
+	smtpServer, delivered := fakeSMTP(t)
+	h := &testHandler{users: map[string]string{"alice": "alice@example.com"}}
+	ec := newTestConnector(t, h, "127.0.0.1:1", smtpServer)
+	in := &incomingMail{
+		From:       "alice@example.com",
+		Subject:    "RE: deploy web",
+		MessageID:  "<a2@example.com>",
+		References: []string{"<a1@example.com>"},
+	}
+	msgObject := &robot.ConnectorMessage{MessageObject: in}
+	if ret := ec.SendProtocolUserMessage("alice", "*deployed*", robot.BasicMarkdown, msgObject); ret != robot.Ok {
+		t.Fatalf("reply = %v", ret)
+	}
+	reply, err := parseMail([]byte(<-delivered))
+	if err != nil {
+		t.Fatalf("parsing sent mail: %v", err)
+	}
+	if reply.Subject != "Re: deploy web" || reply.InReplyTo != "<a2@example.com>" {
+		t.Fatalf("reply = %+v", reply)
+	}
+	if strings.Join(reply.References, " ") != "<a1@example.com> <a2@example.com>" || !reply.AutoSubmitted {
+		t.Fatalf("reply = %+v", reply)
+	}
+	if strings.TrimSpace(reply.Text) != "deployed" {
+		t.Fatalf("reply text = %q", reply.Text)
+	}
+
+	if ret := ec.SendProtocolUserChannelThreadMessage("<bob@example.com>", "bob", "general", "", "Backup finished\nall ok", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("new mail = %v", ret)
+	}
+	mail, _ := parseMail([]byte(<-delivered))
+	if mail.Subject != "Backup finished" || mail.InReplyTo != "" {
+		t.Fatalf("new mail = %+v", mail)
+	}
+	if ret := ec.SendProtocolUserMessage("nobody", "hi", robot.Raw, nil); ret != robot.UserNotFound {
+		t.Fatalf("unknown user = %v, want UserNotFound", ret)
+	}
+	if ret := ec.SendProtocolChannelThreadMessage("general", "", "hi", robot.Raw, nil); ret != robot.ChannelNotFound {
+		t.Fatalf("channel send = %v, want ChannelNotFound", ret)
+	}
+}
+
+func TestReloadSwapsValidationSettings(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{TrustSenderAddress: true}}
+	ec := newTestConnector(t, h, "127.0.0.1:1", "127.0.0.1:1")
+	if err := ec.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if ec.authServID != "" || !ec.trustSenderAddress || ec.imapServer != "127.0.0.1:1" {
+		t.Fatalf("connector after reload = %+v", ec)
+	}
+	if v, ret := ec.GetProtocolUserAttribute("<alice@example.com>", "email"); ret != robot.Ok || v != "alice@example.com" {
+		t.Fatalf("email attribute = %q, %v", v, ret)
+	}
+}
@@ -0,0 +1,204 @@
+package email
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A small IMAP4rev1 client: just enough to log in, find unseen messages,
+// fetch them whole and mark them seen.
+
+var errIMAPStatus = errors.New("IMAP command failed")
+
+type imapClient struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	tagSeq int
+}
+
+// imapResponse is one untagged response; literals are read into
+// literals and left as {n} markers in line.
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+func dialIMAP(server string, useTLS bool) (*imapClient, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(server)
+		conn, err = tls.DialWithDialer(dialer, "tcp", server, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.Dial("tcp", server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(commandTimeout))
+	greeting, _, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading IMAP greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting: %s", greeting)
+	}
+	return c, nil
+}
+
+func (c *imapClient) Close() error {
+	return c.conn.Close()
+}
+
+// readLine reads a response line, including any literals it carries.
+func (c *imapClient) readLine() (string, [][]byte, error) {
+	var line strings.Builder
+	var literals [][]byte
+	for {
+		part, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		line.WriteString(part)
+		n, ok := literalSize(part)
+		if !ok {
+			return line.String(), literals, nil
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return "", nil, err
+		}
+		literals = append(literals, literal)
+	}
+}
+
+// literalSize parses a trailing {n} literal marker.
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndexByte(line, '{')
+	if open == -1 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[open+1:len(line)-1], "+"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// command sends one tagged command and collects the untagged responses
+// up to its completion.
+func (c *imapClient) command(cmd string) ([]imapResponse, error) {
+	c.tagSeq++
+	tag := "g" + strconv.Itoa(c.tagSeq)
+	c.conn.SetDeadline(time.Now().Add(commandTimeout))
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return nil, err
+	}
+	var untagged []imapResponse
+	for {
+		line, literals, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasPrefix(line, "* "):
+			untagged = append(untagged, imapResponse{line: line[2:], literals: literals})
+		case strings.HasPrefix(line, tag+" "):
+			status := line[len(tag)+1:]
+			if strings.HasPrefix(strings.ToUpper(status), "OK") {
+				return untagged, nil
+			}
+			return untagged, fmt.Errorf("%w: %s", errIMAPStatus, status)
+		}
+		// Continuation requests don't happen; the client never sends literals.
+	}
+}
+
+// quote makes an IMAP quoted string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (c *imapClient) login(user, password string) error {
+	if strings.ContainsAny(user+password, "\r\n") {
+		return fmt.Errorf("%w: IMAP credentials can't contain line breaks", errUnauthorized)
+	}
+	if _, err := c.command("LOGIN " + quote(user) + " " + quote(password)); err != nil {
+		if errors.Is(err, errIMAPStatus) {
+			return fmt.Errorf("%w: %v", errUnauthorized, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *imapClient) selectMailbox(mailbox string) error {
+	_, err := c.command("SELECT " + quote(mailbox))
+	return err
+}
+
+// unseen returns the UIDs of unseen messages in ascending order.
+func (c *imapClient) unseen() ([]uint32, error) {
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, resp := range responses {
+		fields := strings.Fields(resp.line)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "SEARCH") {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if uid, err := strconv.ParseUint(f, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
+// fetch returns the full message without setting \Seen.
+func (c *imapClient) fetch(uid uint32) ([]byte, error) {
+	responses, err := c.command(fmt.Sprintf("UID FETCH %d (BODY.PEEK[])", uid))
+	if err != nil {
+		return nil, err
+	}
+	for _, resp := range responses {
+		if strings.Contains(strings.ToUpper(resp.line), "FETCH") && len(resp.literals) > 0 {
+			return resp.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("no message body returned for UID %d", uid)
+}
+
+func (c *imapClient) markSeen(uid uint32) error {
+	_, err := c.command(fmt.Sprintf(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid))
+	return err
+}
+
+func (c *imapClient) noop() error {
+	_, err := c.command("NOOP")
+	return err
+}
+
+func (c *imapClient) logout() {
+	c.command("LOGOUT")
+}
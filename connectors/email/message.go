@@ -0,0 +1,315 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// incomingMail is the part of a received message the connector uses; it
+// is the ConnectorMessage MessageObject.
+type incomingMail struct {
+	From          string // bare sender address, lowercased
+	Subject       string
+	MessageID     string
+	InReplyTo     string
+	References    []string
+	Date          time.Time
+	Text          string // the text/plain body
+	AutoSubmitted bool   // auto-replies, bounces and list traffic
+	AuthResults   []string
+}
+
+type headerGetter interface {
+	Get(key string) string
+}
+
+func parseMail(raw []byte) (*incomingMail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	h := msg.Header
+	from, err := mail.ParseAddress(decodeHeader(h.Get("From")))
+	if err != nil {
+		return nil, fmt.Errorf("parsing From: %w", err)
+	}
+	m := &incomingMail{
+		From:        strings.ToLower(from.Address),
+		Subject:     decodeHeader(h.Get("Subject")),
+		MessageID:   strings.TrimSpace(h.Get("Message-ID")),
+		InReplyTo:   firstMessageID(h.Get("In-Reply-To")),
+		References:  messageIDs(h.Get("References")),
+		AuthResults: h["Authentication-Results"],
+	}
+	m.Date, _ = h.Date()
+	auto := strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted")))
+	precedence := strings.ToLower(strings.TrimSpace(h.Get("Precedence")))
+	m.AutoSubmitted = (auto != "" && auto != "no") ||
+		precedence == "bulk" || precedence == "junk" || precedence == "list" ||
+		h.Get("List-Id") != "" ||
+		strings.HasPrefix(m.From, "mailer-daemon@") || strings.HasPrefix(m.From, "postmaster@")
+	m.Text, err = plainText(h, msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+	return m, nil
+}
+
+func decodeHeader(v string) string {
+	dec := &mime.WordDecoder{CharsetReader: charsetReader}
+	if d, err := dec.DecodeHeader(v); err == nil {
+		return strings.TrimSpace(d)
+	}
+	return strings.TrimSpace(v)
+}
+
+// charsetReader handles Latin-1, the common charset other than UTF-8.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "us-ascii":
+		return input, nil
+	case "iso-8859-1", "latin1", "windows-1252":
+		b, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
+
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+func messageIDs(v string) []string {
+	return messageIDPattern.FindAllString(v, -1)
+}
+
+func firstMessageID(v string) string {
+	if ids := messageIDs(v); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// plainText returns the first text/plain part of a message or part.
+func plainText(h headerGetter, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := plainText(part.Header, part)
+			if err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	r, err := charsetReader(params["charset"], body)
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(string(b), "\r\n", "\n"), nil
+}
+
+var subjectPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?|aw)\s*:\s*`)
+
+// stripSubjectPrefixes removes reply and forward prefixes.
+func stripSubjectPrefixes(subject string) string {
+	for {
+		stripped := subjectPrefix.ReplaceAllString(subject, "")
+		if stripped == subject {
+			return strings.TrimSpace(subject)
+		}
+		subject = stripped
+	}
+}
+
+var attributionLine = regexp.MustCompile(`^On .+ wrote:$`)
+
+// firstLine returns the first line of new text, stopping at quoted text
+// or a signature.
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line == "-- " || strings.HasPrefix(line, ">") {
+			return ""
+		}
+		line = strings.TrimSpace(line)
+		if attributionLine.MatchString(line) {
+			return ""
+		}
+		if line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// command picks the robot command: the subject of a new message, or the
+// first line of a reply, where the subject is just the thread's.
+func (m *incomingMail) command() string {
+	isReply := m.InReplyTo != "" || len(m.References) > 0
+	if !isReply {
+		if s := stripSubjectPrefixes(m.Subject); s != "" {
+			return s
+		}
+	}
+	if line := firstLine(m.Text); line != "" {
+		return line
+	}
+	return stripSubjectPrefixes(m.Subject)
+}
+
+// threadRoot is the Message-ID of the first message in the thread.
+func (m *incomingMail) threadRoot() string {
+	if len(m.References) > 0 {
+		return m.References[0]
+	}
+	if m.InReplyTo != "" {
+		return m.InReplyTo
+	}
+	return m.MessageID
+}
+
+// senderAuthenticated reports whether an Authentication-Results header
+// from the trusted receiving server shows a DMARC pass, or a DKIM pass
+// for the From domain.
+func (m *incomingMail) senderAuthenticated(authServID string) bool {
+	_, domain, ok := strings.Cut(m.From, "@")
+	if !ok || authServID == "" {
+		return false
+	}
+	for _, ar := range m.AuthResults {
+		parts := strings.Split(stripComments(ar), ";")
+		id := strings.Fields(parts[0])
+		if len(id) == 0 || !strings.EqualFold(id[0], authServID) {
+			continue
+		}
+		for _, res := range parts[1:] {
+			fields := strings.Fields(res)
+			if len(fields) == 0 {
+				continue
+			}
+			method, result, _ := strings.Cut(fields[0], "=")
+			if !strings.EqualFold(result, "pass") {
+				continue
+			}
+			props := make(map[string]string)
+			for _, f := range fields[1:] {
+				k, v, _ := strings.Cut(f, "=")
+				props[strings.ToLower(k)] = strings.ToLower(strings.Trim(v, `"`))
+			}
+			switch strings.ToLower(method) {
+			case "dmarc":
+				if from, ok := props["header.from"]; !ok || from == domain {
+					return true
+				}
+			case "dkim":
+				if props["header.d"] == domain {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// stripComments removes the parenthesized comments allowed in
+// Authentication-Results.
+func stripComments(s string) string {
+	var out strings.Builder
+	depth := 0
+	for _, r := range s {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// outgoingMail is one message the robot sends.
+type outgoingMail struct {
+	From       string // bare address
+	FromName   string
+	To         string
+	Subject    string
+	MessageID  string
+	InReplyTo  string
+	References []string
+	Date       time.Time
+	Body       string
+}
+
+// maxReferences keeps References short in long threads; the root and the
+// most recent messages are what clients use.
+const maxReferences = 10
+
+func (o *outgoingMail) bytes() []byte {
+	var b bytes.Buffer
+	from := mail.Address{Name: o.FromName, Address: o.From}
+	to := mail.Address{Address: o.To}
+	writeHeader := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+		}
+	}
+	writeHeader("From", from.String())
+	writeHeader("To", to.String())
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", o.Subject))
+	writeHeader("Date", o.Date.Format(time.RFC1123Z))
+	writeHeader("Message-ID", o.MessageID)
+	writeHeader("In-Reply-To", o.InReplyTo)
+	refs := o.References
+	if len(refs) > maxReferences {
+		refs = append([]string{refs[0]}, refs[len(refs)-maxReferences+1:]...)
+	}
+	writeHeader("References", strings.Join(refs, " "))
+	writeHeader("Auto-Submitted", "auto-replied")
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", "text/plain; charset=utf-8")
+	writeHeader("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	io.WriteString(qp, o.Body)
+	qp.Close()
+	return b.Bytes()
+}
@@ -0,0 +1,137 @@
+package email
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMailMultipartAndCommand(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: =?utf-8?q?Alice_Smith?= <Alice@Example.com>",
+		"To: floyd@example.com",
+		"Subject: Re: [CHG-12] approve?",
+		"Message-ID: <m2@example.com>",
+		"In-Reply-To: <m1@example.com>",
+		"References: <root@example.com> <m1@example.com>",
+		"Date: Mon, 02 Jan 2026 15:04:05 +0000",
+		"Authentication-Results: mx.example.com; dkim=pass (good signature) header.d=example.com; spf=pass",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/alternative; boundary="b1"`,
+		"",
+		"--b1",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"",
+		"approve CHG-12 =E2=9C=93",
+		"",
+		"On Mon, Jan 2, 2026 at 9:00 AM Floyd <floyd@example.com> wrote:",
+		"> Approve CHG-12?",
+		"--b1",
+		"Content-Type: text/html",
+		"",
+		"<p>approve</p>",
+		"--b1--",
+		"",
+	}, "\r\n")
+	m, err := parseMail([]byte(raw))
+	if err != nil {
+		t.Fatalf("parseMail: %v", err)
+	}
+	if m.From != "alice@example.com" || m.MessageID != "<m2@example.com>" || m.InReplyTo != "<m1@example.com>" {
+		t.Fatalf("headers = %+v", m)
+	}
+	if m.threadRoot() != "<root@example.com>" || m.AutoSubmitted {
+		t.Fatalf("thread root %q, auto %v", m.threadRoot(), m.AutoSubmitted)
+	}
+	if got := m.command(); got != "approve CHG-12 \u2713" {
+		t.Fatalf("command = %q", got)
+	}
+	if !m.senderAuthenticated("mx.example.com") {
+		t.Fatal("DKIM pass for the From domain not accepted")
+	}
+	if m.senderAuthenticated("other.example.com") {
+		t.Fatal("Authentication-Results from an untrusted server accepted")
+	}
+}
+
+func TestCommandUsesSubjectOfNewMessages(t *testing.T) {
+	m := &incomingMail{Subject: "Fwd: RE: deploy web", Text: "Thanks!\n-- \nAlice"}
+	if got := m.command(); got != "deploy web" {
+		t.Fatalf("command = %q", got)
+	}
+	m = &incomingMail{Text: "\n  status  \n"}
+	if got := m.command(); got != "status" {
+		t.Fatalf("command without subject = %q", got)
+	}
+	m = &incomingMail{InReplyTo: "<x@y>", Subject: "Re: ping", Text: "> quoted only"}
+	if got := m.command(); got != "ping" {
+		t.Fatalf("command for an empty reply = %q", got)
+	}
+}
+
+func TestSenderAuthenticationNeedsMatchingDomain(t *testing.T) {
+	cases := []struct {
+		results string
+		want    bool
+	}{
+		{"mx.example.com; dmarc=pass header.from=example.com", true},
+		{"mx.example.com; dmarc=pass", true},
+		{"mx.example.com; dmarc=pass header.from=evil.com", false},
+		{"mx.example.com; dkim=pass header.d=evil.com; dmarc=fail", false},
+		{"mx.example.com; spf=pass smtp.mailfrom=alice@example.com", false},
+		{"mx.example.com (dmarc=pass); dkim=fail header.d=example.com", false},
+	}
+	for _, c := range cases {
+		m := &incomingMail{From: "alice@example.com", AuthResults: []string{c.results}}
+		if got := m.senderAuthenticated("mx.example.com"); got != c.want {
+			t.Errorf("senderAuthenticated(%q) = %v, want %v", c.results, got, c.want)
+		}
+	}
+}
+
+func TestAutomaticMailIsFlagged(t *testing.T) {
+	for _, header := range []string{"Auto-Submitted: auto-replied", "Precedence: bulk", "List-Id: <ops.example.com>"} {
+		raw := "From: alice@example.com\r\nSubject: out of office\r\n" + header + "\r\n\r\naway\r\n"
+		m, err := parseMail([]byte(raw))
+		if err != nil {
+			t.Fatalf("parseMail: %v", err)
+		}
+		if !m.AutoSubmitted {
+			t.Errorf("%q not flagged as automatic", header)
+		}
+	}
+}
+
+func TestOutgoingMailRoundTrips(t *testing.T) {
+	out := &outgoingMail{
+		From:       "floyd@example.com",
+		FromName:   "Floyd",
+		To:         "alice@example.com",
+		Subject:    "Re: caf\u00e9 status",
+		MessageID:  "<out@example.com>",
+		InReplyTo:  "<m2@example.com>",
+		References: []string{"<root@example.com>", "<m2@example.com>"},
+		Date:       time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+		Body:       "All good \u2713\n" + strings.Repeat("long line ", 20),
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(out.bytes())))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if msg.Header.Get("In-Reply-To") != "<m2@example.com>" || msg.Header.Get("References") != "<root@example.com> <m2@example.com>" {
+		t.Fatalf("thread headers = %v", msg.Header)
+	}
+	if msg.Header.Get("Auto-Submitted") != "auto-replied" {
+		t.Fatal("outgoing mail isn't marked Auto-Submitted")
+	}
+	in, err := parseMail(out.bytes())
+	if err != nil {
+		t.Fatalf("parseMail: %v", err)
+	}
+	if in.Subject != out.Subject || in.Text != out.Body {
+		t.Fatalf("round trip subject %q, body %q", in.Subject, in.Text)
+	}
+}
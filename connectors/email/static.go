@@ -0,0 +1,7 @@
+package email
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterConnector("email", Initialize)
+}
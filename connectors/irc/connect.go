@@ -0,0 +1,399 @@
+// Package irc implements the robot.Connector interface for IRC, with TLS,
+// SASL PLAIN authentication and the IRCv3 account-tag capability for
+// validating users.
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	// Lines are limited to 512 bytes including the source prefix the server
+	// adds, so the default leaves room for a long nick!user@host.
+	defaultMaxLineLength = 400
+	dialTimeout          = 30 * time.Second
+	registrationTimeout  = 60 * time.Second
+	pingInterval         = 60 * time.Second
+	readTimeout          = 180 * time.Second
+	writeTimeout         = 10 * time.Second
+	reconnectMinDelay    = 2 * time.Second
+	reconnectMaxDelay    = 5 * time.Minute
+	maxNickAttempts      = 5
+)
+
+var errUnauthorized = errors.New("irc server rejected the connector credentials")
+
+type config struct {
+	Server          string   // host:port of the IRC server
+	DisableTLS      bool     // connect in plain text; only for trusted networks
+	Nick            string   // the robot's nick
+	Username        string   // ident username, defaults to Nick
+	RealName        string   // shown in WHOIS, defaults to Nick
+	ServerPassword  string   // sent with PASS when set
+	SASLUser        string   // account for SASL PLAIN; when set SASL is required
+	SASLPassword    string   // password for SASL PLAIN
+	JoinChannels    []string // IRC channels to join in addition to the robot's
+	MaxMessageSplit int      // the maximum number of lines for one outbound send
+	MaxLineLength   int      // the maximum message bytes per line
+	UserMap         map[string]string
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, account := range in {
+		name := strings.TrimSpace(user)
+		acct := strings.TrimSpace(account)
+		if name == "" || acct == "" {
+			h.Log(robot.Warn, "Ignoring invalid IRC UserMap entry (empty username or account): %q -> %q", user, account)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring IRC UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		out[name] = acct
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func newConnector(handler robot.Handler, c config) (*ircConnector, error) {
+	server := strings.TrimSpace(c.Server)
+	if server == "" {
+		return nil, fmt.Errorf("IRC protocol config requires Server")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return nil, fmt.Errorf("invalid IRC Server %q, want host:port: %w", server, err)
+	}
+	nick := strings.TrimSpace(c.Nick)
+	if nick == "" || strings.ContainsAny(nick, " ,*?!@#:") {
+		return nil, fmt.Errorf("IRC protocol config requires a valid Nick, got %q", c.Nick)
+	}
+	if (c.SASLUser == "") != (c.SASLPassword == "") {
+		return nil, fmt.Errorf("IRC SASLUser and SASLPassword must be set together")
+	}
+	if c.Username == "" {
+		c.Username = nick
+	}
+	if c.RealName == "" {
+		c.RealName = nick
+	}
+	if c.MaxMessageSplit <= 0 {
+		c.MaxMessageSplit = 1
+	}
+	if c.MaxLineLength <= 0 {
+		c.MaxLineLength = defaultMaxLineLength
+	}
+	ic := &ircConnector{
+		Handler:         handler,
+		server:          server,
+		useTLS:          !c.DisableTLS,
+		nick:            nick,
+		currentNick:     nick,
+		username:        c.Username,
+		realName:        c.RealName,
+		serverPassword:  c.ServerPassword,
+		saslUser:        c.SASLUser,
+		saslPassword:    c.SASLPassword,
+		maxMessageSplit: c.MaxMessageSplit,
+		maxLineLength:   c.MaxLineLength,
+		botUserMap:      normalizeConfiguredUserMap(c.UserMap, handler),
+		nicksByAccount:  make(map[string]string),
+		channels:        make(map[string]bool),
+		reconnectDelay:  reconnectMinDelay,
+		flood:           newFloodGate(),
+		dial:            dialServer,
+	}
+	for _, ch := range c.JoinChannels {
+		if target := channelTarget(ch); target != "" {
+			ic.channels[ircLower(target)] = true
+		}
+	}
+	return ic, nil
+}
+
+// Initialize validates config and returns the connector; IRC has no way
+// to check credentials before Run connects.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to retrieve irc protocol configuration: %w", err)}
+	}
+	ic, err := newConnector(handler, c)
+	if err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	handler.Log(robot.Info, "IRC connector using nick '%s' on %s", ic.nick, ic.server)
+	handler.SetBotID(ic.nick)
+	// Incoming "nick: " addressing is normalized to @nick.
+	handler.SetBotMention(ic.nick)
+	return robot.InitializedConnector{Connector: ic}
+}
+
+// Reload swaps in the configured UserMap; server, nick and credential
+// changes need a restart.
+func (ic *ircConnector) Reload() error {
+	var c config
+	if err := ic.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve IRC protocol configuration: %w", err)
+	}
+	userMap := normalizeConfiguredUserMap(c.UserMap, ic.Handler)
+	ic.Lock()
+	ic.botUserMap = userMap
+	ic.Unlock()
+	ic.Log(robot.Info, "IRC connector reloaded %d configured user mapping(s)", len(userMap))
+	return nil
+}
+
+func dialServer(server string, useTLS bool) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	if !useTLS {
+		return dialer.Dial("tcp", server)
+	}
+	host, _, _ := net.SplitHostPort(server)
+	return tls.DialWithDialer(dialer, "tcp", server, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+}
+
+// Run keeps a session open until stop is closed, reconnecting with backoff.
+// Only rejected credentials end it early.
+func (ic *ircConnector) Run(stop <-chan struct{}) error {
+	delay := ic.reconnectDelay
+	for {
+		registered, err := ic.session(stop)
+		select {
+		case <-stop:
+			ic.Log(robot.Debug, "Received stop in connector")
+			return nil
+		default:
+		}
+		if errors.Is(err, errUnauthorized) {
+			return err
+		}
+		if registered {
+			delay = ic.reconnectDelay
+		}
+		ic.Log(robot.Warn, "IRC connection lost; reconnecting in %v: %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// session connects, registers and reads until the connection fails.
+// registered reports whether registration completed.
+func (ic *ircConnector) session(stop <-chan struct{}) (registered bool, err error) {
+	conn, err := ic.dial(ic.server, ic.useTLS)
+	if err != nil {
+		return false, err
+	}
+	ic.connLock.Lock()
+	ic.conn = conn
+	ic.registered = false
+	ic.connLock.Unlock()
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		ic.connLock.Lock()
+		ic.conn = nil
+		ic.registered = false
+		ic.connLock.Unlock()
+		conn.Close()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				ic.writeLine("QUIT :shutting down")
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				ic.writeLine("PING :keepalive")
+			}
+		}
+	}()
+
+	ic.setNick(ic.nick)
+	nickAttempts := 0
+	ic.writeLine("CAP LS 302")
+	if ic.serverPassword != "" {
+		ic.writeLine("PASS " + ic.serverPassword)
+	}
+	ic.writeLine("NICK " + ic.nick)
+	ic.writeLine("USER " + ic.username + " 0 * :" + ic.realName)
+
+	var offered []string
+	saslDone := false
+	reader := bufio.NewReaderSize(conn, 8192)
+	conn.SetReadDeadline(time.Now().Add(registrationTimeout))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return registered, err
+		}
+		msg, ok := parseMessage(line)
+		if !ok {
+			continue
+		}
+		if registered {
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		switch msg.Command {
+		case "PING":
+			ic.writeLine("PONG :" + msg.param(0))
+		case "CAP":
+			switch strings.ToUpper(msg.param(1)) {
+			case "LS":
+				offered = append(offered, strings.Fields(msg.Params[len(msg.Params)-1])...)
+				if msg.param(2) == "*" {
+					// Multi-line LS; more to come.
+					continue
+				}
+				req, err := ic.capRequest(offered)
+				if err != nil {
+					return false, err
+				}
+				if req == "" {
+					ic.writeLine("CAP END")
+				} else {
+					ic.writeLine("CAP REQ :" + req)
+				}
+			case "ACK":
+				acked := strings.Fields(msg.Params[len(msg.Params)-1])
+				ic.setCaps(acked)
+				if ic.saslUser != "" && containsCap(acked, "sasl") {
+					ic.writeLine("AUTHENTICATE PLAIN")
+				} else {
+					ic.writeLine("CAP END")
+				}
+			case "NAK":
+				if ic.saslUser != "" {
+					return false, fmt.Errorf("%w: server refused the sasl capability", errUnauthorized)
+				}
+				ic.writeLine("CAP END")
+			}
+		case "AUTHENTICATE":
+			if msg.param(0) == "+" {
+				ic.sendSASLPlain()
+			}
+		case "903": // RPL_SASLSUCCESS
+			saslDone = true
+			ic.writeLine("CAP END")
+		case "902", "904", "905", "906", "908": // SASL failures
+			return false, fmt.Errorf("%w: SASL authentication failed: %s", errUnauthorized, msg.param(len(msg.Params)-1))
+		case "464": // ERR_PASSWDMISMATCH
+			return false, fmt.Errorf("%w: %s", errUnauthorized, msg.param(len(msg.Params)-1))
+		case "433", "437": // ERR_NICKNAMEINUSE, ERR_UNAVAILRESOURCE
+			if registered {
+				continue
+			}
+			nickAttempts++
+			if nickAttempts >= maxNickAttempts {
+				return false, fmt.Errorf("nick %q and %d alternates are in use", ic.nick, nickAttempts-1)
+			}
+			alternate := ic.nick + strings.Repeat("_", nickAttempts)
+			ic.setNick(alternate)
+			ic.Log(robot.Warn, "IRC nick in use; trying '%s'", alternate)
+			ic.writeLine("NICK " + alternate)
+		case "001": // RPL_WELCOME
+			if ic.saslUser != "" && !saslDone {
+				return false, fmt.Errorf("%w: registration completed without SASL", errUnauthorized)
+			}
+			registered = true
+			ic.setNick(msg.param(0))
+			ic.connLock.Lock()
+			ic.registered = true
+			ic.connLock.Unlock()
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+			ic.Log(robot.Info, "IRC registered on %s as '%s'", ic.server, msg.param(0))
+			ic.joinChannels()
+		case "NICK":
+			if ircLower(msg.nick()) == ircLower(ic.ownNick()) {
+				ic.setNick(msg.param(0))
+			}
+		case "PRIVMSG":
+			if registered {
+				ic.handlePrivmsg(msg)
+			}
+		case "ERROR":
+			return registered, fmt.Errorf("server closed the connection: %s", msg.param(0))
+		}
+	}
+}
+
+// capRequest picks the capabilities to request from those offered.
+func (ic *ircConnector) capRequest(offered []string) (string, error) {
+	var req []string
+	hasSASL := false
+	for _, c := range offered {
+		name, value, _ := strings.Cut(c, "=")
+		switch name {
+		case "sasl":
+			// A value lists the supported mechanisms.
+			if ic.saslUser != "" && (value == "" || containsCap(strings.Split(value, ","), "PLAIN")) {
+				hasSASL = true
+				req = append(req, name)
+			}
+		case "account-tag", "message-tags":
+			req = append(req, name)
+		}
+	}
+	if ic.saslUser != "" && !hasSASL {
+		return "", fmt.Errorf("%w: server doesn't offer SASL PLAIN", errUnauthorized)
+	}
+	return strings.Join(req, " "), nil
+}
+
+func (ic *ircConnector) setCaps(acked []string) {
+	ic.Lock()
+	defer ic.Unlock()
+	ic.accountTag = ic.accountTag || containsCap(acked, "account-tag")
+}
+
+func containsCap(caps []string, want string) bool {
+	for _, c := range caps {
+		// A leading "-" in an ACK means the capability was disabled.
+		if c == want {
+			return true
+		}
+	}
+	return false
+}
+
+// sendSASLPlain sends the PLAIN credentials in 400-byte pieces, as the
+// AUTHENTICATE command requires.
+func (ic *ircConnector) sendSASLPlain() {
+	payload := base64.StdEncoding.EncodeToString([]byte(ic.saslUser + "\x00" + ic.saslUser + "\x00" + ic.saslPassword))
+	for len(payload) >= 400 {
+		ic.writeLine("AUTHENTICATE " + payload[:400])
+		payload = payload[400:]
+	}
+	if payload == "" {
+		payload = "+"
+	}
+	ic.writeLine("AUTHENTICATE " + payload)
+}
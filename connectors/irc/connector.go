@@ -0,0 +1,343 @@
+package irc
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const ircTruncatedMessage = "(message too long, truncated)"
+
+var errNotConnected = errors.New("not connected to the IRC server")
+
+type ircConnector struct {
+	robot.Handler
+	sync.RWMutex                      // protects botUserMap, nicksByAccount, channels and accountTag
+	server          string            // host:port
+	useTLS          bool              // false only with DisableTLS
+	nick            string            // the configured nick
+	username        string            // ident username
+	realName        string            // realname sent with USER
+	serverPassword  string            // PASS password
+	saslUser        string            // SASL PLAIN account
+	saslPassword    string            // SASL PLAIN password
+	maxMessageSplit int               // the maximum number of lines for one long send
+	maxLineLength   int               // the maximum message bytes per line
+	botUserMap      map[string]string // connector-local configured mappings of username to services account
+	nicksByAccount  map[string]string // last nick seen for each services account
+	channels        map[string]bool   // channels to (re)join on registration
+	accountTag      bool              // the server tags messages with the sender's account
+
+	connLock    sync.Mutex // protects conn, registered and currentNick
+	conn        net.Conn
+	registered  bool
+	currentNick string // the nick the server knows us by
+
+	sendLock       sync.Mutex // keeps outbound lines in order
+	flood          *floodGate
+	msgSeq         int // message IDs when the server doesn't send msgid
+	reconnectDelay time.Duration
+	dial           func(server string, useTLS bool) (net.Conn, error)
+}
+
+// floodGate paces outbound lines so the server doesn't disconnect the
+// robot for flooding: a short burst goes out at once, then one line per
+// interval.
+type floodGate struct {
+	sync.Mutex
+	burst    int
+	interval time.Duration
+	clock    time.Time // when the queue of lines sent so far drains
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+func newFloodGate() *floodGate {
+	return &floodGate{burst: 4, interval: 700 * time.Millisecond, now: time.Now, sleep: time.Sleep}
+}
+
+// delay accounts for one more line and returns how long to wait before
+// sending it.
+func (f *floodGate) delay() time.Duration {
+	f.Lock()
+	defer f.Unlock()
+	now := f.now()
+	if f.clock.Before(now) {
+		f.clock = now
+	}
+	f.clock = f.clock.Add(f.interval)
+	if ahead := f.clock.Sub(now) - time.Duration(f.burst)*f.interval; ahead > 0 {
+		return ahead
+	}
+	return 0
+}
+
+func (f *floodGate) wait() {
+	if d := f.delay(); d > 0 {
+		f.sleep(d)
+	}
+}
+
+func (ic *ircConnector) writeLine(line string) error {
+	line = strings.NewReplacer("\r", "", "\n", " ").Replace(line)
+	ic.connLock.Lock()
+	defer ic.connLock.Unlock()
+	if ic.conn == nil {
+		return errNotConnected
+	}
+	ic.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := ic.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func (ic *ircConnector) setNick(nick string) {
+	ic.connLock.Lock()
+	ic.currentNick = nick
+	ic.connLock.Unlock()
+}
+
+func (ic *ircConnector) ownNick() string {
+	ic.connLock.Lock()
+	defer ic.connLock.Unlock()
+	return ic.currentNick
+}
+
+func (ic *ircConnector) isRegistered() bool {
+	ic.connLock.Lock()
+	defer ic.connLock.Unlock()
+	return ic.registered
+}
+
+// channelTarget maps a robot channel name to an IRC channel: names
+// without a channel prefix get "#".
+func channelTarget(ch string) string {
+	if id, ok := util.ExtractID(ch); ok {
+		ch = id
+	}
+	ch = strings.TrimSpace(ch)
+	if ch == "" || strings.ContainsAny(ch, " ,\x07") {
+		return ""
+	}
+	if isChannel(ch) {
+		return ch
+	}
+	return "#" + ch
+}
+
+// channelName is the inverse of channelTarget.
+func channelName(target string) string {
+	name := strings.TrimPrefix(target, "#")
+	if name == "" || isChannel(name) {
+		return target
+	}
+	return name
+}
+
+// joinChannels joins every known channel after registration, batching
+// names to stay under the line limit.
+func (ic *ircConnector) joinChannels() {
+	ic.RLock()
+	targets := make([]string, 0, len(ic.channels))
+	for target := range ic.channels {
+		targets = append(targets, target)
+	}
+	ic.RUnlock()
+	sort.Strings(targets)
+	batch := ""
+	for _, target := range targets {
+		if batch != "" && len(batch)+len(target) > 400 {
+			ic.writeLine("JOIN " + batch)
+			batch = ""
+		}
+		if batch != "" {
+			batch += ","
+		}
+		batch += target
+	}
+	if batch != "" {
+		ic.writeLine("JOIN " + batch)
+	}
+}
+
+func (ic *ircConnector) configuredCanonicalUser(account string) (string, bool) {
+	ic.RLock()
+	defer ic.RUnlock()
+	for name, acct := range ic.botUserMap {
+		if ircLower(acct) == ircLower(account) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// userNick resolves a bracketed nick, a configured username or a nick.
+func (ic *ircConnector) userNick(u string) (string, bool) {
+	if id, ok := util.ExtractID(u); ok {
+		return id, true
+	}
+	name := strings.TrimSpace(u)
+	if name == "" || strings.ContainsAny(name, " ,") || isChannel(name) {
+		return "", false
+	}
+	ic.RLock()
+	defer ic.RUnlock()
+	if account, ok := ic.botUserMap[strings.ToLower(name)]; ok {
+		if nick, ok := ic.nicksByAccount[ircLower(account)]; ok {
+			return nick, true
+		}
+		// Until the user speaks, assume their nick matches the account.
+		return account, true
+	}
+	return name, true
+}
+
+// GetProtocolUserAttribute only knows a user's nick; IRC has no profile.
+func (ic *ircConnector) GetProtocolUserAttribute(u, attr string) (value string, ret robot.RetVal) {
+	nick, ok := ic.userNick(u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	switch attr {
+	case "internalid", "nick":
+		return nick, robot.Ok
+	default:
+		return "", robot.AttributeNotFound
+	}
+}
+
+// MessageHeard is a no-op; IRC has no typing notifications.
+func (ic *ircConnector) MessageHeard(user, channel string) {}
+
+func (ic *ircConnector) DefaultHelp() []string {
+	return nil
+}
+
+// JoinChannel joins a channel now if connected, and on every later
+// registration. The engine calls it before Run, so most joins happen at
+// registration.
+func (ic *ircConnector) JoinChannel(c string) robot.RetVal {
+	target := channelTarget(c)
+	if target == "" {
+		ic.Log(robot.Error, "Invalid IRC channel name: %s", c)
+		return robot.ChannelNotFound
+	}
+	ic.Lock()
+	ic.channels[ircLower(target)] = true
+	ic.Unlock()
+	if ic.isRegistered() {
+		if err := ic.writeLine("JOIN " + target); err != nil {
+			ic.Log(robot.Error, "Joining IRC channel '%s': %v", target, err)
+		}
+	}
+	return robot.Ok
+}
+
+// formatLines renders msg as plain text lines that fit the line limit.
+func (ic *ircConnector) formatLines(prefix, msg string, f robot.MessageFormat) []string {
+	if f == robot.BasicMarkdown {
+		msg = util.RenderBasicMarkdownPlain(msg)
+	}
+	msg = strings.ReplaceAll(msg, "\r", "")
+	var lines []string
+	for i, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+		if i == 0 {
+			line = prefix + line
+		}
+		if line == "" {
+			// IRC can't send an empty message.
+			line = " "
+		}
+		lines = append(lines, wrapLine(line, ic.maxLineLength)...)
+	}
+	if len(lines) > ic.maxMessageSplit {
+		ic.Log(robot.Info, "IRC message too long, truncating %d lines to %d", len(lines), ic.maxMessageSplit)
+		lines = append(lines[:ic.maxMessageSplit], ircTruncatedMessage)
+	}
+	return lines
+}
+
+// wrapLine splits line into pieces of at most maxLen bytes, breaking at a
+// space when there is one and never inside a UTF-8 sequence.
+func wrapLine(line string, maxLen int) []string {
+	var out []string
+	for len(line) > maxLen {
+		cut := maxLen
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if line[cut] != ' ' {
+			if sp := strings.LastIndexByte(line[:cut], ' '); sp > maxLen/2 {
+				cut = sp
+			}
+		}
+		out = append(out, line[:cut])
+		line = strings.TrimPrefix(line[cut:], " ")
+	}
+	return append(out, line)
+}
+
+// sendLines sends PRIVMSG lines in order through the flood gate; Ok means
+// every line was written to the server.
+func (ic *ircConnector) sendLines(target string, lines []string) robot.RetVal {
+	if !ic.isRegistered() {
+		ic.Log(robot.Error, "Can't send to IRC '%s': %v", target, errNotConnected)
+		return robot.FailedMessageSend
+	}
+	ic.sendLock.Lock()
+	defer ic.sendLock.Unlock()
+	for _, line := range lines {
+		ic.flood.wait()
+		if err := ic.writeLine("PRIVMSG " + target + " :" + line); err != nil {
+			ic.Log(robot.Error, "Failed sending IRC message to '%s': %v", target, err)
+			return robot.FailedMessageSend
+		}
+	}
+	return robot.Ok
+}
+
+// SendProtocolChannelThreadMessage sends a message to a channel; IRC has no
+// threads.
+func (ic *ircConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	target := channelTarget(ch)
+	if target == "" {
+		ic.Log(robot.Error, "Invalid IRC channel name: %s", ch)
+		return robot.ChannelNotFound
+	}
+	return ic.sendLines(target, ic.formatLines("", msg, f))
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a channel
+// addressed to a user the IRC way, "nick: message".
+func (ic *ircConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	target := channelTarget(ch)
+	if target == "" {
+		ic.Log(robot.Error, "Invalid IRC channel name: %s", ch)
+		return robot.ChannelNotFound
+	}
+	nick, ok := ic.userNick(uid)
+	if !ok {
+		nick, ok = ic.userNick(u)
+	}
+	if !ok {
+		ic.Log(robot.Error, "No IRC nick found for user: %s", u)
+		return robot.UserNotFound
+	}
+	return ic.sendLines(target, ic.formatLines(nick+": ", msg, f))
+}
+
+// SendProtocolUserMessage sends a private message to a user.
+func (ic *ircConnector) SendProtocolUserMessage(u, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	nick, ok := ic.userNick(u)
+	if !ok {
+		ic.Log(robot.Error, "No IRC nick found for user: %s", u)
+		return robot.UserNotFound
+	}
+	return ic.sendLines(nick, ic.formatLines("", msg, f))
+}
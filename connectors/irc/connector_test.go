@@ -0,0 +1,343 @@
+package irc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	protocolConfig *config
+	botID          string
+	botMention     string
+	incoming       chan *robot.ConnectorMessage
+}
+
+func (t *testHandler) IncomingMessage(m *robot.ConnectorMessage) {
+	if t.incoming != nil {
+		t.incoming <- m
+	}
+}
+func (t *testHandler) GetProtocolConfig(v interface{}) error {
+	if t.protocolConfig != nil {
+		*(v.(*config)) = *t.protocolConfig
+	}
+	return nil
+}
+func (t *testHandler) GetBrainConfig(_ interface{}) error         { return nil }
+func (t *testHandler) GetEventStrings() *[]string                 { return nil }
+func (t *testHandler) GetHistoryConfig(_ interface{}) error       { return nil }
+func (t *testHandler) GetBotInfo() robot.BotInfo                  { return robot.BotInfo{} }
+func (t *testHandler) SetBotID(id string)                         { t.botID = id }
+func (t *testHandler) SetTerminalWriter(_ io.Writer)              {}
+func (t *testHandler) SetBotMention(m string)                     { t.botMention = m }
+func (t *testHandler) GetLogLevel() robot.LogLevel                { return robot.Info }
+func (t *testHandler) GetInstallPath() string                     { return "" }
+func (t *testHandler) GetConfigPath() string                      { return "" }
+func (t *testHandler) ReadEncryptedFile(_ string) ([]byte, error) { return nil, nil }
+func (t *testHandler) Log(_ robot.LogLevel, _ string, _ ...interface{}) {
+}
+func (t *testHandler) GetDirectory(_ string) error { return nil }
+
+// fakeServer is a stand-in IRC server that registers one client with
+// SASL PLAIN and records every line it receives.
+type fakeServer struct {
+	net.Listener
+	sync.Mutex
+	password string // the SASL password it accepts
+	conn     net.Conn
+	received []string
+	joined   chan string
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	fs := &fakeServer{Listener: l, password: password, joined: make(chan string, 10)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fs.Lock()
+			fs.conn = conn
+			fs.Unlock()
+			go fs.serve(conn)
+		}
+	}()
+	return fs
+}
+
+func (fs *fakeServer) send(line string) {
+	fs.Lock()
+	defer fs.Unlock()
+	fmt.Fprintf(fs.conn, "%s\r\n", line)
+}
+
+func (fs *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		fs.Lock()
+		fs.received = append(fs.received, line)
+		fs.Unlock()
+		command, arg, _ := strings.Cut(line, " ")
+		switch command {
+		case "CAP":
+			switch {
+			case arg == "LS 302":
+				fs.send(":irc.example.org CAP * LS :multi-prefix sasl=EXTERNAL,PLAIN account-tag")
+			case strings.HasPrefix(arg, "REQ :"):
+				fs.send(":irc.example.org CAP * ACK :" + strings.TrimPrefix(arg, "REQ :"))
+			case arg == "END":
+				fs.send(":irc.example.org 001 floyd :Welcome to the network")
+			}
+		case "AUTHENTICATE":
+			if arg == "PLAIN" {
+				fs.send("AUTHENTICATE +")
+				continue
+			}
+			want := base64.StdEncoding.EncodeToString([]byte("floyd\x00floyd\x00" + fs.password))
+			if arg == want {
+				fs.send(":irc.example.org 903 floyd :SASL authentication successful")
+			} else {
+				fs.send(":irc.example.org 904 floyd :SASL authentication failed")
+			}
+		case "JOIN":
+			fs.joined <- arg
+		}
+	}
+}
+
+// waitFor returns the received lines once one has the given prefix.
+func (fs *fakeServer) waitFor(t *testing.T, prefix string) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		fs.Lock()
+		for _, line := range fs.received {
+			if strings.HasPrefix(line, prefix) {
+				lines := append([]string(nil), fs.received...)
+				fs.Unlock()
+				return lines
+			}
+		}
+		fs.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server never received %q", prefix)
+	return nil
+}
+
+func newTestConnector(t *testing.T, h *testHandler, fs *fakeServer, password string) *ircConnector {
+	t.Helper()
+	ic, err := newConnector(h, config{
+		Server:          fs.Addr().String(),
+		DisableTLS:      true,
+		Nick:            "floyd",
+		SASLUser:        "floyd",
+		SASLPassword:    password,
+		JoinChannels:    []string{"ops", "&local"},
+		MaxMessageSplit: 2,
+		MaxLineLength:   20,
+		UserMap:         map[string]string{"alice": "AliceAcct"},
+	})
+	if err != nil {
+		t.Fatalf("newConnector: %v", err)
+	}
+	ic.flood.sleep = func(time.Duration) {}
+	return ic
+}
+
+// runConnector starts Run and waits for the robot to join its channels.
+func runConnector(t *testing.T, ic *ircConnector, fs *fakeServer) {
+	t.Helper()
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- ic.Run(stop) }()
+	t.Cleanup(func() {
+		close(stop)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Run returned %v after stop", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("Run didn't return after stop")
+		}
+	})
+	select {
+	case joined := <-fs.joined:
+		if joined != "#ops,&local" && joined != "&local,#ops" {
+			t.Fatalf("JOIN %q, want #ops and &local", joined)
+		}
+	case err := <-done:
+		t.Fatalf("Run returned early: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("robot never joined its channels")
+	}
+}
+
+func TestInitializeIdentifiesBot(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{Server: "irc.example.org:6697", Nick: "floyd"}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	if h.botID != "floyd" || h.botMention != "floyd" {
+		t.Fatalf("bot ID %q, mention %q", h.botID, h.botMention)
+	}
+	for _, bad := range []config{
+		{Server: "irc.example.org", Nick: "floyd"},
+		{Server: "irc.example.org:6697", Nick: "floyd bot"},
+		{Server: "irc.example.org:6697", Nick: "floyd", SASLUser: "floyd"},
+	} {
+		h.protocolConfig = &bad
+		if ic := Initialize(h, nil); ic.Error == nil {
+			t.Errorf("Initialize accepted %+v", bad)
+		}
+	}
+}
+
+func TestRunDeliversChannelAndDirectMessages(t *testing.T) {
+	fs := newFakeServer(t, "secret")
+	h := &testHandler{incoming: make(chan *robot.ConnectorMessage, 10)}
+	ic := newTestConnector(t, h, fs, "secret")
+	runConnector(t, ic, fs)
+
+	lines := fs.waitFor(t, "JOIN")
+	if lines[0] != "CAP LS 302" || !containsLine(lines, "CAP REQ :sasl account-tag") {
+		t.Fatalf("registration lines = %q", lines)
+	}
+
+	fs.send("@account=aliceacct;msgid=m1 :alice!a@host PRIVMSG #ops :Floyd: ping")
+	fs.send(":bob!b@host PRIVMSG floyd :\x01VERSION\x01")
+	fs.send("@account=bobacct :bob!b@host PRIVMSG floyd :hello")
+	fs.send(":floyd!f@host PRIVMSG &local :echo")
+
+	channelMsg := <-h.incoming
+	if channelMsg.ChannelName != "ops" || channelMsg.ChannelID != "#ops" || channelMsg.DirectMessage {
+		t.Fatalf("channel message = %+v", channelMsg)
+	}
+	if channelMsg.UserName != "alice" || channelMsg.UserID != "alice" || !channelMsg.ValidatedUser {
+		t.Fatalf("channel message user %q/%q validated %v", channelMsg.UserName, channelMsg.UserID, channelMsg.ValidatedUser)
+	}
+	if channelMsg.MessageText != "@floyd: ping" || channelMsg.MessageID != "m1" || channelMsg.Protocol != "irc" {
+		t.Fatalf("channel message text %q, ID %q", channelMsg.MessageText, channelMsg.MessageID)
+	}
+
+	direct := <-h.incoming
+	if !direct.DirectMessage || direct.ChannelName != "" || direct.UserName != "bob" || direct.ValidatedUser {
+		t.Fatalf("direct message = %+v", direct)
+	}
+	if direct.MessageText != "hello" || direct.MessageID == "" {
+		t.Fatalf("direct message text %q, ID %q", direct.MessageText, direct.MessageID)
+	}
+
+	self := <-h.incoming
+	if !self.SelfMessage || self.ChannelName != "&local" {
+		t.Fatalf("self message = %+v", self)
+	}
+
+	if ret := ic.JoinChannel("late"); ret != robot.Ok {
+		t.Fatalf("JoinChannel = %v", ret)
+	}
+	if joined := <-fs.joined; joined != "#late" {
+		t.Fatalf("late JOIN %q", joined)
+	}
+}
+
+func TestRunRejectsBadSASLPassword(t *testing.T) {
+	fs := newFakeServer(t, "secret")
+	ic := newTestConnector(t, &testHandler{}, fs, "wrong")
+	if err := ic.Run(make(chan struct{})); !errors.Is(err, errUnauthorized) {
+		t.Fatalf("Run with a bad password = %v, want errUnauthorized", err)
+	}
+}
+
+func TestSendsWrapAndTruncate(t *testing.T) {
+	fs := newFakeServer(t, "secret")
+	h := &testHandler{incoming: make(chan *robot.ConnectorMessage, 10)}
+	ic := newTestConnector(t, h, fs, "secret")
+	runConnector(t, ic, fs)
+
+	// Alice's nick is learned from her first message.
+	fs.send("@account=AliceAcct :alice_!a@host PRIVMSG #ops :hi")
+	<-h.incoming
+
+	if ret := ic.SendProtocolUserChannelThreadMessage("", "alice", "ops", "", "*done*", robot.BasicMarkdown, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolUserChannelThreadMessage = %v", ret)
+	}
+	if ret := ic.SendProtocolUserMessage("bob", "one two three four five six seven eight nine", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolUserMessage = %v", ret)
+	}
+	if ret := ic.SendProtocolChannelThreadMessage("#ops", "", "last", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolChannelThreadMessage = %v", ret)
+	}
+	if ret := ic.SendProtocolChannelThreadMessage("bad channel", "", "x", robot.Raw, nil); ret != robot.ChannelNotFound {
+		t.Fatalf("send to an invalid channel = %v, want ChannelNotFound", ret)
+	}
+
+	lines := fs.waitFor(t, "PRIVMSG #ops :last")
+	var sent []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "PRIVMSG ") {
+			sent = append(sent, line)
+		}
+	}
+	want := []string{
+		"PRIVMSG #ops :alice_: done",
+		"PRIVMSG bob :one two three four",
+		"PRIVMSG bob :five six seven eight",
+		"PRIVMSG bob :" + ircTruncatedMessage,
+		"PRIVMSG #ops :last",
+	}
+	if strings.Join(sent, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("sent lines:\n%s\nwant:\n%s", strings.Join(sent, "\n"), strings.Join(want, "\n"))
+	}
+	if v, ret := ic.GetProtocolUserAttribute("alice", "nick"); ret != robot.Ok || v != "alice_" {
+		t.Fatalf("nick attribute = %q, %v", v, ret)
+	}
+}
+
+func TestReloadSwapsConfiguredUserMap(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{UserMap: map[string]string{"bob": "bobacct", "Carol": "carolacct", "dave": ""}}}
+	ic := &ircConnector{Handler: h, botUserMap: map[string]string{"alice": "aliceacct"}}
+	if err := ic.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(ic.botUserMap) != 1 || ic.botUserMap["bob"] != "bobacct" {
+		t.Fatalf("botUserMap after reload = %v, want only bob", ic.botUserMap)
+	}
+	if _, ok := ic.configuredCanonicalUser("aliceacct"); ok {
+		t.Fatal("removed mapping still validates")
+	}
+}
+
+func containsLine(lines []string, want string) bool {
+	for _, line := range lines {
+		if line == want {
+			return true
+		}
+	}
+	return false
+}
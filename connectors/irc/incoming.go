@@ -0,0 +1,73 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// handlePrivmsg turns a PRIVMSG to a channel or to the robot into a
+// ConnectorMessage.
+func (ic *ircConnector) handlePrivmsg(msg *message) {
+	target, text := msg.param(0), msg.param(1)
+	sender := msg.nick()
+	if sender == "" || target == "" {
+		return
+	}
+	if command, _, ok := ctcp(text); ok {
+		ic.Log(robot.Debug, "Ignoring IRC CTCP %s from '%s'", command, sender)
+		return
+	}
+	direct := !isChannel(target)
+
+	messageID := msg.Tags["msgid"]
+	if messageID == "" {
+		ic.msgSeq++
+		messageID = strconv.Itoa(ic.msgSeq)
+	}
+	botMsg := &robot.ConnectorMessage{
+		Protocol:      "irc",
+		UserID:        sender,
+		UserName:      sender,
+		MessageID:     messageID,
+		ThreadID:      messageID,
+		DirectMessage: direct,
+		MessageText:   ic.normalizeBotMention(text),
+		MessageObject: msg,
+	}
+	if !direct {
+		botMsg.ChannelName = channelName(target)
+		botMsg.ChannelID = target
+	}
+	// Only a services account reported by the server identifies a user;
+	// anyone can take a nick.
+	if account := msg.Tags["account"]; account != "" && account != "*" {
+		if validatedName, validated := ic.configuredCanonicalUser(account); validated {
+			botMsg.UserName = validatedName
+			botMsg.ValidatedUser = true
+			ic.Lock()
+			ic.nicksByAccount[ircLower(account)] = sender
+			ic.Unlock()
+		}
+	}
+	if ircLower(sender) == ircLower(ic.ownNick()) {
+		botMsg.SelfMessage = true
+	}
+	ic.IncomingMessage(botMsg)
+}
+
+// normalizeBotMention rewrites the IRC habit of addressing the robot as
+// "nick: ..." or "nick, ..." to @nick, the mention registered with the
+// engine. The current nick may carry a suffix after a nick collision.
+func (ic *ircConnector) normalizeBotMention(text string) string {
+	for _, nick := range []string{ic.ownNick(), ic.nick} {
+		if len(text) <= len(nick) || ircLower(text[:len(nick)]) != ircLower(nick) {
+			continue
+		}
+		if strings.ContainsRune(":,", rune(text[len(nick)])) {
+			return "@" + ic.nick + text[len(nick):]
+		}
+	}
+	return text
+}
@@ -0,0 +1,120 @@
+package irc
+
+import (
+	"strings"
+)
+
+// message is one parsed IRC protocol line.
+type message struct {
+	Tags    map[string]string
+	Source  string // nick!user@host or a server name
+	Command string
+	Params  []string
+}
+
+// nick returns the nick part of the source.
+func (m *message) nick() string {
+	if i := strings.IndexByte(m.Source, '!'); i >= 0 {
+		return m.Source[:i]
+	}
+	return m.Source
+}
+
+func (m *message) param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// parseMessage parses a line without its trailing CRLF, including IRCv3
+// message tags.
+func parseMessage(line string) (*message, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	m := &message{}
+	if strings.HasPrefix(line, "@") {
+		end := strings.IndexByte(line, ' ')
+		if end == -1 {
+			return nil, false
+		}
+		m.Tags = parseTags(line[1:end])
+		line = strings.TrimLeft(line[end:], " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		end := strings.IndexByte(line, ' ')
+		if end == -1 {
+			return nil, false
+		}
+		m.Source = line[1:end]
+		line = strings.TrimLeft(line[end:], " ")
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		end := strings.IndexByte(line, ' ')
+		if end == -1 {
+			m.Params = append(m.Params, line)
+			break
+		}
+		m.Params = append(m.Params, line[:end])
+		line = strings.TrimLeft(line[end:], " ")
+	}
+	if len(m.Params) == 0 {
+		return nil, false
+	}
+	m.Command = strings.ToUpper(m.Params[0])
+	m.Params = m.Params[1:]
+	return m, true
+}
+
+var tagValueUnescaper = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		tags[key] = tagValueUnescaper.Replace(value)
+	}
+	return tags
+}
+
+// ctcp splits a CTCP request like "\x01ACTION waves\x01" into its command
+// and argument.
+func ctcp(text string) (command, arg string, ok bool) {
+	if len(text) < 2 || text[0] != '\x01' {
+		return "", "", false
+	}
+	text = strings.TrimSuffix(text[1:], "\x01")
+	command, arg, _ = strings.Cut(text, " ")
+	return strings.ToUpper(command), arg, true
+}
+
+// isChannel reports whether target names a channel rather than a nick.
+func isChannel(target string) bool {
+	return target != "" && strings.ContainsRune("#&+!", rune(target[0]))
+}
+
+// ircLower folds a nick or channel the way servers using the default
+// rfc1459 casemapping do.
+func ircLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~':
+			return '^'
+		}
+		return r
+	}, s)
+}
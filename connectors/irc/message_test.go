@@ -0,0 +1,109 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestParseMessage(t *testing.T) {
+	msg, ok := parseMessage("@account=alice;msgid=abc\\s1;+draft/x :alice!a@host privmsg #ops :floyd: ping  now\r\n")
+	if !ok {
+		t.Fatal("parseMessage failed")
+	}
+	if msg.Tags["account"] != "alice" || msg.Tags["msgid"] != "abc 1" || msg.Tags["+draft/x"] != "" {
+		t.Fatalf("tags = %v", msg.Tags)
+	}
+	if msg.Source != "alice!a@host" || msg.nick() != "alice" || msg.Command != "PRIVMSG" {
+		t.Fatalf("source %q, nick %q, command %q", msg.Source, msg.nick(), msg.Command)
+	}
+	if len(msg.Params) != 2 || msg.param(0) != "#ops" || msg.param(1) != "floyd: ping  now" || msg.param(2) != "" {
+		t.Fatalf("params = %q", msg.Params)
+	}
+
+	msg, ok = parseMessage("PING irc.example.org")
+	if !ok || msg.Source != "" || msg.Command != "PING" || msg.param(0) != "irc.example.org" {
+		t.Fatalf("PING = %+v, %v", msg, ok)
+	}
+	for _, bad := range []string{"", "@tags-only", ":source-only"} {
+		if _, ok := parseMessage(bad); ok {
+			t.Errorf("parseMessage(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCTCPAndCasemapping(t *testing.T) {
+	if command, arg, ok := ctcp("\x01action waves\x01"); !ok || command != "ACTION" || arg != "waves" {
+		t.Fatalf("ctcp = %q, %q, %v", command, arg, ok)
+	}
+	if _, _, ok := ctcp("hello"); ok {
+		t.Fatal("plain text parsed as CTCP")
+	}
+	if got := ircLower("Floyd[Away]\\~"); got != "floyd{away}|^" {
+		t.Fatalf("ircLower = %q", got)
+	}
+}
+
+func TestChannelNameMapping(t *testing.T) {
+	cases := []struct{ name, target string }{
+		{"ops", "#ops"},
+		{"#ops", "#ops"},
+		{"&local", "&local"},
+		{"##help", "##help"},
+	}
+	for _, c := range cases {
+		target := channelTarget(c.name)
+		if target != c.target {
+			t.Errorf("channelTarget(%q) = %q, want %q", c.name, target, c.target)
+		}
+		if name := channelName(target); channelTarget(name) != target {
+			t.Errorf("channelName(%q) = %q doesn't map back", target, name)
+		}
+	}
+	if got := channelTarget("<#ops>"); got != "#ops" {
+		t.Errorf("bracketed channel = %q", got)
+	}
+	for _, bad := range []string{"", "two words", "a,b"} {
+		if got := channelTarget(bad); got != "" {
+			t.Errorf("channelTarget(%q) = %q, want rejection", bad, got)
+		}
+	}
+}
+
+func TestWrapLineKeepsRunesWhole(t *testing.T) {
+	line := strings.Repeat("h\u00e9llo w\u00f6rld ", 20)
+	pieces := wrapLine(line, 37)
+	if strings.Join(pieces, " ") != line {
+		t.Fatalf("wrapping lost text: %q", pieces)
+	}
+	for _, p := range pieces {
+		if len(p) > 37 || !utf8.ValidString(p) {
+			t.Fatalf("bad piece %q (%d bytes)", p, len(p))
+		}
+	}
+	long := strings.Repeat("\u00e9", 30)
+	for _, p := range wrapLine(long, 7) {
+		if len(p) > 7 || !utf8.ValidString(p) {
+			t.Fatalf("bad piece %q of an unbroken line", p)
+		}
+	}
+}
+
+func TestFloodGateAllowsBurstThenPaces(t *testing.T) {
+	now := time.Unix(1000, 0)
+	f := newFloodGate()
+	f.now = func() time.Time { return now }
+	for i := 0; i < f.burst; i++ {
+		if d := f.delay(); d != 0 {
+			t.Fatalf("line %d in the burst delayed %v", i, d)
+		}
+	}
+	if d := f.delay(); d != f.interval {
+		t.Fatalf("first line past the burst delayed %v, want %v", d, f.interval)
+	}
+	now = now.Add(time.Minute)
+	if d := f.delay(); d != 0 {
+		t.Fatalf("line after an idle minute delayed %v", d)
+	}
+}
@@ -0,0 +1,572 @@
+package matrix
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+// Matrix messages carry a plain-text body plus optional HTML in
+// formatted_body. BasicMarkdown renders to both in one pass: converted
+// constructs are reserved as placeholder tokens with an HTML and a plain
+// form, the remaining text is HTML-escaped, and each output restores its
+// own form of the tokens.
+
+const matrixTruncatedMessage = "(message too long, truncated)"
+
+const matrixHTMLFormat = "org.matrix.custom.html"
+
+// formatMessage renders msg, split into messages no longer than the
+// configured limit. When mentionUserID is set each message starts with a
+// mention of that user.
+func (mc *matrixConnector) formatMessage(mentionUserID, msg string, f robot.MessageFormat) []*messageContent {
+	var prefixPlain, prefixHTML string
+	if mentionUserID != "" {
+		name, ok := mc.displayName(mentionUserID)
+		if !ok {
+			name = localpart(mentionUserID)
+		}
+		prefixPlain = name + ": "
+		prefixHTML = mentionLink(mentionUserID, name) + ": "
+	}
+	chunks := splitMessage(msg, mc.maxMessageLength, mc.maxMessageSplit)
+	contents := make([]*messageContent, 0, len(chunks))
+	for _, chunk := range chunks {
+		var body, formatted string
+		var userIDs []string
+		switch f {
+		case robot.BasicMarkdown:
+			body, formatted, userIDs = mc.renderBasicMarkdown(chunk)
+		case robot.Fixed:
+			body, formatted = chunk, codeBlockHTML("", chunk)
+		default:
+			body = chunk
+		}
+		if mentionUserID != "" {
+			if formatted == "" {
+				formatted = textHTML(body)
+			}
+			if strings.HasPrefix(formatted, "<pre>") {
+				body = prefixPlain + "\n" + body
+			} else {
+				body = prefixPlain + body
+			}
+			formatted = prefixHTML + formatted
+			userIDs = append([]string{mentionUserID}, userIDs...)
+		}
+		content := &messageContent{MsgType: "m.text", Body: body, Mentions: &mentions{UserIDs: dedupe(userIDs)}}
+		if formatted != "" {
+			content.Format = matrixHTMLFormat
+			content.FormattedBody = formatted
+		}
+		contents = append(contents, content)
+	}
+	return contents
+}
+
+func dedupe(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := ids[:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func mentionLink(userID, name string) string {
+	return `<a href="https://matrix.to/#/` + html.EscapeString(userID) + `">` + html.EscapeString(name) + `</a>`
+}
+
+// textHTML escapes plain text for formatted_body.
+func textHTML(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+func codeBlockHTML(lang, code string) string {
+	class := ""
+	if lang != "" {
+		class = ` class="language-` + html.EscapeString(lang) + `"`
+	}
+	return "<pre><code" + class + ">" + html.EscapeString(strings.TrimSuffix(code, "\n")) + "</code></pre>"
+}
+
+// mdRender collects the reserved tokens for one message.
+type mdRender struct {
+	mc       *matrixConnector
+	html     []string
+	plain    []string
+	mentions []string
+}
+
+func (r *mdRender) reserve(htmlToken, plainToken string) string {
+	r.html = append(r.html, htmlToken)
+	r.plain = append(r.plain, plainToken)
+	return markdownPlaceholder(len(r.html) - 1)
+}
+
+// renderBasicMarkdown returns the plain body, the HTML formatted body and
+// the mentioned user IDs.
+func (mc *matrixConnector) renderBasicMarkdown(msg string) (body, formatted string, userIDs []string) {
+	r := &mdRender{mc: mc}
+	var out strings.Builder
+	for {
+		idx := strings.Index(msg, "```")
+		if idx == -1 {
+			out.WriteString(r.inline(msg))
+			break
+		}
+		out.WriteString(r.inline(msg[:idx]))
+		rest := msg[idx+3:]
+		end := strings.Index(rest, "```")
+		code := rest
+		if end == -1 {
+			// Unterminated fence: the rest is code.
+			msg = ""
+		} else {
+			code = rest[:end]
+			msg = rest[end+3:]
+		}
+		out.WriteString(r.codeBlock(code, end != -1))
+		if end == -1 {
+			break
+		}
+	}
+	text := out.String()
+	formatted = restoreMarkdownPlaceholders(textHTML(text), r.html)
+	formatted = strings.ReplaceAll(formatted, "<br><pre>", "<pre>")
+	formatted = strings.ReplaceAll(formatted, "</pre><br>", "</pre>")
+	return restoreMarkdownPlaceholders(text, r.plain), formatted, r.mentions
+}
+
+// codeBlock reserves a fenced block; a first line without spaces is the
+// language hint.
+func (r *mdRender) codeBlock(code string, closed bool) string {
+	plain := "```" + code
+	if closed {
+		plain += "```"
+	}
+	lang, content := "", code
+	if nl := strings.IndexByte(code, '\n'); nl != -1 {
+		first := strings.TrimSpace(code[:nl])
+		if !strings.ContainsAny(first, " \t") {
+			lang, content = first, code[nl+1:]
+		}
+	}
+	return r.reserve(codeBlockHTML(lang, content), plain)
+}
+
+func (r *mdRender) inline(msg string) string {
+	var out strings.Builder
+	for len(msg) > 0 {
+		start := findNextUnescapedBacktick(msg, 0)
+		if start == -1 {
+			out.WriteString(r.text(msg))
+			break
+		}
+		out.WriteString(r.text(msg[:start]))
+
+		end := findNextUnescapedBacktick(msg, start+1)
+		if end == -1 {
+			// Unterminated inline-code delimiter: treat as plain text.
+			out.WriteString(r.text(msg[start:]))
+			break
+		}
+		inner := msg[start+1 : end]
+		out.WriteString(r.reserve("<code>"+html.EscapeString(inner)+"</code>", msg[start:end+1]))
+		msg = msg[end+1:]
+	}
+	return out.String()
+}
+
+func findNextUnescapedBacktick(msg string, start int) int {
+	for i := start; i < len(msg); i++ {
+		if msg[i] == '`' && !isEscapedAt(msg, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isEscapedAt(msg string, idx int) bool {
+	if idx <= 0 || idx > len(msg)-1 {
+		return false
+	}
+	slashes := 0
+	for i := idx - 1; i >= 0 && msg[i] == '\\'; i-- {
+		slashes++
+	}
+	return slashes%2 == 1
+}
+
+func (r *mdRender) text(msg string) string {
+	msg = r.protectEscapes(msg)
+	msg = r.replaceLinks(msg)
+	msg = r.replaceMentions(msg)
+	msg = replaceBasicMarkdownEmoji(msg)
+	msg = r.replaceBold(msg)
+	msg = r.replaceItalic(msg)
+	return msg
+}
+
+func (r *mdRender) protectEscapes(msg string) string {
+	var out strings.Builder
+	for i := 0; i < len(msg); i++ {
+		ch := msg[i]
+		if ch != '\\' || i+1 >= len(msg) || !isBasicMarkdownEscapable(msg[i+1]) {
+			out.WriteByte(ch)
+			continue
+		}
+		literal := string(msg[i+1])
+		out.WriteString(r.reserve(html.EscapeString(literal), literal))
+		i++
+	}
+	return out.String()
+}
+
+func isBasicMarkdownEscapable(ch byte) bool {
+	switch ch {
+	case '*', '`', '[', ']', '(', ')', '@', '\\':
+		return true
+	default:
+		return false
+	}
+}
+
+// replaceLinks turns [label](url) into a link; the label is still
+// processed for emphasis and emoji. The plain body gets "label (url)".
+func (r *mdRender) replaceLinks(msg string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(msg); {
+		open := strings.IndexByte(msg[i:], '[')
+		if open == -1 {
+			out.WriteString(msg[i:])
+			break
+		}
+		open += i
+		out.WriteString(msg[i:open])
+
+		close := strings.IndexByte(msg[open+1:], ']')
+		if close == -1 {
+			out.WriteByte(msg[open])
+			i = open + 1
+			continue
+		}
+		close += open + 1
+
+		if close+1 >= len(msg) || msg[close+1] != '(' {
+			out.WriteString(msg[open : close+1])
+			i = close + 1
+			continue
+		}
+
+		end := strings.IndexByte(msg[close+2:], ')')
+		if end == -1 {
+			out.WriteString(msg[open:])
+			break
+		}
+		end += close + 2
+
+		url := msg[close+2 : end]
+		if !isBasicMarkdownLinkURL(url) {
+			out.WriteString(msg[open : end+1])
+			i = end + 1
+			continue
+		}
+		out.WriteString(r.reserve(`<a href="`+html.EscapeString(url)+`">`, ""))
+		out.WriteString(msg[open+1 : close])
+		out.WriteString(r.reserve("</a>", " ("+url+")"))
+		i = end + 1
+	}
+
+	return out.String()
+}
+
+func isBasicMarkdownLinkURL(url string) bool {
+	if strings.ContainsAny(url, " \t\r\n") {
+		return false
+	}
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
+
+// replaceMentions turns @username for users in the configured UserMap into
+// a Matrix mention pill. Anything else is left literal.
+func (r *mdRender) replaceMentions(msg string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(msg); {
+		if msg[i] != '@' {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(msg) && isMentionTokenChar(msg[i]) {
+			i++
+		}
+		if i == start+1 {
+			out.WriteByte('@')
+			continue
+		}
+
+		mention, suffix := splitMentionCandidate(msg[start+1 : i])
+		if mention == "" || (start > 0 && isEmailLocalChar(msg[start-1])) {
+			out.WriteString(msg[start:i])
+			continue
+		}
+		r.mc.RLock()
+		userID, ok := r.mc.botUserMap[strings.ToLower(mention)]
+		r.mc.RUnlock()
+		if !ok {
+			out.WriteString("@" + mention + suffix)
+			continue
+		}
+		name, found := r.mc.displayName(userID)
+		if !found {
+			name = localpart(userID)
+		}
+		r.mentions = append(r.mentions, userID)
+		out.WriteString(r.reserve(mentionLink(userID, name), name))
+		out.WriteString(suffix)
+	}
+
+	return out.String()
+}
+
+func isMentionTokenChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_' || ch == '-' || ch == '.'
+}
+
+func splitMentionCandidate(token string) (mention string, suffix string) {
+	cut := len(token)
+	for cut > 0 && !isMentionTerminalChar(token[cut-1]) {
+		cut--
+	}
+	return token[:cut], token[cut:]
+}
+
+func isMentionTerminalChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_'
+}
+
+func isEmailLocalChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_' || ch == '.' || ch == '%' || ch == '+' || ch == '-'
+}
+
+// replaceBasicMarkdownEmoji swaps known :shortcode: emoji for Unicode;
+// Matrix clients don't expand shortcodes in received messages.
+func replaceBasicMarkdownEmoji(msg string) string {
+	var out strings.Builder
+	for i := 0; i < len(msg); {
+		if msg[i] != ':' {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		end := findBasicMarkdownEmojiEnd(msg, i)
+		if end == -1 {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		if emoji := util.EmojiUnicode(msg[i+1 : end]); emoji != "" {
+			out.WriteString(emoji)
+		} else {
+			out.WriteString(msg[i : end+1])
+		}
+		i = end + 1
+	}
+	return out.String()
+}
+
+func findBasicMarkdownEmojiEnd(msg string, start int) int {
+	if start > 0 && isBasicMarkdownEmojiNameChar(msg[start-1]) {
+		return -1
+	}
+	nameStart := start + 1
+	if nameStart >= len(msg) || !isBasicMarkdownEmojiNameChar(msg[nameStart]) {
+		return -1
+	}
+	for i := nameStart; i < len(msg); i++ {
+		switch {
+		case msg[i] == ':':
+			if i+1 < len(msg) && isBasicMarkdownEmojiNameChar(msg[i+1]) {
+				return -1
+			}
+			return i
+		case isBasicMarkdownEmojiNameChar(msg[i]):
+			continue
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+func isBasicMarkdownEmojiNameChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') ||
+		(ch >= 'A' && ch <= 'Z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_' || ch == '+' || ch == '-'
+}
+
+func (r *mdRender) replaceBold(msg string) string {
+	var out strings.Builder
+
+	for len(msg) > 0 {
+		start := strings.Index(msg, "**")
+		if start == -1 {
+			out.WriteString(msg)
+			break
+		}
+
+		out.WriteString(msg[:start])
+		msg = msg[start+2:]
+
+		end := strings.Index(msg, "**")
+		if end == -1 {
+			out.WriteString("**")
+			out.WriteString(msg)
+			break
+		}
+
+		inner := msg[:end]
+		if inner == "" {
+			out.WriteString("****")
+		} else {
+			out.WriteString(r.reserve("<strong>", ""))
+			out.WriteString(inner)
+			out.WriteString(r.reserve("</strong>", ""))
+		}
+		msg = msg[end+2:]
+	}
+
+	return out.String()
+}
+
+func (r *mdRender) replaceItalic(msg string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(msg); {
+		if msg[i] != '*' || isAdjacentAsterisk(msg, i) {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+
+		end := findNextSingleAsterisk(msg, i+1)
+		if end == -1 {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+
+		inner := msg[i+1 : end]
+		if inner == "" {
+			out.WriteString("**")
+			i = end + 1
+			continue
+		}
+
+		out.WriteString(r.reserve("<em>", ""))
+		out.WriteString(inner)
+		out.WriteString(r.reserve("</em>", ""))
+		i = end + 1
+	}
+
+	return out.String()
+}
+
+func findNextSingleAsterisk(msg string, start int) int {
+	for i := start; i < len(msg); i++ {
+		if msg[i] == '*' && !isAdjacentAsterisk(msg, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isAdjacentAsterisk(msg string, idx int) bool {
+	return (idx > 0 && msg[idx-1] == '*') || (idx+1 < len(msg) && msg[idx+1] == '*')
+}
+
+func markdownPlaceholder(idx int) string {
+	return fmt.Sprintf("\x00GBMD%d\x00", idx)
+}
+
+func restoreMarkdownPlaceholders(msg string, tokens []string) string {
+	out := msg
+	for i, token := range tokens {
+		out = strings.ReplaceAll(out, markdownPlaceholder(i), token)
+	}
+	return out
+}
+
+// splitMessage breaks msg into at most maxSplit pieces of maxLen
+// characters, preferring newline boundaries and closing/reopening code
+// fences across pieces. Text past the last allowed piece is dropped with a
+// notice.
+func splitMessage(msg string, maxLen, maxSplit int) []string {
+	if utf8.RuneCountInString(msg) <= maxLen {
+		return []string{msg}
+	}
+	// Room for a closing fence and the truncation notice.
+	limit := maxLen - len(matrixTruncatedMessage) - 8
+	if limit < 1 {
+		limit = maxLen
+	}
+	chunks := make([]string, 0, maxSplit)
+	for msg != "" {
+		if utf8.RuneCountInString(msg) <= maxLen {
+			chunks = append(chunks, msg)
+			break
+		}
+		cut := byteIndexForRunes(msg, limit)
+		if nl := strings.LastIndexByte(msg[:cut], '\n'); nl > 0 {
+			cut = nl
+		}
+		chunk := msg[:cut]
+		open := strings.Count(chunk, "```")%2 == 1
+		if open {
+			chunk += "\n```"
+		}
+		if len(chunks) == maxSplit-1 {
+			chunks = append(chunks, chunk+"\n"+matrixTruncatedMessage)
+			break
+		}
+		chunks = append(chunks, chunk)
+		msg = strings.TrimPrefix(msg[cut:], "\n")
+		if open {
+			msg = "```\n" + msg
+		}
+	}
+	return chunks
+}
+
+func byteIndexForRunes(msg string, runes int) int {
+	for i := range msg {
+		if runes == 0 {
+			return i
+		}
+		runes--
+	}
+	return len(msg)
+}
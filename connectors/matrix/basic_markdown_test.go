@@ -0,0 +1,107 @@
+package matrix
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func newRenderTestConnector() *matrixConnector {
+	return &matrixConnector{
+		Handler:          &testHandler{},
+		botUserID:        "@floyd:example.org",
+		botUserMap:       map[string]string{"alice": "@alice:example.org"},
+		displayNames:     map[string]string{"@alice:example.org": "Alice <Ops>"},
+		userIDsByName:    map[string]string{"alice": "@alice:example.org"},
+		maxMessageLength: defaultMaxMessageLength,
+		maxMessageSplit:  1,
+	}
+}
+
+func TestRenderBasicMarkdownToHTML(t *testing.T) {
+	mc := newRenderTestConnector()
+	in := "**Deploy** *status* :rocket: <b>x</b> & see [the **runbook**](https://example.com/a?b=1&c=2)\n```yaml\nkind: Pod # **@alice**\n```\nnext `a<b`"
+	body, formatted, userIDs := mc.renderBasicMarkdown(in)
+	wantHTML := "<strong>Deploy</strong> <em>status</em> \U0001F680 &lt;b&gt;x&lt;/b&gt; &amp; see " +
+		`<a href="https://example.com/a?b=1&amp;c=2">the <strong>runbook</strong></a>` +
+		`<pre><code class="language-yaml">kind: Pod # **@alice**</code></pre>` +
+		"next <code>a&lt;b</code>"
+	if formatted != wantHTML {
+		t.Fatalf("formatted = %q, want %q", formatted, wantHTML)
+	}
+	wantBody := "Deploy status \U0001F680 <b>x</b> & see the runbook (https://example.com/a?b=1&c=2)\n```yaml\nkind: Pod # **@alice**\n```\nnext `a<b`"
+	if body != wantBody {
+		t.Fatalf("body = %q, want %q", body, wantBody)
+	}
+	if len(userIDs) != 0 {
+		t.Fatalf("mentions in code were resolved: %v", userIDs)
+	}
+}
+
+func TestRenderBasicMarkdownMentionsAndEscapes(t *testing.T) {
+	mc := newRenderTestConnector()
+	body, formatted, userIDs := mc.renderBasicMarkdown("Paging @Alice, @david and bob@example.com; \\*not italic\\* \\@alice")
+	wantHTML := `Paging <a href="https://matrix.to/#/@alice:example.org">Alice &lt;Ops&gt;</a>, @david and bob@example.com; *not italic* @alice`
+	if formatted != wantHTML {
+		t.Fatalf("formatted = %q, want %q", formatted, wantHTML)
+	}
+	if body != "Paging Alice <Ops>, @david and bob@example.com; *not italic* @alice" {
+		t.Fatalf("body = %q", body)
+	}
+	if len(userIDs) != 1 || userIDs[0] != "@alice:example.org" {
+		t.Fatalf("mentions = %v", userIDs)
+	}
+}
+
+func TestFormatMessageFixedAndVariable(t *testing.T) {
+	mc := newRenderTestConnector()
+	got := mc.formatMessage("", "a < b\n  **c**\n", robot.Fixed)
+	if len(got) != 1 || got[0].Body != "a < b\n  **c**\n" || got[0].FormattedBody != "<pre><code>a &lt; b\n  **c**</code></pre>" || got[0].Format != matrixHTMLFormat {
+		t.Fatalf("formatMessage(Fixed) = %+v", got[0])
+	}
+	got = mc.formatMessage("", "**as typed**", robot.Variable)
+	if len(got) != 1 || got[0].Body != "**as typed**" || got[0].FormattedBody != "" || got[0].Format != "" {
+		t.Fatalf("formatMessage(Variable) = %+v", got[0])
+	}
+}
+
+func TestNormalizeBotMention(t *testing.T) {
+	mc := newRenderTestConnector()
+	mc.botName, mc.botDisplayName = "floyd", "Floyd Bot"
+	mentioned := &mentions{UserIDs: []string{"@floyd:example.org"}}
+	cases := []struct {
+		content messageContent
+		want    string
+	}{
+		{messageContent{Body: "Floyd Bot: ping", Mentions: mentioned}, "@floyd: ping"},
+		{messageContent{Body: "floyd, ping", Mentions: mentioned}, "@floyd, ping"},
+		{messageContent{Body: "@floyd:example.org ping"}, "@floyd ping"},
+		{messageContent{Body: "Floyd Bot: ping"}, "Floyd Bot: ping"},
+	}
+	for _, c := range cases {
+		if got := mc.normalizeBotMention(c.content); got != c.want {
+			t.Errorf("normalizeBotMention(%q) = %q, want %q", c.content.Body, got, c.want)
+		}
+	}
+}
+
+func TestSplitMessageReopensFencesAndTruncates(t *testing.T) {
+	line := strings.Repeat("x", 30)
+	msg := "```\n" + strings.Repeat(line+"\n", 8) + "```"
+	chunks := splitMessage(msg, 120, 2)
+	if len(chunks) != 2 {
+		t.Fatalf("splitMessage returned %d chunks, want 2: %q", len(chunks), chunks)
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 120 {
+			t.Fatalf("chunk %d is %d characters, over the limit", i, len(chunk))
+		}
+		if strings.Count(chunk, "```")%2 != 0 {
+			t.Fatalf("chunk %d leaves a fence open: %q", i, chunk)
+		}
+	}
+	if !strings.HasPrefix(chunks[1], "```\n") || !strings.HasSuffix(chunks[1], matrixTruncatedMessage) {
+		t.Fatalf("last chunk = %q, want reopened fence and truncation notice", chunks[1])
+	}
+}
@@ -0,0 +1,279 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client is a minimal Matrix client-server API client; it's passed to Go
+// extensions as ConnectorMessage.Client.
+type Client struct {
+	baseURL string // homeserver URL without a trailing slash
+	token   string
+	http    *http.Client
+}
+
+// Event is a Matrix room or account data event; incoming messages carry it
+// as ConnectorMessage.MessageObject.
+type Event struct {
+	Type      string          `json:"type"`
+	EventID   string          `json:"event_id,omitempty"`
+	RoomID    string          `json:"room_id,omitempty"`
+	Sender    string          `json:"sender,omitempty"`
+	StateKey  *string         `json:"state_key,omitempty"`
+	Timestamp int64           `json:"origin_server_ts,omitempty"`
+	Content   json.RawMessage `json:"content"`
+}
+
+type eventList struct {
+	Events []Event `json:"events"`
+}
+
+type joinedRoom struct {
+	State    eventList `json:"state"`
+	Timeline struct {
+		Events  []Event `json:"events"`
+		Limited bool    `json:"limited"`
+	} `json:"timeline"`
+	Summary struct {
+		JoinedMembers  *int `json:"m.joined_member_count"`
+		InvitedMembers *int `json:"m.invited_member_count"`
+	} `json:"summary"`
+}
+
+type invitedRoom struct {
+	InviteState eventList `json:"invite_state"`
+}
+
+type syncResponse struct {
+	NextBatch   string    `json:"next_batch"`
+	AccountData eventList `json:"account_data"`
+	Rooms       struct {
+		Join   map[string]joinedRoom  `json:"join"`
+		Invite map[string]invitedRoom `json:"invite"`
+		Leave  map[string]joinedRoom  `json:"leave"`
+	} `json:"rooms"`
+}
+
+// messageContent is the content of an m.room.message event.
+type messageContent struct {
+	MsgType       string     `json:"msgtype"`
+	Body          string     `json:"body"`
+	Format        string     `json:"format,omitempty"`
+	FormattedBody string     `json:"formatted_body,omitempty"`
+	RelatesTo     *relatesTo `json:"m.relates_to,omitempty"`
+	Mentions      *mentions  `json:"m.mentions,omitempty"`
+}
+
+type relatesTo struct {
+	RelType       string     `json:"rel_type,omitempty"`
+	EventID       string     `json:"event_id,omitempty"`
+	IsFallingBack bool       `json:"is_falling_back,omitempty"`
+	InReplyTo     *inReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type inReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+type mentions struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+}
+
+// APIError is a non-2xx response from the homeserver.
+type APIError struct {
+	StatusCode   int
+	ErrCode      string `json:"errcode"`
+	Message      string `json:"error"`
+	RetryAfterMS int64  `json:"retry_after_ms"`
+}
+
+func (e *APIError) Error() string {
+	if e.ErrCode == "" {
+		return fmt.Sprintf("matrix API returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("matrix API returned HTTP %d: %s: %s", e.StatusCode, e.ErrCode, e.Message)
+}
+
+func newClient(homeserverURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(homeserverURL, "/"),
+		token:   token,
+		// Long enough for a /sync long poll plus slack.
+		http: &http.Client{Timeout: syncTimeout + 30*time.Second},
+	}
+}
+
+// Do sends an authenticated request to path under /_matrix/client/v3,
+// JSON-encoding body when it's non-nil and decoding the response into out
+// when it's non-nil.
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/_matrix/client/v3"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = json.Unmarshal(data, apiErr)
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) whoami(ctx context.Context) (string, error) {
+	var resp struct {
+		UserID string `json:"user_id"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/account/whoami", nil, &resp)
+	return resp.UserID, err
+}
+
+func (c *Client) getDisplayName(ctx context.Context, userID string) (string, error) {
+	var resp struct {
+		DisplayName string `json:"displayname"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/profile/"+url.PathEscape(userID), nil, &resp)
+	return resp.DisplayName, err
+}
+
+func (c *Client) sync(ctx context.Context, since, filter string, timeout time.Duration) (*syncResponse, error) {
+	q := url.Values{}
+	q.Set("timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+	if since != "" {
+		q.Set("since", since)
+	}
+	if filter != "" {
+		q.Set("filter", filter)
+	}
+	resp := &syncResponse{}
+	err := c.Do(ctx, http.MethodGet, "/sync?"+q.Encode(), nil, resp)
+	return resp, err
+}
+
+func (c *Client) resolveAlias(ctx context.Context, alias string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/directory/room/"+url.PathEscape(alias), nil, &resp)
+	return resp.RoomID, err
+}
+
+func (c *Client) joinRoom(ctx context.Context, roomIDOrAlias string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	err := c.Do(ctx, http.MethodPost, "/join/"+url.PathEscape(roomIDOrAlias), map[string]string{}, &resp)
+	return resp.RoomID, err
+}
+
+func (c *Client) createDirectRoom(ctx context.Context, userID string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	err := c.Do(ctx, http.MethodPost, "/createRoom", map[string]interface{}{
+		"is_direct": true,
+		"invite":    []string{userID},
+		"preset":    "trusted_private_chat",
+	}, &resp)
+	return resp.RoomID, err
+}
+
+func (c *Client) setAccountData(ctx context.Context, userID, dataType string, content interface{}) error {
+	return c.Do(ctx, http.MethodPut, "/user/"+url.PathEscape(userID)+"/account_data/"+url.PathEscape(dataType), content, nil)
+}
+
+func (c *Client) sendMessage(ctx context.Context, roomID, txnID string, content *messageContent) (string, error) {
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	err := c.Do(ctx, http.MethodPut, "/rooms/"+url.PathEscape(roomID)+"/send/m.room.message/"+url.PathEscape(txnID), content, &resp)
+	return resp.EventID, err
+}
+
+func (c *Client) setTyping(ctx context.Context, roomID, userID string, timeout time.Duration) error {
+	return c.Do(ctx, http.MethodPut, "/rooms/"+url.PathEscape(roomID)+"/typing/"+url.PathEscape(userID), map[string]interface{}{
+		"typing":  true,
+		"timeout": timeout.Milliseconds(),
+	}, nil)
+}
+
+func apiErrorRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func apiErrorNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.ErrCode == "M_NOT_FOUND")
+}
+
+func apiErrorUnauthorized(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.ErrCode == "M_UNKNOWN_TOKEN")
+}
+
+// retryDelay honors the homeserver's retry_after_ms on rate limiting.
+func retryDelay(err error, fallback time.Duration) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfterMS > 0 {
+		return time.Duration(apiErr.RetryAfterMS) * time.Millisecond
+	}
+	return fallback
+}
+
+// localpart returns "alice" for "@alice:example.org".
+func localpart(userID string) string {
+	id := strings.TrimPrefix(userID, "@")
+	if i := strings.IndexByte(id, ':'); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// serverName returns "example.org" for "@alice:example.org".
+func serverName(userID string) string {
+	if i := strings.IndexByte(userID, ':'); i >= 0 {
+		return userID[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+// Package matrix implements the robot.Connector interface for Matrix, using
+// the client-server API: incoming events come from a /sync long poll and
+// everything else is plain REST.
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	// Matrix events are limited to 64KiB including HTML and JSON overhead,
+	// so the source text gets a conservative default.
+	defaultMaxMessageLength = 16000
+	apiTimeout              = 30 * time.Second
+	syncTimeout             = 30 * time.Second
+	reconnectMinDelay       = 2 * time.Second
+	reconnectMaxDelay       = 2 * time.Minute
+)
+
+// initialSyncFilter keeps the first sync from replaying room history; it's
+// only used to learn room state.
+const initialSyncFilter = `{"room":{"timeline":{"limit":1}}}`
+
+var errUnauthorized = errors.New("matrix homeserver rejected the connector access token")
+
+type config struct {
+	HomeserverURL    string // base URL of the homeserver's client API, e.g. https://matrix.example.org
+	AccessToken      string // access token for the bot account
+	AutoJoin         bool   // accept room invitations automatically
+	MaxMessageSplit  int    // the maximum number of messages to emit for one long outbound send
+	MaxMessageLength int    // the maximum source message size in characters
+	UserMap          map[string]string
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, id := range in {
+		name := strings.TrimSpace(user)
+		uid := strings.TrimSpace(id)
+		if name == "" || uid == "" {
+			h.Log(robot.Warn, "Ignoring invalid Matrix UserMap entry (empty username or user ID): %q -> %q", user, id)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring Matrix UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		if !strings.HasPrefix(uid, "@") || serverName(uid) == "" {
+			h.Log(robot.Warn, "Ignoring Matrix UserMap entry for %q: %q is not a full user ID like @alice:example.org", user, id)
+			continue
+		}
+		out[name] = uid
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func newConnector(handler robot.Handler, c config) (*matrixConnector, error) {
+	homeserver := strings.TrimSpace(c.HomeserverURL)
+	if homeserver == "" {
+		return nil, fmt.Errorf("Matrix protocol config requires HomeserverURL")
+	}
+	if !strings.HasPrefix(homeserver, "https://") && !strings.HasPrefix(homeserver, "http://") {
+		return nil, fmt.Errorf("invalid Matrix HomeserverURL %q: must start with https:// or http://", homeserver)
+	}
+	token := strings.TrimSpace(c.AccessToken)
+	if token == "" {
+		return nil, fmt.Errorf("Matrix protocol config requires AccessToken")
+	}
+	if c.MaxMessageSplit <= 0 {
+		c.MaxMessageSplit = 1
+	}
+	if c.MaxMessageLength <= 0 {
+		c.MaxMessageLength = defaultMaxMessageLength
+	}
+	return &matrixConnector{
+		Handler:          handler,
+		api:              newClient(homeserver, token),
+		autoJoin:         c.AutoJoin,
+		maxMessageSplit:  c.MaxMessageSplit,
+		maxMessageLength: c.MaxMessageLength,
+		botUserMap:       normalizeConfiguredUserMap(c.UserMap, handler),
+		displayNames:     make(map[string]string),
+		userIDsByName:    make(map[string]string),
+		rooms:            make(map[string]*roomInfo),
+		roomIDsByName:    make(map[string]string),
+		directRooms:      make(map[string]string),
+		directRoomUsers:  make(map[string]string),
+		reconnectDelay:   reconnectMinDelay,
+		txnPrefix:        fmt.Sprintf("gb%d", time.Now().UnixNano()),
+	}, nil
+}
+
+// Initialize validates config, looks up the bot account and returns the
+// connector.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to retrieve matrix protocol configuration: %w", err)}
+	}
+	mc, err := newConnector(handler, c)
+	if err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	userID, err := mc.api.whoami(ctx)
+	if err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to look up the Matrix bot account: %w", err)}
+	}
+	mc.botUserID = userID
+	mc.botName = localpart(userID)
+	mc.cacheUser(userID, "")
+	if name, err := mc.api.getDisplayName(ctx, userID); err == nil {
+		mc.botDisplayName = name
+		mc.cacheUser(userID, name)
+	}
+	handler.Log(robot.Info, "Matrix connector using bot account '%s'", mc.botUserID)
+	handler.SetBotID(mc.botUserID)
+	// Incoming mentions of the bot are normalized to @localpart.
+	handler.SetBotMention(mc.botName)
+	return robot.InitializedConnector{Connector: mc}
+}
+
+// Reload swaps in the configured UserMap; homeserver and credential changes
+// need a restart.
+func (mc *matrixConnector) Reload() error {
+	var c config
+	if err := mc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Matrix protocol configuration: %w", err)
+	}
+	userMap := normalizeConfiguredUserMap(c.UserMap, mc.Handler)
+	mc.Lock()
+	mc.botUserMap = userMap
+	mc.Unlock()
+	mc.Log(robot.Info, "Matrix connector reloaded %d configured user mapping(s)", len(userMap))
+	return nil
+}
+
+// Run long-polls /sync until stop is closed, backing off after transient
+// failures. Only a rejected access token ends it early.
+func (mc *matrixConnector) Run(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	delay := mc.reconnectDelay
+	for {
+		err := mc.syncOnce(ctx)
+		if ctx.Err() != nil {
+			mc.Log(robot.Debug, "Received stop in connector")
+			return nil
+		}
+		if err == nil {
+			delay = mc.reconnectDelay
+			continue
+		}
+		if apiErrorUnauthorized(err) {
+			return fmt.Errorf("%w: %v", errUnauthorized, err)
+		}
+		wait := retryDelay(err, delay)
+		mc.Log(robot.Warn, "Matrix sync failed; retrying in %v: %v", wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// syncOnce runs one /sync request and handles its events. The first sync
+// only learns room state; messages sent while the robot was down aren't
+// replayed.
+func (mc *matrixConnector) syncOnce(ctx context.Context) error {
+	initial := mc.since == ""
+	filter, timeout := "", syncTimeout
+	if initial {
+		filter, timeout = initialSyncFilter, 0
+	}
+	resp, err := mc.api.sync(ctx, mc.since, filter, timeout)
+	if err != nil {
+		return err
+	}
+	mc.handleSync(resp, initial)
+	mc.since = resp.NextBatch
+	if initial {
+		mc.Log(robot.Debug, "Matrix initial sync complete")
+	}
+	return nil
+}
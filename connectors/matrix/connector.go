@@ -0,0 +1,388 @@
+package matrix
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	matrixSendTimeout  = 30 * time.Second
+	matrixSendAttempts = 3
+	typingTimeout      = 10 * time.Second
+)
+
+type matrixConnector struct {
+	robot.Handler
+	sync.RWMutex                        // protects the maps below
+	api               *Client           // client-server API client
+	botUserID         string            // full Matrix user ID of the bot
+	botName           string            // localpart of the bot's user ID
+	botDisplayName    string            // the bot's display name, if set
+	autoJoin          bool              // accept room invitations
+	maxMessageSplit   int               // the maximum number of messages for one long send
+	maxMessageLength  int               // the maximum source message size
+	botUserMap        map[string]string // connector-local configured mappings of username to Matrix user ID
+	displayNames      map[string]string // Matrix user ID to display name
+	userIDsByName     map[string]string // localpart to Matrix user ID, for users seen
+	rooms             map[string]*roomInfo
+	roomIDsByName     map[string]string
+	directRooms       map[string]string   // user ID to DM room ID
+	directRoomUsers   map[string]string   // DM room ID to user ID
+	directAccountData map[string][]string // the bot's m.direct account data
+
+	since          string // sync token; only touched by Run
+	reconnectDelay time.Duration
+	sendLock       sync.Mutex // keeps outbound messages in order
+	txnPrefix      string
+	txnSeq         int
+	retrySleep     func(context.Context, time.Duration) error
+}
+
+// cacheUser records a user ID, and its display name when it's known.
+func (mc *matrixConnector) cacheUser(userID, displayName string) {
+	mc.Lock()
+	mc.userIDsByName[localpart(userID)] = userID
+	if displayName != "" {
+		mc.displayNames[userID] = displayName
+	}
+	mc.Unlock()
+}
+
+// noteUser remembers a message sender's localpart.
+func (mc *matrixConnector) noteUser(userID string) {
+	mc.RLock()
+	_, ok := mc.userIDsByName[localpart(userID)]
+	mc.RUnlock()
+	if !ok {
+		mc.cacheUser(userID, "")
+	}
+}
+
+// displayName returns the user's display name, looking it up on first use.
+func (mc *matrixConnector) displayName(userID string) (string, bool) {
+	mc.RLock()
+	name, ok := mc.displayNames[userID]
+	mc.RUnlock()
+	if ok {
+		return name, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	name, err := mc.api.getDisplayName(ctx, userID)
+	if err != nil {
+		if !apiErrorNotFound(err) {
+			mc.Log(robot.Error, "Looking up Matrix profile for '%s': %v", userID, err)
+		}
+		return "", false
+	}
+	if name == "" {
+		name = localpart(userID)
+	}
+	mc.cacheUser(userID, name)
+	return name, true
+}
+
+// userID resolves a canonical username to a Matrix user ID; configured
+// UserMap entries win over same-named Matrix accounts. Full user IDs are
+// taken as-is, and otherwise unknown names are tried on the bot's
+// homeserver.
+func (mc *matrixConnector) userID(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, "@") && serverName(name) != "" {
+		return name, true
+	}
+	name = strings.ToLower(name)
+	if name == "" {
+		return "", false
+	}
+	mc.RLock()
+	id, ok := mc.botUserMap[name]
+	if !ok {
+		id, ok = mc.userIDsByName[name]
+	}
+	mc.RUnlock()
+	if ok {
+		return id, true
+	}
+	id = "@" + name + ":" + serverName(mc.botUserID)
+	if _, ok := mc.displayName(id); !ok {
+		return "", false
+	}
+	return id, true
+}
+
+func (mc *matrixConnector) configuredCanonicalUser(id string) (string, bool) {
+	mc.RLock()
+	defer mc.RUnlock()
+	for name, uid := range mc.botUserMap {
+		if uid == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// roomID takes a bracketed ID from a roster, a room ID, an alias or a
+// channel name.
+func (mc *matrixConnector) roomID(ch string) (string, bool) {
+	if id, ok := util.ExtractID(ch); ok {
+		return id, true
+	}
+	ch = strings.TrimSpace(ch)
+	if strings.HasPrefix(ch, "!") {
+		return ch, true
+	}
+	if ch == "" {
+		return "", false
+	}
+	if !strings.HasPrefix(ch, "#") {
+		mc.RLock()
+		id, ok := mc.roomIDsByName[ch]
+		mc.RUnlock()
+		if ok {
+			return id, true
+		}
+	}
+	alias := ch
+	if !strings.HasPrefix(alias, "#") {
+		alias = "#" + alias
+	}
+	if serverName(alias) == "" {
+		alias += ":" + serverName(mc.botUserID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	id, err := mc.api.resolveAlias(ctx, alias)
+	if err != nil {
+		if !apiErrorNotFound(err) {
+			mc.Log(robot.Error, "Resolving Matrix room alias '%s': %v", alias, err)
+		}
+		return "", false
+	}
+	return id, id != ""
+}
+
+func (mc *matrixConnector) rememberDirectRoom(userID, roomID string) {
+	mc.Lock()
+	mc.directRooms[userID] = roomID
+	mc.directRoomUsers[roomID] = userID
+	direct := make(map[string][]string, len(mc.directAccountData)+1)
+	for uid, rooms := range mc.directAccountData {
+		direct[uid] = rooms
+	}
+	direct[userID] = append(append([]string(nil), direct[userID]...), roomID)
+	mc.directAccountData = direct
+	mc.Unlock()
+
+	// Saving m.direct lets clients and later restarts see the room as a DM.
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	if err := mc.api.setAccountData(ctx, mc.botUserID, "m.direct", direct); err != nil {
+		mc.Log(robot.Warn, "Saving Matrix m.direct account data: %v", err)
+	}
+}
+
+func (mc *matrixConnector) directRoomID(userID string) (string, bool) {
+	mc.RLock()
+	id, ok := mc.directRooms[userID]
+	mc.RUnlock()
+	if ok {
+		return id, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	id, err := mc.api.createDirectRoom(ctx, userID)
+	if err != nil {
+		mc.Log(robot.Error, "Opening Matrix direct room with '%s': %v", userID, err)
+		return "", false
+	}
+	mc.rememberDirectRoom(userID, id)
+	return id, true
+}
+
+// GetProtocolUserAttribute returns a string attribute or "" if Matrix
+// doesn't have that information.
+func (mc *matrixConnector) GetProtocolUserAttribute(u, attr string) (value string, ret robot.RetVal) {
+	id, ok := util.ExtractID(u)
+	if !ok {
+		id, ok = mc.userID(u)
+	}
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	switch attr {
+	case "internalid":
+		return id, robot.Ok
+	case "realname", "fullname", "real name", "full name", "displayname":
+		name, ok := mc.displayName(id)
+		if !ok {
+			return "", robot.UserNotFound
+		}
+		return name, robot.Ok
+	default:
+		// Matrix profiles don't expose email or phone.
+		return "", robot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a typing notification.
+func (mc *matrixConnector) MessageHeard(user, channel string) {
+	roomID, ok := util.ExtractID(channel)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	if err := mc.api.setTyping(ctx, roomID, mc.botUserID, typingTimeout); err != nil {
+		mc.Log(robot.Debug, "Matrix typing notification failed: %v", err)
+	}
+}
+
+func (mc *matrixConnector) DefaultHelp() []string {
+	return nil
+}
+
+// JoinChannel joins a room by ID, alias or name.
+func (mc *matrixConnector) JoinChannel(c string) robot.RetVal {
+	target := strings.TrimSpace(c)
+	if id, ok := util.ExtractID(c); ok {
+		target = id
+	} else if !strings.HasPrefix(target, "!") && !strings.HasPrefix(target, "#") {
+		id, ok := mc.roomID(target)
+		if !ok {
+			mc.Log(robot.Error, "Matrix room not found for: %s", c)
+			return robot.ChannelNotFound
+		}
+		target = id
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	if roomID, err := mc.api.joinRoom(ctx, target); err != nil {
+		mc.Log(robot.Error, "Joining room '%s': %v", c, err)
+	} else {
+		mc.Log(robot.Debug, "Joined room %s/%s", c, roomID)
+	}
+	return robot.Ok
+}
+
+func (mc *matrixConnector) sleepRetry(ctx context.Context, delay time.Duration) error {
+	if mc.retrySleep != nil {
+		return mc.retrySleep(ctx, delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendMessages sends every message in order; Ok means the homeserver
+// accepted all of them. Encrypted rooms are refused, since a plaintext
+// reply would leak what the room meant to keep private.
+func (mc *matrixConnector) sendMessages(roomID, threadID string, contents []*messageContent) robot.RetVal {
+	if len(contents) == 0 {
+		return robot.FailedMessageSend
+	}
+	mc.RLock()
+	r := mc.rooms[roomID]
+	encrypted := r != nil && r.Encrypted
+	mc.RUnlock()
+	if encrypted {
+		mc.Log(robot.Error, "Not sending to end-to-end encrypted Matrix room '%s'; the Matrix connector doesn't support E2EE", roomID)
+		return robot.FailedMessageSend
+	}
+	mc.sendLock.Lock()
+	defer mc.sendLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), matrixSendTimeout)
+	defer cancel()
+	for _, content := range contents {
+		if threadID != "" {
+			content.RelatesTo = &relatesTo{
+				RelType:       "m.thread",
+				EventID:       threadID,
+				IsFallingBack: true,
+				InReplyTo:     &inReplyTo{EventID: threadID},
+			}
+		}
+		mc.txnSeq++
+		// The transaction ID stays the same across retries so the
+		// homeserver drops duplicates.
+		txnID := mc.txnPrefix + "." + strconv.Itoa(mc.txnSeq)
+		var err error
+		for attempt := 0; attempt < matrixSendAttempts; attempt++ {
+			if _, err = mc.api.sendMessage(ctx, roomID, txnID, content); err == nil {
+				break
+			}
+			if attempt == matrixSendAttempts-1 || !apiErrorRetryable(err) {
+				break
+			}
+			delay := retryDelay(err, time.Second<<attempt)
+			mc.Log(robot.Warn, "Sending Matrix message to room '%s' failed (attempt %d/%d); retrying in %v: %v", roomID, attempt+1, matrixSendAttempts, delay, err)
+			if serr := mc.sleepRetry(ctx, delay); serr != nil {
+				err = serr
+				break
+			}
+		}
+		if err != nil {
+			mc.Log(robot.Error, "Failed sending Matrix message to room '%s': %v", roomID, err)
+			return robot.FailedMessageSend
+		}
+	}
+	return robot.Ok
+}
+
+// SendProtocolChannelThreadMessage sends a message to a room, replying in
+// the thread rooted at thr when set.
+func (mc *matrixConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	roomID, ok := mc.roomID(ch)
+	if !ok {
+		mc.Log(robot.Error, "Matrix room not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	return mc.sendMessages(roomID, thr, mc.formatMessage("", msg, f))
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a room addressed
+// to a user with a mention.
+func (mc *matrixConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	roomID, ok := mc.roomID(ch)
+	if !ok {
+		mc.Log(robot.Error, "Matrix room not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	userID, ok := util.ExtractID(uid)
+	if !ok {
+		userID, ok = mc.userID(u)
+	}
+	if !ok {
+		mc.Log(robot.Error, "Matrix user ID not found for: %s", u)
+		return robot.UserNotFound
+	}
+	return mc.sendMessages(roomID, thr, mc.formatMessage(userID, msg, f))
+}
+
+// SendProtocolUserMessage sends a direct message to a user.
+func (mc *matrixConnector) SendProtocolUserMessage(u, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	userID, ok := util.ExtractID(u)
+	if !ok {
+		userID, ok = mc.userID(u)
+	}
+	if !ok {
+		mc.Log(robot.Error, "No Matrix user ID found for user: %s", u)
+		return robot.UserNotFound
+	}
+	roomID, ok := mc.directRoomID(userID)
+	if !ok {
+		return robot.FailedMessageSend
+	}
+	return mc.sendMessages(roomID, "", mc.formatMessage("", msg, f))
+}
@@ -0,0 +1,391 @@
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	protocolConfig *config
+	botID          string
+	botMention     string
+	incoming       chan *robot.ConnectorMessage
+}
+
+func (t *testHandler) IncomingMessage(m *robot.ConnectorMessage) {
+	if t.incoming != nil {
+		t.incoming <- m
+	}
+}
+func (t *testHandler) GetProtocolConfig(v interface{}) error {
+	if t.protocolConfig != nil {
+		*(v.(*config)) = *t.protocolConfig
+	}
+	return nil
+}
+func (t *testHandler) GetBrainConfig(_ interface{}) error         { return nil }
+func (t *testHandler) GetEventStrings() *[]string                 { return nil }
+func (t *testHandler) GetHistoryConfig(_ interface{}) error       { return nil }
+func (t *testHandler) GetBotInfo() robot.BotInfo                  { return robot.BotInfo{} }
+func (t *testHandler) SetBotID(id string)                         { t.botID = id }
+func (t *testHandler) SetTerminalWriter(_ io.Writer)              {}
+func (t *testHandler) SetBotMention(m string)                     { t.botMention = m }
+func (t *testHandler) GetLogLevel() robot.LogLevel                { return robot.Info }
+func (t *testHandler) GetInstallPath() string                     { return "" }
+func (t *testHandler) GetConfigPath() string                      { return "" }
+func (t *testHandler) ReadEncryptedFile(_ string) ([]byte, error) { return nil, nil }
+func (t *testHandler) Log(_ robot.LogLevel, _ string, _ ...interface{}) {
+}
+func (t *testHandler) GetDirectory(_ string) error { return nil }
+
+type sentMessage struct {
+	RoomID  string
+	Content messageContent
+}
+
+// fakeHomeserver is a stand-in for a Matrix homeserver's client API.
+type fakeHomeserver struct {
+	*httptest.Server
+	sync.Mutex
+	syncs       []string // canned /sync responses, served in order
+	syncCalls   int
+	sent        []sentMessage
+	txnIDs      map[string]bool
+	directData  map[string][]string
+	createdRoom bool
+}
+
+func newFakeHomeserver(t *testing.T) *fakeHomeserver {
+	t.Helper()
+	fs := &fakeHomeserver{txnIDs: make(map[string]bool)}
+	profiles := map[string]string{
+		"@floyd:example.org": "Floyd",
+		"@alice:example.org": "Alice Smith",
+	}
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	notFound := func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"errcode": "M_NOT_FOUND", "error": "not found"})
+	}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token passed."})
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3")
+		switch {
+		case path == "/account/whoami":
+			writeJSON(w, map[string]string{"user_id": "@floyd:example.org"})
+		case strings.HasPrefix(path, "/profile/"):
+			if name, ok := profiles[strings.TrimPrefix(path, "/profile/")]; ok {
+				writeJSON(w, map[string]string{"displayname": name})
+				return
+			}
+			notFound(w)
+		case path == "/sync":
+			fs.Lock()
+			n := fs.syncCalls
+			fs.syncCalls++
+			fs.Unlock()
+			if n < len(fs.syncs) {
+				w.Write([]byte(fs.syncs[n]))
+				return
+			}
+			select {
+			case <-r.Context().Done():
+			case <-time.After(20 * time.Millisecond):
+			}
+			writeJSON(w, map[string]string{"next_batch": "idle"})
+		case path == "/directory/room/#ops:example.org":
+			writeJSON(w, map[string]string{"room_id": "!ops:example.org"})
+		case strings.HasPrefix(path, "/directory/room/"):
+			notFound(w)
+		case strings.HasPrefix(path, "/join/"):
+			writeJSON(w, map[string]string{"room_id": "!ops:example.org"})
+		case path == "/createRoom":
+			var req struct {
+				IsDirect bool     `json:"is_direct"`
+				Invite   []string `json:"invite"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if !req.IsDirect || len(req.Invite) != 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fs.Lock()
+			fs.createdRoom = true
+			fs.Unlock()
+			writeJSON(w, map[string]string{"room_id": "!dm-" + localpart(req.Invite[0]) + ":example.org"})
+		case path == "/user/@floyd:example.org/account_data/m.direct":
+			fs.Lock()
+			json.NewDecoder(r.Body).Decode(&fs.directData)
+			fs.Unlock()
+			writeJSON(w, map[string]string{})
+		case strings.HasPrefix(path, "/rooms/") && strings.Contains(path, "/send/m.room.message/"):
+			parts := strings.Split(strings.TrimPrefix(path, "/rooms/"), "/")
+			var content messageContent
+			json.NewDecoder(r.Body).Decode(&content)
+			fs.Lock()
+			if fs.txnIDs[parts[3]] {
+				fs.Unlock()
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fs.txnIDs[parts[3]] = true
+			fs.sent = append(fs.sent, sentMessage{RoomID: parts[0], Content: content})
+			fs.Unlock()
+			writeJSON(w, map[string]string{"event_id": "$sent"})
+		case strings.HasPrefix(path, "/rooms/") && strings.Contains(path, "/typing/"):
+			writeJSON(w, map[string]string{})
+		default:
+			notFound(w)
+		}
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(data)
+}
+
+func stateEvent(evtType string, content interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": evtType, "state_key": "", "sender": "@admin:example.org", "content": content}
+}
+
+func messageEvent(id, sender string, ts int64, content interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "m.room.message", "event_id": id, "sender": sender, "origin_server_ts": ts, "content": content}
+}
+
+func TestInitializeIdentifiesBot(t *testing.T) {
+	fs := newFakeHomeserver(t)
+	h := &testHandler{protocolConfig: &config{HomeserverURL: fs.URL + "/", AccessToken: "secret"}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	mc := ic.Connector.(*matrixConnector)
+	if h.botID != "@floyd:example.org" || h.botMention != "floyd" || mc.botDisplayName != "Floyd" {
+		t.Fatalf("bot ID %q, mention %q, display name %q", h.botID, h.botMention, mc.botDisplayName)
+	}
+	h.protocolConfig.AccessToken = ""
+	if ic := Initialize(h, nil); ic.Error == nil {
+		t.Fatal("Initialize accepted an empty AccessToken")
+	}
+}
+
+func TestRunDeliversMessagesAndRefusesEncryptedRooms(t *testing.T) {
+	fs := newFakeHomeserver(t)
+	fs.syncs = []string{
+		mustJSON(t, map[string]interface{}{
+			"next_batch": "s1",
+			"account_data": map[string]interface{}{"events": []interface{}{
+				map[string]interface{}{"type": "m.direct", "content": map[string][]string{"@alice:example.org": {"!dm:example.org"}}},
+			}},
+			"rooms": map[string]interface{}{"join": map[string]interface{}{
+				"!ops:example.org": map[string]interface{}{
+					"state": map[string]interface{}{"events": []interface{}{
+						stateEvent("m.room.canonical_alias", map[string]string{"alias": "#ops:example.org"}),
+					}},
+					// History from before startup isn't replayed.
+					"timeline": map[string]interface{}{"events": []interface{}{
+						messageEvent("$old", "@alice:example.org", 1, map[string]string{"msgtype": "m.text", "body": "floyd, ping"}),
+					}},
+				},
+				"!secret:example.org": map[string]interface{}{
+					"state": map[string]interface{}{"events": []interface{}{
+						stateEvent("m.room.encryption", map[string]string{"algorithm": "m.megolm.v1.aes-sha2"}),
+					}},
+				},
+			}},
+		}),
+		mustJSON(t, map[string]interface{}{
+			"next_batch": "s2",
+			"rooms": map[string]interface{}{"join": map[string]interface{}{
+				"!ops:example.org": map[string]interface{}{
+					"timeline": map[string]interface{}{"events": []interface{}{
+						messageEvent("$thread", "@alice:example.org", 10, map[string]interface{}{
+							"msgtype":        "m.text",
+							"body":           "Floyd: ping",
+							"format":         "org.matrix.custom.html",
+							"formatted_body": `<a href="https://matrix.to/#/@floyd:example.org">Floyd</a>: ping`,
+							"m.mentions":     map[string][]string{"user_ids": {"@floyd:example.org"}},
+							"m.relates_to":   map[string]interface{}{"rel_type": "m.thread", "event_id": "$root"},
+						}),
+						messageEvent("$edit", "@alice:example.org", 11, map[string]interface{}{
+							"msgtype":      "m.text",
+							"body":         "* Floyd: pong",
+							"m.relates_to": map[string]string{"rel_type": "m.replace", "event_id": "$thread"},
+						}),
+						messageEvent("$self", "@floyd:example.org", 40, map[string]string{"msgtype": "m.text", "body": "pong"}),
+					}},
+				},
+				"!dm:example.org": map[string]interface{}{
+					"timeline": map[string]interface{}{"events": []interface{}{
+						messageEvent("$dm", "@alice:example.org", 20, map[string]string{"msgtype": "m.text", "body": "help"}),
+					}},
+				},
+				"!secret:example.org": map[string]interface{}{
+					"timeline": map[string]interface{}{"events": []interface{}{
+						map[string]interface{}{"type": "m.room.encrypted", "event_id": "$enc", "sender": "@alice:example.org", "origin_server_ts": 30, "content": map[string]string{"algorithm": "m.megolm.v1.aes-sha2"}},
+					}},
+				},
+			}},
+		}),
+	}
+	h := &testHandler{
+		protocolConfig: &config{HomeserverURL: fs.URL, AccessToken: "secret", UserMap: map[string]string{"alice": "@alice:example.org"}},
+		incoming:       make(chan *robot.ConnectorMessage, 4),
+	}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	mc := ic.Connector.(*matrixConnector)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- mc.Run(stop) }()
+
+	var got []*robot.ConnectorMessage
+	for len(got) < 3 {
+		select {
+		case m := <-h.incoming:
+			got = append(got, m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d messages, want 3", len(got))
+		}
+	}
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned %v after stop", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after stop")
+	}
+	if len(h.incoming) != 0 {
+		t.Fatalf("%d unexpected extra messages", len(h.incoming))
+	}
+
+	thread, direct, self := got[0], got[1], got[2]
+	if thread.MessageID != "$thread" || thread.ThreadID != "$root" || !thread.ThreadedMessage || thread.ChannelName != "ops" || thread.DirectMessage {
+		t.Fatalf("threaded message = %+v", thread)
+	}
+	if thread.MessageText != "@floyd: ping" || thread.UserName != "alice" || !thread.ValidatedUser || thread.Protocol != "matrix" {
+		t.Fatalf("threaded message text %q, user %q validated %t protocol %q", thread.MessageText, thread.UserName, thread.ValidatedUser, thread.Protocol)
+	}
+	if !direct.DirectMessage || direct.ChannelName != "" || direct.ThreadID != "$dm" || direct.ThreadedMessage {
+		t.Fatalf("direct message = %+v", direct)
+	}
+	if !self.SelfMessage || self.UserName != "floyd" || self.ValidatedUser {
+		t.Fatalf("self message = %+v", self)
+	}
+	if ret := mc.SendProtocolChannelThreadMessage("<!secret:example.org>", "", "hi", robot.Raw, nil); ret != robot.FailedMessageSend {
+		t.Fatalf("send to encrypted room = %v, want FailedMessageSend", ret)
+	}
+}
+
+func TestRunRejectsBadToken(t *testing.T) {
+	fs := newFakeHomeserver(t)
+	mc, err := newConnector(&testHandler{}, config{HomeserverURL: fs.URL, AccessToken: "wrong"})
+	if err != nil {
+		t.Fatalf("newConnector: %v", err)
+	}
+	if err := mc.Run(make(chan struct{})); !errors.Is(err, errUnauthorized) {
+		t.Fatalf("Run with a bad token = %v, want errUnauthorized", err)
+	}
+}
+
+func TestSendsUseThreadsAndDirectRooms(t *testing.T) {
+	fs := newFakeHomeserver(t)
+	h := &testHandler{protocolConfig: &config{HomeserverURL: fs.URL, AccessToken: "secret", UserMap: map[string]string{"alice": "@alice:example.org"}}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	mc := ic.Connector.(*matrixConnector)
+
+	if ret := mc.SendProtocolUserChannelThreadMessage("", "alice", "ops", "$root", "done, **@alice**", robot.BasicMarkdown, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolUserChannelThreadMessage = %v", ret)
+	}
+	if ret := mc.SendProtocolUserMessage("alice", "psst", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolUserMessage = %v", ret)
+	}
+	if ret := mc.SendProtocolUserMessage("alice", "again", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("second SendProtocolUserMessage = %v", ret)
+	}
+	if ret := mc.SendProtocolChannelThreadMessage("missing", "", "hi", robot.Raw, nil); ret != robot.ChannelNotFound {
+		t.Fatalf("send to unknown room = %v, want ChannelNotFound", ret)
+	}
+	if ret := mc.JoinChannel("#ops:example.org"); ret != robot.Ok {
+		t.Fatalf("JoinChannel = %v", ret)
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	if len(fs.sent) != 3 {
+		t.Fatalf("sent = %+v, want 3 messages", fs.sent)
+	}
+	threaded := fs.sent[0]
+	if threaded.RoomID != "!ops:example.org" || threaded.Content.RelatesTo == nil || threaded.Content.RelatesTo.RelType != "m.thread" || threaded.Content.RelatesTo.EventID != "$root" {
+		t.Fatalf("threaded send = %+v", threaded)
+	}
+	if threaded.Content.Body != "Alice Smith: done, Alice Smith" {
+		t.Fatalf("threaded body = %q", threaded.Content.Body)
+	}
+	wantHTML := `<a href="https://matrix.to/#/@alice:example.org">Alice Smith</a>: done, <strong><a href="https://matrix.to/#/@alice:example.org">Alice Smith</a></strong>`
+	if threaded.Content.FormattedBody != wantHTML || threaded.Content.Mentions == nil || len(threaded.Content.Mentions.UserIDs) != 1 {
+		t.Fatalf("threaded formatted body = %q, mentions %+v", threaded.Content.FormattedBody, threaded.Content.Mentions)
+	}
+	if fs.sent[1].RoomID != "!dm-alice:example.org" || fs.sent[1].Content.Body != "psst" || fs.sent[1].Content.FormattedBody != "" {
+		t.Fatalf("direct send = %+v", fs.sent[1])
+	}
+	if fs.sent[2].RoomID != "!dm-alice:example.org" {
+		t.Fatalf("second direct send went to %q", fs.sent[2].RoomID)
+	}
+	if rooms := fs.directData["@alice:example.org"]; len(rooms) != 1 || rooms[0] != "!dm-alice:example.org" {
+		t.Fatalf("saved m.direct = %v", fs.directData)
+	}
+	if v, ret := mc.GetProtocolUserAttribute("alice", "fullname"); ret != robot.Ok || v != "Alice Smith" {
+		t.Fatalf("fullname attribute = %q, %v", v, ret)
+	}
+	if v, ret := mc.GetProtocolUserAttribute("alice", "internalid"); ret != robot.Ok || v != "@alice:example.org" {
+		t.Fatalf("internalid attribute = %q, %v", v, ret)
+	}
+	if _, ret := mc.GetProtocolUserAttribute("nobody", "fullname"); ret != robot.UserNotFound {
+		t.Fatalf("unknown user attribute = %v, want UserNotFound", ret)
+	}
+}
+
+func TestReloadSwapsConfiguredUserMap(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{UserMap: map[string]string{"bob": "@bob:example.org", "Carol": "@carol:example.org", "dave": "dave"}}}
+	mc := &matrixConnector{Handler: h, botUserMap: map[string]string{"alice": "@alice:example.org"}}
+	if err := mc.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(mc.botUserMap) != 1 || mc.botUserMap["bob"] != "@bob:example.org" {
+		t.Fatalf("botUserMap after reload = %v, want only bob", mc.botUserMap)
+	}
+	if _, ok := mc.configuredCanonicalUser("@alice:example.org"); ok {
+		t.Fatal("removed mapping still validates")
+	}
+}
@@ -0,0 +1,322 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// roomInfo is the room state the connector tracks from sync.
+type roomInfo struct {
+	ID            string
+	Name          string // m.room.name
+	Alias         string // m.room.canonical_alias
+	Encrypted     bool   // m.room.encryption has been seen
+	JoinedMembers int
+	warned        bool // the encryption refusal has been logged
+}
+
+// channelName is the robot-facing name: the canonical alias localpart,
+// else the room name.
+func (r *roomInfo) channelName() string {
+	if r.Alias != "" {
+		return strings.TrimPrefix(localpart(r.Alias), "#")
+	}
+	return r.Name
+}
+
+func (mc *matrixConnector) handleSync(resp *syncResponse, initial bool) {
+	for _, evt := range resp.AccountData.Events {
+		if evt.Type == "m.direct" {
+			mc.setDirectRooms(evt.Content)
+		}
+	}
+	for roomID, invite := range resp.Rooms.Invite {
+		mc.handleInvite(roomID, invite)
+	}
+	for roomID := range resp.Rooms.Leave {
+		mc.forgetRoom(roomID)
+	}
+
+	var messages []Event
+	for roomID, room := range resp.Rooms.Join {
+		for _, evt := range room.State.Events {
+			mc.applyState(roomID, evt)
+		}
+		if room.Summary.JoinedMembers != nil {
+			mc.Lock()
+			mc.room(roomID).JoinedMembers = *room.Summary.JoinedMembers
+			mc.Unlock()
+		}
+		for _, evt := range room.Timeline.Events {
+			if evt.StateKey != nil {
+				mc.applyState(roomID, evt)
+				continue
+			}
+			if initial {
+				continue
+			}
+			evt.RoomID = roomID
+			messages = append(messages, evt)
+		}
+	}
+	// Sync groups events by room; restore the order they were sent in.
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp < messages[j].Timestamp
+	})
+	for i := range messages {
+		mc.processEvent(&messages[i])
+	}
+}
+
+// room returns the tracked state for roomID, creating it; the caller holds
+// the write lock.
+func (mc *matrixConnector) room(roomID string) *roomInfo {
+	r, ok := mc.rooms[roomID]
+	if !ok {
+		r = &roomInfo{ID: roomID}
+		mc.rooms[roomID] = r
+	}
+	return r
+}
+
+func (mc *matrixConnector) applyState(roomID string, evt Event) {
+	var content struct {
+		Name      string `json:"name"`
+		Alias     string `json:"alias"`
+		Algorithm string `json:"algorithm"`
+	}
+	switch evt.Type {
+	case "m.room.name", "m.room.canonical_alias", "m.room.encryption":
+		_ = json.Unmarshal(evt.Content, &content)
+	default:
+		return
+	}
+	mc.Lock()
+	defer mc.Unlock()
+	r := mc.room(roomID)
+	old := r.channelName()
+	switch evt.Type {
+	case "m.room.name":
+		r.Name = content.Name
+	case "m.room.canonical_alias":
+		r.Alias = content.Alias
+	case "m.room.encryption":
+		r.Encrypted = true
+	}
+	if name := r.channelName(); name != old {
+		if mc.roomIDsByName[old] == roomID {
+			delete(mc.roomIDsByName, old)
+		}
+		if name != "" {
+			mc.roomIDsByName[name] = roomID
+		}
+	}
+}
+
+func (mc *matrixConnector) forgetRoom(roomID string) {
+	mc.Lock()
+	defer mc.Unlock()
+	if r, ok := mc.rooms[roomID]; ok {
+		if name := r.channelName(); mc.roomIDsByName[name] == roomID {
+			delete(mc.roomIDsByName, name)
+		}
+		delete(mc.rooms, roomID)
+	}
+	if userID, ok := mc.directRoomUsers[roomID]; ok {
+		delete(mc.directRoomUsers, roomID)
+		if mc.directRooms[userID] == roomID {
+			delete(mc.directRooms, userID)
+		}
+	}
+}
+
+// setDirectRooms replaces the DM map from the bot's m.direct account data,
+// which maps user IDs to lists of room IDs.
+func (mc *matrixConnector) setDirectRooms(content json.RawMessage) {
+	var direct map[string][]string
+	if err := json.Unmarshal(content, &direct); err != nil {
+		mc.Log(robot.Warn, "Ignoring malformed Matrix m.direct account data: %v", err)
+		return
+	}
+	mc.Lock()
+	defer mc.Unlock()
+	mc.directAccountData = direct
+	mc.directRooms = make(map[string]string, len(direct))
+	mc.directRoomUsers = make(map[string]string)
+	for userID, roomIDs := range direct {
+		for _, roomID := range roomIDs {
+			mc.directRoomUsers[roomID] = userID
+		}
+		if len(roomIDs) > 0 {
+			mc.directRooms[userID] = roomIDs[len(roomIDs)-1]
+		}
+	}
+}
+
+// handleInvite joins rooms the bot is invited to when AutoJoin is set.
+func (mc *matrixConnector) handleInvite(roomID string, invite invitedRoom) {
+	var inviter string
+	var direct bool
+	for _, evt := range invite.InviteState.Events {
+		if evt.Type != "m.room.member" || evt.StateKey == nil || *evt.StateKey != mc.botUserID {
+			continue
+		}
+		var content struct {
+			Membership string `json:"membership"`
+			IsDirect   bool   `json:"is_direct"`
+		}
+		_ = json.Unmarshal(evt.Content, &content)
+		if content.Membership == "invite" {
+			inviter, direct = evt.Sender, content.IsDirect
+		}
+	}
+	if inviter == "" {
+		return
+	}
+	if !mc.autoJoin {
+		mc.Log(robot.Info, "Ignoring Matrix invitation from '%s' to room '%s'; AutoJoin is off", inviter, roomID)
+		return
+	}
+	mc.Log(robot.Info, "Accepting Matrix invitation from '%s' to room '%s'", inviter, roomID)
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	if _, err := mc.api.joinRoom(ctx, roomID); err != nil {
+		mc.Log(robot.Error, "Joining Matrix room '%s': %v", roomID, err)
+		return
+	}
+	if direct {
+		mc.rememberDirectRoom(inviter, roomID)
+	}
+}
+
+// replyFallback matches the quoted lines older clients put at the top of a
+// reply's body.
+var replyFallback = regexp.MustCompile(`^(?:> [^\n]*\n)+\n`)
+
+// processEvent turns a new room message into a ConnectorMessage.
+func (mc *matrixConnector) processEvent(evt *Event) {
+	switch evt.Type {
+	case "m.room.message":
+	case "m.room.encrypted":
+		mc.refuseEncrypted(evt.RoomID)
+		return
+	default:
+		return
+	}
+	var content messageContent
+	if err := json.Unmarshal(evt.Content, &content); err != nil {
+		mc.Log(robot.Warn, "Ignoring Matrix message '%s' with malformed content: %v", evt.EventID, err)
+		return
+	}
+	switch content.MsgType {
+	case "m.text", "m.notice", "m.emote":
+	default:
+		mc.Log(robot.Debug, "Ignoring Matrix message '%s' of type '%s'", evt.EventID, content.MsgType)
+		return
+	}
+	threadID := evt.EventID
+	threaded := false
+	if rel := content.RelatesTo; rel != nil {
+		switch rel.RelType {
+		case "m.replace":
+			mc.Log(robot.Debug, "Ignoring edited Matrix message '%s'", evt.EventID)
+			return
+		case "m.thread":
+			threadID = rel.EventID
+			threaded = true
+		default:
+			if rel.InReplyTo != nil {
+				content.Body = replyFallback.ReplaceAllString(content.Body, "")
+			}
+		}
+	}
+
+	mc.RLock()
+	r := mc.rooms[evt.RoomID]
+	_, direct := mc.directRoomUsers[evt.RoomID]
+	var channelName string
+	if r != nil {
+		channelName = r.channelName()
+		// Unnamed two-person rooms are DMs even without m.direct.
+		direct = direct || (channelName == "" && r.JoinedMembers == 2)
+	}
+	mc.RUnlock()
+
+	botMsg := &robot.ConnectorMessage{
+		Protocol:        "matrix",
+		UserID:          evt.Sender,
+		ChannelID:       evt.RoomID,
+		MessageID:       evt.EventID,
+		ThreadID:        threadID,
+		ThreadedMessage: threaded,
+		DirectMessage:   direct,
+		MessageText:     mc.normalizeBotMention(content),
+		MessageObject:   evt,
+		Client:          mc.api,
+	}
+	if !direct {
+		botMsg.ChannelName = channelName
+	}
+	if validatedName, validated := mc.configuredCanonicalUser(evt.Sender); validated {
+		botMsg.UserName = validatedName
+		botMsg.ValidatedUser = true
+	} else {
+		botMsg.UserName = localpart(evt.Sender)
+	}
+	mc.noteUser(evt.Sender)
+	if evt.Sender == mc.botUserID {
+		botMsg.SelfMessage = true
+		mc.Log(robot.Trace, "Forwarding Matrix return message '%s' from the robot", evt.EventID)
+	}
+	mc.IncomingMessage(botMsg)
+}
+
+// normalizeBotMention rewrites the ways clients mention the bot to
+// @localpart, the mention registered with the engine. Clients put the
+// display name in the body for a "pill" mention, and the full user ID
+// when typed by hand.
+func (mc *matrixConnector) normalizeBotMention(content messageContent) string {
+	body := strings.ReplaceAll(content.Body, mc.botUserID, "@"+mc.botName)
+	mentioned := strings.Contains(content.FormattedBody, "matrix.to/#/"+mc.botUserID)
+	if content.Mentions != nil {
+		for _, id := range content.Mentions.UserIDs {
+			if id == mc.botUserID {
+				mentioned = true
+			}
+		}
+	}
+	if !mentioned {
+		return body
+	}
+	for _, name := range []string{mc.botDisplayName, mc.botName} {
+		if name == "" || len(body) <= len(name) || !strings.EqualFold(body[:len(name)], name) {
+			continue
+		}
+		switch body[len(name)] {
+		case ':', ',', ' ':
+			return "@" + mc.botName + body[len(name):]
+		}
+	}
+	return body
+}
+
+// refuseEncrypted logs, once per room, that the connector can't read an
+// end-to-end encrypted room.
+func (mc *matrixConnector) refuseEncrypted(roomID string) {
+	mc.Lock()
+	r := mc.room(roomID)
+	r.Encrypted = true
+	warned := r.warned
+	r.warned = true
+	name := r.channelName()
+	mc.Unlock()
+	if warned {
+		return
+	}
+	mc.Log(robot.Warn, "Matrix room '%s' (%s) is end-to-end encrypted; the Matrix connector doesn't support E2EE and will neither read nor send messages there. Use an unencrypted room, or a direct message created without encryption.", name, roomID)
+}
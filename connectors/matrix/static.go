@@ -0,0 +1,7 @@
+package matrix
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterConnector("matrix", Initialize)
+}
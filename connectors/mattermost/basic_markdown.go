@@ -0,0 +1,381 @@
+package mattermost
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Mattermost renders CommonMark plus :emoji: shortcodes and @username
+// mentions natively, so BasicMarkdown mostly passes through; the connector
+// only resolves configured mentions and re-escapes escaped literals.
+
+const mattermostZWSP = "\u200B"
+
+const mattermostTruncatedMessage = "(message too long, truncated)"
+
+var markdownEscapeReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"[", `\[`,
+	"]", `\]`,
+	"<", `\<`,
+	">", `\>`,
+	"#", `\#`,
+	"|", `\|`,
+)
+
+// formatMessage renders msg and splits it into posts no longer than the
+// server's limit.
+func (mc *mattermostConnector) formatMessage(prefix, msg string, f robot.MessageFormat) []string {
+	var text string
+	switch f {
+	case robot.BasicMarkdown:
+		text = mc.renderBasicMarkdown(msg)
+	case robot.Fixed:
+		text = renderFixed(msg)
+	case robot.Variable:
+		text = renderVariable(msg)
+	default:
+		text = msg
+	}
+	if prefix != "" && strings.HasPrefix(text, "```") {
+		prefix = strings.TrimSpace(prefix) + "\n"
+	}
+	return splitMessage(prefix+text, mc.maxMessageLength, mc.maxMessageSplit)
+}
+
+func renderFixed(msg string) string {
+	if strings.TrimSpace(msg) == "" {
+		return ""
+	}
+	fence := codeFence(msg)
+	return fence + "\n" + msg + "\n" + fence
+}
+
+// codeFence returns a backtick fence longer than any backtick run in msg.
+func codeFence(msg string) string {
+	longest, run := 0, 0
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// renderVariable escapes markdown so the text shows up as typed.
+func renderVariable(msg string) string {
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		line = markdownEscapeReplacer.Replace(line)
+		trimmed := strings.TrimLeft(line, " ")
+		// Leading spaces become no-break spaces; four or more would
+		// otherwise start an indented code block.
+		indent := strings.Repeat("\u00a0", len(line)-len(trimmed))
+		switch {
+		case strings.HasPrefix(trimmed, "-"), strings.HasPrefix(trimmed, "+"), strings.HasPrefix(trimmed, "="):
+			trimmed = `\` + trimmed
+		default:
+			digits := 0
+			for digits < len(trimmed) && trimmed[digits] >= '0' && trimmed[digits] <= '9' {
+				digits++
+			}
+			if digits > 0 && digits < len(trimmed) && (trimmed[digits] == '.' || trimmed[digits] == ')') {
+				trimmed = trimmed[:digits] + `\` + trimmed[digits:]
+			}
+		}
+		lines[i] = indent + trimmed
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (mc *mattermostConnector) renderBasicMarkdown(msg string) string {
+	var out strings.Builder
+	inFence := false
+
+	for {
+		idx := strings.Index(msg, "```")
+		if idx == -1 {
+			if inFence {
+				out.WriteString(msg)
+			} else {
+				out.WriteString(mc.renderBasicMarkdownInline(msg))
+			}
+			break
+		}
+
+		chunk := msg[:idx]
+		if inFence {
+			out.WriteString(chunk)
+		} else {
+			out.WriteString(mc.renderBasicMarkdownInline(chunk))
+		}
+		// Language hints are kept; Mattermost uses them for highlighting.
+		out.WriteString("```")
+		inFence = !inFence
+		msg = msg[idx+3:]
+	}
+
+	return out.String()
+}
+
+func (mc *mattermostConnector) renderBasicMarkdownInline(msg string) string {
+	var out strings.Builder
+	for len(msg) > 0 {
+		start := findNextUnescapedBacktick(msg, 0)
+		if start == -1 {
+			out.WriteString(mc.renderBasicMarkdownPlain(msg))
+			break
+		}
+		out.WriteString(mc.renderBasicMarkdownPlain(msg[:start]))
+
+		end := findNextUnescapedBacktick(msg, start+1)
+		if end == -1 {
+			// Unterminated inline-code delimiter: treat as plain text.
+			out.WriteString(mc.renderBasicMarkdownPlain(msg[start:]))
+			break
+		}
+		out.WriteString(msg[start : end+1])
+		msg = msg[end+1:]
+	}
+	return out.String()
+}
+
+func findNextUnescapedBacktick(msg string, start int) int {
+	for i := start; i < len(msg); i++ {
+		if msg[i] == '`' && !isEscapedAt(msg, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isEscapedAt(msg string, idx int) bool {
+	if idx <= 0 || idx > len(msg)-1 {
+		return false
+	}
+	slashes := 0
+	for i := idx - 1; i >= 0 && msg[i] == '\\'; i-- {
+		slashes++
+	}
+	return slashes%2 == 1
+}
+
+func (mc *mattermostConnector) renderBasicMarkdownPlain(msg string) string {
+	msg, escapedLiterals := protectBasicMarkdownEscapes(msg)
+	msg = mc.replaceBasicMarkdownMentions(msg)
+	return restoreEscapedLiterals(msg, escapedLiterals)
+}
+
+func protectBasicMarkdownEscapes(msg string) (string, []string) {
+	escapedLiterals := make([]string, 0)
+	var out strings.Builder
+
+	for i := 0; i < len(msg); i++ {
+		ch := msg[i]
+		if ch != '\\' || i+1 >= len(msg) || !isBasicMarkdownEscapable(msg[i+1]) {
+			out.WriteByte(ch)
+			continue
+		}
+		escapedLiterals = append(escapedLiterals, string(msg[i+1]))
+		out.WriteString(escapedPlaceholder(len(escapedLiterals) - 1))
+		i++
+	}
+
+	return out.String(), escapedLiterals
+}
+
+func isBasicMarkdownEscapable(ch byte) bool {
+	switch ch {
+	case '*', '`', '[', ']', '(', ')', '@', '\\':
+		return true
+	default:
+		return false
+	}
+}
+
+// replaceBasicMarkdownMentions rewrites @username for users in the
+// configured UserMap to their Mattermost username. Anything else is left
+// literal, which Mattermost itself resolves against its own usernames.
+func (mc *mattermostConnector) replaceBasicMarkdownMentions(msg string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(msg); {
+		if msg[i] != '@' {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(msg) && isMentionTokenChar(msg[i]) {
+			i++
+		}
+		if i == start+1 {
+			out.WriteByte('@')
+			continue
+		}
+
+		mention, suffix := splitMentionCandidate(msg[start+1 : i])
+		if mention == "" || (start > 0 && isEmailLocalChar(msg[start-1])) {
+			out.WriteString(msg[start:i])
+			continue
+		}
+		if username, ok := mc.resolveBasicMarkdownMention(mention); ok {
+			out.WriteString("@" + username)
+		} else {
+			out.WriteString("@" + mention)
+		}
+		out.WriteString(suffix)
+	}
+
+	return out.String()
+}
+
+func (mc *mattermostConnector) resolveBasicMarkdownMention(name string) (string, bool) {
+	mc.RLock()
+	id, ok := mc.botUserMap[strings.ToLower(name)]
+	mc.RUnlock()
+	if !ok {
+		return "", false
+	}
+	user, ok := mc.userByID(id)
+	if !ok || user.Username == "" {
+		return "", false
+	}
+	return user.Username, true
+}
+
+func isMentionTokenChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_' || ch == '-' || ch == '.'
+}
+
+func splitMentionCandidate(token string) (mention string, suffix string) {
+	cut := len(token)
+	for cut > 0 && !isMentionTerminalChar(token[cut-1]) {
+		cut--
+	}
+	return token[:cut], token[cut:]
+}
+
+func isMentionTerminalChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_'
+}
+
+func isEmailLocalChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_' || ch == '.' || ch == '%' || ch == '+' || ch == '-'
+}
+
+func escapedPlaceholder(idx int) string {
+	return fmt.Sprintf("\x00GBESC%d\x00", idx)
+}
+
+func restoreEscapedLiterals(msg string, literals []string) string {
+	out := msg
+	for i, literal := range literals {
+		out = strings.ReplaceAll(out, escapedPlaceholder(i), mattermostEscapedLiteral(literal))
+	}
+	return out
+}
+
+// mattermostEscapedLiteral keeps an escaped character literal; a
+// zero-width space after @ stops Mattermost from treating it as a mention.
+func mattermostEscapedLiteral(literal string) string {
+	if literal == "@" {
+		return "@" + mattermostZWSP
+	}
+	return `\` + literal
+}
+
+// splitMessage breaks msg into at most maxSplit posts of maxLen characters,
+// preferring newline boundaries and closing/reopening code fences across
+// posts. Text past the last allowed post is dropped with a notice.
+func splitMessage(msg string, maxLen, maxSplit int) []string {
+	if utf8.RuneCountInString(msg) <= maxLen {
+		return []string{msg}
+	}
+	// Room for a closing fence and the truncation notice.
+	limit := maxLen - len(mattermostTruncatedMessage) - 12
+	if limit < 1 {
+		limit = maxLen
+	}
+	chunks := make([]string, 0, maxSplit)
+	for msg != "" {
+		if utf8.RuneCountInString(msg) <= maxLen {
+			chunks = append(chunks, msg)
+			break
+		}
+		cut := byteIndexForRunes(msg, limit)
+		if nl := strings.LastIndexByte(msg[:cut], '\n'); nl > 0 {
+			cut = nl
+		}
+		chunk := msg[:cut]
+		fence := openFence(chunk)
+		if fence != "" {
+			chunk += "\n" + fence
+		}
+		if len(chunks) == maxSplit-1 {
+			chunks = append(chunks, chunk+"\n"+mattermostTruncatedMessage)
+			break
+		}
+		chunks = append(chunks, chunk)
+		msg = strings.TrimPrefix(msg[cut:], "\n")
+		if fence != "" {
+			msg = fence + "\n" + msg
+		}
+	}
+	return chunks
+}
+
+func byteIndexForRunes(msg string, runes int) int {
+	for i := range msg {
+		if runes == 0 {
+			return i
+		}
+		runes--
+	}
+	return len(msg)
+}
+
+// openFence returns the fence marker of a code block left open at the end
+// of text, or "".
+func openFence(text string) string {
+	open := ""
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(line, "```") && !strings.HasPrefix(line, "~~~") {
+			continue
+		}
+		n := 0
+		for n < len(line) && line[n] == line[0] {
+			n++
+		}
+		marker := line[:n]
+		switch {
+		case open == "":
+			open = marker
+		case marker[0] == open[0] && len(marker) >= len(open) && strings.TrimSpace(line[len(marker):]) == "":
+			open = ""
+		}
+	}
+	return open
+}
@@ -0,0 +1,77 @@
+package mattermost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func newRenderTestConnector() *mattermostConnector {
+	return &mattermostConnector{
+		Handler:          &testHandler{},
+		botUserMap:       map[string]string{"alice": "a1"},
+		usersByID:        map[string]User{"a1": {ID: "a1", Username: "alice.smith"}},
+		userIDsByName:    map[string]string{"alice.smith": "a1"},
+		maxMessageLength: defaultMaxMessageLength,
+		maxMessageSplit:  1,
+	}
+}
+
+func TestRenderBasicMarkdownPassesThroughNativeMarkdown(t *testing.T) {
+	mc := newRenderTestConnector()
+	in := "**Deploy status:** *rollback* :rocket:\n> paused\n- See [runbook](https://example.com/runbook)\n```yaml\nkind: Pod # @alice\n```"
+	if got := mc.renderBasicMarkdown(in); got != in {
+		t.Fatalf("renderBasicMarkdown() = %q, want unchanged %q", got, in)
+	}
+}
+
+func TestRenderBasicMarkdownMentionsAndEscapes(t *testing.T) {
+	mc := newRenderTestConnector()
+	in := "Paging @Alice, @david and bob@example.com; `@alice` \\*not bold\\* \\@alice"
+	got := mc.renderBasicMarkdown(in)
+	want := "Paging @alice.smith, @david and bob@example.com; `@alice` \\*not bold\\* @" + mattermostZWSP + "alice"
+	if got != want {
+		t.Fatalf("renderBasicMarkdown() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderVariableEscapesMarkdown(t *testing.T) {
+	got := renderVariable("*x* _y_ [z](u)\n- item\n1. first\n    indented\n# not a heading")
+	want := "\\*x\\* \\_y\\_ \\[z\\](u)\n\\- item\n1\\. first\n\u00a0\u00a0\u00a0\u00a0indented\n\\# not a heading"
+	if got != want {
+		t.Fatalf("renderVariable() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatMessageFixedUsesLongerFence(t *testing.T) {
+	mc := newRenderTestConnector()
+	got := mc.formatMessage("@alice.smith: ", "has ``` inside", robot.Fixed)
+	want := "@alice.smith:\n````\nhas ``` inside\n````"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("formatMessage(Fixed) = %q, want %q", got, want)
+	}
+}
+
+func TestSplitMessageReopensFencesAndTruncates(t *testing.T) {
+	line := strings.Repeat("x", 30)
+	msg := "```\n" + strings.Repeat(line+"\n", 8) + "```"
+	chunks := splitMessage(msg, 120, 2)
+	if len(chunks) != 2 {
+		t.Fatalf("splitMessage returned %d chunks, want 2: %q", len(chunks), chunks)
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 120 {
+			t.Fatalf("chunk %d is %d characters, over the limit", i, len(chunk))
+		}
+		if strings.Count(chunk, "```")%2 != 0 {
+			t.Fatalf("chunk %d leaves a fence open: %q", i, chunk)
+		}
+	}
+	if !strings.HasPrefix(chunks[1], "```\n") || !strings.HasSuffix(chunks[1], mattermostTruncatedMessage) {
+		t.Fatalf("last chunk = %q, want reopened fence and truncation notice", chunks[1])
+	}
+	if got := splitMessage("short", 120, 2); len(got) != 1 || got[0] != "short" {
+		t.Fatalf("splitMessage(short) = %q", got)
+	}
+}
@@ -0,0 +1,215 @@
+package mattermost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is a minimal Mattermost REST API v4 client; it's passed to Go
+// extensions as ConnectorMessage.Client.
+type Client struct {
+	baseURL string // server URL without a trailing slash
+	token   string
+	http    *http.Client
+}
+
+// User is the subset of a Mattermost user object the connector uses.
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	IsBot     bool   `json:"is_bot"`
+}
+
+// Channel is the subset of a Mattermost channel object the connector uses.
+type Channel struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Type        string `json:"type"`
+}
+
+// Post is a Mattermost post; incoming messages carry it as
+// ConnectorMessage.MessageObject.
+type Post struct {
+	ID        string                 `json:"id,omitempty"`
+	ChannelID string                 `json:"channel_id"`
+	UserID    string                 `json:"user_id,omitempty"`
+	RootID    string                 `json:"root_id,omitempty"`
+	Message   string                 `json:"message"`
+	Type      string                 `json:"type,omitempty"`
+	Props     map[string]interface{} `json:"props,omitempty"`
+}
+
+type team struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// APIError is a non-2xx response from the Mattermost server.
+type APIError struct {
+	StatusCode int
+	ID         string `json:"id"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("mattermost API returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("mattermost API returned HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+func newClient(serverURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(serverURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Do sends an authenticated request to path under /api/v4, JSON-encoding
+// body when it's non-nil and decoding the response into out when it's
+// non-nil.
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api/v4"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = json.Unmarshal(data, apiErr)
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) getMe(ctx context.Context) (User, error) {
+	var u User
+	err := c.Do(ctx, http.MethodGet, "/users/me", nil, &u)
+	return u, err
+}
+
+func (c *Client) getUser(ctx context.Context, id string) (User, error) {
+	var u User
+	err := c.Do(ctx, http.MethodGet, "/users/"+url.PathEscape(id), nil, &u)
+	return u, err
+}
+
+func (c *Client) getUserByUsername(ctx context.Context, name string) (User, error) {
+	var u User
+	err := c.Do(ctx, http.MethodGet, "/users/username/"+url.PathEscape(name), nil, &u)
+	return u, err
+}
+
+func (c *Client) getTeamByName(ctx context.Context, name string) (team, error) {
+	var t team
+	err := c.Do(ctx, http.MethodGet, "/teams/name/"+url.PathEscape(name), nil, &t)
+	return t, err
+}
+
+func (c *Client) getMyTeams(ctx context.Context) ([]team, error) {
+	var teams []team
+	err := c.Do(ctx, http.MethodGet, "/users/me/teams", nil, &teams)
+	return teams, err
+}
+
+func (c *Client) getChannel(ctx context.Context, id string) (Channel, error) {
+	var ch Channel
+	err := c.Do(ctx, http.MethodGet, "/channels/"+url.PathEscape(id), nil, &ch)
+	return ch, err
+}
+
+func (c *Client) getChannelByName(ctx context.Context, teamID, name string) (Channel, error) {
+	var ch Channel
+	err := c.Do(ctx, http.MethodGet, "/teams/"+url.PathEscape(teamID)+"/channels/name/"+url.PathEscape(name), nil, &ch)
+	return ch, err
+}
+
+func (c *Client) createDirectChannel(ctx context.Context, botID, userID string) (Channel, error) {
+	var ch Channel
+	err := c.Do(ctx, http.MethodPost, "/channels/direct", []string{botID, userID}, &ch)
+	return ch, err
+}
+
+func (c *Client) addChannelMember(ctx context.Context, channelID, userID string) error {
+	return c.Do(ctx, http.MethodPost, "/channels/"+url.PathEscape(channelID)+"/members", map[string]string{"user_id": userID}, nil)
+}
+
+func (c *Client) createPost(ctx context.Context, post *Post) error {
+	return c.Do(ctx, http.MethodPost, "/posts", post, nil)
+}
+
+// websocketURL maps the server URL to the API v4 websocket endpoint.
+func websocketURL(serverURL string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(serverURL, "/"))
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", errors.New("URL has no host")
+	}
+	u.Path += "/api/v4/websocket"
+	return u.String(), nil
+}
+
+func apiErrorRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func apiErrorNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
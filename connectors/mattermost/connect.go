@@ -0,0 +1,256 @@
+// Package mattermost implements the robot.Connector interface for Mattermost,
+// using the API v4 websocket for incoming events and REST for everything else.
+package mattermost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	// Mattermost's default MaxPostSize; servers on older schemas use 4000.
+	defaultMaxMessageLength = 16383
+	apiTimeout              = 30 * time.Second
+	reconnectMinDelay       = 2 * time.Second
+	reconnectMaxDelay       = 2 * time.Minute
+	websocketPingInterval   = 30 * time.Second
+	websocketReadTimeout    = 90 * time.Second
+)
+
+var errUnauthorized = errors.New("mattermost rejected the connector token")
+
+type config struct {
+	ServerURL        string // base URL of the Mattermost server, e.g. https://chat.example.com
+	Token            string // bot account or personal access token
+	Team             string // team name used to find channels by name; optional when the bot is in one team
+	MaxMessageSplit  int    // the maximum number of posts to emit for one long outbound send
+	MaxMessageLength int    // the server's maximum post size in characters
+	UserMap          map[string]string
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, id := range in {
+		name := strings.TrimSpace(user)
+		uid := strings.TrimSpace(id)
+		if name == "" || uid == "" {
+			h.Log(robot.Warn, "Ignoring invalid Mattermost UserMap entry (empty username or user ID): %q -> %q", user, id)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring Mattermost UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		out[name] = uid
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func newConnector(handler robot.Handler, c config) (*mattermostConnector, error) {
+	serverURL := strings.TrimSpace(c.ServerURL)
+	if serverURL == "" {
+		return nil, fmt.Errorf("Mattermost protocol config requires ServerURL")
+	}
+	token := strings.TrimSpace(c.Token)
+	if token == "" {
+		return nil, fmt.Errorf("Mattermost protocol config requires Token")
+	}
+	wsURL, err := websocketURL(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Mattermost ServerURL %q: %w", serverURL, err)
+	}
+	if c.MaxMessageSplit <= 0 {
+		c.MaxMessageSplit = 1
+	}
+	if c.MaxMessageLength <= 0 {
+		c.MaxMessageLength = defaultMaxMessageLength
+	}
+	return &mattermostConnector{
+		Handler:          handler,
+		api:              newClient(serverURL, token),
+		wsURL:            wsURL,
+		teamName:         strings.TrimSpace(c.Team),
+		maxMessageSplit:  c.MaxMessageSplit,
+		maxMessageLength: c.MaxMessageLength,
+		botUserMap:       normalizeConfiguredUserMap(c.UserMap, handler),
+		usersByID:        make(map[string]User),
+		userIDsByName:    make(map[string]string),
+		channelsByID:     make(map[string]Channel),
+		channelIDsByName: make(map[string]string),
+		directChannels:   make(map[string]string),
+		dialer:           websocket.DefaultDialer,
+		reconnectDelay:   reconnectMinDelay,
+	}, nil
+}
+
+// identify looks up the bot account and the team used for channel names.
+func (mc *mattermostConnector) identify(ctx context.Context) error {
+	me, err := mc.api.getMe(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to look up the Mattermost bot account: %w", err)
+	}
+	mc.botUserID = me.ID
+	mc.botName = me.Username
+	mc.cacheUser(me)
+
+	if mc.teamName != "" {
+		t, err := mc.api.getTeamByName(ctx, mc.teamName)
+		if err != nil {
+			return fmt.Errorf("unable to look up Mattermost team %q: %w", mc.teamName, err)
+		}
+		mc.teamID = t.ID
+		return nil
+	}
+	teams, err := mc.api.getMyTeams(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list the bot's Mattermost teams: %w", err)
+	}
+	switch len(teams) {
+	case 0:
+		return fmt.Errorf("Mattermost bot account %q is not a member of any team", me.Username)
+	case 1:
+		mc.teamName = teams[0].Name
+		mc.teamID = teams[0].ID
+		return nil
+	default:
+		return fmt.Errorf("Mattermost bot account %q belongs to %d teams; set Team in the protocol config", me.Username, len(teams))
+	}
+}
+
+// Initialize validates config, looks up the bot account and returns the
+// connector.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to retrieve mattermost protocol configuration: %w", err)}
+	}
+	mc, err := newConnector(handler, c)
+	if err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	if err := mc.identify(ctx); err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	handler.Log(robot.Info, "Mattermost connector using bot account '%s' (%s) in team '%s'", mc.botName, mc.botUserID, mc.teamName)
+	handler.SetBotID(mc.botUserID)
+	handler.SetBotMention(mc.botName)
+	return robot.InitializedConnector{Connector: mc}
+}
+
+// Reload swaps in the configured UserMap; server and token changes need a
+// restart.
+func (mc *mattermostConnector) Reload() error {
+	var c config
+	if err := mc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Mattermost protocol configuration: %w", err)
+	}
+	userMap := normalizeConfiguredUserMap(c.UserMap, mc.Handler)
+	mc.Lock()
+	mc.botUserMap = userMap
+	mc.Unlock()
+	mc.Log(robot.Info, "Mattermost connector reloaded %d configured user mapping(s)", len(userMap))
+	return nil
+}
+
+// Run reads the websocket event stream until stop is closed, reconnecting
+// with backoff after transient failures. Only a rejected token ends it early.
+func (mc *mattermostConnector) Run(stop <-chan struct{}) error {
+	delay := mc.reconnectDelay
+	for {
+		connected, err := mc.readEvents(stop)
+		select {
+		case <-stop:
+			mc.Log(robot.Debug, "Received stop in connector")
+			return nil
+		default:
+		}
+		if errors.Is(err, errUnauthorized) {
+			return err
+		}
+		if connected {
+			delay = mc.reconnectDelay
+		}
+		mc.Log(robot.Warn, "Mattermost websocket disconnected; reconnecting in %v: %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// readEvents runs one websocket session; connected reports whether the
+// dial succeeded.
+func (mc *mattermostConnector) readEvents(stop <-chan struct{}) (connected bool, err error) {
+	header := http.Header{"Authorization": {"Bearer " + mc.api.token}}
+	conn, resp, err := mc.dialer.Dial(mc.wsURL, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return false, errUnauthorized
+		}
+		return false, err
+	}
+	mc.wsLock.Lock()
+	mc.ws = conn
+	mc.wsLock.Unlock()
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		mc.wsLock.Lock()
+		mc.ws = nil
+		mc.wsLock.Unlock()
+		conn.Close()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(websocketPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					mc.Log(robot.Debug, "Mattermost websocket ping failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	extend := func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(websocketReadTimeout))
+	}
+	extend("")
+	conn.SetPongHandler(extend)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		extend("")
+		mc.handleEvent(data)
+	}
+}
@@ -0,0 +1,382 @@
+package mattermost
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	mattermostSendTimeout  = 30 * time.Second
+	mattermostSendAttempts = 3
+)
+
+type mattermostConnector struct {
+	robot.Handler
+	sync.RWMutex                       // protects the maps below
+	api              *Client           // REST client
+	wsURL            string            // API v4 websocket endpoint
+	teamName         string            // team used for channel name lookups
+	teamID           string            // ID of teamName
+	botUserID        string            // Mattermost user ID of the bot account
+	botName          string            // Mattermost username of the bot account
+	maxMessageSplit  int               // the maximum number of posts for one long send
+	maxMessageLength int               // the server's maximum post size
+	botUserMap       map[string]string // connector-local configured mappings of username to user ID
+	usersByID        map[string]User   // cached Mattermost users
+	userIDsByName    map[string]string // Mattermost username to user ID
+	channelsByID     map[string]Channel
+	channelIDsByName map[string]string // channel name in teamID to channel ID
+	directChannels   map[string]string // user ID to DM channel ID
+
+	dialer         *websocket.Dialer
+	reconnectDelay time.Duration
+	wsLock         sync.Mutex // serializes websocket writes
+	ws             *websocket.Conn
+	wsSeq          int64
+	sendLock       sync.Mutex // keeps outbound posts in order
+	retrySleep     func(context.Context, time.Duration) error
+}
+
+func (mc *mattermostConnector) cacheUser(u User) {
+	if u.ID == "" {
+		return
+	}
+	mc.Lock()
+	if old, ok := mc.usersByID[u.ID]; ok && old.Username != u.Username {
+		delete(mc.userIDsByName, old.Username)
+	}
+	mc.usersByID[u.ID] = u
+	if u.Username != "" {
+		mc.userIDsByName[u.Username] = u.ID
+	}
+	mc.Unlock()
+}
+
+func (mc *mattermostConnector) userByID(id string) (User, bool) {
+	mc.RLock()
+	u, ok := mc.usersByID[id]
+	mc.RUnlock()
+	if ok {
+		return u, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	u, err := mc.api.getUser(ctx, id)
+	if err != nil {
+		if !apiErrorNotFound(err) {
+			mc.Log(robot.Error, "Looking up Mattermost user ID '%s': %v", id, err)
+		}
+		return User{}, false
+	}
+	mc.cacheUser(u)
+	return u, true
+}
+
+// userID resolves a canonical username to a Mattermost user ID; configured
+// UserMap entries win over same-named Mattermost accounts.
+func (mc *mattermostConnector) userID(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", false
+	}
+	mc.RLock()
+	id, ok := mc.botUserMap[name]
+	if !ok {
+		id, ok = mc.userIDsByName[name]
+	}
+	mc.RUnlock()
+	if ok {
+		return id, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	u, err := mc.api.getUserByUsername(ctx, name)
+	if err != nil {
+		if !apiErrorNotFound(err) {
+			mc.Log(robot.Error, "Looking up Mattermost username '%s': %v", name, err)
+		}
+		return "", false
+	}
+	mc.cacheUser(u)
+	return u.ID, true
+}
+
+func (mc *mattermostConnector) configuredCanonicalUser(id string) (string, bool) {
+	mc.RLock()
+	defer mc.RUnlock()
+	for name, uid := range mc.botUserMap {
+		if uid == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (mc *mattermostConnector) cacheChannel(ch Channel) {
+	if ch.ID == "" {
+		return
+	}
+	mc.Lock()
+	mc.channelsByID[ch.ID] = ch
+	if ch.Name != "" && ch.TeamID == mc.teamID {
+		mc.channelIDsByName[ch.Name] = ch.ID
+	}
+	mc.Unlock()
+}
+
+func (mc *mattermostConnector) forgetChannels() {
+	mc.Lock()
+	mc.channelsByID = make(map[string]Channel)
+	mc.channelIDsByName = make(map[string]string)
+	mc.Unlock()
+}
+
+func (mc *mattermostConnector) channelByID(id string) (Channel, bool) {
+	mc.RLock()
+	ch, ok := mc.channelsByID[id]
+	mc.RUnlock()
+	if ok {
+		return ch, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	ch, err := mc.api.getChannel(ctx, id)
+	if err != nil {
+		mc.Log(robot.Error, "Looking up Mattermost channel ID '%s': %v", id, err)
+		return Channel{}, false
+	}
+	mc.cacheChannel(ch)
+	return ch, true
+}
+
+// channelID takes a bracketed ID from a roster or a channel name in the
+// configured team.
+func (mc *mattermostConnector) channelID(ch string) (string, bool) {
+	if id, ok := util.ExtractID(ch); ok {
+		return id, true
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(ch), "~")
+	if name == "" {
+		return "", false
+	}
+	mc.RLock()
+	id, ok := mc.channelIDsByName[name]
+	mc.RUnlock()
+	if ok {
+		return id, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	channel, err := mc.api.getChannelByName(ctx, mc.teamID, name)
+	if err != nil {
+		if !apiErrorNotFound(err) {
+			mc.Log(robot.Error, "Looking up Mattermost channel '%s': %v", name, err)
+		}
+		return "", false
+	}
+	mc.cacheChannel(channel)
+	return channel.ID, true
+}
+
+func (mc *mattermostConnector) directChannelID(userID string) (string, bool) {
+	mc.RLock()
+	id, ok := mc.directChannels[userID]
+	mc.RUnlock()
+	if ok {
+		return id, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	ch, err := mc.api.createDirectChannel(ctx, mc.botUserID, userID)
+	if err != nil {
+		mc.Log(robot.Error, "Opening Mattermost direct channel to user ID '%s': %v", userID, err)
+		return "", false
+	}
+	mc.Lock()
+	mc.directChannels[userID] = ch.ID
+	mc.channelsByID[ch.ID] = ch
+	mc.Unlock()
+	return ch.ID, true
+}
+
+// GetProtocolUserAttribute returns a string attribute or "" if Mattermost
+// doesn't have that information.
+func (mc *mattermostConnector) GetProtocolUserAttribute(u, attr string) (value string, ret robot.RetVal) {
+	id, ok := util.ExtractID(u)
+	if !ok {
+		id, ok = mc.userID(u)
+	}
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	user, ok := mc.userByID(id)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	switch attr {
+	case "email":
+		return user.Email, robot.Ok
+	case "internalid":
+		return user.ID, robot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return strings.TrimSpace(user.FirstName + " " + user.LastName), robot.Ok
+	case "firstname", "first name":
+		return user.FirstName, robot.Ok
+	case "lastname", "last name":
+		return user.LastName, robot.Ok
+	case "nickname":
+		return user.Nickname, robot.Ok
+	default:
+		return "", robot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a typing notification over the websocket.
+func (mc *mattermostConnector) MessageHeard(user, channel string) {
+	chanID, ok := util.ExtractID(channel)
+	if !ok {
+		return
+	}
+	mc.wsLock.Lock()
+	defer mc.wsLock.Unlock()
+	if mc.ws == nil {
+		return
+	}
+	mc.wsSeq++
+	action := map[string]interface{}{
+		"seq":    mc.wsSeq,
+		"action": "user_typing",
+		"data":   map[string]string{"channel_id": chanID},
+	}
+	mc.ws.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := mc.ws.WriteJSON(action); err != nil {
+		mc.Log(robot.Debug, "Mattermost typing notification failed: %v", err)
+	}
+}
+
+func (mc *mattermostConnector) DefaultHelp() []string {
+	return nil
+}
+
+// JoinChannel adds the bot account to a channel in the configured team.
+func (mc *mattermostConnector) JoinChannel(c string) robot.RetVal {
+	chanID, ok := mc.channelID(c)
+	if !ok {
+		mc.Log(robot.Error, "Mattermost channel ID not found for: %s", c)
+		return robot.ChannelNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	if err := mc.api.addChannelMember(ctx, chanID, mc.botUserID); err != nil {
+		mc.Log(robot.Error, "Joining channel '%s': %v", c, err)
+	} else {
+		mc.Log(robot.Debug, "Joined channel %s/%s", c, chanID)
+	}
+	return robot.Ok
+}
+
+func (mc *mattermostConnector) sleepRetry(ctx context.Context, delay time.Duration) error {
+	if mc.retrySleep != nil {
+		return mc.retrySleep(ctx, delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendPosts posts every chunk in order; Ok means the server accepted all of
+// them.
+func (mc *mattermostConnector) sendPosts(chanID, rootID string, chunks []string) robot.RetVal {
+	if len(chunks) == 0 {
+		return robot.FailedMessageSend
+	}
+	mc.sendLock.Lock()
+	defer mc.sendLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), mattermostSendTimeout)
+	defer cancel()
+	for _, chunk := range chunks {
+		post := &Post{ChannelID: chanID, RootID: rootID, Message: chunk}
+		var err error
+		for attempt := 0; attempt < mattermostSendAttempts; attempt++ {
+			if err = mc.api.createPost(ctx, post); err == nil {
+				break
+			}
+			if attempt == mattermostSendAttempts-1 || !apiErrorRetryable(err) {
+				break
+			}
+			delay := time.Second << attempt
+			mc.Log(robot.Warn, "Sending Mattermost post to channel '%s' failed (attempt %d/%d); retrying in %v: %v", chanID, attempt+1, mattermostSendAttempts, delay, err)
+			if serr := mc.sleepRetry(ctx, delay); serr != nil {
+				err = serr
+				break
+			}
+		}
+		if err != nil {
+			mc.Log(robot.Error, "Failed sending Mattermost post to channel '%s': %v", chanID, err)
+			return robot.FailedMessageSend
+		}
+	}
+	return robot.Ok
+}
+
+// SendProtocolChannelThreadMessage sends a message to a channel, replying in
+// the thread rooted at thr when set.
+func (mc *mattermostConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	chanID, ok := mc.channelID(ch)
+	if !ok {
+		mc.Log(robot.Error, "Mattermost channel ID not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	return mc.sendPosts(chanID, thr, mc.formatMessage("", msg, f))
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a channel
+// addressed to a user with an @mention.
+func (mc *mattermostConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	chanID, ok := mc.channelID(ch)
+	if !ok {
+		mc.Log(robot.Error, "Mattermost channel ID not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	userID, ok := util.ExtractID(uid)
+	if !ok {
+		userID, ok = mc.userID(u)
+	}
+	if !ok {
+		mc.Log(robot.Error, "Mattermost user ID not found for: %s", u)
+		return robot.UserNotFound
+	}
+	prefix := "@" + u + ": "
+	if user, found := mc.userByID(userID); found {
+		prefix = "@" + user.Username + ": "
+	}
+	return mc.sendPosts(chanID, thr, mc.formatMessage(prefix, msg, f))
+}
+
+// SendProtocolUserMessage sends a direct message to a user.
+func (mc *mattermostConnector) SendProtocolUserMessage(u, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	userID, ok := util.ExtractID(u)
+	if !ok {
+		userID, ok = mc.userID(u)
+	}
+	if !ok {
+		mc.Log(robot.Error, "No Mattermost user ID found for user: %s", u)
+		return robot.UserNotFound
+	}
+	chanID, ok := mc.directChannelID(userID)
+	if !ok {
+		return robot.FailedMessageSend
+	}
+	return mc.sendPosts(chanID, "", mc.formatMessage("", msg, f))
+}
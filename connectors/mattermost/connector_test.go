@@ -0,0 +1,315 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	protocolConfig *config
+	botID          string
+	botMention     string
+	incoming       chan *robot.ConnectorMessage
+}
+
+func (t *testHandler) IncomingMessage(m *robot.ConnectorMessage) {
+	if t.incoming != nil {
+		t.incoming <- m
+	}
+}
+func (t *testHandler) GetProtocolConfig(v interface{}) error {
+	if t.protocolConfig != nil {
+		*(v.(*config)) = *t.protocolConfig
+	}
+	return nil
+}
+func (t *testHandler) GetBrainConfig(_ interface{}) error         { return nil }
+func (t *testHandler) GetEventStrings() *[]string                 { return nil }
+func (t *testHandler) GetHistoryConfig(_ interface{}) error       { return nil }
+func (t *testHandler) GetBotInfo() robot.BotInfo                  { return robot.BotInfo{} }
+func (t *testHandler) SetBotID(id string)                         { t.botID = id }
+func (t *testHandler) SetTerminalWriter(_ io.Writer)              {}
+func (t *testHandler) SetBotMention(m string)                     { t.botMention = m }
+func (t *testHandler) GetLogLevel() robot.LogLevel                { return robot.Info }
+func (t *testHandler) GetInstallPath() string                     { return "" }
+func (t *testHandler) GetConfigPath() string                      { return "" }
+func (t *testHandler) ReadEncryptedFile(_ string) ([]byte, error) { return nil, nil }
+func (t *testHandler) Log(_ robot.LogLevel, _ string, _ ...interface{}) {
+}
+func (t *testHandler) GetDirectory(_ string) error { return nil }
+
+// fakeServer is a stand-in for the Mattermost REST API and websocket.
+type fakeServer struct {
+	*httptest.Server
+	sync.Mutex
+	teams  []team
+	events []string
+	posts  []Post
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	fs := &fakeServer{teams: []team{{ID: "team1", Name: "eng"}}}
+	users := map[string]User{
+		"bot1":   {ID: "bot1", Username: "floyd", IsBot: true},
+		"alice1": {ID: "alice1", Username: "alice.smith", Email: "alice@example.com", FirstName: "Alice", LastName: "Smith"},
+	}
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	notFound := func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]interface{}{"id": "store.not_found", "message": "not found", "status_code": 404})
+	}
+	upgrader := websocket.Upgrader{}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/api/v4")
+		switch {
+		case path == "/websocket":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			fs.Lock()
+			events := append([]string{`{"event":"hello","data":{}}`}, fs.events...)
+			fs.Unlock()
+			for _, evt := range events {
+				conn.WriteMessage(websocket.TextMessage, []byte(evt))
+			}
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		case path == "/users/me":
+			writeJSON(w, users["bot1"])
+		case path == "/users/me/teams":
+			fs.Lock()
+			writeJSON(w, fs.teams)
+			fs.Unlock()
+		case strings.HasPrefix(path, "/users/username/"):
+			name := strings.TrimPrefix(path, "/users/username/")
+			for _, u := range users {
+				if u.Username == name {
+					writeJSON(w, u)
+					return
+				}
+			}
+			notFound(w)
+		case strings.HasPrefix(path, "/users/"):
+			if u, ok := users[strings.TrimPrefix(path, "/users/")]; ok {
+				writeJSON(w, u)
+				return
+			}
+			notFound(w)
+		case path == "/teams/name/eng":
+			writeJSON(w, team{ID: "team1", Name: "eng"})
+		case path == "/teams/team1/channels/name/general":
+			writeJSON(w, Channel{ID: "chan1", TeamID: "team1", Name: "general", Type: "O"})
+		case path == "/channels/chan1":
+			writeJSON(w, Channel{ID: "chan1", TeamID: "team1", Name: "general", Type: "O"})
+		case path == "/channels/direct":
+			var ids []string
+			json.NewDecoder(r.Body).Decode(&ids)
+			writeJSON(w, Channel{ID: "dm-" + ids[1], Name: ids[0] + "__" + ids[1], Type: "D"})
+		case path == "/posts" && r.Method == http.MethodPost:
+			var p Post
+			json.NewDecoder(r.Body).Decode(&p)
+			fs.Lock()
+			fs.posts = append(fs.posts, p)
+			fs.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, p)
+		default:
+			notFound(w)
+		}
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func postedEvent(t *testing.T, post Post, channelType, channelName string) string {
+	t.Helper()
+	encoded, err := json.Marshal(post)
+	if err != nil {
+		t.Fatalf("marshal post: %v", err)
+	}
+	evt, err := json.Marshal(map[string]interface{}{
+		"event": "posted",
+		"data": map[string]string{
+			"channel_type": channelType,
+			"channel_name": channelName,
+			"post":         string(encoded),
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+	return string(evt)
+}
+
+func TestInitializeIdentifiesBotAndTeam(t *testing.T) {
+	fs := newFakeServer(t)
+	h := &testHandler{protocolConfig: &config{ServerURL: fs.URL + "/", Token: "secret"}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	mc := ic.Connector.(*mattermostConnector)
+	if h.botID != "bot1" || h.botMention != "floyd" || mc.teamID != "team1" {
+		t.Fatalf("bot ID %q, mention %q, team %q", h.botID, h.botMention, mc.teamID)
+	}
+	if ic.Capabilities.HiddenCommands {
+		t.Fatal("Mattermost connector should not claim hidden commands")
+	}
+
+	fs.teams = append(fs.teams, team{ID: "team2", Name: "ops"})
+	if ic := Initialize(h, nil); ic.Error == nil || !strings.Contains(ic.Error.Error(), "set Team") {
+		t.Fatalf("Initialize with two teams and no Team = %v, want an error asking for Team", ic.Error)
+	}
+	h.protocolConfig.Token = ""
+	if ic := Initialize(h, nil); ic.Error == nil {
+		t.Fatal("Initialize accepted an empty Token")
+	}
+}
+
+func TestRunDeliversThreadedAndDirectPosts(t *testing.T) {
+	fs := newFakeServer(t)
+	fs.events = []string{
+		postedEvent(t, Post{ID: "p0", ChannelID: "chan1", UserID: "alice1", Type: "system_join_channel", Message: "joined"}, "O", "general"),
+		postedEvent(t, Post{ID: "p1", ChannelID: "chan1", UserID: "alice1", RootID: "root1", Message: "floyd ping"}, "O", "general"),
+		postedEvent(t, Post{ID: "p2", ChannelID: "dm1", UserID: "alice1", Message: "help"}, "D", "bot1__alice1"),
+		postedEvent(t, Post{ID: "p3", ChannelID: "chan1", UserID: "bot1", Message: "pong"}, "O", "general"),
+	}
+	h := &testHandler{
+		protocolConfig: &config{ServerURL: fs.URL, Token: "secret", UserMap: map[string]string{"alice": "alice1"}},
+		incoming:       make(chan *robot.ConnectorMessage, 4),
+	}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- ic.Connector.Run(stop) }()
+
+	var got []*robot.ConnectorMessage
+	for len(got) < 3 {
+		select {
+		case m := <-h.incoming:
+			got = append(got, m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d messages, want 3", len(got))
+		}
+	}
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned %v after stop", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after stop")
+	}
+
+	threaded, direct, self := got[0], got[1], got[2]
+	if threaded.MessageID != "p1" || threaded.ThreadID != "root1" || !threaded.ThreadedMessage || threaded.ChannelName != "general" || threaded.DirectMessage {
+		t.Fatalf("threaded message = %+v", threaded)
+	}
+	if threaded.UserName != "alice" || !threaded.ValidatedUser || threaded.Protocol != "mattermost" {
+		t.Fatalf("threaded message user = %q validated %t protocol %q", threaded.UserName, threaded.ValidatedUser, threaded.Protocol)
+	}
+	if !direct.DirectMessage || direct.ChannelName != "" || direct.ThreadID != "p2" || direct.ThreadedMessage {
+		t.Fatalf("direct message = %+v", direct)
+	}
+	if !self.SelfMessage || self.UserName != "floyd" || self.ValidatedUser {
+		t.Fatalf("self message = %+v", self)
+	}
+}
+
+func TestRunRejectsBadToken(t *testing.T) {
+	fs := newFakeServer(t)
+	mc, err := newConnector(&testHandler{}, config{ServerURL: fs.URL, Token: "wrong"})
+	if err != nil {
+		t.Fatalf("newConnector: %v", err)
+	}
+	if err := mc.Run(make(chan struct{})); err != errUnauthorized {
+		t.Fatalf("Run with a bad token = %v, want errUnauthorized", err)
+	}
+}
+
+func TestSendsUseRootIDAndDirectChannels(t *testing.T) {
+	fs := newFakeServer(t)
+	h := &testHandler{protocolConfig: &config{ServerURL: fs.URL, Token: "secret", Team: "eng", UserMap: map[string]string{"alice": "alice1"}}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	mc := ic.Connector.(*mattermostConnector)
+
+	if ret := mc.SendProtocolUserChannelThreadMessage("", "alice", "general", "root1", "done, @alice", robot.BasicMarkdown, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolUserChannelThreadMessage = %v", ret)
+	}
+	if ret := mc.SendProtocolUserMessage("alice", "psst", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolUserMessage = %v", ret)
+	}
+	if ret := mc.SendProtocolChannelThreadMessage("missing", "", "hi", robot.Raw, nil); ret != robot.ChannelNotFound {
+		t.Fatalf("send to unknown channel = %v, want ChannelNotFound", ret)
+	}
+	if ret := mc.JoinChannel("general"); ret != robot.Ok {
+		t.Fatalf("JoinChannel = %v", ret)
+	}
+
+	want := []Post{
+		{ChannelID: "chan1", RootID: "root1", Message: "@alice.smith: done, @alice.smith"},
+		{ChannelID: "dm-alice1", Message: "psst"},
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	if len(fs.posts) != len(want) {
+		t.Fatalf("posts = %+v, want %+v", fs.posts, want)
+	}
+	for i := range want {
+		if fs.posts[i].ChannelID != want[i].ChannelID || fs.posts[i].RootID != want[i].RootID || fs.posts[i].Message != want[i].Message {
+			t.Fatalf("post %d = %+v, want %+v", i, fs.posts[i], want[i])
+		}
+	}
+	if v, ret := mc.GetProtocolUserAttribute("alice", "email"); ret != robot.Ok || v != "alice@example.com" {
+		t.Fatalf("email attribute = %q, %v", v, ret)
+	}
+	if v, ret := mc.GetProtocolUserAttribute("<alice1>", "fullname"); ret != robot.Ok || v != "Alice Smith" {
+		t.Fatalf("fullname attribute = %q, %v", v, ret)
+	}
+	if _, ret := mc.GetProtocolUserAttribute("nobody", "email"); ret != robot.UserNotFound {
+		t.Fatalf("unknown user attribute = %v, want UserNotFound", ret)
+	}
+}
+
+func TestReloadSwapsConfiguredUserMap(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{UserMap: map[string]string{"bob": "b2", "Carol": "c3"}}}
+	mc := &mattermostConnector{Handler: h, botUserMap: map[string]string{"alice": "a1"}}
+	if err := mc.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(mc.botUserMap) != 1 || mc.botUserMap["bob"] != "b2" {
+		t.Fatalf("botUserMap after reload = %v, want only bob", mc.botUserMap)
+	}
+	if _, ok := mc.configuredCanonicalUser("a1"); ok {
+		t.Fatal("removed mapping still validates")
+	}
+}
@@ -0,0 +1,115 @@
+package mattermost
+
+import (
+	"encoding/json"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type websocketEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// postedEventData is the data of a "posted" event; the post itself is a
+// JSON-encoded string.
+type postedEventData struct {
+	ChannelName string `json:"channel_name"`
+	ChannelType string `json:"channel_type"`
+	Post        string `json:"post"`
+}
+
+type userUpdatedEventData struct {
+	User User `json:"user"`
+}
+
+func (mc *mattermostConnector) handleEvent(data []byte) {
+	var evt websocketEvent
+	if err := json.Unmarshal(data, &evt); err != nil {
+		mc.Log(robot.Warn, "Ignoring undecodable Mattermost websocket message: %v", err)
+		return
+	}
+	switch evt.Event {
+	case "":
+		// replies to our own actions, e.g. typing
+	case "hello":
+		mc.Log(robot.Debug, "Mattermost websocket connected")
+	case "posted":
+		var posted postedEventData
+		if err := json.Unmarshal(evt.Data, &posted); err != nil {
+			mc.Log(robot.Warn, "Ignoring malformed Mattermost posted event: %v", err)
+			return
+		}
+		var post Post
+		if err := json.Unmarshal([]byte(posted.Post), &post); err != nil {
+			mc.Log(robot.Warn, "Ignoring Mattermost posted event with malformed post: %v", err)
+			return
+		}
+		mc.processPost(&post, posted)
+	case "channel_updated", "channel_deleted", "channel_restored", "channel_converted":
+		mc.forgetChannels()
+	case "user_updated":
+		var updated userUpdatedEventData
+		if err := json.Unmarshal(evt.Data, &updated); err == nil {
+			mc.cacheUser(updated.User)
+		}
+	default:
+		mc.Log(robot.Trace, "Ignoring Mattermost event type: %s", evt.Event)
+	}
+}
+
+// processPost turns a new post into a ConnectorMessage.
+func (mc *mattermostConnector) processPost(post *Post, posted postedEventData) {
+	if post.Type != "" {
+		mc.Log(robot.Debug, "Ignoring Mattermost system post of type '%s'", post.Type)
+		return
+	}
+	if post.UserID == "" || post.ChannelID == "" {
+		mc.Log(robot.Debug, "Ignoring Mattermost post without a user or channel")
+		return
+	}
+	threadID := post.ID
+	threaded := false
+	if post.RootID != "" {
+		threadID = post.RootID
+		threaded = true
+	}
+	channelType := posted.ChannelType
+	channelName := posted.ChannelName
+	if channelType == "" {
+		if ch, ok := mc.channelByID(post.ChannelID); ok {
+			channelType = ch.Type
+			channelName = ch.Name
+		}
+	}
+	direct := channelType == "D"
+	botMsg := &robot.ConnectorMessage{
+		Protocol:        "mattermost",
+		UserID:          post.UserID,
+		ChannelID:       post.ChannelID,
+		MessageID:       post.ID,
+		ThreadID:        threadID,
+		ThreadedMessage: threaded,
+		DirectMessage:   direct,
+		MessageText:     post.Message,
+		MessageObject:   post,
+		Client:          mc.api,
+	}
+	if !direct {
+		botMsg.ChannelName = channelName
+	}
+	if validatedName, validated := mc.configuredCanonicalUser(post.UserID); validated {
+		botMsg.UserName = validatedName
+		botMsg.ValidatedUser = true
+	}
+	if user, ok := mc.userByID(post.UserID); !ok {
+		mc.Log(robot.Debug, "Couldn't find user name for user ID '%s'", post.UserID)
+	} else if botMsg.UserName == "" {
+		botMsg.UserName = user.Username
+	}
+	if post.UserID == mc.botUserID {
+		botMsg.SelfMessage = true
+		mc.Log(robot.Trace, "Forwarding Mattermost return message '%s' from the robot", post.ID)
+	}
+	mc.IncomingMessage(botMsg)
+}
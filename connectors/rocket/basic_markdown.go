@@ -0,0 +1,483 @@
+package rocket
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Rocket.Chat markdown is Slack-like: *bold*, _italic_, ~strike~, inline
+// code, fences and [label](url) links, with no reliable backslash escapes.
+// Literal markers are padded with a soft hyphen instead, as the Slack
+// connector does.
+
+// Soft hyphen; invisible, but enough to break up a markdown marker.
+const escapePad = "\u00AD"
+
+const rocketZWSP = "\u200B"
+
+const rocketTruncatedMessage = "(message too long, truncated)"
+
+// formatMessage renders msg and splits it into messages no longer than the
+// server's limit.
+func (rc *rocketConnector) formatMessage(prefix, msg string, f robot.MessageFormat) []string {
+	var text string
+	switch f {
+	case robot.BasicMarkdown:
+		text = rc.renderBasicMarkdown(msg)
+	case robot.Fixed:
+		text = renderFixed(msg)
+	case robot.Variable:
+		text = renderVariable(msg)
+	default:
+		text = msg
+	}
+	if prefix != "" && strings.HasPrefix(text, "```") {
+		prefix = strings.TrimSpace(prefix) + "\n"
+	}
+	return splitMessage(prefix+text, rc.maxMessageLength, rc.maxMessageSplit)
+}
+
+func renderFixed(msg string) string {
+	if strings.TrimSpace(msg) == "" {
+		return ""
+	}
+	// Rocket.Chat fences don't nest, so backtick runs inside are padded.
+	msg = strings.ReplaceAll(msg, "```", "`"+escapePad+"``")
+	return "```\n" + strings.TrimSuffix(msg, "\n") + "\n```"
+}
+
+// renderVariable pads markdown markers so the text shows up as typed.
+func renderVariable(msg string) string {
+	for _, padChar := range []string{"`", "*", "_", "~", ":"} {
+		msg = strings.ReplaceAll(msg, padChar, escapePad+padChar)
+	}
+	return strings.ReplaceAll(msg, "@", "@"+rocketZWSP)
+}
+
+func (rc *rocketConnector) renderBasicMarkdown(msg string) string {
+	var out strings.Builder
+	inFence := false
+
+	for {
+		idx := strings.Index(msg, "```")
+		if idx == -1 {
+			if inFence {
+				out.WriteString(msg)
+			} else {
+				out.WriteString(rc.renderBasicMarkdownInline(msg))
+			}
+			break
+		}
+
+		chunk := msg[:idx]
+		if inFence {
+			out.WriteString(chunk)
+		} else {
+			out.WriteString(rc.renderBasicMarkdownInline(chunk))
+		}
+		out.WriteString("```")
+		inFence = !inFence
+		msg = msg[idx+3:]
+	}
+
+	return out.String()
+}
+
+func (rc *rocketConnector) renderBasicMarkdownInline(msg string) string {
+	var out strings.Builder
+	for len(msg) > 0 {
+		start := findNextUnescapedBacktick(msg, 0)
+		if start == -1 {
+			out.WriteString(rc.renderBasicMarkdownPlain(msg))
+			break
+		}
+		out.WriteString(rc.renderBasicMarkdownPlain(msg[:start]))
+
+		end := findNextUnescapedBacktick(msg, start+1)
+		if end == -1 {
+			// Unterminated inline-code delimiter: treat as plain text.
+			out.WriteString(rc.renderBasicMarkdownPlain(msg[start:]))
+			break
+		}
+		out.WriteString(msg[start : end+1])
+		msg = msg[end+1:]
+	}
+	return out.String()
+}
+
+func findNextUnescapedBacktick(msg string, start int) int {
+	for i := start; i < len(msg); i++ {
+		if msg[i] == '`' && !isEscapedAt(msg, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isEscapedAt(msg string, idx int) bool {
+	if idx <= 0 || idx > len(msg)-1 {
+		return false
+	}
+	slashes := 0
+	for i := idx - 1; i >= 0 && msg[i] == '\\'; i-- {
+		slashes++
+	}
+	return slashes%2 == 1
+}
+
+func (rc *rocketConnector) renderBasicMarkdownPlain(msg string) string {
+	msg, escapedLiterals := protectBasicMarkdownEscapes(msg)
+
+	mdTokens := make([]string, 0)
+	reserveMD := func(token string) string {
+		mdTokens = append(mdTokens, token)
+		return markdownPlaceholder(len(mdTokens) - 1)
+	}
+
+	msg = replaceBasicMarkdownLinks(msg, reserveMD)
+	msg = rc.replaceBasicMarkdownMentions(msg)
+	msg = replaceBasicMarkdownEmphasis(msg, reserveMD)
+	msg = restoreEscapedLiterals(msg, escapedLiterals)
+	msg = restoreMarkdownPlaceholders(msg, mdTokens)
+
+	return msg
+}
+
+func replaceBasicMarkdownEmphasis(msg string, reserveMD func(string) string) string {
+	msg = replaceBasicMarkdownBold(msg, reserveMD)
+	msg = replaceBasicMarkdownItalic(msg)
+	return msg
+}
+
+func replaceBasicMarkdownBold(msg string, reserveMD func(string) string) string {
+	var out strings.Builder
+
+	for len(msg) > 0 {
+		start := strings.Index(msg, "**")
+		if start == -1 {
+			out.WriteString(msg)
+			break
+		}
+
+		out.WriteString(msg[:start])
+		msg = msg[start+2:]
+
+		end := strings.Index(msg, "**")
+		if end == -1 {
+			out.WriteString("**")
+			out.WriteString(msg)
+			break
+		}
+
+		inner := msg[:end]
+		if inner == "" {
+			out.WriteString("****")
+		} else {
+			out.WriteString(reserveMD("*" + inner + "*"))
+		}
+		msg = msg[end+2:]
+	}
+
+	return out.String()
+}
+
+func replaceBasicMarkdownItalic(msg string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(msg); {
+		if msg[i] != '*' || isAdjacentAsterisk(msg, i) {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+
+		end := findNextSingleAsterisk(msg, i+1)
+		if end == -1 {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+
+		inner := msg[i+1 : end]
+		if inner == "" {
+			out.WriteString("**")
+			i = end + 1
+			continue
+		}
+
+		out.WriteByte('_')
+		out.WriteString(inner)
+		out.WriteByte('_')
+		i = end + 1
+	}
+
+	return out.String()
+}
+
+func findNextSingleAsterisk(msg string, start int) int {
+	for i := start; i < len(msg); i++ {
+		if msg[i] == '*' && !isAdjacentAsterisk(msg, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isAdjacentAsterisk(msg string, idx int) bool {
+	return (idx > 0 && msg[idx-1] == '*') || (idx+1 < len(msg) && msg[idx+1] == '*')
+}
+
+func protectBasicMarkdownEscapes(msg string) (string, []string) {
+	escapedLiterals := make([]string, 0)
+	var out strings.Builder
+
+	for i := 0; i < len(msg); i++ {
+		ch := msg[i]
+		if ch != '\\' || i+1 >= len(msg) || !isBasicMarkdownEscapable(msg[i+1]) {
+			out.WriteByte(ch)
+			continue
+		}
+		escapedLiterals = append(escapedLiterals, string(msg[i+1]))
+		out.WriteString(escapedPlaceholder(len(escapedLiterals) - 1))
+		i++
+	}
+
+	return out.String(), escapedLiterals
+}
+
+func isBasicMarkdownEscapable(ch byte) bool {
+	switch ch {
+	case '*', '`', '[', ']', '(', ')', '@', '\\':
+		return true
+	default:
+		return false
+	}
+}
+
+// replaceBasicMarkdownLinks reserves [label](url) links, which Rocket.Chat
+// renders natively, so emphasis conversion leaves them alone.
+func replaceBasicMarkdownLinks(msg string, reserveMD func(string) string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(msg); {
+		open := strings.IndexByte(msg[i:], '[')
+		if open == -1 {
+			out.WriteString(msg[i:])
+			break
+		}
+		open += i
+		out.WriteString(msg[i:open])
+
+		close := strings.IndexByte(msg[open+1:], ']')
+		if close == -1 {
+			out.WriteByte(msg[open])
+			i = open + 1
+			continue
+		}
+		close += open + 1
+
+		if close+1 >= len(msg) || msg[close+1] != '(' {
+			out.WriteString(msg[open : close+1])
+			i = close + 1
+			continue
+		}
+
+		end := strings.IndexByte(msg[close+2:], ')')
+		if end == -1 {
+			out.WriteString(msg[open:])
+			break
+		}
+		end += close + 2
+
+		url := msg[close+2 : end]
+		if !isBasicMarkdownLinkURL(url) {
+			out.WriteString(msg[open : end+1])
+			i = end + 1
+			continue
+		}
+		out.WriteString(reserveMD(msg[open : end+1]))
+		i = end + 1
+	}
+
+	return out.String()
+}
+
+func isBasicMarkdownLinkURL(url string) bool {
+	if strings.ContainsAny(url, " \t\r\n") {
+		return false
+	}
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
+
+// replaceBasicMarkdownMentions rewrites @username for users in the
+// configured UserMap to their Rocket.Chat username. Anything else is left
+// literal, which Rocket.Chat itself resolves against its own usernames.
+func (rc *rocketConnector) replaceBasicMarkdownMentions(msg string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(msg); {
+		if msg[i] != '@' {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(msg) && isMentionTokenChar(msg[i]) {
+			i++
+		}
+		if i == start+1 {
+			out.WriteByte('@')
+			continue
+		}
+
+		mention, suffix := splitMentionCandidate(msg[start+1 : i])
+		if mention == "" || (start > 0 && isEmailLocalChar(msg[start-1])) {
+			out.WriteString(msg[start:i])
+			continue
+		}
+		if username, ok := rc.resolveBasicMarkdownMention(mention); ok {
+			out.WriteString("@" + username)
+		} else {
+			out.WriteString("@" + mention)
+		}
+		out.WriteString(suffix)
+	}
+
+	return out.String()
+}
+
+func (rc *rocketConnector) resolveBasicMarkdownMention(name string) (string, bool) {
+	rc.RLock()
+	id, ok := rc.botUserMap[strings.ToLower(name)]
+	rc.RUnlock()
+	if !ok {
+		return "", false
+	}
+	user, ok := rc.userByID(id)
+	if !ok || user.Username == "" {
+		return "", false
+	}
+	return user.Username, true
+}
+
+func isMentionTokenChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_' || ch == '-' || ch == '.'
+}
+
+func splitMentionCandidate(token string) (mention string, suffix string) {
+	cut := len(token)
+	for cut > 0 && !isMentionTerminalChar(token[cut-1]) {
+		cut--
+	}
+	return token[:cut], token[cut:]
+}
+
+func isMentionTerminalChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_'
+}
+
+func isEmailLocalChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '_' || ch == '.' || ch == '%' || ch == '+' || ch == '-'
+}
+
+func escapedPlaceholder(idx int) string {
+	return fmt.Sprintf("\x00GBESC%d\x00", idx)
+}
+
+func markdownPlaceholder(idx int) string {
+	return fmt.Sprintf("\x00GBMD%d\x00", idx)
+}
+
+func restoreEscapedLiterals(msg string, literals []string) string {
+	out := msg
+	for i, literal := range literals {
+		out = strings.ReplaceAll(out, escapedPlaceholder(i), rocketEscapedLiteral(literal))
+	}
+	return out
+}
+
+// rocketEscapedLiteral keeps an escaped character literal; a zero-width
+// space after @ stops Rocket.Chat from treating it as a mention.
+func rocketEscapedLiteral(literal string) string {
+	switch literal {
+	case "*", "`", "_":
+		return escapePad + literal
+	case "@":
+		return "@" + rocketZWSP
+	default:
+		return literal
+	}
+}
+
+func restoreMarkdownPlaceholders(msg string, tokens []string) string {
+	out := msg
+	for i, token := range tokens {
+		out = strings.ReplaceAll(out, markdownPlaceholder(i), token)
+	}
+	return out
+}
+
+// splitMessage breaks msg into at most maxSplit messages of maxLen
+// characters, preferring newline boundaries and closing/reopening code
+// fences across messages. Text past the last allowed message is dropped with
+// a notice.
+func splitMessage(msg string, maxLen, maxSplit int) []string {
+	if utf8.RuneCountInString(msg) <= maxLen {
+		return []string{msg}
+	}
+	// Room for a closing fence and the truncation notice.
+	limit := maxLen - len(rocketTruncatedMessage) - 8
+	if limit < 1 {
+		limit = maxLen
+	}
+	chunks := make([]string, 0, maxSplit)
+	for msg != "" {
+		if utf8.RuneCountInString(msg) <= maxLen {
+			chunks = append(chunks, msg)
+			break
+		}
+		cut := byteIndexForRunes(msg, limit)
+		if nl := strings.LastIndexByte(msg[:cut], '\n'); nl > 0 {
+			cut = nl
+		}
+		chunk := msg[:cut]
+		open := strings.Count(chunk, "```")%2 == 1
+		if open {
+			chunk += "\n```"
+		}
+		if len(chunks) == maxSplit-1 {
+			chunks = append(chunks, chunk+"\n"+rocketTruncatedMessage)
+			break
+		}
+		chunks = append(chunks, chunk)
+		msg = strings.TrimPrefix(msg[cut:], "\n")
+		if open {
+			msg = "```\n" + msg
+		}
+	}
+	return chunks
+}
+
+func byteIndexForRunes(msg string, runes int) int {
+	for i := range msg {
+		if runes == 0 {
+			return i
+		}
+		runes--
+	}
+	return len(msg)
+}
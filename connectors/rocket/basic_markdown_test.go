@@ -0,0 +1,73 @@
+package rocket
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func newRenderTestConnector() *rocketConnector {
+	return &rocketConnector{
+		Handler:          &testHandler{},
+		botUserMap:       map[string]string{"alice": "a1"},
+		usersByID:        map[string]User{"a1": {ID: "a1", Username: "alice.smith", Emails: []Email{{Address: "alice@example.com"}}}},
+		userIDsByName:    map[string]string{"alice.smith": "a1"},
+		maxMessageLength: defaultMaxMessageLength,
+		maxMessageSplit:  1,
+	}
+}
+
+func TestRenderBasicMarkdownConvertsEmphasisAndKeepsLinks(t *testing.T) {
+	rc := newRenderTestConnector()
+	in := "**Deploy status:** *rollback* :rocket: see [the **runbook**](https://example.com/a_b*c)\n```yaml\nkind: Pod # **@alice**\n```"
+	got := rc.renderBasicMarkdown(in)
+	want := "*Deploy status:* _rollback_ :rocket: see [the **runbook**](https://example.com/a_b*c)\n```yaml\nkind: Pod # **@alice**\n```"
+	if got != want {
+		t.Fatalf("renderBasicMarkdown() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderBasicMarkdownMentionsAndEscapes(t *testing.T) {
+	rc := newRenderTestConnector()
+	in := "Paging @Alice, @david and bob@example.com; `@alice` \\*not bold\\* \\@alice"
+	got := rc.renderBasicMarkdown(in)
+	want := "Paging @alice.smith, @david and bob@example.com; `@alice` " + escapePad + "*not bold" + escapePad + "* @" + rocketZWSP + "alice"
+	if got != want {
+		t.Fatalf("renderBasicMarkdown() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatMessageVariableAndFixed(t *testing.T) {
+	rc := newRenderTestConnector()
+	got := rc.formatMessage("", "*x* _y_ @here", robot.Variable)
+	want := escapePad + "*x" + escapePad + "* " + escapePad + "_y" + escapePad + "_ @" + rocketZWSP + "here"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("formatMessage(Variable) = %q, want %q", got, want)
+	}
+	got = rc.formatMessage("@alice.smith: ", "has ``` inside\n", robot.Fixed)
+	want = "@alice.smith:\n```\nhas `" + escapePad + "`` inside\n```"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("formatMessage(Fixed) = %q, want %q", got, want)
+	}
+}
+
+func TestSplitMessageReopensFencesAndTruncates(t *testing.T) {
+	line := strings.Repeat("x", 30)
+	msg := "```\n" + strings.Repeat(line+"\n", 8) + "```"
+	chunks := splitMessage(msg, 120, 2)
+	if len(chunks) != 2 {
+		t.Fatalf("splitMessage returned %d chunks, want 2: %q", len(chunks), chunks)
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 120 {
+			t.Fatalf("chunk %d is %d characters, over the limit", i, len(chunk))
+		}
+		if strings.Count(chunk, "```")%2 != 0 {
+			t.Fatalf("chunk %d leaves a fence open: %q", i, chunk)
+		}
+	}
+	if !strings.HasPrefix(chunks[1], "```\n") || !strings.HasSuffix(chunks[1], rocketTruncatedMessage) {
+		t.Fatalf("last chunk = %q, want reopened fence and truncation notice", chunks[1])
+	}
+}
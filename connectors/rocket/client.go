@@ -0,0 +1,219 @@
+package rocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is a minimal Rocket.Chat REST API v1 client; it's passed to Go
+// extensions as ConnectorMessage.Client.
+type Client struct {
+	baseURL string // server URL without a trailing slash
+	userID  string
+	token   string
+	http    *http.Client
+}
+
+// User is the subset of a Rocket.Chat user object the connector uses.
+type User struct {
+	ID       string  `json:"_id"`
+	Username string  `json:"username"`
+	Name     string  `json:"name"`
+	Emails   []Email `json:"emails"`
+}
+
+// Email is one of a User's addresses.
+type Email struct {
+	Address  string `json:"address"`
+	Verified bool   `json:"verified"`
+}
+
+// Room is the subset of a Rocket.Chat room object the connector uses.
+type Room struct {
+	ID   string `json:"_id"`
+	RID  string `json:"rid"` // set instead of _id by some DM endpoints
+	Name string `json:"name"`
+	Type string `json:"t"`
+}
+
+func (r Room) roomID() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return r.RID
+}
+
+// Message is a Rocket.Chat chat message; incoming messages carry it as
+// ConnectorMessage.MessageObject.
+type Message struct {
+	ID       string           `json:"_id"`
+	RoomID   string           `json:"rid"`
+	Text     string           `json:"msg"`
+	ThreadID string           `json:"tmid,omitempty"`
+	Type     string           `json:"t,omitempty"`
+	User     MessageUser      `json:"u"`
+	EditedAt *json.RawMessage `json:"editedAt,omitempty"`
+	Bot      *json.RawMessage `json:"bot,omitempty"`
+}
+
+// MessageUser identifies the author of a Message.
+type MessageUser struct {
+	ID       string `json:"_id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+type outgoingMessage struct {
+	RoomID   string `json:"rid"`
+	Text     string `json:"msg"`
+	ThreadID string `json:"tmid,omitempty"`
+}
+
+// APIError is a failed Rocket.Chat REST call.
+type APIError struct {
+	StatusCode int
+	Message    string `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("rocket.chat API returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("rocket.chat API returned HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+func newClient(serverURL, userID, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(serverURL, "/"),
+		userID:  userID,
+		token:   token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Do sends an authenticated request to path under /api/v1, JSON-encoding
+// body when it's non-nil and decoding the response into out when it's
+// non-nil.
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-User-Id", c.userID)
+	req.Header.Set("X-Auth-Token", c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = json.Unmarshal(data, apiErr)
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) getMe(ctx context.Context) (User, error) {
+	var u User
+	err := c.Do(ctx, http.MethodGet, "me", nil, &u)
+	return u, err
+}
+
+func (c *Client) getUser(ctx context.Context, query url.Values) (User, error) {
+	var resp struct {
+		User User `json:"user"`
+	}
+	err := c.Do(ctx, http.MethodGet, "users.info?"+query.Encode(), nil, &resp)
+	return resp.User, err
+}
+
+func (c *Client) getRoom(ctx context.Context, query url.Values) (Room, error) {
+	var resp struct {
+		Room Room `json:"room"`
+	}
+	err := c.Do(ctx, http.MethodGet, "rooms.info?"+query.Encode(), nil, &resp)
+	return resp.Room, err
+}
+
+func (c *Client) createDirectRoom(ctx context.Context, username string) (Room, error) {
+	var resp struct {
+		Room Room `json:"room"`
+	}
+	err := c.Do(ctx, http.MethodPost, "im.create", map[string]string{"username": username}, &resp)
+	return resp.Room, err
+}
+
+func (c *Client) joinChannel(ctx context.Context, roomID string) error {
+	return c.Do(ctx, http.MethodPost, "channels.join", map[string]string{"roomId": roomID}, nil)
+}
+
+func (c *Client) sendMessage(ctx context.Context, msg outgoingMessage) error {
+	return c.Do(ctx, http.MethodPost, "chat.sendMessage", map[string]outgoingMessage{"message": msg}, nil)
+}
+
+// realtimeURL maps the server URL to the DDP websocket endpoint.
+func realtimeURL(serverURL string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(serverURL, "/"))
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", errors.New("URL has no host")
+	}
+	u.Path += "/websocket"
+	return u.String(), nil
+}
+
+func apiErrorRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// apiErrorNotFound reports a failed lookup; Rocket.Chat answers most unknown
+// users and rooms with HTTP 400 rather than 404.
+func apiErrorNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusBadRequest)
+}
@@ -0,0 +1,273 @@
+// Package rocket implements the robot.Connector interface for Rocket.Chat,
+// using the realtime (DDP) websocket API for incoming messages and the REST
+// API for everything else.
+package rocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	// Rocket.Chat's default Message_MaxAllowedSize.
+	defaultMaxMessageLength = 5000
+	apiTimeout              = 30 * time.Second
+	reconnectMinDelay       = 2 * time.Second
+	reconnectMaxDelay       = 2 * time.Minute
+	realtimePingInterval    = 30 * time.Second
+	realtimeReadTimeout     = 90 * time.Second
+)
+
+var errUnauthorized = errors.New("rocket.chat rejected the connector credentials")
+
+type config struct {
+	ServerURL        string // base URL of the Rocket.Chat server, e.g. https://chat.example.com
+	UserID           string // user ID the access token belongs to
+	Token            string // personal access token for the bot user
+	MaxMessageSplit  int    // the maximum number of messages to emit for one long outbound send
+	MaxMessageLength int    // the server's maximum message size in characters
+	UserMap          map[string]string
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, id := range in {
+		name := strings.TrimSpace(user)
+		uid := strings.TrimSpace(id)
+		if name == "" || uid == "" {
+			h.Log(robot.Warn, "Ignoring invalid Rocket.Chat UserMap entry (empty username or user ID): %q -> %q", user, id)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring Rocket.Chat UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		out[name] = uid
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func newConnector(handler robot.Handler, c config) (*rocketConnector, error) {
+	serverURL := strings.TrimSpace(c.ServerURL)
+	if serverURL == "" {
+		return nil, fmt.Errorf("Rocket.Chat protocol config requires ServerURL")
+	}
+	userID := strings.TrimSpace(c.UserID)
+	token := strings.TrimSpace(c.Token)
+	if userID == "" || token == "" {
+		return nil, fmt.Errorf("Rocket.Chat protocol config requires UserID and Token")
+	}
+	wsURL, err := realtimeURL(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Rocket.Chat ServerURL %q: %w", serverURL, err)
+	}
+	if c.MaxMessageSplit <= 0 {
+		c.MaxMessageSplit = 1
+	}
+	if c.MaxMessageLength <= 0 {
+		c.MaxMessageLength = defaultMaxMessageLength
+	}
+	return &rocketConnector{
+		Handler:          handler,
+		api:              newClient(serverURL, userID, token),
+		wsURL:            wsURL,
+		maxMessageSplit:  c.MaxMessageSplit,
+		maxMessageLength: c.MaxMessageLength,
+		botUserMap:       normalizeConfiguredUserMap(c.UserMap, handler),
+		usersByID:        make(map[string]User),
+		userIDsByName:    make(map[string]string),
+		roomsByID:        make(map[string]Room),
+		roomIDsByName:    make(map[string]string),
+		directRooms:      make(map[string]string),
+		seenMessages:     make(map[string]time.Time),
+		dialer:           websocket.DefaultDialer,
+		reconnectDelay:   reconnectMinDelay,
+	}, nil
+}
+
+// Initialize validates config, looks up the bot user and returns the
+// connector.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to retrieve rocket protocol configuration: %w", err)}
+	}
+	rc, err := newConnector(handler, c)
+	if err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	me, err := rc.api.getMe(ctx)
+	if err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to look up the Rocket.Chat bot user: %w", err)}
+	}
+	rc.botUserID = me.ID
+	rc.botName = me.Username
+	rc.cacheUser(me)
+	handler.Log(robot.Info, "Rocket.Chat connector using bot user '%s' (%s)", rc.botName, rc.botUserID)
+	handler.SetBotID(rc.botUserID)
+	// The Rocket.Chat username may not match the robot's configured name.
+	handler.SetBotMention(rc.botName)
+	return robot.InitializedConnector{Connector: rc}
+}
+
+// Reload swaps in the configured UserMap; server and credential changes
+// need a restart.
+func (rc *rocketConnector) Reload() error {
+	var c config
+	if err := rc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Rocket.Chat protocol configuration: %w", err)
+	}
+	userMap := normalizeConfiguredUserMap(c.UserMap, rc.Handler)
+	rc.Lock()
+	rc.botUserMap = userMap
+	rc.Unlock()
+	rc.Log(robot.Info, "Rocket.Chat connector reloaded %d configured user mapping(s)", len(userMap))
+	return nil
+}
+
+// Run reads the realtime message stream until stop is closed, reconnecting
+// with backoff after transient failures. Only rejected credentials end it
+// early.
+func (rc *rocketConnector) Run(stop <-chan struct{}) error {
+	delay := rc.reconnectDelay
+	for {
+		connected, err := rc.readEvents(stop)
+		select {
+		case <-stop:
+			rc.Log(robot.Debug, "Received stop in connector")
+			return nil
+		default:
+		}
+		if errors.Is(err, errUnauthorized) {
+			return err
+		}
+		if connected {
+			delay = rc.reconnectDelay
+		}
+		rc.Log(robot.Warn, "Rocket.Chat realtime connection lost; reconnecting in %v: %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// readEvents runs one DDP session: connect, resume login with the access
+// token, subscribe to the bot's messages and read until the socket fails.
+// connected reports whether the login succeeded.
+func (rc *rocketConnector) readEvents(stop <-chan struct{}) (connected bool, err error) {
+	conn, _, err := rc.dialer.Dial(rc.wsURL, nil)
+	if err != nil {
+		return false, err
+	}
+	rc.wsLock.Lock()
+	rc.ws = conn
+	rc.wsLock.Unlock()
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		rc.wsLock.Lock()
+		rc.ws = nil
+		rc.wsLock.Unlock()
+		conn.Close()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(realtimePingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := rc.writeDDP(map[string]string{"msg": "ping"}); err != nil {
+					rc.Log(robot.Debug, "Rocket.Chat realtime ping failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	if err := rc.writeDDP(map[string]interface{}{"msg": "connect", "version": "1", "support": []string{"1"}}); err != nil {
+		return false, err
+	}
+	if err := rc.writeDDP(map[string]interface{}{
+		"msg":    "method",
+		"method": "login",
+		"id":     ddpLoginID,
+		"params": []interface{}{map[string]string{"resume": rc.api.token}},
+	}); err != nil {
+		return false, err
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(realtimeReadTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return connected, err
+		}
+		var msg ddpMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			rc.Log(robot.Warn, "Ignoring undecodable Rocket.Chat realtime message: %v", err)
+			continue
+		}
+		switch msg.Msg {
+		case "ping":
+			pong := map[string]string{"msg": "pong"}
+			if msg.ID != "" {
+				pong["id"] = msg.ID
+			}
+			if err := rc.writeDDP(pong); err != nil {
+				return connected, err
+			}
+		case "result":
+			if msg.ID != ddpLoginID {
+				if msg.Error != nil {
+					rc.Log(robot.Debug, "Rocket.Chat realtime method %s failed: %s", msg.ID, msg.Error)
+				}
+				continue
+			}
+			if msg.Error != nil {
+				return false, fmt.Errorf("%w: %s", errUnauthorized, msg.Error)
+			}
+			connected = true
+			rc.Log(robot.Debug, "Rocket.Chat realtime login succeeded")
+			if err := rc.writeDDP(map[string]interface{}{
+				"msg":    "sub",
+				"id":     ddpMessagesSubID,
+				"name":   "stream-room-messages",
+				"params": []interface{}{"__my_messages__", false},
+			}); err != nil {
+				return connected, err
+			}
+		case "nosub":
+			return connected, fmt.Errorf("rocket.chat refused the message subscription: %s", msg.Error)
+		case "changed":
+			if msg.Collection == "stream-room-messages" {
+				rc.handleStreamMessage(msg.Fields)
+			}
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package rocket
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	rocketSendTimeout  = 30 * time.Second
+	rocketSendAttempts = 3
+)
+
+type rocketConnector struct {
+	robot.Handler
+	sync.RWMutex                       // protects the maps below
+	api              *Client           // REST client
+	wsURL            string            // realtime websocket endpoint
+	botUserID        string            // Rocket.Chat user ID of the bot
+	botName          string            // Rocket.Chat username of the bot
+	maxMessageSplit  int               // the maximum number of messages for one long send
+	maxMessageLength int               // the server's maximum message size
+	botUserMap       map[string]string // connector-local configured mappings of username to user ID
+	usersByID        map[string]User   // cached Rocket.Chat users
+	userIDsByName    map[string]string // Rocket.Chat username to user ID
+	roomsByID        map[string]Room
+	roomIDsByName    map[string]string
+	directRooms      map[string]string // user ID to DM room ID
+
+	seenLock     sync.Mutex
+	seenMessages map[string]time.Time
+
+	dialer         *websocket.Dialer
+	reconnectDelay time.Duration
+	wsLock         sync.Mutex // serializes websocket writes
+	ws             *websocket.Conn
+	ddpSeq         int
+	sendLock       sync.Mutex // keeps outbound messages in order
+	retrySleep     func(context.Context, time.Duration) error
+}
+
+func (rc *rocketConnector) writeDDP(v interface{}) error {
+	rc.wsLock.Lock()
+	defer rc.wsLock.Unlock()
+	if rc.ws == nil {
+		return websocket.ErrCloseSent
+	}
+	rc.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return rc.ws.WriteJSON(v)
+}
+
+func (rc *rocketConnector) cacheUser(u User) {
+	if u.ID == "" {
+		return
+	}
+	rc.Lock()
+	if old, ok := rc.usersByID[u.ID]; ok && old.Username != u.Username {
+		delete(rc.userIDsByName, old.Username)
+	}
+	rc.usersByID[u.ID] = u
+	if u.Username != "" {
+		rc.userIDsByName[u.Username] = u.ID
+	}
+	rc.Unlock()
+}
+
+// noteUser remembers a message author's username without replacing a
+// fuller cached record.
+func (rc *rocketConnector) noteUser(u MessageUser) {
+	rc.RLock()
+	old, ok := rc.usersByID[u.ID]
+	rc.RUnlock()
+	if ok && old.Username == u.Username {
+		return
+	}
+	rc.cacheUser(User{ID: u.ID, Username: u.Username, Name: u.Name})
+}
+
+func (rc *rocketConnector) lookupUser(query url.Values) (User, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	u, err := rc.api.getUser(ctx, query)
+	if err != nil {
+		if !apiErrorNotFound(err) {
+			rc.Log(robot.Error, "Looking up Rocket.Chat user %s: %v", query.Encode(), err)
+		}
+		return User{}, false
+	}
+	rc.cacheUser(u)
+	return u, true
+}
+
+func (rc *rocketConnector) userByID(id string) (User, bool) {
+	rc.RLock()
+	u, ok := rc.usersByID[id]
+	rc.RUnlock()
+	if ok && len(u.Emails) > 0 {
+		return u, true
+	}
+	if found, ok := rc.lookupUser(url.Values{"userId": {id}}); ok {
+		return found, true
+	}
+	return u, ok
+}
+
+// userID resolves a canonical username to a Rocket.Chat user ID; configured
+// UserMap entries win over same-named Rocket.Chat accounts.
+func (rc *rocketConnector) userID(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", false
+	}
+	rc.RLock()
+	id, ok := rc.botUserMap[name]
+	if !ok {
+		id, ok = rc.userIDsByName[name]
+	}
+	rc.RUnlock()
+	if ok {
+		return id, true
+	}
+	u, ok := rc.lookupUser(url.Values{"username": {name}})
+	return u.ID, ok
+}
+
+func (rc *rocketConnector) configuredCanonicalUser(id string) (string, bool) {
+	rc.RLock()
+	defer rc.RUnlock()
+	for name, uid := range rc.botUserMap {
+		if uid == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (rc *rocketConnector) cacheRoom(r Room) {
+	id := r.roomID()
+	if id == "" {
+		return
+	}
+	rc.Lock()
+	rc.roomsByID[id] = r
+	if r.Name != "" && r.Type != "d" {
+		rc.roomIDsByName[r.Name] = id
+	}
+	rc.Unlock()
+}
+
+func (rc *rocketConnector) roomByID(id string) (Room, bool) {
+	rc.RLock()
+	r, ok := rc.roomsByID[id]
+	rc.RUnlock()
+	if ok {
+		return r, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	r, err := rc.api.getRoom(ctx, url.Values{"roomId": {id}})
+	if err != nil {
+		rc.Log(robot.Error, "Looking up Rocket.Chat room ID '%s': %v", id, err)
+		return Room{}, false
+	}
+	rc.cacheRoom(r)
+	return r, true
+}
+
+// roomID takes a bracketed ID from a roster or a channel name.
+func (rc *rocketConnector) roomID(ch string) (string, bool) {
+	if id, ok := util.ExtractID(ch); ok {
+		return id, true
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(ch), "#")
+	if name == "" {
+		return "", false
+	}
+	rc.RLock()
+	id, ok := rc.roomIDsByName[name]
+	rc.RUnlock()
+	if ok {
+		return id, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	r, err := rc.api.getRoom(ctx, url.Values{"roomName": {name}})
+	if err != nil {
+		if !apiErrorNotFound(err) {
+			rc.Log(robot.Error, "Looking up Rocket.Chat room '%s': %v", name, err)
+		}
+		return "", false
+	}
+	rc.cacheRoom(r)
+	return r.roomID(), r.roomID() != ""
+}
+
+func (rc *rocketConnector) directRoomID(userID string) (string, bool) {
+	rc.RLock()
+	id, ok := rc.directRooms[userID]
+	rc.RUnlock()
+	if ok {
+		return id, true
+	}
+	user, ok := rc.userByID(userID)
+	if !ok || user.Username == "" {
+		rc.Log(robot.Error, "No Rocket.Chat username found for user ID '%s'", userID)
+		return "", false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	r, err := rc.api.createDirectRoom(ctx, user.Username)
+	if err != nil {
+		rc.Log(robot.Error, "Opening Rocket.Chat direct room to '%s': %v", user.Username, err)
+		return "", false
+	}
+	id = r.roomID()
+	rc.Lock()
+	rc.directRooms[userID] = id
+	rc.Unlock()
+	return id, id != ""
+}
+
+// GetProtocolUserAttribute returns a string attribute or "" if Rocket.Chat
+// doesn't have that information.
+func (rc *rocketConnector) GetProtocolUserAttribute(u, attr string) (value string, ret robot.RetVal) {
+	id, ok := util.ExtractID(u)
+	if !ok {
+		id, ok = rc.userID(u)
+	}
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	user, ok := rc.userByID(id)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	switch attr {
+	case "email":
+		if len(user.Emails) == 0 {
+			return "", robot.AttributeNotFound
+		}
+		return user.Emails[0].Address, robot.Ok
+	case "internalid":
+		return user.ID, robot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return user.Name, robot.Ok
+	default:
+		return "", robot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a typing notification over the realtime connection.
+func (rc *rocketConnector) MessageHeard(user, channel string) {
+	roomID, ok := util.ExtractID(channel)
+	if !ok {
+		return
+	}
+	rc.wsLock.Lock()
+	rc.ddpSeq++
+	id := "gopherbot-typing-" + strconv.Itoa(rc.ddpSeq)
+	rc.wsLock.Unlock()
+	err := rc.writeDDP(map[string]interface{}{
+		"msg":    "method",
+		"method": "stream-notify-room",
+		"id":     id,
+		"params": []interface{}{roomID + "/user-activity", rc.botName, []string{"user-typing"}, map[string]string{}},
+	})
+	if err != nil {
+		rc.Log(robot.Debug, "Rocket.Chat typing notification failed: %v", err)
+	}
+}
+
+func (rc *rocketConnector) DefaultHelp() []string {
+	return nil
+}
+
+// JoinChannel joins a public channel.
+func (rc *rocketConnector) JoinChannel(c string) robot.RetVal {
+	roomID, ok := rc.roomID(c)
+	if !ok {
+		rc.Log(robot.Error, "Rocket.Chat room ID not found for: %s", c)
+		return robot.ChannelNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	if err := rc.api.joinChannel(ctx, roomID); err != nil {
+		rc.Log(robot.Error, "Joining channel '%s': %v", c, err)
+	} else {
+		rc.Log(robot.Debug, "Joined channel %s/%s", c, roomID)
+	}
+	return robot.Ok
+}
+
+func (rc *rocketConnector) sleepRetry(ctx context.Context, delay time.Duration) error {
+	if rc.retrySleep != nil {
+		return rc.retrySleep(ctx, delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendMessages sends every chunk in order; Ok means the server accepted all
+// of them.
+func (rc *rocketConnector) sendMessages(roomID, threadID string, chunks []string) robot.RetVal {
+	if len(chunks) == 0 {
+		return robot.FailedMessageSend
+	}
+	rc.sendLock.Lock()
+	defer rc.sendLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), rocketSendTimeout)
+	defer cancel()
+	for _, chunk := range chunks {
+		msg := outgoingMessage{RoomID: roomID, Text: chunk, ThreadID: threadID}
+		var err error
+		for attempt := 0; attempt < rocketSendAttempts; attempt++ {
+			if err = rc.api.sendMessage(ctx, msg); err == nil {
+				break
+			}
+			if attempt == rocketSendAttempts-1 || !apiErrorRetryable(err) {
+				break
+			}
+			delay := time.Second << attempt
+			rc.Log(robot.Warn, "Sending Rocket.Chat message to room '%s' failed (attempt %d/%d); retrying in %v: %v", roomID, attempt+1, rocketSendAttempts, delay, err)
+			if serr := rc.sleepRetry(ctx, delay); serr != nil {
+				err = serr
+				break
+			}
+		}
+		if err != nil {
+			rc.Log(robot.Error, "Failed sending Rocket.Chat message to room '%s': %v", roomID, err)
+			return robot.FailedMessageSend
+		}
+	}
+	return robot.Ok
+}
+
+// SendProtocolChannelThreadMessage sends a message to a room, replying in
+// the thread started by thr when set.
+func (rc *rocketConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	roomID, ok := rc.roomID(ch)
+	if !ok {
+		rc.Log(robot.Error, "Rocket.Chat room ID not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	return rc.sendMessages(roomID, thr, rc.formatMessage("", msg, f))
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a room addressed
+// to a user with an @mention.
+func (rc *rocketConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	roomID, ok := rc.roomID(ch)
+	if !ok {
+		rc.Log(robot.Error, "Rocket.Chat room ID not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	userID, ok := util.ExtractID(uid)
+	if !ok {
+		userID, ok = rc.userID(u)
+	}
+	if !ok {
+		rc.Log(robot.Error, "Rocket.Chat user ID not found for: %s", u)
+		return robot.UserNotFound
+	}
+	prefix := "@" + u + ": "
+	if user, found := rc.userByID(userID); found && user.Username != "" {
+		prefix = "@" + user.Username + ": "
+	}
+	return rc.sendMessages(roomID, thr, rc.formatMessage(prefix, msg, f))
+}
+
+// SendProtocolUserMessage sends a direct message to a user.
+func (rc *rocketConnector) SendProtocolUserMessage(u, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	userID, ok := util.ExtractID(u)
+	if !ok {
+		userID, ok = rc.userID(u)
+	}
+	if !ok {
+		rc.Log(robot.Error, "No Rocket.Chat user ID found for user: %s", u)
+		return robot.UserNotFound
+	}
+	roomID, ok := rc.directRoomID(userID)
+	if !ok {
+		return robot.FailedMessageSend
+	}
+	return rc.sendMessages(roomID, "", rc.formatMessage("", msg, f))
+}
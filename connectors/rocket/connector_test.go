@@ -0,0 +1,342 @@
+package rocket
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	protocolConfig *config
+	botID          string
+	botMention     string
+	incoming       chan *robot.ConnectorMessage
+}
+
+func (t *testHandler) IncomingMessage(m *robot.ConnectorMessage) {
+	if t.incoming != nil {
+		t.incoming <- m
+	}
+}
+func (t *testHandler) GetProtocolConfig(v interface{}) error {
+	if t.protocolConfig != nil {
+		*(v.(*config)) = *t.protocolConfig
+	}
+	return nil
+}
+func (t *testHandler) GetBrainConfig(_ interface{}) error         { return nil }
+func (t *testHandler) GetEventStrings() *[]string                 { return nil }
+func (t *testHandler) GetHistoryConfig(_ interface{}) error       { return nil }
+func (t *testHandler) GetBotInfo() robot.BotInfo                  { return robot.BotInfo{} }
+func (t *testHandler) SetBotID(id string)                         { t.botID = id }
+func (t *testHandler) SetTerminalWriter(_ io.Writer)              {}
+func (t *testHandler) SetBotMention(m string)                     { t.botMention = m }
+func (t *testHandler) GetLogLevel() robot.LogLevel                { return robot.Info }
+func (t *testHandler) GetInstallPath() string                     { return "" }
+func (t *testHandler) GetConfigPath() string                      { return "" }
+func (t *testHandler) ReadEncryptedFile(_ string) ([]byte, error) { return nil, nil }
+func (t *testHandler) Log(_ robot.LogLevel, _ string, _ ...interface{}) {
+}
+func (t *testHandler) GetDirectory(_ string) error { return nil }
+
+// fakeServer is a stand-in for the Rocket.Chat REST API and DDP websocket.
+type fakeServer struct {
+	*httptest.Server
+	sync.Mutex
+	events []string
+	sent   []outgoingMessage
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	fs := &fakeServer{}
+	users := map[string]User{
+		"bot1":   {ID: "bot1", Username: "floyd", Name: "Floyd"},
+		"alice1": {ID: "alice1", Username: "alice.smith", Name: "Alice Smith", Emails: []Email{{Address: "alice@example.com"}}},
+	}
+	rooms := map[string]Room{
+		"chan1": {ID: "chan1", Name: "general", Type: "c"},
+	}
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	badRequest := func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]interface{}{"success": false, "error": "not found"})
+	}
+	upgrader := websocket.Upgrader{}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/websocket" {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			fs.serveDDP(conn)
+			return
+		}
+		if r.Header.Get("X-User-Id") != "bot1" || r.Header.Get("X-Auth-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		switch strings.TrimPrefix(r.URL.Path, "/api/v1/") {
+		case "me":
+			writeJSON(w, users["bot1"])
+		case "users.info":
+			for _, u := range users {
+				if u.ID == q.Get("userId") || (q.Get("username") != "" && u.Username == q.Get("username")) {
+					writeJSON(w, map[string]interface{}{"user": u})
+					return
+				}
+			}
+			badRequest(w)
+		case "rooms.info":
+			for _, room := range rooms {
+				if room.ID == q.Get("roomId") || (q.Get("roomName") != "" && room.Name == q.Get("roomName")) {
+					writeJSON(w, map[string]interface{}{"room": room})
+					return
+				}
+			}
+			badRequest(w)
+		case "im.create":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			writeJSON(w, map[string]interface{}{"room": Room{RID: "dm-" + req["username"], Type: "d"}})
+		case "channels.join":
+			writeJSON(w, map[string]interface{}{"success": true})
+		case "chat.sendMessage":
+			var req struct {
+				Message outgoingMessage `json:"message"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			fs.Lock()
+			fs.sent = append(fs.sent, req.Message)
+			fs.Unlock()
+			writeJSON(w, map[string]interface{}{"success": true})
+		default:
+			badRequest(w)
+		}
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+// serveDDP answers connect, login and the message subscription, then sends
+// the queued events.
+func (fs *fakeServer) serveDDP(conn *websocket.Conn) {
+	for {
+		var msg struct {
+			Msg    string            `json:"msg"`
+			ID     string            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch {
+		case msg.Msg == "connect":
+			conn.WriteJSON(map[string]string{"msg": "connected", "session": "s1"})
+		case msg.Msg == "method" && msg.Method == "login":
+			var params struct {
+				Resume string `json:"resume"`
+			}
+			json.Unmarshal(msg.Params[0], &params)
+			if params.Resume != "secret" {
+				conn.WriteJSON(map[string]interface{}{"msg": "result", "id": msg.ID, "error": map[string]interface{}{"error": 403, "reason": "You've been logged out by the server. Please log in again."}})
+				continue
+			}
+			conn.WriteJSON(map[string]interface{}{"msg": "result", "id": msg.ID, "result": map[string]string{"id": "bot1"}})
+		case msg.Msg == "sub":
+			conn.WriteJSON(map[string]interface{}{"msg": "ready", "subs": []string{msg.ID}})
+			fs.Lock()
+			events := fs.events
+			fs.Unlock()
+			for _, evt := range events {
+				conn.WriteMessage(websocket.TextMessage, []byte(evt))
+			}
+		}
+	}
+}
+
+func changedEvent(t *testing.T, msg Message, roomType, roomName string) string {
+	t.Helper()
+	evt, err := json.Marshal(map[string]interface{}{
+		"msg":        "changed",
+		"collection": "stream-room-messages",
+		"id":         "id",
+		"fields": map[string]interface{}{
+			"eventName": "__my_messages__",
+			"args":      []interface{}{msg, roomContext{RoomType: roomType, RoomName: roomName}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+	return string(evt)
+}
+
+func TestInitializeIdentifiesBot(t *testing.T) {
+	fs := newFakeServer(t)
+	h := &testHandler{protocolConfig: &config{ServerURL: fs.URL + "/", UserID: "bot1", Token: "secret"}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	rc := ic.Connector.(*rocketConnector)
+	if h.botID != "bot1" || h.botMention != "floyd" || rc.botName != "floyd" {
+		t.Fatalf("bot ID %q, mention %q", h.botID, h.botMention)
+	}
+	h.protocolConfig.Token = "wrong"
+	if ic := Initialize(h, nil); ic.Error == nil {
+		t.Fatal("Initialize accepted a rejected token")
+	}
+	h.protocolConfig.UserID = ""
+	if ic := Initialize(h, nil); ic.Error == nil {
+		t.Fatal("Initialize accepted an empty UserID")
+	}
+}
+
+func TestRunDeliversThreadedAndDirectMessages(t *testing.T) {
+	fs := newFakeServer(t)
+	threaded := Message{ID: "m1", RoomID: "chan1", ThreadID: "root1", Text: "floyd ping", User: MessageUser{ID: "alice1", Username: "alice.smith"}}
+	fs.events = []string{
+		changedEvent(t, Message{ID: "m0", RoomID: "chan1", Type: "uj", User: MessageUser{ID: "alice1", Username: "alice.smith"}}, "c", "general"),
+		changedEvent(t, threaded, "c", "general"),
+		// A thread reply re-broadcasts its parent; it must not be heard twice.
+		changedEvent(t, threaded, "c", "general"),
+		changedEvent(t, Message{ID: "m2", RoomID: "dm1", Text: "help", User: MessageUser{ID: "alice1", Username: "alice.smith"}}, "d", ""),
+		changedEvent(t, Message{ID: "m3", RoomID: "chan1", Text: "pong", User: MessageUser{ID: "bot1", Username: "floyd"}}, "", ""),
+	}
+	h := &testHandler{
+		protocolConfig: &config{ServerURL: fs.URL, UserID: "bot1", Token: "secret", UserMap: map[string]string{"alice": "alice1"}},
+		incoming:       make(chan *robot.ConnectorMessage, 4),
+	}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- ic.Connector.Run(stop) }()
+
+	var got []*robot.ConnectorMessage
+	for len(got) < 3 {
+		select {
+		case m := <-h.incoming:
+			got = append(got, m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d messages, want 3", len(got))
+		}
+	}
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned %v after stop", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after stop")
+	}
+	if len(h.incoming) != 0 {
+		t.Fatalf("%d unexpected extra messages", len(h.incoming))
+	}
+
+	thread, direct, self := got[0], got[1], got[2]
+	if thread.MessageID != "m1" || thread.ThreadID != "root1" || !thread.ThreadedMessage || thread.ChannelName != "general" || thread.DirectMessage {
+		t.Fatalf("threaded message = %+v", thread)
+	}
+	if thread.UserName != "alice" || !thread.ValidatedUser || thread.Protocol != "rocket" {
+		t.Fatalf("threaded message user = %q validated %t protocol %q", thread.UserName, thread.ValidatedUser, thread.Protocol)
+	}
+	if !direct.DirectMessage || direct.ChannelName != "" || direct.ThreadID != "m2" || direct.ThreadedMessage {
+		t.Fatalf("direct message = %+v", direct)
+	}
+	// The room context was empty, so the room was looked up over REST.
+	if !self.SelfMessage || self.UserName != "floyd" || self.ValidatedUser || self.ChannelName != "general" {
+		t.Fatalf("self message = %+v", self)
+	}
+}
+
+func TestRunRejectsBadToken(t *testing.T) {
+	fs := newFakeServer(t)
+	rc, err := newConnector(&testHandler{}, config{ServerURL: fs.URL, UserID: "bot1", Token: "wrong"})
+	if err != nil {
+		t.Fatalf("newConnector: %v", err)
+	}
+	if err := rc.Run(make(chan struct{})); !errors.Is(err, errUnauthorized) {
+		t.Fatalf("Run with a bad token = %v, want errUnauthorized", err)
+	}
+}
+
+func TestSendsUseThreadIDAndDirectRooms(t *testing.T) {
+	fs := newFakeServer(t)
+	h := &testHandler{protocolConfig: &config{ServerURL: fs.URL, UserID: "bot1", Token: "secret", UserMap: map[string]string{"alice": "alice1"}}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	rc := ic.Connector.(*rocketConnector)
+
+	if ret := rc.SendProtocolUserChannelThreadMessage("", "alice", "general", "root1", "done, @alice", robot.BasicMarkdown, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolUserChannelThreadMessage = %v", ret)
+	}
+	if ret := rc.SendProtocolUserMessage("alice", "psst", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("SendProtocolUserMessage = %v", ret)
+	}
+	if ret := rc.SendProtocolChannelThreadMessage("missing", "", "hi", robot.Raw, nil); ret != robot.ChannelNotFound {
+		t.Fatalf("send to unknown channel = %v, want ChannelNotFound", ret)
+	}
+	if ret := rc.JoinChannel("general"); ret != robot.Ok {
+		t.Fatalf("JoinChannel = %v", ret)
+	}
+
+	want := []outgoingMessage{
+		{RoomID: "chan1", ThreadID: "root1", Text: "@alice.smith: done, @alice.smith"},
+		{RoomID: "dm-alice.smith", Text: "psst"},
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	if len(fs.sent) != len(want) {
+		t.Fatalf("sent = %+v, want %+v", fs.sent, want)
+	}
+	for i := range want {
+		if fs.sent[i] != want[i] {
+			t.Fatalf("message %d = %+v, want %+v", i, fs.sent[i], want[i])
+		}
+	}
+	if v, ret := rc.GetProtocolUserAttribute("alice", "email"); ret != robot.Ok || v != "alice@example.com" {
+		t.Fatalf("email attribute = %q, %v", v, ret)
+	}
+	if v, ret := rc.GetProtocolUserAttribute("<alice1>", "fullname"); ret != robot.Ok || v != "Alice Smith" {
+		t.Fatalf("fullname attribute = %q, %v", v, ret)
+	}
+	if _, ret := rc.GetProtocolUserAttribute("nobody", "email"); ret != robot.UserNotFound {
+		t.Fatalf("unknown user attribute = %v, want UserNotFound", ret)
+	}
+}
+
+func TestReloadSwapsConfiguredUserMap(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{UserMap: map[string]string{"bob": "b2", "Carol": "c3"}}}
+	rc := &rocketConnector{Handler: h, botUserMap: map[string]string{"alice": "a1"}}
+	if err := rc.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(rc.botUserMap) != 1 || rc.botUserMap["bob"] != "b2" {
+		t.Fatalf("botUserMap after reload = %v, want only bob", rc.botUserMap)
+	}
+	if _, ok := rc.configuredCanonicalUser("a1"); ok {
+		t.Fatal("removed mapping still validates")
+	}
+}
@@ -0,0 +1,154 @@
+package rocket
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	ddpLoginID       = "gopherbot-login"
+	ddpMessagesSubID = "gopherbot-messages"
+)
+
+// Thread replies re-broadcast their parent with new counters, so message
+// IDs are remembered for a while to keep the parent from being heard twice.
+const (
+	seenMessageWindow = 10 * time.Minute
+	seenMessageLimit  = 2000
+)
+
+type ddpMessage struct {
+	Msg        string          `json:"msg"`
+	ID         string          `json:"id,omitempty"`
+	Collection string          `json:"collection,omitempty"`
+	Fields     json.RawMessage `json:"fields,omitempty"`
+	Error      *ddpError       `json:"error,omitempty"`
+}
+
+type ddpError struct {
+	Code    json.RawMessage `json:"error"`
+	Reason  string          `json:"reason"`
+	Message string          `json:"message"`
+}
+
+func (e *ddpError) String() string {
+	if e == nil {
+		return ""
+	}
+	if e.Reason != "" {
+		return e.Reason
+	}
+	if e.Message != "" {
+		return e.Message
+	}
+	return strings.Trim(string(e.Code), `"`)
+}
+
+type streamFields struct {
+	EventName string            `json:"eventName"`
+	Args      []json.RawMessage `json:"args"`
+}
+
+// roomContext is the second argument of a __my_messages__ event.
+type roomContext struct {
+	RoomType string `json:"roomType"`
+	RoomName string `json:"roomName"`
+}
+
+func (rc *rocketConnector) handleStreamMessage(fields json.RawMessage) {
+	var stream streamFields
+	if err := json.Unmarshal(fields, &stream); err != nil || len(stream.Args) == 0 {
+		rc.Log(robot.Warn, "Ignoring malformed Rocket.Chat message event")
+		return
+	}
+	var msg Message
+	if err := json.Unmarshal(stream.Args[0], &msg); err != nil {
+		rc.Log(robot.Warn, "Ignoring Rocket.Chat message event with malformed message: %v", err)
+		return
+	}
+	var room roomContext
+	if len(stream.Args) > 1 {
+		_ = json.Unmarshal(stream.Args[1], &room)
+	}
+	rc.processMessage(&msg, room)
+}
+
+// firstSighting records id and reports whether it's new.
+func (rc *rocketConnector) firstSighting(id string, now time.Time) bool {
+	rc.seenLock.Lock()
+	defer rc.seenLock.Unlock()
+	if _, seen := rc.seenMessages[id]; seen {
+		return false
+	}
+	if len(rc.seenMessages) >= seenMessageLimit {
+		for seenID, at := range rc.seenMessages {
+			if now.Sub(at) > seenMessageWindow {
+				delete(rc.seenMessages, seenID)
+			}
+		}
+	}
+	rc.seenMessages[id] = now
+	return true
+}
+
+// processMessage turns a new chat message into a ConnectorMessage.
+func (rc *rocketConnector) processMessage(msg *Message, room roomContext) {
+	if msg.Type != "" {
+		rc.Log(robot.Debug, "Ignoring Rocket.Chat system message of type '%s'", msg.Type)
+		return
+	}
+	if msg.EditedAt != nil {
+		rc.Log(robot.Debug, "Ignoring edited Rocket.Chat message '%s'", msg.ID)
+		return
+	}
+	if msg.ID == "" || msg.RoomID == "" || msg.User.ID == "" {
+		rc.Log(robot.Debug, "Ignoring Rocket.Chat message without an ID, room or user")
+		return
+	}
+	if !rc.firstSighting(msg.ID, time.Now()) {
+		return
+	}
+	roomType, roomName := room.RoomType, room.RoomName
+	if roomType == "" {
+		if r, ok := rc.roomByID(msg.RoomID); ok {
+			roomType, roomName = r.Type, r.Name
+		}
+	}
+	threadID := msg.ID
+	threaded := false
+	if msg.ThreadID != "" {
+		threadID = msg.ThreadID
+		threaded = true
+	}
+	direct := roomType == "d"
+	botMsg := &robot.ConnectorMessage{
+		Protocol:        "rocket",
+		UserID:          msg.User.ID,
+		ChannelID:       msg.RoomID,
+		MessageID:       msg.ID,
+		ThreadID:        threadID,
+		ThreadedMessage: threaded,
+		DirectMessage:   direct,
+		MessageText:     msg.Text,
+		MessageObject:   msg,
+		Client:          rc.api,
+	}
+	if !direct {
+		botMsg.ChannelName = roomName
+	}
+	if validatedName, validated := rc.configuredCanonicalUser(msg.User.ID); validated {
+		botMsg.UserName = validatedName
+		botMsg.ValidatedUser = true
+	} else {
+		botMsg.UserName = msg.User.Username
+	}
+	rc.noteUser(msg.User)
+	if msg.User.ID == rc.botUserID {
+		botMsg.SelfMessage = true
+		rc.Log(robot.Trace, "Forwarding Rocket.Chat return message '%s' from the robot", msg.ID)
+	}
+	rc.IncomingMessage(botMsg)
+}
@@ -0,0 +1,7 @@
+package rocket
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterConnector("rocket", Initialize)
+}
@@ -0,0 +1,242 @@
+package teams
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// The parts of the Bot Framework activity schema the connector uses.
+
+type channelAccount struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	AADObjectID string `json:"aadObjectId,omitempty"`
+}
+
+type conversationAccount struct {
+	ID               string `json:"id"`
+	Name             string `json:"name,omitempty"`
+	ConversationType string `json:"conversationType,omitempty"` // personal, groupChat or channel
+	TenantID         string `json:"tenantId,omitempty"`
+}
+
+type entity struct {
+	Type      string          `json:"type"`
+	Mentioned *channelAccount `json:"mentioned,omitempty"`
+	Text      string          `json:"text,omitempty"`
+}
+
+type idName struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type teamsChannelData struct {
+	Channel *idName `json:"channel,omitempty"`
+	Team    *idName `json:"team,omitempty"`
+	Tenant  *idName `json:"tenant,omitempty"`
+}
+
+type attachment struct {
+	ContentType string      `json:"contentType"`
+	Content     interface{} `json:"content"`
+}
+
+type activity struct {
+	Type         string               `json:"type"`
+	ID           string               `json:"id,omitempty"`
+	ServiceURL   string               `json:"serviceUrl,omitempty"`
+	ChannelID    string               `json:"channelId,omitempty"`
+	From         *channelAccount      `json:"from,omitempty"`
+	Conversation *conversationAccount `json:"conversation,omitempty"`
+	Recipient    *channelAccount      `json:"recipient,omitempty"`
+	Text         string               `json:"text,omitempty"`
+	TextFormat   string               `json:"textFormat,omitempty"`
+	Summary      string               `json:"summary,omitempty"`
+	ReplyToID    string               `json:"replyToId,omitempty"`
+	Entities     []entity             `json:"entities,omitempty"`
+	Attachments  []attachment         `json:"attachments,omitempty"`
+	ChannelData  *teamsChannelData    `json:"channelData,omitempty"`
+}
+
+func (a *activity) tenantID() string {
+	if a.ChannelData != nil && a.ChannelData.Tenant != nil && a.ChannelData.Tenant.ID != "" {
+		return a.ChannelData.Tenant.ID
+	}
+	if a.Conversation != nil {
+		return a.Conversation.TenantID
+	}
+	return ""
+}
+
+// splitConversationID splits a channel reply chain ID,
+// "19:...@thread.tacv2;messageid=<root>", into the channel ID and the
+// thread's root message ID.
+func splitConversationID(id string) (channelID, root string) {
+	channelID, root, _ = strings.Cut(id, ";messageid=")
+	return channelID, root
+}
+
+// isTeamChannel reports whether a conversation ID is a team channel, where
+// every top-level post starts a thread, rather than a group chat.
+func isTeamChannel(id string) bool {
+	return strings.HasSuffix(id, "@thread.tacv2") || strings.HasSuffix(id, "@thread.skype")
+}
+
+var atTag = regexp.MustCompile(`(?s)<at[^>]*>(.*?)</at>`)
+
+// messageText returns the text of a message with the robot's mention
+// removed, and whether the robot was mentioned.
+func messageText(a *activity, botID string) (string, bool) {
+	text := a.Text
+	mentioned := false
+	for _, e := range a.Entities {
+		if e.Type != "mention" || e.Mentioned == nil || e.Mentioned.ID != botID || e.Text == "" {
+			continue
+		}
+		if strings.Contains(text, e.Text) {
+			text = strings.ReplaceAll(text, e.Text, "")
+			mentioned = true
+		}
+	}
+	// Other mentions keep the name.
+	text = atTag.ReplaceAllString(text, "$1")
+	text = html.UnescapeString(text)
+	text = strings.ReplaceAll(text, "\u00a0", " ")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.TrimSpace(text), mentioned
+}
+
+const (
+	adaptiveCardType    = "application/vnd.microsoft.card.adaptive"
+	adaptiveCardSchema  = "http://adaptivecards.io/schemas/adaptive-card.json"
+	adaptiveCardVersion = "1.4"
+	truncatedMessage    = "(message too long, truncated)"
+	summaryLength       = 80
+)
+
+// renderMessage turns one part of a robot message into an activity.
+// Fixed and Variable text goes in an Adaptive Card built from TextRuns,
+// which Teams shows as typed, without markdown; Fixed is monospace.
+func renderMessage(msg string, f robot.MessageFormat, mention *channelAccount) *activity {
+	a := &activity{Type: "message"}
+	var mentionText string
+	if mention != nil {
+		m := *mention
+		if m.Name == "" {
+			m.Name = m.ID
+		}
+		mentionText = "<at>" + html.EscapeString(m.Name) + "</at>"
+		a.Entities = []entity{{Type: "mention", Mentioned: &m, Text: mentionText}}
+	}
+	switch f {
+	case robot.Fixed, robot.Variable:
+		var body []interface{}
+		if mentionText != "" {
+			body = append(body, map[string]interface{}{"type": "TextBlock", "text": mentionText, "wrap": true})
+		}
+		for _, line := range strings.Split(msg, "\n") {
+			body = append(body, textRunBlock(line, f == robot.Fixed))
+		}
+		card := map[string]interface{}{
+			"type":    "AdaptiveCard",
+			"$schema": adaptiveCardSchema,
+			"version": adaptiveCardVersion,
+			"body":    body,
+			"msteams": map[string]interface{}{"width": "Full"},
+		}
+		if mention != nil {
+			card["msteams"].(map[string]interface{})["entities"] = a.Entities
+			a.Entities = nil
+		}
+		a.Attachments = []attachment{{ContentType: adaptiveCardType, Content: card}}
+		a.Summary = summary(msg)
+	case robot.BasicMarkdown:
+		a.TextFormat = "markdown"
+		a.Text = joinMention(mentionText, msg)
+	default:
+		a.TextFormat = "plain"
+		a.Text = joinMention(mentionText, msg)
+	}
+	return a
+}
+
+func joinMention(mentionText, msg string) string {
+	if mentionText == "" {
+		return msg
+	}
+	return mentionText + " " + msg
+}
+
+// textRunBlock is one line of text; leading spaces become no-break spaces
+// so indentation survives.
+func textRunBlock(line string, monospace bool) map[string]interface{} {
+	trimmed := strings.TrimLeft(line, " ")
+	line = strings.Repeat("\u00a0", len(line)-len(trimmed)) + trimmed
+	if line == "" {
+		line = "\u00a0"
+	}
+	run := map[string]interface{}{"type": "TextRun", "text": line}
+	if monospace {
+		run["fontType"] = "Monospace"
+	}
+	return map[string]interface{}{
+		"type":    "RichTextBlock",
+		"spacing": "None",
+		"inlines": []interface{}{run},
+	}
+}
+
+// summary is the notification preview of a card message.
+func summary(msg string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	if len(line) > summaryLength {
+		cut := summaryLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		line = line[:cut] + "..."
+	}
+	return line
+}
+
+// splitMessage splits msg at line breaks into at most maxSplit parts of
+// at most maxLen runes, truncating the last part if needed.
+func splitMessage(msg string, maxLen, maxSplit int) []string {
+	if utf8.RuneCountInString(msg) <= maxLen {
+		return []string{msg}
+	}
+	limit := max(maxLen-len(truncatedMessage)-1, 1)
+	parts := make([]string, 0, maxSplit)
+	for msg != "" {
+		if utf8.RuneCountInString(msg) <= maxLen {
+			parts = append(parts, msg)
+			break
+		}
+		cut := byteIndexForRunes(msg, limit)
+		if nl := strings.LastIndexByte(msg[:cut], '\n'); nl > 0 {
+			cut = nl
+		}
+		if len(parts) == maxSplit-1 {
+			parts = append(parts, msg[:cut]+"\n"+truncatedMessage)
+			break
+		}
+		parts = append(parts, msg[:cut])
+		msg = strings.TrimPrefix(msg[cut:], "\n")
+	}
+	return parts
+}
+
+func byteIndexForRunes(msg string, runes int) int {
+	for i := range msg {
+		if runes == 0 {
+			return i
+		}
+		runes--
+	}
+	return len(msg)
+}
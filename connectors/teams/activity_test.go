@@ -0,0 +1,74 @@
+package teams
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestMessageTextStripsRobotMention(t *testing.T) {
+	bot := "28:app"
+	a := &activity{
+		Text: "<at>Floyd</at>&nbsp;ping <at>Bob Jones</at>\r\nplease",
+		Entities: []entity{
+			{Type: "mention", Mentioned: &channelAccount{ID: bot}, Text: "<at>Floyd</at>"},
+			{Type: "mention", Mentioned: &channelAccount{ID: "29:bob"}, Text: "<at>Bob Jones</at>"},
+		},
+	}
+	text, mentioned := messageText(a, bot)
+	if text != "ping Bob Jones\nplease" || !mentioned {
+		t.Fatalf("messageText = %q, %v", text, mentioned)
+	}
+	a.Entities = a.Entities[1:]
+	if _, mentioned := messageText(a, bot); mentioned {
+		t.Fatal("robot reported mentioned without a mention entity")
+	}
+}
+
+func TestSplitConversationID(t *testing.T) {
+	ch, root := splitConversationID("19:abc@thread.tacv2;messageid=1700000000000")
+	if ch != "19:abc@thread.tacv2" || root != "1700000000000" {
+		t.Fatalf("split = %q, %q", ch, root)
+	}
+	if !isTeamChannel(ch) || isTeamChannel("19:chat@thread.v2") || isTeamChannel("a:dm") {
+		t.Fatal("isTeamChannel misclassified a conversation")
+	}
+}
+
+func TestRenderMessageFormats(t *testing.T) {
+	a := renderMessage("**done**", robot.BasicMarkdown, &channelAccount{ID: "29:alice", Name: "A&B"})
+	if a.TextFormat != "markdown" || a.Text != "<at>A&amp;B</at> **done**" || len(a.Entities) != 1 {
+		t.Fatalf("markdown activity = %+v", a)
+	}
+	a = renderMessage("*not bold*\n\nnext", robot.Variable, &channelAccount{ID: "29:alice"})
+	card := a.Attachments[0].Content.(map[string]interface{})
+	body := card["body"].([]interface{})
+	if len(body) != 4 || a.Summary != "*not bold*" || a.Entities != nil {
+		t.Fatalf("variable card = %+v", a)
+	}
+	if mention := body[0].(map[string]interface{}); mention["text"] != "<at>29:alice</at>" {
+		t.Fatalf("mention block = %v", mention)
+	}
+	run := body[1].(map[string]interface{})["inlines"].([]interface{})[0].(map[string]interface{})
+	if run["text"] != "*not bold*" || run["fontType"] != nil {
+		t.Fatalf("variable run = %v", run)
+	}
+	if entities := card["msteams"].(map[string]interface{})["entities"]; entities == nil {
+		t.Fatal("card mention has no entity")
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	msg := strings.Repeat("line of text\n", 10)
+	parts := splitMessage(msg, 50, 2)
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], truncatedMessage) {
+		t.Fatalf("parts = %q", parts)
+	}
+	if !strings.HasSuffix(parts[0], "text") || !strings.HasPrefix(parts[1], "line") {
+		t.Fatalf("split not at a line break: %q", parts)
+	}
+	if parts := splitMessage("short", 50, 2); len(parts) != 1 {
+		t.Fatalf("short message split: %q", parts)
+	}
+}
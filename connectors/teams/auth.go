@@ -0,0 +1,275 @@
+package teams
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bot Framework authentication: incoming activities carry a JWT signed with
+// a key from the configured JWKS, and outgoing REST calls use an OAuth2
+// client-credentials token for the app.
+
+const (
+	keyRefreshInterval = 24 * time.Hour
+	minKeyRefetch      = 5 * time.Minute
+	maxClockSkew       = 5 * time.Minute
+	tokenEarlyRefresh  = 5 * time.Minute
+	botFrameworkScope  = "https://api.botframework.com/.default"
+)
+
+var (
+	errUnauthenticated = errors.New("missing or invalid Bot Framework token")
+	errUnauthorized    = errors.New("Microsoft identity platform rejected the app credentials")
+)
+
+// jsonWebKey is one RSA key from the JWKS document; endorsements list the
+// channels (e.g. "msteams") the key may sign for.
+type jsonWebKey struct {
+	Kty          string   `json:"kty"`
+	Kid          string   `json:"kid"`
+	N            string   `json:"n"`
+	E            string   `json:"e"`
+	Endorsements []string `json:"endorsements"`
+}
+
+type verifyKey struct {
+	pub          *rsa.PublicKey
+	endorsements []string
+}
+
+// keySet caches the signing keys, refetching them daily or when a token
+// names an unknown key.
+type keySet struct {
+	sync.Mutex
+	url     string
+	client  *http.Client
+	keys    map[string]verifyKey
+	fetched time.Time
+	now     func() time.Time
+}
+
+func (ks *keySet) key(kid string) (verifyKey, error) {
+	ks.Lock()
+	defer ks.Unlock()
+	k, ok := ks.keys[kid]
+	age := ks.now().Sub(ks.fetched)
+	if ok && age < keyRefreshInterval {
+		return k, nil
+	}
+	if !ok && ks.keys != nil && age < minKeyRefetch {
+		return verifyKey{}, fmt.Errorf("%w: unknown signing key %q", errUnauthenticated, kid)
+	}
+	if err := ks.refresh(); err != nil {
+		if ok {
+			// Keep using a cached key until the endpoint is back.
+			return k, nil
+		}
+		return verifyKey{}, err
+	}
+	if k, ok = ks.keys[kid]; !ok {
+		return verifyKey{}, fmt.Errorf("%w: unknown signing key %q", errUnauthenticated, kid)
+	}
+	return k, nil
+}
+
+func (ks *keySet) refresh() error {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return fmt.Errorf("fetching signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching signing keys from %s: %s", ks.url, resp.Status)
+	}
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decoding signing keys: %w", err)
+	}
+	keys := make(map[string]verifyKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || k.Kid == "" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		keys[k.Kid] = verifyKey{pub: pub, endorsements: k.Endorsements}
+	}
+	ks.keys = keys
+	ks.fetched = ks.now()
+	return nil
+}
+
+// audience is a JWT "aud" claim, a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+type tokenClaims struct {
+	Issuer     string   `json:"iss"`
+	Audience   audience `json:"aud"`
+	Expires    int64    `json:"exp"`
+	NotBefore  int64    `json:"nbf"`
+	ServiceURL string   `json:"serviceurl"`
+}
+
+// verifyToken checks the Authorization header of an incoming activity:
+// an RS256 JWT from the trusted issuer, for this app, signed by a key
+// endorsed for the activity's channel, vouching for its service URL.
+func (tc *teamsConnector) verifyToken(authorization, serviceURL, channelID string) error {
+	raw, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return fmt.Errorf("%w: no bearer token", errUnauthenticated)
+	}
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: malformed token", errUnauthenticated)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Alg != "RS256" {
+		return fmt.Errorf("%w: unexpected signing algorithm %q", errUnauthenticated, header.Alg)
+	}
+	key, err := tc.keys.key(header.Kid)
+	if err != nil {
+		return err
+	}
+	if len(key.endorsements) > 0 && !slices.Contains(key.endorsements, channelID) {
+		return fmt.Errorf("%w: key %q isn't endorsed for channel %q", errUnauthenticated, header.Kid, channelID)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", errUnauthenticated)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key.pub, crypto.SHA256, digest[:], sig); err != nil {
+		return fmt.Errorf("%w: bad signature", errUnauthenticated)
+	}
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return err
+	}
+	now := tc.now()
+	switch {
+	case claims.Issuer != tc.issuer:
+		return fmt.Errorf("%w: unexpected issuer %q", errUnauthenticated, claims.Issuer)
+	case !slices.Contains(claims.Audience, tc.appID):
+		return fmt.Errorf("%w: token isn't for this app", errUnauthenticated)
+	case claims.Expires == 0 || now.After(time.Unix(claims.Expires, 0).Add(maxClockSkew)):
+		return fmt.Errorf("%w: token expired", errUnauthenticated)
+	case claims.NotBefore != 0 && now.Add(maxClockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return fmt.Errorf("%w: token not yet valid", errUnauthenticated)
+	case strings.TrimSuffix(claims.ServiceURL, "/") != strings.TrimSuffix(serviceURL, "/"):
+		return fmt.Errorf("%w: token service URL %q doesn't match activity %q", errUnauthenticated, claims.ServiceURL, serviceURL)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", errUnauthenticated)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: malformed token", errUnauthenticated)
+	}
+	return nil
+}
+
+// tokenSource gets and caches the app's access token for the connector
+// REST API.
+type tokenSource struct {
+	sync.Mutex
+	url          string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	token        string
+	expires      time.Time
+	now          func() time.Time
+}
+
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	ts.Lock()
+	defer ts.Unlock()
+	if ts.token != "" && ts.now().Before(ts.expires) {
+		return ts.token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {ts.clientID},
+		"client_secret": {ts.clientSecret},
+		"scope":         {botFrameworkScope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting access token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("%w: %s", errUnauthorized, bytes.TrimSpace(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("requesting access token: %s", resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.AccessToken == "" {
+		return "", fmt.Errorf("decoding access token response: %v", err)
+	}
+	ts.token = tok.AccessToken
+	ts.expires = ts.now().Add(time.Duration(tok.ExpiresIn)*time.Second - tokenEarlyRefresh)
+	return ts.token, nil
+}
+
+// invalidate drops a token the service refused.
+func (ts *tokenSource) invalidate() {
+	ts.Lock()
+	ts.token = ""
+	ts.Unlock()
+}
@@ -0,0 +1,164 @@
+package teams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Bot Connector REST API, at the service URL of the conversation.
+
+// apiError is a failed REST call.
+type apiError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("bot connector API returned %d: %s", e.Status, e.Body)
+}
+
+func apiErrorStatus(err error) int {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		return ae.Status
+	}
+	return 0
+}
+
+// conversationParameters creates a DM with a user, or a new post in a
+// team channel.
+type conversationParameters struct {
+	IsGroup     bool              `json:"isGroup"`
+	Bot         *channelAccount   `json:"bot,omitempty"`
+	Members     []channelAccount  `json:"members,omitempty"`
+	TenantID    string            `json:"tenantId,omitempty"`
+	Activity    *activity         `json:"activity,omitempty"`
+	ChannelData *teamsChannelData `json:"channelData,omitempty"`
+}
+
+type conversationResource struct {
+	ID         string `json:"id"`
+	ActivityID string `json:"activityId"`
+}
+
+type resourceResponse struct {
+	ID string `json:"id"`
+}
+
+// teamsMember is a conversation member with the profile fields Teams adds.
+type teamsMember struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	AADObjectID       string `json:"aadObjectId"`
+	Email             string `json:"email"`
+	UserPrincipalName string `json:"userPrincipalName"`
+	GivenName         string `json:"givenName"`
+	Surname           string `json:"surname"`
+}
+
+// call makes one REST call, retrying throttling and server errors, and
+// getting a new token once if the service refuses the cached one.
+func (tc *teamsConnector) call(method, serviceURL, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	endpoint := strings.TrimSuffix(serviceURL, "/") + path
+	delay := time.Second
+	refreshed := false
+	var err error
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = tc.callOnce(method, endpoint, body, out)
+		status := apiErrorStatus(err)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, errUnauthorized):
+			return err
+		case status == http.StatusUnauthorized && !refreshed:
+			tc.tokens.invalidate()
+			refreshed = true
+			attempt--
+			continue
+		case status != 0 && status != http.StatusTooManyRequests && status < 500:
+			return err
+		}
+		if attempt < sendAttempts {
+			tc.retrySleep(max(delay, retryAfter))
+			delay *= 2
+		}
+	}
+	return err
+}
+
+func (tc *teamsConnector) callOnce(method, endpoint string, body []byte, out interface{}) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	token, err := tc.tokens.Token(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := tc.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		var retryAfter time.Duration
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return retryAfter, &apiError{Status: resp.StatusCode, Body: strings.TrimSpace(string(respBody))}
+	}
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return 0, fmt.Errorf("decoding bot connector response: %w", err)
+		}
+	}
+	return 0, nil
+}
+
+func (tc *teamsConnector) sendToConversation(serviceURL, conversationID string, a *activity) (string, error) {
+	var res resourceResponse
+	err := tc.call(http.MethodPost, serviceURL, "/v3/conversations/"+url.PathEscape(conversationID)+"/activities", a, &res)
+	return res.ID, err
+}
+
+func (tc *teamsConnector) createConversation(serviceURL string, params *conversationParameters) (conversationResource, error) {
+	var res conversationResource
+	err := tc.call(http.MethodPost, serviceURL, "/v3/conversations", params, &res)
+	return res, err
+}
+
+func (tc *teamsConnector) getMember(serviceURL, conversationID, userID string) (teamsMember, error) {
+	var m teamsMember
+	path := "/v3/conversations/" + url.PathEscape(conversationID) + "/members/" + url.PathEscape(userID)
+	err := tc.call(http.MethodGet, serviceURL, path, nil, &m)
+	return m, err
+}
@@ -0,0 +1,530 @@
+// Package teams implements the robot.Connector interface for Microsoft
+// Teams, receiving Bot Framework activities on an HTTP endpoint and
+// replying through the Bot Connector REST API.
+package teams
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	defaultListenHost       = "127.0.0.1"
+	defaultListenPort       = 3978
+	defaultPath             = "/api/messages"
+	defaultMaxBodyBytes     = 256 * 1024
+	defaultIssuer           = "https://api.botframework.com"
+	defaultJWKSURL          = "https://login.botframework.com/v1/.well-known/keys"
+	defaultTokenTenant      = "botframework.com"
+	defaultMaxMessageLength = 20000 // Teams refuses messages over about 28KB
+	defaultMaxMessageSplit  = 2
+	apiTimeout              = 30 * time.Second
+	sendAttempts            = 3
+	shutdownTimeout         = 5 * time.Second
+)
+
+type config struct {
+	AppID            string // the bot's Microsoft app ID
+	AppPassword      string // the app's client secret
+	TenantID         string // for single-tenant apps and proactive messages
+	ListenHost       string
+	ListenPort       int
+	Path             string
+	MaxBodyBytes     int64
+	ServiceURL       string            // for proactive messages before any activity arrives
+	Issuer           string            // expected "iss" of incoming tokens
+	JWKSURL          string            // keys that sign incoming tokens
+	TokenURL         string            // OAuth2 token endpoint for outgoing calls
+	MaxMessageLength int               // characters per message
+	MaxMessageSplit  int               // the maximum number of messages for one long send
+	Channels         map[string]string // channel name to Teams channel ID
+	UserMap          map[string]string // username to Teams user ID or Entra object ID
+}
+
+// teamsUser is what the connector has learned about a user.
+type teamsUser struct {
+	id           string
+	aadObjectID  string
+	name         string
+	conversation string       // the last conversation the user was seen in
+	member       *teamsMember // profile, fetched on demand
+}
+
+type teamsConnector struct {
+	robot.Handler
+	sync.RWMutex                       // protects the fields and maps below
+	serviceURL       string            // default service URL, the last one seen
+	tenantID         string            // tenant for proactive conversations
+	channelMap       map[string]string // configured channel name to ID
+	userMap          map[string]string // configured username to user ID
+	channelNames     map[string]string // learned channel ID to name
+	serviceURLs      map[string]string // conversation or channel ID to service URL
+	users            map[string]*teamsUser
+	directChats      map[string]string // user ID to personal conversation ID
+	appID            string
+	botID            string // "28:" + appID
+	listenAddr       string
+	path             string
+	maxBodyBytes     int64
+	issuer           string
+	maxMessageLength int
+	maxMessageSplit  int
+
+	keys       *keySet
+	tokens     *tokenSource
+	httpClient *http.Client
+	sendLock   sync.Mutex // keeps outbound messages in order
+	retrySleep func(time.Duration)
+	now        func() time.Time
+}
+
+func normalizeMap(kind string, in map[string]string, h robot.Handler) map[string]string {
+	out := make(map[string]string, len(in))
+	for name, id := range in {
+		n := strings.TrimSpace(name)
+		v := strings.TrimSpace(id)
+		if n == "" || v == "" {
+			h.Log(robot.Warn, "Ignoring invalid Teams %s entry (empty name or ID): %q -> %q", kind, name, id)
+			continue
+		}
+		if kind == "UserMap" && strings.ToLower(n) != n {
+			h.Log(robot.Warn, "Ignoring Teams UserMap entry with uppercase username: %q", name)
+			continue
+		}
+		out[n] = v
+	}
+	return out
+}
+
+func newConnector(handler robot.Handler, c config) (*teamsConnector, error) {
+	appID := strings.TrimSpace(c.AppID)
+	if appID == "" || c.AppPassword == "" {
+		return nil, fmt.Errorf("Teams protocol config requires AppID and AppPassword")
+	}
+	if c.ListenHost == "" {
+		c.ListenHost = defaultListenHost
+	}
+	if c.ListenPort == 0 {
+		c.ListenPort = defaultListenPort
+	}
+	if c.Path == "" {
+		c.Path = defaultPath
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return nil, fmt.Errorf("Teams Path must start with '/', got %q", c.Path)
+	}
+	if c.MaxBodyBytes <= 0 {
+		c.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if c.Issuer == "" {
+		c.Issuer = defaultIssuer
+	}
+	if c.JWKSURL == "" {
+		c.JWKSURL = defaultJWKSURL
+	}
+	if c.TokenURL == "" {
+		tenant := c.TenantID
+		if tenant == "" {
+			tenant = defaultTokenTenant
+		}
+		c.TokenURL = "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/token"
+	}
+	if c.MaxMessageLength <= 0 {
+		c.MaxMessageLength = defaultMaxMessageLength
+	}
+	if c.MaxMessageSplit <= 0 {
+		c.MaxMessageSplit = defaultMaxMessageSplit
+	}
+	httpClient := &http.Client{Timeout: apiTimeout}
+	tc := &teamsConnector{
+		Handler:          handler,
+		serviceURL:       strings.TrimSpace(c.ServiceURL),
+		tenantID:         strings.TrimSpace(c.TenantID),
+		channelMap:       normalizeMap("Channels", c.Channels, handler),
+		userMap:          normalizeMap("UserMap", c.UserMap, handler),
+		channelNames:     make(map[string]string),
+		serviceURLs:      make(map[string]string),
+		users:            make(map[string]*teamsUser),
+		directChats:      make(map[string]string),
+		appID:            appID,
+		botID:            "28:" + appID,
+		listenAddr:       net.JoinHostPort(c.ListenHost, strconv.Itoa(c.ListenPort)),
+		path:             c.Path,
+		maxBodyBytes:     c.MaxBodyBytes,
+		issuer:           c.Issuer,
+		maxMessageLength: c.MaxMessageLength,
+		maxMessageSplit:  c.MaxMessageSplit,
+		httpClient:       httpClient,
+		retrySleep:       time.Sleep,
+		now:              time.Now,
+	}
+	tc.keys = &keySet{url: c.JWKSURL, client: httpClient, now: tc.clock}
+	tc.tokens = &tokenSource{url: c.TokenURL, clientID: appID, clientSecret: c.AppPassword, client: httpClient, now: tc.clock}
+	return tc, nil
+}
+
+// clock lets tests move time for the key and token caches too.
+func (tc *teamsConnector) clock() time.Time {
+	return tc.now()
+}
+
+// Initialize validates config and returns the connector; the listener
+// opens in Run.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to retrieve Teams protocol configuration: %w", err)}
+	}
+	tc, err := newConnector(handler, c)
+	if err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	handler.SetBotID(tc.botID)
+	handler.Log(robot.Info, "Teams connector using app ID '%s'", tc.appID)
+	return robot.InitializedConnector{Connector: tc}
+}
+
+// Reload swaps in the channel and user maps; credential and listener
+// changes need a restart.
+func (tc *teamsConnector) Reload() error {
+	var c config
+	if err := tc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Teams protocol configuration: %w", err)
+	}
+	channels := normalizeMap("Channels", c.Channels, tc.Handler)
+	users := normalizeMap("UserMap", c.UserMap, tc.Handler)
+	tc.Lock()
+	tc.channelMap = channels
+	tc.userMap = users
+	tc.Unlock()
+	tc.Log(robot.Info, "Teams connector reloaded %d channel(s) and %d user mapping(s)", len(channels), len(users))
+	return nil
+}
+
+// channelID resolves a bracketed ID, a configured or learned channel name,
+// or a bare Teams conversation ID.
+func (tc *teamsConnector) channelID(ch string) (string, bool) {
+	if id, ok := util.ExtractID(ch); ok {
+		return id, true
+	}
+	tc.RLock()
+	defer tc.RUnlock()
+	if id, ok := tc.channelMap[ch]; ok {
+		return id, true
+	}
+	for id, name := range tc.channelNames {
+		if name == ch {
+			return id, true
+		}
+	}
+	if strings.HasPrefix(ch, "19:") {
+		return ch, true
+	}
+	return "", false
+}
+
+// channelName prefers the configured name for a channel ID.
+func (tc *teamsConnector) channelName(id string) string {
+	tc.RLock()
+	defer tc.RUnlock()
+	for name, cid := range tc.channelMap {
+		if cid == id {
+			return name
+		}
+	}
+	return tc.channelNames[id]
+}
+
+// userID resolves a bracketed ID or a configured username; a configured
+// Entra object ID becomes the Teams user ID once the user has been seen.
+func (tc *teamsConnector) userID(u string) (string, bool) {
+	if id, ok := util.ExtractID(u); ok {
+		return id, true
+	}
+	tc.RLock()
+	defer tc.RUnlock()
+	id, ok := tc.userMap[strings.ToLower(strings.TrimSpace(u))]
+	if !ok {
+		return "", false
+	}
+	for _, user := range tc.users {
+		if user.aadObjectID != "" && strings.EqualFold(user.aadObjectID, id) {
+			return user.id, true
+		}
+	}
+	return id, true
+}
+
+func (tc *teamsConnector) configuredCanonicalUser(id, aadObjectID string) (string, bool) {
+	tc.RLock()
+	defer tc.RUnlock()
+	for name, uid := range tc.userMap {
+		if uid == id || (aadObjectID != "" && strings.EqualFold(uid, aadObjectID)) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (tc *teamsConnector) serviceURLFor(conversationID string) string {
+	tc.RLock()
+	defer tc.RUnlock()
+	if u, ok := tc.serviceURLs[conversationID]; ok {
+		return u
+	}
+	return tc.serviceURL
+}
+
+// member returns a user's profile, fetching it from a conversation the
+// user was seen in.
+func (tc *teamsConnector) member(id string) (teamsMember, bool) {
+	tc.RLock()
+	user, ok := tc.users[id]
+	var conversation string
+	if ok {
+		if user.member != nil {
+			m := *user.member
+			tc.RUnlock()
+			return m, true
+		}
+		conversation = user.conversation
+	}
+	if conversation == "" {
+		conversation = tc.directChats[id]
+	}
+	tc.RUnlock()
+	if conversation == "" {
+		tc.Log(robot.Debug, "No Teams conversation known for user '%s'; can't look up the profile", id)
+		return teamsMember{}, false
+	}
+	m, err := tc.getMember(tc.serviceURLFor(conversation), conversation, id)
+	if err != nil {
+		tc.Log(robot.Error, "Looking up Teams user '%s': %v", id, err)
+		return teamsMember{}, false
+	}
+	tc.Lock()
+	if user, ok := tc.users[id]; ok {
+		user.member = &m
+	}
+	tc.Unlock()
+	return m, true
+}
+
+// GetProtocolUserAttribute looks up profile attributes with the members API.
+func (tc *teamsConnector) GetProtocolUserAttribute(u, attr string) (value string, ret robot.RetVal) {
+	id, ok := tc.userID(u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	if attr == "internalid" {
+		return id, robot.Ok
+	}
+	m, ok := tc.member(id)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	switch attr {
+	case "email":
+		if m.Email != "" {
+			return m.Email, robot.Ok
+		}
+		return m.UserPrincipalName, robot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return m.Name, robot.Ok
+	case "firstname", "first name":
+		return m.GivenName, robot.Ok
+	case "lastname", "last name":
+		return m.Surname, robot.Ok
+	default:
+		return "", robot.AttributeNotFound
+	}
+}
+
+// MessageHeard shows a typing indicator in chats; Teams doesn't show them
+// in channels.
+func (tc *teamsConnector) MessageHeard(user, channel string) {
+	var conversation string
+	if channel == "" {
+		uid, ok := util.ExtractID(user)
+		if !ok {
+			return
+		}
+		tc.RLock()
+		conversation = tc.directChats[uid]
+		tc.RUnlock()
+	} else if id, ok := util.ExtractID(channel); ok && !isTeamChannel(id) {
+		conversation = id
+	}
+	serviceURL := tc.serviceURLFor(conversation)
+	if conversation == "" || serviceURL == "" {
+		return
+	}
+	go func() {
+		endpoint := strings.TrimSuffix(serviceURL, "/") + "/v3/conversations/" + url.PathEscape(conversation) + "/activities"
+		body := []byte(`{"type":"typing"}`)
+		if _, err := tc.callOnce(http.MethodPost, endpoint, body, nil); err != nil {
+			tc.Log(robot.Debug, "Teams typing indicator failed: %v", err)
+		}
+	}()
+}
+
+func (tc *teamsConnector) DefaultHelp() []string {
+	return nil
+}
+
+// JoinChannel is a no-op; the robot is in every channel of a team it's
+// installed in.
+func (tc *teamsConnector) JoinChannel(c string) robot.RetVal {
+	return robot.Ok
+}
+
+// SendProtocolChannelThreadMessage posts in a channel or group chat,
+// starting a new thread in a team channel when thr is empty.
+func (tc *teamsConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	chID, ok := tc.channelID(ch)
+	if !ok {
+		tc.Log(robot.Error, "Teams channel ID not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	return tc.sendChannel(chID, thr, nil, msg, f)
+}
+
+// SendProtocolUserChannelThreadMessage posts in a channel, mentioning the
+// user.
+func (tc *teamsConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	chID, ok := tc.channelID(ch)
+	if !ok {
+		tc.Log(robot.Error, "Teams channel ID not found for: %s", ch)
+		return robot.ChannelNotFound
+	}
+	id, ok := tc.userID(uid)
+	if !ok {
+		id, ok = tc.userID(u)
+	}
+	if !ok {
+		tc.Log(robot.Debug, "No Teams user ID for '%s'; sending without a mention", u)
+		return tc.sendChannel(chID, thr, nil, u+": "+msg, f)
+	}
+	mention := &channelAccount{ID: id, Name: u}
+	tc.RLock()
+	if user, ok := tc.users[id]; ok && user.name != "" {
+		mention.Name = user.name
+	}
+	tc.RUnlock()
+	return tc.sendChannel(chID, thr, mention, msg, f)
+}
+
+// SendProtocolUserMessage sends a direct message, creating the personal
+// conversation if the user hasn't messaged the robot.
+func (tc *teamsConnector) SendProtocolUserMessage(u, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	id, ok := tc.userID(u)
+	if !ok {
+		tc.Log(robot.Error, "No Teams user ID found for user: %s", u)
+		return robot.UserNotFound
+	}
+	conversation, err := tc.directChat(id)
+	if err != nil {
+		tc.Log(robot.Error, "Opening a Teams chat with user '%s': %v", u, err)
+		return robot.FailedMessageSend
+	}
+	tc.sendLock.Lock()
+	defer tc.sendLock.Unlock()
+	serviceURL := tc.serviceURLFor(conversation)
+	for _, part := range splitMessage(msg, tc.maxMessageLength, tc.maxMessageSplit) {
+		if _, err := tc.sendToConversation(serviceURL, conversation, renderMessage(part, f, nil)); err != nil {
+			tc.Log(robot.Error, "Sending Teams message to user '%s': %v", u, err)
+			return robot.FailedMessageSend
+		}
+	}
+	return robot.Ok
+}
+
+var errNoServiceURL = errors.New("no Teams service URL known yet; set ServiceURL or wait for an incoming message")
+
+func (tc *teamsConnector) directChat(id string) (string, error) {
+	tc.RLock()
+	conversation, ok := tc.directChats[id]
+	tenantID, serviceURL := tc.tenantID, tc.serviceURL
+	tc.RUnlock()
+	if ok {
+		return conversation, nil
+	}
+	if serviceURL == "" {
+		return "", errNoServiceURL
+	}
+	params := &conversationParameters{
+		Bot:      &channelAccount{ID: tc.botID},
+		Members:  []channelAccount{{ID: id}},
+		TenantID: tenantID,
+	}
+	if tenantID != "" {
+		params.ChannelData = &teamsChannelData{Tenant: &idName{ID: tenantID}}
+	}
+	res, err := tc.createConversation(serviceURL, params)
+	if err != nil {
+		return "", err
+	}
+	tc.Lock()
+	tc.directChats[id] = res.ID
+	tc.serviceURLs[res.ID] = serviceURL
+	tc.Unlock()
+	return res.ID, nil
+}
+
+// sendChannel sends every part of a message in order. A new post in a
+// team channel starts a thread, and later parts go in that thread.
+func (tc *teamsConnector) sendChannel(chID, thr string, mention *channelAccount, msg string, f robot.MessageFormat) robot.RetVal {
+	serviceURL := tc.serviceURLFor(chID)
+	if serviceURL == "" {
+		tc.Log(robot.Error, "Sending to Teams channel '%s': %v", chID, errNoServiceURL)
+		return robot.FailedMessageSend
+	}
+	tc.RLock()
+	tenantID := tc.tenantID
+	tc.RUnlock()
+	teamChannel := isTeamChannel(chID)
+	tc.sendLock.Lock()
+	defer tc.sendLock.Unlock()
+	for i, part := range splitMessage(msg, tc.maxMessageLength, tc.maxMessageSplit) {
+		var m *channelAccount
+		if i == 0 {
+			m = mention
+		}
+		a := renderMessage(part, f, m)
+		var err error
+		switch {
+		case teamChannel && thr == "":
+			var res conversationResource
+			res, err = tc.createConversation(serviceURL, &conversationParameters{
+				IsGroup:     true,
+				TenantID:    tenantID,
+				Activity:    a,
+				ChannelData: &teamsChannelData{Channel: &idName{ID: chID}},
+			})
+			thr = res.ActivityID
+			if thr == "" {
+				_, thr = splitConversationID(res.ID)
+			}
+		case teamChannel:
+			_, err = tc.sendToConversation(serviceURL, chID+";messageid="+thr, a)
+		default:
+			_, err = tc.sendToConversation(serviceURL, chID, a)
+		}
+		if err != nil {
+			tc.Log(robot.Error, "Sending Teams message to channel '%s': %v", chID, err)
+			return robot.FailedMessageSend
+		}
+	}
+	return robot.Ok
+}
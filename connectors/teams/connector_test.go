@@ -0,0 +1,419 @@
+package teams
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	protocolConfig *config
+	botID          string
+	incoming       chan *robot.ConnectorMessage
+}
+
+func (t *testHandler) IncomingMessage(m *robot.ConnectorMessage) {
+	if t.incoming != nil {
+		t.incoming <- m
+	}
+}
+func (t *testHandler) GetProtocolConfig(v interface{}) error {
+	if t.protocolConfig != nil {
+		*(v.(*config)) = *t.protocolConfig
+	}
+	return nil
+}
+func (t *testHandler) GetBrainConfig(_ interface{}) error         { return nil }
+func (t *testHandler) GetEventStrings() *[]string                 { return nil }
+func (t *testHandler) GetHistoryConfig(_ interface{}) error       { return nil }
+func (t *testHandler) GetBotInfo() robot.BotInfo                  { return robot.BotInfo{} }
+func (t *testHandler) SetBotID(id string)                         { t.botID = id }
+func (t *testHandler) SetTerminalWriter(_ io.Writer)              {}
+func (t *testHandler) SetBotMention(_ string)                     {}
+func (t *testHandler) GetLogLevel() robot.LogLevel                { return robot.Info }
+func (t *testHandler) GetInstallPath() string                     { return "" }
+func (t *testHandler) GetConfigPath() string                      { return "" }
+func (t *testHandler) ReadEncryptedFile(_ string) ([]byte, error) { return nil, nil }
+func (t *testHandler) Log(_ robot.LogLevel, _ string, _ ...interface{}) {
+}
+func (t *testHandler) GetDirectory(_ string) error { return nil }
+
+const (
+	testAppID   = "11111111-2222-3333-4444-555555555555"
+	testChannel = "19:ops@thread.tacv2"
+	testUser    = "29:alice"
+)
+
+var (
+	testKey     *rsa.PrivateKey
+	testKeyOnce sync.Once
+)
+
+func signingKey(t *testing.T) *rsa.PrivateKey {
+	testKeyOnce.Do(func() {
+		var err error
+		if testKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatalf("generating key: %v", err)
+		}
+	})
+	return testKey
+}
+
+// standIn plays the Microsoft side: the signing keys, the token endpoint
+// and the Bot Connector service, recording every REST call.
+type standIn struct {
+	*httptest.Server
+	sync.Mutex
+	calls     []recordedCall
+	badSecret bool
+}
+
+type recordedCall struct {
+	method, path, auth string
+	body               map[string]interface{}
+}
+
+func newStandIn(t *testing.T) *standIn {
+	t.Helper()
+	key := signingKey(t)
+	s := &standIn{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		e := big.NewInt(int64(key.E)).Bytes()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]interface{}{{
+			"kty":          "RSA",
+			"kid":          "k1",
+			"n":            base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":            base64.RawURLEncoding.EncodeToString(e),
+			"endorsements": []string{"msteams"},
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.Lock()
+		bad := s.badSecret
+		s.Unlock()
+		if bad || r.Form.Get("client_id") != testAppID || r.Form.Get("scope") != botFrameworkScope {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "out-token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/v3/", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.Lock()
+		s.calls = append(s.calls, recordedCall{r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization"), body})
+		s.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v3/conversations":
+			if body["isGroup"] == true {
+				json.NewEncoder(w).Encode(map[string]string{"id": testChannel + ";messageid=900", "activityId": "900"})
+			} else {
+				json.NewEncoder(w).Encode(map[string]string{"id": "a:dm-alice"})
+			}
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/members/"):
+			json.NewEncoder(w).Encode(teamsMember{ID: testUser, Name: "Alice Smith", Email: "alice@example.com", GivenName: "Alice", Surname: "Smith"})
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"id": "901"})
+		}
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *standIn) takeCalls() []recordedCall {
+	s.Lock()
+	defer s.Unlock()
+	calls := s.calls
+	s.calls = nil
+	return calls
+}
+
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestConnector(t *testing.T, h *testHandler, s *standIn) *teamsConnector {
+	t.Helper()
+	tc, err := newConnector(h, config{
+		AppID:       testAppID,
+		AppPassword: "secret",
+		JWKSURL:     s.URL + "/keys",
+		TokenURL:    s.URL + "/token",
+		Channels:    map[string]string{"ops": testChannel},
+		UserMap:     map[string]string{"alice": "aad-alice"},
+	})
+	if err != nil {
+		t.Fatalf("newConnector: %v", err)
+	}
+	tc.now = func() time.Time { return testNow }
+	tc.retrySleep = func(time.Duration) {}
+	return tc
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims(serviceURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":        defaultIssuer,
+		"aud":        testAppID,
+		"exp":        testNow.Add(time.Hour).Unix(),
+		"nbf":        testNow.Add(-time.Minute).Unix(),
+		"serviceurl": serviceURL,
+	}
+}
+
+func channelActivity(serviceURL, conversationID, id, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":         "message",
+		"id":           id,
+		"serviceUrl":   serviceURL,
+		"channelId":    "msteams",
+		"from":         map[string]string{"id": testUser, "name": "Alice Smith", "aadObjectId": "aad-alice"},
+		"recipient":    map[string]string{"id": "28:" + testAppID, "name": "Floyd"},
+		"conversation": map[string]string{"id": conversationID, "conversationType": "channel", "tenantId": "tenant-1"},
+		"text":         "<at>Floyd</at> " + text,
+		"entities": []map[string]interface{}{{
+			"type":      "mention",
+			"mentioned": map[string]string{"id": "28:" + testAppID, "name": "Floyd"},
+			"text":      "<at>Floyd</at>",
+		}},
+		"channelData": map[string]interface{}{
+			"channel": map[string]string{"id": testChannel},
+			"team":    map[string]string{"id": "19:team@thread.tacv2"},
+			"tenant":  map[string]string{"id": "tenant-1"},
+		},
+	}
+}
+
+func postActivity(t *testing.T, tc *teamsConnector, token string, a map[string]interface{}) int {
+	t.Helper()
+	body, _ := json.Marshal(a)
+	req := httptest.NewRequest(http.MethodPost, "/api/messages", bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	tc.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestInitializeIdentifiesBot(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{AppID: testAppID, AppPassword: "secret", TenantID: "tenant-1"}}
+	ic := Initialize(h, nil)
+	if ic.Error != nil {
+		t.Fatalf("Initialize: %v", ic.Error)
+	}
+	tc := ic.Connector.(*teamsConnector)
+	if h.botID != "28:"+testAppID || tc.listenAddr != "127.0.0.1:3978" || tc.path != "/api/messages" {
+		t.Fatalf("connector = bot %q, listen %q%s", h.botID, tc.listenAddr, tc.path)
+	}
+	if tc.tokens.url != "https://login.microsoftonline.com/tenant-1/oauth2/v2.0/token" {
+		t.Fatalf("token URL = %q", tc.tokens.url)
+	}
+	h.protocolConfig.AppPassword = ""
+	if ic := Initialize(h, nil); ic.Error == nil {
+		t.Fatal("config without AppPassword accepted")
+	}
+}
+
+func TestRunStopsOnRejectedCredentials(t *testing.T) {
+	s := newStandIn(t)
+	s.badSecret = true
+	tc := newTestConnector(t, &testHandler{}, s)
+	tc.listenAddr = "127.0.0.1:0"
+	if err := tc.Run(make(chan struct{})); !errors.Is(err, errUnauthorized) {
+		t.Fatalf("Run = %v, want errUnauthorized", err)
+	}
+}
+
+func TestIncomingChannelMessage(t *testing.T) {
+	s := newStandIn(t)
+	h := &testHandler{incoming: make(chan *robot.ConnectorMessage, 2)}
+	tc := newTestConnector(t, h, s)
+	token := signToken(t, signingKey(t), validClaims(s.URL))
+
+	if code := postActivity(t, tc, token, channelActivity(s.URL, testChannel+";messageid=500", "500", "deploy &amp; verify")); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	m := <-h.incoming
+	if m.Protocol != "teams" || m.UserID != testUser || m.UserName != "alice" || !m.ValidatedUser {
+		t.Fatalf("identity = %+v", m)
+	}
+	if m.ChannelID != testChannel || m.ChannelName != "ops" || m.ThreadID != "500" || m.ThreadedMessage || m.DirectMessage {
+		t.Fatalf("channel = %+v", m)
+	}
+	if m.MessageText != "deploy & verify" || !m.BotMessage {
+		t.Fatalf("text %q, addressed %v", m.MessageText, m.BotMessage)
+	}
+
+	a := channelActivity(s.URL, testChannel+";messageid=500", "501", "status")
+	a["from"] = map[string]string{"id": "29:bob", "name": "Bob"}
+	a["text"], a["entities"] = "status", nil
+	postActivity(t, tc, token, a)
+	m = <-h.incoming
+	if m.ThreadID != "500" || !m.ThreadedMessage || m.BotMessage || m.UserName != "" || m.ValidatedUser {
+		t.Fatalf("thread reply = %+v", m)
+	}
+	if id, ok := tc.userID("alice"); !ok || id != testUser {
+		t.Fatalf("alice resolves to %q, %v after being seen", id, ok)
+	}
+}
+
+func TestRejectsUnauthenticatedActivities(t *testing.T) {
+	s := newStandIn(t)
+	h := &testHandler{incoming: make(chan *robot.ConnectorMessage, 8)}
+	tc := newTestConnector(t, h, s)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		c := validClaims(s.URL)
+		c[k] = v
+		return c
+	}
+	tokens := map[string]string{
+		"no token":         "",
+		"wrong key":        signToken(t, other, validClaims(s.URL)),
+		"wrong issuer":     signToken(t, signingKey(t), with("iss", "https://evil.example.com")),
+		"wrong audience":   signToken(t, signingKey(t), with("aud", []string{"someone-else"})),
+		"expired":          signToken(t, signingKey(t), with("exp", testNow.Add(-time.Hour).Unix())),
+		"other serviceurl": signToken(t, signingKey(t), with("serviceurl", "https://evil.example.com")),
+	}
+	for name, token := range tokens {
+		if code := postActivity(t, tc, token, channelActivity(s.URL, testChannel, "1", "hi")); code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, code)
+		}
+	}
+	a := channelActivity(s.URL, testChannel, "1", "hi")
+	a["channelId"] = "slack"
+	if code := postActivity(t, tc, signToken(t, signingKey(t), validClaims(s.URL)), a); code != http.StatusUnauthorized {
+		t.Errorf("key not endorsed for the channel: status %d, want 401", code)
+	}
+	if len(h.incoming) != 0 || tc.serviceURLFor(testChannel) != "" {
+		t.Fatal("an unauthenticated activity reached the robot")
+	}
+}
+
+func TestSendsChannelPostsAndThreadReplies(t *testing.T) {
+	s := newStandIn(t)
+	h := &testHandler{incoming: make(chan *robot.ConnectorMessage, 1)}
+	tc := newTestConnector(t, h, s)
+	postActivity(t, tc, signToken(t, signingKey(t), validClaims(s.URL)), channelActivity(s.URL, testChannel+";messageid=500", "500", "deploy"))
+	<-h.incoming
+
+	if ret := tc.SendProtocolUserChannelThreadMessage("<"+testUser+">", "alice", "ops", "500", "done", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("thread reply = %v", ret)
+	}
+	if ret := tc.SendProtocolChannelThreadMessage("ops", "", "*nightly* report", robot.BasicMarkdown, nil); ret != robot.Ok {
+		t.Fatalf("new post = %v", ret)
+	}
+	if ret := tc.SendProtocolChannelThreadMessage("nowhere", "", "x", robot.Raw, nil); ret != robot.ChannelNotFound {
+		t.Fatalf("unknown channel = %v, want ChannelNotFound", ret)
+	}
+	calls := s.takeCalls()
+	if len(calls) != 2 {
+		t.Fatalf("calls = %+v", calls)
+	}
+	reply := calls[0]
+	if reply.path != "/v3/conversations/19:ops@thread.tacv2%3Bmessageid=500/activities" || reply.auth != "Bearer out-token" {
+		t.Fatalf("thread reply call = %+v", reply)
+	}
+	if reply.body["text"] != "<at>Alice Smith</at> done" || reply.body["entities"] == nil {
+		t.Fatalf("thread reply body = %v", reply.body)
+	}
+	post := calls[1]
+	channelData, _ := post.body["channelData"].(map[string]interface{})
+	posted, _ := post.body["activity"].(map[string]interface{})
+	if post.path != "/v3/conversations" || post.body["isGroup"] != true || channelData == nil || posted["textFormat"] != "markdown" {
+		t.Fatalf("new post call = %+v", post)
+	}
+}
+
+func TestSendsDirectMessagesAsCards(t *testing.T) {
+	s := newStandIn(t)
+	tc := newTestConnector(t, &testHandler{}, s)
+	tc.serviceURL = s.URL
+	tc.tenantID = "tenant-1"
+
+	if ret := tc.SendProtocolUserMessage("<"+testUser+">", "NAME    STATUS\n  web   ok", robot.Fixed, nil); ret != robot.Ok {
+		t.Fatalf("direct message = %v", ret)
+	}
+	if ret := tc.SendProtocolUserMessage("<"+testUser+">", "again", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("second direct message = %v", ret)
+	}
+	if ret := tc.SendProtocolUserMessage("bob", "hi", robot.Raw, nil); ret != robot.UserNotFound {
+		t.Fatalf("unmapped user = %v, want UserNotFound", ret)
+	}
+	calls := s.takeCalls()
+	if len(calls) != 3 || calls[0].path != "/v3/conversations" || calls[0].body["tenantId"] != "tenant-1" {
+		t.Fatalf("calls = %+v", calls)
+	}
+	if calls[1].path != "/v3/conversations/a:dm-alice/activities" || calls[2].path != calls[1].path {
+		t.Fatalf("the personal conversation wasn't reused: %+v", calls)
+	}
+	card := calls[1].body["attachments"].([]interface{})[0].(map[string]interface{})
+	content := card["content"].(map[string]interface{})
+	body := content["body"].([]interface{})
+	run := body[1].(map[string]interface{})["inlines"].([]interface{})[0].(map[string]interface{})
+	if card["contentType"] != adaptiveCardType || len(body) != 2 || run["fontType"] != "Monospace" || run["text"] != "\u00a0\u00a0web   ok" {
+		t.Fatalf("card = %v", card)
+	}
+
+	if v, ret := tc.GetProtocolUserAttribute("<"+testUser+">", "email"); ret != robot.Ok || v != "alice@example.com" {
+		t.Fatalf("email = %q, %v", v, ret)
+	}
+}
+
+func TestReloadSwapsMaps(t *testing.T) {
+	s := newStandIn(t)
+	h := &testHandler{protocolConfig: &config{
+		Channels: map[string]string{"builds": "19:builds@thread.tacv2"},
+		UserMap:  map[string]string{"bob": "29:bob", "Carol": "29:carol"},
+	}}
+	tc := newTestConnector(t, h, s)
+	if err := tc.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, ok := tc.channelID("ops"); ok {
+		t.Fatal("removed channel still resolves")
+	}
+	if id, ok := tc.channelID("builds"); !ok || id != "19:builds@thread.tacv2" {
+		t.Fatalf("builds = %q, %v", id, ok)
+	}
+	if name, ok := tc.configuredCanonicalUser("29:bob", ""); !ok || name != "bob" {
+		t.Fatalf("bob = %q, %v", name, ok)
+	}
+	if _, ok := tc.userID("carol"); ok {
+		t.Fatal("uppercase UserMap entry accepted")
+	}
+}
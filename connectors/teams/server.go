@@ -0,0 +1,177 @@
+package teams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Run serves the messaging endpoint until stop is closed. Rejected app
+// credentials end it at once; everything else is retried per request.
+func (tc *teamsConnector) Run(stop <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	_, err := tc.tokens.Token(ctx)
+	cancel()
+	if errors.Is(err, errUnauthorized) {
+		return err
+	}
+	if err != nil {
+		tc.Log(robot.Warn, "Teams connector couldn't get an access token yet: %v", err)
+	}
+	ln, err := net.Listen("tcp", tc.listenAddr)
+	if err != nil {
+		return fmt.Errorf("Teams connector failed to listen on %s: %w", tc.listenAddr, err)
+	}
+	srv := &http.Server{Handler: tc, ReadHeaderTimeout: 10 * time.Second}
+	tc.Log(robot.Info, "Teams connector listening on %s%s", ln.Addr(), tc.path)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	select {
+	case <-stop:
+		tc.Log(robot.Info, "Received stop in Teams connector")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+		return nil
+	case err := <-errc:
+		return fmt.Errorf("Teams endpoint failed: %w", err)
+	}
+}
+
+func (tc *teamsConnector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != tc.path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, tc.maxBodyBytes))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var a activity
+	if err := json.Unmarshal(body, &a); err != nil {
+		http.Error(w, "invalid activity", http.StatusBadRequest)
+		return
+	}
+	if err := tc.verifyToken(r.Header.Get("Authorization"), a.ServiceURL, a.ChannelID); err != nil {
+		tc.Log(robot.Warn, "Rejected Teams activity from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// The engine handles messages asynchronously; replies go out through
+	// the REST API, not this response.
+	w.WriteHeader(http.StatusOK)
+	tc.handleActivity(&a)
+}
+
+// handleActivity records what an authenticated activity says about its
+// conversation and sender, then dispatches messages.
+func (tc *teamsConnector) handleActivity(a *activity) {
+	if a.Conversation == nil || a.From == nil {
+		tc.Log(robot.Debug, "Ignoring Teams %s activity without a conversation or sender", a.Type)
+		return
+	}
+	conversationID := a.Conversation.ID
+	if a.Conversation.ConversationType == "channel" {
+		conversationID, _ = splitConversationID(conversationID)
+	}
+	tc.Lock()
+	tc.serviceURL = a.ServiceURL
+	tc.serviceURLs[conversationID] = a.ServiceURL
+	if tenant := a.tenantID(); tenant != "" && tc.tenantID == "" {
+		tc.tenantID = tenant
+	}
+	if cd := a.ChannelData; cd != nil && cd.Channel != nil && cd.Channel.ID != "" {
+		switch {
+		case cd.Channel.Name != "":
+			tc.channelNames[cd.Channel.ID] = cd.Channel.Name
+		case cd.Team != nil && cd.Team.ID == cd.Channel.ID:
+			// The General channel shares the team's ID and has no name.
+			tc.channelNames[cd.Channel.ID] = "General"
+		}
+	}
+	if a.From.ID != tc.botID {
+		user, ok := tc.users[a.From.ID]
+		if !ok {
+			user = &teamsUser{id: a.From.ID}
+			tc.users[a.From.ID] = user
+		}
+		if a.From.Name != "" {
+			user.name = a.From.Name
+		}
+		if a.From.AADObjectID != "" {
+			user.aadObjectID = a.From.AADObjectID
+		}
+		user.conversation = conversationID
+		if a.Conversation.ConversationType == "personal" {
+			tc.directChats[a.From.ID] = conversationID
+		}
+	}
+	tc.Unlock()
+
+	switch a.Type {
+	case "message":
+		tc.handleMessage(a)
+	case "conversationUpdate":
+		tc.Log(robot.Debug, "Teams conversation update in '%s'", conversationID)
+	default:
+		tc.Log(robot.Trace, "Ignoring Teams %s activity", a.Type)
+	}
+}
+
+func (tc *teamsConnector) handleMessage(a *activity) {
+	text, mentioned := messageText(a, tc.botID)
+	if text == "" {
+		tc.Log(robot.Debug, "Ignoring Teams message '%s' with no text", a.ID)
+		return
+	}
+	botMsg := &robot.ConnectorMessage{
+		Protocol:      "teams",
+		UserID:        a.From.ID,
+		MessageID:     a.ID,
+		BotMessage:    mentioned,
+		MessageText:   text,
+		MessageObject: a,
+	}
+	switch a.Conversation.ConversationType {
+	case "personal":
+		botMsg.DirectMessage = true
+	case "channel":
+		channelID, root := splitConversationID(a.Conversation.ID)
+		if root == "" {
+			root = a.ID
+		}
+		botMsg.ChannelID = channelID
+		botMsg.ChannelName = tc.channelName(channelID)
+		botMsg.ThreadID = root
+		botMsg.ThreadedMessage = root != a.ID
+	default:
+		botMsg.ChannelID = a.Conversation.ID
+		botMsg.ChannelName = tc.channelName(a.Conversation.ID)
+		if botMsg.ChannelName == "" {
+			botMsg.ChannelName = a.Conversation.Name
+		}
+	}
+	if name, ok := tc.configuredCanonicalUser(a.From.ID, a.From.AADObjectID); ok {
+		botMsg.UserName = name
+		botMsg.ValidatedUser = true
+	}
+	if a.From.ID == tc.botID {
+		botMsg.SelfMessage = true
+	}
+	tc.IncomingMessage(botMsg)
+}
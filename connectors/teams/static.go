@@ -0,0 +1,7 @@
+package teams
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterConnector("teams", Initialize)
+}
@@ -0,0 +1,220 @@
+// Package webhook implements the robot.Connector interface for
+// authenticated HTTP POSTs, so CI systems and scripts can run robot
+// commands through the normal authorization and elevation pipeline and
+// get the replies back.
+package webhook
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	defaultListenHost   = "127.0.0.1"
+	defaultListenPort   = 8089
+	defaultPath         = "/webhook"
+	defaultReplyTimeout = 30 // seconds
+	defaultReplyIdle    = 2  // seconds
+	defaultMaxBodyBytes = 64 * 1024
+)
+
+// clientEntry configures one caller and the identity its commands run as.
+type clientEntry struct {
+	Name        string // identifies the client; the internal user ID of its messages
+	UserName    string // canonical username commands run as
+	Channel     string // channel commands run in; empty for a direct message
+	Token       string // bearer token
+	HMACSecret  string // shared secret for signed requests and callbacks
+	CallbackURL string // where async results are posted
+}
+
+type config struct {
+	ListenHost   string
+	ListenPort   int
+	Path         string
+	ReplyTimeout int // the longest a request waits for replies, in seconds
+	ReplyIdle    int // seconds without a new reply that end the wait
+	MaxBodyBytes int64
+	Clients      []clientEntry
+}
+
+func normalizeClients(in []clientEntry, h robot.Handler) map[string]clientEntry {
+	out := make(map[string]clientEntry, len(in))
+	for _, c := range in {
+		c.Name = strings.TrimSpace(c.Name)
+		c.UserName = strings.TrimSpace(c.UserName)
+		c.Channel = strings.TrimSpace(c.Channel)
+		switch {
+		case c.Name == "" || c.UserName == "":
+			h.Log(robot.Warn, "Ignoring webhook client with an empty Name or UserName: %q -> %q", c.Name, c.UserName)
+			continue
+		case strings.ToLower(c.UserName) != c.UserName:
+			h.Log(robot.Warn, "Ignoring webhook client '%s' with uppercase username: %q", c.Name, c.UserName)
+			continue
+		case c.Token == "" && c.HMACSecret == "":
+			h.Log(robot.Warn, "Ignoring webhook client '%s' with neither Token nor HMACSecret", c.Name)
+			continue
+		}
+		if _, dup := out[c.Name]; dup {
+			h.Log(robot.Warn, "Ignoring duplicate webhook client '%s'", c.Name)
+			continue
+		}
+		out[c.Name] = c
+	}
+	return out
+}
+
+type webhookConnector struct {
+	robot.Handler
+	sync.RWMutex                        // protects clients
+	clients      map[string]clientEntry // configured clients by Name
+	listenAddr   string
+	path         string
+	replyTimeout time.Duration
+	replyIdle    time.Duration
+	maxBodyBytes int64
+
+	pendingLock sync.Mutex                 // protects pending and signatures
+	pending     map[string]*pendingRequest // requests waiting for replies, by MessageID
+	signatures  map[string]time.Time       // recent signatures, to refuse replays
+
+	httpClient *http.Client // for callbacks
+	now        func() time.Time
+}
+
+func newConnector(handler robot.Handler, c config) (*webhookConnector, error) {
+	if c.ListenHost == "" {
+		c.ListenHost = defaultListenHost
+	}
+	if c.ListenPort == 0 {
+		c.ListenPort = defaultListenPort
+	}
+	if c.Path == "" {
+		c.Path = defaultPath
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return nil, fmt.Errorf("webhook Path must start with '/', got %q", c.Path)
+	}
+	if c.ReplyTimeout <= 0 {
+		c.ReplyTimeout = defaultReplyTimeout
+	}
+	if c.ReplyIdle <= 0 {
+		c.ReplyIdle = defaultReplyIdle
+	}
+	if c.MaxBodyBytes <= 0 {
+		c.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	wc := &webhookConnector{
+		Handler:      handler,
+		clients:      normalizeClients(c.Clients, handler),
+		listenAddr:   net.JoinHostPort(c.ListenHost, strconv.Itoa(c.ListenPort)),
+		path:         c.Path,
+		replyTimeout: time.Duration(c.ReplyTimeout) * time.Second,
+		replyIdle:    time.Duration(c.ReplyIdle) * time.Second,
+		maxBodyBytes: c.MaxBodyBytes,
+		pending:      make(map[string]*pendingRequest),
+		signatures:   make(map[string]time.Time),
+		httpClient:   &http.Client{Timeout: callbackTimeout},
+		now:          time.Now,
+	}
+	return wc, nil
+}
+
+// Initialize validates config and returns the connector; the listener
+// opens in Run.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		return robot.InitializedConnector{Error: fmt.Errorf("unable to retrieve webhook protocol configuration: %w", err)}
+	}
+	wc, err := newConnector(handler, c)
+	if err != nil {
+		return robot.InitializedConnector{Error: err}
+	}
+	if len(wc.clients) == 0 {
+		handler.Log(robot.Warn, "Webhook connector started with no configured clients; no request can authenticate until Clients is configured in ProtocolConfig")
+	}
+	return robot.InitializedConnector{Connector: wc}
+}
+
+// Reload swaps in the configured clients; listener changes need a restart.
+func (wc *webhookConnector) Reload() error {
+	var c config
+	if err := wc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve webhook protocol configuration: %w", err)
+	}
+	clients := normalizeClients(c.Clients, wc.Handler)
+	wc.Lock()
+	wc.clients = clients
+	wc.Unlock()
+	wc.Log(robot.Info, "Webhook connector reloaded %d configured client(s)", len(clients))
+	return nil
+}
+
+// clientForUser finds a client by bracketed internal ID (its Name) or
+// canonical username.
+func (wc *webhookConnector) clientForUser(u string) (clientEntry, bool) {
+	wc.RLock()
+	defer wc.RUnlock()
+	if id, ok := util.ExtractID(u); ok {
+		c, found := wc.clients[id]
+		return c, found
+	}
+	for _, c := range wc.clients {
+		if c.UserName == u {
+			return c, true
+		}
+	}
+	return clientEntry{}, false
+}
+
+// GetProtocolUserAttribute only knows the client name of a configured user.
+func (wc *webhookConnector) GetProtocolUserAttribute(u, attr string) (value string, ret robot.RetVal) {
+	c, ok := wc.clientForUser(u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	if attr == "internalid" {
+		return c.Name, robot.Ok
+	}
+	return "", robot.AttributeNotFound
+}
+
+// MessageHeard is a no-op; the caller is waiting on the HTTP response.
+func (wc *webhookConnector) MessageHeard(user, channel string) {}
+
+func (wc *webhookConnector) DefaultHelp() []string {
+	return nil
+}
+
+// JoinChannel is a no-op; channels only name where commands run.
+func (wc *webhookConnector) JoinChannel(c string) robot.RetVal {
+	return robot.Ok
+}
+
+// SendProtocolChannelThreadMessage adds a reply to the request that
+// triggered it.
+func (wc *webhookConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	return wc.addReply(msgObject, reply{Channel: ch, Thread: thr, Format: f.String(), Text: msg})
+}
+
+// SendProtocolUserChannelThreadMessage adds a reply directed at a user to
+// the request that triggered it.
+func (wc *webhookConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thr, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	return wc.addReply(msgObject, reply{User: u, Channel: ch, Thread: thr, Format: f.String(), Text: msg})
+}
+
+// SendProtocolUserMessage adds a direct reply to the request that
+// triggered it.
+func (wc *webhookConnector) SendProtocolUserMessage(u, msg string, f robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	return wc.addReply(msgObject, reply{User: u, Format: f.String(), Text: msg})
+}
@@ -0,0 +1,269 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	protocolConfig *config
+	// respond stands in for the engine, replying to incoming messages.
+	respond  func(*robot.ConnectorMessage)
+	incoming chan *robot.ConnectorMessage
+}
+
+func (t *testHandler) IncomingMessage(m *robot.ConnectorMessage) {
+	if t.incoming != nil {
+		t.incoming <- m
+	}
+	if t.respond != nil {
+		go t.respond(m)
+	}
+}
+func (t *testHandler) GetProtocolConfig(v interface{}) error {
+	if t.protocolConfig != nil {
+		*(v.(*config)) = *t.protocolConfig
+	}
+	return nil
+}
+func (t *testHandler) GetBrainConfig(_ interface{}) error         { return nil }
+func (t *testHandler) GetEventStrings() *[]string                 { return nil }
+func (t *testHandler) GetHistoryConfig(_ interface{}) error       { return nil }
+func (t *testHandler) GetBotInfo() robot.BotInfo                  { return robot.BotInfo{} }
+func (t *testHandler) SetBotID(_ string)                          {}
+func (t *testHandler) SetTerminalWriter(_ io.Writer)              {}
+func (t *testHandler) SetBotMention(_ string)                     {}
+func (t *testHandler) GetLogLevel() robot.LogLevel                { return robot.Info }
+func (t *testHandler) GetInstallPath() string                     { return "" }
+func (t *testHandler) GetConfigPath() string                      { return "" }
+func (t *testHandler) ReadEncryptedFile(_ string) ([]byte, error) { return nil, nil }
+func (t *testHandler) Log(_ robot.LogLevel, _ string, _ ...interface{}) {
+}
+func (t *testHandler) GetDirectory(_ string) error { return nil }
+
+var testClients = []clientEntry{
+	{Name: "ci", UserName: "ci-runner", Channel: "builds", Token: "ci-token"},
+	{Name: "deploy", UserName: "deployer", HMACSecret: "shh"},
+}
+
+// newTestConnector serves the connector with short reply windows.
+func newTestConnector(t *testing.T, h *testHandler) (*webhookConnector, *httptest.Server) {
+	t.Helper()
+	wc, err := newConnector(h, config{Clients: testClients})
+	if err != nil {
+		t.Fatalf("newConnector: %v", err)
+	}
+	wc.replyTimeout = 2 * time.Second
+	wc.replyIdle = 100 * time.Millisecond
+	srv := httptest.NewServer(wc)
+	t.Cleanup(srv.Close)
+	return wc, srv
+}
+
+func post(t *testing.T, url, body string, headers map[string]string) (*http.Response, result) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+	var res result
+	if resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+	}
+	return resp, res
+}
+
+func signedHeaders(client, secret string, ts time.Time, body string) map[string]string {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	return map[string]string{
+		clientHeader:    client,
+		timestampHeader: timestamp,
+		signatureHeader: sign(secret, timestamp, []byte(body)),
+	}
+}
+
+func TestBearerRequestReturnsReplies(t *testing.T) {
+	h := &testHandler{incoming: make(chan *robot.ConnectorMessage, 1)}
+	wc, srv := newTestConnector(t, h)
+	h.respond = func(m *robot.ConnectorMessage) {
+		wc.SendProtocolUserChannelThreadMessage("<ci>", "ci-runner", "builds", m.ThreadID, "building", robot.Variable, m)
+		wc.SendProtocolChannelThreadMessage("builds", m.ThreadID, "*done*", robot.BasicMarkdown, m)
+	}
+
+	resp, res := post(t, srv.URL+"/webhook", `{"Text": "build gopherbot"}`, map[string]string{"Authorization": "Bearer ci-token"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %s", resp.Status)
+	}
+	m := <-h.incoming
+	if m.Protocol != "webhook" || m.UserName != "ci-runner" || m.UserID != "ci" || !m.ValidatedUser || !m.BotMessage {
+		t.Fatalf("incoming identity = %+v", m)
+	}
+	if m.ChannelName != "builds" || m.DirectMessage || m.MessageText != "build gopherbot" || m.MessageID != res.ID {
+		t.Fatalf("incoming message = %+v", m)
+	}
+	if res.TimedOut || len(res.Replies) != 2 {
+		t.Fatalf("result = %+v", res)
+	}
+	if r := res.Replies[0]; r.User != "ci-runner" || r.Channel != "builds" || r.Text != "building" || r.Format != "Variable" {
+		t.Fatalf("first reply = %+v", r)
+	}
+	if r := res.Replies[1]; r.Text != "*done*" || r.Format != "BasicMarkdown" {
+		t.Fatalf("second reply = %+v", r)
+	}
+
+	// A reply after the request finished has nowhere to go.
+	if ret := wc.SendProtocolChannelThreadMessage("builds", "", "late", robot.Raw, m); ret != robot.FailedMessageSend {
+		t.Fatalf("late reply = %v, want FailedMessageSend", ret)
+	}
+}
+
+func TestSignedRequestsAndReplays(t *testing.T) {
+	h := &testHandler{incoming: make(chan *robot.ConnectorMessage, 2)}
+	wc, srv := newTestConnector(t, h)
+	h.respond = func(m *robot.ConnectorMessage) {
+		wc.SendProtocolUserMessage("deployer", "ok", robot.Raw, m)
+	}
+	body := `{"Text": "deploy web"}`
+	headers := signedHeaders("deploy", "shh", time.Now(), body)
+
+	resp, res := post(t, srv.URL+"/webhook", body, headers)
+	if resp.StatusCode != http.StatusOK || len(res.Replies) != 1 || res.Replies[0].User != "deployer" {
+		t.Fatalf("signed request: %s, %+v", resp.Status, res)
+	}
+	if m := <-h.incoming; !m.DirectMessage || m.ChannelName != "" {
+		t.Fatalf("client without a channel sent %+v", m)
+	}
+	if resp, _ := post(t, srv.URL+"/webhook", body, headers); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("replayed request: %s", resp.Status)
+	}
+
+	rejected := []map[string]string{
+		signedHeaders("deploy", "wrong", time.Now(), body),
+		signedHeaders("deploy", "shh", time.Now().Add(-time.Hour), body),
+		signedHeaders("ci", "shh", time.Now(), body),
+		{"Authorization": "Bearer nope"},
+		nil,
+	}
+	for i, headers := range rejected {
+		if resp, _ := post(t, srv.URL+"/webhook", body, headers); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("request %d: %s, want 401", i, resp.Status)
+		}
+	}
+	if len(h.incoming) != 0 {
+		t.Fatal("a rejected request reached the robot")
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, srv := newTestConnector(t, &testHandler{})
+	auth := map[string]string{"Authorization": "Bearer ci-token"}
+	cases := []struct {
+		path, body string
+		want       int
+	}{
+		{"/other", `{"Text": "x"}`, http.StatusNotFound},
+		{"/webhook", `not json`, http.StatusBadRequest},
+		{"/webhook", `{"Text": "  "}`, http.StatusBadRequest},
+		{"/webhook", `{"Text": "x", "Async": true}`, http.StatusBadRequest},
+		{"/webhook", `{"Text": "` + strings.Repeat("x", defaultMaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		if resp, _ := post(t, srv.URL+c.path, c.body, auth); resp.StatusCode != c.want {
+			t.Errorf("POST %s %.20q: %s, want %d", c.path, c.body, resp.Status, c.want)
+		}
+	}
+	resp, err := http.Get(srv.URL + "/webhook")
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET = %v, %v", resp, err)
+	}
+}
+
+func TestTimeoutWithoutReplies(t *testing.T) {
+	wc, srv := newTestConnector(t, &testHandler{})
+	wc.replyTimeout = 100 * time.Millisecond
+	resp, res := post(t, srv.URL+"/webhook", `{"Text": "quiet"}`, map[string]string{"Authorization": "Bearer ci-token"})
+	if resp.StatusCode != http.StatusOK || !res.TimedOut || len(res.Replies) != 0 {
+		t.Fatalf("quiet request: %s, %+v", resp.Status, res)
+	}
+}
+
+func TestAsyncResultGoesToSignedCallback(t *testing.T) {
+	type delivery struct {
+		headers http.Header
+		body    []byte
+	}
+	deliveries := make(chan delivery, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{r.Header, body}
+	}))
+	defer callback.Close()
+
+	h := &testHandler{}
+	wc, srv := newTestConnector(t, h)
+	wc.clients["deploy"] = clientEntry{Name: "deploy", UserName: "deployer", HMACSecret: "shh", CallbackURL: callback.URL}
+	h.respond = func(m *robot.ConnectorMessage) {
+		time.Sleep(50 * time.Millisecond)
+		wc.SendProtocolUserMessage("deployer", "deployed", robot.Raw, m)
+	}
+
+	body := `{"Text": "deploy web", "Async": true}`
+	resp, accepted := post(t, srv.URL+"/webhook", body, signedHeaders("deploy", "shh", time.Now(), body))
+	if resp.StatusCode != http.StatusAccepted || accepted.ID == "" || len(accepted.Replies) != 0 {
+		t.Fatalf("async request: %s, %+v", resp.Status, accepted)
+	}
+	select {
+	case d := <-deliveries:
+		if d.headers.Get(signatureHeader) != sign("shh", d.headers.Get(timestampHeader), d.body) {
+			t.Fatalf("callback signature %q doesn't verify", d.headers.Get(signatureHeader))
+		}
+		var res result
+		if err := json.Unmarshal(d.body, &res); err != nil {
+			t.Fatalf("callback body: %v", err)
+		}
+		if res.ID != accepted.ID || len(res.Replies) != 1 || res.Replies[0].Text != "deployed" {
+			t.Fatalf("callback result = %+v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback never arrived")
+	}
+}
+
+func TestReloadSwapsClients(t *testing.T) {
+	h := &testHandler{protocolConfig: &config{Clients: []clientEntry{
+		{Name: "new", UserName: "newbie", Token: "t"},
+		{Name: "upper", UserName: "Upper", Token: "t2"},
+		{Name: "open", UserName: "open"},
+	}}}
+	wc, err := newConnector(h, config{Clients: testClients})
+	if err != nil {
+		t.Fatalf("newConnector: %v", err)
+	}
+	if err := wc.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(wc.clients) != 1 || wc.clients["new"].UserName != "newbie" {
+		t.Fatalf("clients after reload = %v, want only new", wc.clients)
+	}
+	if v, ret := wc.GetProtocolUserAttribute("newbie", "internalid"); ret != robot.Ok || v != "new" {
+		t.Fatalf("internalid = %q, %v", v, ret)
+	}
+	if _, ret := wc.GetProtocolUserAttribute("ci-runner", "internalid"); ret != robot.UserNotFound {
+		t.Fatalf("removed client lookup = %v, want UserNotFound", ret)
+	}
+}
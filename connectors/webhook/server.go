@@ -0,0 +1,328 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	clientHeader     = "X-Gopherbot-Client"
+	timestampHeader  = "X-Gopherbot-Timestamp"
+	signatureHeader  = "X-Gopherbot-Signature"
+	maxClockSkew     = 5 * time.Minute
+	callbackTimeout  = 30 * time.Second
+	callbackAttempts = 3
+	shutdownTimeout  = 5 * time.Second
+)
+
+var errUnauthenticated = errors.New("missing or invalid credentials")
+
+// hookRequest is the JSON body of a request.
+type hookRequest struct {
+	Text  string // the command, as a user would type it to the robot
+	Async bool   // respond at once and post the result to the client's CallbackURL
+}
+
+// reply is one message the robot sent in response to a request.
+type reply struct {
+	Channel string `json:",omitempty"`
+	Thread  string `json:",omitempty"`
+	User    string `json:",omitempty"`
+	Format  string
+	Text    string
+}
+
+// result is the response body for sync requests and the callback body for
+// async requests.
+type result struct {
+	ID       string
+	Replies  []reply
+	TimedOut bool // ReplyTimeout ended the wait rather than ReplyIdle
+}
+
+type pendingRequest struct {
+	sync.Mutex
+	replies []reply
+	notify  chan struct{} // signalled on each new reply
+}
+
+// Run serves requests until stop is closed.
+func (wc *webhookConnector) Run(stop <-chan struct{}) error {
+	ln, err := net.Listen("tcp", wc.listenAddr)
+	if err != nil {
+		return fmt.Errorf("webhook connector failed to listen on %s: %w", wc.listenAddr, err)
+	}
+	srv := &http.Server{Handler: wc, ReadHeaderTimeout: 10 * time.Second}
+	wc.Log(robot.Info, "Webhook connector listening on %s%s", ln.Addr(), wc.path)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	select {
+	case <-stop:
+		wc.Log(robot.Info, "Received stop in webhook connector")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+		return nil
+	case err := <-errc:
+		return fmt.Errorf("webhook server failed: %w", err)
+	}
+}
+
+func (wc *webhookConnector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != wc.path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, wc.maxBodyBytes))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	client, err := wc.authenticate(r, body)
+	if err != nil {
+		wc.Log(robot.Warn, "Rejected webhook request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req hookRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		http.Error(w, "Text is required", http.StatusBadRequest)
+		return
+	}
+	if req.Async && client.CallbackURL == "" {
+		http.Error(w, "async requests need a CallbackURL configured for the client", http.StatusBadRequest)
+		return
+	}
+
+	id := newID()
+	pr := &pendingRequest{notify: make(chan struct{}, 1)}
+	wc.pendingLock.Lock()
+	wc.pending[id] = pr
+	wc.pendingLock.Unlock()
+	wc.Log(robot.Debug, "Webhook client '%s' sent request %s", client.Name, id)
+	wc.IncomingMessage(&robot.ConnectorMessage{
+		Protocol:      "webhook",
+		UserID:        client.Name,
+		UserName:      client.UserName,
+		ValidatedUser: true,
+		ChannelName:   client.Channel,
+		ChannelID:     client.Channel,
+		DirectMessage: client.Channel == "",
+		// The request is always addressed to the robot.
+		BotMessage:    true,
+		MessageID:     id,
+		ThreadID:      id,
+		MessageText:   req.Text,
+		MessageObject: &req,
+	})
+
+	if req.Async {
+		go func() {
+			res := wc.collect(context.Background(), id, pr)
+			wc.postCallback(client, res)
+		}()
+		writeJSON(w, http.StatusAccepted, result{ID: id})
+		return
+	}
+	writeJSON(w, http.StatusOK, wc.collect(r.Context(), id, pr))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// authenticate accepts a bearer token, or a client name, timestamp and
+// HMAC-SHA256 signature of "<timestamp>.<body>".
+func (wc *webhookConnector) authenticate(r *http.Request, body []byte) (clientEntry, error) {
+	wc.RLock()
+	defer wc.RUnlock()
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		for _, c := range wc.clients {
+			if c.Token != "" && subtle.ConstantTimeCompare([]byte(c.Token), []byte(token)) == 1 {
+				return c, nil
+			}
+		}
+		return clientEntry{}, errUnauthenticated
+	}
+	c, ok := wc.clients[r.Header.Get(clientHeader)]
+	if !ok || c.HMACSecret == "" {
+		return clientEntry{}, errUnauthenticated
+	}
+	timestamp := r.Header.Get(timestampHeader)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return clientEntry{}, fmt.Errorf("%w: bad timestamp", errUnauthenticated)
+	}
+	now := wc.now()
+	if skew := now.Sub(time.Unix(ts, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return clientEntry{}, fmt.Errorf("%w: timestamp outside the allowed window", errUnauthenticated)
+	}
+	signature := r.Header.Get(signatureHeader)
+	if !hmac.Equal([]byte(signature), []byte(sign(c.HMACSecret, timestamp, body))) {
+		return clientEntry{}, fmt.Errorf("%w: bad signature for client '%s'", errUnauthenticated, c.Name)
+	}
+	if !wc.firstUse(signature, now) {
+		return clientEntry{}, fmt.Errorf("%w: replayed request for client '%s'", errUnauthenticated, c.Name)
+	}
+	return c, nil
+}
+
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// firstUse records a signature and reports whether it's new. Signatures
+// are kept as long as their timestamp would be accepted.
+func (wc *webhookConnector) firstUse(signature string, now time.Time) bool {
+	wc.pendingLock.Lock()
+	defer wc.pendingLock.Unlock()
+	for sig, seen := range wc.signatures {
+		if now.Sub(seen) > 2*maxClockSkew {
+			delete(wc.signatures, sig)
+		}
+	}
+	if _, seen := wc.signatures[signature]; seen {
+		return false
+	}
+	wc.signatures[signature] = now
+	return true
+}
+
+// collect gathers replies until ReplyIdle passes without one, ReplyTimeout
+// passes, or ctx ends; the idle wait only starts with the first reply.
+func (wc *webhookConnector) collect(ctx context.Context, id string, pr *pendingRequest) result {
+	timeout := time.NewTimer(wc.replyTimeout)
+	defer timeout.Stop()
+	var idle <-chan time.Time
+	timedOut := false
+wait:
+	for {
+		select {
+		case <-pr.notify:
+			idle = time.After(wc.replyIdle)
+		case <-idle:
+			break wait
+		case <-timeout.C:
+			timedOut = true
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	wc.pendingLock.Lock()
+	delete(wc.pending, id)
+	wc.pendingLock.Unlock()
+	pr.Lock()
+	defer pr.Unlock()
+	return result{ID: id, Replies: append([]reply{}, pr.replies...), TimedOut: timedOut}
+}
+
+func (wc *webhookConnector) addReply(msgObject *robot.ConnectorMessage, r reply) robot.RetVal {
+	if msgObject == nil || msgObject.Protocol != "webhook" {
+		wc.Log(robot.Warn, "Dropping webhook message with no originating request: %s", r.Text)
+		return robot.FailedMessageSend
+	}
+	wc.pendingLock.Lock()
+	pr, ok := wc.pending[msgObject.MessageID]
+	wc.pendingLock.Unlock()
+	if !ok {
+		wc.Log(robot.Warn, "Dropping webhook reply for request %s, which is no longer waiting: %s", msgObject.MessageID, r.Text)
+		return robot.FailedMessageSend
+	}
+	pr.Lock()
+	pr.replies = append(pr.replies, r)
+	pr.Unlock()
+	select {
+	case pr.notify <- struct{}{}:
+	default:
+	}
+	return robot.Ok
+}
+
+// postCallback delivers an async result, signed when the client has an
+// HMACSecret, retrying failures.
+func (wc *webhookConnector) postCallback(c clientEntry, res result) {
+	body, err := json.Marshal(res)
+	if err != nil {
+		wc.Log(robot.Error, "Encoding webhook result %s: %v", res.ID, err)
+		return
+	}
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err = wc.tryCallback(c, body)
+		if err == nil {
+			wc.Log(robot.Debug, "Posted webhook result %s to client '%s'", res.ID, c.Name)
+			return
+		}
+		if attempt == callbackAttempts {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	wc.Log(robot.Error, "Failed posting webhook result %s to client '%s' after %d attempts: %v", res.ID, c.Name, callbackAttempts, err)
+}
+
+func (wc *webhookConnector) tryCallback(c clientEntry, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.HMACSecret != "" {
+		timestamp := strconv.FormatInt(wc.now().Unix(), 10)
+		req.Header.Set(clientHeader, c.Name)
+		req.Header.Set(timestampHeader, timestamp)
+		req.Header.Set(signatureHeader, sign(c.HMACSecret, timestamp, body))
+	}
+	resp, err := wc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package webhook
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterConnector("webhook", Initialize)
+}
@@ -0,0 +1,354 @@
+// Package s3history stores plugin and job histories as objects in AWS S3 or
+// an S3-compatible store such as MinIO, and publishes them with presigned,
+// expiring URLs.
+package s3history
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/v2/internal/s3"
+	"github.com/lnxjedi/gopherbot/v2/modules/linebuffer"
+)
+
+// Same format as the file history provider's log.LstdFlags
+const timestampFormat = "2006/01/02 15:04:05"
+
+const logContentType = "text/plain; charset=utf-8"
+
+type historyConfig struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	PathStyle       bool
+	AccessKeyID     string
+	SecretAccessKey string
+	// How long links from MakeLogURL stay valid
+	URLExpirationSeconds    int
+	OperationTimeoutSeconds int
+	// Logs are kept in memory until they're uploaded; past BufferSize the
+	// oldest lines are dropped.
+	BufferSize, MaxLineLength int
+	Truncated                 string
+}
+
+// logger is the part of robot.Handler the provider needs after startup.
+type logger interface {
+	Log(l robot.LogLevel, m string, v ...interface{})
+}
+
+type s3History struct {
+	cfg    historyConfig
+	logger logger
+	client *s3.Client
+	sync.Mutex
+	// running logs, by object key, until they're finalized
+	running map[string]*runLog
+}
+
+type runLog struct {
+	h    *s3History
+	key  string
+	keep bool
+	lb   *linebuffer.Buffer
+	// serializes uploads from MakeLogURL and Close
+	sync.Mutex
+}
+
+func normalizeConfig(cfg historyConfig) (historyConfig, error) {
+	cfg.Endpoint = strings.TrimSpace(cfg.Endpoint)
+	cfg.Region = strings.TrimSpace(cfg.Region)
+	cfg.Bucket = strings.TrimSpace(cfg.Bucket)
+	cfg.Prefix = strings.TrimLeft(strings.TrimSpace(cfg.Prefix), "/")
+	cfg.AccessKeyID = strings.TrimSpace(cfg.AccessKeyID)
+	cfg.SecretAccessKey = strings.TrimSpace(cfg.SecretAccessKey)
+	if cfg.Bucket == "" {
+		return cfg, errors.New("Bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "gopherbot-history/"
+	}
+	if !strings.HasSuffix(cfg.Prefix, "/") {
+		cfg.Prefix += "/"
+	}
+	if cfg.AccessKeyID == "" && cfg.SecretAccessKey != "" {
+		return cfg, errors.New("SecretAccessKey is set but AccessKeyID is empty")
+	}
+	if cfg.AccessKeyID != "" && cfg.SecretAccessKey == "" {
+		return cfg, errors.New("AccessKeyID is set but SecretAccessKey is empty")
+	}
+	if cfg.URLExpirationSeconds <= 0 {
+		cfg.URLExpirationSeconds = 3600
+	}
+	if time.Duration(cfg.URLExpirationSeconds)*time.Second > s3.MaxPresignExpiry {
+		return cfg, fmt.Errorf("URLExpirationSeconds %d is longer than S3 allows (%d)", cfg.URLExpirationSeconds, int(s3.MaxPresignExpiry/time.Second))
+	}
+	if cfg.OperationTimeoutSeconds <= 0 {
+		cfg.OperationTimeoutSeconds = 15
+	}
+	if cfg.BufferSize < 4096 {
+		cfg.BufferSize = 1048576
+	}
+	if cfg.MaxLineLength < 1024 {
+		cfg.MaxLineLength = 16384
+	}
+	if cfg.MaxLineLength > cfg.BufferSize {
+		cfg.MaxLineLength = cfg.BufferSize
+	}
+	if cfg.Truncated == "" {
+		cfg.Truncated = "<... truncated>"
+	}
+	return cfg, nil
+}
+
+func provider(r robot.Handler) robot.HistoryProvider {
+	var cfg historyConfig
+	if err := r.GetHistoryConfig(&cfg); err != nil {
+		r.Log(robot.Error, "Unable to retrieve S3 history configuration: %v", err)
+		return nil
+	}
+	cfg, err := normalizeConfig(cfg)
+	if err != nil {
+		r.Log(robot.Error, "Invalid S3 history configuration: %v", err)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.OperationTimeoutSeconds)*time.Second)
+	defer cancel()
+	creds, err := s3.LoadCredentials(ctx, cfg.Region, cfg.AccessKeyID, cfg.SecretAccessKey)
+	if err != nil {
+		r.Log(robot.Error, "Unable to load AWS credentials for S3 history: %v", err)
+		return nil
+	}
+	h, err := newS3History(cfg, r, creds)
+	if err != nil {
+		r.Log(robot.Error, "Creating S3 history client: %v", err)
+		return nil
+	}
+	if _, _, err := h.client.List(ctx, cfg.Prefix, "", 1); err != nil {
+		r.Log(robot.Error, "Validating S3 history bucket '%s': %v", h.client.Location(), err)
+		return nil
+	}
+	r.Log(robot.Info, "Initialized S3 history provider in '%s' with prefix '%s'", h.client.Location(), cfg.Prefix)
+	return h
+}
+
+func newS3History(cfg historyConfig, logger logger, creds aws.CredentialsProvider) (*s3History, error) {
+	client, err := s3.New(s3.Config{
+		Endpoint:  cfg.Endpoint,
+		Region:    cfg.Region,
+		Bucket:    cfg.Bucket,
+		PathStyle: cfg.PathStyle,
+	}, creds, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &s3History{
+		cfg:     cfg,
+		logger:  logger,
+		client:  client,
+		running: make(map[string]*runLog),
+	}, nil
+}
+
+func (h *s3History) timeoutContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(h.cfg.OperationTimeoutSeconds) * time.Second
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// tagPrefix is where a pipeline's logs live; as with the file provider,
+// slashes in the tag don't make deeper paths.
+func (h *s3History) tagPrefix(tag string) string {
+	tag = strings.NewReplacer(`\`, ":", "/", ":").Replace(tag)
+	return h.cfg.Prefix + tag + "/"
+}
+
+func (h *s3History) objectKey(tag string, index int) string {
+	return h.tagPrefix(tag) + fmt.Sprintf("run-%d.log", index)
+}
+
+// runIndex returns the index from a log's object key.
+func runIndex(key string) (int, bool) {
+	name := strings.TrimSuffix(strings.TrimPrefix(path.Base(key), "run-"), ".log")
+	idx, err := strconv.Atoi(name)
+	return idx, err == nil
+}
+
+// NewLog starts a log that's kept in memory until Close uploads it, and
+// removes uploaded logs at least maxHistories runs older.
+func (h *s3History) NewLog(tag string, index, maxHistories int) (robot.HistoryLogger, error) {
+	key := h.objectKey(tag, index)
+	l := &runLog{
+		h:    h,
+		key:  key,
+		keep: maxHistories != 0,
+		lb:   linebuffer.New(h.cfg.BufferSize, h.cfg.MaxLineLength, h.cfg.Truncated),
+	}
+	h.Lock()
+	h.running[key] = l
+	h.Unlock()
+	if maxHistories > 0 {
+		if err := h.prune(tag, index-maxHistories); err != nil {
+			h.logger.Log(robot.Error, "Removing old S3 histories for '%s': %v", tag, err)
+		}
+	}
+	return l, nil
+}
+
+// prune deletes the uploaded logs for tag with an index <= oldest.
+func (h *s3History) prune(tag string, oldest int) error {
+	if oldest < 0 {
+		return nil
+	}
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+	var stale []string
+	token := ""
+	for {
+		keys, next, err := h.client.List(ctx, h.tagPrefix(tag), token, 1000)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if idx, ok := runIndex(key); ok && idx <= oldest {
+				stale = append(stale, key)
+			}
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	for _, key := range stale {
+		if err := h.client.Delete(ctx, key); err != nil {
+			return fmt.Errorf("deleting '%s': %w", key, err)
+		}
+	}
+	return nil
+}
+
+// GetLog returns a running log from memory, or an uploaded log from the
+// bucket.
+func (h *s3History) GetLog(tag string, index int) (io.Reader, error) {
+	key := h.objectKey(tag, index)
+	h.Lock()
+	l, ok := h.running[key]
+	h.Unlock()
+	if ok {
+		return l.reader(), nil
+	}
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+	obj, exists, err := h.client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no history for '%s', run %d", tag, index)
+	}
+	return bytes.NewReader(obj.Body), nil
+}
+
+// GetLogURL - objects in the bucket have no permanent link
+func (h *s3History) GetLogURL(tag string, index int) (string, bool) {
+	return "", false
+}
+
+// MakeLogURL returns a presigned link to a kept log, uploading what's been
+// logged so far if the run is still going.
+func (h *s3History) MakeLogURL(tag string, index int) (string, bool) {
+	key := h.objectKey(tag, index)
+	h.Lock()
+	l, running := h.running[key]
+	h.Unlock()
+	if running {
+		if !l.keep {
+			return "", false
+		}
+		if err := l.upload(); err != nil {
+			h.logger.Log(robot.Error, "Uploading history '%s' for a link: %v", key, err)
+			return "", false
+		}
+	}
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+	if !running {
+		_, exists, err := h.client.Head(ctx, key)
+		if err != nil {
+			h.logger.Log(robot.Error, "Checking for history '%s': %v", key, err)
+			return "", false
+		}
+		if !exists {
+			return "", false
+		}
+	}
+	u, err := h.client.Presign(ctx, key, time.Duration(h.cfg.URLExpirationSeconds)*time.Second)
+	if err != nil {
+		h.logger.Log(robot.Error, "Creating a link for history '%s': %v", key, err)
+		return "", false
+	}
+	return u, true
+}
+
+// Log writes a timestamped line to the log
+func (l *runLog) Log(line string) {
+	l.lb.WriteLine(time.Now().Format(timestampFormat) + " " + line)
+}
+
+// Line writes a plain line to the log
+func (l *runLog) Line(line string) {
+	l.lb.WriteLine(line)
+}
+
+func (l *runLog) reader() io.Reader {
+	r, err := l.lb.Reader()
+	if err != nil {
+		return l.lb.Snapshot()
+	}
+	return r
+}
+
+// upload stores the log's contents so far in the bucket.
+func (l *runLog) upload() error {
+	body, err := io.ReadAll(l.reader())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := l.h.timeoutContext()
+	defer cancel()
+	l.Lock()
+	defer l.Unlock()
+	_, err = l.h.client.Put(ctx, l.key, body, nil, s3.PutOptions{ContentType: logContentType})
+	return err
+}
+
+// Close ends the log and uploads it if it's being kept
+func (l *runLog) Close() {
+	l.lb.Close()
+	if !l.keep {
+		return
+	}
+	if err := l.upload(); err != nil {
+		l.h.logger.Log(robot.Error, "Uploading history '%s': %v", l.key, err)
+	}
+}
+
+// Finalize releases the in-memory log; unkept logs are never uploaded.
+func (l *runLog) Finalize() {
+	l.h.Lock()
+	delete(l.h.running, l.key)
+	l.h.Unlock()
+}
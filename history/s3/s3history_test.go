@@ -0,0 +1,258 @@
+package s3history
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type fakeObject struct {
+	body        []byte
+	contentType string
+}
+
+// fakeS3 is a minimal path-style S3 stand-in that accepts header-signed
+// requests and presigned GETs.
+type fakeS3 struct {
+	sync.Mutex
+	bucket  string
+	objects map[string]fakeObject
+}
+
+func (f *fakeS3) authorized(r *http.Request) bool {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+		return true
+	}
+	q := r.URL.Query()
+	return r.Method == http.MethodGet && strings.HasPrefix(q.Get("X-Amz-Credential"), "AKIDTEST/") &&
+		q.Get("X-Amz-Signature") != "" && q.Get("X-Amz-Expires") != ""
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if !f.authorized(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == f.bucket && r.Method == http.MethodGet {
+		f.list(w, r)
+		return
+	}
+	key, ok := strings.CutPrefix(path, f.bucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	obj, exists := f.objects[key]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.body)
+		}
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = fakeObject{body: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	type content struct{ Key string }
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []content
+	}{}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{Key: key})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) keys() []string {
+	f.Lock()
+	defer f.Unlock()
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type testLogger struct{}
+
+func (testLogger) Log(robot.LogLevel, string, ...interface{}) {}
+
+func newTestHistory(t *testing.T) (*s3History, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{bucket: "robots", objects: make(map[string]fakeObject)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	cfg, err := normalizeConfig(historyConfig{
+		Endpoint:             server.URL,
+		Bucket:               "robots",
+		PathStyle:            true,
+		URLExpirationSeconds: 120,
+	})
+	if err != nil {
+		t.Fatalf("normalizeConfig() error = %v", err)
+	}
+	h, err := newS3History(cfg, testLogger{}, credentials.NewStaticCredentialsProvider("AKIDTEST", "secret", ""))
+	if err != nil {
+		t.Fatalf("newS3History() error = %v", err)
+	}
+	return h, fake
+}
+
+func readLog(t *testing.T, h *s3History, tag string, index int) string {
+	t.Helper()
+	r, err := h.GetLog(tag, index)
+	if err != nil {
+		t.Fatalf("GetLog(%q, %d) error = %v", tag, index, err)
+	}
+	b, _ := io.ReadAll(r)
+	return string(b)
+}
+
+func TestNormalizeConfig(t *testing.T) {
+	cfg, err := normalizeConfig(historyConfig{Bucket: " logs ", Prefix: "/robot"})
+	if err != nil {
+		t.Fatalf("normalizeConfig() error = %v", err)
+	}
+	if cfg.Bucket != "logs" || cfg.Prefix != "robot/" || cfg.Region != "us-east-1" || cfg.URLExpirationSeconds != 3600 {
+		t.Fatalf("normalizeConfig() = %+v, want trimmed bucket and prefix with defaults", cfg)
+	}
+	for _, bad := range []historyConfig{
+		{},
+		{Bucket: "logs", AccessKeyID: "AKID"},
+		{Bucket: "logs", URLExpirationSeconds: 8 * 24 * 3600},
+	} {
+		if _, err := normalizeConfig(bad); err == nil {
+			t.Fatalf("normalizeConfig(%+v) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestLogsUploadOnCloseAndKeepMaxHistories(t *testing.T) {
+	h, fake := newTestHistory(t)
+
+	for i := 0; i < 4; i++ {
+		l, err := h.NewLog("deploy/prod", i, 2)
+		if err != nil {
+			t.Fatalf("NewLog() error = %v", err)
+		}
+		l.Line("*** start")
+		l.Log("OUT deploying")
+		if got := readLog(t, h, "deploy/prod", i); !strings.Contains(got, "OUT deploying") {
+			t.Fatalf("GetLog() while running = %q, want the output so far", got)
+		}
+		l.Close()
+		l.Finalize()
+	}
+	want := []string{"gopherbot-history/deploy:prod/run-2.log", "gopherbot-history/deploy:prod/run-3.log"}
+	if got := fake.keys(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("bucket keys = %v, want %v", got, want)
+	}
+	if ct := fake.objects[want[1]].contentType; !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q, want text/plain", ct)
+	}
+	got := readLog(t, h, "deploy/prod", 3)
+	if !strings.HasPrefix(got, "*** start\n") || !strings.HasSuffix(got, " OUT deploying\n") {
+		t.Fatalf("GetLog() after Finalize() = %q, want the uploaded log", got)
+	}
+	if _, err := h.GetLog("deploy/prod", 0); err == nil {
+		t.Fatal("GetLog() found run 0, want it removed with maxHistories 2")
+	}
+
+	l, _ := h.NewLog("ping", 9, 0)
+	l.Log("pong")
+	l.Close()
+	l.Finalize()
+	if len(fake.keys()) != 2 {
+		t.Fatalf("bucket keys = %v, want unkept logs left out", fake.keys())
+	}
+}
+
+func TestMakeLogURLPresignsKeptLogs(t *testing.T) {
+	h, _ := newTestHistory(t)
+
+	l, _ := h.NewLog("nightly", 5, 10)
+	l.Log("first half")
+	link, ok := h.MakeLogURL("nightly", 5)
+	if !ok {
+		t.Fatal("MakeLogURL() for a running kept log failed")
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parsing link %q: %v", link, err)
+	}
+	if u.Query().Get("X-Amz-Expires") != "120" || u.Query().Get("X-Amz-Signature") == "" {
+		t.Fatalf("link %q isn't presigned for 120 seconds", link)
+	}
+	fetch := func() string {
+		t.Helper()
+		resp, err := http.Get(link)
+		if err != nil {
+			t.Fatalf("fetching link: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("fetching link: status %d", resp.StatusCode)
+		}
+		return string(body)
+	}
+	if got := fetch(); !strings.Contains(got, "first half") {
+		t.Fatalf("link body = %q, want the output so far", got)
+	}
+	l.Log("second half")
+	l.Close()
+	l.Finalize()
+	if got := fetch(); !strings.Contains(got, "second half") {
+		t.Fatalf("link body = %q, want the whole log after Close()", got)
+	}
+	if _, ok := h.MakeLogURL("nightly", 5); !ok {
+		t.Fatal("MakeLogURL() for a finished log failed")
+	}
+
+	if _, ok := h.MakeLogURL("nightly", 6); ok {
+		t.Fatal("MakeLogURL() for a missing log succeeded")
+	}
+	unkept, _ := h.NewLog("ping", 1, 0)
+	if _, ok := h.MakeLogURL("ping", 1); ok {
+		t.Fatal("MakeLogURL() for an unkept log succeeded")
+	}
+	unkept.Close()
+	unkept.Finalize()
+}
@@ -0,0 +1,7 @@
+package s3history
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterHistoryProvider("s3", provider)
+}
@@ -0,0 +1,418 @@
+// Package sqlitehistory stores plugin and job histories in a local SQLite
+// database, along with per-run metadata that can be searched; see
+// robot.RunSearcher.
+package sqlitehistory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	_ "modernc.org/sqlite"
+)
+
+// Same format as the file history provider's log.LstdFlags
+const timestampFormat = "2006/01/02 15:04:05"
+
+// flushLines is how many lines a log buffers before writing them out
+const flushLines = 32
+
+const defaultSearchLimit = 20
+
+type historyConfig struct {
+	Path                    string // database file, created if missing
+	JournalMode             string // WAL for local disks, DELETE for NFS
+	BusyTimeoutMillis       int
+	OperationTimeoutSeconds int
+}
+
+type sqliteHistory struct {
+	cfg historyConfig
+	db  *sql.DB
+	log func(l robot.LogLevel, m string, v ...interface{})
+}
+
+type runLog struct {
+	h    *sqliteHistory
+	tag  string
+	idx  int
+	keep bool
+	sync.Mutex
+	pending []string
+	seq     int
+	closed  bool
+}
+
+func defaultedConfig(cfg historyConfig) (historyConfig, error) {
+	cfg.Path = strings.TrimSpace(cfg.Path)
+	cfg.JournalMode = strings.ToUpper(strings.TrimSpace(cfg.JournalMode))
+	if cfg.Path == "" {
+		cfg.Path = "state/history.sqlite"
+	}
+	if cfg.JournalMode == "" {
+		cfg.JournalMode = "WAL"
+	}
+	if cfg.BusyTimeoutMillis <= 0 {
+		cfg.BusyTimeoutMillis = 5000
+	}
+	if cfg.OperationTimeoutSeconds <= 0 {
+		cfg.OperationTimeoutSeconds = 15
+	}
+	switch cfg.JournalMode {
+	case "WAL", "DELETE", "TRUNCATE", "PERSIST":
+	default:
+		return cfg, fmt.Errorf("invalid JournalMode %q, must be one of WAL, DELETE, TRUNCATE or PERSIST", cfg.JournalMode)
+	}
+	return cfg, nil
+}
+
+func dataSourceName(cfg historyConfig) string {
+	q := url.Values{}
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cfg.BusyTimeoutMillis))
+	q.Add("_pragma", "journal_mode("+cfg.JournalMode+")")
+	q.Set("_txlock", "immediate")
+	return cfg.Path + "?" + q.Encode()
+}
+
+const schema = `CREATE TABLE IF NOT EXISTS gopherbot_runs (
+	tag TEXT NOT NULL,
+	idx INTEGER NOT NULL,
+	ref TEXT NOT NULL DEFAULT '',
+	started_by TEXT NOT NULL DEFAULT '',
+	channel TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL DEFAULT '',
+	args TEXT NOT NULL DEFAULT '[]',
+	tasks TEXT NOT NULL DEFAULT '[]',
+	started INTEGER NOT NULL,
+	finished INTEGER NOT NULL DEFAULT 0,
+	status INTEGER NOT NULL DEFAULT 0,
+	keep INTEGER NOT NULL,
+	PRIMARY KEY (tag, idx)
+);
+CREATE INDEX IF NOT EXISTS gopherbot_runs_started ON gopherbot_runs (tag, started);
+CREATE INDEX IF NOT EXISTS gopherbot_runs_ref ON gopherbot_runs (ref);
+CREATE TABLE IF NOT EXISTS gopherbot_run_lines (
+	tag TEXT NOT NULL,
+	idx INTEGER NOT NULL,
+	seq INTEGER NOT NULL,
+	line TEXT NOT NULL,
+	PRIMARY KEY (tag, idx, seq)
+);`
+
+func openSQLiteHistory(cfg historyConfig) (*sqliteHistory, error) {
+	if dir := filepath.Dir(cfg.Path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("creating directory for '%s': %w", cfg.Path, err)
+		}
+	}
+	db, err := sql.Open("sqlite", dataSourceName(cfg))
+	if err != nil {
+		return nil, err
+	}
+	// As with the SQLite brain, one connection serializes writers.
+	db.SetMaxOpenConns(1)
+	h := &sqliteHistory{cfg: cfg, db: db, log: func(robot.LogLevel, string, ...interface{}) {}}
+
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("creating history tables: %w", err)
+	}
+	return h, nil
+}
+
+func (h *sqliteHistory) timeoutContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(h.cfg.OperationTimeoutSeconds) * time.Second
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// NewLog starts a run, replacing any earlier run with the same index, and
+// removes the oldest runs past maxHistories.
+func (h *sqliteHistory) NewLog(tag string, index, maxHistories int) (robot.HistoryLogger, error) {
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("starting history for '%s': %w", tag, err)
+	}
+	defer tx.Rollback()
+	keep := maxHistories != 0
+	queries := []string{
+		`DELETE FROM gopherbot_run_lines WHERE tag = ? AND idx = ?`,
+		`DELETE FROM gopherbot_runs WHERE tag = ? AND idx = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, tag, index); err != nil {
+			return nil, fmt.Errorf("starting history for '%s': %w", tag, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO gopherbot_runs (tag, idx, started, keep) VALUES (?, ?, ?, ?)`,
+		tag, index, time.Now().UnixMilli(), keep); err != nil {
+		return nil, fmt.Errorf("starting history for '%s': %w", tag, err)
+	}
+	if maxHistories > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM gopherbot_runs WHERE tag = ? AND keep = 1 AND idx NOT IN
+	(SELECT idx FROM gopherbot_runs WHERE tag = ? AND keep = 1 ORDER BY started DESC, idx DESC LIMIT ?)`,
+			tag, tag, maxHistories); err != nil {
+			return nil, fmt.Errorf("removing old histories for '%s': %w", tag, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM gopherbot_run_lines WHERE tag = ? AND idx NOT IN
+	(SELECT idx FROM gopherbot_runs WHERE tag = ?)`, tag, tag); err != nil {
+			return nil, fmt.Errorf("removing old histories for '%s': %w", tag, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("starting history for '%s': %w", tag, err)
+	}
+	return &runLog{h: h, tag: tag, idx: index, keep: keep}, nil
+}
+
+// GetLog returns the stored output for a run.
+func (h *sqliteHistory) GetLog(tag string, index int) (io.Reader, error) {
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+	var n int
+	if err := h.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM gopherbot_runs WHERE tag = ? AND idx = ?`, tag, index).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("no history for '%s', run %d", tag, index)
+	}
+	rows, err := h.db.QueryContext(ctx, `SELECT line FROM gopherbot_run_lines WHERE tag = ? AND idx = ? ORDER BY seq`, tag, index)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var buf bytes.Buffer
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// GetLogURL - histories in the database have no URL
+func (h *sqliteHistory) GetLogURL(tag string, index int) (string, bool) {
+	return "", false
+}
+
+// MakeLogURL - histories in the database have no URL
+func (h *sqliteHistory) MakeLogURL(tag string, index int) (string, bool) {
+	return "", false
+}
+
+// SearchRuns returns the kept runs matching q, most recent first.
+func (h *sqliteHistory) SearchRuns(q robot.RunQuery) ([]robot.RunInfo, error) {
+	where := []string{"keep = 1"}
+	var args []interface{}
+	if q.Tag != "" {
+		where = append(where, "tag = ?")
+		args = append(args, q.Tag)
+	}
+	if q.Ref != "" {
+		where = append(where, "ref = ?")
+		args = append(args, q.Ref)
+	}
+	if q.Failed {
+		where = append(where, "finished != 0 AND status != ?")
+		args = append(args, int(robot.Normal))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "started >= ?")
+		args = append(args, q.Since.UnixMilli())
+	}
+	if q.Text != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM gopherbot_run_lines l
+	WHERE l.tag = gopherbot_runs.tag AND l.idx = gopherbot_runs.idx AND l.line LIKE ? ESCAPE '\')`)
+		args = append(args, "%"+likeEscaper.Replace(q.Text)+"%")
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	args = append(args, limit)
+
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `SELECT tag, idx, ref, started_by, channel, source, args, tasks, started, finished, status
+FROM gopherbot_runs WHERE `+strings.Join(where, " AND ")+` ORDER BY started DESC, idx DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []robot.RunInfo
+	for rows.Next() {
+		var (
+			ri                robot.RunInfo
+			runArgs, tasks    string
+			started, finished int64
+			status            int
+		)
+		if err := rows.Scan(&ri.Tag, &ri.Index, &ri.Ref, &ri.User, &ri.Channel, &ri.Trigger, &runArgs, &tasks, &started, &finished, &status); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(runArgs), &ri.Args); err != nil {
+			return nil, fmt.Errorf("decoding args for '%s', run %d: %w", ri.Tag, ri.Index, err)
+		}
+		if err := json.Unmarshal([]byte(tasks), &ri.Tasks); err != nil {
+			return nil, fmt.Errorf("decoding tasks for '%s', run %d: %w", ri.Tag, ri.Index, err)
+		}
+		ri.Started = time.UnixMilli(started)
+		if finished != 0 {
+			ri.Finished = time.UnixMilli(finished)
+		}
+		ri.Status = robot.TaskRetVal(status)
+		runs = append(runs, ri)
+	}
+	return runs, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Log stores a timestamped line of output
+func (l *runLog) Log(line string) {
+	l.add(time.Now().Format(timestampFormat) + " " + line)
+}
+
+// Line stores a line without a timestamp
+func (l *runLog) Line(line string) {
+	l.add(line)
+}
+
+func (l *runLog) add(line string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return
+	}
+	l.pending = append(l.pending, line)
+	if len(l.pending) >= flushLines {
+		l.flush()
+	}
+}
+
+// flush writes pending lines; caller holds the lock.
+func (l *runLog) flush() {
+	if len(l.pending) == 0 {
+		return
+	}
+	ctx, cancel := l.h.timeoutContext()
+	defer cancel()
+	err := func() error {
+		tx, err := l.h.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for _, line := range l.pending {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO gopherbot_run_lines (tag, idx, seq, line) VALUES (?, ?, ?, ?)`, l.tag, l.idx, l.seq, line); err != nil {
+				return err
+			}
+			l.seq++
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		l.h.log(robot.Error, "Writing history for '%s', run %d: %v", l.tag, l.idx, err)
+	}
+	l.pending = l.pending[:0]
+}
+
+// Close writes any buffered output and stops further writes
+func (l *runLog) Close() {
+	l.Lock()
+	defer l.Unlock()
+	l.flush()
+	l.closed = true
+}
+
+// Finalize removes the run if it isn't being kept
+func (l *runLog) Finalize() {
+	l.Close()
+	if l.keep {
+		return
+	}
+	ctx, cancel := l.h.timeoutContext()
+	defer cancel()
+	for _, query := range []string{
+		`DELETE FROM gopherbot_run_lines WHERE tag = ? AND idx = ?`,
+		`DELETE FROM gopherbot_runs WHERE tag = ? AND idx = ?`,
+	} {
+		if _, err := l.h.db.ExecContext(ctx, query, l.tag, l.idx); err != nil {
+			l.h.log(robot.Error, "Removing history for '%s', run %d: %v", l.tag, l.idx, err)
+			return
+		}
+	}
+}
+
+// SetRunInfo stores the metadata for the run
+func (l *runLog) SetRunInfo(info robot.RunInfo) {
+	args, _ := json.Marshal(nonNil(info.Args))
+	tasks, _ := json.Marshal(nonNil(info.Tasks))
+	var finished int64
+	if !info.Finished.IsZero() {
+		finished = info.Finished.UnixMilli()
+	}
+	started := info.Started
+	if started.IsZero() {
+		started = time.Now()
+	}
+	ctx, cancel := l.h.timeoutContext()
+	defer cancel()
+	_, err := l.h.db.ExecContext(ctx, `UPDATE gopherbot_runs SET ref = ?, started_by = ?, channel = ?, source = ?, args = ?, tasks = ?,
+	started = ?, finished = ?, status = ? WHERE tag = ? AND idx = ?`,
+		info.Ref, info.User, info.Channel, info.Trigger, string(args), string(tasks),
+		started.UnixMilli(), finished, int(info.Status), l.tag, l.idx)
+	if err != nil {
+		l.h.log(robot.Error, "Recording run information for '%s', run %d: %v", l.tag, l.idx, err)
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func provider(r robot.Handler) robot.HistoryProvider {
+	var cfg historyConfig
+	if err := r.GetHistoryConfig(&cfg); err != nil {
+		r.Log(robot.Error, "Unable to retrieve SQLite history configuration: %v", err)
+		return nil
+	}
+	cfg, err := defaultedConfig(cfg)
+	if err != nil {
+		r.Log(robot.Error, "Invalid SQLite history configuration: %v", err)
+		return nil
+	}
+	h, err := openSQLiteHistory(cfg)
+	if err != nil {
+		r.Log(robot.Error, "Opening SQLite history database '%s': %v", cfg.Path, err)
+		return nil
+	}
+	h.log = r.Log
+	r.Log(robot.Info, "Initialized SQLite history provider with database '%s'", cfg.Path)
+	return h
+}
+
+var _ robot.RunSearcher = (*sqliteHistory)(nil)
+var _ robot.RunLogger = (*runLog)(nil)
@@ -0,0 +1,183 @@
+package sqlitehistory
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func openTestHistory(t *testing.T) *sqliteHistory {
+	t.Helper()
+	cfg, err := defaultedConfig(historyConfig{Path: filepath.Join(t.TempDir(), "history", "history.sqlite")})
+	if err != nil {
+		t.Fatalf("defaultedConfig() error = %v", err)
+	}
+	h, err := openSQLiteHistory(cfg)
+	if err != nil {
+		t.Fatalf("openSQLiteHistory() error = %v", err)
+	}
+	t.Cleanup(func() { h.db.Close() })
+	return h
+}
+
+func readLog(t *testing.T, h *sqliteHistory, tag string, index int) string {
+	t.Helper()
+	r, err := h.GetLog(tag, index)
+	if err != nil {
+		t.Fatalf("GetLog(%q, %d) error = %v", tag, index, err)
+	}
+	b, _ := io.ReadAll(r)
+	return string(b)
+}
+
+func TestDefaultedConfig(t *testing.T) {
+	cfg, err := defaultedConfig(historyConfig{JournalMode: " delete "})
+	if err != nil {
+		t.Fatalf("defaultedConfig() error = %v", err)
+	}
+	if cfg.Path != "state/history.sqlite" || cfg.JournalMode != "DELETE" {
+		t.Fatalf("defaultedConfig() = %+v, want default path with DELETE journal", cfg)
+	}
+	if _, err := defaultedConfig(historyConfig{JournalMode: "OFF"}); err == nil {
+		t.Fatal("defaultedConfig() accepted journal mode OFF")
+	}
+}
+
+func TestLogsAreStoredPrunedAndFinalized(t *testing.T) {
+	h := openTestHistory(t)
+
+	for i := 0; i < 3; i++ {
+		l, err := h.NewLog("deploy", i, 2)
+		if err != nil {
+			t.Fatalf("NewLog() error = %v", err)
+		}
+		l.Line("*** start")
+		for n := 0; n < flushLines+5; n++ {
+			l.Log("OUT line")
+		}
+		l.Close()
+		l.Log("after close")
+		l.Finalize()
+	}
+	if _, err := h.GetLog("deploy", 0); err == nil {
+		t.Fatal("GetLog() found run 0, want it pruned with maxHistories 2")
+	}
+	out := readLog(t, h, "deploy", 2)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != flushLines+6 || lines[0] != "*** start" || !strings.HasSuffix(lines[1], " OUT line") {
+		t.Fatalf("GetLog() = %d lines starting %q, want start line and %d timestamped lines", len(lines), lines[0], flushLines+5)
+	}
+	if strings.Contains(out, "after close") {
+		t.Fatal("GetLog() includes a line logged after Close()")
+	}
+
+	l, err := h.NewLog("ping", 7, 0)
+	if err != nil {
+		t.Fatalf("NewLog() error = %v", err)
+	}
+	l.Log("pong")
+	l.Close()
+	if out := readLog(t, h, "ping", 7); !strings.Contains(out, "pong") {
+		t.Fatalf("GetLog() before Finalize() = %q, want the output", out)
+	}
+	l.Finalize()
+	if _, err := h.GetLog("ping", 7); err == nil {
+		t.Fatal("GetLog() found an unkept run after Finalize()")
+	}
+}
+
+func TestSearchRuns(t *testing.T) {
+	h := openTestHistory(t)
+	now := time.Now()
+
+	runs := []struct {
+		index  int
+		age    time.Duration
+		status robot.TaskRetVal
+		output string
+	}{
+		{1, 10 * 24 * time.Hour, robot.Fail, "connection refused"},
+		{2, 3 * 24 * time.Hour, robot.Fail, "disk 100% full"},
+		{3, 2 * 24 * time.Hour, robot.Normal, "deployed"},
+		{4, time.Hour, robot.Normal, "Connection Refused, retrying"},
+	}
+	for _, r := range runs {
+		l, err := h.NewLog("deploy", r.index, 10)
+		if err != nil {
+			t.Fatalf("NewLog() error = %v", err)
+		}
+		info := robot.RunInfo{
+			Tag:     "deploy",
+			Index:   r.index,
+			Ref:     "ref" + string(rune('0'+r.index)),
+			User:    "alice",
+			Channel: "ops",
+			Trigger: "run",
+			Args:    []string{"prod"},
+			Started: now.Add(-r.age),
+		}
+		l.(robot.RunLogger).SetRunInfo(info)
+		l.Log(r.output)
+		l.Close()
+		info.Tasks = []string{"deploy", "notify"}
+		info.Finished = info.Started.Add(time.Minute)
+		info.Status = r.status
+		l.(robot.RunLogger).SetRunInfo(info)
+		l.Finalize()
+	}
+	running, err := h.NewLog("deploy", 5, 10)
+	if err != nil {
+		t.Fatalf("NewLog() error = %v", err)
+	}
+	running.(robot.RunLogger).SetRunInfo(robot.RunInfo{Tag: "deploy", Index: 5, Started: now})
+
+	indexes := func(q robot.RunQuery) []int {
+		t.Helper()
+		found, err := h.SearchRuns(q)
+		if err != nil {
+			t.Fatalf("SearchRuns(%+v) error = %v", q, err)
+		}
+		var idx []int
+		for _, r := range found {
+			idx = append(idx, r.Index)
+		}
+		return idx
+	}
+	check := func(name string, got []int, want ...int) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got runs %v, want %v", name, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: got runs %v, want %v", name, got, want)
+			}
+		}
+	}
+
+	check("all", indexes(robot.RunQuery{Tag: "deploy"}), 5, 4, 3, 2, 1)
+	check("failed in the last week", indexes(robot.RunQuery{Tag: "deploy", Failed: true, Since: now.Add(-7 * 24 * time.Hour)}), 2)
+	check("text", indexes(robot.RunQuery{Tag: "deploy", Text: "connection refused"}), 4, 1)
+	check("literal percent", indexes(robot.RunQuery{Text: "100%"}), 2)
+	check("wildcard underscore", indexes(robot.RunQuery{Text: "_"}))
+	check("ref", indexes(robot.RunQuery{Ref: "ref3"}), 3)
+	check("limit", indexes(robot.RunQuery{Tag: "deploy", Limit: 2}), 5, 4)
+
+	found, err := h.SearchRuns(robot.RunQuery{Ref: "ref2"})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("SearchRuns() = %v, %v; want one run", found, err)
+	}
+	got := found[0]
+	if got.User != "alice" || got.Channel != "ops" || got.Trigger != "run" || got.Status != robot.Fail ||
+		len(got.Args) != 1 || len(got.Tasks) != 2 || got.Finished.Sub(got.Started) != time.Minute {
+		t.Fatalf("SearchRuns() = %+v, want the recorded metadata", got)
+	}
+	found, _ = h.SearchRuns(robot.RunQuery{Tag: "deploy", Limit: 1})
+	if len(found) != 1 || !found[0].Finished.IsZero() || found[0].Args == nil {
+		t.Fatalf("SearchRuns() = %+v, want an unfinished run with empty args", found)
+	}
+}
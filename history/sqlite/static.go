@@ -0,0 +1,7 @@
+package sqlitehistory
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterHistoryProvider("sqlite", provider)
+}
@@ -0,0 +1,94 @@
+// Package modpath loads shared library modules for the embedded Lua and
+// JavaScript interpreters. The bundled and config lib directories are read
+// as they always were; directories added with robot.yaml ModulePaths are
+// "strict": files under them must resolve inside the directory, and neither
+// the file nor its directory may be group or world writable.
+package modpath
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Contains reports whether path lies lexically within one of roots.
+func Contains(roots []string, path string) bool {
+	return rootFor(roots, path) != ""
+}
+
+// ReadFile returns the contents of the module file at path, applying the
+// strict checks when path lies under one of strict. Missing files and
+// directories return an error wrapping fs.ErrNotExist, so callers can keep
+// searching; any other error means the file exists but may not be loaded.
+func ReadFile(strict []string, path string) ([]byte, error) {
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("module %s is a directory: %w", path, fs.ErrNotExist)
+	}
+	root := rootFor(strict, path)
+	if root == "" {
+		return os.ReadFile(path)
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	if !within(realRoot, realPath) {
+		return nil, fmt.Errorf("module %s resolves outside search path directory %s", path, root)
+	}
+	if err := checkPerms(realPath, info); err != nil {
+		return nil, err
+	}
+	dirInfo, err := os.Stat(filepath.Dir(realPath))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPerms(filepath.Dir(realPath), dirInfo); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(realPath)
+}
+
+// checkPerms refuses anything other than regular files and directories, and
+// anything group/world writable.
+func checkPerms(path string, info fs.FileInfo) error {
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return fmt.Errorf("module path %s is not a regular file", path)
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("module path %s is group/world writable", path)
+	}
+	return nil
+}
+
+// rootFor returns the first root containing path, or "".
+func rootFor(roots []string, path string) string {
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		root = filepath.Clean(root)
+		if within(root, path) {
+			return root
+		}
+	}
+	return ""
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
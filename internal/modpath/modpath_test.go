@@ -0,0 +1,87 @@
+package modpath
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFileEnforcesStrictSearchPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Chmod(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	good := filepath.Join(root, "mycorp.lua")
+	if err := os.WriteFile(good, []byte("return {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writable := filepath.Join(root, "writable.lua")
+	if err := os.WriteFile(writable, []byte("return {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(writable, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(outside, "secret.lua")
+	if err := os.WriteFile(secret, []byte("return {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "escape.lua")
+	if err := os.Symlink(secret, link); err != nil {
+		t.Skipf("symlink unsupported: %v", err)
+	}
+	roots := []string{root}
+
+	if data, err := ReadFile(roots, good); err != nil || string(data) != "return {}" {
+		t.Fatalf("ReadFile(good) = %q, %v", data, err)
+	}
+	if _, err := ReadFile(roots, filepath.Join(root, "missing.lua")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ReadFile(missing) error = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := ReadFile(roots, root); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ReadFile(dir) error = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := ReadFile(roots, writable); err == nil || !strings.Contains(err.Error(), "group/world writable") {
+		t.Fatalf("ReadFile(writable) error = %v, want group/world writable", err)
+	}
+	if _, err := ReadFile(roots, link); err == nil || !strings.Contains(err.Error(), "resolves outside") {
+		t.Fatalf("ReadFile(symlink) error = %v, want resolves outside", err)
+	}
+	// Outside the strict roots, files load without the permission checks.
+	if _, err := ReadFile(roots, filepath.Join(root, "..", filepath.Base(outside), "secret.lua")); err != nil {
+		t.Fatalf("ReadFile(non-strict) error = %v", err)
+	}
+	if Contains(roots, secret) || !Contains(roots, good) {
+		t.Fatalf("Contains mismatch for %s / %s", secret, good)
+	}
+}
+
+func TestReadFileLoadsGroupWritableNonStrictPath(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib")
+	luaDir := filepath.Join(lib, "lua")
+	if err := os.MkdirAll(luaDir, 0o775); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{lib, luaDir} {
+		if err := os.Chmod(dir, 0o775); err != nil {
+			t.Fatal(err)
+		}
+	}
+	libV1 := filepath.Join(lib, "gopherbot_v1.lua")
+	if err := os.WriteFile(libV1, []byte("return {}"), 0o664); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(libV1, 0o664); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFile(nil, libV1); err != nil || string(data) != "return {}" {
+		t.Fatalf("ReadFile(install lib) = %q, %v", data, err)
+	}
+	if _, err := ReadFile([]string{lib}, libV1); err == nil {
+		t.Fatal("ReadFile() under a strict root accepted a group writable file")
+	}
+}
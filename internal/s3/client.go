@@ -0,0 +1,379 @@
+// Package s3 is a small S3 REST client, enough for storing and listing
+// objects in AWS S3, MinIO and other S3-compatible stores without pulling in
+// the full service SDK.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+const metadataHeaderPrefix = "X-Amz-Meta-"
+
+// Config locates a bucket. Endpoint defaults to the AWS regional endpoint;
+// PathStyle puts the bucket in the path instead of the host name, which is
+// what MinIO and most other S3-compatible stores expect.
+type Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	PathStyle bool
+}
+
+// Client signs requests with AWS Signature Version 4.
+type Client struct {
+	cfg      Config
+	endpoint *url.URL
+	creds    aws.CredentialsProvider
+	signer   *v4.Signer
+	http     *http.Client
+}
+
+// Object is an object's metadata, and its body for Get.
+type Object struct {
+	Key      string
+	ETag     string
+	Metadata map[string]string
+	Body     []byte
+}
+
+// PutOptions make a Put conditional; IfNoneMatch only creates a new object,
+// IfMatch only replaces the object with the given ETag. ContentType
+// defaults to application/octet-stream.
+type PutOptions struct {
+	IfMatch     string
+	IfNoneMatch bool
+	ContentType string
+}
+
+// MaxPresignExpiry is the longest S3 honors a presigned URL.
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// Error is a non-success response from the store.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsPreconditionFailed reports whether a conditional write lost a race with
+// another writer.
+func IsPreconditionFailed(err error) bool {
+	var s3err *Error
+	return errors.As(err, &s3err) && (s3err.StatusCode == http.StatusPreconditionFailed || s3err.StatusCode == http.StatusConflict)
+}
+
+// IsNotImplemented reports whether the store rejected a request feature,
+// such as conditional writes on older S3-compatible servers.
+func IsNotImplemented(err error) bool {
+	var s3err *Error
+	return errors.As(err, &s3err) && s3err.StatusCode == http.StatusNotImplemented
+}
+
+// LoadCredentials returns static credentials when accessKeyID is set, or
+// the AWS SDK default credential chain.
+func LoadCredentials(ctx context.Context, region, accessKeyID, secretAccessKey string) (aws.CredentialsProvider, error) {
+	if accessKeyID != "" {
+		return credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, ""), nil
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Credentials, nil
+}
+
+// New returns a Client for cfg; a nil httpClient uses http.DefaultClient.
+func New(cfg Config, creds aws.CredentialsProvider, httpClient *http.Client) (*Client, error) {
+	cfg.Endpoint = strings.TrimRight(strings.TrimSpace(cfg.Endpoint), "/")
+	cfg.Region = strings.TrimSpace(cfg.Region)
+	cfg.Bucket = strings.TrimSpace(cfg.Bucket)
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing endpoint %q: %w", cfg.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("endpoint %q must be an http or https URL", cfg.Endpoint)
+	}
+	if creds == nil {
+		return nil, errors.New("credentials are required")
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		cfg:      cfg,
+		endpoint: endpoint,
+		creds:    creds,
+		signer: v4.NewSigner(func(o *v4.SignerOptions) {
+			o.DisableURIPathEscaping = true
+		}),
+		http: httpClient,
+	}, nil
+}
+
+// Location describes the bucket for logs and brain identity.
+func (c *Client) Location() string {
+	return c.endpoint.Host + "/" + c.cfg.Bucket
+}
+
+// Get returns an object and its metadata, or exists=false.
+func (c *Client) Get(ctx context.Context, key string) (obj Object, exists bool, err error) {
+	return c.fetch(ctx, http.MethodGet, key)
+}
+
+// Head returns an object's metadata without the body.
+func (c *Client) Head(ctx context.Context, key string) (obj Object, exists bool, err error) {
+	return c.fetch(ctx, http.MethodHead, key)
+}
+
+func (c *Client) fetch(ctx context.Context, method, key string) (Object, bool, error) {
+	resp, err := c.do(ctx, method, key, nil, nil, nil)
+	if err != nil {
+		return Object{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Object{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, false, responseError(resp)
+	}
+	obj := Object{
+		Key:      key,
+		ETag:     resp.Header.Get("ETag"),
+		Metadata: make(map[string]string),
+	}
+	for name, values := range resp.Header {
+		if strings.HasPrefix(name, metadataHeaderPrefix) && len(values) > 0 {
+			obj.Metadata[strings.ToLower(strings.TrimPrefix(name, metadataHeaderPrefix))] = values[0]
+		}
+	}
+	if method == http.MethodGet {
+		if obj.Body, err = io.ReadAll(resp.Body); err != nil {
+			return Object{}, false, err
+		}
+	}
+	return obj, true, nil
+}
+
+// Put stores body with lowercase user metadata keys, returning the new ETag.
+func (c *Client) Put(ctx context.Context, key string, body []byte, metadata map[string]string, opts PutOptions) (string, error) {
+	header := make(http.Header)
+	header.Set("Content-Type", "application/octet-stream")
+	if opts.ContentType != "" {
+		header.Set("Content-Type", opts.ContentType)
+	}
+	for name, value := range metadata {
+		header.Set(metadataHeaderPrefix+name, value)
+	}
+	if opts.IfMatch != "" {
+		header.Set("If-Match", opts.IfMatch)
+	}
+	if opts.IfNoneMatch {
+		header.Set("If-None-Match", "*")
+	}
+	resp, err := c.do(ctx, http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Header.Get("ETag"), nil
+}
+
+// Delete removes an object; deleting a missing object isn't an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return responseError(resp)
+}
+
+// Presign returns a URL anyone can use to GET key until it expires, at
+// most MaxPresignExpiry from now.
+func (c *Client) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > MaxPresignExpiry {
+		return "", fmt.Errorf("presigned URL expiry %v must be between 1s and %v", expires, MaxPresignExpiry)
+	}
+	query := url.Values{}
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.objectURL(key, query).String(), nil)
+	if err != nil {
+		return "", err
+	}
+	creds, err := c.creds.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("retrieving credentials: %w", err)
+	}
+	signed, _, err := c.signer.PresignHTTP(ctx, creds, req, "UNSIGNED-PAYLOAD", "s3", c.cfg.Region, time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("presigning request: %w", err)
+	}
+	return signed, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string
+		ETag string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List returns up to maxKeys object keys under prefix in key order, and a
+// continuation token for the next page, empty on the last page.
+func (c *Client) List(ctx context.Context, prefix, token string, maxKeys int) ([]string, string, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(resp)
+	}
+	var result listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("decoding object list: %w", err)
+	}
+	keys := make([]string, 0, len(result.Contents))
+	for _, item := range result.Contents {
+		keys = append(keys, item.Key)
+	}
+	if !result.IsTruncated {
+		return keys, "", nil
+	}
+	return keys, result.NextContinuationToken, nil
+}
+
+func (c *Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	path := strings.TrimRight(u.Path, "/")
+	if c.cfg.PathStyle {
+		path += "/" + c.cfg.Bucket
+	} else {
+		u.Host = c.cfg.Bucket + "." + u.Host
+	}
+	if key == "" && path != "" {
+		u.Path = path
+		u.RawPath = uriEncode(path)
+	} else {
+		u.Path = path + "/" + key
+		u.RawPath = uriEncode(path) + "/" + uriEncode(key)
+	}
+	if query != nil {
+		// Signature V4 wants %20 for spaces in the canonical query.
+		u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+	return &u
+}
+
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	creds, err := c.creds.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving credentials: %w", err)
+	}
+	if err := c.signer.SignHTTP(ctx, creds, req, payloadHash, "s3", c.cfg.Region, time.Now().UTC()); err != nil {
+		return nil, fmt.Errorf("signing request: %w", err)
+	}
+	return c.http.Do(req)
+}
+
+func responseError(resp *http.Response) error {
+	s3err := &Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(data, &body) == nil {
+		s3err.Code = body.Code
+		s3err.Message = body.Message
+	}
+	return s3err
+}
+
+// uriEncode escapes a path the way Signature V4 expects for S3: everything
+// but unreserved characters and slashes.
+func uriEncode(path string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
@@ -113,6 +113,8 @@ const OAuth2ReauthRequired = 32
 const OAuth2RefreshFailed = 33
 const OAuth2InvalidLinkRequest = 34
 const OAuth2ConfigError = 35
+const QueueProviderNotFound = 36
+const QueuePublishFailed = 37
 const Failed = 63
 
 # Plugin return values / exit codes
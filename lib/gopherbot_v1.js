@@ -54,6 +54,8 @@ const ret = {
   IdentityRefreshFailed: 33,
   IdentityInvalidLinkRequest: 34,
   IdentityConfigError: 35,
+  QueueProviderNotFound: 36,
+  QueuePublishFailed: 37,
 
   // General Failure
   Failed: 63,
@@ -994,6 +996,20 @@ Robot.prototype.LinkOAuth2Identity = function (link) {
   return this.gbot.LinkOAuth2Identity(link);
 };
 
+/**
+ * Publishes a message to a topic on a running queue provider that supports publishing,
+ * e.g. to trigger another robot's UUIDTrigger job.
+ *
+ * @param {string} provider - Queue provider name from robot.yaml QueueProviders.
+ * @param {string} topic - Provider-specific topic, allowed by the provider's PublishTopics.
+ * @param {string} body - Message body.
+ * @param {Object<string, string>} [attributes] - Optional message attributes.
+ * @returns {number} - `ret.Ok`, `ret.QueueProviderNotFound` or `ret.QueuePublishFailed`.
+ */
+Robot.prototype.PublishQueueMessage = function (provider, topic, body, attributes) {
+  return this.gbot.PublishQueueMessage(provider, topic, body, attributes);
+};
+
 /**
  * Encrypts plaintext for safe use in custom conf/variables Secrets entries.
  *
@@ -68,6 +68,8 @@ M.ret = {
     IdentityRefreshFailed = 33,
     IdentityInvalidLinkRequest = 34,
     IdentityConfigError = 35,
+    QueueProviderNotFound = 36,
+    QueuePublishFailed = 37,
 
     -- General Failure
     Failed = 63,
@@ -499,6 +501,16 @@ function Robot:LinkOAuth2Identity(link)
     return self.gbot:LinkOAuth2Identity(link)
 end
 
+---Publish a message to a topic on a running queue provider that supports publishing.
+---@param provider string
+---@param topic string
+---@param body string
+---@param attributes table|nil optional string key/value attributes
+---@return number retVal
+function Robot:PublishQueueMessage(provider, topic, body, attributes)
+    return self.gbot:PublishQueueMessage(provider, topic, body, attributes)
+end
+
 ---Encrypt plaintext for safe use in custom conf/variables Secrets entries.
 ---@param plaintext string
 ---@return string ciphertext
@@ -79,6 +79,8 @@ class BaseBot
 	IdentityRefreshFailed = 33
 	IdentityInvalidLinkRequest = 34
 	IdentityConfigError = 35
+	QueueProviderNotFound = 36
+	QueuePublishFailed = 37
 	Failed = 63
 
 	# Plugin return values / exit codes
@@ -42,6 +42,8 @@ GBRET_IdentityReauthRequired=32
 GBRET_IdentityRefreshFailed=33
 GBRET_IdentityInvalidLinkRequest=34
 GBRET_IdentityConfigError=35
+GBRET_QueueProviderNotFound=36
+GBRET_QueuePublishFailed=37
 GBRET_Failed=63
 
 # Plugin return values / exit codes
@@ -74,6 +74,8 @@ class Robot:
     IdentityRefreshFailed = 33
     IdentityInvalidLinkRequest = 34
     IdentityConfigError = 35
+    QueueProviderNotFound = 36
+    QueuePublishFailed = 37
     Failed = 63
 
     # Plugin return values / exit codes
@@ -197,6 +199,10 @@ class Robot:
         ret = self.Call(sys._getframe().f_code.co_name, { "Provider": provider, "User": user })
         return ret["RetVal"]
 
+    def PublishQueueMessage(self, provider, topic, body, attributes=None):
+        ret = self.Call(sys._getframe().f_code.co_name, { "Provider": provider, "Topic": topic, "Body": body, "Attributes": attributes or {} })
+        return ret["RetVal"]
+
     def GetHelpMetadata(self, query):
         ret = self.Call(sys._getframe().f_code.co_name, { "Query": query })
         return ret["StrVal"]
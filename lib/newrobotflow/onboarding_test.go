@@ -174,7 +174,10 @@ func (r *onboardingTestRobot) GetIdentityCredential(string, string) (*robot.Iden
 func (r *onboardingTestRobot) LinkOAuth2Identity(*robot.OAuth2IdentityLinkRequest) robot.RetVal {
 	return robot.Failed
 }
-func (r *onboardingTestRobot) UnlinkIdentity(string, string) robot.RetVal        { return robot.Failed }
+func (r *onboardingTestRobot) UnlinkIdentity(string, string) robot.RetVal { return robot.Failed }
+func (r *onboardingTestRobot) PublishQueueMessage(string, string, string, map[string]string) robot.RetVal {
+	return robot.Failed
+}
 func (r *onboardingTestRobot) Email(string, *bytes.Buffer, ...bool) robot.RetVal { return robot.Failed }
 func (r *onboardingTestRobot) EmailUser(string, string, *bytes.Buffer, ...bool) robot.RetVal {
 	return robot.Failed
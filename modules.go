@@ -30,17 +30,42 @@ import (
 	_ "github.com/lnxjedi/gopherbot/v2/connectors/googlechat"
 	// *** Default SSH connector
 	_ "github.com/lnxjedi/gopherbot/v2/connectors/ssh"
+	// *** Mattermost connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/mattermost"
+	// *** Rocket.Chat connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/rocket"
+	// *** Matrix connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/matrix"
+	// *** IRC connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/irc"
+	// *** Webhook connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/webhook"
+	// *** Email connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/email"
+	// *** Teams connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/teams"
+	// *** Discord connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/discord"
 
 	// *** Default queue providers
+	_ "github.com/lnxjedi/gopherbot/v2/queues/amqp"
 	_ "github.com/lnxjedi/gopherbot/v2/queues/gcloud"
+	_ "github.com/lnxjedi/gopherbot/v2/queues/spool"
 
 	// *** Default file history
 	_ "github.com/lnxjedi/gopherbot/v2/history/file"
+	// *** Searchable SQLite history
+	_ "github.com/lnxjedi/gopherbot/v2/history/sqlite"
+	// *** S3 history with presigned log links
+	_ "github.com/lnxjedi/gopherbot/v2/history/s3"
 
 	// *** A couple of fantastic brains
 	_ "github.com/lnxjedi/gopherbot/v2/brains/cloudflarekv"
 	_ "github.com/lnxjedi/gopherbot/v2/brains/dynamodb"
 	_ "github.com/lnxjedi/gopherbot/v2/brains/firestore"
+	_ "github.com/lnxjedi/gopherbot/v2/brains/postgres"
+	_ "github.com/lnxjedi/gopherbot/v2/brains/s3"
+	_ "github.com/lnxjedi/gopherbot/v2/brains/sqlite"
 )
 
 /* Uncomment under Profiling above to enable profiling. This inflates
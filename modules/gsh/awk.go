@@ -0,0 +1,1667 @@
+package gsh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// The GSH awk builtin is a POSIX awk interpreter (see awk_parse.go for the
+// grammar). Input and output redirections to files work; anything that would
+// start a process - system(), print | "cmd", "cmd" | getline - is rejected,
+// since GSH never runs external commands. for-in loops visit keys in sorted
+// order so script output is deterministic.
+type awkOptions struct {
+	fs      *string
+	assigns []string
+	progs   []string
+	help    bool
+}
+
+type awkValueKind int
+
+const (
+	awkUninit awkValueKind = iota
+	awkNum
+	awkStr
+	// awkStrNum is input data that looks numeric, which compares as a number
+	awkStrNum
+)
+
+type awkValue struct {
+	kind awkValueKind
+	s    string
+	n    float64
+}
+
+func awkNumber(n float64) awkValue {
+	return awkValue{kind: awkNum, n: n}
+}
+
+func awkString(s string) awkValue {
+	return awkValue{kind: awkStr, s: s}
+}
+
+func awkBool(b bool) awkValue {
+	if b {
+		return awkNumber(1)
+	}
+	return awkNumber(0)
+}
+
+// awkInput makes a value from input data: fields, getline, split, ARGV,
+// ENVIRON and command-line assignments.
+func awkInput(s string) awkValue {
+	if n, ok := awkLooksNumeric(s); ok {
+		return awkValue{kind: awkStrNum, s: s, n: n}
+	}
+	return awkString(s)
+}
+
+// awkNumericPrefix returns the length of the leading decimal number in s.
+func awkNumericPrefix(s string) int {
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			i = j
+		}
+	}
+	return i
+}
+
+func awkStrToNum(s string) float64 {
+	s = strings.TrimLeft(s, " \t\n\r\f\v")
+	n, _ := strconv.ParseFloat(s[:awkNumericPrefix(s)], 64)
+	return n
+}
+
+func awkLooksNumeric(s string) (float64, bool) {
+	s = strings.Trim(s, " \t\n\r\f\v")
+	end := awkNumericPrefix(s)
+	if end == 0 || end != len(s) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
+
+func (v awkValue) num() float64 {
+	switch v.kind {
+	case awkNum, awkStrNum:
+		return v.n
+	case awkStr:
+		return awkStrToNum(v.s)
+	}
+	return 0
+}
+
+func (v awkValue) truthy() bool {
+	switch v.kind {
+	case awkNum, awkStrNum:
+		return v.n != 0
+	case awkStr:
+		return v.s != ""
+	}
+	return false
+}
+
+// awkCell holds a variable, which is a scalar until it's used as an array.
+type awkCell struct {
+	v   awkValue
+	arr map[string]awkValue
+}
+
+type awkFrame struct {
+	locals map[string]*awkCell
+}
+
+// awkStream is an open redirection or getline file.
+type awkStream struct {
+	w      *bufio.Writer
+	r      *awkRecordReader
+	closer io.Closer
+}
+
+// awkRecordReader splits an input stream into records according to RS.
+type awkRecordReader struct {
+	r      *bufio.Reader
+	closer io.Closer
+	// a regex RS reads the rest of the input into buf
+	slurped bool
+	buf     string
+}
+
+func newAwkRecordReader(r io.Reader, closer io.Closer) *awkRecordReader {
+	return &awkRecordReader{r: bufio.NewReader(r), closer: closer}
+}
+
+func (rr *awkRecordReader) close() error {
+	if rr.closer != nil {
+		return rr.closer.Close()
+	}
+	return nil
+}
+
+func (rr *awkRecordReader) read(rs string, rsRegex func() (*regexp.Regexp, error)) (string, bool, error) {
+	if len(rs) > 1 && !rr.slurped {
+		data, err := io.ReadAll(rr.r)
+		if err != nil {
+			return "", false, err
+		}
+		rr.slurped, rr.buf = true, string(data)
+	}
+	if rr.slurped {
+		return rr.readBuffered(rs, rsRegex)
+	}
+	switch rs {
+	case "":
+		for {
+			b, err := rr.r.ReadByte()
+			if err == io.EOF {
+				return "", false, nil
+			} else if err != nil {
+				return "", false, err
+			}
+			if b != '\n' {
+				rr.r.UnreadByte()
+				break
+			}
+		}
+		var rec strings.Builder
+		for {
+			line, err := rr.r.ReadString('\n')
+			if line == "\n" {
+				break
+			}
+			rec.WriteString(line)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return "", false, err
+			}
+		}
+		return strings.TrimRight(rec.String(), "\n"), true, nil
+	default:
+		line, err := rr.r.ReadString(rs[0])
+		if err != nil && err != io.EOF {
+			return "", false, err
+		}
+		if line == "" {
+			return "", false, nil
+		}
+		return strings.TrimSuffix(line, rs[:1]), true, nil
+	}
+}
+
+func (rr *awkRecordReader) readBuffered(rs string, rsRegex func() (*regexp.Regexp, error)) (string, bool, error) {
+	if rs == "" {
+		rr.buf = strings.TrimLeft(rr.buf, "\n")
+	}
+	if rr.buf == "" {
+		return "", false, nil
+	}
+	start, end := -1, -1
+	switch {
+	case rs == "":
+		if i := strings.Index(rr.buf, "\n\n"); i >= 0 {
+			start, end = i, i+2
+		}
+	case len(rs) == 1:
+		if i := strings.IndexByte(rr.buf, rs[0]); i >= 0 {
+			start, end = i, i+1
+		}
+	default:
+		re, err := rsRegex()
+		if err != nil {
+			return "", false, err
+		}
+		if loc := re.FindStringIndex(rr.buf); loc != nil && loc[1] > loc[0] {
+			start, end = loc[0], loc[1]
+		}
+	}
+	if start < 0 {
+		rec := rr.buf
+		rr.buf = ""
+		if rs == "" {
+			rec = strings.TrimRight(rec, "\n")
+		}
+		return rec, true, nil
+	}
+	rec := rr.buf[:start]
+	rr.buf = rr.buf[end:]
+	return rec, true, nil
+}
+
+// Control flow signals, carried by panics out of nested evaluation
+type (
+	awkNextSignal  struct{}
+	awkExitSignal  struct{}
+	awkFatalSignal struct{ err error }
+)
+
+type awkFlow int
+
+const (
+	awkFlowNormal awkFlow = iota
+	awkFlowBreak
+	awkFlowContinue
+	awkFlowReturn
+)
+
+type awkInterp struct {
+	ctx   context.Context
+	hc    interp.HandlerContext
+	prog  *awkProgram
+	out   *bufio.Writer
+	steps int
+
+	globals map[string]*awkCell
+	frames  []*awkFrame
+	retval  awkValue
+
+	record string
+	fields []string
+	// split reports whether fields reflects record
+	split   bool
+	splitFS string
+
+	main     *awkRecordReader
+	argIndex int
+	sawFile  bool
+	streams  map[string]*awkStream
+
+	regexes  map[string]*regexp.Regexp
+	random   *rand.Rand
+	seed     float64
+	exitCode int
+	status   int
+	// inMain is set while pattern-action items run, where next is allowed
+	inMain bool
+}
+
+func (c *shellContext) runAwk(ctx context.Context, args []string) error {
+	hc := interp.HandlerCtx(ctx)
+	opts, operands, err := parseAwkArgs(args)
+	if err != nil {
+		fmt.Fprintf(hc.Stderr, "awk: %v\n", err)
+		fmt.Fprint(hc.Stderr, awkUsage)
+		return interp.ExitStatus(2)
+	}
+	if opts.help {
+		fmt.Fprint(hc.Stdout, awkHelpText)
+		return nil
+	}
+	var source string
+	if len(opts.progs) > 0 {
+		var parts []string
+		for _, name := range opts.progs {
+			data, err := os.ReadFile(resolvePath(hc.Dir, name))
+			if err != nil {
+				fmt.Fprintf(hc.Stderr, "awk: can't open source file %s: %v\n", name, errors.Unwrap(err))
+				return interp.ExitStatus(2)
+			}
+			parts = append(parts, string(data))
+		}
+		source = strings.Join(parts, "\n")
+	} else {
+		if len(operands) == 0 {
+			fmt.Fprint(hc.Stderr, awkUsage)
+			return interp.ExitStatus(2)
+		}
+		source, operands = operands[0], operands[1:]
+	}
+	prog, err := parseAwk(source)
+	if err != nil {
+		fmt.Fprintf(hc.Stderr, "awk: %v\n", err)
+		return interp.ExitStatus(2)
+	}
+
+	in := &awkInterp{
+		ctx:      ctx,
+		hc:       hc,
+		prog:     prog,
+		out:      bufio.NewWriter(hc.Stdout),
+		globals:  map[string]*awkCell{},
+		argIndex: 1,
+		streams:  map[string]*awkStream{},
+		regexes:  map[string]*regexp.Regexp{},
+		random:   rand.New(rand.NewSource(0)),
+	}
+	in.initGlobals(operands)
+	if opts.fs != nil {
+		in.setVar("FS", awkString(*opts.fs))
+	}
+	for _, assign := range opts.assigns {
+		name, value, _ := strings.Cut(assign, "=")
+		in.setVar(name, awkInput(awkProcessEscapes(value)))
+	}
+
+	err = in.run()
+	in.closeAll()
+	if ferr := in.out.Flush(); err == nil && ferr != nil {
+		err = ferr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Fprintf(hc.Stderr, "awk: %v\n", err)
+		return interp.ExitStatus(2)
+	}
+	if in.exitCode != 0 {
+		return interp.ExitStatus(uint8(in.exitCode))
+	}
+	if in.status != 0 {
+		return interp.ExitStatus(uint8(in.status))
+	}
+	return nil
+}
+
+var awkAssignOperand = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+func parseAwkArgs(args []string) (*awkOptions, []string, error) {
+	opts := &awkOptions{}
+	i := 0
+	value := func(arg, flag string) (string, error) {
+		if len(arg) > len(flag) {
+			return arg[len(flag):], nil
+		}
+		i++
+		if i >= len(args) {
+			return "", fmt.Errorf("option requires an argument -- %s", flag[1:])
+		}
+		return args[i], nil
+	}
+	for ; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return opts, args[i+1:], nil
+		case arg == "--help" || arg == "-h":
+			opts.help = true
+			return opts, nil, nil
+		case strings.HasPrefix(arg, "-F"):
+			fs, err := value(arg, "-F")
+			if err != nil {
+				return nil, nil, err
+			}
+			if fs == "t" {
+				fs = "\t"
+			}
+			fs = awkProcessEscapes(fs)
+			opts.fs = &fs
+		case strings.HasPrefix(arg, "-v"):
+			assign, err := value(arg, "-v")
+			if err != nil {
+				return nil, nil, err
+			}
+			if !awkAssignOperand.MatchString(assign) {
+				return nil, nil, fmt.Errorf("invalid -v argument %q, expected var=value", assign)
+			}
+			opts.assigns = append(opts.assigns, assign)
+		case strings.HasPrefix(arg, "-f"):
+			prog, err := value(arg, "-f")
+			if err != nil {
+				return nil, nil, err
+			}
+			opts.progs = append(opts.progs, prog)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return nil, nil, fmt.Errorf("unknown option %s", arg)
+		default:
+			return opts, args[i:], nil
+		}
+	}
+	return opts, nil, nil
+}
+
+func (in *awkInterp) initGlobals(operands []string) {
+	for name, value := range map[string]awkValue{
+		"FS": awkString(" "), "OFS": awkString(" "), "ORS": awkString("\n"),
+		"RS": awkString("\n"), "SUBSEP": awkString("\x1c"),
+		"CONVFMT": awkString("%.6g"), "OFMT": awkString("%.6g"),
+		"NR": awkNumber(0), "FNR": awkNumber(0), "RSTART": awkNumber(0),
+		"RLENGTH": awkNumber(-1), "FILENAME": awkString(""),
+		"ARGC": awkNumber(float64(len(operands) + 1)),
+	} {
+		in.globals[name] = &awkCell{v: value}
+	}
+	argv := map[string]awkValue{"0": awkString("awk")}
+	for i, operand := range operands {
+		argv[strconv.Itoa(i+1)] = awkInput(operand)
+	}
+	in.globals["ARGV"] = &awkCell{arr: argv}
+	environ := map[string]awkValue{}
+	if in.hc.Env != nil {
+		for _, pair := range jqEnvironment(in.hc.Env) {
+			name, value, _ := strings.Cut(pair, "=")
+			environ[name] = awkInput(value)
+		}
+	}
+	in.globals["ENVIRON"] = &awkCell{arr: environ}
+}
+
+func (in *awkInterp) fatal(format string, args ...interface{}) {
+	panic(&awkFatalSignal{err: fmt.Errorf(format, args...)})
+}
+
+// guard runs f, converting exit and fatal panics into results.
+func (in *awkInterp) guard(f func()) (exited bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch sig := r.(type) {
+			case *awkExitSignal:
+				exited = true
+			case *awkFatalSignal:
+				err = sig.err
+			default:
+				panic(r)
+			}
+		}
+	}()
+	f()
+	return false, nil
+}
+
+func (in *awkInterp) run() error {
+	exited, err := in.guard(func() {
+		for _, item := range in.prog.begins {
+			in.execBlock(item.body)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !exited && (len(in.prog.items) > 0 || len(in.prog.ends) > 0) {
+		exited, err = in.guard(in.mainLoop)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = in.guard(func() {
+		for _, item := range in.prog.ends {
+			in.execBlock(item.body)
+		}
+	})
+	return err
+}
+
+func (in *awkInterp) mainLoop() {
+	in.inMain = true
+	defer func() { in.inMain = false }()
+	for {
+		rec, ok := in.nextMainRecord()
+		if !ok {
+			return
+		}
+		in.setRecord(rec)
+		in.runItems()
+	}
+}
+
+// runItems applies every pattern-action item to the current record; next
+// ends the pass early.
+func (in *awkInterp) runItems() {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*awkNextSignal); !ok {
+				panic(r)
+			}
+		}
+	}()
+	for _, item := range in.prog.items {
+		if !in.itemMatches(item) {
+			continue
+		}
+		if !item.hasBody {
+			in.printValues(in.out, []awkValue{awkInput(in.record)})
+			continue
+		}
+		in.execBlock(item.body)
+	}
+}
+
+func (in *awkInterp) itemMatches(item *awkItem) bool {
+	switch {
+	case item.pattern == nil:
+		return true
+	case item.pattern2 == nil:
+		return in.eval(item.pattern).truthy()
+	case item.inRange:
+		if in.eval(item.pattern2).truthy() {
+			item.inRange = false
+		}
+		return true
+	case in.eval(item.pattern).truthy():
+		item.inRange = !in.eval(item.pattern2).truthy()
+		return true
+	}
+	return false
+}
+
+// nextMainRecord reads from the ARGV operands in turn, or stdin when there
+// are none, applying var=value operands as they're reached.
+func (in *awkInterp) nextMainRecord() (string, bool) {
+	for {
+		if in.main == nil && !in.openNextMain() {
+			return "", false
+		}
+		rec, ok, err := in.main.read(in.varString("RS"), in.rsRegex)
+		if err != nil {
+			in.fatal("error reading %s: %v", in.varString("FILENAME"), err)
+		}
+		if ok {
+			in.setVar("NR", awkNumber(in.getVar("NR").num()+1))
+			in.setVar("FNR", awkNumber(in.getVar("FNR").num()+1))
+			return rec, true
+		}
+		in.main.close()
+		in.main = nil
+	}
+}
+
+func (in *awkInterp) openNextMain() bool {
+	argv := in.globals["ARGV"].arr
+	for {
+		if in.argIndex >= int(in.getVar("ARGC").num()) {
+			if in.sawFile {
+				return false
+			}
+			in.sawFile = true
+			in.main = newAwkRecordReader(gshStdinReader(in.hc), nil)
+			return true
+		}
+		arg := in.toStr(argv[strconv.Itoa(in.argIndex)])
+		in.argIndex++
+		switch {
+		case arg == "":
+			continue
+		case awkAssignOperand.MatchString(arg):
+			name, value, _ := strings.Cut(arg, "=")
+			in.setVar(name, awkInput(awkProcessEscapes(value)))
+			continue
+		}
+		in.sawFile = true
+		if arg == "-" || arg == "/dev/stdin" {
+			in.main = newAwkRecordReader(gshStdinReader(in.hc), nil)
+		} else {
+			f, err := os.Open(resolvePath(in.hc.Dir, arg))
+			if err != nil {
+				fmt.Fprintf(in.hc.Stderr, "awk: can't open file %s: %v\n", arg, errors.Unwrap(err))
+				in.status = 2
+				continue
+			}
+			in.main = newAwkRecordReader(f, f)
+		}
+		in.setVar("FILENAME", awkString(arg))
+		in.setVar("FNR", awkNumber(0))
+		return true
+	}
+}
+
+func (in *awkInterp) rsRegex() (*regexp.Regexp, error) {
+	return in.regex(in.varString("RS"))
+}
+
+func (in *awkInterp) regex(src string) (*regexp.Regexp, error) {
+	if re, ok := in.regexes[src]; ok {
+		return re, nil
+	}
+	re, err := compileAwkRegex(src)
+	if err != nil {
+		return nil, err
+	}
+	in.regexes[src] = re
+	return re, nil
+}
+
+func (in *awkInterp) mustRegex(x awkExpr) *regexp.Regexp {
+	if lit, ok := x.(*awkRegexLit); ok {
+		return lit.re
+	}
+	re, err := in.regex(in.toStr(in.eval(x)))
+	if err != nil {
+		in.fatal("%v", err)
+	}
+	return re
+}
+
+// Variables
+
+func (in *awkInterp) cell(name string) *awkCell {
+	if len(in.frames) > 0 {
+		if cell, ok := in.frames[len(in.frames)-1].locals[name]; ok {
+			return cell
+		}
+	}
+	cell, ok := in.globals[name]
+	if !ok {
+		cell = &awkCell{}
+		in.globals[name] = cell
+	}
+	return cell
+}
+
+// isNF reports whether name refers to the NF special variable rather than a
+// function parameter of that name.
+func (in *awkInterp) isNF(name string) bool {
+	return name == "NF" && (len(in.frames) == 0 || in.frames[len(in.frames)-1].locals[name] == nil)
+}
+
+func (in *awkInterp) getVar(name string) awkValue {
+	if in.isNF(name) {
+		in.splitRecord()
+		return awkNumber(float64(len(in.fields)))
+	}
+	cell := in.cell(name)
+	if cell.arr != nil {
+		in.fatal("attempt to use array %s in a scalar context", name)
+	}
+	return cell.v
+}
+
+func (in *awkInterp) setVar(name string, v awkValue) {
+	if in.isNF(name) {
+		in.setNF(int(v.num()))
+		return
+	}
+	cell := in.cell(name)
+	if cell.arr != nil {
+		in.fatal("attempt to use array %s in a scalar context", name)
+	}
+	cell.v = v
+}
+
+func (in *awkInterp) varString(name string) string {
+	return in.toStr(in.getVar(name))
+}
+
+func (in *awkInterp) array(name string) map[string]awkValue {
+	cell := in.cell(name)
+	if cell.arr == nil {
+		if cell.v.kind != awkUninit {
+			in.fatal("attempt to use scalar %s as an array", name)
+		}
+		cell.arr = map[string]awkValue{}
+	}
+	return cell.arr
+}
+
+func (in *awkInterp) subscript(subs []awkExpr) string {
+	if len(subs) == 1 {
+		return in.toStr(in.eval(subs[0]))
+	}
+	parts := make([]string, len(subs))
+	for i, sub := range subs {
+		parts[i] = in.toStr(in.eval(sub))
+	}
+	return strings.Join(parts, in.varString("SUBSEP"))
+}
+
+// Records and fields
+
+func (in *awkInterp) setRecord(rec string) {
+	in.record = rec
+	in.split = false
+	in.splitFS = in.varString("FS")
+}
+
+func (in *awkInterp) splitRecord() {
+	if in.split {
+		return
+	}
+	in.split = true
+	fs := in.splitFS
+	if in.varString("RS") == "" && fs != " " {
+		in.fields = in.fields[:0]
+		for _, line := range strings.Split(in.record, "\n") {
+			in.fields = append(in.fields, in.splitString(line, fs)...)
+		}
+		return
+	}
+	in.fields = in.splitString(in.record, fs)
+}
+
+func (in *awkInterp) splitString(s, fs string) []string {
+	switch {
+	case fs == " ":
+		return strings.FieldsFunc(s, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == '\n'
+		})
+	case s == "":
+		return nil
+	case fs == "":
+		fields := make([]string, 0, len(s))
+		for _, r := range s {
+			fields = append(fields, string(r))
+		}
+		return fields
+	case utf8.RuneCountInString(fs) == 1 && fs != "\\":
+		return strings.Split(s, fs)
+	}
+	re, err := in.regex(fs)
+	if err != nil {
+		in.fatal("%v", err)
+	}
+	return awkRegexSplit(s, re)
+}
+
+func awkRegexSplit(s string, re *regexp.Regexp) []string {
+	if s == "" {
+		return nil
+	}
+	var fields []string
+	start := 0
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		if loc[1] == loc[0] {
+			continue
+		}
+		fields = append(fields, s[start:loc[0]])
+		start = loc[1]
+	}
+	return append(fields, s[start:])
+}
+
+func (in *awkInterp) getField(i int) awkValue {
+	switch {
+	case i < 0:
+		in.fatal("attempt to access field %d", i)
+	case i == 0:
+		return awkInput(in.record)
+	}
+	in.splitRecord()
+	if i > len(in.fields) {
+		return awkValue{}
+	}
+	return awkInput(in.fields[i-1])
+}
+
+func (in *awkInterp) setField(i int, s string) {
+	switch {
+	case i < 0:
+		in.fatal("attempt to access field %d", i)
+	case i == 0:
+		in.setRecord(s)
+		return
+	}
+	in.splitRecord()
+	for len(in.fields) < i {
+		in.fields = append(in.fields, "")
+	}
+	in.fields[i-1] = s
+	in.rebuildRecord()
+}
+
+func (in *awkInterp) setNF(nf int) {
+	if nf < 0 {
+		in.fatal("NF set to negative value")
+	}
+	in.splitRecord()
+	for len(in.fields) < nf {
+		in.fields = append(in.fields, "")
+	}
+	in.fields = in.fields[:nf]
+	in.rebuildRecord()
+}
+
+func (in *awkInterp) rebuildRecord() {
+	in.record = strings.Join(in.fields, in.varString("OFS"))
+}
+
+// Values
+
+func (in *awkInterp) toStr(v awkValue) string {
+	if v.kind == awkNum {
+		return in.formatNum(v.n, in.varString("CONVFMT"))
+	}
+	return v.s
+}
+
+func (in *awkInterp) toOutput(v awkValue) string {
+	if v.kind == awkNum {
+		return in.formatNum(v.n, in.varString("OFMT"))
+	}
+	return v.s
+}
+
+func (in *awkInterp) formatNum(n float64, format string) string {
+	switch {
+	case math.IsNaN(n):
+		return "nan"
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case n == math.Trunc(n) && math.Abs(n) < 1e30:
+		return strconv.FormatFloat(n, 'f', 0, 64)
+	}
+	return in.sprintf(format, []awkValue{awkNumber(n)})
+}
+
+func (in *awkInterp) compare(l, r awkValue) int {
+	numeric := func(v awkValue) bool {
+		return v.kind != awkStr
+	}
+	if numeric(l) && numeric(r) {
+		a, b := l.num(), r.num()
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(in.toStr(l), in.toStr(r))
+}
+
+// Statements
+
+func (in *awkInterp) tick() {
+	in.steps++
+	if in.steps%1024 == 0 {
+		if err := in.ctx.Err(); err != nil {
+			panic(&awkFatalSignal{err: err})
+		}
+	}
+}
+
+func (in *awkInterp) execBlock(body []awkStmt) {
+	if flow := in.exec(body); flow == awkFlowBreak || flow == awkFlowContinue {
+		in.fatal("break or continue outside a loop")
+	}
+}
+
+func (in *awkInterp) exec(body []awkStmt) awkFlow {
+	for _, stmt := range body {
+		in.tick()
+		if flow := in.execStmt(stmt); flow != awkFlowNormal {
+			return flow
+		}
+	}
+	return awkFlowNormal
+}
+
+func (in *awkInterp) execStmt(stmt awkStmt) awkFlow {
+	switch s := stmt.(type) {
+	case *awkExprStmt:
+		in.eval(s.x)
+	case *awkPrintStmt:
+		in.execPrint(s)
+	case *awkIfStmt:
+		if in.eval(s.cond).truthy() {
+			return in.exec(s.then)
+		}
+		return in.exec(s.els)
+	case *awkWhileStmt:
+		for in.eval(s.cond).truthy() {
+			in.tick()
+			if flow := in.exec(s.body); flow == awkFlowBreak {
+				break
+			} else if flow == awkFlowReturn {
+				return flow
+			}
+		}
+	case *awkDoStmt:
+		for {
+			in.tick()
+			if flow := in.exec(s.body); flow == awkFlowBreak {
+				break
+			} else if flow == awkFlowReturn {
+				return flow
+			}
+			if !in.eval(s.cond).truthy() {
+				break
+			}
+		}
+	case *awkForStmt:
+		if s.init != nil {
+			in.execStmt(s.init)
+		}
+		for s.cond == nil || in.eval(s.cond).truthy() {
+			in.tick()
+			if flow := in.exec(s.body); flow == awkFlowBreak {
+				break
+			} else if flow == awkFlowReturn {
+				return flow
+			}
+			if s.post != nil {
+				in.execStmt(s.post)
+			}
+		}
+	case *awkForInStmt:
+		for _, key := range awkSortedKeys(in.array(s.array)) {
+			if _, ok := in.array(s.array)[key]; !ok {
+				continue
+			}
+			in.tick()
+			in.assign(s.key, awkInput(key))
+			if flow := in.exec(s.body); flow == awkFlowBreak {
+				break
+			} else if flow == awkFlowReturn {
+				return flow
+			}
+		}
+	case *awkNextStmt:
+		if !in.inMain {
+			in.fatal("next used in BEGIN or END action")
+		}
+		panic(&awkNextSignal{})
+	case *awkExitStmt:
+		if s.code != nil {
+			in.exitCode = int(in.eval(s.code).num()) & 0xff
+		}
+		panic(&awkExitSignal{})
+	case *awkReturnStmt:
+		in.retval = awkValue{}
+		if s.x != nil {
+			in.retval = in.eval(s.x)
+		}
+		return awkFlowReturn
+	case *awkBreakStmt:
+		return awkFlowBreak
+	case *awkContinueStmt:
+		return awkFlowContinue
+	case *awkDeleteStmt:
+		arr := in.array(s.name)
+		if s.subs == nil {
+			for key := range arr {
+				delete(arr, key)
+			}
+		} else {
+			delete(arr, in.subscript(s.subs))
+		}
+	case *awkBlockStmt:
+		return in.exec(s.body)
+	}
+	return awkFlowNormal
+}
+
+// awkSortedKeys orders array keys numerically when they're all numbers.
+func awkSortedKeys(arr map[string]awkValue) []string {
+	keys := make([]string, 0, len(arr))
+	numeric := true
+	for key := range arr {
+		keys = append(keys, key)
+		if _, ok := awkLooksNumeric(key); !ok {
+			numeric = false
+		}
+	}
+	if numeric {
+		sort.Slice(keys, func(i, j int) bool {
+			a, _ := awkLooksNumeric(keys[i])
+			b, _ := awkLooksNumeric(keys[j])
+			return a < b
+		})
+	} else {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+func (in *awkInterp) execPrint(s *awkPrintStmt) {
+	values := make([]awkValue, 0, len(s.args))
+	for _, arg := range s.args {
+		if g, ok := arg.(*awkGrouping); ok {
+			for _, x := range g.list {
+				values = append(values, in.eval(x))
+			}
+			continue
+		}
+		values = append(values, in.eval(arg))
+	}
+	w, dest := in.out, ""
+	if s.dest != nil {
+		dest = in.toStr(in.eval(s.dest))
+		w = in.outputStream(dest, s.redirect == ">>")
+	}
+	if s.printf {
+		w.WriteString(in.sprintf(in.toStr(values[0]), values[1:]))
+	} else {
+		if len(values) == 0 {
+			values = append(values, awkInput(in.record))
+		}
+		in.printValues(w, values)
+	}
+	if dest == "/dev/stderr" {
+		w.Flush()
+	}
+}
+
+func (in *awkInterp) printValues(w *bufio.Writer, values []awkValue) {
+	ofs := in.varString("OFS")
+	for i, v := range values {
+		if i > 0 {
+			w.WriteString(ofs)
+		}
+		w.WriteString(in.toOutput(v))
+	}
+	w.WriteString(in.varString("ORS"))
+}
+
+func (in *awkInterp) outputStream(name string, appending bool) *bufio.Writer {
+	switch name {
+	case "/dev/stdout", "-":
+		return in.out
+	}
+	if stream, ok := in.streams[name]; ok && stream.w != nil {
+		return stream.w
+	}
+	if name == "/dev/stderr" {
+		in.out.Flush()
+		stream := &awkStream{w: bufio.NewWriter(in.hc.Stderr)}
+		in.streams[name] = stream
+		return stream.w
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(resolvePath(in.hc.Dir, name), flags, 0o644)
+	if err != nil {
+		in.fatal("can't redirect to %s: %v", name, errors.Unwrap(err))
+	}
+	stream := &awkStream{w: bufio.NewWriter(f), closer: f}
+	in.streams[name] = stream
+	return stream.w
+}
+
+func (in *awkInterp) closeStream(name string) int {
+	stream, ok := in.streams[name]
+	if !ok {
+		return -1
+	}
+	delete(in.streams, name)
+	result := 0
+	if stream.w != nil && stream.w.Flush() != nil {
+		result = -1
+	}
+	if stream.r != nil && stream.r.close() != nil {
+		result = -1
+	}
+	if stream.closer != nil && stream.closer.Close() != nil {
+		result = -1
+	}
+	return result
+}
+
+func (in *awkInterp) closeAll() {
+	for name := range in.streams {
+		if in.closeStream(name) != 0 {
+			in.status = 2
+		}
+	}
+	if in.main != nil {
+		in.main.close()
+	}
+}
+
+// Expressions
+
+func (in *awkInterp) eval(x awkExpr) awkValue {
+	switch e := x.(type) {
+	case *awkNumLit:
+		return awkNumber(e.n)
+	case *awkStrLit:
+		return awkString(e.s)
+	case *awkRegexLit:
+		return awkBool(e.re.MatchString(in.record))
+	case *awkVarRef:
+		return in.getVar(e.name)
+	case *awkIndexRef:
+		arr := in.array(e.name)
+		key := in.subscript(e.subs)
+		v, ok := arr[key]
+		if !ok {
+			arr[key] = v
+		}
+		return v
+	case *awkFieldRef:
+		return in.getField(in.fieldIndex(e.index))
+	case *awkAssign:
+		v := in.eval(e.rhs)
+		if e.op != "" {
+			v = awkNumber(in.arith(e.op, in.eval(e.lhs).num(), v.num()))
+		}
+		in.assign(e.lhs, v)
+		return v
+	case *awkCondExpr:
+		if in.eval(e.cond).truthy() {
+			return in.eval(e.yes)
+		}
+		return in.eval(e.no)
+	case *awkBinary:
+		return in.evalBinary(e)
+	case *awkUnary:
+		v := in.eval(e.x)
+		switch e.op {
+		case "!":
+			return awkBool(!v.truthy())
+		case "-":
+			return awkNumber(-v.num())
+		}
+		return awkNumber(v.num())
+	case *awkIncr:
+		old := in.eval(e.lhs).num()
+		in.assign(e.lhs, awkNumber(old+e.delta))
+		if e.pre {
+			return awkNumber(old + e.delta)
+		}
+		return awkNumber(old)
+	case *awkInExpr:
+		_, ok := in.array(e.name)[in.subscript(e.subs)]
+		return awkBool(ok)
+	case *awkCallExpr:
+		return in.call(e)
+	case *awkBuiltinExpr:
+		return in.builtin(e)
+	case *awkGetlineExpr:
+		return in.getline(e)
+	case *awkGrouping:
+		in.fatal("unexpected parenthesized list")
+	}
+	in.fatal("unknown expression %T", x)
+	return awkValue{}
+}
+
+func (in *awkInterp) fieldIndex(x awkExpr) int {
+	n := in.eval(x).num()
+	if n < 0 {
+		in.fatal("attempt to access field %v", n)
+	}
+	return int(n)
+}
+
+func (in *awkInterp) assign(lhs awkExpr, v awkValue) {
+	switch e := lhs.(type) {
+	case *awkVarRef:
+		in.setVar(e.name, v)
+	case *awkIndexRef:
+		in.array(e.name)[in.subscript(e.subs)] = v
+	case *awkFieldRef:
+		in.setField(in.fieldIndex(e.index), in.toStr(v))
+	default:
+		in.fatal("assignment to a non-variable")
+	}
+}
+
+func (in *awkInterp) arith(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		if b == 0 {
+			in.fatal("division by zero")
+		}
+		return a / b
+	case "%":
+		if b == 0 {
+			in.fatal("division by zero in %%")
+		}
+		return math.Mod(a, b)
+	case "^":
+		return math.Pow(a, b)
+	}
+	in.fatal("unknown operator %s", op)
+	return 0
+}
+
+func (in *awkInterp) evalBinary(e *awkBinary) awkValue {
+	switch e.op {
+	case "&&":
+		return awkBool(in.eval(e.l).truthy() && in.eval(e.r).truthy())
+	case "||":
+		return awkBool(in.eval(e.l).truthy() || in.eval(e.r).truthy())
+	case "~", "!~":
+		s := in.toStr(in.eval(e.l))
+		matched := in.mustRegex(e.r).MatchString(s)
+		return awkBool(matched == (e.op == "~"))
+	case "":
+		l := in.toStr(in.eval(e.l))
+		return awkString(l + in.toStr(in.eval(e.r)))
+	}
+	l, r := in.eval(e.l), in.eval(e.r)
+	switch e.op {
+	case "<":
+		return awkBool(in.compare(l, r) < 0)
+	case "<=":
+		return awkBool(in.compare(l, r) <= 0)
+	case ">":
+		return awkBool(in.compare(l, r) > 0)
+	case ">=":
+		return awkBool(in.compare(l, r) >= 0)
+	case "==":
+		return awkBool(in.compare(l, r) == 0)
+	case "!=":
+		return awkBool(in.compare(l, r) != 0)
+	}
+	return awkNumber(in.arith(e.op, l.num(), r.num()))
+}
+
+func (in *awkInterp) call(e *awkCallExpr) awkValue {
+	fn := in.prog.funcs[e.name]
+	if len(in.frames) > 1000 {
+		in.fatal("function call nesting too deep in %s", e.name)
+	}
+	frame := &awkFrame{locals: make(map[string]*awkCell, len(fn.params))}
+	for i, param := range fn.params {
+		cell := &awkCell{}
+		switch {
+		case i < len(e.args) && fn.arrays[i]:
+			ref, ok := e.args[i].(*awkVarRef)
+			if !ok {
+				in.fatal("function %s: argument %d must be an array", e.name, i+1)
+			}
+			cell.arr = in.array(ref.name)
+		case i < len(e.args):
+			if ref, ok := e.args[i].(*awkVarRef); ok {
+				// an array can be passed through an untyped parameter
+				if c := in.cell(ref.name); c.arr != nil {
+					cell.arr = c.arr
+					break
+				}
+			}
+			cell.v = in.eval(e.args[i])
+		case fn.arrays[i]:
+			cell.arr = map[string]awkValue{}
+		}
+		frame.locals[param] = cell
+	}
+	in.frames = append(in.frames, frame)
+	defer func() { in.frames = in.frames[:len(in.frames)-1] }()
+	in.retval = awkValue{}
+	if flow := in.exec(fn.body); flow == awkFlowBreak || flow == awkFlowContinue {
+		in.fatal("break or continue outside a loop")
+	}
+	ret := in.retval
+	in.retval = awkValue{}
+	return ret
+}
+
+func (in *awkInterp) getline(e *awkGetlineExpr) awkValue {
+	var rec string
+	if e.file == nil {
+		var ok bool
+		if rec, ok = in.nextMainRecord(); !ok {
+			return awkNumber(0)
+		}
+	} else {
+		name := in.toStr(in.eval(e.file))
+		stream, ok := in.streams[name]
+		if !ok || stream.r == nil {
+			if name == "-" || name == "/dev/stdin" {
+				stream = &awkStream{r: newAwkRecordReader(gshStdinReader(in.hc), nil)}
+			} else {
+				f, err := os.Open(resolvePath(in.hc.Dir, name))
+				if err != nil {
+					return awkNumber(-1)
+				}
+				stream = &awkStream{r: newAwkRecordReader(f, f)}
+			}
+			in.streams[name] = stream
+		}
+		line, ok, err := stream.r.read(in.varString("RS"), in.rsRegex)
+		if err != nil {
+			return awkNumber(-1)
+		}
+		if !ok {
+			return awkNumber(0)
+		}
+		rec = line
+	}
+	switch {
+	case e.lhs != nil:
+		in.assign(e.lhs, awkInput(rec))
+	default:
+		in.setRecord(rec)
+	}
+	return awkNumber(1)
+}
+
+func (in *awkInterp) builtin(e *awkBuiltinExpr) awkValue {
+	args := e.args
+	str := func(i int) string {
+		return in.toStr(in.eval(args[i]))
+	}
+	num := func(i int) float64 {
+		return in.eval(args[i]).num()
+	}
+	switch e.name {
+	case "length":
+		if len(args) == 0 {
+			return awkNumber(float64(utf8.RuneCountInString(in.record)))
+		}
+		if ref, ok := args[0].(*awkVarRef); ok {
+			if cell := in.cell(ref.name); cell.arr != nil {
+				return awkNumber(float64(len(cell.arr)))
+			}
+		}
+		return awkNumber(float64(utf8.RuneCountInString(str(0))))
+	case "substr":
+		runes := []rune(str(0))
+		start := math.RoundToEven(num(1))
+		end := float64(len(runes) + 1)
+		if len(args) == 3 {
+			length := num(2)
+			if math.IsNaN(length) {
+				return awkString("")
+			}
+			end = start + math.RoundToEven(length)
+		}
+		start = math.Max(start, 1)
+		end = math.Min(end, float64(len(runes)+1))
+		if end <= start {
+			return awkString("")
+		}
+		return awkString(string(runes[int(start)-1 : int(end)-1]))
+	case "index":
+		s, t := str(0), str(1)
+		i := strings.Index(s, t)
+		if i < 0 || t == "" {
+			return awkNumber(0)
+		}
+		return awkNumber(float64(utf8.RuneCountInString(s[:i]) + 1))
+	case "split":
+		s := str(0)
+		arr := in.array(args[1].(*awkVarRef).name)
+		var parts []string
+		switch {
+		case len(args) < 3:
+			parts = in.splitString(s, in.varString("FS"))
+		default:
+			if lit, ok := args[2].(*awkRegexLit); ok {
+				parts = awkRegexSplit(s, lit.re)
+			} else {
+				parts = in.splitString(s, str(2))
+			}
+		}
+		for key := range arr {
+			delete(arr, key)
+		}
+		for i, part := range parts {
+			arr[strconv.Itoa(i+1)] = awkInput(part)
+		}
+		return awkNumber(float64(len(parts)))
+	case "sub", "gsub":
+		re := in.mustRegex(args[0])
+		repl := str(1)
+		var target awkExpr = &awkFieldRef{index: &awkNumLit{n: 0}}
+		if len(args) == 3 {
+			target = args[2]
+		}
+		s := in.toStr(in.eval(target))
+		var matches [][]int
+		if e.name == "gsub" {
+			matches = re.FindAllStringIndex(s, -1)
+		} else if loc := re.FindStringIndex(s); loc != nil {
+			matches = [][]int{loc}
+		}
+		if len(matches) == 0 {
+			return awkNumber(0)
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			b.WriteString(s[last:m[0]])
+			b.WriteString(awkExpandReplacement(repl, s[m[0]:m[1]]))
+			last = m[1]
+		}
+		b.WriteString(s[last:])
+		in.assign(target, awkString(b.String()))
+		return awkNumber(float64(len(matches)))
+	case "match":
+		s := str(0)
+		loc := in.mustRegex(args[1]).FindStringIndex(s)
+		if loc == nil {
+			in.setVar("RSTART", awkNumber(0))
+			in.setVar("RLENGTH", awkNumber(-1))
+			return awkNumber(0)
+		}
+		start := float64(utf8.RuneCountInString(s[:loc[0]]) + 1)
+		in.setVar("RSTART", awkNumber(start))
+		in.setVar("RLENGTH", awkNumber(float64(utf8.RuneCountInString(s[loc[0]:loc[1]]))))
+		return awkNumber(start)
+	case "sprintf":
+		values := make([]awkValue, len(args)-1)
+		for i := range values {
+			values[i] = in.eval(args[i+1])
+		}
+		return awkString(in.sprintf(str(0), values))
+	case "tolower":
+		return awkString(strings.ToLower(str(0)))
+	case "toupper":
+		return awkString(strings.ToUpper(str(0)))
+	case "int":
+		return awkNumber(math.Trunc(num(0)))
+	case "sqrt":
+		return awkNumber(math.Sqrt(num(0)))
+	case "exp":
+		return awkNumber(math.Exp(num(0)))
+	case "log":
+		return awkNumber(math.Log(num(0)))
+	case "sin":
+		return awkNumber(math.Sin(num(0)))
+	case "cos":
+		return awkNumber(math.Cos(num(0)))
+	case "atan2":
+		return awkNumber(math.Atan2(num(0), num(1)))
+	case "rand":
+		return awkNumber(in.random.Float64())
+	case "srand":
+		prev := in.seed
+		if len(args) == 0 {
+			in.seed = float64(time.Now().Unix())
+		} else {
+			in.seed = num(0)
+		}
+		in.random.Seed(int64(in.seed))
+		return awkNumber(prev)
+	case "close":
+		return awkNumber(float64(in.closeStream(str(0))))
+	case "fflush":
+		if len(args) == 0 {
+			in.out.Flush()
+			for _, stream := range in.streams {
+				if stream.w != nil {
+					stream.w.Flush()
+				}
+			}
+			return awkNumber(0)
+		}
+		name := str(0)
+		if name == "/dev/stdout" || name == "-" {
+			return awkNumber(awkFlushResult(in.out.Flush()))
+		}
+		stream, ok := in.streams[name]
+		if !ok || stream.w == nil {
+			return awkNumber(-1)
+		}
+		return awkNumber(awkFlushResult(stream.w.Flush()))
+	}
+	in.fatal("%s() is not supported", e.name)
+	return awkValue{}
+}
+
+func awkFlushResult(err error) float64 {
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// awkExpandReplacement handles & and its escapes in sub and gsub
+// replacement text.
+func awkExpandReplacement(repl, matched string) string {
+	if !strings.ContainsAny(repl, `&\`) {
+		return repl
+	}
+	var b strings.Builder
+	for i := 0; i < len(repl); i++ {
+		switch {
+		case repl[i] == '\\' && i+1 < len(repl) && (repl[i+1] == '&' || repl[i+1] == '\\'):
+			i++
+			b.WriteByte(repl[i])
+		case repl[i] == '&':
+			b.WriteString(matched)
+		default:
+			b.WriteByte(repl[i])
+		}
+	}
+	return b.String()
+}
+
+// sprintf implements printf formatting with C semantics on top of Go's fmt.
+func (in *awkInterp) sprintf(format string, args []awkValue) string {
+	var b strings.Builder
+	next := func() awkValue {
+		if len(args) == 0 {
+			return awkValue{}
+		}
+		v := args[0]
+		args = args[1:]
+		return v
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		start := i
+		i++
+		if i < len(format) && format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		spec := []byte{'%'}
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			spec = append(spec, format[i])
+			i++
+		}
+		hasPrec := false
+		for part := 0; part < 2; part++ {
+			if part == 1 {
+				if i >= len(format) || format[i] != '.' {
+					break
+				}
+				hasPrec = true
+				spec = append(spec, '.')
+				i++
+			}
+			if i < len(format) && format[i] == '*' {
+				n := int(next().num())
+				if part == 0 && n < 0 {
+					spec = append(spec, '-')
+					n = -n
+				}
+				spec = strconv.AppendInt(spec, int64(n), 10)
+				i++
+				continue
+			}
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				spec = append(spec, format[i])
+				i++
+			}
+		}
+		if i >= len(format) {
+			b.WriteString(format[start:])
+			break
+		}
+		verb := format[i]
+		switch verb {
+		case 'd', 'i', 'u':
+			n := next().num()
+			if math.IsNaN(n) || math.IsInf(n, 0) || math.Abs(n) >= 1<<63 {
+				fmt.Fprintf(&b, string(spec)+"s", in.formatNum(math.Trunc(n), "%.6g"))
+			} else {
+				fmt.Fprintf(&b, string(spec)+"d", int64(n))
+			}
+		case 'o', 'x', 'X':
+			n := next().num()
+			if n < 0 {
+				fmt.Fprintf(&b, string(spec)+string(verb), uint64(int64(n)))
+			} else {
+				fmt.Fprintf(&b, string(spec)+string(verb), uint64(n))
+			}
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			if !hasPrec {
+				spec = append(spec, ".6"...)
+			}
+			fmt.Fprintf(&b, string(spec)+string(verb), next().num())
+		case 'c':
+			v := next()
+			var ch string
+			if v.kind == awkNum {
+				ch = string(rune(int(v.n)))
+			} else if s := in.toStr(v); s != "" {
+				r, _ := utf8.DecodeRuneInString(s)
+				ch = string(r)
+			}
+			fmt.Fprintf(&b, string(spec)+"s", ch)
+		case 's':
+			fmt.Fprintf(&b, string(spec)+"s", in.toOutput(next()))
+		default:
+			b.WriteString(format[start : i+1])
+		}
+	}
+	return b.String()
+}
+
+const awkUsage = "usage: awk [-F fs][-v var=value][prog | -f progfile][file ...]\n"
+
+const awkHelpText = `Usage: awk [OPTION]... 'program' [FILE|var=value]...
+   or: awk [OPTION]... -f progfile [FILE|var=value]...
+Scan each FILE (or standard input) for lines matching awk patterns and run
+the associated actions. This is the GSH built-in POSIX awk.
+
+Options:
+  -F fs          use fs as the input field separator (-Ft means tab)
+  -v var=value   assign var before the program starts
+  -f progfile    read the program from progfile (may be repeated)
+  --help         display this help and exit
+
+GSH awk supports the POSIX language, including user functions, getline from
+files, and print/printf redirection to files with > and >>. Process pipes
+(print | "cmd", "cmd" | getline) and system() are not supported. for-in
+loops visit array keys in sorted order.
+`
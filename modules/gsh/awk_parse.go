@@ -0,0 +1,1339 @@
+package gsh
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lexer, parser and syntax tree for the GSH awk builtin. The grammar is the
+// POSIX awk one; see awk.go for the interpreter.
+
+type awkTokKind int
+
+const (
+	awkTokEOF awkTokKind = iota
+	awkTokNewline
+	awkTokNumber
+	awkTokString
+	awkTokRegex
+	awkTokName
+	// a user function name immediately followed by '('
+	awkTokFuncName
+	awkTokBuiltin
+	awkTokKeyword
+	awkTokPunct
+)
+
+type awkToken struct {
+	kind awkTokKind
+	text string
+	num  float64
+	line int
+}
+
+func (t awkToken) is(kind awkTokKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+func (t awkToken) punct(text string) bool {
+	return t.is(awkTokPunct, text)
+}
+
+func (t awkToken) keyword(text string) bool {
+	return t.is(awkTokKeyword, text)
+}
+
+func (t awkToken) String() string {
+	switch t.kind {
+	case awkTokEOF:
+		return "end of program"
+	case awkTokNewline:
+		return "newline"
+	case awkTokString:
+		return strconv.Quote(t.text)
+	case awkTokRegex:
+		return "/" + t.text + "/"
+	}
+	return t.text
+}
+
+var awkKeywords = map[string]bool{
+	"BEGIN": true, "END": true, "function": true, "func": true, "if": true,
+	"else": true, "while": true, "for": true, "do": true, "break": true,
+	"continue": true, "next": true, "exit": true, "return": true,
+	"delete": true, "in": true, "getline": true, "print": true, "printf": true,
+}
+
+// awkBuiltins maps each builtin function to its minimum and maximum number
+// of arguments.
+var awkBuiltins = map[string][2]int{
+	"length": {0, 1}, "substr": {2, 3}, "index": {2, 2}, "split": {2, 3},
+	"sub": {2, 3}, "gsub": {2, 3}, "match": {2, 2}, "sprintf": {1, -1},
+	"sin": {1, 1}, "cos": {1, 1}, "atan2": {2, 2}, "exp": {1, 1},
+	"log": {1, 1}, "sqrt": {1, 1}, "int": {1, 1}, "rand": {0, 0},
+	"srand": {0, 1}, "tolower": {1, 1}, "toupper": {1, 1}, "system": {1, 1},
+	"close": {1, 1}, "fflush": {0, 1},
+}
+
+func isAwkBuiltin(name string) bool {
+	_, ok := awkBuiltins[name]
+	return ok
+}
+
+type awkSyntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *awkSyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at source line %d: %s", e.line, e.msg)
+}
+
+func lexAwk(src string) ([]awkToken, error) {
+	var toks []awkToken
+	line := 1
+	// lastOperand reports whether a '/' divides rather than starting a regex
+	lastOperand := func() bool {
+		if len(toks) == 0 {
+			return false
+		}
+		t := toks[len(toks)-1]
+		switch t.kind {
+		case awkTokNumber, awkTokString, awkTokName, awkTokBuiltin, awkTokRegex:
+			return true
+		case awkTokKeyword:
+			return t.text == "getline"
+		case awkTokPunct:
+			return t.text == ")" || t.text == "]" || t.text == "$" || t.text == "++" || t.text == "--"
+		}
+		return false
+	}
+	// Newlines after these are insignificant
+	continues := func() bool {
+		if len(toks) == 0 {
+			return true
+		}
+		t := toks[len(toks)-1]
+		switch t.kind {
+		case awkTokNewline:
+			return true
+		case awkTokPunct:
+			return t.text == "," || t.text == "{" || t.text == "&&" || t.text == "||"
+		case awkTokKeyword:
+			return t.text == "do" || t.text == "else"
+		}
+		return false
+	}
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+		case ch == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			i += 2
+			line++
+		case ch == '\\' && i+2 < len(src) && src[i+1] == '\r' && src[i+2] == '\n':
+			i += 3
+			line++
+		case ch == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case ch == '\n':
+			if !continues() {
+				toks = append(toks, awkToken{kind: awkTokNewline, text: "\n", line: line})
+			}
+			line++
+			i++
+		case ch == '"':
+			s, n, err := awkUnquote(src[i+1:], '"')
+			if err != nil {
+				return nil, &awkSyntaxError{line: line, msg: err.Error()}
+			}
+			toks = append(toks, awkToken{kind: awkTokString, text: s, line: line})
+			line += strings.Count(src[i:i+1+n], "\n")
+			i += 1 + n
+		case ch == '/' && !lastOperand():
+			re, n, err := awkRegexSource(src[i+1:])
+			if err != nil {
+				return nil, &awkSyntaxError{line: line, msg: err.Error()}
+			}
+			toks = append(toks, awkToken{kind: awkTokRegex, text: re, line: line})
+			i += 1 + n
+		case ch >= '0' && ch <= '9' || ch == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && src[k] >= '0' && src[k] <= '9' {
+					for k < len(src) && src[k] >= '0' && src[k] <= '9' {
+						k++
+					}
+					j = k
+				}
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, &awkSyntaxError{line: line, msg: fmt.Sprintf("invalid number %q", src[i:j])}
+			}
+			toks = append(toks, awkToken{kind: awkTokNumber, text: src[i:j], num: n, line: line})
+			i = j
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			word := src[i:j]
+			kind := awkTokName
+			switch {
+			case awkKeywords[word]:
+				kind = awkTokKeyword
+				if word == "func" {
+					word = "function"
+				}
+			case isAwkBuiltin(word):
+				kind = awkTokBuiltin
+			case j < len(src) && src[j] == '(':
+				kind = awkTokFuncName
+			}
+			toks = append(toks, awkToken{kind: kind, text: word, line: line})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"**=", "&&", "||", "++", "--", "+=", "-=", "*=", "/=", "%=", "^=", "==", "<=", ">=", "!=", "!~", ">>", "**"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("{}()[];,+-*/%^!><|?:~$=", rune(ch)) {
+					return nil, &awkSyntaxError{line: line, msg: fmt.Sprintf("unexpected character %q", ch)}
+				}
+				op = string(ch)
+			}
+			i += len(op)
+			switch op {
+			case "**":
+				op = "^"
+			case "**=":
+				op = "^="
+			}
+			toks = append(toks, awkToken{kind: awkTokPunct, text: op, line: line})
+		}
+	}
+	toks = append(toks, awkToken{kind: awkTokEOF, line: line})
+	return toks, nil
+}
+
+// awkUnquote decodes a string up to the closing quote, returning the number
+// of source bytes used including the quote.
+func awkUnquote(src string, quote byte) (string, int, error) {
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch ch {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\n':
+			return "", 0, fmt.Errorf("newline in string")
+		case '\\':
+			i++
+			if i >= len(src) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i += awkEscape(&b, src[i:]) - 1
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// awkEscape writes the character for the escape sequence at the start of s
+// (after the backslash) and returns how many bytes it used.
+func awkEscape(b *strings.Builder, s string) int {
+	switch s[0] {
+	case 'n':
+		b.WriteByte('\n')
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case 'a':
+		b.WriteByte('\a')
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'v':
+		b.WriteByte('\v')
+	case '\\', '"', '/':
+		b.WriteByte(s[0])
+	case '\n':
+	default:
+		if s[0] >= '0' && s[0] <= '7' {
+			n, used := 0, 0
+			for used < 3 && used < len(s) && s[used] >= '0' && s[used] <= '7' {
+				n = n*8 + int(s[used]-'0')
+				used++
+			}
+			b.WriteByte(byte(n))
+			return used
+		}
+		b.WriteByte('\\')
+		b.WriteByte(s[0])
+	}
+	return 1
+}
+
+// awkProcessEscapes applies string escapes to -v and command-line
+// assignments, as awk does.
+func awkProcessEscapes(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i += awkEscape(&b, s[i+1:])
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// awkRegexSource reads a regex literal up to the closing slash; "\/" is a
+// slash and a slash inside a bracket expression doesn't end the regex.
+func awkRegexSource(src string) (string, int, error) {
+	var b strings.Builder
+	inBracket := false
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == '\n':
+			return "", 0, fmt.Errorf("newline in regex")
+		case ch == '\\' && i+1 < len(src):
+			if src[i+1] == '/' {
+				b.WriteByte('/')
+			} else {
+				b.WriteByte(ch)
+				b.WriteByte(src[i+1])
+			}
+			i++
+		case ch == '[' && !inBracket:
+			inBracket = true
+			b.WriteByte(ch)
+			if i+1 < len(src) && src[i+1] == '^' {
+				i++
+				b.WriteByte('^')
+			}
+			if i+1 < len(src) && src[i+1] == ']' {
+				i++
+				b.WriteByte(']')
+			}
+		case ch == ']' && inBracket:
+			inBracket = false
+			b.WriteByte(ch)
+		case ch == '/' && !inBracket:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated regex")
+}
+
+func compileAwkRegex(src string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("(?s)" + translatePosixRegex(src, true))
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex /%s/: %v", src, err)
+	}
+	return re, nil
+}
+
+// Syntax tree
+
+type awkExpr interface{}
+
+type (
+	awkNumLit   struct{ n float64 }
+	awkStrLit   struct{ s string }
+	awkRegexLit struct{ re *regexp.Regexp }
+	awkVarRef   struct{ name string }
+	awkIndexRef struct {
+		name string
+		subs []awkExpr
+	}
+	awkFieldRef struct{ index awkExpr }
+	awkAssign   struct {
+		// op is "" for plain assignment, otherwise the arithmetic operator
+		op       string
+		lhs, rhs awkExpr
+	}
+	awkCondExpr struct{ cond, yes, no awkExpr }
+	awkBinary   struct {
+		// op "" is concatenation
+		op   string
+		l, r awkExpr
+	}
+	awkUnary struct {
+		op string
+		x  awkExpr
+	}
+	awkIncr struct {
+		lhs   awkExpr
+		delta float64
+		pre   bool
+	}
+	awkInExpr struct {
+		subs []awkExpr
+		name string
+	}
+	awkCallExpr struct {
+		name string
+		args []awkExpr
+		line int
+	}
+	awkBuiltinExpr struct {
+		name string
+		args []awkExpr
+	}
+	awkGetlineExpr struct {
+		lhs  awkExpr
+		file awkExpr
+	}
+	// awkGrouping is a parenthesized list, valid only before "in" or as
+	// print arguments.
+	awkGrouping struct{ list []awkExpr }
+)
+
+type awkStmt interface{}
+
+type (
+	awkExprStmt  struct{ x awkExpr }
+	awkPrintStmt struct {
+		printf   bool
+		args     []awkExpr
+		redirect string
+		dest     awkExpr
+	}
+	awkIfStmt struct {
+		cond      awkExpr
+		then, els []awkStmt
+	}
+	awkWhileStmt struct {
+		cond awkExpr
+		body []awkStmt
+	}
+	awkDoStmt struct {
+		body []awkStmt
+		cond awkExpr
+	}
+	awkForStmt struct {
+		init awkStmt
+		cond awkExpr
+		post awkStmt
+		body []awkStmt
+	}
+	awkForInStmt struct {
+		key   awkExpr
+		array string
+		body  []awkStmt
+	}
+	awkNextStmt     struct{}
+	awkExitStmt     struct{ code awkExpr }
+	awkReturnStmt   struct{ x awkExpr }
+	awkBreakStmt    struct{}
+	awkContinueStmt struct{}
+	awkDeleteStmt   struct {
+		name string
+		// subs is nil to delete the whole array
+		subs []awkExpr
+	}
+	awkBlockStmt struct{ body []awkStmt }
+)
+
+type awkItem struct {
+	pattern, pattern2 awkExpr
+	body              []awkStmt
+	hasBody           bool
+	// inRange tracks an active pattern1, pattern2 range
+	inRange bool
+}
+
+type awkFunction struct {
+	name   string
+	params []string
+	// arrays marks the parameters used as arrays
+	arrays []bool
+	body   []awkStmt
+}
+
+type awkProgram struct {
+	begins, ends []*awkItem
+	items        []*awkItem
+	funcs        map[string]*awkFunction
+}
+
+type awkParser struct {
+	toks []awkToken
+	pos  int
+	prog *awkProgram
+	// noGreater makes '>' a print redirection rather than a comparison
+	noGreater bool
+	// function being parsed, for resolving parameters
+	fn    *awkFunction
+	calls []*awkCallExpr
+	loops int
+}
+
+func parseAwk(src string) (*awkProgram, error) {
+	toks, err := lexAwk(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &awkParser{toks: toks, prog: &awkProgram{funcs: map[string]*awkFunction{}}}
+	if err := p.catch(p.program); err != nil {
+		return nil, err
+	}
+	for _, call := range p.calls {
+		fn, ok := p.prog.funcs[call.name]
+		if !ok {
+			return nil, &awkSyntaxError{line: call.line, msg: fmt.Sprintf("calling undefined function %s", call.name)}
+		}
+		if len(call.args) > len(fn.params) {
+			return nil, &awkSyntaxError{line: call.line, msg: fmt.Sprintf("function %s called with %d args, accepts only %d", call.name, len(call.args), len(fn.params))}
+		}
+	}
+	p.resolveArrayParams()
+	return p.prog, nil
+}
+
+// catch turns a parse panic into an error.
+func (p *awkParser) catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*awkSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = serr
+		}
+	}()
+	f()
+	return nil
+}
+
+func (p *awkParser) tok() awkToken {
+	return p.toks[p.pos]
+}
+
+func (p *awkParser) peekTok(n int) awkToken {
+	if p.pos+n < len(p.toks) {
+		return p.toks[p.pos+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *awkParser) advance() awkToken {
+	t := p.toks[p.pos]
+	if t.kind != awkTokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *awkParser) fail(format string, args ...interface{}) {
+	panic(&awkSyntaxError{line: p.tok().line, msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *awkParser) expect(text string) {
+	if !p.tok().punct(text) {
+		p.fail("expected %q near %s", text, p.tok())
+	}
+	p.advance()
+}
+
+func (p *awkParser) optNewlines() {
+	for p.tok().kind == awkTokNewline {
+		p.advance()
+	}
+}
+
+func (p *awkParser) skipTerminators() {
+	for p.tok().kind == awkTokNewline || p.tok().punct(";") {
+		p.advance()
+	}
+}
+
+func (p *awkParser) program() {
+	p.skipTerminators()
+	for p.tok().kind != awkTokEOF {
+		switch {
+		case p.tok().keyword("BEGIN"):
+			p.advance()
+			p.prog.begins = append(p.prog.begins, &awkItem{body: p.actionBlock(), hasBody: true})
+		case p.tok().keyword("END"):
+			p.advance()
+			p.prog.ends = append(p.prog.ends, &awkItem{body: p.actionBlock(), hasBody: true})
+		case p.tok().keyword("function"):
+			p.function()
+		default:
+			item := &awkItem{}
+			if !p.tok().punct("{") {
+				item.pattern = p.expr()
+				if p.tok().punct(",") {
+					p.advance()
+					p.optNewlines()
+					item.pattern2 = p.expr()
+				}
+			}
+			if p.tok().punct("{") {
+				item.body = p.block()
+				item.hasBody = true
+			}
+			p.prog.items = append(p.prog.items, item)
+		}
+		if t := p.tok(); t.kind != awkTokEOF && t.kind != awkTokNewline && !t.punct(";") && !p.toks[p.pos-1].punct("}") {
+			p.fail("unexpected %s", t)
+		}
+		p.skipTerminators()
+	}
+}
+
+func (p *awkParser) actionBlock() []awkStmt {
+	if !p.tok().punct("{") {
+		p.fail("BEGIN and END need an action")
+	}
+	return p.block()
+}
+
+func (p *awkParser) function() {
+	p.advance()
+	t := p.advance()
+	if t.kind != awkTokName && t.kind != awkTokFuncName {
+		p.fail("expected a function name near %s", t)
+	}
+	if _, exists := p.prog.funcs[t.text]; exists {
+		p.fail("function %s redefined", t.text)
+	}
+	fn := &awkFunction{name: t.text}
+	p.expect("(")
+	for !p.tok().punct(")") {
+		param := p.advance()
+		if param.kind != awkTokName {
+			p.fail("expected a parameter name near %s", param)
+		}
+		fn.params = append(fn.params, param.text)
+		if p.tok().punct(",") {
+			p.advance()
+			p.optNewlines()
+		} else if !p.tok().punct(")") {
+			p.fail("expected , or ) near %s", p.tok())
+		}
+	}
+	p.advance()
+	p.optNewlines()
+	fn.arrays = make([]bool, len(fn.params))
+	p.prog.funcs[fn.name] = fn
+	p.fn = fn
+	fn.body = p.block()
+	p.fn = nil
+}
+
+func (p *awkParser) block() []awkStmt {
+	p.expect("{")
+	var body []awkStmt
+	for {
+		p.skipTerminators()
+		if p.tok().punct("}") {
+			p.advance()
+			return body
+		}
+		if p.tok().kind == awkTokEOF {
+			p.fail("unexpected end of program, missing }")
+		}
+		body = append(body, p.statement())
+	}
+}
+
+// bodyStatement reads the statement controlled by if/while/for/do.
+func (p *awkParser) bodyStatement() []awkStmt {
+	p.optNewlines()
+	if p.tok().punct(";") {
+		p.advance()
+		return nil
+	}
+	stmt := p.statement()
+	if block, ok := stmt.(*awkBlockStmt); ok {
+		return block.body
+	}
+	return []awkStmt{stmt}
+}
+
+func (p *awkParser) endSimple() {
+	switch t := p.tok(); {
+	case t.punct(";") || t.kind == awkTokNewline:
+		p.advance()
+	case t.punct("}") || t.kind == awkTokEOF:
+	default:
+		p.fail("unexpected %s", t)
+	}
+}
+
+func (p *awkParser) statement() awkStmt {
+	t := p.tok()
+	switch {
+	case t.punct("{"):
+		return &awkBlockStmt{body: p.block()}
+	case t.keyword("if"):
+		p.advance()
+		p.expect("(")
+		stmt := &awkIfStmt{cond: p.expr()}
+		p.expect(")")
+		stmt.then = p.bodyStatement()
+		save := p.pos
+		p.skipTerminators()
+		if p.tok().keyword("else") {
+			p.advance()
+			stmt.els = p.bodyStatement()
+		} else {
+			p.pos = save
+		}
+		return stmt
+	case t.keyword("while"):
+		p.advance()
+		p.expect("(")
+		stmt := &awkWhileStmt{cond: p.expr()}
+		p.expect(")")
+		if p.tok().punct(";") {
+			p.advance()
+			return stmt
+		}
+		p.loops++
+		stmt.body = p.bodyStatement()
+		p.loops--
+		return stmt
+	case t.keyword("do"):
+		p.advance()
+		p.loops++
+		stmt := &awkDoStmt{body: p.bodyStatement()}
+		p.loops--
+		p.skipTerminators()
+		if !p.tok().keyword("while") {
+			p.fail("expected while after do body near %s", p.tok())
+		}
+		p.advance()
+		p.expect("(")
+		stmt.cond = p.expr()
+		p.expect(")")
+		p.endSimple()
+		return stmt
+	case t.keyword("for"):
+		return p.forStatement()
+	case t.punct(";"):
+		p.advance()
+		return &awkBlockStmt{}
+	}
+	stmt := p.simpleStatement()
+	p.endSimple()
+	return stmt
+}
+
+func (p *awkParser) forStatement() awkStmt {
+	p.advance()
+	p.expect("(")
+	if p.tok().kind == awkTokName && p.peekTok(1).keyword("in") && p.peekTok(2).kind == awkTokName && p.peekTok(3).punct(")") {
+		key := &awkVarRef{name: p.advance().text}
+		p.advance()
+		array := p.advance().text
+		p.markArray(array)
+		p.advance()
+		p.loops++
+		body := p.bodyStatement()
+		p.loops--
+		return &awkForInStmt{key: key, array: array, body: body}
+	}
+	stmt := &awkForStmt{}
+	if !p.tok().punct(";") {
+		stmt.init = p.simpleStatement()
+	}
+	p.expect(";")
+	p.optNewlines()
+	if !p.tok().punct(";") {
+		stmt.cond = p.expr()
+	}
+	p.expect(";")
+	p.optNewlines()
+	if !p.tok().punct(")") {
+		stmt.post = p.simpleStatement()
+	}
+	p.expect(")")
+	if p.tok().punct(";") {
+		p.advance()
+		return stmt
+	}
+	p.loops++
+	stmt.body = p.bodyStatement()
+	p.loops--
+	return stmt
+}
+
+func (p *awkParser) simpleStatement() awkStmt {
+	t := p.tok()
+	switch {
+	case t.keyword("print"), t.keyword("printf"):
+		return p.printStatement()
+	case t.keyword("next"):
+		p.advance()
+		return &awkNextStmt{}
+	case t.keyword("exit"):
+		p.advance()
+		stmt := &awkExitStmt{}
+		if !p.atStatementEnd() {
+			stmt.code = p.expr()
+		}
+		return stmt
+	case t.keyword("return"):
+		p.advance()
+		if p.fn == nil {
+			p.fail("return outside a function")
+		}
+		stmt := &awkReturnStmt{}
+		if !p.atStatementEnd() {
+			stmt.x = p.expr()
+		}
+		return stmt
+	case t.keyword("break"), t.keyword("continue"):
+		p.advance()
+		if p.loops == 0 {
+			p.fail("%s outside a loop", t.text)
+		}
+		if t.text == "break" {
+			return &awkBreakStmt{}
+		}
+		return &awkContinueStmt{}
+	case t.keyword("delete"):
+		p.advance()
+		name := p.advance()
+		if name.kind != awkTokName {
+			p.fail("expected an array name after delete near %s", name)
+		}
+		p.markArray(name.text)
+		stmt := &awkDeleteStmt{name: name.text}
+		if p.tok().punct("[") {
+			p.advance()
+			stmt.subs = p.exprList("]")
+		}
+		return stmt
+	}
+	return &awkExprStmt{x: p.expr()}
+}
+
+func (p *awkParser) atStatementEnd() bool {
+	t := p.tok()
+	return t.punct(";") || t.punct("}") || t.kind == awkTokNewline || t.kind == awkTokEOF
+}
+
+func (p *awkParser) printStatement() awkStmt {
+	stmt := &awkPrintStmt{printf: p.advance().text == "printf"}
+	if p.tok().punct("(") {
+		// print (a, b) > "file" groups the whole argument list
+		save := p.pos
+		p.advance()
+		args := p.exprList(")")
+		if end := p.tok(); p.atStatementEnd() || end.punct(">") || end.punct(">>") || end.punct("|") {
+			stmt.args = args
+		} else {
+			p.pos = save
+		}
+	}
+	if stmt.args == nil && !p.atStatementEnd() && !p.tok().punct(">") && !p.tok().punct(">>") && !p.tok().punct("|") {
+		saved := p.noGreater
+		p.noGreater = true
+		stmt.args = append(stmt.args, p.expr())
+		for p.tok().punct(",") {
+			p.advance()
+			p.optNewlines()
+			stmt.args = append(stmt.args, p.expr())
+		}
+		p.noGreater = saved
+	}
+	if stmt.printf && len(stmt.args) == 0 {
+		p.fail("printf: no format")
+	}
+	switch t := p.tok(); {
+	case t.punct(">"), t.punct(">>"):
+		p.advance()
+		stmt.redirect = t.text
+		saved := p.noGreater
+		p.noGreater = true
+		stmt.dest = p.concat()
+		p.noGreater = saved
+	case t.punct("|"):
+		p.fail("output pipes (print | \"command\") are not supported in gsh awk")
+	}
+	return stmt
+}
+
+func (p *awkParser) exprList(end string) []awkExpr {
+	saved := p.noGreater
+	p.noGreater = false
+	defer func() { p.noGreater = saved }()
+	var list []awkExpr
+	p.optNewlines()
+	for !p.tok().punct(end) {
+		list = append(list, p.expr())
+		p.optNewlines()
+		if p.tok().punct(",") {
+			p.advance()
+			p.optNewlines()
+		} else if !p.tok().punct(end) {
+			p.fail("expected , or %s near %s", end, p.tok())
+		}
+	}
+	p.advance()
+	return list
+}
+
+func isAwkLvalue(x awkExpr) bool {
+	switch x.(type) {
+	case *awkVarRef, *awkIndexRef, *awkFieldRef:
+		return true
+	}
+	return false
+}
+
+func (p *awkParser) expr() awkExpr {
+	left := p.ternary()
+	t := p.tok()
+	if t.kind == awkTokPunct && isAwkLvalue(left) {
+		switch t.text {
+		case "=", "+=", "-=", "*=", "/=", "%=", "^=":
+			p.advance()
+			p.optNewlines()
+			return &awkAssign{op: strings.TrimSuffix(t.text, "="), lhs: left, rhs: p.expr()}
+		}
+	}
+	return left
+}
+
+func (p *awkParser) ternary() awkExpr {
+	cond := p.or()
+	if !p.tok().punct("?") {
+		return cond
+	}
+	p.advance()
+	p.optNewlines()
+	yes := p.expr()
+	p.optNewlines()
+	p.expect(":")
+	p.optNewlines()
+	return &awkCondExpr{cond: cond, yes: yes, no: p.expr()}
+}
+
+func (p *awkParser) or() awkExpr {
+	left := p.and()
+	for p.tok().punct("||") {
+		p.advance()
+		left = &awkBinary{op: "||", l: left, r: p.and()}
+	}
+	return left
+}
+
+func (p *awkParser) and() awkExpr {
+	left := p.in()
+	for p.tok().punct("&&") {
+		p.advance()
+		left = &awkBinary{op: "&&", l: left, r: p.in()}
+	}
+	return left
+}
+
+func (p *awkParser) in() awkExpr {
+	left := p.match()
+	for p.tok().keyword("in") {
+		p.advance()
+		name := p.advance()
+		if name.kind != awkTokName {
+			p.fail("expected an array name after in near %s", name)
+		}
+		p.markArray(name.text)
+		subs := []awkExpr{left}
+		if g, ok := left.(*awkGrouping); ok {
+			subs = g.list
+		}
+		left = &awkInExpr{subs: subs, name: name.text}
+	}
+	if _, ok := left.(*awkGrouping); ok {
+		p.fail("unexpected parenthesized list")
+	}
+	return left
+}
+
+func (p *awkParser) match() awkExpr {
+	left := p.relational()
+	for p.tok().punct("~") || p.tok().punct("!~") {
+		op := p.advance().text
+		left = &awkBinary{op: op, l: left, r: p.relational()}
+	}
+	return left
+}
+
+func (p *awkParser) relational() awkExpr {
+	left := p.concat()
+	switch t := p.tok(); {
+	case t.punct(">") && p.noGreater:
+	case t.punct("<"), t.punct("<="), t.punct("=="), t.punct("!="), t.punct(">"), t.punct(">="):
+		p.advance()
+		return &awkBinary{op: t.text, l: left, r: p.concat()}
+	}
+	return left
+}
+
+func (p *awkParser) startsConcat() bool {
+	t := p.tok()
+	switch t.kind {
+	case awkTokNumber, awkTokString, awkTokName, awkTokFuncName, awkTokBuiltin, awkTokRegex:
+		return true
+	case awkTokPunct:
+		return t.text == "$" || t.text == "(" || t.text == "++" || t.text == "--"
+	}
+	return false
+}
+
+func (p *awkParser) concat() awkExpr {
+	left := p.additive()
+	for {
+		if p.tok().punct("|") && p.peekTok(1).keyword("getline") {
+			p.fail("command pipes (\"command\" | getline) are not supported in gsh awk")
+		}
+		if !p.startsConcat() {
+			return left
+		}
+		left = &awkBinary{op: "", l: left, r: p.additive()}
+	}
+}
+
+func (p *awkParser) additive() awkExpr {
+	left := p.multiplicative()
+	for p.tok().punct("+") || p.tok().punct("-") {
+		op := p.advance().text
+		left = &awkBinary{op: op, l: left, r: p.multiplicative()}
+	}
+	return left
+}
+
+func (p *awkParser) multiplicative() awkExpr {
+	left := p.unary()
+	for p.tok().punct("*") || p.tok().punct("/") || p.tok().punct("%") {
+		op := p.advance().text
+		left = &awkBinary{op: op, l: left, r: p.unary()}
+	}
+	return left
+}
+
+func (p *awkParser) unary() awkExpr {
+	switch t := p.tok(); {
+	case t.punct("!"):
+		p.advance()
+		return &awkUnary{op: "!", x: p.unary()}
+	case t.punct("-"), t.punct("+"):
+		p.advance()
+		return &awkUnary{op: t.text, x: p.unary()}
+	}
+	return p.power()
+}
+
+func (p *awkParser) power() awkExpr {
+	base := p.postfix()
+	if p.tok().punct("^") {
+		p.advance()
+		// right associative, and the exponent may be negated
+		var exp awkExpr
+		if p.tok().punct("-") || p.tok().punct("+") || p.tok().punct("!") {
+			op := p.advance().text
+			exp = &awkUnary{op: op, x: p.power()}
+		} else {
+			exp = p.power()
+		}
+		return &awkBinary{op: "^", l: base, r: exp}
+	}
+	return base
+}
+
+func (p *awkParser) postfix() awkExpr {
+	x := p.primary()
+	if isAwkLvalue(x) && (p.tok().punct("++") || p.tok().punct("--")) {
+		delta := 1.0
+		if p.advance().text == "--" {
+			delta = -1
+		}
+		return &awkIncr{lhs: x, delta: delta}
+	}
+	return x
+}
+
+func (p *awkParser) primary() awkExpr {
+	t := p.advance()
+	switch t.kind {
+	case awkTokNumber:
+		return &awkNumLit{n: t.num}
+	case awkTokString:
+		return &awkStrLit{s: t.text}
+	case awkTokRegex:
+		re, err := compileAwkRegex(t.text)
+		if err != nil {
+			p.pos--
+			p.fail("%v", err)
+		}
+		return &awkRegexLit{re: re}
+	case awkTokName:
+		if p.tok().punct("[") {
+			p.advance()
+			p.markArray(t.text)
+			return &awkIndexRef{name: t.text, subs: p.exprList("]")}
+		}
+		return &awkVarRef{name: t.text}
+	case awkTokFuncName:
+		p.expect("(")
+		call := &awkCallExpr{name: t.text, args: p.exprList(")"), line: t.line}
+		p.calls = append(p.calls, call)
+		return call
+	case awkTokBuiltin:
+		return p.builtin(t)
+	case awkTokKeyword:
+		if t.text == "getline" {
+			return p.getline()
+		}
+	case awkTokPunct:
+		switch t.text {
+		case "$":
+			var index awkExpr
+			switch {
+			case p.tok().punct("++") || p.tok().punct("--"):
+				index = p.preIncrement(p.advance())
+			case p.tok().punct("-") || p.tok().punct("+") || p.tok().punct("!"):
+				op := p.advance().text
+				index = &awkUnary{op: op, x: p.primary()}
+			default:
+				index = p.primary()
+			}
+			return &awkFieldRef{index: index}
+		case "(":
+			list := p.exprList(")")
+			switch len(list) {
+			case 0:
+				p.fail("empty parentheses")
+			case 1:
+				return list[0]
+			}
+			return &awkGrouping{list: list}
+		case "++", "--":
+			return p.preIncrement(t)
+		case "-", "+", "!":
+			return &awkUnary{op: t.text, x: p.unary()}
+		}
+	}
+	p.pos--
+	p.fail("unexpected %s", t)
+	return nil
+}
+
+func (p *awkParser) preIncrement(op awkToken) awkExpr {
+	x := p.primary()
+	if !isAwkLvalue(x) {
+		p.fail("%s needs a variable, field or array element", op.text)
+	}
+	delta := 1.0
+	if op.text == "--" {
+		delta = -1
+	}
+	return &awkIncr{lhs: x, delta: delta, pre: true}
+}
+
+func (p *awkParser) builtin(t awkToken) awkExpr {
+	call := &awkBuiltinExpr{name: t.text}
+	if p.tok().punct("(") {
+		p.advance()
+		call.args = p.exprList(")")
+	} else if t.text != "length" {
+		p.fail("%s requires arguments", t.text)
+	}
+	limits := awkBuiltins[t.text]
+	if len(call.args) < limits[0] || limits[1] >= 0 && len(call.args) > limits[1] {
+		p.fail("wrong number of arguments to %s", t.text)
+	}
+	switch t.text {
+	case "split":
+		ref, ok := call.args[1].(*awkVarRef)
+		if !ok {
+			p.fail("split: second argument must be an array name")
+		}
+		p.markArray(ref.name)
+	case "sub", "gsub":
+		if len(call.args) == 3 && !isAwkLvalue(call.args[2]) {
+			p.fail("%s: third argument must be a variable, field or array element", t.text)
+		}
+	case "system":
+		p.fail("system() is not supported in gsh awk")
+	}
+	return call
+}
+
+func (p *awkParser) getline() awkExpr {
+	g := &awkGetlineExpr{}
+	switch t := p.tok(); {
+	case t.kind == awkTokName:
+		p.advance()
+		if p.tok().punct("[") {
+			p.advance()
+			p.markArray(t.text)
+			g.lhs = &awkIndexRef{name: t.text, subs: p.exprList("]")}
+		} else {
+			g.lhs = &awkVarRef{name: t.text}
+		}
+	case t.punct("$"):
+		g.lhs = p.primary()
+	}
+	if p.tok().punct("<") {
+		p.advance()
+		g.file = p.postfix()
+	}
+	return g
+}
+
+// markArray records array use of a function parameter.
+func (p *awkParser) markArray(name string) {
+	if p.fn == nil {
+		return
+	}
+	for i, param := range p.fn.params {
+		if param == name {
+			p.fn.arrays[i] = true
+		}
+	}
+}
+
+// resolveArrayParams propagates array-ness through parameters passed on to
+// other functions' array parameters.
+func (p *awkParser) resolveArrayParams() {
+	for changed := true; changed; {
+		changed = false
+		for _, fn := range p.prog.funcs {
+			awkWalkCalls(fn.body, func(call *awkCallExpr) {
+				callee := p.prog.funcs[call.name]
+				for i, arg := range call.args {
+					ref, ok := arg.(*awkVarRef)
+					if !ok || i >= len(callee.arrays) || !callee.arrays[i] {
+						continue
+					}
+					for j, param := range fn.params {
+						if param == ref.name && !fn.arrays[j] {
+							fn.arrays[j] = true
+							changed = true
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// awkWalkCalls visits the user function calls made directly in body.
+func awkWalkCalls(body []awkStmt, visit func(*awkCallExpr)) {
+	var expr func(awkExpr)
+	exprs := func(list []awkExpr) {
+		for _, x := range list {
+			expr(x)
+		}
+	}
+	expr = func(x awkExpr) {
+		switch x := x.(type) {
+		case *awkCallExpr:
+			visit(x)
+			exprs(x.args)
+		case *awkIndexRef:
+			exprs(x.subs)
+		case *awkFieldRef:
+			expr(x.index)
+		case *awkAssign:
+			expr(x.lhs)
+			expr(x.rhs)
+		case *awkCondExpr:
+			exprs([]awkExpr{x.cond, x.yes, x.no})
+		case *awkBinary:
+			expr(x.l)
+			expr(x.r)
+		case *awkUnary:
+			expr(x.x)
+		case *awkIncr:
+			expr(x.lhs)
+		case *awkInExpr:
+			exprs(x.subs)
+		case *awkBuiltinExpr:
+			exprs(x.args)
+		case *awkGetlineExpr:
+			if x.lhs != nil {
+				expr(x.lhs)
+			}
+			if x.file != nil {
+				expr(x.file)
+			}
+		}
+	}
+	var stmts func([]awkStmt)
+	stmts = func(list []awkStmt) {
+		for _, s := range list {
+			switch s := s.(type) {
+			case *awkExprStmt:
+				expr(s.x)
+			case *awkPrintStmt:
+				exprs(s.args)
+				if s.dest != nil {
+					expr(s.dest)
+				}
+			case *awkIfStmt:
+				expr(s.cond)
+				stmts(s.then)
+				stmts(s.els)
+			case *awkWhileStmt:
+				expr(s.cond)
+				stmts(s.body)
+			case *awkDoStmt:
+				stmts(s.body)
+				expr(s.cond)
+			case *awkForStmt:
+				stmts([]awkStmt{s.init, s.post})
+				if s.cond != nil {
+					expr(s.cond)
+				}
+				stmts(s.body)
+			case *awkForInStmt:
+				stmts(s.body)
+			case *awkExitStmt:
+				if s.code != nil {
+					expr(s.code)
+				}
+			case *awkReturnStmt:
+				if s.x != nil {
+					expr(s.x)
+				}
+			case *awkDeleteStmt:
+				exprs(s.subs)
+			case *awkBlockStmt:
+				stmts(s.body)
+			}
+		}
+	}
+	stmts(body)
+}
@@ -0,0 +1,91 @@
+package gsh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAwkBuiltinCases(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("one\ntwo\nthree\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		want   string
+		status int
+		stderr string
+	}{
+		// parse errors
+		{name: "system rejected", args: []string{`BEGIN { system("ls") }`}, status: 2, stderr: "system() is not supported"},
+		{name: "output pipe rejected", args: []string{`{ print $1 | "sort" }`}, status: 2, stderr: "output pipes"},
+		{name: "bare print pipe rejected", args: []string{`{ print | "cat" }`}, status: 2, stderr: "output pipes"},
+		{name: "command pipe rejected", args: []string{`BEGIN { "date" | getline x }`}, status: 2, stderr: "command pipes"},
+		{name: "unbalanced paren", args: []string{`BEGIN { print ( }`}, status: 2, stderr: "syntax error at source line 1"},
+		{name: "error line number", args: []string{"BEGIN {\n  x = 1\n  print x +\n}"}, status: 2, stderr: "syntax error at source line"},
+		{name: "unterminated block", args: []string{`BEGIN {`}, status: 2, stderr: "syntax error"},
+		{name: "no program", args: nil, status: 2, stderr: "usage"},
+
+		// getline
+		{name: "getline advances record", args: []string{`NR == 1 { getline; print "now", $0, NR }`}, stdin: "a\nb\nc\n", want: "now b 2\n"},
+		{name: "getline var keeps $0", args: []string{`{ getline nxt; print $0 "+" nxt, NR }`}, stdin: "a\nb\nc\n", want: "a+b 2\nc+b 3\n"},
+		{name: "getline from file", args: []string{`BEGIN { while ((getline < "data.txt") > 0) n++; print n, $0, NR }`}, want: "3 three 0\n"},
+		{name: "getline var from file", args: []string{`BEGIN { while ((getline line < "data.txt") > 0) s = s line; print s, NR, "[" $0 "]" }`}, want: "onetwothree 0 []\n"},
+		{name: "getline missing file", args: []string{`BEGIN { print (getline x < "missing.txt") }`}, want: "-1\n"},
+		{name: "getline at end of input", args: []string{`END { print getline, $0 }`}, stdin: "last\n", want: "0 last\n"},
+
+		// printf
+		{name: "printf width and precision", args: []string{`BEGIN { printf "%5.2f|%-4d|%3s|\n", 3.14159, 7, "x" }`}, want: " 3.14|7   |  x|\n"},
+		{name: "printf char", args: []string{`BEGIN { printf "%c%c\n", 65, "hello" }`}, want: "Ah\n"},
+		{name: "printf numeric prefix and percent", args: []string{`BEGIN { printf "%d%%\n", "42abc" }`}, want: "42%\n"},
+		{name: "printf bases", args: []string{`BEGIN { printf "%x %o %e\n", 255, 8, 1234.5 }`}, want: "ff 10 1.234500e+03\n"},
+		{name: "printf star width", args: []string{`BEGIN { printf "%*d|%-*s|\n", 5, 42, 3, "a" }`}, want: "   42|a  |\n"},
+		{name: "printf truncates", args: []string{`BEGIN { printf "%d %.3s\n", -3.9, "abcdef" }`}, want: "-3 abc\n"},
+		{name: "OFMT and CONVFMT", args: []string{`BEGIN { OFMT = "%.2f"; CONVFMT = "%.1f"; x = 3.14159; print x; print x "" }`}, want: "3.14\n3.1\n"},
+
+		// uninitialized values
+		{name: "uninitialized scalar", args: []string{`BEGIN { print x + 0, "[" x "]", length(x), (x == 0), (x == "") }`}, want: "0 [] 0 1 1\n"},
+		{name: "uninitialized array element", args: []string{`BEGIN { n = a["k"] + 1; print n, ("k" in a), ("z" in a), length(a) }`}, want: "1 1 0 1\n"},
+		{name: "missing field", args: []string{`{ print "[" $5 "]", NF }`}, stdin: "a b\n", want: "[] 2\n"},
+
+		// division by zero
+		{name: "division by zero", args: []string{`BEGIN { print 1 / 0 }`}, status: 2, stderr: "division by zero"},
+		{name: "modulo by zero", args: []string{`BEGIN { x = 0; print 5 % x }`}, status: 2, stderr: "division by zero in %"},
+		{name: "fractional modulo", args: []string{`BEGIN { print 7.5 % 2, -7 % 3 }`}, want: "1.5 -1\n"},
+
+		// -v, -F and FS
+		{name: "-v escapes", args: []string{"-v", `x=a\tb`, `BEGIN { print x }`}, want: "a\tb\n"},
+		{name: "-v attached", args: []string{"-vn=3", `BEGIN { print n * 2 }`}, want: "6\n"},
+		{name: "-v numeric string", args: []string{"-v", "n=10", `BEGIN { print (n > 9) }`}, want: "1\n"},
+		{name: "-v invalid", args: []string{"-v", "1x=2", `BEGIN {}`}, status: 2, stderr: "invalid -v argument"},
+		{name: "-F missing argument", args: []string{"-F"}, status: 2, stderr: "option requires an argument -- F"},
+		{name: "unknown option", args: []string{"-q", `BEGIN {}`}, status: 2, stderr: "unknown option -q"},
+		{name: "-F single char", args: []string{"-F:", `{ print $2, NF }`}, stdin: "a:b:c\n", want: "b 3\n"},
+		{name: "-F t is tab", args: []string{"-Ft", `{ print $2 }`}, stdin: "a\tb c\n", want: "b c\n"},
+		{name: "-F regex", args: []string{"-F", "[,;]", `{ print $3 }`}, stdin: "a,b;c\n", want: "c\n"},
+		{name: "-v FS", args: []string{"-v", "FS=,", `{ print $2 }`}, stdin: "a,b\n", want: "b\n"},
+		{name: "FS set in BEGIN", args: []string{`BEGIN { FS = "," } { print $2 }`}, stdin: "a,b\n", want: "b\n"},
+		{name: "default FS trims blanks", args: []string{`{ print $1 "|" $2 "|" NF }`}, stdin: "  a \t b  \n", want: "a|b|2\n"},
+		{name: "FS change applies to next record", args: []string{`{ FS = ","; print $1 }`}, stdin: "a,b c\nd,e f\n", want: "a,b\nd\n"},
+		{name: "operand assignment", args: []string{`{ print x, $0 }`, "x=1", "-"}, stdin: "in\n", want: "1 in\n"},
+		{name: "exit status", args: []string{`BEGIN { exit 3 }`}, status: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, status := runBuiltin(t, dir, tt.stdin, append([]string{"awk"}, tt.args...)...)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d; stderr=%q", status, tt.status, stderr)
+			}
+			if tt.stderr != "" && !strings.Contains(stderr, tt.stderr) {
+				t.Fatalf("stderr = %q, want %q", stderr, tt.stderr)
+			}
+			if tt.status == 0 && stdout != tt.want {
+				t.Fatalf("stdout = %q, want %q; stderr=%q", stdout, tt.want, stderr)
+			}
+		})
+	}
+}
@@ -83,6 +83,7 @@ func (c *shellContext) commandMap() map[string]commandHandler {
 		"getidentitycredential":           c.cmdGetIdentityCredential,
 		"linkoauth2identity":              c.cmdLinkOAuth2Identity,
 		"unlinkidentity":                  c.cmdUnlinkIdentity,
+		"publishqueuemessage":             c.cmdPublishQueueMessage,
 		"setparameter":                    c.cmdSetParameter,
 		"setworkingdirectory":             c.cmdSetWorkingDirectory,
 		"addtask":                         c.cmdAddTask,
@@ -137,7 +138,11 @@ func (c *shellContext) commandMap() map[string]commandHandler {
 		"sort":                            c.cmdSort,
 		"uniq":                            c.cmdUniq,
 		"grep":                            c.cmdGrep,
+		"cut":                             c.cmdCut,
+		"sed":                             c.cmdSed,
+		"awk":                             c.cmdAwk,
 		"jq":                              c.cmdJq,
+		"curl":                            c.cmdCurl,
 		"realpath":                        c.cmdRealpath,
 		"date":                            c.cmdDate,
 	}
@@ -424,6 +429,24 @@ func (c *shellContext) cmdUnlinkIdentity(ctx context.Context, args []string) err
 	return retCodeError(c.bot.UnlinkIdentity(args[0], args[1]))
 }
 
+func (c *shellContext) cmdPublishQueueMessage(ctx context.Context, args []string) error {
+	if len(args) < 3 {
+		return usageError(ctx, "PublishQueueMessage requires provider, topic and body, followed by optional key=value attributes")
+	}
+	var attributes map[string]string
+	if len(args) > 3 {
+		attributes = make(map[string]string, len(args)-3)
+		for _, attr := range args[3:] {
+			key, value, ok := strings.Cut(attr, "=")
+			if !ok || key == "" {
+				return usageError(ctx, "PublishQueueMessage attributes must be key=value")
+			}
+			attributes[key] = value
+		}
+	}
+	return retCodeError(c.bot.PublishQueueMessage(args[0], args[1], args[2], attributes))
+}
+
 func (c *shellContext) cmdSetParameter(ctx context.Context, args []string) error {
 	if len(args) != 2 {
 		return usageError(ctx, "SetParameter requires name and value")
@@ -1123,6 +1146,63 @@ func (c *shellContext) cmdGrep(ctx context.Context, args []string) error {
 	return interp.ExitStatus(1)
 }
 
+func (c *shellContext) cmdCut(ctx context.Context, args []string) error {
+	flags, files, err := parseCutFlags(args)
+	if err != nil {
+		return usageError(ctx, err.Error())
+	}
+	hc := interp.HandlerCtx(ctx)
+	readers, err := inputReaders(hc, files)
+	if err != nil {
+		return err
+	}
+	defer closeReaders(readers)
+	for _, reader := range readers {
+		scanner := bufio.NewScanner(reader.reader)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if flags.bytes {
+				var out []byte
+				for i := 0; i < len(line); i++ {
+					if flags.ranges.contains(i + 1) {
+						out = append(out, line[i])
+					}
+				}
+				fmt.Fprintln(hc.Stdout, string(out))
+				continue
+			}
+			if flags.characters {
+				var out []rune
+				for i, ch := range []rune(line) {
+					if flags.ranges.contains(i + 1) {
+						out = append(out, ch)
+					}
+				}
+				fmt.Fprintln(hc.Stdout, string(out))
+				continue
+			}
+			if !strings.Contains(line, flags.delimiter) {
+				if !flags.onlyDelimited {
+					fmt.Fprintln(hc.Stdout, line)
+				}
+				continue
+			}
+			var out []string
+			for i, field := range strings.Split(line, flags.delimiter) {
+				if flags.ranges.contains(i + 1) {
+					out = append(out, field)
+				}
+			}
+			fmt.Fprintln(hc.Stdout, strings.Join(out, flags.outputDelimiter))
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *shellContext) cmdRealpath(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return usageError(ctx, "realpath requires exactly one path")
@@ -1140,21 +1220,25 @@ func (c *shellContext) cmdRealpath(ctx context.Context, args []string) error {
 }
 
 func (c *shellContext) cmdDate(ctx context.Context, args []string) error {
-	now := time.Now()
-	layout := time.RFC3339
-	if len(args) == 1 && strings.HasPrefix(args[0], "+") {
-		layout = translateDateFormat(args[0][1:])
-	} else if len(args) > 0 {
-		return usageError(ctx, "date only supports +FORMAT in gsh")
-	}
-	fmt.Fprintln(interp.HandlerCtx(ctx).Stdout, now.Format(layout))
-	return nil
+	return c.runDate(ctx, args)
 }
 
 func (c *shellContext) cmdJq(ctx context.Context, args []string) error {
 	return c.runJq(ctx, args)
 }
 
+func (c *shellContext) cmdCurl(ctx context.Context, args []string) error {
+	return c.runCurl(ctx, args)
+}
+
+func (c *shellContext) cmdSed(ctx context.Context, args []string) error {
+	return c.runSed(ctx, args)
+}
+
+func (c *shellContext) cmdAwk(ctx context.Context, args []string) error {
+	return c.runAwk(ctx, args)
+}
+
 func (c *shellContext) botWithMessageOptions(ctx context.Context, args []string, direct, threaded bool) (BotAPI, string, error) {
 	bot, rest, err := c.botWithOptions(ctx, args, direct, threaded)
 	if err != nil {
@@ -1491,20 +1575,130 @@ func parseGrepFlags(args []string) (grepFlags, []string, error) {
 	return flags, rest, nil
 }
 
-func translateDateFormat(format string) string {
-	replacer := strings.NewReplacer(
-		"%Y", "2006",
-		"%m", "01",
-		"%d", "02",
-		"%H", "15",
-		"%M", "04",
-		"%S", "05",
-		"%a", "Mon",
-		"%b", "Jan",
-		"%T", "15:04:05",
-		"%F", "2006-01-02",
-	)
-	return replacer.Replace(format)
+type cutFlags struct {
+	characters      bool
+	bytes           bool
+	delimiter       string
+	outputDelimiter string
+	onlyDelimited   bool
+	ranges          cutRanges
+}
+
+// cutRanges holds 1-based inclusive ranges; an end of 0 means "to the end".
+type cutRanges [][2]int
+
+func (r cutRanges) contains(n int) bool {
+	for _, rng := range r {
+		if n >= rng[0] && (rng[1] == 0 || n <= rng[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCutFlags(args []string) (cutFlags, []string, error) {
+	flags := cutFlags{delimiter: "\t"}
+	var list string
+	setList := func(kind, value string) error {
+		if list != "" {
+			return fmt.Errorf("cut: only one of -b, -c or -f may be given")
+		}
+		list = value
+		switch kind {
+		case "c":
+			flags.characters = true
+		case "b":
+			flags.bytes = true
+		}
+		return nil
+	}
+	outputDelimiterSet := false
+	rest := args
+	for len(rest) > 0 && strings.HasPrefix(rest[0], "-") && rest[0] != "-" {
+		arg := rest[0]
+		rest = rest[1:]
+		if arg == "--" {
+			break
+		}
+		if value, ok := strings.CutPrefix(arg, "--output-delimiter="); ok {
+			flags.outputDelimiter = value
+			outputDelimiterSet = true
+			continue
+		}
+		switch arg {
+		case "--only-delimited":
+			flags.onlyDelimited = true
+			continue
+		case "--complement":
+			return cutFlags{}, nil, fmt.Errorf("cut: --complement is not supported")
+		}
+		for i := 1; i < len(arg); i++ {
+			switch ch := arg[i]; ch {
+			case 's':
+				flags.onlyDelimited = true
+			case 'n':
+				// -n is ignored, as in GNU cut
+			case 'b', 'c', 'd', 'f':
+				value := arg[i+1:]
+				if value == "" {
+					if len(rest) == 0 {
+						return cutFlags{}, nil, fmt.Errorf("cut: option -%c requires an argument", ch)
+					}
+					value = rest[0]
+					rest = rest[1:]
+				}
+				if ch == 'd' {
+					if len([]rune(value)) != 1 {
+						return cutFlags{}, nil, fmt.Errorf("cut: the delimiter must be a single character")
+					}
+					flags.delimiter = value
+				} else if err := setList(string(ch), value); err != nil {
+					return cutFlags{}, nil, err
+				}
+				i = len(arg)
+			default:
+				return cutFlags{}, nil, fmt.Errorf("unsupported cut flag -%c", ch)
+			}
+		}
+	}
+	if list == "" {
+		return cutFlags{}, nil, fmt.Errorf("cut: you must specify a list of bytes, characters, or fields")
+	}
+	ranges, err := parseCutList(list)
+	if err != nil {
+		return cutFlags{}, nil, err
+	}
+	flags.ranges = ranges
+	if !outputDelimiterSet {
+		flags.outputDelimiter = flags.delimiter
+	}
+	return flags, rest, nil
+}
+
+func parseCutList(list string) (cutRanges, error) {
+	var ranges cutRanges
+	for _, part := range strings.Split(list, ",") {
+		start, end, isRange := strings.Cut(part, "-")
+		lo, hi := 1, 0
+		var err error
+		if start != "" {
+			if lo, err = strconv.Atoi(start); err != nil || lo < 1 {
+				return nil, fmt.Errorf("cut: invalid list value %q", part)
+			}
+		}
+		switch {
+		case !isRange:
+			hi = lo
+		case end != "":
+			if hi, err = strconv.Atoi(end); err != nil || hi < lo {
+				return nil, fmt.Errorf("cut: invalid list value %q", part)
+			}
+		case start == "":
+			return nil, fmt.Errorf("cut: invalid list value %q", part)
+		}
+		ranges = append(ranges, [2]int{lo, hi})
+	}
+	return ranges, nil
 }
 
 func min(a, b int) int {
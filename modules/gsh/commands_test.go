@@ -2,12 +2,22 @@ package gsh
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/robot"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
 )
 
 func writeTempScript(t *testing.T, dir, name, contents string) string {
@@ -19,6 +29,36 @@ func writeTempScript(t *testing.T, dir, name, contents string) string {
 	return path
 }
 
+// runBuiltin runs one command line through the gsh exec handler in dir and
+// returns its output and exit status.
+func runBuiltin(t *testing.T, dir, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	file, err := syntax.NewParser().Parse(strings.NewReader(`"$@"`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &shellContext{envMap: map[string]string{}}
+	var stdout, stderr bytes.Buffer
+	runner, err := interp.New(
+		interp.Dir(dir),
+		interp.StdIO(strings.NewReader(stdin), &stdout, &stderr),
+		interp.Params(append([]string{"--"}, args...)...),
+		interp.ExecHandlers(c.execHandler),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := 0
+	if err := runner.Run(context.Background(), file); err != nil {
+		var exit interp.ExitStatus
+		if !errors.As(err, &exit) {
+			t.Fatalf("%v: %v", args, err)
+		}
+		status = int(exit)
+	}
+	return stdout.String(), stderr.String(), status
+}
+
 func TestRunScriptUtilityBuiltins(t *testing.T) {
 	tmp := t.TempDir()
 	script := writeTempScript(t, tmp, "utilities.gsh", `#!/bin/sh
@@ -283,3 +323,354 @@ func TestParseLogLevelSupportsNumericAndNamedLevels(t *testing.T) {
 		})
 	}
 }
+
+type curlIdentityBot struct {
+	BotAPI
+	provider, user string
+	headerName     string
+}
+
+func (b *curlIdentityBot) GetIdentityCredential(provider, user string) (*robot.IdentityCredential, robot.RetVal) {
+	b.provider, b.user = provider, user
+	if b.headerName != "" {
+		return &robot.IdentityCredential{Type: "api_key", Value: "tok", HeaderName: b.headerName, HeaderValue: "tok"}, robot.Ok
+	}
+	return &robot.IdentityCredential{Type: "oauth2", Value: "tok", Scheme: "Bearer", HeaderName: "Authorization", HeaderValue: "Bearer tok"}, robot.Ok
+}
+
+func TestRunScriptCurlBuiltin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"method":%q,"type":%q,"auth":%q,"query":%q,"body":%q}`,
+				r.Method, r.Header.Get("Content-Type"), r.Header.Get("Authorization"), r.URL.RawQuery, body)
+		case "/upload":
+			f, h, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(f)
+			fmt.Fprintf(w, "%s:%s:%s", r.FormValue("note"), h.Filename, data)
+		case "/moved":
+			http.Redirect(w, r, "/echo", http.StatusFound)
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tmp := t.TempDir()
+	script := writeTempScript(t, tmp, "curl.gsh", `#!/bin/sh
+base="$SERVER_URL"
+post=$(curl -sS --json '{"n":1}' "$base/echo" | jq -r '.method + " " + .type + " " + .body') || exit 10
+form=$(curl -s -d a=1 --data-urlencode 'b=x y' "$base/echo" | jq -r '.type + " " + .body')
+get=$(curl -s -G -d q=go "$base/echo" | jq -r '.method + " " + .query')
+printf 'payload' > up.txt
+upload=$(curl -s -F note=hi -F file=@up.txt "$base/upload")
+curl -s -o out.json -X DELETE -u bob:secret "$base/echo" || exit 11
+deleted=$(jq -r '.method + " " + .auth' out.json)
+code=$(curl -s -o /dev/null -w '%{http_code}' "$base/missing")
+curl -sf "$base/missing" > failed.txt
+failed=$?
+redirect=$(curl -s -w '%{http_code}' -o /dev/null "$base/moved")
+followed=$(curl -sL "$base/moved" | jq -r .method)
+curl -s -m 0.2 "$base/slow"
+timeout=$?
+curl -s --bogus "$base/echo"
+usage=$?
+printf 'post=%s|form=%s|get=%s|upload=%s|delete=%s|code=%s|fail=%s:%s|redirect=%s|follow=%s|timeout=%s|usage=%s\n' \
+  "$post" "$form" "$get" "$upload" "$deleted" "$code" "$failed" "$(cat failed.txt)" "$redirect" "$followed" "$timeout" "$usage"
+`)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	ret, err := runScript(
+		script,
+		"curl-test",
+		tmp,
+		[]string{
+			"SERVER_URL=" + server.URL,
+			"GOPHER_INSTALLDIR=" + tmp,
+		},
+		nil,
+		nil,
+		nil,
+		&stdout,
+		&stderr,
+	)
+	if err != nil {
+		t.Fatalf("runScript() error = %v; stderr=%q", err, stderr.String())
+	}
+	if ret != robot.Normal {
+		t.Fatalf("runScript() ret = %v, want %v; stderr=%q", ret, robot.Normal, stderr.String())
+	}
+	got := strings.TrimSpace(stdout.String())
+	basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:secret"))
+	want := "post=POST application/json {\"n\":1}" +
+		"|form=application/x-www-form-urlencoded a=1&b=x+y" +
+		"|get=GET q=go" +
+		"|upload=hi:up.txt:payload" +
+		"|delete=DELETE " + basicAuth +
+		"|code=404|fail=22:|redirect=302|follow=GET|timeout=28|usage=2"
+	if got != want {
+		t.Fatalf("curl output = %q, want %q; stderr=%q", got, want, stderr.String())
+	}
+}
+
+func TestCurlIdentityInjectsCredentialHeader(t *testing.T) {
+	bot := &curlIdentityBot{}
+	c := &shellContext{bot: bot, envMap: map[string]string{"GOPHER_USER": "alice"}}
+	req := httptest.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+	if name, err := c.injectIdentity(req, "github"); err != nil || name != "Authorization" {
+		t.Fatalf("injectIdentity() = %q, %v", name, err)
+	}
+	if bot.provider != "github" || bot.user != "alice" {
+		t.Fatalf("GetIdentityCredential(%q, %q), want github for the current user", bot.provider, bot.user)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer tok" {
+		t.Fatalf("Authorization = %q, want the credential header", got)
+	}
+	if _, err := c.injectIdentity(req, "github:bob"); err != nil || bot.user != "bob" {
+		t.Fatalf("injectIdentity(github:bob) = %v for user %q, want bob", err, bot.user)
+	}
+	c.bot = nil
+	if _, err := c.injectIdentity(req, "github"); err == nil {
+		t.Fatal("injectIdentity() without a robot succeeded")
+	}
+}
+
+func TestCurlIdentityHeaderDroppedOnCrossHostRedirect(t *testing.T) {
+	seen := make(chan string, 4)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- "other:" + r.Header.Get("X-Api-Key")
+	}))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.URL.Path + ":" + r.Header.Get("X-Api-Key")
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/same", http.StatusFound)
+		case "/same":
+			http.Redirect(w, r, other.URL+"/away", http.StatusFound)
+		}
+	}))
+	defer origin.Close()
+
+	c := &shellContext{bot: &curlIdentityBot{headerName: "X-API-Key"}, envMap: map[string]string{"GOPHER_USER": "alice"}}
+	req, err := http.NewRequest(http.MethodGet, origin.URL+"/start", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := c.injectIdentity(req, "vendor")
+	if err != nil {
+		t.Fatalf("injectIdentity() error = %v", err)
+	}
+	resp, err := newCurlClient(&curlOptions{location: true, maxRedirs: -1, identityName: name}).Do(req)
+	if err != nil {
+		t.Fatalf("client.Do() error = %v", err)
+	}
+	resp.Body.Close()
+	close(seen)
+	var got []string
+	for s := range seen {
+		got = append(got, s)
+	}
+	want := []string{"/start:tok", "/same:tok", "other:"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("identity header per hop = %q, want %q", got, want)
+	}
+}
+
+func TestCurlIdentityHeaderDroppedOnSchemeDowngrade(t *testing.T) {
+	client := newCurlClient(&curlOptions{location: true, maxRedirs: -1, identityName: "X-API-Key"})
+	first := httptest.NewRequest(http.MethodGet, "https://api.example.com:8443/start", nil)
+	for _, tt := range []struct {
+		url  string
+		want string
+	}{
+		{url: "https://api.example.com:8443/next", want: "tok"},
+		{url: "http://api.example.com:8443/next", want: ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		req.Header.Set("X-API-Key", "tok")
+		if err := client.CheckRedirect(req, []*http.Request{first}); err != nil {
+			t.Fatalf("CheckRedirect(%s) error = %v", tt.url, err)
+		}
+		if got := req.Header.Get("X-API-Key"); got != tt.want {
+			t.Fatalf("redirect to %s sent X-API-Key %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRunScriptTextProcessingBuiltins(t *testing.T) {
+	tmp := t.TempDir()
+	script := writeTempScript(t, tmp, "text.gsh", `#!/bin/sh
+printf 'alice:admin:3\nbob:user:5\ncarol:admin:7\n' > users.txt
+names=$(cut -d: -f1 users.txt | tr '\n' ',')
+chars=$(printf 'abcdef\n' | cut -c2-4)
+admins=$(sed -n '/:admin:/s/:.*//p' users.txt | tr '\n' ',')
+swapped=$(printf 'one two\n' | sed -E 's/([a-z]+) ([a-z]+)/\2 \1/')
+upper=$(printf 'make loud\n' | sed 's/loud/\U&/')
+joined=$(sed -n 'H;${x;s/\n/+/g;s/^+//;p}' users.txt)
+cp users.txt edit.txt
+sed -i.bak -e '2d' -e 's/carol/CAROL/' edit.txt || exit 10
+edited=$(cat edit.txt | tr '\n' ',')
+backup=$(cat edit.txt.bak | wc -l)
+set -- $backup
+total=$(awk -F: '{ sum += $3 } END { print sum, NR }' users.txt)
+roles=$(awk -F: '{ n[$2]++ } END { for (r in n) printf "%s=%d ", r, n[r] }' users.txt)
+formatted=$(awk -F: -v pre=">" 'function pad(s) { return sprintf("%-6s|", s) } $3 > 4 { print pre pad($1) toupper($2) }' users.txt | tr '\n' ',')
+lookup=$(printf 'bob\nzed\n' | awk -F: 'NR == FNR { role[$1] = $2; next } { print $1 ":" (($1 in role) ? role[$1] : "none") }' users.txt - | tr '\n' ',')
+awk 'BEGIN { exit 3 }'
+status=$?
+printf 'names=%s chars=%s admins=%s swapped=%s upper=%s joined=%s edited=%s backup=%s\n' "$names" "$chars" "$admins" "$swapped" "$upper" "$joined" "$edited" "$1"
+printf 'total=%s roles=%s formatted=%s lookup=%s status=%s\n' "$total" "$roles" "$formatted" "$lookup" "$status"
+`)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	ret, err := runScript(
+		script,
+		"text-test",
+		tmp,
+		[]string{
+			"GOPHER_WORKSPACE=" + tmp,
+			"GOPHER_INSTALLDIR=" + tmp,
+		},
+		nil,
+		nil,
+		nil,
+		&stdout,
+		&stderr,
+	)
+	if err != nil {
+		t.Fatalf("runScript() error = %v; stderr=%q", err, stderr.String())
+	}
+	if ret != robot.Normal {
+		t.Fatalf("runScript() ret = %v, want %v; stderr=%q", ret, robot.Normal, stderr.String())
+	}
+	got := strings.TrimSpace(stdout.String())
+	want := "names=alice,bob,carol, chars=bcd admins=alice,carol, swapped=two one upper=make LOUD" +
+		" joined=alice:admin:3+bob:user:5+carol:admin:7 edited=alice:admin:3,CAROL:admin:7, backup=3\n" +
+		"total=15 3 roles=admin=2 user=1  formatted=>bob   |USER,>carol |ADMIN, lookup=bob:user,zed:none, status=3"
+	if got != want {
+		t.Fatalf("text processing output = %q, want %q; stderr=%q", got, want, stderr.String())
+	}
+}
+
+func TestCutBuiltinRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		want   string
+		status int
+		stderr string
+	}{
+		{name: "bytes open start", args: []string{"-b-3"}, stdin: "abcdef\n", want: "abc\n"},
+		{name: "bytes open end", args: []string{"-b", "4-"}, stdin: "abcdef\n", want: "def\n"},
+		{name: "bytes list and open end", args: []string{"-b1,5-"}, stdin: "abcdef\n", want: "aef\n"},
+		{name: "bytes past end of line", args: []string{"-b5-9"}, stdin: "abcdef\nab\n", want: "ef\n\n"},
+		{name: "bytes split runes", args: []string{"-b1-2"}, stdin: "h\u00e9llo\n", want: "h\xc3\n"},
+		{name: "characters keep runes", args: []string{"-c1-2"}, stdin: "h\u00e9llo\n", want: "h\u00e9\n"},
+		{name: "characters open end", args: []string{"-c", "3-"}, stdin: "h\u00e9llo\n", want: "llo\n"},
+		{name: "characters overlapping", args: []string{"-c-2,2-3"}, stdin: "abcdef\n", want: "abc\n"},
+		{name: "fields open end", args: []string{"-d:", "-f2-"}, stdin: "a:b:c:d\n", want: "b:c:d\n"},
+		{name: "fields open start", args: []string{"-d", ":", "-f", "-2"}, stdin: "a:b:c:d\n", want: "a:b\n"},
+		{name: "fields list and open end", args: []string{"-d:", "-f1,3-"}, stdin: "a:b:c:d\n", want: "a:c:d\n"},
+		{name: "fields default tab", args: []string{"-f2-"}, stdin: "a\tb\tc\n", want: "b\tc\n"},
+		{name: "fields past end", args: []string{"-d:", "-f5-"}, stdin: "a:b\n", want: "\n"},
+		{name: "fields empty", args: []string{"-d:", "-f2"}, stdin: "a::c\n", want: "\n"},
+		{name: "line without delimiter", args: []string{"-d:", "-f2-"}, stdin: "plain\na:b\n", want: "plain\nb\n"},
+		{name: "only delimited", args: []string{"-s", "-d:", "-f2-"}, stdin: "plain\na:b\n", want: "b\n"},
+		{name: "output delimiter", args: []string{"-d:", "-f-2", "--output-delimiter=,"}, stdin: "a:b:c\n", want: "a,b\n"},
+		{name: "bare dash", args: []string{"-f-"}, status: 2, stderr: "invalid list value"},
+		{name: "zero", args: []string{"-f0-"}, status: 2, stderr: "invalid list value"},
+		{name: "decreasing", args: []string{"-c3-1"}, status: 2, stderr: "invalid list value"},
+		{name: "two lists", args: []string{"-b1", "-f2"}, status: 2, stderr: "only one of -b, -c or -f"},
+		{name: "no list", args: []string{"-d:"}, status: 2, stderr: "must specify a list"},
+		{name: "long delimiter", args: []string{"-d", "ab", "-f1"}, status: 2, stderr: "single character"},
+		{name: "complement", args: []string{"--complement", "-f1"}, status: 2, stderr: "not supported"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, status := runBuiltin(t, dir, tt.stdin, append([]string{"cut"}, tt.args...)...)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d; stderr=%q", status, tt.status, stderr)
+			}
+			if tt.stderr != "" && !strings.Contains(stderr, tt.stderr) {
+				t.Fatalf("stderr = %q, want %q", stderr, tt.stderr)
+			}
+			if tt.status == 0 && stdout != tt.want {
+				t.Fatalf("stdout = %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunScriptDateBuiltin(t *testing.T) {
+	tmp := t.TempDir()
+	script := writeTempScript(t, tmp, "date.gsh", `#!/bin/sh
+export TZ=UTC
+base='2024-03-15 13:45:30'
+fmt=$(date -d "$base" '+%Y-%m-%d %H:%M:%S %a %b %j %V %u %q %s %-d/%_m %^a')
+iso=$(date -d "$base" -Iseconds)
+rfc=$(date -d 'Mon, 02 Jan 2006 15:04:05 -0700' -R)
+epoch=$(date -d '@1700000000.25' '+%F %T.%3N')
+yesterday=$(date -d "2024-03-01 yesterday" +%F)
+ago=$(date -d "2024-03-15 3 days ago" +%F)
+month=$(date -d "2024-01-31 +1 month" +%F)
+weekday=$(date -d "2024-03-13 next friday" '+%F %A')
+later=$(date -d "$base +90 minutes" +%R)
+zoned=$(TZ=America/New_York date -d '2024-07-04T12:00:00Z' '+%T %Z %:z')
+tokyo=$(date -u -d 'TZ="Asia/Tokyo" 2024-01-01 09:00' '+%F %T %Z')
+date -d '2024-02-30' 2>/dev/null
+invalid=$?
+printf 'fmt=%s|iso=%s|rfc=%s|epoch=%s|yesterday=%s|ago=%s|month=%s|weekday=%s|later=%s|zoned=%s|tokyo=%s|invalid=%s\n' \
+  "$fmt" "$iso" "$rfc" "$epoch" "$yesterday" "$ago" "$month" "$weekday" "$later" "$zoned" "$tokyo" "$invalid"
+`)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	ret, err := runScript(
+		script,
+		"date-test",
+		tmp,
+		[]string{
+			"GOPHER_WORKSPACE=" + tmp,
+			"GOPHER_INSTALLDIR=" + tmp,
+		},
+		nil,
+		nil,
+		nil,
+		&stdout,
+		&stderr,
+	)
+	if err != nil {
+		t.Fatalf("runScript() error = %v; stderr=%q", err, stderr.String())
+	}
+	if ret != robot.Normal {
+		t.Fatalf("runScript() ret = %v, want %v; stderr=%q", ret, robot.Normal, stderr.String())
+	}
+	got := strings.TrimSpace(stdout.String())
+	want := "fmt=2024-03-15 13:45:30 Fri Mar 075 11 5 1 1710510330 15/ 3 FRI" +
+		"|iso=2024-03-15T13:45:30+00:00" +
+		"|rfc=Mon, 02 Jan 2006 22:04:05 +0000" +
+		"|epoch=2023-11-14 22:13:20.250" +
+		"|yesterday=2024-02-29|ago=2024-03-12|month=2024-03-02" +
+		"|weekday=2024-03-15 Friday|later=15:15" +
+		"|zoned=08:00:00 EDT -04:00" +
+		"|tokyo=2024-01-01 00:00:00 UTC|invalid=1"
+	if got != want {
+		t.Fatalf("date output = %q, want %q; stderr=%q", got, want, stderr.String())
+	}
+}
@@ -0,0 +1,828 @@
+package gsh
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Exit codes shared with curl, so scripts that test $? keep working when the
+// host curl is replaced by the builtin.
+const (
+	curlExitUnsupportedProtocol = 1
+	curlExitUsage               = 2
+	curlExitBadURL              = 3
+	curlExitResolve             = 6
+	curlExitConnect             = 7
+	curlExitHTTPError           = 22
+	curlExitWrite               = 23
+	curlExitRead                = 26
+	curlExitTimeout             = 28
+	curlExitSSLConnect          = 35
+	curlExitTooManyRedirects    = 47
+	curlExitTransport           = 56
+	curlExitPeerCert            = 60
+)
+
+const curlUserAgent = "gopherbot-gsh/curl"
+
+// The GSH curl builtin covers the subset of curl options shipped automation
+// uses for REST calls. Anything else is a usage error rather than being
+// silently ignored. --identity is GSH-only: it injects the header from
+// GetIdentityCredential so tokens never pass through shell variables.
+type curlOptions struct {
+	method        string
+	url           string
+	headers       []string
+	data          []string
+	dataSet       bool
+	json          bool
+	form          []string
+	uploadFile    string
+	get           bool
+	head          bool
+	output        string
+	dumpHeader    string
+	silent        bool
+	showError     bool
+	fail          bool
+	failWithBody  bool
+	include       bool
+	location      bool
+	maxRedirs     int
+	writeOut      string
+	maxTime       time.Duration
+	connectTime   time.Duration
+	userPass      string
+	userAgent     string
+	insecure      bool
+	identity      string
+	identitySet   bool
+	identityName  string // header injectIdentity set, dropped on cross-host redirects
+	help          bool
+	stdinConsumed bool
+}
+
+// curlError carries the exit code and curl-style message for a failure.
+type curlError struct {
+	code int
+	msg  string
+}
+
+func (e *curlError) Error() string {
+	return e.msg
+}
+
+func curlErrorf(code int, format string, args ...interface{}) *curlError {
+	return &curlError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+func (c *shellContext) runCurl(ctx context.Context, args []string) error {
+	hc := interp.HandlerCtx(ctx)
+	opts, err := parseCurlArgs(args)
+	if err != nil {
+		fmt.Fprintf(hc.Stderr, "curl: %v\n", err)
+		return interp.ExitStatus(curlExitUsage)
+	}
+	if opts.help {
+		fmt.Fprint(hc.Stdout, curlHelpText)
+		return nil
+	}
+	if err := c.doCurl(ctx, hc, opts); err != nil {
+		var cerr *curlError
+		if !errors.As(err, &cerr) {
+			return err
+		}
+		if !opts.silent || opts.showError {
+			fmt.Fprintf(hc.Stderr, "curl: (%d) %s\n", cerr.code, cerr.msg)
+		}
+		return interp.ExitStatus(uint8(cerr.code))
+	}
+	return nil
+}
+
+func parseCurlArgs(args []string) (*curlOptions, error) {
+	opts := &curlOptions{maxRedirs: 50}
+	var urls []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			urls = append(urls, args[i+1:]...)
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			urls = append(urls, arg)
+			continue
+		}
+		// Each entry is a flag name plus, for flags taking a value, the value
+		// glued to a short flag ("-XPOST") or the next argument.
+		type flag struct{ name, value string }
+		var flags []flag
+		if strings.HasPrefix(arg, "--") {
+			f := flag{name: arg}
+			if curlFlagTakesValue(arg) {
+				i++
+				if i >= len(args) {
+					return nil, fmt.Errorf("option %s: requires parameter", arg)
+				}
+				f.value = args[i]
+			}
+			flags = append(flags, f)
+		} else {
+			group := arg[1:]
+			for j := 0; j < len(group); j++ {
+				name := "-" + group[j:j+1]
+				if !curlFlagTakesValue(name) {
+					flags = append(flags, flag{name: name})
+					continue
+				}
+				value := group[j+1:]
+				if value == "" {
+					i++
+					if i >= len(args) {
+						return nil, fmt.Errorf("option %s: requires parameter", name)
+					}
+					value = args[i]
+				}
+				flags = append(flags, flag{name: name, value: value})
+				break
+			}
+		}
+		for _, f := range flags {
+			if err := opts.set(f.name, f.value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.help {
+		return opts, nil
+	}
+	if opts.url != "" {
+		urls = append([]string{opts.url}, urls...)
+	}
+	if len(urls) != 1 {
+		return nil, errors.New("exactly one URL is required")
+	}
+	opts.url = urls[0]
+	if len(opts.form) > 0 && opts.dataSet {
+		return nil, errors.New("-F/--form can't be combined with -d/--data or --json")
+	}
+	if opts.uploadFile != "" && (opts.dataSet || len(opts.form) > 0) {
+		return nil, errors.New("-T/--upload-file can't be combined with a request body")
+	}
+	return opts, nil
+}
+
+func curlFlagTakesValue(name string) bool {
+	switch name {
+	case "-X", "--request", "-H", "--header", "-d", "--data", "--data-ascii",
+		"--data-raw", "--data-binary", "--data-urlencode", "--json", "-F", "--form",
+		"-T", "--upload-file", "-o", "--output", "-D", "--dump-header", "-w",
+		"--write-out", "-m", "--max-time", "--connect-timeout", "-u", "--user",
+		"-A", "--user-agent", "--url", "--max-redirs", "--identity":
+		return true
+	}
+	return false
+}
+
+func (o *curlOptions) set(name, value string) error {
+	switch name {
+	case "-X", "--request":
+		o.method = value
+	case "-H", "--header":
+		o.headers = append(o.headers, value)
+	case "-d", "--data", "--data-ascii":
+		o.data = append(o.data, "d:"+value)
+		o.dataSet = true
+	case "--data-raw":
+		o.data = append(o.data, "r:"+value)
+		o.dataSet = true
+	case "--data-binary":
+		o.data = append(o.data, "b:"+value)
+		o.dataSet = true
+	case "--data-urlencode":
+		o.data = append(o.data, "u:"+value)
+		o.dataSet = true
+	case "--json":
+		o.data = append(o.data, "j:"+value)
+		o.dataSet = true
+		o.json = true
+	case "-F", "--form":
+		o.form = append(o.form, value)
+	case "-T", "--upload-file":
+		o.uploadFile = value
+	case "-G", "--get":
+		o.get = true
+	case "-I", "--head":
+		o.head = true
+	case "-o", "--output":
+		o.output = value
+	case "-D", "--dump-header":
+		o.dumpHeader = value
+	case "-s", "--silent":
+		o.silent = true
+	case "-S", "--show-error":
+		o.showError = true
+	case "-f", "--fail":
+		o.fail = true
+	case "--fail-with-body":
+		o.failWithBody = true
+	case "-i", "--include":
+		o.include = true
+	case "-L", "--location":
+		o.location = true
+	case "--max-redirs":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("option --max-redirs: expected a number, got %q", value)
+		}
+		o.maxRedirs = n
+	case "-w", "--write-out":
+		o.writeOut = value
+	case "-m", "--max-time", "--connect-timeout":
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("option %s: expected a number of seconds, got %q", name, value)
+		}
+		d := time.Duration(seconds * float64(time.Second))
+		if name == "--connect-timeout" {
+			o.connectTime = d
+		} else {
+			o.maxTime = d
+		}
+	case "-u", "--user":
+		o.userPass = value
+	case "-A", "--user-agent":
+		o.userAgent = value
+	case "-k", "--insecure":
+		o.insecure = true
+	case "--url":
+		if o.url != "" {
+			return errors.New("exactly one URL is required")
+		}
+		o.url = value
+	case "--identity":
+		o.identity = value
+		o.identitySet = true
+	case "--compressed":
+		// Go's transport already negotiates and decodes gzip.
+	case "-h", "--help":
+		o.help = true
+	default:
+		return fmt.Errorf("option %s: is unknown to the gsh builtin", name)
+	}
+	return nil
+}
+
+func (c *shellContext) doCurl(ctx context.Context, hc interp.HandlerContext, opts *curlOptions) error {
+	target, err := curlTargetURL(opts.url)
+	if err != nil {
+		return err
+	}
+	body, contentType, err := opts.requestBody(hc)
+	if err != nil {
+		return err
+	}
+	if opts.get && body != nil {
+		query, _ := io.ReadAll(body)
+		if len(query) > 0 {
+			if target.RawQuery != "" {
+				target.RawQuery += "&"
+			}
+			target.RawQuery += string(query)
+		}
+		body = nil
+		contentType = ""
+	}
+
+	method := "GET"
+	switch {
+	case opts.head:
+		method = "HEAD"
+	case opts.uploadFile != "":
+		method = "PUT"
+	case body != nil:
+		method = "POST"
+	}
+	if opts.method != "" {
+		method = opts.method
+	}
+
+	if opts.maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.maxTime)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return curlErrorf(curlExitBadURL, "URL rejected: %v", err)
+	}
+	req.Header.Set("User-Agent", curlUserAgent)
+	req.Header.Set("Accept", "*/*")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if opts.json {
+		req.Header.Set("Accept", "application/json")
+	}
+	if opts.userAgent != "" {
+		req.Header.Set("User-Agent", opts.userAgent)
+	}
+	if opts.userPass != "" {
+		user, pass, _ := strings.Cut(opts.userPass, ":")
+		req.SetBasicAuth(user, pass)
+	}
+	if opts.identitySet {
+		name, err := c.injectIdentity(req, opts.identity)
+		if err != nil {
+			return err
+		}
+		opts.identityName = name
+	}
+	for _, h := range opts.headers {
+		if err := setCurlHeader(req, h); err != nil {
+			return err
+		}
+	}
+
+	client := newCurlClient(opts)
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return curlTransportError(ctx, err, opts)
+	}
+	defer resp.Body.Close()
+
+	if opts.fail && resp.StatusCode >= 400 {
+		return curlErrorf(curlExitHTTPError, "The requested URL returned error: %d", resp.StatusCode)
+	}
+
+	out := hc.Stdout
+	if opts.output != "" && opts.output != "-" {
+		f, err := os.Create(resolvePath(hc.Dir, opts.output))
+		if err != nil {
+			return curlErrorf(curlExitWrite, "Failed writing body: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	headerBlock := curlHeaderBlock(resp)
+	if opts.dumpHeader != "" {
+		if err := writeCurlDump(hc, opts.dumpHeader, headerBlock); err != nil {
+			return err
+		}
+	}
+	if opts.include || opts.head {
+		if _, err := io.WriteString(out, headerBlock); err != nil {
+			return curlErrorf(curlExitWrite, "Failed writing header: %v", err)
+		}
+	}
+	counter := &countingWriter{w: out}
+	if _, err := io.Copy(counter, resp.Body); err != nil {
+		var werr *curlWriteError
+		if errors.As(err, &werr) {
+			return curlErrorf(curlExitWrite, "Failed writing body: %v", werr.err)
+		}
+		return curlTransportError(ctx, err, opts)
+	}
+
+	if opts.writeOut != "" {
+		fmt.Fprint(hc.Stdout, expandCurlWriteOut(opts.writeOut, resp, method, counter.n, time.Since(start)))
+	}
+	if opts.failWithBody && resp.StatusCode >= 400 {
+		return curlErrorf(curlExitHTTPError, "The requested URL returned error: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func curlTargetURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, curlErrorf(curlExitBadURL, "URL rejected: %v", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	default:
+		return nil, curlErrorf(curlExitUnsupportedProtocol, "Protocol %q not supported", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, curlErrorf(curlExitBadURL, "URL rejected: No host part in the URL")
+	}
+	return u, nil
+}
+
+// requestBody assembles -d/--data*/--json, -F or -T into a body and its
+// default content type.
+func (o *curlOptions) requestBody(hc interp.HandlerContext) (io.Reader, string, error) {
+	switch {
+	case o.uploadFile != "":
+		data, err := o.readSource(hc, o.uploadFile)
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(data), "", nil
+	case len(o.form) > 0:
+		return o.multipartBody(hc)
+	case !o.dataSet:
+		return nil, "", nil
+	}
+	var buf bytes.Buffer
+	for i, item := range o.data {
+		kind, value := item[:1], item[2:]
+		if i > 0 && kind != "j" {
+			buf.WriteByte('&')
+		}
+		switch kind {
+		case "r":
+			buf.WriteString(value)
+		case "d", "b", "j":
+			if !strings.HasPrefix(value, "@") {
+				buf.WriteString(value)
+				continue
+			}
+			data, err := o.readSource(hc, value[1:])
+			if err != nil {
+				return nil, "", err
+			}
+			if kind == "d" {
+				data = bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r"), nil), []byte("\n"), nil)
+			}
+			buf.Write(data)
+		case "u":
+			encoded, err := o.urlencodeData(hc, value)
+			if err != nil {
+				return nil, "", err
+			}
+			buf.WriteString(encoded)
+		}
+	}
+	contentType := "application/x-www-form-urlencoded"
+	if o.json {
+		contentType = "application/json"
+	}
+	return &buf, contentType, nil
+}
+
+// urlencodeData follows curl's --data-urlencode forms: content, =content,
+// name=content, @file and name@file.
+func (o *curlOptions) urlencodeData(hc interp.HandlerContext, value string) (string, error) {
+	if eq := strings.IndexByte(value, '='); eq >= 0 && !strings.Contains(value[:eq], "@") {
+		name, content := value[:eq], value[eq+1:]
+		if name == "" {
+			return url.QueryEscape(content), nil
+		}
+		return name + "=" + url.QueryEscape(content), nil
+	}
+	if at := strings.IndexByte(value, '@'); at >= 0 {
+		data, err := o.readSource(hc, value[at+1:])
+		if err != nil {
+			return "", err
+		}
+		if at == 0 {
+			return url.QueryEscape(string(data)), nil
+		}
+		return value[:at] + "=" + url.QueryEscape(string(data)), nil
+	}
+	return url.QueryEscape(value), nil
+}
+
+func (o *curlOptions) multipartBody(hc interp.HandlerContext) (io.Reader, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, field := range o.form {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || name == "" {
+			return nil, "", curlErrorf(curlExitUsage, "Illegally formatted input field: %q", field)
+		}
+		switch {
+		case strings.HasPrefix(value, "@"):
+			path, fieldType, _ := strings.Cut(value[1:], ";type=")
+			data, err := o.readSource(hc, path)
+			if err != nil {
+				return nil, "", err
+			}
+			if fieldType == "" {
+				fieldType = "application/octet-stream"
+			}
+			h := make(textproto.MIMEHeader)
+			h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, name, filepath.Base(path)))
+			h.Set("Content-Type", fieldType)
+			part, err := mw.CreatePart(h)
+			if err != nil {
+				return nil, "", err
+			}
+			_, _ = part.Write(data)
+		case strings.HasPrefix(value, "<"):
+			data, err := o.readSource(hc, value[1:])
+			if err != nil {
+				return nil, "", err
+			}
+			_ = mw.WriteField(name, string(data))
+		default:
+			_ = mw.WriteField(name, value)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, mw.FormDataContentType(), nil
+}
+
+// readSource reads a file relative to the shell's working directory, or the
+// shell's stdin for "-".
+func (o *curlOptions) readSource(hc interp.HandlerContext, path string) ([]byte, error) {
+	if path == "-" {
+		if o.stdinConsumed || hc.Stdin == nil {
+			return nil, nil
+		}
+		o.stdinConsumed = true
+		data, err := io.ReadAll(hc.Stdin)
+		if err != nil {
+			return nil, curlErrorf(curlExitRead, "Failed reading stdin: %v", err)
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(resolvePath(hc.Dir, path))
+	if err != nil {
+		return nil, curlErrorf(curlExitRead, "Failed to open/read local data from file/application: %v", err)
+	}
+	return data, nil
+}
+
+// injectIdentity adds the credential header for "provider[:user]" and
+// returns its name; the user defaults to the one running the pipeline.
+func (c *shellContext) injectIdentity(req *http.Request, spec string) (string, error) {
+	if c.bot == nil {
+		return "", curlErrorf(curlExitUsage, "--identity is unavailable during _configure")
+	}
+	provider, user, _ := strings.Cut(spec, ":")
+	if user == "" {
+		user = c.envMap["GOPHER_USER"]
+	}
+	if provider == "" || user == "" {
+		return "", curlErrorf(curlExitUsage, "--identity requires provider[:user], and there's no current user")
+	}
+	credential, ret := c.bot.GetIdentityCredential(provider, user)
+	if credential == nil {
+		return "", curlErrorf(curlExitUsage, "GetIdentityCredential(%s, %s) failed: %s", provider, user, ret)
+	}
+	name, value := credential.HeaderName, credential.HeaderValue
+	if name == "" {
+		name = "Authorization"
+	}
+	if value == "" {
+		value = strings.TrimSpace(credential.Scheme + " " + credential.Value)
+	}
+	req.Header.Set(name, value)
+	return name, nil
+}
+
+// setCurlHeader applies -H: "Name: value" sets, "Name:" removes a default
+// header and "Name;" sends it empty.
+func setCurlHeader(req *http.Request, h string) error {
+	if name, ok := strings.CutSuffix(h, ";"); ok && !strings.Contains(name, ":") {
+		req.Header[textproto.CanonicalMIMEHeaderKey(name)] = []string{""}
+		return nil
+	}
+	name, value, ok := strings.Cut(h, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return curlErrorf(curlExitUsage, "Illegal header %q", h)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		req.Header.Del(name)
+		return nil
+	}
+	if strings.EqualFold(name, "Host") {
+		req.Host = value
+		return nil
+	}
+	req.Header.Set(name, value)
+	return nil
+}
+
+func newCurlClient(opts *curlOptions) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.connectTime > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: opts.connectTime, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = opts.connectTime
+	}
+	if opts.insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !opts.location {
+				return http.ErrUseLastResponse
+			}
+			if opts.maxRedirs >= 0 && len(via) > opts.maxRedirs {
+				return curlErrorf(curlExitTooManyRedirects, "Maximum (%d) redirects followed", opts.maxRedirs)
+			}
+			// net/http only strips Authorization and Cookie when leaving the
+			// original host; a custom identity header (X-API-Key) would go
+			// along, and over a downgrade to http it would go in cleartext.
+			if opts.identityName != "" && (req.URL.Host != via[0].URL.Host || req.URL.Scheme != via[0].URL.Scheme) {
+				req.Header.Del(opts.identityName)
+			}
+			return nil
+		},
+	}
+}
+
+func curlTransportError(ctx context.Context, err error, opts *curlOptions) error {
+	var cerr *curlError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &cerr):
+		return cerr
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return curlErrorf(curlExitTimeout, "Operation timed out after %d milliseconds", opts.maxTime.Milliseconds())
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.As(err, &dnsErr):
+		return curlErrorf(curlExitResolve, "Could not resolve host: %s", dnsErr.Name)
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr):
+		return curlErrorf(curlExitPeerCert, "SSL certificate problem: %v", err)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return curlErrorf(curlExitTimeout, "Connection timed out: %v", err)
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return curlErrorf(curlExitConnect, "Failed to connect: %v", opErr.Err)
+	case strings.Contains(err.Error(), "tls:"):
+		return curlErrorf(curlExitSSLConnect, "SSL connect error: %v", err)
+	}
+	return curlErrorf(curlExitTransport, "Failure when receiving data from the peer: %v", err)
+}
+
+func curlHeaderBlock(resp *http.Response) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range resp.Header[name] {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+		}
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+func writeCurlDump(hc interp.HandlerContext, path, block string) error {
+	if path == "-" {
+		_, _ = io.WriteString(hc.Stdout, block)
+		return nil
+	}
+	if err := os.WriteFile(resolvePath(hc.Dir, path), []byte(block), 0o644); err != nil {
+		return curlErrorf(curlExitWrite, "Failed writing header: %v", err)
+	}
+	return nil
+}
+
+// expandCurlWriteOut handles the common -w variables and backslash escapes.
+func expandCurlWriteOut(format string, resp *http.Response, method string, size int64, elapsed time.Duration) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		ch := format[i]
+		switch {
+		case ch == '\\' && i+1 < len(format):
+			i++
+			switch format[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\':
+				b.WriteByte('\\')
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(format[i])
+			}
+		case ch == '%' && strings.HasPrefix(format[i:], "%{"):
+			closing := "}"
+			if strings.HasPrefix(format[i+2:], "header{") {
+				closing = "}}"
+			}
+			end := strings.Index(format[i:], closing)
+			if end < 0 {
+				b.WriteString(format[i:])
+				return b.String()
+			}
+			end += len(closing) - 1
+			b.WriteString(curlWriteOutVar(format[i+2:i+end], resp, method, size, elapsed))
+			i += end
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+func curlWriteOutVar(name string, resp *http.Response, method string, size int64, elapsed time.Duration) string {
+	if header, ok := strings.CutPrefix(name, "header{"); ok {
+		return resp.Header.Get(strings.TrimSuffix(header, "}"))
+	}
+	switch name {
+	case "http_code", "response_code":
+		return fmt.Sprintf("%03d", resp.StatusCode)
+	case "content_type":
+		return resp.Header.Get("Content-Type")
+	case "url_effective", "url":
+		return resp.Request.URL.String()
+	case "method":
+		return method
+	case "size_download":
+		return strconv.FormatInt(size, 10)
+	case "time_total":
+		return strconv.FormatFloat(elapsed.Seconds(), 'f', 6, 64)
+	case "http_version":
+		return strings.TrimPrefix(resp.Proto, "HTTP/")
+	}
+	return ""
+}
+
+type curlWriteError struct {
+	err error
+}
+
+func (e *curlWriteError) Error() string {
+	return e.err.Error()
+}
+
+// countingWriter tracks size_download and tags write failures so they map
+// to exit 23 rather than a transport error.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if err != nil {
+		return n, &curlWriteError{err: err}
+	}
+	return n, nil
+}
+
+const curlHelpText = `Usage: curl [options...] <url>
+GSH builtin supporting a curl-compatible subset:
+  -X, --request METHOD       HTTP method
+  -H, --header "Name: value" add a header ("Name:" removes, "Name;" sends empty)
+  -d, --data DATA            form data; @file reads a file, @- reads stdin
+      --data-raw DATA        data without @file handling
+      --data-binary DATA     data; @file is sent unmodified
+      --data-urlencode DATA  url-encode content, name=content, @file or name@file
+      --json DATA            JSON body with JSON Content-Type and Accept
+  -F, --form name=value      multipart form; name=@file[;type=mime] uploads a file
+  -T, --upload-file FILE     PUT a file
+  -G, --get                  send -d data in the query string
+  -I, --head                 HEAD request, print the headers
+  -i, --include              print response headers before the body
+  -D, --dump-header FILE     write response headers to FILE
+  -o, --output FILE          write the body to FILE
+  -w, --write-out FORMAT     print %{http_code}, %{content_type}, %{header{Name}}, ...
+  -f, --fail                 exit 22 without output on HTTP errors
+      --fail-with-body       exit 22 on HTTP errors after writing the body
+  -L, --location             follow redirects (--max-redirs N)
+  -m, --max-time SECONDS     limit the whole operation
+      --connect-timeout SECS limit connecting
+  -u, --user USER:PASS       basic authentication
+  -A, --user-agent NAME      User-Agent header
+  -k, --insecure             skip TLS certificate verification
+  -s, --silent / -S, --show-error
+      --identity PROVIDER[:USER]
+                             add the GetIdentityCredential header; USER defaults
+                             to $GOPHER_USER
+`
@@ -0,0 +1,816 @@
+package gsh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// The GSH date builtin follows GNU date: -d parses the date strings GNU
+// accepts in practice (ISO 8601, RFC 2822, "@epoch", month names, weekdays
+// and relative items like "yesterday" or "3 days ago"), and +FORMAT takes the
+// full strftime conversion set with GNU's padding flags. Setting the system
+// clock is not supported.
+type dateOptions struct {
+	date      *string
+	file      string
+	reference string
+	utc       bool
+	format    string
+	help      bool
+}
+
+const dateDefaultFormat = "%a %b %e %H:%M:%S %Z %Y"
+
+func (c *shellContext) runDate(ctx context.Context, args []string) error {
+	hc := interp.HandlerCtx(ctx)
+	opts, err := parseDateArgs(args)
+	if err != nil {
+		fmt.Fprintf(hc.Stderr, "date: %v\n", err)
+		return interp.ExitStatus(1)
+	}
+	if opts.help {
+		io.WriteString(hc.Stdout, dateHelpText)
+		return nil
+	}
+	loc := time.Local
+	if hc.Env != nil {
+		if tz := hc.Env.Get("TZ"); tz.IsSet() {
+			loc = dateLocation(tz.String())
+		}
+	}
+	if opts.utc {
+		loc = time.UTC
+	}
+	now := time.Now()
+
+	switch {
+	case opts.file != "":
+		var r io.Reader = gshStdinReader(hc)
+		if opts.file != "-" {
+			f, err := os.Open(resolvePath(hc.Dir, opts.file))
+			if err != nil {
+				fmt.Fprintf(hc.Stderr, "date: %s: %v\n", opts.file, errors.Unwrap(err))
+				return interp.ExitStatus(1)
+			}
+			defer f.Close()
+			r = f
+		}
+		status := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			t, err := parseDateString(scanner.Text(), now, loc)
+			if err != nil {
+				fmt.Fprintf(hc.Stderr, "date: %v\n", err)
+				status = 1
+				continue
+			}
+			fmt.Fprintln(hc.Stdout, formatDate(t.In(loc), opts.format))
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(hc.Stderr, "date: %s: %v\n", opts.file, err)
+			return interp.ExitStatus(1)
+		}
+		if status != 0 {
+			return interp.ExitStatus(uint8(status))
+		}
+		return nil
+	case opts.reference != "":
+		info, err := os.Stat(resolvePath(hc.Dir, opts.reference))
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "date: %s: %v\n", opts.reference, errors.Unwrap(err))
+			return interp.ExitStatus(1)
+		}
+		now = info.ModTime()
+	case opts.date != nil:
+		t, err := parseDateString(*opts.date, now, loc)
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "date: %v\n", err)
+			return interp.ExitStatus(1)
+		}
+		now = t
+	}
+	fmt.Fprintln(hc.Stdout, formatDate(now.In(loc), opts.format))
+	return nil
+}
+
+// dateLocation interprets a TZ value. Names the zone database doesn't know
+// are treated as UTC under that name, as the C library does.
+func dateLocation(tz string) *time.Location {
+	tz = strings.TrimPrefix(tz, ":")
+	if tz == "" {
+		return time.UTC
+	}
+	if loc, err := time.LoadLocation(tz); err == nil {
+		return loc
+	}
+	name := strings.TrimRight(tz, "0123456789+-:,.")
+	if name == "" {
+		name = "UTC"
+	}
+	return time.FixedZone(name, 0)
+}
+
+func parseDateArgs(args []string) (*dateOptions, error) {
+	opts := &dateOptions{format: dateDefaultFormat}
+	formatSet := false
+	setFormat := func(format string) error {
+		if formatSet {
+			return fmt.Errorf("multiple output formats specified")
+		}
+		formatSet = true
+		opts.format = format
+		return nil
+	}
+	isoFormat := func(spec string) (string, error) {
+		switch spec {
+		case "", "date", "d":
+			return "%Y-%m-%d", nil
+		case "hours", "h":
+			return "%Y-%m-%dT%H%:z", nil
+		case "minutes", "m":
+			return "%Y-%m-%dT%H:%M%:z", nil
+		case "seconds", "s":
+			return "%Y-%m-%dT%H:%M:%S%:z", nil
+		case "ns", "n":
+			return "%Y-%m-%dT%H:%M:%S,%N%:z", nil
+		}
+		return "", fmt.Errorf("invalid argument %q for --iso-8601", spec)
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func(flag string) (string, error) {
+			if v, ok := strings.CutPrefix(arg, flag+"="); ok && strings.HasPrefix(flag, "--") {
+				return v, nil
+			}
+			if len(arg) > len(flag) && !strings.HasPrefix(flag, "--") {
+				return arg[len(flag):], nil
+			}
+			i++
+			if i >= len(args) {
+				return "", fmt.Errorf("option requires an argument -- '%s'", strings.TrimLeft(flag, "-"))
+			}
+			return args[i], nil
+		}
+		flagIs := func(short, long string) bool {
+			return short != "" && strings.HasPrefix(arg, short) && !strings.HasPrefix(arg, "--") ||
+				arg == long || strings.HasPrefix(arg, long+"=")
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "+"):
+			err = setFormat(arg[1:])
+		case arg == "--help":
+			opts.help = true
+			return opts, nil
+		case arg == "-u" || arg == "--utc" || arg == "--universal":
+			opts.utc = true
+		case flagIs("-d", "--date"):
+			flag := "--date"
+			if !strings.HasPrefix(arg, "--") {
+				flag = "-d"
+			}
+			var date string
+			if date, err = value(flag); err == nil {
+				opts.date = &date
+			}
+		case flagIs("-f", "--file"):
+			flag := "--file"
+			if !strings.HasPrefix(arg, "--") {
+				flag = "-f"
+			}
+			opts.file, err = value(flag)
+		case flagIs("-r", "--reference"):
+			flag := "--reference"
+			if !strings.HasPrefix(arg, "--") {
+				flag = "-r"
+			}
+			opts.reference, err = value(flag)
+		case strings.HasPrefix(arg, "-I") || arg == "--iso-8601" || strings.HasPrefix(arg, "--iso-8601="):
+			spec := strings.TrimPrefix(strings.TrimPrefix(arg, "-I"), "--iso-8601")
+			var format string
+			if format, err = isoFormat(strings.TrimPrefix(spec, "=")); err == nil {
+				err = setFormat(format)
+			}
+		case arg == "-R" || arg == "--rfc-email" || arg == "--rfc-2822" || arg == "--rfc-822":
+			err = setFormat("%a, %d %b %Y %H:%M:%S %z")
+		case strings.HasPrefix(arg, "--rfc-3339="):
+			switch spec := strings.TrimPrefix(arg, "--rfc-3339="); spec {
+			case "date":
+				err = setFormat("%Y-%m-%d")
+			case "seconds":
+				err = setFormat("%Y-%m-%d %H:%M:%S%:z")
+			case "ns":
+				err = setFormat("%Y-%m-%d %H:%M:%S.%N%:z")
+			default:
+				err = fmt.Errorf("invalid argument %q for --rfc-3339", spec)
+			}
+		case arg == "-s" || strings.HasPrefix(arg, "--set"):
+			err = fmt.Errorf("setting the date is not supported in gsh")
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			err = fmt.Errorf("invalid option -- '%s'", strings.TrimLeft(arg, "-"))
+		default:
+			err = fmt.Errorf("extra operand '%s' (setting the date is not supported in gsh)", arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sources := 0
+	for _, set := range []bool{opts.date != nil, opts.file != "", opts.reference != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("the options to specify dates for printing are mutually exclusive")
+	}
+	return opts, nil
+}
+
+// Date string parsing
+
+type dateItems struct {
+	haveDate, haveTime, haveWeekday bool
+	year, month, day                int
+	hour, minute, second, nsec      int
+	zone                            *time.Location
+	weekday                         time.Weekday
+	// weekdayOrdinal is -1 for "last", 1 for "next" and 0 otherwise
+	weekdayOrdinal               int
+	relYears, relMonths, relDays int
+	relDuration                  time.Duration
+	// thisYear fills in dates given without a year
+	thisYear int
+}
+
+var (
+	dateMonths = map[string]time.Month{
+		"jan": time.January, "feb": time.February, "mar": time.March,
+		"apr": time.April, "may": time.May, "jun": time.June, "jul": time.July,
+		"aug": time.August, "sep": time.September, "sept": time.September,
+		"oct": time.October, "nov": time.November, "dec": time.December,
+	}
+	dateWeekdays = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+		"tues": time.Tuesday, "wed": time.Wednesday, "wednes": time.Wednesday,
+		"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+		"fri": time.Friday, "sat": time.Saturday, "satur": time.Saturday,
+	}
+	dateOrdinals = map[string]int{"last": -1, "this": 0, "next": 1}
+	// dateZoneNames are the zone abbreviations accepted in date strings,
+	// with their UTC offsets in hours
+	dateZoneNames = map[string]int{
+		"z": 0, "utc": 0, "gmt": 0, "ut": 0, "wet": 0, "west": 1, "bst": 1,
+		"cet": 1, "cest": 2, "eet": 2, "eest": 3, "jst": 9, "aest": 10,
+		"est": -5, "edt": -4, "cst": -6, "cdt": -5, "mst": -7, "mdt": -6,
+		"pst": -8, "pdt": -7,
+	}
+
+	monthNamePattern = `(jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sept?(?:ember)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)\.?`
+
+	dateEpochRe    = regexp.MustCompile(`^@\s*([-+]?\d+(?:[.,]\d+)?)$`)
+	dateTZPrefixRe = regexp.MustCompile(`(?i)^tz="([^"]*)"\s*`)
+	dateISORe      = regexp.MustCompile(`^([-+]?\d{4,})-(\d{1,2})-(\d{1,2})(?:t|\b)`)
+	dateCompactRe  = regexp.MustCompile(`^(\d{8})(?:t|\b)`)
+	dateSlashRe    = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})(?:/(\d{2,4}))?\b`)
+	dateMonthDayRe = regexp.MustCompile(`^` + monthNamePattern + `[\s-]*(\d{1,2})(?:st|nd|rd|th)?\b(?:,?[\s-]*(\d{4})\b)?`)
+	dateDayMonthRe = regexp.MustCompile(`^(\d{1,2})(?:st|nd|rd|th)?[\s-]*` + monthNamePattern + `(?:[\s-]*(\d{4}|\d{2})\b)?`)
+	dateMonthYrRe  = regexp.MustCompile(`^` + monthNamePattern + `[\s-]+(\d{4})\b`)
+	dateTimeRe     = regexp.MustCompile(`^(\d{1,2}):(\d{2})(?::(\d{2})(?:[.,](\d+))?)?(?:\s*([ap])\.?m\.?\b)?`)
+	dateHourAmPmRe = regexp.MustCompile(`^(\d{1,2})\s*([ap])\.?m\.?\b`)
+	dateZoneRe     = regexp.MustCompile(`^(?:(z|utc|gmt|ut|[ecmp][sd]t|bst|cest?|eest?|west?|jst|aest)\b|([-+])(\d{2}):?(\d{2})\b)`)
+	dateAttachedRe = regexp.MustCompile(`^([-+])(\d{1,2})\b`)
+	dateWeekdayRe  = regexp.MustCompile(`^(sun|mon|tues?|wed(?:nes)?|thu(?:rs?)?|fri|sat(?:ur)?)(?:day)?\b\.?,?`)
+	dateRelRe      = regexp.MustCompile(`^(?:([-+]?\s*\d+)\s*|(last|this|next|an?)\s+)?(year|month|fortnight|week|day|hour|minute|min|second|sec)s?\b(\s+ago\b)?`)
+	dateOrdinalRe  = regexp.MustCompile(`^(last|this|next)\s+`)
+	dateWordRe     = regexp.MustCompile(`^(now|today|yesterday|tomorrow|noon|midnight)\b`)
+)
+
+func parseDateString(input string, now time.Time, loc *time.Location) (time.Time, error) {
+	invalid := fmt.Errorf("invalid date '%s'", input)
+	s := strings.TrimSpace(input)
+	if m := dateTZPrefixRe.FindStringSubmatch(s); m != nil {
+		loc = dateLocation(m[1])
+		s = s[len(m[0]):]
+	}
+	s = strings.ToLower(s)
+	if m := dateEpochRe.FindStringSubmatch(s); m != nil {
+		secs, err := strconv.ParseFloat(strings.ReplaceAll(strings.ReplaceAll(m[1], ",", "."), " ", ""), 64)
+		if err != nil {
+			return time.Time{}, invalid
+		}
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(math.Round(frac*1e9))), nil
+	}
+
+	it := dateItems{thisYear: now.In(loc).Year()}
+	for s = strings.TrimLeft(s, " \t,"); s != ""; s = strings.TrimLeft(s, " \t,") {
+		n, err := it.parseItem(s)
+		if err != nil || n == 0 {
+			return time.Time{}, invalid
+		}
+		s = s[n:]
+	}
+
+	base := now.In(loc)
+	if it.zone != nil {
+		base = now.In(it.zone)
+		loc = it.zone
+	}
+	year, month, day := base.Date()
+	hour, minute, second, nsec := base.Hour(), base.Minute(), base.Second(), base.Nanosecond()
+	if it.haveDate {
+		year, month, day = it.year, time.Month(it.month), it.day
+	}
+	if it.haveDate || it.haveWeekday {
+		hour, minute, second, nsec = 0, 0, 0, 0
+	}
+	if it.haveTime {
+		hour, minute, second, nsec = it.hour, it.minute, it.second, it.nsec
+	}
+	t := time.Date(year, month, day, hour, minute, second, nsec, loc)
+	if it.haveDate && (t.Day() != day || t.Month() != month) {
+		return time.Time{}, invalid
+	}
+	if it.haveWeekday {
+		delta := (int(it.weekday) - int(t.Weekday()) + 7) % 7
+		switch {
+		case it.weekdayOrdinal > 0 && delta == 0:
+			delta = 7
+		case it.weekdayOrdinal < 0:
+			delta -= 7
+		}
+		t = t.AddDate(0, 0, delta)
+	}
+	t = t.AddDate(it.relYears, it.relMonths, it.relDays)
+	return t.Add(it.relDuration), nil
+}
+
+// parseItem consumes one item from the front of s, returning its length.
+func (it *dateItems) parseItem(s string) (int, error) {
+	setDate := func(year, month, day int) error {
+		if it.haveDate || month < 1 || month > 12 || day < 1 || day > 31 {
+			return errors.New("invalid date")
+		}
+		it.haveDate = true
+		it.year, it.month, it.day = year, month, day
+		return nil
+	}
+	setTime := func(hour, minute, second, nsec int, ampm string) error {
+		switch ampm {
+		case "a":
+			if hour < 1 || hour > 12 {
+				return errors.New("invalid time")
+			}
+			hour %= 12
+		case "p":
+			if hour < 1 || hour > 12 {
+				return errors.New("invalid time")
+			}
+			hour = hour%12 + 12
+		}
+		if it.haveTime || hour > 23 || minute > 59 || second > 60 {
+			return errors.New("invalid time")
+		}
+		it.haveTime = true
+		it.hour, it.minute, it.second, it.nsec = hour, minute, second, nsec
+		return nil
+	}
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(strings.TrimPrefix(s, "+"))
+		return n
+	}
+	year := func(s string) int {
+		y := atoi(s)
+		if len(s) == 2 {
+			if y < 69 {
+				return 2000 + y
+			}
+			return 1900 + y
+		}
+		return y
+	}
+
+	if m := dateISORe.FindStringSubmatch(s); m != nil {
+		return len(m[0]), setDate(atoi(m[1]), atoi(m[2]), atoi(m[3]))
+	}
+	if m := dateCompactRe.FindStringSubmatch(s); m != nil {
+		return len(m[0]), setDate(atoi(m[1][:4]), atoi(m[1][4:6]), atoi(m[1][6:]))
+	}
+	if m := dateTimeRe.FindStringSubmatch(s); m != nil {
+		nsec := 0
+		if m[4] != "" {
+			frac := (m[4] + "000000000")[:9]
+			nsec = atoi(frac)
+		}
+		if err := setTime(atoi(m[1]), atoi(m[2]), atoi(m[3]), nsec, m[5]); err != nil {
+			return 0, err
+		}
+		n := len(m[0])
+		// a bare signed hour offset only counts as a zone right after a time
+		if z := dateAttachedRe.FindStringSubmatch(s[n:]); z != nil && !dateZoneRe.MatchString(s[n:]) && !dateRelRe.MatchString(strings.TrimLeft(s[n:], "+-")) {
+			offset := atoi(z[2]) * 3600
+			if z[1] == "-" {
+				offset = -offset
+			}
+			it.zone = time.FixedZone("", offset)
+			n += len(z[0])
+		}
+		return n, nil
+	}
+	if m := dateHourAmPmRe.FindStringSubmatch(s); m != nil {
+		return len(m[0]), setTime(atoi(m[1]), 0, 0, 0, m[2])
+	}
+	if m := dateSlashRe.FindStringSubmatch(s); m != nil {
+		y := it.thisYear
+		if m[3] != "" {
+			y = year(m[3])
+		}
+		return len(m[0]), setDate(y, atoi(m[1]), atoi(m[2]))
+	}
+	if m := dateMonthYrRe.FindStringSubmatch(s); m != nil {
+		return len(m[0]), setDate(atoi(m[2]), int(dateMonths[m[1][:3]]), 1)
+	}
+	if m := dateMonthDayRe.FindStringSubmatch(s); m != nil {
+		y := it.thisYear
+		if m[3] != "" {
+			y = atoi(m[3])
+		}
+		return len(m[0]), setDate(y, int(dateMonths[m[1][:3]]), atoi(m[2]))
+	}
+	if m := dateDayMonthRe.FindStringSubmatch(s); m != nil {
+		y := it.thisYear
+		if m[3] != "" {
+			y = year(m[3])
+		}
+		return len(m[0]), setDate(y, int(dateMonths[m[2][:3]]), atoi(m[1]))
+	}
+	if m := dateZoneRe.FindStringSubmatch(s); m != nil {
+		if it.zone != nil {
+			return 0, errors.New("multiple zones")
+		}
+		if m[1] != "" {
+			it.zone = time.FixedZone(strings.ToUpper(m[1]), dateZoneNames[m[1]]*3600)
+		} else {
+			offset := atoi(m[3])*3600 + atoi(m[4])*60
+			if m[2] == "-" {
+				offset = -offset
+			}
+			it.zone = time.FixedZone("", offset)
+		}
+		return len(m[0]), nil
+	}
+	ordinal := 0
+	prefix := 0
+	if m := dateOrdinalRe.FindStringSubmatch(s); m != nil && dateWeekdayRe.MatchString(s[len(m[0]):]) {
+		ordinal = dateOrdinals[m[1]]
+		prefix = len(m[0])
+	}
+	if m := dateWeekdayRe.FindStringSubmatch(s[prefix:]); m != nil {
+		if it.haveWeekday {
+			return 0, errors.New("multiple weekdays")
+		}
+		it.haveWeekday = true
+		it.weekday = dateWeekdays[m[1]]
+		it.weekdayOrdinal = ordinal
+		return prefix + len(m[0]), nil
+	}
+	if m := dateRelRe.FindStringSubmatch(s); m != nil {
+		count := 1
+		switch {
+		case m[1] != "":
+			count = atoi(strings.ReplaceAll(m[1], " ", ""))
+		case m[2] == "last":
+			count = -1
+		case m[2] == "this":
+			count = 0
+		}
+		if m[4] != "" {
+			count = -count
+		}
+		switch m[3] {
+		case "year":
+			it.relYears += count
+		case "month":
+			it.relMonths += count
+		case "fortnight":
+			it.relDays += 14 * count
+		case "week":
+			it.relDays += 7 * count
+		case "day":
+			it.relDays += count
+		case "hour":
+			it.relDuration += time.Duration(count) * time.Hour
+		case "minute", "min":
+			it.relDuration += time.Duration(count) * time.Minute
+		case "second", "sec":
+			it.relDuration += time.Duration(count) * time.Second
+		}
+		return len(m[0]), nil
+	}
+	if m := dateWordRe.FindStringSubmatch(s); m != nil {
+		switch m[1] {
+		case "yesterday":
+			it.relDays--
+		case "tomorrow":
+			it.relDays++
+		case "noon":
+			return len(m[0]), setTime(12, 0, 0, 0, "")
+		case "midnight":
+			return len(m[0]), setTime(0, 0, 0, 0, "")
+		}
+		return len(m[0]), nil
+	}
+	return 0, errors.New("unrecognized item")
+}
+
+// Formatting
+
+var (
+	dateDayNames   = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+	dateMonthNames = []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+)
+
+// formatDate expands a GNU date format: %[flags][width][E|O]conversion,
+// where the flags are - (no padding), _ (spaces), 0 (zeros), ^ (upper case)
+// and # (opposite case).
+func formatDate(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		start := i
+		i++
+		var pad byte
+		upper, swap := false, false
+		for ; i < len(format); i++ {
+			switch format[i] {
+			case '-', '_', '0':
+				pad = format[i]
+				continue
+			case '^':
+				upper = true
+				continue
+			case '#':
+				swap = true
+				continue
+			}
+			break
+		}
+		width := -1
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			if width < 0 {
+				width = 0
+			}
+			width = width*10 + int(format[i]-'0')
+			i++
+		}
+		for i < len(format) && (format[i] == 'E' || format[i] == 'O') {
+			i++
+		}
+		colons := 0
+		for i < len(format) && format[i] == ':' {
+			colons++
+			i++
+		}
+		if i >= len(format) {
+			b.WriteString(format[start:])
+			break
+		}
+		conv := format[i]
+		if colons > 0 && conv != 'z' {
+			b.WriteString(format[start : i+1])
+			continue
+		}
+
+		num := func(n, digits int, defPad byte) string {
+			return datePadNumber(n, digits, defPad, pad, width)
+		}
+		text := func(s string) string {
+			if upper {
+				s = strings.ToUpper(s)
+			}
+			return datePadText(s, pad, width)
+		}
+		swapText := func(s string, lower bool) string {
+			if swap {
+				if lower {
+					s = strings.ToLower(s)
+				} else {
+					s = strings.ToUpper(s)
+				}
+			}
+			return text(s)
+		}
+
+		_, isoWeek := t.ISOWeek()
+		isoYear, _ := t.ISOWeek()
+		hour12 := t.Hour() % 12
+		if hour12 == 0 {
+			hour12 = 12
+		}
+		switch conv {
+		case 'a':
+			b.WriteString(swapText(dateDayNames[t.Weekday()][:3], false))
+		case 'A':
+			b.WriteString(swapText(dateDayNames[t.Weekday()], false))
+		case 'b', 'h':
+			b.WriteString(swapText(dateMonthNames[t.Month()-1][:3], false))
+		case 'B':
+			b.WriteString(swapText(dateMonthNames[t.Month()-1], false))
+		case 'c':
+			b.WriteString(text(formatDate(t, "%a %b %e %H:%M:%S %Y")))
+		case 'C':
+			b.WriteString(num(t.Year()/100, 2, '0'))
+		case 'd':
+			b.WriteString(num(t.Day(), 2, '0'))
+		case 'D':
+			b.WriteString(text(formatDate(t, "%m/%d/%y")))
+		case 'e':
+			b.WriteString(num(t.Day(), 2, '_'))
+		case 'F':
+			b.WriteString(text(formatDate(t, "%Y-%m-%d")))
+		case 'g':
+			b.WriteString(num(isoYear%100, 2, '0'))
+		case 'G':
+			b.WriteString(num(isoYear, 1, '0'))
+		case 'H':
+			b.WriteString(num(t.Hour(), 2, '0'))
+		case 'I':
+			b.WriteString(num(hour12, 2, '0'))
+		case 'j':
+			b.WriteString(num(t.YearDay(), 3, '0'))
+		case 'k':
+			b.WriteString(num(t.Hour(), 2, '_'))
+		case 'l':
+			b.WriteString(num(hour12, 2, '_'))
+		case 'm':
+			b.WriteString(num(int(t.Month()), 2, '0'))
+		case 'M':
+			b.WriteString(num(t.Minute(), 2, '0'))
+		case 'n':
+			b.WriteByte('\n')
+		case 'N':
+			digits := fmt.Sprintf("%09d", t.Nanosecond())
+			if width > 0 && width < 9 {
+				digits = digits[:width]
+			} else if width > 9 {
+				digits += strings.Repeat("0", width-9)
+			}
+			b.WriteString(digits)
+		case 'p':
+			ampm := "AM"
+			if t.Hour() >= 12 {
+				ampm = "PM"
+			}
+			b.WriteString(swapText(ampm, true))
+		case 'P':
+			ampm := "am"
+			if t.Hour() >= 12 {
+				ampm = "pm"
+			}
+			b.WriteString(text(ampm))
+		case 'q':
+			b.WriteString(num((int(t.Month())+2)/3, 1, '0'))
+		case 'r':
+			b.WriteString(text(formatDate(t, "%I:%M:%S %p")))
+		case 'R':
+			b.WriteString(text(formatDate(t, "%H:%M")))
+		case 's':
+			b.WriteString(datePadText(strconv.FormatInt(t.Unix(), 10), pad, width))
+		case 'S':
+			b.WriteString(num(t.Second(), 2, '0'))
+		case 't':
+			b.WriteByte('\t')
+		case 'T':
+			b.WriteString(text(formatDate(t, "%H:%M:%S")))
+		case 'u':
+			wd := int(t.Weekday())
+			if wd == 0 {
+				wd = 7
+			}
+			b.WriteString(num(wd, 1, '0'))
+		case 'U':
+			b.WriteString(num((t.YearDay()+6-int(t.Weekday()))/7, 2, '0'))
+		case 'V':
+			b.WriteString(num(isoWeek, 2, '0'))
+		case 'w':
+			b.WriteString(num(int(t.Weekday()), 1, '0'))
+		case 'W':
+			b.WriteString(num((t.YearDay()+6-(int(t.Weekday())+6)%7)/7, 2, '0'))
+		case 'x':
+			b.WriteString(text(formatDate(t, "%m/%d/%y")))
+		case 'X':
+			b.WriteString(text(formatDate(t, "%H:%M:%S")))
+		case 'y':
+			b.WriteString(num(t.Year()%100, 2, '0'))
+		case 'Y':
+			b.WriteString(num(t.Year(), 1, '0'))
+		case 'z':
+			b.WriteString(datePadText(dateZoneOffset(t, colons), pad, width))
+		case 'Z':
+			name, _ := t.Zone()
+			if name == "" {
+				name = dateZoneOffset(t, 1)
+			}
+			b.WriteString(swapText(name, true))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteString(format[start : i+1])
+		}
+	}
+	return b.String()
+}
+
+func datePadNumber(n, digits int, defPad, pad byte, width int) string {
+	s := strconv.Itoa(n)
+	if pad == 0 {
+		pad = defPad
+	}
+	if width < 0 {
+		width = digits
+	}
+	if pad == '-' || len(s) >= width {
+		return s
+	}
+	fill := "0"
+	if pad == '_' {
+		fill = " "
+	}
+	return strings.Repeat(fill, width-len(s)) + s
+}
+
+func datePadText(s string, pad byte, width int) string {
+	if width <= len(s) || pad == '-' {
+		return s
+	}
+	fill := " "
+	if pad == '0' {
+		fill = "0"
+	}
+	return strings.Repeat(fill, width-len(s)) + s
+}
+
+// dateZoneOffset renders %z, %:z, %::z and %:::z.
+func dateZoneOffset(t time.Time, colons int) string {
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	h, m, s := offset/3600, offset/60%60, offset%60
+	switch colons {
+	case 1:
+		return fmt.Sprintf("%c%02d:%02d", sign, h, m)
+	case 2:
+		return fmt.Sprintf("%c%02d:%02d:%02d", sign, h, m, s)
+	case 3:
+		switch {
+		case s != 0:
+			return fmt.Sprintf("%c%02d:%02d:%02d", sign, h, m, s)
+		case m != 0:
+			return fmt.Sprintf("%c%02d:%02d", sign, h, m)
+		}
+		return fmt.Sprintf("%c%02d", sign, h)
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, h, m)
+}
+
+const dateHelpText = `Usage: date [OPTION]... [+FORMAT]
+Display the current time in the given FORMAT. This is the GSH built-in date;
+it can't set the system clock.
+
+  -d, --date=STRING          display time described by STRING, not 'now'
+  -f, --file=DATEFILE        like --date; once for each line of DATEFILE
+  -I[FMT], --iso-8601[=FMT]  output date/time in ISO 8601 format.
+                               FMT='date' (default), 'hours', 'minutes',
+                               'seconds', or 'ns'
+  -R, --rfc-email            output date and time in RFC 5322 format
+      --rfc-3339=FMT         output date/time in RFC 3339 format.
+                               FMT='date', 'seconds', or 'ns'
+  -r, --reference=FILE       display the last modification time of FILE
+  -u, --utc, --universal     print Coordinated Universal Time (UTC)
+      --help                 display this help and exit
+
+STRING accepts ISO 8601 and RFC 5322 dates, '@SECONDS', month and weekday
+names, times with am/pm, zones (Z, UTC, +hh:mm), TZ="Area/City" prefixes, and
+relative items such as 'yesterday', 'next monday', '2 weeks ago' or
+'+3 hours'. FORMAT takes the GNU date conversions, including %s, %N, %:z,
+%V and %q, and the -, _, 0, ^ and # flags. TZ in the environment selects the
+output time zone.
+`
@@ -0,0 +1,1362 @@
+package gsh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// The GSH sed builtin implements the POSIX sed command set plus the GNU
+// extensions legacy bash plugins lean on (-i, -E, -s, I/M flags, first~step
+// and addr,+N addresses, T, Q, z, \U/\L case conversion). Regular expressions
+// are translated to Go's RE2 syntax, so backreferences inside a pattern are
+// not available.
+type sedOptions struct {
+	quiet    bool
+	inPlace  bool
+	suffix   string
+	extended bool
+	separate bool
+	script   []string
+	help     bool
+}
+
+type sedAddrKind int
+
+const (
+	sedAddrLine sedAddrKind = iota
+	sedAddrLast
+	sedAddrRegex
+	sedAddrStep
+	sedAddrRelative
+)
+
+type sedAddress struct {
+	kind sedAddrKind
+	line int
+	step int
+	// re is nil for an empty regex, which reuses the last one applied
+	re *regexp.Regexp
+}
+
+type sedReplacement struct {
+	literal string
+	group   int
+	// caseOp is one of 'U', 'L', 'u', 'l', 'E' or 0
+	caseOp byte
+}
+
+type sedCommand struct {
+	addr1, addr2 *sedAddress
+	negate       bool
+	name         byte
+	text         string
+	label        string
+	// target is the jump destination for b/t/T, or the matching '}' for '{'
+	target int
+
+	re          *regexp.Regexp
+	replacement []sedReplacement
+	global      bool
+	nth         int
+	printMatch  bool
+
+	from, to []rune
+	exitCode int
+
+	inRange  bool
+	rangeEnd int
+}
+
+// sedExit ends the script from q/Q with a status.
+type sedExit struct {
+	code int
+}
+
+func (e *sedExit) Error() string {
+	return fmt.Sprintf("exit %d", e.code)
+}
+
+func (c *shellContext) runSed(ctx context.Context, args []string) error {
+	hc := interp.HandlerCtx(ctx)
+	opts, files, err := parseSedArgs(hc, args)
+	if err != nil {
+		fmt.Fprintf(hc.Stderr, "sed: %v\n", err)
+		return interp.ExitStatus(1)
+	}
+	if opts.help {
+		fmt.Fprint(hc.Stdout, sedHelpText)
+		return nil
+	}
+	cmds, err := parseSedScript(strings.Join(opts.script, "\n"), opts.extended)
+	if err != nil {
+		fmt.Fprintf(hc.Stderr, "sed: -e expression: %v\n", err)
+		return interp.ExitStatus(1)
+	}
+	if first := opts.script[0]; first == "#n" || strings.HasPrefix(first, "#n\n") {
+		opts.quiet = true
+	}
+	if opts.inPlace && len(files) == 0 {
+		fmt.Fprintln(hc.Stderr, "sed: no input files")
+		return interp.ExitStatus(1)
+	}
+
+	status := 0
+	run := func(names []string, out io.Writer) error {
+		for _, cmd := range cmds {
+			cmd.inRange = false
+		}
+		r := &sedRunner{
+			cmds:  cmds,
+			quiet: opts.quiet,
+			out:   bufio.NewWriter(out),
+			in:    &sedInput{hc: hc, names: names},
+		}
+		err := r.run(ctx)
+		if ferr := r.out.Flush(); err == nil && ferr != nil {
+			err = ferr
+		}
+		if r.in.failed {
+			status = 2
+		}
+		return err
+	}
+
+	var runErr error
+	switch {
+	case opts.inPlace:
+		for _, name := range files {
+			path := resolvePath(hc.Dir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				fmt.Fprintf(hc.Stderr, "sed: can't read %s: %v\n", name, errors.Unwrap(err))
+				status = 2
+				continue
+			}
+			if runErr = sedInPlace(path, info.Mode().Perm(), opts.suffix, run); runErr != nil {
+				break
+			}
+		}
+	case opts.separate:
+		for _, name := range files {
+			if runErr = run([]string{name}, hc.Stdout); runErr != nil {
+				break
+			}
+		}
+	default:
+		runErr = run(files, hc.Stdout)
+	}
+	var exit *sedExit
+	if errors.As(runErr, &exit) {
+		if exit.code != 0 {
+			return interp.ExitStatus(uint8(exit.code))
+		}
+		runErr = nil
+	}
+	if runErr != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Fprintf(hc.Stderr, "sed: %v\n", runErr)
+		if errors.Is(runErr, errSedNoPreviousRegex) {
+			return interp.ExitStatus(1)
+		}
+		return interp.ExitStatus(4)
+	}
+	if status != 0 {
+		return interp.ExitStatus(uint8(status))
+	}
+	return nil
+}
+
+// sedInPlace runs the script over one file, replacing it through a temporary
+// file in the same directory and keeping a backup when suffix is set.
+func sedInPlace(path string, perm os.FileMode, suffix string, run func([]string, io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sed*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	runErr := run([]string{path}, tmp)
+	var exit *sedExit
+	if runErr != nil && !errors.As(runErr, &exit) {
+		tmp.Close()
+		return runErr
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if suffix != "" {
+		backup := path + suffix
+		if strings.Contains(suffix, "*") {
+			backup = filepath.Join(filepath.Dir(path), strings.ReplaceAll(suffix, "*", filepath.Base(path)))
+		}
+		if err := os.Link(path, backup); err != nil {
+			if err := copyFileContents(path, backup); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return runErr
+}
+
+func copyFileContents(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o644)
+}
+
+func parseSedArgs(hc interp.HandlerContext, args []string) (*sedOptions, []string, error) {
+	opts := &sedOptions{}
+	var files []string
+	haveScript := false
+	addScriptFile := func(name string) error {
+		var data []byte
+		var err error
+		if name == "-" {
+			data, err = io.ReadAll(hc.Stdin)
+		} else {
+			data, err = os.ReadFile(resolvePath(hc.Dir, name))
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't open file %s: %v", name, err)
+		}
+		opts.script = append(opts.script, strings.TrimSuffix(string(data), "\n"))
+		haveScript = true
+		return nil
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			files = append(files, args[i+1:]...)
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		if strings.HasPrefix(arg, "--") {
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			take := func() (string, error) {
+				if hasValue {
+					return value, nil
+				}
+				i++
+				if i >= len(args) {
+					return "", fmt.Errorf("option '--%s' requires an argument", name)
+				}
+				return args[i], nil
+			}
+			switch name {
+			case "quiet", "silent":
+				opts.quiet = true
+			case "expression":
+				v, err := take()
+				if err != nil {
+					return nil, nil, err
+				}
+				opts.script = append(opts.script, v)
+				haveScript = true
+			case "file":
+				v, err := take()
+				if err != nil {
+					return nil, nil, err
+				}
+				if err := addScriptFile(v); err != nil {
+					return nil, nil, err
+				}
+			case "in-place":
+				opts.inPlace = true
+				opts.suffix = value
+			case "regexp-extended":
+				opts.extended = true
+			case "separate":
+				opts.separate = true
+			case "posix", "debug", "sandbox":
+			case "help":
+				opts.help = true
+			default:
+				return nil, nil, fmt.Errorf("unknown option --%s", name)
+			}
+			continue
+		}
+		for j := 1; j < len(arg); j++ {
+			switch ch := arg[j]; ch {
+			case 'n':
+				opts.quiet = true
+			case 'E', 'r':
+				opts.extended = true
+			case 's':
+				opts.separate = true
+			case 'i':
+				opts.inPlace = true
+				opts.suffix = arg[j+1:]
+				j = len(arg)
+			case 'e', 'f':
+				value := arg[j+1:]
+				if value == "" {
+					i++
+					if i >= len(args) {
+						return nil, nil, fmt.Errorf("option requires an argument -- '%c'", ch)
+					}
+					value = args[i]
+				}
+				if ch == 'e' {
+					opts.script = append(opts.script, value)
+					haveScript = true
+				} else if err := addScriptFile(value); err != nil {
+					return nil, nil, err
+				}
+				j = len(arg)
+			default:
+				return nil, nil, fmt.Errorf("invalid option -- '%c'", ch)
+			}
+		}
+	}
+	if opts.help {
+		return opts, nil, nil
+	}
+	if !haveScript {
+		if len(files) == 0 {
+			return nil, nil, errors.New("no script specified")
+		}
+		opts.script = []string{files[0]}
+		files = files[1:]
+	}
+	return opts, files, nil
+}
+
+// sedParser reads a sed script into a flat command list; blocks and branches
+// are resolved to indexes in that list.
+type sedParser struct {
+	src      []rune
+	pos      int
+	extended bool
+	cmds     []*sedCommand
+	// sawRegex is set once the script has a non-empty regex, which an
+	// empty // can then reuse.
+	sawRegex bool
+}
+
+func parseSedScript(script string, extended bool) ([]*sedCommand, error) {
+	p := &sedParser{src: []rune(script), extended: extended}
+	var blocks []int
+	labels := map[string]int{}
+	for {
+		p.skipSeparators()
+		if p.eof() {
+			break
+		}
+		cmd := &sedCommand{}
+		var err error
+		if cmd.addr1, err = p.address(false); err != nil {
+			return nil, err
+		}
+		if cmd.addr1 != nil {
+			p.skipSpaces()
+			if p.peek() == ',' {
+				p.pos++
+				p.skipSpaces()
+				if cmd.addr2, err = p.address(true); err != nil {
+					return nil, err
+				}
+				if cmd.addr2 == nil {
+					return nil, p.errorf("unexpected `,'")
+				}
+			}
+			// Line 0 only makes sense as 0,/re/, a range that's already
+			// open when line 1 is read.
+			if cmd.addr1.kind == sedAddrLine && cmd.addr1.line == 0 && (cmd.addr2 == nil || cmd.addr2.kind != sedAddrRegex) {
+				return nil, p.errorf("invalid usage of line address 0")
+			}
+		}
+		p.skipSpaces()
+		for p.peek() == '!' {
+			cmd.negate = true
+			p.pos++
+			p.skipSpaces()
+		}
+		if p.eof() {
+			return nil, p.errorf("missing command")
+		}
+		cmd.name = byte(p.next())
+		if cmd.addr1 != nil && strings.IndexByte(":}", cmd.name) >= 0 {
+			return nil, p.errorf("%c doesn't want any addresses", cmd.name)
+		}
+		switch cmd.name {
+		case '{':
+			blocks = append(blocks, len(p.cmds))
+			p.cmds = append(p.cmds, cmd)
+			continue
+		case '}':
+			if len(blocks) == 0 {
+				return nil, p.errorf("unexpected `}'")
+			}
+			open := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			p.cmds[open].target = len(p.cmds)
+		case '=', 'd', 'D', 'g', 'G', 'h', 'H', 'n', 'N', 'p', 'P', 'x', 'z':
+		case 'a', 'i', 'c':
+			cmd.text = p.text()
+		case ':':
+			cmd.label = p.label()
+			if cmd.label == "" {
+				return nil, p.errorf("\":\" lacks a label")
+			}
+			labels[cmd.label] = len(p.cmds)
+		case 'b', 't', 'T':
+			p.skipSpaces()
+			cmd.label = p.label()
+		case 'q', 'Q':
+			p.skipSpaces()
+			start := p.pos
+			for !p.eof() && unicode.IsDigit(p.peek()) {
+				p.pos++
+			}
+			if start != p.pos {
+				cmd.exitCode, _ = strconv.Atoi(string(p.src[start:p.pos]))
+			}
+		case 's':
+			if err := p.substitute(cmd); err != nil {
+				return nil, err
+			}
+		case 'y':
+			if err := p.transliterate(cmd); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.errorf("unknown command: `%c'", cmd.name)
+		}
+		p.cmds = append(p.cmds, cmd)
+		if err := p.endCommand(); err != nil {
+			return nil, err
+		}
+	}
+	if len(blocks) > 0 {
+		return nil, p.errorf("unmatched `{'")
+	}
+	for _, cmd := range p.cmds {
+		if strings.IndexByte("btT", cmd.name) < 0 {
+			continue
+		}
+		if cmd.label == "" {
+			cmd.target = len(p.cmds)
+			continue
+		}
+		target, ok := labels[cmd.label]
+		if !ok {
+			return nil, fmt.Errorf("can't find label for jump to `%s'", cmd.label)
+		}
+		cmd.target = target
+	}
+	return p.cmds, nil
+}
+
+func (p *sedParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("char %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *sedParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *sedParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *sedParser) next() rune {
+	ch := p.peek()
+	p.pos++
+	return ch
+}
+
+func (p *sedParser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *sedParser) skipSeparators() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\n', ';':
+			p.pos++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *sedParser) endCommand() error {
+	p.skipSpaces()
+	switch p.peek() {
+	case 0, '\n', ';', '}', '#':
+		return nil
+	}
+	return p.errorf("extra characters after command")
+}
+
+func (p *sedParser) number() int {
+	start := p.pos
+	for !p.eof() && unicode.IsDigit(p.peek()) {
+		p.pos++
+	}
+	n, _ := strconv.Atoi(string(p.src[start:p.pos]))
+	return n
+}
+
+func (p *sedParser) address(second bool) (*sedAddress, error) {
+	switch ch := p.peek(); {
+	case unicode.IsDigit(ch):
+		n := p.number()
+		if p.peek() == '~' {
+			p.pos++
+			return &sedAddress{kind: sedAddrStep, line: n, step: p.number()}, nil
+		}
+		return &sedAddress{kind: sedAddrLine, line: n}, nil
+	case ch == '$':
+		p.pos++
+		return &sedAddress{kind: sedAddrLast}, nil
+	case ch == '+' && second:
+		p.pos++
+		return &sedAddress{kind: sedAddrRelative, line: p.number()}, nil
+	case ch == '/' || ch == '\\':
+		p.pos++
+		delim := '/'
+		if ch == '\\' {
+			delim = p.next()
+		}
+		pattern, err := p.delimited(delim, true)
+		if err != nil {
+			return nil, err
+		}
+		flags := ""
+		for p.peek() == 'I' || p.peek() == 'M' {
+			flags += string(p.next())
+		}
+		addr := &sedAddress{kind: sedAddrRegex}
+		if err := p.checkEmptyRegex(pattern); err != nil {
+			return nil, err
+		}
+		if pattern != "" {
+			if addr.re, err = compileSedRegex(pattern, p.extended, flags); err != nil {
+				return nil, err
+			}
+		}
+		return addr, nil
+	}
+	return nil, nil
+}
+
+// checkEmptyRegex rejects an empty regex that comes before any other in the
+// script, as GNU sed does.
+func (p *sedParser) checkEmptyRegex(pattern string) error {
+	if pattern != "" {
+		p.sawRegex = true
+		return nil
+	}
+	if !p.sawRegex {
+		return p.errorf("no previous regular expression")
+	}
+	return nil
+}
+
+// delimited reads up to an unescaped delim. In regexes "\delim" becomes the
+// delimiter itself and other escapes are kept for the regex translator; a
+// newline can be written as "\n".
+func (p *sedParser) delimited(delim rune, regex bool) (string, error) {
+	var b strings.Builder
+	inBracket := false
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated address regex or `s' command")
+		}
+		ch := p.next()
+		switch {
+		case regex && inBracket:
+			if ch == ']' {
+				inBracket = false
+			}
+			b.WriteRune(ch)
+		case ch == '\\':
+			if p.eof() {
+				return "", p.errorf("trailing backslash")
+			}
+			next := p.next()
+			switch {
+			case next == delim && delim != '&':
+				b.WriteRune(delim)
+			case next == '\n':
+				b.WriteRune('\n')
+			default:
+				b.WriteRune('\\')
+				b.WriteRune(next)
+			}
+		case ch == delim:
+			return b.String(), nil
+		case regex && ch == '[':
+			inBracket = true
+			b.WriteRune(ch)
+			if p.peek() == '^' {
+				b.WriteRune(p.next())
+			}
+			if p.peek() == ']' {
+				b.WriteRune(p.next())
+			}
+		default:
+			b.WriteRune(ch)
+		}
+	}
+}
+
+func (p *sedParser) substitute(cmd *sedCommand) error {
+	delim := p.next()
+	if delim == 0 || delim == '\n' || delim == '\\' {
+		return p.errorf("unterminated `s' command")
+	}
+	pattern, err := p.delimited(delim, true)
+	if err != nil {
+		return err
+	}
+	replacement, err := p.delimited(delim, false)
+	if err != nil {
+		return err
+	}
+	cmd.nth = 1
+	flags := ""
+	nthSet := false
+	for done := false; !done && !p.eof(); {
+		switch ch := p.peek(); {
+		case ch == 'g':
+			cmd.global = true
+			p.pos++
+		case ch == 'p':
+			cmd.printMatch = true
+			p.pos++
+		case ch == 'i' || ch == 'I':
+			flags += "I"
+			p.pos++
+		case ch == 'm' || ch == 'M':
+			flags += "M"
+			p.pos++
+		case unicode.IsDigit(ch):
+			if nthSet {
+				return p.errorf("multiple number options to `s' command")
+			}
+			cmd.nth = p.number()
+			nthSet = true
+			if cmd.nth == 0 {
+				return p.errorf("number option to `s' command may not be zero")
+			}
+		case ch == 'e' || ch == 'w':
+			return p.errorf("the `%c' flag of `s' is not supported in gsh", ch)
+		default:
+			done = true
+		}
+	}
+	if err := p.checkEmptyRegex(pattern); err != nil {
+		return err
+	}
+	if pattern != "" {
+		if cmd.re, err = compileSedRegex(pattern, p.extended, flags); err != nil {
+			return err
+		}
+	}
+	cmd.replacement = parseSedReplacement(replacement)
+	return nil
+}
+
+func parseSedReplacement(text string) []sedReplacement {
+	var parts []sedReplacement
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, sedReplacement{literal: lit.String(), group: -1})
+			lit.Reset()
+		}
+	}
+	src := []rune(text)
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == '&':
+			flush()
+			parts = append(parts, sedReplacement{group: 0})
+		case ch == '\\' && i+1 < len(src):
+			i++
+			next := src[i]
+			switch {
+			case next >= '0' && next <= '9':
+				flush()
+				parts = append(parts, sedReplacement{group: int(next - '0')})
+			case next == 'n':
+				lit.WriteRune('\n')
+			case next == 't':
+				lit.WriteRune('\t')
+			case strings.ContainsRune("ULulE", next):
+				flush()
+				parts = append(parts, sedReplacement{group: -1, caseOp: byte(next)})
+			default:
+				lit.WriteRune(next)
+			}
+		default:
+			lit.WriteRune(ch)
+		}
+	}
+	flush()
+	return parts
+}
+
+func (p *sedParser) transliterate(cmd *sedCommand) error {
+	delim := p.next()
+	if delim == 0 || delim == '\n' || delim == '\\' {
+		return p.errorf("unterminated `y' command")
+	}
+	read := func() ([]rune, error) {
+		var out []rune
+		for {
+			if p.eof() {
+				return nil, p.errorf("unterminated `y' command")
+			}
+			ch := p.next()
+			switch {
+			case ch == delim:
+				return out, nil
+			case ch == '\\' && !p.eof():
+				next := p.next()
+				switch next {
+				case 'n':
+					out = append(out, '\n')
+				case 't':
+					out = append(out, '\t')
+				default:
+					out = append(out, next)
+				}
+			default:
+				out = append(out, ch)
+			}
+		}
+	}
+	var err error
+	if cmd.from, err = read(); err != nil {
+		return err
+	}
+	if cmd.to, err = read(); err != nil {
+		return err
+	}
+	if len(cmd.from) != len(cmd.to) {
+		return p.errorf("strings for `y' command are different lengths")
+	}
+	return nil
+}
+
+// text reads the argument of a, i and c in both the POSIX "a\" form and the
+// GNU one-line form; a trailing backslash continues the text on the next line.
+func (p *sedParser) text() string {
+	p.skipSpaces()
+	if p.peek() == '\\' {
+		p.pos++
+		p.skipSpaces()
+		if p.peek() == '\n' {
+			p.pos++
+		}
+	}
+	var b strings.Builder
+	for !p.eof() && p.peek() != '\n' {
+		ch := p.next()
+		if ch == '\\' && !p.eof() {
+			ch = p.next()
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+func (p *sedParser) label() string {
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune("\n;}", p.peek()) {
+		p.pos++
+	}
+	return strings.TrimSpace(string(p.src[start:p.pos]))
+}
+
+// compileSedRegex translates a POSIX basic or extended regex to RE2. Pattern
+// space may hold several lines, so "." matches a newline as it does in sed.
+func compileSedRegex(pattern string, extended bool, flags string) (*regexp.Regexp, error) {
+	prefix := "(?s"
+	if strings.Contains(flags, "I") {
+		prefix += "i"
+	}
+	if strings.Contains(flags, "M") {
+		prefix += "m"
+	}
+	re, err := regexp.Compile(prefix + ")" + translatePosixRegex(pattern, extended))
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+func translatePosixRegex(pattern string, extended bool) string {
+	var b strings.Builder
+	src := []rune(pattern)
+	// atStart tracks where a BRE '*' is literal: at the start of the regex
+	// or a group, or right after an anchor.
+	atStart := true
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == '[':
+			j := i + 1
+			if j < len(src) && src[j] == '^' {
+				j++
+			}
+			if j < len(src) && src[j] == ']' {
+				j++
+			}
+			for j < len(src) && src[j] != ']' {
+				// skip over [:class:], [.sym.] and [=equiv=]
+				if src[j] == '[' && j+1 < len(src) && strings.ContainsRune(":.=", src[j+1]) {
+					for k := j + 2; k+1 < len(src); k++ {
+						if src[k] == src[j+1] && src[k+1] == ']' {
+							j = k + 1
+							break
+						}
+					}
+				}
+				j++
+			}
+			if j >= len(src) {
+				b.WriteString(regexp.QuoteMeta(string(src[i:])))
+				return b.String()
+			}
+			class := string(src[i : j+1])
+			class = strings.Replace(class, "[]", `[\]`, 1)
+			class = strings.Replace(class, "[^]", `[^\]`, 1)
+			b.WriteString(class)
+			i = j
+			atStart = false
+			continue
+		case ch == '\\' && i+1 < len(src):
+			i++
+			next := src[i]
+			switch {
+			case !extended && strings.ContainsRune("(){}|+?", next):
+				b.WriteRune(next)
+				atStart = next == '(' || next == '|'
+				continue
+			case next == '<' || next == '>':
+				b.WriteString(`\b`)
+			case next == '`':
+				b.WriteString(`\A`)
+			case next == '\'':
+				b.WriteString(`\z`)
+			case next == 'n':
+				b.WriteString(`\n`)
+			case next == 't':
+				b.WriteString(`\t`)
+			case next >= '1' && next <= '9':
+				// RE2 has no backreferences; match the digit literally
+				// rather than failing the whole script.
+				b.WriteRune(next)
+			default:
+				b.WriteRune('\\')
+				b.WriteRune(next)
+			}
+		case !extended && strings.ContainsRune("(){}|+?", ch):
+			b.WriteRune('\\')
+			b.WriteRune(ch)
+		case ch == '*' && atStart:
+			b.WriteString(`\*`)
+		case ch == '^':
+			b.WriteRune(ch)
+			atStart = true
+			continue
+		case extended && (ch == '(' || ch == '|'):
+			b.WriteRune(ch)
+			atStart = true
+			continue
+		default:
+			b.WriteRune(ch)
+		}
+		atStart = false
+	}
+	return b.String()
+}
+
+// sedInput streams the named files (or stdin) as one sequence of lines,
+// reading one line ahead so '$' can be recognized.
+type sedInput struct {
+	hc     interp.HandlerContext
+	names  []string
+	opened bool
+	reader *bufio.Reader
+	closer io.Closer
+	// the next line, when ok
+	next      string
+	nextNoEOL bool
+	nextOK    bool
+	failed    bool
+}
+
+func (in *sedInput) openNext() bool {
+	if in.closer != nil {
+		in.closer.Close()
+		in.closer = nil
+	}
+	for {
+		if !in.opened {
+			in.opened = true
+			if len(in.names) == 0 {
+				in.reader = bufio.NewReader(gshStdinReader(in.hc))
+				return true
+			}
+		}
+		if len(in.names) == 0 {
+			return false
+		}
+		name := in.names[0]
+		in.names = in.names[1:]
+		if name == "-" {
+			in.reader = bufio.NewReader(gshStdinReader(in.hc))
+			return true
+		}
+		f, err := os.Open(resolvePath(in.hc.Dir, name))
+		if err != nil {
+			fmt.Fprintf(in.hc.Stderr, "sed: can't read %s: %v\n", name, errors.Unwrap(err))
+			in.failed = true
+			continue
+		}
+		in.reader = bufio.NewReader(f)
+		in.closer = f
+		return true
+	}
+}
+
+func (in *sedInput) fill() error {
+	in.nextOK = false
+	for {
+		if in.reader == nil && !in.openNext() {
+			return nil
+		}
+		line, err := in.reader.ReadString('\n')
+		if len(line) > 0 {
+			in.nextNoEOL = !strings.HasSuffix(line, "\n")
+			in.next = strings.TrimSuffix(line, "\n")
+			in.nextOK = true
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		in.reader = nil
+	}
+}
+
+// read returns the next line, whether it lacked a trailing newline, and
+// whether there was one at all.
+func (in *sedInput) read() (string, bool, bool, error) {
+	if !in.opened {
+		if err := in.fill(); err != nil {
+			return "", false, false, err
+		}
+	}
+	if !in.nextOK {
+		return "", false, false, nil
+	}
+	line, noEOL := in.next, in.nextNoEOL
+	err := in.fill()
+	return line, noEOL, true, err
+}
+
+func (in *sedInput) last() bool {
+	return !in.nextOK
+}
+
+type sedRunner struct {
+	cmds    []*sedCommand
+	quiet   bool
+	out     *bufio.Writer
+	in      *sedInput
+	lineNo  int
+	noEOL   bool
+	hold    string
+	lastRe  *regexp.Regexp
+	appends []string
+}
+
+func (r *sedRunner) emit(text string) {
+	r.out.WriteString(text)
+	if !r.noEOL || !r.in.last() {
+		r.out.WriteByte('\n')
+	}
+}
+
+func (r *sedRunner) flushAppends() {
+	for _, text := range r.appends {
+		r.out.WriteString(text)
+		r.out.WriteByte('\n')
+	}
+	r.appends = r.appends[:0]
+}
+
+func (r *sedRunner) readLine() (string, bool, error) {
+	line, noEOL, ok, err := r.in.read()
+	if ok {
+		r.lineNo++
+		r.noEOL = noEOL
+	}
+	return line, ok, err
+}
+
+func (r *sedRunner) run(ctx context.Context) error {
+	ps, ok, err := r.readLine()
+	for ok && err == nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		next, restart, err := r.cycle(ps)
+		if err != nil {
+			return err
+		}
+		if restart {
+			ps = next
+			continue
+		}
+		ps, ok, err = r.readLine()
+	}
+	return err
+}
+
+// cycle runs the script over one pattern space. For D it returns the pattern
+// space to restart with instead of reading a new line.
+func (r *sedRunner) cycle(ps string) (string, bool, error) {
+	substituted := false
+	autoprint := !r.quiet
+	for pc := 0; pc < len(r.cmds); pc++ {
+		cmd := r.cmds[pc]
+		selected, err := r.selected(cmd, ps)
+		if err != nil {
+			return "", false, err
+		}
+		if !selected {
+			if cmd.name == '{' {
+				pc = cmd.target
+			}
+			continue
+		}
+		switch cmd.name {
+		case '{', '}', ':':
+		case '=':
+			fmt.Fprintf(r.out, "%d\n", r.lineNo)
+		case 'a':
+			r.appends = append(r.appends, cmd.text)
+		case 'i':
+			r.out.WriteString(cmd.text + "\n")
+		case 'c':
+			if cmd.addr2 == nil || !cmd.inRange || cmd.negate {
+				r.out.WriteString(cmd.text + "\n")
+			}
+			r.flushAppends()
+			return "", false, nil
+		case 'd':
+			r.flushAppends()
+			return "", false, nil
+		case 'D':
+			nl := strings.IndexByte(ps, '\n')
+			r.flushAppends()
+			if nl < 0 {
+				return "", false, nil
+			}
+			return ps[nl+1:], true, nil
+		case 'p':
+			r.emit(ps)
+		case 'P':
+			if nl := strings.IndexByte(ps, '\n'); nl >= 0 {
+				r.out.WriteString(ps[:nl+1])
+			} else {
+				r.emit(ps)
+			}
+		case 'n', 'N':
+			if r.in.last() {
+				if autoprint {
+					r.emit(ps)
+				}
+				r.flushAppends()
+				return "", false, nil
+			}
+			if cmd.name == 'n' && autoprint {
+				r.emit(ps)
+			}
+			r.flushAppends()
+			line, _, err := r.readLine()
+			if err != nil {
+				return "", false, err
+			}
+			if cmd.name == 'n' {
+				ps = line
+			} else {
+				ps += "\n" + line
+			}
+		case 'g':
+			ps = r.hold
+		case 'G':
+			ps += "\n" + r.hold
+		case 'h':
+			r.hold = ps
+		case 'H':
+			r.hold += "\n" + ps
+		case 'x':
+			ps, r.hold = r.hold, ps
+		case 'z':
+			ps = ""
+		case 'q':
+			if autoprint {
+				r.emit(ps)
+			}
+			r.flushAppends()
+			return "", false, &sedExit{code: cmd.exitCode}
+		case 'Q':
+			return "", false, &sedExit{code: cmd.exitCode}
+		case 's':
+			out, changed, err := r.substitute(cmd, ps)
+			if err != nil {
+				return "", false, err
+			}
+			if changed {
+				ps = out
+				substituted = true
+				if cmd.printMatch {
+					r.emit(ps)
+				}
+			}
+		case 'y':
+			ps = strings.Map(func(ch rune) rune {
+				for i, from := range cmd.from {
+					if ch == from {
+						return cmd.to[i]
+					}
+				}
+				return ch
+			}, ps)
+		case 'b':
+			pc = cmd.target - 1
+		case 't', 'T':
+			if substituted == (cmd.name == 't') {
+				pc = cmd.target - 1
+			}
+			substituted = false
+		}
+	}
+	if autoprint {
+		r.emit(ps)
+	}
+	r.flushAppends()
+	return "", false, nil
+}
+
+// errSedNoPreviousRegex is returned when an empty regex runs before any
+// other; like GNU sed, it's a script error (exit 1), not an I/O one.
+var errSedNoPreviousRegex = errors.New("no previous regular expression")
+
+func (r *sedRunner) regex(re *regexp.Regexp) (*regexp.Regexp, error) {
+	if re == nil {
+		if r.lastRe == nil {
+			return nil, errSedNoPreviousRegex
+		}
+		return r.lastRe, nil
+	}
+	r.lastRe = re
+	return re, nil
+}
+
+func (r *sedRunner) matchAddress(addr *sedAddress, ps string) (bool, error) {
+	switch addr.kind {
+	case sedAddrLine:
+		return r.lineNo == addr.line, nil
+	case sedAddrLast:
+		return r.in.last(), nil
+	case sedAddrStep:
+		if addr.step <= 0 {
+			return r.lineNo == addr.line, nil
+		}
+		return r.lineNo >= addr.line && (r.lineNo-addr.line)%addr.step == 0, nil
+	case sedAddrRegex:
+		re, err := r.regex(addr.re)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(ps), nil
+	}
+	return false, nil
+}
+
+func (r *sedRunner) selected(cmd *sedCommand, ps string) (bool, error) {
+	matched, err := r.inRange(cmd, ps)
+	if err != nil {
+		return false, err
+	}
+	return matched != cmd.negate, nil
+}
+
+func (r *sedRunner) inRange(cmd *sedCommand, ps string) (bool, error) {
+	if cmd.addr1 == nil {
+		return true, nil
+	}
+	if cmd.addr2 == nil {
+		return r.matchAddress(cmd.addr1, ps)
+	}
+	if !cmd.inRange {
+		if cmd.addr1.kind == sedAddrLine && cmd.addr1.line == 0 {
+			// 0,/re/ is open on line 1, so /re/ can close it right there.
+			if r.lineNo != 1 {
+				return false, nil
+			}
+			ok, err := r.matchAddress(cmd.addr2, ps)
+			if err != nil {
+				return false, err
+			}
+			cmd.inRange = !ok
+			return true, nil
+		}
+		ok, err := r.matchAddress(cmd.addr1, ps)
+		if err != nil || !ok {
+			return false, err
+		}
+		switch cmd.addr2.kind {
+		case sedAddrLine:
+			cmd.inRange = cmd.addr2.line > r.lineNo
+		case sedAddrRelative:
+			cmd.rangeEnd = r.lineNo + cmd.addr2.line
+			cmd.inRange = cmd.addr2.line > 0
+		case sedAddrLast:
+			cmd.inRange = !r.in.last()
+		default:
+			cmd.inRange = true
+		}
+		return true, nil
+	}
+	switch cmd.addr2.kind {
+	case sedAddrLine:
+		cmd.inRange = r.lineNo < cmd.addr2.line
+	case sedAddrRelative:
+		cmd.inRange = r.lineNo < cmd.rangeEnd
+	default:
+		ok, err := r.matchAddress(cmd.addr2, ps)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			cmd.inRange = false
+		}
+	}
+	return true, nil
+}
+
+func (r *sedRunner) substitute(cmd *sedCommand, ps string) (string, bool, error) {
+	re, err := r.regex(cmd.re)
+	if err != nil {
+		return "", false, err
+	}
+	matches := re.FindAllStringSubmatchIndex(ps, -1)
+	if len(matches) < cmd.nth {
+		return ps, false, nil
+	}
+	var b strings.Builder
+	last := 0
+	changed := false
+	for i, m := range matches {
+		n := i + 1
+		if n < cmd.nth || (n > cmd.nth && !cmd.global) {
+			continue
+		}
+		b.WriteString(ps[last:m[0]])
+		b.WriteString(expandSedReplacement(cmd.replacement, ps, m))
+		last = m[1]
+		changed = true
+	}
+	b.WriteString(ps[last:])
+	return b.String(), changed, nil
+}
+
+func expandSedReplacement(parts []sedReplacement, src string, m []int) string {
+	var b strings.Builder
+	var mode, once byte
+	write := func(s string) {
+		for _, ch := range s {
+			switch once {
+			case 'u':
+				ch = unicode.ToUpper(ch)
+				once = 0
+			case 'l':
+				ch = unicode.ToLower(ch)
+				once = 0
+			default:
+				switch mode {
+				case 'U':
+					ch = unicode.ToUpper(ch)
+				case 'L':
+					ch = unicode.ToLower(ch)
+				}
+			}
+			b.WriteRune(ch)
+		}
+	}
+	for _, part := range parts {
+		switch {
+		case part.caseOp == 'u' || part.caseOp == 'l':
+			once = part.caseOp
+		case part.caseOp == 'U' || part.caseOp == 'L':
+			mode, once = part.caseOp, 0
+		case part.caseOp == 'E':
+			mode, once = 0, 0
+		case part.group >= 0:
+			if 2*part.group+1 < len(m) && m[2*part.group] >= 0 {
+				write(src[m[2*part.group]:m[2*part.group+1]])
+			}
+		default:
+			write(part.literal)
+		}
+	}
+	return b.String()
+}
+
+const sedHelpText = `Usage: sed [OPTION]... {script} [input-file]...
+GSH builtin supporting POSIX sed plus common GNU extensions:
+  -n, --quiet, --silent   suppress automatic printing of pattern space
+  -e, --expression=SCRIPT add SCRIPT to the commands to be executed
+  -f, --file=FILE         add the contents of FILE to the commands
+  -i[SUFFIX], --in-place[=SUFFIX]
+                          edit files in place, keeping a backup with SUFFIX
+  -E, -r, --regexp-extended
+                          use extended regular expressions
+  -s, --separate          treat files as separate rather than one stream
+Regular expressions use Go's RE2 engine; backreferences inside a pattern
+(e.g. \(a\)\1) are not supported. The r, R, w, W, e, F, l and v commands are
+not available.
+`
@@ -0,0 +1,147 @@
+package gsh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sedTestInput = "one\ntwo\nthree\nfour\nfive\n"
+
+func TestSedBuiltinCases(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		want   string
+		status int
+		stderr string
+	}{
+		// address ranges
+		{name: "line range", args: []string{"2,4d"}, want: "one\nfive\n"},
+		{name: "last line", args: []string{"-n", "$p"}, want: "five\n"},
+		{name: "line to last", args: []string{"3,$d"}, want: "one\ntwo\n"},
+		{name: "regex range", args: []string{"-n", "/two/,/four/p"}, want: "two\nthree\nfour\n"},
+		{name: "regex range to end", args: []string{"-n", "/four/,/nomatch/p"}, want: "four\nfive\n"},
+		{name: "range end before start", args: []string{"-n", "3,1p"}, want: "three\n"},
+		{name: "relative range", args: []string{"-n", "/two/,+2p"}, want: "two\nthree\nfour\n"},
+		{name: "step address", args: []string{"-n", "1~2p"}, want: "one\nthree\nfive\n"},
+		{name: "negated range", args: []string{"2,4!d"}, want: "two\nthree\nfour\n"},
+		{name: "range reopens", args: []string{"-n", "/a/,/b/p"}, stdin: "a\nx\nb\ny\na\nz\n", want: "a\nx\nb\na\nz\n"},
+		{name: "zero to regex", args: []string{"0,/one/d"}, want: "two\nthree\nfour\nfive\n"},
+		{name: "one to regex", args: []string{"1,/one/d"}, want: ""},
+		{name: "zero to later regex", args: []string{"0,/three/d"}, want: "four\nfive\n"},
+		{name: "block on range", args: []string{"2,3{s/^/> /;s/$/ </}"}, want: "one\n> two <\n> three <\nfour\nfive\n"},
+
+		// -n and p
+		{name: "quiet", args: []string{"-n", "s/o/0/"}, want: ""},
+		{name: "quiet with p flag", args: []string{"-n", "s/o/0/p"}, want: "0ne\ntw0\nf0ur\n"},
+		{name: "p without quiet doubles", args: []string{"2p"}, stdin: "a\nb\n", want: "a\nb\nb\n"},
+		{name: "#n first line", args: []string{"#n\n/three/p"}, want: "three\n"},
+
+		// s///g and numbered flags
+		{name: "first match only", args: []string{"s/a/X/"}, stdin: "banana\n", want: "bXnana\n"},
+		{name: "global", args: []string{"s/a/X/g"}, stdin: "banana\n", want: "bXnXnX\n"},
+		{name: "numbered", args: []string{"s/a/X/2"}, stdin: "banana\n", want: "banXna\n"},
+		{name: "numbered global", args: []string{"s/a/X/2g"}, stdin: "banana\n", want: "banXnX\n"},
+		{name: "numbered past matches", args: []string{"s/a/X/4"}, stdin: "banana\n", want: "banana\n"},
+		{name: "case insensitive", args: []string{"s/A/x/Ig"}, stdin: "aAa\n", want: "xxx\n"},
+		{name: "ampersand and groups", args: []string{`s/\(b\)\(an\)/[&:\2\1]/`}, stdin: "banana\n", want: "[ban:anb]ana\n"},
+		{name: "extended groups", args: []string{"-E", "s/(a|n)+$/<&>/"}, stdin: "banana\n", want: "b<anana>\n"},
+		{name: "empty regex reuses last", args: []string{"/an/s//AN/g"}, stdin: "banana\n", want: "bANANa\n"},
+		{name: "other delimiter", args: []string{"s|/usr|/opt|"}, stdin: "/usr/bin\n", want: "/opt/bin\n"},
+
+		// errors
+		{name: "invalid regex", args: []string{"-E", "s/(a/b/"}, status: 1, stderr: "invalid regex"},
+		{name: "invalid address regex", args: []string{"/[a/p"}, status: 1, stderr: "sed: -e expression"},
+		{name: "line address zero", args: []string{"0p"}, status: 1, stderr: "invalid usage of line address 0"},
+		{name: "line address zero to line", args: []string{"0,3p"}, status: 1, stderr: "invalid usage of line address 0"},
+		{name: "empty regex without previous", args: []string{"-n", "//p"}, status: 1, stderr: "no previous regular expression"},
+		{name: "empty range end without previous", args: []string{"-n", "2,//p"}, status: 1, stderr: "no previous regular expression"},
+		{name: "empty regex runs first", args: []string{"-n", "2{/a/p};//p"}, stdin: "a\nb\n", status: 1, stderr: "no previous regular expression"},
+		{name: "unterminated s", args: []string{"s/a/b"}, status: 1, stderr: "unterminated"},
+		{name: "zero number flag", args: []string{"s/a/b/0"}, status: 1, stderr: "may not be zero"},
+		{name: "two number flags", args: []string{"s/a/b/1g2"}, status: 1, stderr: "multiple number options"},
+		{name: "w flag", args: []string{"s/a/b/w out.txt"}, status: 1, stderr: "not supported"},
+		{name: "unknown option", args: []string{"-Q", "p"}, status: 1, stderr: "invalid option -- 'Q'"},
+		{name: "no script", args: nil, status: 1, stderr: "no script specified"},
+		{name: "missing file", args: []string{"p", "missing.txt"}, status: 2, stderr: "missing.txt"},
+		{name: "-i without files", args: []string{"-i", "p"}, status: 1, stderr: "no input files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin := tt.stdin
+			if stdin == "" {
+				stdin = sedTestInput
+			}
+			stdout, stderr, status := runBuiltin(t, dir, stdin, append([]string{"sed"}, tt.args...)...)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d; stderr=%q", status, tt.status, stderr)
+			}
+			if tt.stderr != "" && !strings.Contains(stderr, tt.stderr) {
+				t.Fatalf("stderr = %q, want %q", stderr, tt.stderr)
+			}
+			if tt.status == 0 && stdout != tt.want {
+				t.Fatalf("stdout = %q, want %q; stderr=%q", stdout, tt.want, stderr)
+			}
+		})
+	}
+}
+
+func TestSedInPlace(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(sedTestInput), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	plain := write("plain.txt")
+	if stdout, stderr, status := runBuiltin(t, dir, "", "sed", "-i", "-n", "2,3p", "plain.txt"); status != 0 || stdout != "" {
+		t.Fatalf("sed -i -n = %q, status %d; stderr=%q", stdout, status, stderr)
+	}
+	if got := read(plain); got != "two\nthree\n" {
+		t.Fatalf("sed -i -n 2,3p left %q", got)
+	}
+	if info, err := os.Stat(plain); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0o640 {
+		t.Fatalf("sed -i mode = %v, want 0640 kept", info.Mode().Perm())
+	}
+	if _, err := os.Stat(plain + ".bak"); !os.IsNotExist(err) {
+		t.Fatalf("sed -i without a suffix made a backup: %v", err)
+	}
+
+	first, second := write("a.txt"), write("b.txt")
+	if _, stderr, status := runBuiltin(t, dir, "", "sed", "-i.bak", "-e", "1d", "-e", "$s/five/end/", "a.txt", "b.txt"); status != 0 {
+		t.Fatalf("sed -i.bak status %d; stderr=%q", status, stderr)
+	}
+	// -i treats each file separately, so 1d and $ apply per file.
+	for _, path := range []string{first, second} {
+		if got := read(path); got != "two\nthree\nfour\nend\n" {
+			t.Fatalf("%s = %q after sed -i.bak", filepath.Base(path), got)
+		}
+		if got := read(path + ".bak"); got != sedTestInput {
+			t.Fatalf("%s.bak = %q, want the original", filepath.Base(path), got)
+		}
+	}
+
+	write("keep.txt")
+	if _, _, status := runBuiltin(t, dir, "", "sed", "-i", "s/(/x/;s/a", "keep.txt"); status != 1 {
+		t.Fatalf("sed -i with a bad script status %d, want 1", status)
+	}
+	if got := read(filepath.Join(dir, "keep.txt")); got != sedTestInput {
+		t.Fatalf("sed -i with a bad script changed the file to %q", got)
+	}
+}
@@ -65,6 +65,7 @@ func (jr *jsBot) createBotObject() *goja.Object {
 	botObj.Set("GetIdentityCredential", jr.botGetIdentityCredential)
 	botObj.Set("LinkOAuth2Identity", jr.botLinkOAuth2Identity)
 	botObj.Set("UnlinkIdentity", jr.botUnlinkIdentity)
+	botObj.Set("PublishQueueMessage", jr.botPublishQueueMessage)
 	botObj.Set("CheckAdmin", jr.botCheckAdmin)
 	botObj.Set("Elevate", jr.botElevate)
 	botObj.Set("Log", jr.botLog)
@@ -17,15 +17,17 @@ type jsContext struct {
 	bot          map[string]string
 	vm           *goja.Runtime
 	requirePaths []string
+	strictPaths  []string // requirePaths entries from robot.yaml ModulePaths
 }
 
 // CallExtension loads and executes a JavaScript file with goja:
 //   - taskPath, taskName - the path to script and its name
-//   - pkgPath - directories the script should search for requires
+//   - requirePaths - directories the script should search for requires
+//   - strictPaths - the requirePaths entries from robot.yaml ModulePaths
 //   - env - env vars normally passed to external scripts, has thread info
 //   - r: the JavaScript BotAPI
 //   - args: the script arguments
-func CallExtension(execPath, taskPath, taskName string, requirePaths []string, logger robot.Logger,
+func CallExtension(execPath, taskPath, taskName string, requirePaths, strictPaths []string, logger robot.Logger,
 	realBot map[string]string, r BotAPI, args []string) (robot.TaskRetVal, error) {
 	// Create a new goja VM
 	vm := goja.New()
@@ -36,6 +38,7 @@ func CallExtension(execPath, taskPath, taskName string, requirePaths []string, l
 		bot:          realBot,
 		vm:           vm,
 		requirePaths: requirePaths,
+		strictPaths:  strictPaths,
 	}
 
 	ctx.addRequires(vm)
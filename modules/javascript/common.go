@@ -1,11 +1,14 @@
 package javascript
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 
 	"github.com/dop251/goja"
 	"github.com/dop251/goja_nodejs/require"
 	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/v2/internal/modpath"
 )
 
 // setProcessArgv creates the global "process.argv" array in JS so scripts can read arguments
@@ -40,10 +43,12 @@ func (ctx *jsContext) setProcessArgv(execPath, taskPath string, args ...string)
 }
 
 // addRequires sets up a require() function using goja_nodejs, allowing JavaScript
-// scripts to load other scripts/modules from the given paths.
+// scripts to load other scripts/modules from the given paths. Modules are
+// cached per runtime by the registry.
 func (ctx *jsContext) addRequires(vm *goja.Runtime) {
 	registry := require.NewRegistry(
 		require.WithGlobalFolders(ctx.requirePaths...),
+		require.WithLoader(ctx.loadModuleSource),
 	)
 
 	registerHttpModule(registry)
@@ -51,6 +56,21 @@ func (ctx *jsContext) addRequires(vm *goja.Runtime) {
 	registry.Enable(vm)
 }
 
+// loadModuleSource reads module files for require(). Files under robot.yaml
+// ModulePaths directories must stay inside them and must not be group/world
+// writable; anything else (the bundled and config lib directories, a
+// script's own relative requires) loads as before.
+func (ctx *jsContext) loadModuleSource(path string) ([]byte, error) {
+	if !modpath.Contains(ctx.strictPaths, path) {
+		return require.DefaultSourceLoader(path)
+	}
+	src, err := modpath.ReadFile(ctx.strictPaths, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, require.ModuleFileDoesNotExistError
+	}
+	return src, err
+}
+
 // requireStringArg generates a js exception if we didn't get a string argument
 func (jr *jsBot) requireStringArg(methodName string, call goja.FunctionCall, index int) string {
 	// Make sure we actually have enough arguments
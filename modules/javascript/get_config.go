@@ -10,7 +10,7 @@ import (
 
 // GetPluginConfig calls the given JS script with the argument "_configure".
 // We expect the script to return a YAML string that we convert to *[]byte.
-func GetPluginConfig(execPath, taskPath, taskName string, emptyBot map[string]string, libPaths []string) (*[]byte, error) {
+func GetPluginConfig(execPath, taskPath, taskName string, emptyBot map[string]string, libPaths, strictPaths []string) (*[]byte, error) {
 	vm := goja.New()
 
 	ctx := &jsContext{
@@ -18,6 +18,7 @@ func GetPluginConfig(execPath, taskPath, taskName string, emptyBot map[string]st
 		bot:          emptyBot,
 		vm:           vm,
 		requirePaths: libPaths,
+		strictPaths:  strictPaths,
 	}
 
 	ctx.addRequires(vm)
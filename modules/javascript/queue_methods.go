@@ -0,0 +1,31 @@
+package javascript
+
+import (
+	"fmt"
+
+	"github.com/dop251/goja"
+)
+
+// botPublishQueueMessage(provider, topic, body [, attributes]) -> retVal
+func (jr *jsBot) botPublishQueueMessage(call goja.FunctionCall) goja.Value {
+	const methodName = "PublishQueueMessage"
+	provider := jr.requireStringArg(methodName, call, 0)
+	topic := jr.requireStringArg(methodName, call, 1)
+	body := jr.requireStringArg(methodName, call, 2)
+	var attributes map[string]string
+	if len(call.Arguments) > 3 && !goja.IsUndefined(call.Arguments[3]) && !goja.IsNull(call.Arguments[3]) {
+		rawAttrs, ok := call.Arguments[3].Export().(map[string]interface{})
+		if !ok {
+			panic(jr.ctx.vm.ToValue(fmt.Sprintf("%s: argument #4 must be an object of string attributes", methodName)))
+		}
+		attributes = make(map[string]string, len(rawAttrs))
+		for k, v := range rawAttrs {
+			s, ok := v.(string)
+			if !ok {
+				panic(jr.ctx.vm.ToValue(fmt.Sprintf("%s: attribute '%s' must be a string, got %T", methodName, k, v)))
+			}
+			attributes[k] = s
+		}
+	}
+	return jr.ctx.vm.ToValue(int(jr.r.PublishQueueMessage(provider, topic, body, attributes)))
+}
@@ -15,6 +15,7 @@ type BotAPI interface {
 	GetIdentityCredential(provider, user string) (*robot.IdentityCredential, robot.RetVal)
 	LinkOAuth2Identity(link *robot.OAuth2IdentityLinkRequest) robot.RetVal
 	UnlinkIdentity(provider, user string) robot.RetVal
+	PublishQueueMessage(provider, topic, body string, attributes map[string]string) robot.RetVal
 	Exclusive(tag string, queueTask bool) bool
 	Fixed() BotAPI
 	MessageFormat(f robot.MessageFormat) BotAPI
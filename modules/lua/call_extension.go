@@ -24,11 +24,12 @@ type luaContext struct {
 
 // CallExtension loads and executes a Lua script:
 //   - taskPath, taskName - the path to script and its name
-//   - pkgPath - directories the script should search for requires
+//   - pkgPath - module search path directories for require()
+//   - strictPaths - the pkgPath entries from robot.yaml ModulePaths
 //   - env - env vars normally passed to external scripts, has thread info
 //   - r: the Lua BotAPI
 //   - args: the script arguments
-func CallExtension(execPath, taskPath, taskName string, pkgPath []string, logger robot.Logger,
+func CallExtension(execPath, taskPath, taskName string, pkgPath, strictPaths []string, logger robot.Logger,
 	bot map[string]string, r BotAPI, args []string) (robot.TaskRetVal, error) {
 	L := glua.NewState()
 	defer L.Close()
@@ -59,6 +60,7 @@ func CallExtension(execPath, taskPath, taskName string, pkgPath []string, logger
 	lctx.RegisterUtilMethods(L)
 	lctx.RegisterAttributeMethods(L)
 	lctx.RegisterOAuth2Methods(L)
+	lctx.RegisterQueueMethods(L)
 	lctx.RegisterPromptingMethods(L)
 	lctx.RegisterPipelineMethods(L)
 
@@ -66,11 +68,8 @@ func CallExtension(execPath, taskPath, taskName string, pkgPath []string, logger
 	robotUD := lctx.newLuaBot(L, r)
 	L.SetGlobal("GBOT", robotUD)
 
-	// Update package.path with additional directories and Lua patterns
-	ret, err := updatePkgPath(L, r, pkgPath)
-	if err != nil {
-		return ret, err
-	}
+	// Search the robot's module path for require()d libraries
+	registerModuleSearcher(L, pkgPath, strictPaths)
 
 	// Register native helper modules for require("http") and require("json")
 	registerHttpModule(L)
@@ -114,32 +113,6 @@ func addArgTable(L *glua.LState, execPath, taskPath string, args ...string) {
 	L.SetGlobal("arg", argTable)
 }
 
-// updatePkgPath appends additional paths to Lua's package.path
-func updatePkgPath(L *glua.LState, l robot.Logger, pkgPath []string) (robot.TaskRetVal, error) {
-	var additionalPaths []string
-	for _, dir := range pkgPath {
-		// Ensure no trailing slash
-		dir = strings.TrimRight(dir, "/")
-
-		// Append Lua patterns
-		additionalPaths = append(additionalPaths, fmt.Sprintf("%s/?.lua", dir))
-		additionalPaths = append(additionalPaths, fmt.Sprintf("%s/?/init.lua", dir))
-	}
-
-	// Join the additional paths with semicolons
-	additionalPathsStr := strings.Join(additionalPaths, ";")
-
-	// Lua code to append the additional paths to package.path
-	luaPathUpdate := fmt.Sprintf(`package.path = package.path .. ";%s"`, additionalPathsStr)
-
-	// Execute the Lua code to update package.path
-	if err := L.DoString(luaPathUpdate); err != nil {
-		l.Log(robot.Error, fmt.Sprintf("Failed to update package.path: %v", err))
-		return robot.MechanismFail, err
-	}
-	return robot.Normal, nil
-}
-
 // modifyOSFunctions overrides os.setenv and os.setlocale in Lua to prevent modifications
 func modifyOSFunctions(L *glua.LState, l robot.Logger) {
 	osVal := L.GetGlobal("os")
@@ -8,7 +8,7 @@ import (
 
 // GetPluginConfig calls the given Lua script with the argument "_configure".
 // We expect the script to return a YAML string that we convert to *[]byte.
-func GetPluginConfig(execPath, taskPath, taskName string, emptyBot map[string]string, pkgPath []string) (*[]byte, error) {
+func GetPluginConfig(execPath, taskPath, taskName string, emptyBot map[string]string, pkgPath, strictPaths []string) (*[]byte, error) {
 	L := glua.NewState()
 	defer L.Close()
 
@@ -34,11 +34,8 @@ func GetPluginConfig(execPath, taskPath, taskName string, emptyBot map[string]st
 	robotUD := lctx.newLuaBot(L, nil)
 	L.SetGlobal("GBOT", robotUD)
 
-	// **Update package.path with additional directories and Lua patterns**
-	_, err := updatePkgPath(L, nil, pkgPath)
-	if err != nil {
-		return nil, err
-	}
+	// Search the robot's module path for require()d libraries
+	registerModuleSearcher(L, pkgPath, strictPaths)
 
 	// Register native helper modules for require("http") and require("json")
 	registerHttpModule(L)
@@ -0,0 +1,59 @@
+package lua
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/v2/internal/modpath"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// luaModulePatterns are tried in order in each module search directory.
+var luaModulePatterns = []string{"?.lua", "?/init.lua"}
+
+// registerModuleSearcher installs a package searcher for the robot's
+// module search path, right after the preload searcher. Files under
+// strictPaths (robot.yaml ModulePaths) get modpath's containment and
+// permission checks; loaded modules are cached in package.loaded as usual.
+func registerModuleSearcher(L *glua.LState, pkgPath, strictPaths []string) {
+	loaders, ok := L.GetField(L.GetField(L.Get(glua.EnvironIndex), "package"), "loaders").(*glua.LTable)
+	if !ok {
+		return
+	}
+	roots := make([]string, 0, len(pkgPath))
+	for _, dir := range pkgPath {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			roots = append(roots, filepath.Clean(dir))
+		}
+	}
+	loaders.Insert(2, L.NewFunction(func(L *glua.LState) int {
+		name := L.CheckString(1)
+		modName := strings.ReplaceAll(name, ".", "/")
+		var msg strings.Builder
+		for _, root := range roots {
+			for _, pattern := range luaModulePatterns {
+				candidate := filepath.Join(root, strings.ReplaceAll(pattern, "?", modName))
+				src, err := modpath.ReadFile(strictPaths, candidate)
+				if err != nil {
+					if errors.Is(err, fs.ErrNotExist) {
+						fmt.Fprintf(&msg, "\n\tno file '%s'", candidate)
+						continue
+					}
+					L.RaiseError("loading module '%s': %v", name, err)
+				}
+				fn, err := L.Load(bytes.NewReader(src), candidate)
+				if err != nil {
+					L.RaiseError("loading module '%s': %v", name, err)
+				}
+				L.Push(fn)
+				return 1
+			}
+		}
+		L.Push(glua.LString(msg.String()))
+		return 1
+	}))
+}
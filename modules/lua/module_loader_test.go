@@ -0,0 +1,62 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+func writeModuleFile(t *testing.T, path, src string, perm os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o775); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(src), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModuleSearcherLoadsGroupWritableInstallLib(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib")
+	writeModuleFile(t, filepath.Join(lib, "gopherbot_v1.lua"), "loads = (loads or 0) + 1\nreturn {version = 1}", 0o664)
+	writeModuleFile(t, filepath.Join(lib, "lua", "mycorp", "init.lua"), "return {name = 'mycorp'}", 0o664)
+	for _, dir := range []string{lib, filepath.Join(lib, "lua"), filepath.Join(lib, "lua", "mycorp")} {
+		if err := os.Chmod(dir, 0o775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	L := glua.NewState()
+	defer L.Close()
+	registerModuleSearcher(L, []string{filepath.Join(lib, "lua"), lib}, nil)
+	if err := L.DoString(`
+		local a = require("gopherbot_v1")
+		local b = require("gopherbot_v1")
+		assert(a.version == 1 and a == b and loads == 1, "cached install lib")
+		assert(require("mycorp").name == "mycorp", "init.lua module")
+	`); err != nil {
+		t.Fatalf("require from install lib: %v", err)
+	}
+}
+
+func TestModuleSearcherRefusesGroupWritableModulePath(t *testing.T) {
+	shared := t.TempDir()
+	if err := os.Chmod(shared, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeModuleFile(t, filepath.Join(shared, "helpers.lua"), "return {}", 0o664)
+
+	L := glua.NewState()
+	defer L.Close()
+	registerModuleSearcher(L, []string{shared}, []string{shared})
+	err := L.DoString(`require("helpers")`)
+	if err == nil || !strings.Contains(err.Error(), "group/world writable") {
+		t.Fatalf("require from strict ModulePaths dir error = %v, want group/world writable", err)
+	}
+}
@@ -0,0 +1,41 @@
+package lua
+
+import (
+	glua "github.com/yuin/gopher-lua"
+)
+
+// RegisterQueueMethods attaches queue functions to the bot metatable:
+//
+//	bot:PublishQueueMessage(provider, topic, body [, attributes]) -> RetVal
+func (lctx *luaContext) RegisterQueueMethods(L *glua.LState) {
+	methods := map[string]glua.LGFunction{
+		"PublishQueueMessage": lctx.botPublishQueueMessage,
+	}
+	mt := registerBotMetatableIfNeeded(L)
+	L.SetFuncs(mt, methods)
+}
+
+func (lctx *luaContext) botPublishQueueMessage(L *glua.LState) int {
+	r := lctx.getRobot(L, "PublishQueueMessage")
+	provider := L.CheckString(2)
+	topic := L.CheckString(3)
+	body := L.CheckString(4)
+	var attributes map[string]string
+	if attrTable := L.OptTable(5, nil); attrTable != nil {
+		attributes = make(map[string]string)
+		attrTable.ForEach(func(k, v glua.LValue) {
+			key, keyOK := k.(glua.LString)
+			if !keyOK {
+				L.ArgError(5, "attribute keys must be strings")
+			}
+			switch v.(type) {
+			case glua.LString, glua.LNumber:
+				attributes[string(key)] = v.String()
+			default:
+				L.ArgError(5, "attribute values must be strings")
+			}
+		})
+	}
+	L.Push(glua.LNumber(r.PublishQueueMessage(provider, topic, body, attributes)))
+	return 1
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/lnxjedi/gopherbot/robot"
@@ -23,6 +24,8 @@ const (
 	robotImportPath      = "github.com/lnxjedi/gopherbot/robot"
 	installLibImportRoot = "gopherbot.internal/lib"
 	configLibImportRoot  = "robot.internal/lib"
+	goLibDirName         = "go"
+	vendoredManifestName = ".vendored-imports"
 )
 
 func sharedGoPath(homePath string) string {
@@ -124,9 +127,124 @@ func ensureGoPath(homePath, robotSrcDir, installLibDir, configLibDir string) (st
 		return "", fmt.Errorf("failed to stage config lib packages: %w", err)
 	}
 
+	if err := stageVendoredPackages(root, filepath.Join(configLibDir, goLibDirName)); err != nil {
+		return "", fmt.Errorf("failed to stage vendored Go packages: %w", err)
+	}
+
 	return root, nil
 }
 
+// findVendoredPackages returns the import paths of the package roots in
+// goLibDir, which is laid out by import path like a `go mod vendor`
+// directory. A package root is the shallowest directory holding a go.mod
+// or .go files; its subdirectories come along with it.
+func findVendoredPackages(goLibDir string) ([]string, error) {
+	var found []string
+	err := filepath.WalkDir(goLibDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if path == goLibDir && os.IsNotExist(err) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if !d.IsDir() || path == goLibDir {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" {
+			return filepath.SkipDir
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			if entry.Name() == "go.mod" || strings.HasSuffix(entry.Name(), ".go") {
+				rel, err := filepath.Rel(goLibDir, path)
+				if err != nil {
+					return err
+				}
+				found = append(found, filepath.ToSlash(rel))
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// reservedImportPath reports whether importPath overlaps one of the import
+// roots the engine stages itself.
+func reservedImportPath(importPath string) bool {
+	for _, reserved := range []string{robotImportPath, installLibImportRoot, configLibImportRoot} {
+		if importPath == reserved || strings.HasPrefix(reserved, importPath+"/") || strings.HasPrefix(importPath, reserved+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// stageVendoredPackages links each package root in goLibDir into the
+// shared GOPATH at its import path, so interpreted plugins import vendored
+// code exactly as compiled Go would. Links recorded by a previous run that
+// are no longer wanted are removed first; packages overlapping the
+// engine's own import roots are skipped.
+func stageVendoredPackages(root, goLibDir string) error {
+	packages, err := findVendoredPackages(goLibDir)
+	if err != nil {
+		return err
+	}
+	manifest := filepath.Join(root, vendoredManifestName)
+	wanted := make(map[string]bool, len(packages))
+	for _, importPath := range packages {
+		if !reservedImportPath(importPath) {
+			wanted[importPath] = true
+		}
+	}
+
+	if previous, err := os.ReadFile(manifest); err == nil {
+		for _, importPath := range strings.Split(string(previous), "\n") {
+			if importPath == "" || wanted[importPath] || reservedImportPath(importPath) {
+				continue
+			}
+			dst := filepath.Join(root, "src", filepath.FromSlash(importPath))
+			if info, err := os.Lstat(dst); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if err := os.Remove(dst); err != nil {
+					return fmt.Errorf("failed to remove stale vendored package '%s': %w", dst, err)
+				}
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", manifest, err)
+	}
+
+	staged := make([]string, 0, len(wanted))
+	for _, importPath := range packages {
+		if !wanted[importPath] {
+			continue
+		}
+		src := filepath.Join(goLibDir, filepath.FromSlash(importPath))
+		dst := filepath.Join(root, "src", filepath.FromSlash(importPath))
+		if err := ensureSymlinkIfDirExists(src, dst); err != nil {
+			return err
+		}
+		staged = append(staged, importPath+"\n")
+	}
+
+	tmp := fmt.Sprintf("%s.%d", manifest, os.Getpid())
+	if err := os.WriteFile(tmp, []byte(strings.Join(staged, "")), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifest, err)
+	}
+	if err := os.Rename(tmp, manifest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", manifest, err)
+	}
+	return nil
+}
+
 func initializeInterpreter(privileged bool, env []string) (*interp.Interpreter, error) {
 	if initErr != nil {
 		return nil, initErr
@@ -139,6 +257,8 @@ func initializeInterpreter(privileged bool, env []string) (*interp.Interpreter,
 	if err := i.Use(stdlib.Symbols); err != nil {
 		return nil, fmt.Errorf("failed to load standard library: %w", err)
 	}
+	// Symbols holds the robot package plus the extension packages
+	// (yaegi_symbols_*.go) compiled into the binary.
 	if err := i.Use(Symbols); err != nil {
 		return nil, fmt.Errorf("failed to load robot symbols: %w", err)
 	}
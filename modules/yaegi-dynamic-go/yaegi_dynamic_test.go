@@ -235,6 +235,94 @@ func TestEnsureGoPathUsesSharedSymlinks(t *testing.T) {
 	assertSymlinkTarget(t, filepath.Join(got, "src", filepath.FromSlash(configLibImportRoot)), configLibDir)
 }
 
+func TestEnsureGoPathStagesVendoredGoPackages(t *testing.T) {
+	_, thisFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("runtime.Caller(0) failed")
+	}
+	repoRoot := filepath.Clean(filepath.Join(filepath.Dir(thisFile), "..", ".."))
+	homeDir := t.TempDir()
+	configLibDir := filepath.Join(homeDir, "custom", "lib")
+	goLibDir := filepath.Join(configLibDir, goLibDirName)
+	for _, pkg := range []string{"example.com/greet", "example.com/greet/names", "github.com/lnxjedi/gopherbot/robot"} {
+		dir := filepath.Join(goLibDir, filepath.FromSlash(pkg))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "pkg.go"), []byte("package "+filepath.Base(dir)+"\n"), 0o600); err != nil {
+			t.Fatalf("write %s: %v", dir, err)
+		}
+	}
+
+	got, err := ensureGoPath(homeDir, filepath.Join(repoRoot, "robot"), filepath.Join(repoRoot, "lib"), configLibDir)
+	if err != nil {
+		t.Fatalf("ensureGoPath() error = %v", err)
+	}
+	greetDst := filepath.Join(got, "src", "example.com", "greet")
+	assertSymlinkTarget(t, greetDst, filepath.Join(goLibDir, "example.com", "greet"))
+	// The engine's own robot package wins over a vendored copy.
+	assertSymlinkTarget(t, filepath.Join(got, "src", filepath.FromSlash(robotImportPath)), filepath.Join(repoRoot, "robot"))
+
+	if err := os.RemoveAll(filepath.Join(goLibDir, "example.com")); err != nil {
+		t.Fatalf("remove vendored package: %v", err)
+	}
+	if _, err := ensureGoPath(homeDir, filepath.Join(repoRoot, "robot"), filepath.Join(repoRoot, "lib"), configLibDir); err != nil {
+		t.Fatalf("ensureGoPath() rerun error = %v", err)
+	}
+	if _, err := os.Lstat(greetDst); !os.IsNotExist(err) {
+		t.Fatalf("stale vendored link %s still present: %v", greetDst, err)
+	}
+}
+
+func TestRunPluginHandlerYaegiCanImportVendoredAndExtensionPackages(t *testing.T) {
+	_, thisFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("runtime.Caller(0) failed")
+	}
+	repoRoot := filepath.Clean(filepath.Join(filepath.Dir(thisFile), "..", ".."))
+	homeDir := t.TempDir()
+	configLibDir := filepath.Join(homeDir, "custom", "lib")
+	greetDir := filepath.Join(configLibDir, goLibDirName, "example.com", "greet")
+	namesDir := filepath.Join(greetDir, "names")
+	if err := os.MkdirAll(namesDir, 0o755); err != nil {
+		t.Fatalf("mkdir vendored package: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(greetDir, "greet.go"), []byte(strings.Join([]string{
+		"package greet",
+		"",
+		"import \"example.com/greet/names\"",
+		"",
+		"func Hello() string {",
+		"	return \"hello \" + names.Default",
+		"}",
+	}, "\n")), 0o600); err != nil {
+		t.Fatalf("write vendored package: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(namesDir, "names.go"), []byte("package names\n\nconst Default = \"floyd\"\n"), 0o600); err != nil {
+		t.Fatalf("write vendored subpackage: %v", err)
+	}
+	var err error
+	goPath, err = ensureGoPath(homeDir, filepath.Join(repoRoot, "robot"), filepath.Join(repoRoot, "lib"), configLibDir)
+	if err != nil {
+		t.Fatalf("ensure shared gopath: %v", err)
+	}
+	initErr = nil
+
+	pluginPath := filepath.Join(t.TempDir(), "plugin.go")
+	if err := os.WriteFile(pluginPath, []byte(yaegiPluginImportingVendoredAndExtensionPackages()), 0o600); err != nil {
+		t.Fatalf("write temp plugin: %v", err)
+	}
+	logger := &testLogger{}
+
+	ret, err := RunPluginHandler(pluginPath, "vendored-import", nil, nil, logger, false, "probe")
+	if err != nil {
+		t.Fatalf("RunPluginHandler vendored import error = %v", err)
+	}
+	if ret != robot.Normal {
+		t.Fatalf("RunPluginHandler vendored import ret = %v, want %v", ret, robot.Normal)
+	}
+}
+
 func writeTempPlugin(t *testing.T, src string) string {
 	t.Helper()
 	ensureYaegiInitialized(t)
@@ -475,6 +563,59 @@ func yaegiPluginImportingConfigLibPackage() string {
 	}, "\n")
 }
 
+func yaegiPluginImportingVendoredAndExtensionPackages() string {
+	return strings.Join([]string{
+		"package main",
+		"",
+		"import (",
+		"	\"bytes\"",
+		"	\"crypto/hmac\"",
+		"	\"crypto/sha256\"",
+		"	\"encoding/csv\"",
+		"	\"encoding/hex\"",
+		"	\"net/url\"",
+		"	\"strings\"",
+		"	\"text/template\"",
+		"",
+		"	\"example.com/greet\"",
+		"	\"github.com/google/uuid\"",
+		"	\"github.com/lnxjedi/gopherbot/robot\"",
+		"	\"gopkg.in/yaml.v3\"",
+		")",
+		"",
+		"func PluginHandler(r robot.Robot, command string, args ...string) robot.TaskRetVal {",
+		"	if greet.Hello() != \"hello floyd\" {",
+		"		return robot.MechanismFail",
+		"	}",
+		"	var cfg map[string]string",
+		"	if err := yaml.Unmarshal([]byte(\"name: floyd\\n\"), &cfg); err != nil || cfg[\"name\"] != \"floyd\" {",
+		"		return robot.MechanismFail",
+		"	}",
+		"	if uuid.NewSHA1(uuid.NameSpaceURL, []byte(\"gopherbot\")).Version() != 5 {",
+		"		return robot.MechanismFail",
+		"	}",
+		"	rows, err := csv.NewReader(strings.NewReader(\"a,b\\n\")).ReadAll()",
+		"	if err != nil || len(rows) != 1 || rows[0][1] != \"b\" {",
+		"		return robot.MechanismFail",
+		"	}",
+		"	var buf bytes.Buffer",
+		"	tmpl := template.Must(template.New(\"t\").Parse(\"hi {{.}}\"))",
+		"	if err := tmpl.Execute(&buf, \"floyd\"); err != nil || buf.String() != \"hi floyd\" {",
+		"		return robot.MechanismFail",
+		"	}",
+		"	if url.QueryEscape(\"a b\") != \"a+b\" {",
+		"		return robot.MechanismFail",
+		"	}",
+		"	mac := hmac.New(sha256.New, []byte(\"key\"))",
+		"	mac.Write([]byte(\"msg\"))",
+		"	if len(hex.EncodeToString(mac.Sum(nil))) != 64 {",
+		"		return robot.MechanismFail",
+		"	}",
+		"	return robot.Normal",
+		"}",
+	}, "\n")
+}
+
 func yaegiPluginWithWrappedReturn() string {
 	return strings.Join([]string{
 		"package main",
@@ -53,6 +53,8 @@ func init() {
 		"Null":                       reflect.ValueOf(robot.Null),
 		"Ok":                         reflect.ValueOf(robot.Ok),
 		"IdentityConfigError":        reflect.ValueOf(robot.IdentityConfigError),
+		"QueueProviderNotFound":      reflect.ValueOf(robot.QueueProviderNotFound),
+		"QueuePublishFailed":         reflect.ValueOf(robot.QueuePublishFailed),
 		"IdentityCredential":         reflect.ValueOf((*robot.IdentityCredential)(nil)),
 		"IdentityInvalidLinkRequest": reflect.ValueOf(robot.IdentityInvalidLinkRequest),
 		"IdentityNotLinked":          reflect.ValueOf(robot.IdentityNotLinked),
@@ -311,6 +313,7 @@ type _github_com_lnxjedi_gopherbot_robot_Robot struct {
 	WPromptUserChannelForReply       func(regexID string, user string, channel string, prompt string, v ...interface{}) (string, robot.RetVal)
 	WPromptUserChannelThreadForReply func(regexID string, user string, channel string, thread string, prompt string, v ...interface{}) (string, robot.RetVal)
 	WPromptUserForReply              func(regexID string, user string, prompt string, v ...interface{}) (string, robot.RetVal)
+	WPublishQueueMessage             func(provider string, topic string, body string, attributes map[string]string) robot.RetVal
 	WRandomInt                       func(n int) int
 	WRandomString                    func(s []string) string
 	WRecall                          func(key string, shared bool) string
@@ -449,6 +452,9 @@ func (W _github_com_lnxjedi_gopherbot_robot_Robot) PromptUserChannelThreadForRep
 func (W _github_com_lnxjedi_gopherbot_robot_Robot) PromptUserForReply(regexID string, user string, prompt string, v ...interface{}) (string, robot.RetVal) {
 	return W.WPromptUserForReply(regexID, user, prompt, v...)
 }
+func (W _github_com_lnxjedi_gopherbot_robot_Robot) PublishQueueMessage(provider string, topic string, body string, attributes map[string]string) robot.RetVal {
+	return W.WPublishQueueMessage(provider, topic, body, attributes)
+}
 func (W _github_com_lnxjedi_gopherbot_robot_Robot) RandomInt(n int) int {
 	return W.WRandomInt(n)
 }
@@ -0,0 +1,330 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultQueue              = "gopherbot-job-triggers"
+	defaultBindingKey         = "#"
+	defaultPrefetch           = 1
+	defaultMaxBodySize        = 4096
+	defaultReconnectMinMillis = 1000
+	defaultReconnectMaxMillis = 60000
+	connectionName            = "gopherbot"
+	publishTimeout            = 10 * time.Second
+)
+
+type config struct {
+	URL                string
+	Queue              string
+	DeclareQueue       bool
+	Exchange           string
+	BindingKeys        []string
+	Prefetch           int
+	ConsumerTag        string
+	DeadLetterRetries  bool
+	MaxBodySize        int
+	ReconnectMinMillis int
+	ReconnectMaxMillis int
+	// PublishExchange and PublishTopics allow publishing; topics are
+	// routing keys, and the default exchange routes them to the queue
+	// of the same name.
+	PublishExchange string
+	PublishTopics   []string
+}
+
+// session is a single broker connection with an active consumer. It is an
+// interface so tests can stand in for a broker.
+type session interface {
+	Deliveries() <-chan amqp091.Delivery
+	Closed() <-chan *amqp091.Error
+	Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error
+	Close() error
+}
+
+type dialFunc func(c config) (session, error)
+
+type queueProvider struct {
+	robot.QueueHandler
+	cfg          config
+	redactedURL  string
+	maxBodySize  int
+	reconnectMin time.Duration
+	reconnectMax time.Duration
+	dial         dialFunc
+	topics       map[string]bool
+
+	// current is the connected session, used for publishing
+	sync.Mutex
+	current session
+}
+
+func Initialize(handler robot.QueueHandler, _ *log.Logger) (robot.InitializedQueueProvider, error) {
+	var c config
+	if err := handler.GetQueueConfig(&c); err != nil {
+		return robot.InitializedQueueProvider{}, fmt.Errorf("retrieve amqp queue configuration: %w", err)
+	}
+	c, err := normalizeConfig(c)
+	if err != nil {
+		return robot.InitializedQueueProvider{}, err
+	}
+	qp := &queueProvider{
+		QueueHandler: handler,
+		cfg:          c,
+		redactedURL:  redactURL(c.URL),
+		maxBodySize:  c.MaxBodySize,
+		reconnectMin: time.Duration(c.ReconnectMinMillis) * time.Millisecond,
+		reconnectMax: time.Duration(c.ReconnectMaxMillis) * time.Millisecond,
+		dial:         dialBroker,
+		topics:       make(map[string]bool, len(c.PublishTopics)),
+	}
+	for _, topic := range c.PublishTopics {
+		qp.topics[topic] = true
+	}
+	return robot.InitializedQueueProvider{Provider: qp}, nil
+}
+
+func normalizeConfig(c config) (config, error) {
+	c.URL = strings.TrimSpace(c.URL)
+	if c.URL == "" {
+		return c, fmt.Errorf("amqp queue provider requires a broker URL")
+	}
+	if _, err := amqp091.ParseURI(c.URL); err != nil {
+		return c, fmt.Errorf("invalid amqp broker URL '%s': %w", redactURL(c.URL), err)
+	}
+	c.Queue = strings.TrimSpace(c.Queue)
+	if c.Queue == "" {
+		c.Queue = defaultQueue
+	}
+	c.Exchange = strings.TrimSpace(c.Exchange)
+	keys := make([]string, 0, len(c.BindingKeys))
+	for _, key := range c.BindingKeys {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 && c.Exchange != "" {
+		keys = append(keys, defaultBindingKey)
+	}
+	c.BindingKeys = keys
+	c.PublishExchange = strings.TrimSpace(c.PublishExchange)
+	topics := make([]string, 0, len(c.PublishTopics))
+	for _, topic := range c.PublishTopics {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	c.PublishTopics = topics
+	if c.Prefetch <= 0 {
+		c.Prefetch = defaultPrefetch
+	}
+	c.ConsumerTag = strings.TrimSpace(c.ConsumerTag)
+	if c.MaxBodySize <= 0 {
+		c.MaxBodySize = defaultMaxBodySize
+	}
+	if c.ReconnectMinMillis <= 0 {
+		c.ReconnectMinMillis = defaultReconnectMinMillis
+	}
+	if c.ReconnectMaxMillis <= 0 {
+		c.ReconnectMaxMillis = defaultReconnectMaxMillis
+	}
+	if c.ReconnectMaxMillis < c.ReconnectMinMillis {
+		c.ReconnectMaxMillis = c.ReconnectMinMillis
+	}
+	return c, nil
+}
+
+// redactURL removes any password from the broker URL so it can be logged.
+func redactURL(in string) string {
+	u, err := url.Parse(in)
+	if err != nil {
+		return "<unparseable URL>"
+	}
+	return u.Redacted()
+}
+
+func nextBackoff(current, max time.Duration) time.Duration {
+	next := current * 2
+	if next > max {
+		return max
+	}
+	return next
+}
+
+func (q *queueProvider) Run(stop <-chan struct{}) {
+	backoff := q.reconnectMin
+	for {
+		s, err := q.dial(q.cfg)
+		if err != nil {
+			q.Log(robot.Error, "Connecting to AMQP broker '%s': %v; retrying in %s", q.redactedURL, err, backoff)
+		} else {
+			q.Log(robot.Info, "AMQP queue provider receiving from queue '%s' on '%s'", q.cfg.Queue, q.redactedURL)
+			q.setSession(s)
+			stopped, received := q.consume(s, stop)
+			q.setSession(nil)
+			if err := s.Close(); err != nil && !stopped {
+				q.Log(robot.Debug, "Closing AMQP connection: %v", err)
+			}
+			if stopped {
+				return
+			}
+			if received {
+				backoff = q.reconnectMin
+			}
+			q.Log(robot.Warn, "AMQP connection to '%s' lost; reconnecting in %s", q.redactedURL, backoff)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff, q.reconnectMax)
+	}
+}
+
+func (q *queueProvider) setSession(s session) {
+	q.Lock()
+	q.current = s
+	q.Unlock()
+}
+
+// Publish sends body to PublishExchange with topic as the routing key, using
+// the consumer's connection; it fails while the provider is reconnecting.
+func (q *queueProvider) Publish(topic string, body []byte, attributes map[string]string) error {
+	if !q.topics[topic] {
+		return fmt.Errorf("topic '%s' is not listed in amqp PublishTopics", topic)
+	}
+	q.Lock()
+	s := q.current
+	q.Unlock()
+	if s == nil {
+		return fmt.Errorf("not connected to AMQP broker '%s'", q.redactedURL)
+	}
+	headers := make(amqp091.Table, len(attributes))
+	for k, v := range attributes {
+		headers[k] = v
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return s.Publish(ctx, q.cfg.PublishExchange, topic, amqp091.Publishing{
+		Headers:      headers,
+		ContentType:  "text/plain",
+		DeliveryMode: amqp091.Persistent,
+		Timestamp:    time.Now(),
+		AppId:        connectionName,
+		Body:         body,
+	})
+}
+
+// consume handles deliveries until the session closes or stop is closed. It
+// reports whether it was stopped, and whether any message was received so
+// the caller can reset its reconnect backoff.
+func (q *queueProvider) consume(s session, stop <-chan struct{}) (stopped, received bool) {
+	deliveries := s.Deliveries()
+	closed := s.Closed()
+	for {
+		select {
+		case <-stop:
+			return true, received
+		case err, ok := <-closed:
+			if ok && err != nil {
+				q.Log(robot.Error, "AMQP connection closed by broker: %v", err)
+			}
+			return false, received
+		case d, ok := <-deliveries:
+			if !ok {
+				return false, received
+			}
+			received = true
+			q.handleDelivery(d)
+		}
+	}
+}
+
+func (q *queueProvider) handleDelivery(d amqp091.Delivery) {
+	id := deliveryID(d)
+	if len(d.Body) > q.maxBodySize {
+		q.Log(robot.Error, "AMQP queue message '%s' exceeded MaxBodySize: %d > %d", id, len(d.Body), q.maxBodySize)
+		q.settle(d, id, robot.QueueAck)
+		return
+	}
+	disposition := q.HandleQueueMessage(robot.QueueMessage{
+		ID:         id,
+		Body:       d.Body,
+		Attributes: deliveryAttributes(d),
+	})
+	q.settle(d, id, disposition)
+}
+
+func (q *queueProvider) settle(d amqp091.Delivery, id string, disposition robot.QueueDisposition) {
+	var err error
+	switch disposition {
+	case robot.QueueRetry:
+		// With DeadLetterRetries the broker's dead-letter exchange for the
+		// queue owns retry policy; otherwise the message is requeued.
+		err = d.Nack(false, !q.cfg.DeadLetterRetries)
+	default:
+		err = d.Ack(false)
+	}
+	if err != nil {
+		q.Log(robot.Error, "Settling AMQP queue message '%s': %v", id, err)
+	}
+}
+
+func deliveryID(d amqp091.Delivery) string {
+	if d.MessageId != "" {
+		return d.MessageId
+	}
+	return fmt.Sprintf("%s:%d", d.ConsumerTag, d.DeliveryTag)
+}
+
+// deliveryAttributes flattens message headers and selected properties into
+// queue message attributes. Properties are prefixed with "amqp." so they can't
+// be confused with application headers.
+func deliveryAttributes(d amqp091.Delivery) map[string]string {
+	attrs := make(map[string]string, len(d.Headers)+8)
+	for k, v := range d.Headers {
+		switch tv := v.(type) {
+		case string:
+			attrs[k] = tv
+		case []byte:
+			attrs[k] = string(tv)
+		case bool, int8, int16, int32, int64, uint8, uint16, uint32, uint64, float32, float64:
+			attrs[k] = fmt.Sprint(tv)
+		case time.Time:
+			attrs[k] = tv.UTC().Format(time.RFC3339)
+		}
+	}
+	props := map[string]string{
+		"amqp.exchange":       d.Exchange,
+		"amqp.routing_key":    d.RoutingKey,
+		"amqp.message_id":     d.MessageId,
+		"amqp.correlation_id": d.CorrelationId,
+		"amqp.app_id":         d.AppId,
+		"amqp.type":           d.Type,
+		"amqp.user_id":        d.UserId,
+		"amqp.content_type":   d.ContentType,
+	}
+	for k, v := range props {
+		if v != "" {
+			attrs[k] = v
+		}
+	}
+	if !d.Timestamp.IsZero() {
+		attrs["amqp.timestamp"] = d.Timestamp.UTC().Format(time.RFC3339)
+	}
+	if d.Redelivered {
+		attrs["amqp.redelivered"] = "true"
+	}
+	return attrs
+}
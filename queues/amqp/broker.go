@@ -0,0 +1,79 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+type brokerSession struct {
+	conn       *amqp091.Connection
+	ch         *amqp091.Channel
+	deliveries <-chan amqp091.Delivery
+	closed     chan *amqp091.Error
+}
+
+// dialBroker connects to the broker, applies prefetch, optionally declares and
+// binds the queue, and starts a manual-ack consumer.
+func dialBroker(c config) (session, error) {
+	conn, err := amqp091.DialConfig(c.URL, amqp091.Config{
+		Properties: amqp091.Table{"connection_name": connectionName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("open channel: %w", err)
+	}
+	if err := ch.Qos(c.Prefetch, 0, false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("set prefetch: %w", err)
+	}
+	if c.DeclareQueue {
+		if _, err := ch.QueueDeclare(c.Queue, true, false, false, false, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("declare queue '%s': %w", c.Queue, err)
+		}
+	}
+	if c.Exchange != "" {
+		for _, key := range c.BindingKeys {
+			if err := ch.QueueBind(c.Queue, key, c.Exchange, false, nil); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("bind queue '%s' to exchange '%s' with key '%s': %w", c.Queue, c.Exchange, key, err)
+			}
+		}
+	}
+	deliveries, err := ch.Consume(c.Queue, c.ConsumerTag, false, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("consume from queue '%s': %w", c.Queue, err)
+	}
+	return &brokerSession{
+		conn:       conn,
+		ch:         ch,
+		deliveries: deliveries,
+		closed:     conn.NotifyClose(make(chan *amqp091.Error, 1)),
+	}, nil
+}
+
+func (s *brokerSession) Deliveries() <-chan amqp091.Delivery {
+	return s.deliveries
+}
+
+func (s *brokerSession) Closed() <-chan *amqp091.Error {
+	return s.closed
+}
+
+func (s *brokerSession) Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error {
+	return s.ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+}
+
+func (s *brokerSession) Close() error {
+	if s.conn.IsClosed() {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,7 @@
+package amqp
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterQueueProvider("amqp", Initialize)
+}
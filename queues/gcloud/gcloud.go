@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/lnxjedi/gopherbot/robot"
@@ -15,6 +17,7 @@ const (
 	defaultCredentialsEncryptedFile = "gopherbot-key.json.enc"
 	defaultSubscriptionID           = "job-triggers-pull"
 	defaultMaxBodySize              = 4096
+	publishTimeout                  = 30 * time.Second
 )
 
 type config struct {
@@ -24,6 +27,8 @@ type config struct {
 	MaxOutstandingMessages   int
 	NumGoroutines            int
 	MaxBodySize              int
+	// PublishTopics lists the topic IDs PublishQueueMessage may send to.
+	PublishTopics []string
 }
 
 type queueProvider struct {
@@ -33,6 +38,10 @@ type queueProvider struct {
 	maxBodySize    int
 	client         *pubsub.Client
 	subscription   *pubsub.Subscription
+	publishTopics  map[string]bool
+
+	sync.Mutex
+	topics map[string]*pubsub.Topic
 }
 
 func Initialize(handler robot.QueueHandler, _ *log.Logger) (robot.InitializedQueueProvider, error) {
@@ -84,6 +93,13 @@ func Initialize(handler robot.QueueHandler, _ *log.Logger) (robot.InitializedQue
 		maxBodySize:    maxBodySize,
 		client:         client,
 		subscription:   subscription,
+		publishTopics:  make(map[string]bool, len(c.PublishTopics)),
+		topics:         make(map[string]*pubsub.Topic),
+	}
+	for _, topic := range c.PublishTopics {
+		if topic = normalizeTopicID(topic); topic != "" {
+			qp.publishTopics[topic] = true
+		}
 	}
 	return robot.InitializedQueueProvider{Provider: qp}, nil
 }
@@ -102,6 +118,14 @@ func normalizeSubscriptionID(in string) string {
 	return in
 }
 
+func normalizeTopicID(in string) string {
+	in = strings.TrimSpace(in)
+	if idx := strings.LastIndex(in, "topics/"); idx >= 0 {
+		return strings.TrimSpace(in[idx+len("topics/"):])
+	}
+	return in
+}
+
 func normalizeMaxBodySize(in int) int {
 	if in > 0 {
 		return in
@@ -113,6 +137,7 @@ func (q *queueProvider) Run(stop <-chan struct{}) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	defer func() {
+		q.stopTopics()
 		if err := q.client.Close(); err != nil {
 			q.Log(robot.Warn, "Closing Google Pub/Sub client: %v", err)
 		}
@@ -151,3 +176,38 @@ func (q *queueProvider) Run(stop <-chan struct{}) {
 		q.Log(robot.Error, "Google Pub/Sub queue receive loop exited with error: %v", err)
 	}
 }
+
+// Publish sends body to a topic ID listed in PublishTopics and waits for the
+// server to accept it.
+func (q *queueProvider) Publish(topic string, body []byte, attributes map[string]string) error {
+	topic = normalizeTopicID(topic)
+	if !q.publishTopics[topic] {
+		return fmt.Errorf("topic '%s' is not listed in gcloud PublishTopics", topic)
+	}
+	q.Lock()
+	t, ok := q.topics[topic]
+	if !ok {
+		t = q.client.Topic(topic)
+		q.topics[topic] = t
+	}
+	q.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	_, err := t.Publish(ctx, &pubsub.Message{
+		Data:       body,
+		Attributes: attributes,
+	}).Get(ctx)
+	return err
+}
+
+// stopTopics flushes and stops topics used for publishing before the client
+// is closed.
+func (q *queueProvider) stopTopics() {
+	q.Lock()
+	defer q.Unlock()
+	for id, t := range q.topics {
+		t.Stop()
+		delete(q.topics, id)
+	}
+}
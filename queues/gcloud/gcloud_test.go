@@ -35,3 +35,17 @@ func TestNormalizeMaxBodySize(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeTopicID(t *testing.T) {
+	tests := map[string]string{
+		"job-triggers":                         "job-triggers",
+		"projects/example/topics/job-triggers": "job-triggers",
+		"  topics/job-triggers  ":              "job-triggers",
+		"":                                     "",
+	}
+	for in, want := range tests {
+		if got := normalizeTopicID(in); got != want {
+			t.Fatalf("normalizeTopicID(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
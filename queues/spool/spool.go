@@ -0,0 +1,396 @@
+// Package spool is a local filesystem queue provider. Writers create a
+// message file under tmp/ and rename it into new/; the provider claims
+// messages by moving them to cur/, and acknowledged messages are removed.
+// Messages the engine asks to retry wait in retry/. Messages that exhaust
+// their retries, or that can never be accepted, are moved to failed/ for an
+// administrator to inspect. Publishing writes to
+// the spool directory configured for the topic, the same way.
+package spool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	defaultDirectory          = "state/queue-spool"
+	defaultPollIntervalMillis = 1000
+	defaultRetryDelayMillis   = 30000
+	defaultMaxRetries         = 5
+	defaultMaxBodySize        = 4096
+
+	tmpDir    = "tmp"
+	newDir    = "new"
+	curDir    = "cur"
+	retryDir  = "retry"
+	failedDir = "failed"
+
+	// retrySeparator separates the original message name from the retry
+	// count in the provider-owned cur/ and retry/ directories:
+	// <name>:<retries>. Writers only deliver to new/, so the names they
+	// choose are never parsed and may contain the separator.
+	retrySeparator = ":"
+)
+
+type config struct {
+	Directory          string
+	PollIntervalMillis int
+	RetryDelayMillis   int
+	MaxRetries         int
+	MaxBodySize        int
+	// PublishTopics maps topic names to the spool directories messages
+	// are published to; an empty directory publishes to this spool.
+	PublishTopics map[string]string
+}
+
+type queueProvider struct {
+	robot.QueueHandler
+	dir          string
+	pollInterval time.Duration
+	retryDelay   time.Duration
+	maxRetries   int
+	maxBodySize  int
+	topics       map[string]string
+	now          func() time.Time
+}
+
+type spoolEntry struct {
+	dir     string // newDir or retryDir
+	file    string
+	id      string
+	retries int
+}
+
+func Initialize(handler robot.QueueHandler, _ *log.Logger) (robot.InitializedQueueProvider, error) {
+	var c config
+	if err := handler.GetQueueConfig(&c); err != nil {
+		return robot.InitializedQueueProvider{}, fmt.Errorf("retrieve spool queue configuration: %w", err)
+	}
+	dir := strings.TrimSpace(c.Directory)
+	if dir == "" {
+		dir = defaultDirectory
+	}
+	if err := ensureSpoolDirs(dir); err != nil {
+		return robot.InitializedQueueProvider{}, err
+	}
+	topics := make(map[string]string, len(c.PublishTopics))
+	for topic, topicDir := range c.PublishTopics {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			continue
+		}
+		if topicDir = strings.TrimSpace(topicDir); topicDir == "" {
+			topicDir = dir
+		}
+		topics[topic] = topicDir
+	}
+	qp := &queueProvider{
+		QueueHandler: handler,
+		dir:          dir,
+		pollInterval: millisOrDefault(c.PollIntervalMillis, defaultPollIntervalMillis),
+		retryDelay:   millisOrDefault(c.RetryDelayMillis, defaultRetryDelayMillis),
+		maxRetries:   normalizeMaxRetries(c.MaxRetries),
+		maxBodySize:  normalizeMaxBodySize(c.MaxBodySize),
+		topics:       topics,
+		now:          time.Now,
+	}
+	return robot.InitializedQueueProvider{Provider: qp}, nil
+}
+
+func ensureSpoolDirs(dir string) error {
+	for _, sub := range []string{tmpDir, newDir, curDir, retryDir, failedDir} {
+		p := filepath.Join(dir, sub)
+		if err := os.MkdirAll(p, 0700); err != nil {
+			return fmt.Errorf("create spool directory '%s': %w", p, err)
+		}
+	}
+	return nil
+}
+
+func millisOrDefault(in, def int) time.Duration {
+	if in > 0 {
+		return time.Duration(in) * time.Millisecond
+	}
+	return time.Duration(def) * time.Millisecond
+}
+
+// normalizeMaxRetries allows an explicit negative value to disable retries
+// entirely; zero selects the default.
+func normalizeMaxRetries(in int) int {
+	switch {
+	case in > 0:
+		return in
+	case in < 0:
+		return 0
+	}
+	return defaultMaxRetries
+}
+
+func normalizeMaxBodySize(in int) int {
+	if in > 0 {
+		return in
+	}
+	return defaultMaxBodySize
+}
+
+// parseSpoolName splits a cur/ or retry/ file name into the message ID and
+// the number of retries already attempted. A name without a retry count,
+// left in cur/ by an older release, is a message that hasn't been retried.
+func parseSpoolName(name string) spoolEntry {
+	entry := spoolEntry{dir: retryDir, file: name, id: name}
+	idx := strings.LastIndex(name, retrySeparator)
+	if idx <= 0 {
+		return entry
+	}
+	retries, err := strconv.Atoi(name[idx+1:])
+	if err != nil || retries < 0 {
+		return entry
+	}
+	entry.id = name[:idx]
+	entry.retries = retries
+	return entry
+}
+
+func spoolName(id string, retries int) string {
+	return id + retrySeparator + strconv.Itoa(retries)
+}
+
+func (q *queueProvider) Run(stop <-chan struct{}) {
+	q.recoverClaimed()
+	q.Log(robot.Info, "Spool queue provider receiving from '%s'", filepath.Join(q.dir, newDir))
+
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+	for {
+		q.processPending(stop)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recoverClaimed returns messages left in cur/ by an interrupted run to new/,
+// or to retry/ if they had already been retried. Delivery is at-least-once;
+// the engine's UUID/timestamp dedupe absorbs a message that was accepted
+// just before the interruption.
+func (q *queueProvider) recoverClaimed() {
+	names, err := readNames(filepath.Join(q.dir, curDir))
+	if err != nil {
+		q.Log(robot.Error, "Reading spool claimed directory: %v", err)
+		return
+	}
+	for _, name := range names {
+		entry := parseSpoolName(name)
+		src := filepath.Join(q.dir, curDir, name)
+		dir, dstName := retryDir, func(suffix string) string { return spoolName(entry.id+suffix, entry.retries) }
+		if entry.retries == 0 {
+			dir, dstName = newDir, func(suffix string) string { return entry.id + suffix }
+		}
+		if _, err := moveNoClobber(src, filepath.Join(q.dir, dir), dstName); err != nil {
+			q.Log(robot.Error, "Recovering spool message '%s': %v", entry.id, err)
+			continue
+		}
+		q.Log(robot.Warn, "Recovered interrupted spool message '%s'", entry.id)
+	}
+}
+
+// processPending delivers new messages, then any retries that are due.
+func (q *queueProvider) processPending(stop <-chan struct{}) {
+	var entries []spoolEntry
+	names, err := readNames(filepath.Join(q.dir, newDir))
+	if err != nil {
+		q.Log(robot.Error, "Reading spool directory: %v", err)
+		return
+	}
+	for _, name := range names {
+		entries = append(entries, spoolEntry{dir: newDir, file: name, id: name})
+	}
+	names, err = readNames(filepath.Join(q.dir, retryDir))
+	if err != nil {
+		q.Log(robot.Error, "Reading spool retry directory: %v", err)
+	}
+	for _, name := range names {
+		entries = append(entries, parseSpoolName(name))
+	}
+	for _, entry := range entries {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		q.processEntry(entry)
+	}
+}
+
+func (q *queueProvider) processEntry(entry spoolEntry) {
+	pending := filepath.Join(q.dir, entry.dir, entry.file)
+	if entry.dir == retryDir {
+		info, err := os.Stat(pending)
+		if err != nil {
+			return
+		}
+		if q.now().Before(info.ModTime().Add(q.retryDelay)) {
+			return
+		}
+	}
+	claimed := filepath.Join(q.dir, curDir, spoolName(entry.id, entry.retries))
+	if err := os.Rename(pending, claimed); err != nil {
+		// Most likely another robot sharing the spool claimed it first.
+		if !os.IsNotExist(err) {
+			q.Log(robot.Error, "Claiming spool message '%s': %v", entry.id, err)
+		}
+		return
+	}
+	info, err := os.Stat(claimed)
+	if err != nil {
+		q.Log(robot.Error, "Reading spool message '%s': %v", entry.id, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		q.Log(robot.Error, "Spool message '%s' is not a regular file", entry.id)
+		q.fail(entry, claimed)
+		return
+	}
+	if info.Size() > int64(q.maxBodySize) {
+		q.Log(robot.Error, "Spool queue message '%s' exceeded MaxBodySize: %d > %d", entry.id, info.Size(), q.maxBodySize)
+		q.fail(entry, claimed)
+		return
+	}
+	body, err := os.ReadFile(claimed)
+	if err != nil {
+		q.Log(robot.Error, "Reading spool message '%s': %v", entry.id, err)
+		q.retry(entry, claimed)
+		return
+	}
+	disposition := q.HandleQueueMessage(robot.QueueMessage{
+		ID:   entry.id,
+		Body: body,
+	})
+	switch disposition {
+	case robot.QueueRetry:
+		q.retry(entry, claimed)
+	default:
+		if err := os.Remove(claimed); err != nil {
+			q.Log(robot.Error, "Removing acknowledged spool message '%s': %v", entry.id, err)
+		}
+	}
+}
+
+func (q *queueProvider) retry(entry spoolEntry, claimed string) {
+	retries := entry.retries + 1
+	if retries > q.maxRetries {
+		q.Log(robot.Error, "Spool message '%s' exhausted %d retries", entry.id, q.maxRetries)
+		q.fail(entry, claimed)
+		return
+	}
+	now := q.now()
+	if err := os.Chtimes(claimed, now, now); err != nil {
+		q.Log(robot.Warn, "Updating retry time for spool message '%s': %v", entry.id, err)
+	}
+	dstName := func(suffix string) string { return spoolName(entry.id+suffix, retries) }
+	if _, err := moveNoClobber(claimed, filepath.Join(q.dir, retryDir), dstName); err != nil {
+		q.Log(robot.Error, "Requeueing spool message '%s': %v", entry.id, err)
+		return
+	}
+	q.Log(robot.Debug, "Spool message '%s' queued for retry %d of %d", entry.id, retries, q.maxRetries)
+}
+
+func (q *queueProvider) fail(entry spoolEntry, claimed string) {
+	name, err := moveNoClobber(claimed, filepath.Join(q.dir, failedDir), func(suffix string) string { return entry.id + suffix })
+	if err != nil {
+		q.Log(robot.Error, "Moving spool message '%s' to failed directory: %v", entry.id, err)
+		return
+	}
+	q.Log(robot.Warn, "Spool message '%s' moved to '%s'", entry.id, filepath.Join(q.dir, failedDir, name))
+}
+
+// moveNoClobber moves src into dir as name(""), or name(".1"), name(".2")
+// and so on when that's taken; writers choose message names and may reuse
+// them, and a rename would silently replace the earlier message. It returns
+// the name used.
+func moveNoClobber(src, dir string, name func(suffix string) string) (string, error) {
+	for n := 0; ; n++ {
+		suffix := ""
+		if n > 0 {
+			suffix = "." + strconv.Itoa(n)
+		}
+		dst := name(suffix)
+		// Link fails rather than replacing an existing file.
+		err := os.Link(src, filepath.Join(dir, dst))
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return dst, os.Remove(src)
+	}
+}
+
+// Publish writes body to the spool directory configured for topic. The file
+// is written under tmp/ and renamed into new/ so a reader never sees a
+// partial message. Spool messages are plain files, so attributes can't be
+// carried and are rejected rather than silently dropped.
+func (q *queueProvider) Publish(topic string, body []byte, attributes map[string]string) error {
+	dir, ok := q.topics[topic]
+	if !ok {
+		return fmt.Errorf("topic '%s' is not listed in spool PublishTopics", topic)
+	}
+	if len(attributes) > 0 {
+		return fmt.Errorf("spool messages can't carry attributes")
+	}
+	name, err := q.newMessageName()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, tmpDir, name)
+	if err := os.WriteFile(tmp, body, 0600); err != nil {
+		return fmt.Errorf("write spool message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, newDir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("deliver spool message: %w", err)
+	}
+	return nil
+}
+
+// newMessageName returns a unique name prefixed with the time in
+// milliseconds, so published messages are processed in order.
+func (q *queueProvider) newMessageName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate spool message name: %w", err)
+	}
+	return fmt.Sprintf("%d-%s", q.now().UnixMilli(), hex.EncodeToString(b)), nil
+}
+
+// readNames returns the sorted, non-hidden entry names in dir. Writers that
+// prefix names with a timestamp get first-in, first-out processing.
+func readNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,267 @@
+package spool
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	config      config
+	disposition robot.QueueDisposition
+	received    []robot.QueueMessage
+}
+
+func (h *testHandler) GetQueueConfig(v interface{}) error {
+	b, err := json.Marshal(h.config)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (h *testHandler) HandleQueueMessage(msg robot.QueueMessage) robot.QueueDisposition {
+	h.received = append(h.received, msg)
+	return h.disposition
+}
+
+func (h *testHandler) ReadEncryptedFile(string) ([]byte, error)   { return nil, nil }
+func (h *testHandler) Log(robot.LogLevel, string, ...interface{}) {}
+func (h *testHandler) GetInstallPath() string                     { return "" }
+func (h *testHandler) GetConfigPath() string                      { return "" }
+
+func newTestProvider(t *testing.T, h *testHandler) *queueProvider {
+	t.Helper()
+	h.config.Directory = t.TempDir()
+	initialized, err := Initialize(h, nil)
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return initialized.Provider.(*queueProvider)
+}
+
+func writeSpoolFile(t *testing.T, q *queueProvider, sub, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(q.dir, sub, name), []byte(body), 0600); err != nil {
+		t.Fatalf("writing spool file: %v", err)
+	}
+}
+
+func spoolFiles(t *testing.T, q *queueProvider, sub string) []string {
+	t.Helper()
+	names, err := readNames(filepath.Join(q.dir, sub))
+	if err != nil {
+		t.Fatalf("reading %s: %v", sub, err)
+	}
+	return names
+}
+
+func TestParseSpoolName(t *testing.T) {
+	tests := map[string]spoolEntry{
+		"1700000000000-job":   {dir: retryDir, file: "1700000000000-job", id: "1700000000000-job"},
+		"1700000000000-job:3": {dir: retryDir, file: "1700000000000-job:3", id: "1700000000000-job", retries: 3},
+		"report:2:0":          {dir: retryDir, file: "report:2:0", id: "report:2"},
+		"host:name":           {dir: retryDir, file: "host:name", id: "host:name"},
+		":2":                  {dir: retryDir, file: ":2", id: ":2"},
+	}
+	for in, want := range tests {
+		if got := parseSpoolName(in); got != want {
+			t.Fatalf("parseSpoolName(%q) = %+v, want %+v", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeMaxRetries(t *testing.T) {
+	tests := []struct {
+		in   int
+		want int
+	}{
+		{in: 3, want: 3},
+		{in: 0, want: defaultMaxRetries},
+		{in: -1, want: 0},
+	}
+	for _, tc := range tests {
+		if got := normalizeMaxRetries(tc.in); got != tc.want {
+			t.Fatalf("normalizeMaxRetries(%d) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestProcessPendingAcksAndRemoves(t *testing.T) {
+	h := &testHandler{disposition: robot.QueueAck}
+	q := newTestProvider(t, h)
+	writeSpoolFile(t, q, newDir, "002-second", "second")
+	writeSpoolFile(t, q, newDir, "001-first", "first")
+	writeSpoolFile(t, q, newDir, ".partial", "ignored")
+
+	q.processPending(make(chan struct{}))
+
+	if len(h.received) != 2 {
+		t.Fatalf("received %d messages, want 2", len(h.received))
+	}
+	if h.received[0].ID != "001-first" || string(h.received[0].Body) != "first" {
+		t.Fatalf("first message = %+v, want 001-first", h.received[0])
+	}
+	if got := spoolFiles(t, q, newDir); len(got) != 0 {
+		t.Fatalf("new/ = %v, want empty", got)
+	}
+	if got := spoolFiles(t, q, curDir); len(got) != 0 {
+		t.Fatalf("cur/ = %v, want empty", got)
+	}
+}
+
+func TestProcessPendingRetriesThenFails(t *testing.T) {
+	h := &testHandler{disposition: robot.QueueRetry, config: config{MaxRetries: 1}}
+	q := newTestProvider(t, h)
+	clock := time.Now()
+	q.now = func() time.Time { return clock }
+	writeSpoolFile(t, q, newDir, "001-job", "body")
+
+	q.processPending(make(chan struct{}))
+	if got := spoolFiles(t, q, retryDir); len(got) != 1 || got[0] != "001-job:1" {
+		t.Fatalf("retry/ after first retry = %v, want [001-job:1]", got)
+	}
+
+	q.processPending(make(chan struct{}))
+	if len(h.received) != 1 {
+		t.Fatalf("retry delivered before RetryDelay elapsed: %d deliveries", len(h.received))
+	}
+
+	clock = clock.Add(q.retryDelay + time.Second)
+	q.processPending(make(chan struct{}))
+	if len(h.received) != 2 || h.received[1].ID != "001-job" {
+		t.Fatalf("retry deliveries = %+v, want second delivery of 001-job", h.received)
+	}
+	if got := spoolFiles(t, q, failedDir); len(got) != 1 || got[0] != "001-job" {
+		t.Fatalf("failed/ = %v, want [001-job]", got)
+	}
+	if got := spoolFiles(t, q, retryDir); len(got) != 0 {
+		t.Fatalf("retry/ = %v, want empty", got)
+	}
+}
+
+func TestProcessPendingKeepsNamesWithSeparator(t *testing.T) {
+	h := &testHandler{disposition: robot.QueueRetry, config: config{MaxRetries: 1}}
+	q := newTestProvider(t, h)
+	writeSpoolFile(t, q, newDir, "report:2", "body")
+
+	q.processPending(make(chan struct{}))
+	if len(h.received) != 1 || h.received[0].ID != "report:2" {
+		t.Fatalf("received = %+v, want report:2 on its first attempt", h.received)
+	}
+	if got := spoolFiles(t, q, retryDir); len(got) != 1 || got[0] != "report:2:1" {
+		t.Fatalf("retry/ = %v, want [report:2:1]", got)
+	}
+	if got := spoolFiles(t, q, failedDir); len(got) != 0 {
+		t.Fatalf("failed/ = %v, want empty", got)
+	}
+}
+
+func TestProcessPendingRejectsOversizedBody(t *testing.T) {
+	h := &testHandler{disposition: robot.QueueAck, config: config{MaxBodySize: 4}}
+	q := newTestProvider(t, h)
+	writeSpoolFile(t, q, newDir, "001-big", "too large")
+
+	q.processPending(make(chan struct{}))
+
+	if len(h.received) != 0 {
+		t.Fatalf("oversized message was delivered")
+	}
+	if got := spoolFiles(t, q, failedDir); len(got) != 1 || got[0] != "001-big" {
+		t.Fatalf("failed/ = %v, want [001-big]", got)
+	}
+}
+
+func TestFailKeepsEarlierMessageWithSameName(t *testing.T) {
+	h := &testHandler{disposition: robot.QueueAck, config: config{MaxBodySize: 4}}
+	q := newTestProvider(t, h)
+	writeSpoolFile(t, q, newDir, "deploy-event", "first too large")
+	q.processPending(make(chan struct{}))
+	writeSpoolFile(t, q, newDir, "deploy-event", "second too large")
+	q.processPending(make(chan struct{}))
+
+	got := spoolFiles(t, q, failedDir)
+	if len(got) != 2 || got[0] != "deploy-event" || got[1] != "deploy-event.1" {
+		t.Fatalf("failed/ = %v, want [deploy-event deploy-event.1]", got)
+	}
+	for name, want := range map[string]string{"deploy-event": "first too large", "deploy-event.1": "second too large"} {
+		if body, err := os.ReadFile(filepath.Join(q.dir, failedDir, name)); err != nil || string(body) != want {
+			t.Fatalf("failed/%s = %q (%v), want %q", name, body, err, want)
+		}
+	}
+	if got := spoolFiles(t, q, curDir); len(got) != 0 {
+		t.Fatalf("cur/ = %v, want empty", got)
+	}
+}
+
+func TestRecoverClaimedReturnsMessages(t *testing.T) {
+	h := &testHandler{disposition: robot.QueueAck}
+	q := newTestProvider(t, h)
+	writeSpoolFile(t, q, curDir, "001-interrupted:2", "body")
+	writeSpoolFile(t, q, curDir, "002-host:name:0", "body")
+
+	q.recoverClaimed()
+
+	if got := spoolFiles(t, q, retryDir); len(got) != 1 || got[0] != "001-interrupted:2" {
+		t.Fatalf("retry/ = %v, want [001-interrupted:2]", got)
+	}
+	if got := spoolFiles(t, q, newDir); len(got) != 1 || got[0] != "002-host:name" {
+		t.Fatalf("new/ = %v, want [002-host:name]", got)
+	}
+	if got := spoolFiles(t, q, curDir); len(got) != 0 {
+		t.Fatalf("cur/ = %v, want empty", got)
+	}
+
+	// A fresh delivery with the same name as a recovered message is kept.
+	writeSpoolFile(t, q, newDir, "003-job", "fresh")
+	writeSpoolFile(t, q, curDir, "003-job:0", "interrupted")
+	q.recoverClaimed()
+	if got := spoolFiles(t, q, newDir); len(got) != 3 || got[1] != "003-job" || got[2] != "003-job.1" {
+		t.Fatalf("new/ = %v, want 003-job and 003-job.1 kept apart", got)
+	}
+	if body, _ := os.ReadFile(filepath.Join(q.dir, newDir, "003-job")); string(body) != "fresh" {
+		t.Fatalf("new/003-job = %q, want the fresh delivery", body)
+	}
+}
+
+func TestPublishDeliversToTopicSpool(t *testing.T) {
+	other := t.TempDir()
+	if err := ensureSpoolDirs(other); err != nil {
+		t.Fatalf("ensureSpoolDirs() error = %v", err)
+	}
+	h := &testHandler{config: config{PublishTopics: map[string]string{"peer": other, "self": ""}}}
+	q := newTestProvider(t, h)
+
+	if err := q.Publish("peer", []byte("body"), nil); err != nil {
+		t.Fatalf("Publish(peer) error = %v", err)
+	}
+	names, err := readNames(filepath.Join(other, newDir))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("peer new/ = %v (%v), want one message", names, err)
+	}
+	if body, _ := os.ReadFile(filepath.Join(other, newDir, names[0])); string(body) != "body" {
+		t.Fatalf("published body = %q, want body", body)
+	}
+	if names, _ := readNames(filepath.Join(other, tmpDir)); len(names) != 0 {
+		t.Fatalf("peer tmp/ = %v, want empty", names)
+	}
+
+	if err := q.Publish("self", []byte("loopback"), nil); err != nil {
+		t.Fatalf("Publish(self) error = %v", err)
+	}
+	q.processPending(make(chan struct{}))
+	if len(h.received) != 1 || string(h.received[0].Body) != "loopback" {
+		t.Fatalf("received = %+v, want loopback message", h.received)
+	}
+
+	if err := q.Publish("unlisted", []byte("body"), nil); err == nil {
+		t.Fatal("Publish() accepted a topic missing from PublishTopics")
+	}
+	if err := q.Publish("peer", []byte("body"), map[string]string{"k": "v"}); err == nil {
+		t.Fatal("Publish() accepted attributes")
+	}
+}
@@ -20,6 +20,20 @@ const (
 	Null
 	// SSH connector for local development
 	SSH
+	// Mattermost connector
+	Mattermost
+	// Matrix connector
+	Matrix
+	// IRC connector
+	IRC
+	// Webhook connector
+	Webhook
+	// Email connector
+	Email
+	// Teams connector
+	Teams
+	// Discord connector
+	Discord
 )
 
 // ConnectorMessage is passed in to the robot for every incoming message seen.
@@ -228,3 +242,55 @@ type Injector interface {
 type MessageSource interface {
 	GetMessages(req MessageQuery) (MessageBatch, error)
 }
+
+// UserDirectory is an optional Handler API for connectors that identify
+// users by their UserRoster email address rather than a protocol user ID.
+type UserDirectory interface {
+	// UserNameForEmail returns the roster username with the given email
+	// address, compared case-insensitively.
+	UserNameForEmail(address string) (string, bool)
+	// EmailForUserName returns the roster email address of a username.
+	EmailForUserName(user string) (string, bool)
+}
+
+// CommandDirectory is an optional Handler API for connectors that offer the
+// robot's plugin commands natively, e.g. as Discord slash commands.
+type CommandDirectory interface {
+	// CommandSchemas describes every enabled plugin command whose
+	// SimpleMatcher can be expressed as a name and typed arguments.
+	CommandSchemas() []CommandSchema
+}
+
+// CommandSchema describes one SimpleMatcher command. Connectors rebuild the
+// text a user would have typed from Parts, so the engine matches and
+// authorizes it like any other command.
+type CommandSchema struct {
+	Plugin, Command string
+	// Words are the leading literal words of the matcher, e.g. "add", "user".
+	Words   []string
+	Summary string
+	// Arguments are the typed captures and choices, in matcher order.
+	Arguments []CommandArgument
+	// Parts spell out the full matcher; see CommandPart.
+	Parts []CommandPart
+}
+
+// CommandArgument is one capture in a CommandSchema.
+type CommandArgument struct {
+	Name string
+	// Type is the SimpleMatcher capture type, e.g. "number" or "rest";
+	// fixed choices have type "choice".
+	Type        string
+	Description string
+	Choices     []string
+	Optional    bool
+}
+
+// CommandPart is a literal word or, when Argument > 0, the value of
+// Arguments[Argument-1]. Parts sharing a non-zero Group come from one
+// optional group and are dropped unless every argument in it has a value.
+type CommandPart struct {
+	Literal  string
+	Argument int
+	Group    int
+}
@@ -1,6 +1,9 @@
 package robot
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // HistoryLogger is provided by a HistoryProvider for each job / plugin run
 // where it's requested
@@ -28,3 +31,44 @@ type HistoryProvider interface {
 	// URL need only be available for a short timespan, e.g. 42 seconds
 	MakeLogURL(tag string, index int) (URL string, exists bool)
 }
+
+// RunInfo is the metadata kept for one pipeline run by history providers
+// that implement RunSearcher.
+type RunInfo struct {
+	Tag     string   // pipeline name
+	Index   int      // run number
+	Ref     string   // log reference, e.g. for "tail log <ref>"
+	User    string   // user that started the run, if any
+	Channel string   // channel it was started from
+	Trigger string   // how it started: run, sched, trigger, spawn, ...
+	Args    []string // arguments to the first task
+	Tasks   []string // tasks run in the primary pipeline, in order
+	Started time.Time
+	// Finished is zero while the run is in progress
+	Finished time.Time
+	Status   TaskRetVal
+}
+
+// RunQuery selects runs from a RunSearcher; zero fields match any run.
+type RunQuery struct {
+	Tag    string
+	Ref    string
+	Failed bool      // only finished runs with a non-Normal status
+	Since  time.Time // runs started at or after
+	Text   string    // case-insensitive substring of the run's output
+	Limit  int
+}
+
+// RunLogger is optionally implemented by a HistoryLogger that stores
+// structured metadata with the log.
+type RunLogger interface {
+	// SetRunInfo records metadata for the run; called when the pipeline
+	// starts and again when it finishes, before Finalize.
+	SetRunInfo(info RunInfo)
+}
+
+// RunSearcher is optionally implemented by a HistoryProvider that can
+// query the runs it has kept; results are most recent first.
+type RunSearcher interface {
+	SearchRuns(q RunQuery) ([]RunInfo, error)
+}
@@ -15,11 +15,18 @@ func _() {
 	_ = x[Test-4]
 	_ = x[Null-5]
 	_ = x[SSH-6]
+	_ = x[Mattermost-7]
+	_ = x[Matrix-8]
+	_ = x[IRC-9]
+	_ = x[Webhook-10]
+	_ = x[Email-11]
+	_ = x[Teams-12]
+	_ = x[Discord-13]
 }
 
-const _Protocol_name = "SlackGoogleChatRocketTerminalTestNullSSH"
+const _Protocol_name = "SlackGoogleChatRocketTerminalTestNullSSHMattermostMatrixIRCWebhookEmailTeamsDiscord"
 
-var _Protocol_index = [...]uint8{0, 5, 15, 21, 29, 33, 37, 40}
+var _Protocol_index = [...]uint8{0, 5, 15, 21, 29, 33, 37, 40, 50, 56, 59, 66, 71, 76, 83}
 
 func (i Protocol) String() string {
 	if i < 0 || i >= Protocol(len(_Protocol_index)-1) {
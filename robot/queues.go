@@ -22,6 +22,15 @@ type QueueProvider interface {
 	Run(stop <-chan struct{})
 }
 
+// QueuePublisher is an optional capability for queue providers that can
+// send messages as well as receive them; the engine type-asserts running
+// providers for it when a pipeline calls Robot.PublishQueueMessage. Topic
+// meaning is provider-specific, and providers should only publish to topics
+// allowed by their QueueConfig.
+type QueuePublisher interface {
+	Publish(topic string, body []byte, attributes map[string]string) error
+}
+
 type InitializedQueueProvider struct {
 	Provider QueueProvider
 }
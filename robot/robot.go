@@ -83,6 +83,11 @@ type Robot interface {
 	LinkOAuth2Identity(link *OAuth2IdentityLinkRequest) RetVal
 	// UnlinkIdentity removes an existing user-linked identity for the given provider/user.
 	UnlinkIdentity(provider, user string) RetVal
+	// PublishQueueMessage sends a message to a topic on a running queue provider
+	// that supports publishing, e.g. to trigger another robot's UUIDTrigger job.
+	// Returns QueueProviderNotFound when the provider isn't running or can't
+	// publish, and QueuePublishFailed when the provider rejects the message.
+	PublishQueueMessage(provider, topic, body string, attributes map[string]string) RetVal
 	Email(subject string, messageBody *bytes.Buffer, html ...bool) (ret RetVal)
 	EmailUser(user, subject string, messageBody *bytes.Buffer, html ...bool) (ret RetVal)
 	EmailAddress(address, subject string, messageBody *bytes.Buffer, html ...bool) (ret RetVal)
@@ -154,6 +154,10 @@ const (
 	IdentityInvalidLinkRequest
 	// IdentityConfigError - provider config is incomplete or invalid
 	IdentityConfigError
+	// QueueProviderNotFound - queue provider isn't running or can't publish
+	QueueProviderNotFound
+	// QueuePublishFailed - the queue provider failed to publish a message
+	QueuePublishFailed
 	// Failed is a generic failure code for use when we don't want to return Ok;
 	// should be accompanied by a log.
 	Failed RetVal = 63